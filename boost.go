@@ -0,0 +1,181 @@
+package botanic
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+minimumBoostError is the weighted error rate used to compute the weight of a
+tree that predicts all the training samples correctly, so that it is finite.
+*/
+const minimumBoostError = 1e-10
+
+/*
+Grower is a function that takes a context and a training set and returns
+a tree grown from the set or an error. Boost uses it to grow the trees of
+an ensemble.
+*/
+type Grower func(ctx context.Context, s set.Set) (*tree.Tree, error)
+
+/*
+Boost takes a context, a training set, a class feature, a number of rounds,
+a function to build sets from slices of samples and a Grower, and grows a
+boosted ensemble of trees with the multi-class variant of AdaBoost known as
+SAMME. On every round a tree is grown with the Grower from a set built with
+the samples of the training set weighted so that the samples misclassified
+by the previous trees weigh more. The tree is added to the ensemble with a
+weight that grows as its weighted error rate decreases. Samples the tree
+cannot predict count as misclassified.
+
+The initial weights of the samples are their weights on the training set,
+and they are scaled on every round so that they add up to the number of
+samples. Boosting stops before the given number of rounds if a tree does
+no better than choosing a class at random, in which case the tree is
+discarded unless the ensemble is empty, or if a tree predicts every
+training sample correctly.
+
+All samples of the training set are retrieved to memory. An error is
+returned if the number of rounds is not positive, the training set is
+empty or cannot be queried, or a tree cannot be grown or make predictions.
+*/
+func Boost(ctx context.Context, s set.Set, classFeature feature.Feature, rounds int, sg func([]set.Sample) set.Set, grow Grower) (*tree.Ensemble, error) {
+	if rounds < 1 {
+		return nil, fmt.Errorf("boosting requires at least 1 round, got %d", rounds)
+	}
+	samples, err := s.Samples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("cannot boost trees with an empty set")
+	}
+	k, err := classCount(ctx, s, classFeature)
+	if err != nil {
+		return nil, err
+	}
+	weights := make([]float64, len(samples))
+	for i, sample := range samples {
+		weights[i] = set.SampleWeight(sample)
+	}
+	e := tree.NewEnsemble(classFeature)
+	for round := 0; round < rounds; round++ {
+		err = normalizeBoostWeights(weights)
+		if err != nil {
+			return nil, err
+		}
+		weightedSamples := make([]set.Sample, len(samples))
+		for i, sample := range samples {
+			weightedSamples[i] = set.WithWeight(sample, weights[i])
+		}
+		t, err := grow(ctx, sg(weightedSamples))
+		if err != nil {
+			return nil, err
+		}
+		misses, err := misclassified(ctx, t, samples)
+		if err != nil {
+			return nil, err
+		}
+		var errorWeight, totalWeight float64
+		for i, w := range weights {
+			totalWeight += w
+			if misses[i] {
+				errorWeight += w
+			}
+		}
+		errorRate := errorWeight / totalWeight
+		if errorRate >= 1.0-1.0/float64(k) {
+			if len(e.Trees) == 0 {
+				e.Add(t, 1.0)
+			}
+			break
+		}
+		clampedRate := math.Max(errorRate, minimumBoostError)
+		alpha := math.Log((1.0-clampedRate)/clampedRate) + math.Log(float64(k-1))
+		e.Add(t, alpha)
+		if errorRate <= 0 {
+			break
+		}
+		for i, miss := range misses {
+			if miss {
+				weights[i] *= math.Exp(alpha)
+			}
+		}
+	}
+	return e, nil
+}
+
+/*
+classCount takes a context, a set and a class feature and returns the number
+of classes the feature can take: its available values if it is discrete or
+the number of values it takes on the set otherwise. At least 2 classes are
+always returned.
+*/
+func classCount(ctx context.Context, s set.Set, classFeature feature.Feature) (int, error) {
+	var k int
+	if df, ok := classFeature.(*feature.DiscreteFeature); ok {
+		k = len(df.AvailableValues())
+	} else {
+		fvs, err := s.FeatureValues(ctx, classFeature)
+		if err != nil {
+			return 0, err
+		}
+		k = len(fvs)
+	}
+	if k < 2 {
+		k = 2
+	}
+	return k, nil
+}
+
+/*
+normalizeBoostWeights takes a slice of sample weights and scales them so that
+they add up to the number of samples. An error is returned if they add up to
+0.
+*/
+func normalizeBoostWeights(weights []float64) error {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return fmt.Errorf("cannot boost trees with samples weighing 0")
+	}
+	factor := float64(len(weights)) / total
+	for i := range weights {
+		weights[i] *= factor
+	}
+	return nil
+}
+
+/*
+misclassified takes a context, a tree and a slice of samples and returns a
+slice of booleans telling for every sample whether the tree fails to predict
+its class correctly, either because it predicts another class or because it
+cannot make a prediction for it.
+*/
+func misclassified(ctx context.Context, t *tree.Tree, samples []set.Sample) ([]bool, error) {
+	misses := make([]bool, len(samples))
+	for i, sample := range samples {
+		p, err := t.Predict(ctx, sample)
+		if err != nil {
+			if err != tree.ErrCannotPredictFromSample {
+				return nil, err
+			}
+			misses[i] = true
+			continue
+		}
+		v, err := sample.ValueFor(t.ClassFeature)
+		if err != nil {
+			return nil, err
+		}
+		pV, _ := p.PredictedValue()
+		misses[i] = pV != v
+	}
+	return misses, nil
+}
@@ -2,9 +2,12 @@ package botanic
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/logging"
 	"github.com/pbanos/botanic/queue"
 	"github.com/pbanos/botanic/set"
 	"github.com/pbanos/botanic/tree"
@@ -28,7 +31,7 @@ func Seed(ctx context.Context, classFeature feature.Feature, features []feature.
 	if err != nil {
 		return nil, err
 	}
-	task := &queue.Task{Node: n, Set: s, AvailableFeatures: features}
+	task := queue.NewTask(n, s, features)
 	t := tree.New(n.ID, ns, classFeature)
 	err = q.Push(ctx, task)
 	if err != nil {
@@ -38,11 +41,38 @@ func Seed(ctx context.Context, classFeature feature.Feature, features []feature.
 	return t, nil
 }
 
-// BranchOut takes a context, a task, a tree and a pruning strategy,
-// develops the node in the task using the task's set and available
-// feature to predict the tree's class feature and returns a set of
-// tasks to develop the resulting children nodes or an error.
-func BranchOut(ctx context.Context, task *queue.Task, t *tree.Tree, ps *PruningStrategy) (tasks []*queue.Task, e error) {
+// BranchOut takes a context, a task, a tree, a pruning strategy and
+// any number of GrowOptions for the settings of the growth other
+// than those of the strategy, such as WithDiscreteSplit, develops the
+// node in the task using the task's set and available feature to
+// predict the tree's class feature and returns a set of tasks to
+// develop the resulting children nodes or an error. The node's depth,
+// the number of samples in its set and the information gain of its
+// split are recorded on it. Nodes at the strategy's MaxDepth or with
+// fewer samples than its MinSamplesSplit are not developed, and
+// partitions are pruned as the strategy's Prune method determines.
+// Discarded partitions and the branching out of the node are
+// notified to the Observer given with WithObserver, and the outcome
+// is logged at debug level with the Logger given with WithLogger.
+// The partitions with every available feature are computed
+// concurrently up to the FeatureConcurrency given with
+// WithFeatureConcurrency, and ties in information gain are broken
+// in favour of the feature that comes first in the task. The
+// feature of the selected partition is no longer available to
+// develop the children nodes, except for those with a subset of
+// the values of a discrete feature split in two, which may be
+// split further. If the tree's MissingValueStrategy is
+// tree.MissingValueSurrogate, a surrogate split is also computed
+// for the node. The tasks are given the number of samples in their
+// set as priority, so that queues develop larger nodes first.
+func BranchOut(ctx context.Context, task *queue.Task, t *tree.Tree, ps *PruningStrategy, opts ...GrowOption) ([]*queue.Task, error) {
+	return branchOut(ctx, task, t, growConfig(ps, opts))
+}
+
+// branchOut works like BranchOut, with the pruning strategy and
+// the rest of the settings of the growth on a GrowConfig.
+func branchOut(ctx context.Context, task *queue.Task, t *tree.Tree, gc *GrowConfig) (tasks []*queue.Task, e error) {
+	ps := gc.PruningStrategy
 	prediction, err := tree.NewPredictionFromSet(ctx, task.Set, t.ClassFeature)
 	if err != nil {
 		if err != tree.ErrCannotPredictFromEmptySet {
@@ -56,29 +86,46 @@ func BranchOut(ctx context.Context, task *queue.Task, t *tree.Tree, ps *PruningS
 		}
 	}()
 	task.Node.Prediction = prediction
+	task.Node.Depth = task.Depth
+	task.Node.SampleCount, err = task.Set.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
 	sEntropy, err := task.Set.Entropy(ctx, t.ClassFeature)
 	if err != nil {
 		return nil, err
 	}
-	if len(task.AvailableFeatures) == 0 || sEntropy <= ps.MinimumEntropy {
+	if len(task.AvailableFeatures) == 0 || sEntropy <= ps.MinimumEntropy || (ps.MaxDepth > 0 && task.Depth >= ps.MaxDepth) {
+		gc.logger().Log(logging.LevelDebug, "Node developed as a leaf", "node", task.Node.ID, "depth", task.Depth, "samples", task.Node.SampleCount, "entropy", sEntropy)
+		return nil, nil
+	}
+	if task.Node.SampleCount < ps.MinSamplesSplit {
+		gc.logger().Log(logging.LevelDebug, "Node developed as a leaf", "node", task.Node.ID, "depth", task.Depth, "samples", task.Node.SampleCount, "entropy", sEntropy)
 		return nil, nil
 	}
+	partitions, err := featurePartitions(ctx, task.Set, task.AvailableFeatures, t.ClassFeature, gc)
+	if err != nil {
+		return nil, err
+	}
+	for i, part := range partitions {
+		if part == nil {
+			gc.observer().OnPruned(ctx, task, task.AvailableFeatures[i])
+		}
+	}
 	var selectedPartition *Partition
 	var featureIndex int
-	for i, f := range task.AvailableFeatures {
-		part, err := partition(ctx, task.Set, f, t.ClassFeature, ps)
-		if err != nil {
-			return nil, err
-		}
+	for i, part := range partitions {
 		if selectedPartition == nil || (part != nil && part.informationGain > selectedPartition.informationGain) {
 			selectedPartition = part
 			featureIndex = i
 		}
 	}
 	if selectedPartition == nil {
+		gc.logger().Log(logging.LevelDebug, "Node developed as a leaf, every partition was discarded", "node", task.Node.ID, "depth", task.Depth, "samples", task.Node.SampleCount, "entropy", sEntropy)
 		return nil, nil
 	}
 	task.Node.SubtreeFeature = selectedPartition.Feature
+	task.Node.InformationGain = selectedPartition.informationGain
 	stAvailableFeatures := make([]feature.Feature, 0, len(task.AvailableFeatures)-1)
 	for fi, sf := range task.AvailableFeatures {
 		if fi != featureIndex {
@@ -94,37 +141,99 @@ func BranchOut(ctx context.Context, task *queue.Task, t *tree.Tree, ps *PruningS
 		}
 		stNodeIDs = append(stNodeIDs, st.Node.ID)
 		st.AvailableFeatures = stAvailableFeatures
+		if keepsFeature(st) {
+			st.AvailableFeatures = task.AvailableFeatures
+		}
+		st.Depth = task.Depth + 1
+		st.ParentID = task.ID()
+		st.CreatedAt = time.Now()
+		st.SubsetCount, err = st.Set.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		st.Priority = st.SubsetCount
 	}
 	task.Node.SubtreeIDs = stNodeIDs
+	if t.MissingValueStrategy == tree.MissingValueSurrogate {
+		task.Node.Surrogate, err = surrogateSplit(ctx, selectedPartition, stNodeIDs, partitions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	gc.logger().Log(logging.LevelDebug, "Node branched out", "node", task.Node.ID, "depth", task.Depth, "samples", task.Node.SampleCount, "feature", selectedPartition.Feature.Name(), "informationGain", selectedPartition.informationGain, "subtrees", len(stNodeIDs))
+	gc.observer().OnNodeBranched(ctx, task, selectedPartition.Tasks)
 	return selectedPartition.Tasks, nil
 }
 
-// Work takes a context, a tree, a queue, a pruning strategy
-// and an emptyQueueSleep duration and enters a loop in which
-// it:
+// Work takes a context, a tree, a queue, a pruning strategy,
+// an emptyQueueSleep duration and any number of GrowOptions
+// for the settings of the growth other than those of the
+// strategy, such as WithDiscreteSplit, and enters a loop in
+// which it:
 //   * pulls a task for the queue,
 //   * branches its node out into new subnodes using BranchOut
 //   * pushes the tasks for the new subnodes into the queue
+//     with queue.PushAll, at once if it is a queue.BatchQueue
 //   * marks the task as completed on the queue
 //
 // If at some point no task can be pulled from the queue and
 // the sum of tasks running and pending on the queue is 0, the
-// worker ends returning nil. If no task can be pulled but the
-// sum is not 0, then the worker will sleep for the given
-// emptyQueueSleep duration and then retry.
+// worker ends returning nil, or ErrDeadLetters if the queue is
+// a queue.DeadLetterQueue with dead letters. If no task can be
+// pulled but the sum is not 0, then the worker will sleep for
+// the given emptyQueueSleep duration and then retry. Workers on
+// a queue.WaitingQueue, such as the one returned by queue.New,
+// block on its PullWait method instead, so they do not poll it.
 //
 // Work will return a non-nil error if the given context
 // times out or is cancelled, if BranchOut returns a non-nil
 // error or if an operation with the given queue returns a
-// non-nil error.
-func Work(ctx context.Context, t *tree.Tree, q queue.Queue, ps *PruningStrategy, emptyQueueSleep time.Duration) error {
+// non-nil error. Transient errors, as determined by the
+// RetryPolicy given with WithRetryPolicy, such as those of
+// sets that lost the connection to their database, are the
+// exception. Pulling, counting and completing tasks and
+// retrieving dead letters are retried on their own, waiting
+// longer after every attempt, up to the attempts allowed by
+// the policy. Tasks whose development fails are dropped back
+// into the queue and the worker waits before going on, unless
+// the attempts allowed by the policy fail this way
+// consecutively. Tasks that fail once the tasks for their
+// subnodes may have been pushed into the queue, such as those
+// that cannot be completed, are not dropped back, as
+// developing them again would grow duplicate subtrees, and
+// Work returns their error. Without a RetryPolicy, the worker
+// waits for the emptyQueueSleep duration before every retry
+// and gives up after maxTemporaryErrors retries. Every retry
+// is logged as a warning with the Logger given with
+// WithLogger and notified to the Observer given with
+// WithObserver if it is a RetryObserver.
+func Work(ctx context.Context, t *tree.Tree, q queue.Queue, ps *PruningStrategy, emptyQueueSleep time.Duration, opts ...GrowOption) error {
+	return runWorker(ctx, t, q, growConfig(ps, opts), emptyQueueSleep)
+}
+
+// runWorker works like Work, with the pruning strategy and the
+// rest of the settings of the growth on a GrowConfig.
+func runWorker(ctx context.Context, t *tree.Tree, q queue.Queue, gc *GrowConfig, emptyQueueSleep time.Duration) error {
+	rp := gc.retryPolicy(emptyQueueSleep)
+	var failedTasks int
 	for {
-		task, tctx, err := q.Pull(ctx)
+		var task *queue.Task
+		var tctx context.Context
+		err := rp.do(ctx, gc, "pull", func() error {
+			var err error
+			task, tctx, err = pull(ctx, q)
+			return err
+		})
 		if err != nil {
 			return err
 		}
 		if task == nil {
-			r, p, err := q.Count(ctx)
+			var r, p int
+			err = rp.do(ctx, gc, "count", func() error {
+				var err error
+				r, p, err = q.Count(ctx)
+				return err
+			})
 			if err != nil {
 				return err
 			}
@@ -139,34 +248,99 @@ func Work(ctx context.Context, t *tree.Tree, q queue.Queue, ps *PruningStrategy,
 			continue
 		}
 		mctx, cancel := mergeCtxCancel(tctx, ctx)
-		err = workTask(mctx, task, t, q, ps)
+		pushed, err := workTask(mctx, task, t, q, gc, rp)
 		cancel()
+		if err != nil && pushed {
+			return fmt.Errorf("task %s failed after pushing its subtasks: %w", task.ID(), err)
+		}
 		if err != nil {
-			return err
+			failedTasks++
+			retry, werr := rp.wait(ctx, gc, "task", failedTasks, err, "task", task.ID(), "node", task.Node.ID)
+			if werr != nil {
+				return werr
+			}
+			if !retry {
+				return err
+			}
+			continue
 		}
+		failedTasks = 0
 		err = ctx.Err()
 		if err != nil {
 			return err
 		}
 	}
+	if dlq, ok := q.(queue.DeadLetterQueue); ok {
+		var deadLetters []*queue.Task
+		err := rp.do(ctx, gc, "dead-letters", func() error {
+			var err error
+			deadLetters, err = dlq.DeadLetters(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(deadLetters) > 0 {
+			return fmt.Errorf("%w: %d", ErrDeadLetters, len(deadLetters))
+		}
+	}
 	return nil
 }
 
-func workTask(ctx context.Context, task *queue.Task, t *tree.Tree, q queue.Queue, ps *PruningStrategy) error {
+// ErrDeadLetters is returned by Work when the tasks on its
+// queue run out but some were kept as dead letters, leaving
+// their nodes undeveloped. They can be inspected and requeued
+// with the methods of the queue.DeadLetterQueue before
+// working on the queue again.
+var ErrDeadLetters = errors.New("tasks abandoned as dead letters after too many attempts")
+
+// pull takes a context and a queue and pulls a task from
+// the queue, waiting for one with PullWait if the queue is a
+// queue.WaitingQueue.
+func pull(ctx context.Context, q queue.Queue) (*queue.Task, context.Context, error) {
+	if wq, ok := q.(queue.WaitingQueue); ok {
+		return wq.PullWait(ctx)
+	}
+	return q.Pull(ctx)
+}
+
+// maxTemporaryErrors is the number of consecutive tasks that
+// Work drops back into the queue because of temporary errors
+// before giving up when it is given no RetryPolicy.
+const maxTemporaryErrors = 3
+
+// workTask develops the node of a task with branchOut, pushes
+// the tasks for its subnodes into the queue and completes the
+// task on it. It returns whether the tasks for the subnodes
+// may have been pushed into the queue, and the error of any of
+// the steps. A task that fails before any of them is pushed is
+// dropped back into the queue to be developed again, whereas
+// one that fails after is not, as developing it again would
+// push new tasks for its subnodes along with the ones already
+// pushed, growing duplicate subtrees.
+func workTask(ctx context.Context, task *queue.Task, t *tree.Tree, q queue.Queue, gc *GrowConfig, rp *RetryPolicy) (pushed bool, err error) {
+	gc.observer().OnTaskStarted(ctx, task)
 	defer func() {
-		q.Drop(ctx, task.ID())
+		if !pushed {
+			q.Drop(ctx, task.ID())
+		}
+		gc.observer().OnTaskCompleted(ctx, task, err)
 	}()
-	tasks, err := BranchOut(ctx, task, t, ps)
+	tasks, err := branchOut(ctx, task, t, gc)
 	if err != nil {
-		return err
+		return false, err
 	}
-	for _, st := range tasks {
-		err = q.Push(ctx, st)
-		if err != nil {
-			return err
-		}
+	err = queue.PushAll(ctx, q, tasks)
+	if err != nil {
+		// Only a BatchQueue guarantees that none of the tasks
+		// was pushed when pushing them fails.
+		_, batch := q.(queue.BatchQueue)
+		return !batch && len(tasks) > 1, err
 	}
-	return q.Complete(ctx, task.ID())
+	pushed = len(tasks) > 0
+	return pushed, rp.do(ctx, gc, "complete", func() error {
+		return q.Complete(ctx, task.ID())
+	})
 }
 
 func mergeCtxCancel(ctx1, ctx2 context.Context) (context.Context, context.CancelFunc) {
@@ -180,3 +354,14 @@ func mergeCtxCancel(ctx1, ctx2 context.Context) (context.Context, context.Cancel
 	}()
 	return mctx, cancel
 }
+
+/*
+keepsFeature takes a task of a partition and returns whether the feature of
+the partition remains available to develop it, as happens with the tasks of
+the subsets of values of a binary split of a discrete feature, which may be
+split further.
+*/
+func keepsFeature(t *queue.Task) bool {
+	_, ok := t.Node.FeatureCriterion.(feature.DiscreteValuesCriterion)
+	return ok
+}
@@ -0,0 +1,74 @@
+package botanic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+failingSet is a set whose backend is unavailable while failing is true, so
+that developing a node with it fails with a transient error.
+*/
+type failingSet struct {
+	set.Set
+	failing bool
+}
+
+func (s *failingSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
+	if s.failing {
+		return 0, set.ErrBackendUnavailable
+	}
+	return s.Set.Entropy(ctx, f)
+}
+
+func TestWorkReturnsErrDeadLettersUntilTheyAreRequeued(t *testing.T) {
+	ctx := context.Background()
+	class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+	x := feature.NewDiscreteFeature("x", []string{"a", "b"})
+	var samples []set.Sample
+	for i := 0; i < 8; i++ {
+		values := map[string]interface{}{"class": "yes", "x": "a"}
+		if i%2 == 0 {
+			values = map[string]interface{}{"class": "no", "x": "b"}
+		}
+		samples = append(samples, set.NewSample(values))
+	}
+	s := &failingSet{Set: set.New(samples), failing: true}
+	q := queue.NewWithMaxAttempts(1)
+	tr, err := Seed(ctx, class, []feature.Feature{x}, s, q, tree.NewMemoryNodeStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Work(ctx, tr, q, nil, time.Millisecond)
+	if !errors.Is(err, ErrDeadLetters) {
+		t.Fatalf("expected Work to fail with ErrDeadLetters once the root task was dropped, got %v", err)
+	}
+	deadLetters, err := q.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].ID() != tr.RootID {
+		t.Fatalf("expected the root task as the only dead letter, got %v", deadLetters)
+	}
+	s.failing = false
+	if err := q.Requeue(ctx, tr.RootID); err != nil {
+		t.Fatal(err)
+	}
+	if err := Work(ctx, tr, q, nil, time.Millisecond); err != nil {
+		t.Fatalf("working on the requeued task: %v", err)
+	}
+	root, err := tr.NodeStore.Get(ctx, tr.RootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.SubtreeIDs) == 0 {
+		t.Fatal("expected the root node to be developed once requeued")
+	}
+}
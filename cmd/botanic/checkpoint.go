@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+checkpoint records on the file given with the checkpoint flag how many
+samples of the input set have been imported into the output set, so that an
+interrupted import can be resumed with the resume flag, skipping them
+instead of starting from zero. As the samples are skipped from the stream
+after the where, limit and sampling flags are applied, the checkpoint also
+records them and the projection, and refuses to resume an import that
+would read other samples.
+*/
+type checkpoint struct {
+	path   string
+	resume bool
+	skip   int
+	state  checkpointState
+}
+
+/*
+checkpointState is what is recorded on a checkpoint file: the import, given
+by its input and output sets, its where expression, the description of its
+sampler, including the seed, and its select and rename flags, and the
+number of samples imported.
+*/
+type checkpointState struct {
+	Input   string `json:"input"`
+	Output  string `json:"output"`
+	Where   string `json:"where,omitempty"`
+	Sampler string `json:"sampler,omitempty"`
+	Select  string `json:"select,omitempty"`
+	Rename  string `json:"rename,omitempty"`
+	Samples int    `json:"samples"`
+}
+
+/*
+String returns a description of the import of the state, without the
+number of samples imported.
+*/
+func (cs checkpointState) String() string {
+	var flags []string
+	if cs.Where != "" {
+		flags = append(flags, fmt.Sprintf("where=%q", cs.Where))
+	}
+	if cs.Sampler != "" {
+		flags = append(flags, cs.Sampler)
+	}
+	if cs.Select != "" {
+		flags = append(flags, fmt.Sprintf("select=%q", cs.Select))
+	}
+	if cs.Rename != "" {
+		flags = append(flags, fmt.Sprintf("rename=%q", cs.Rename))
+	}
+	if len(flags) == 0 {
+		return fmt.Sprintf("importing %s into %s", cs.Input, cs.Output)
+	}
+	return fmt.Sprintf("importing %s into %s with %s", cs.Input, cs.Output, strings.Join(flags, " "))
+}
+
+/*
+Enabled returns whether imports are checkpointed.
+*/
+func (c *checkpoint) Enabled() bool {
+	return c.path != ""
+}
+
+/*
+Load takes the state of the import, with no samples imported, and, when
+resuming, reads the number of samples to skip from the checkpoint file. A
+missing file is taken as a checkpoint with no samples imported. It returns
+an error if the file cannot be read or belongs to another import, be it of
+other sets or with other where, sampling, select or rename flags.
+*/
+func (c *checkpoint) Load(state checkpointState) error {
+	c.state = state
+	if !c.resume {
+		return nil
+	}
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading checkpoint %s: %v", c.path, err)
+	}
+	var recorded checkpointState
+	err = json.Unmarshal(b, &recorded)
+	if err != nil {
+		return fmt.Errorf("parsing checkpoint %s: %v", c.path, err)
+	}
+	c.skip, recorded.Samples = recorded.Samples, 0
+	if recorded != state {
+		c.skip = 0
+		return fmt.Errorf("checkpoint %s is for %v, not %v", c.path, recorded, state)
+	}
+	return nil
+}
+
+/*
+Resuming returns whether the import resumes one that already imported some
+samples, so that the output set must be opened instead of created.
+*/
+func (c *checkpoint) Resuming() bool {
+	return c.skip > 0
+}
+
+/*
+Save takes the number of samples written on the output set since the import
+was started or resumed and records it on the checkpoint file, together with
+the samples skipped. The file is replaced atomically, so that an
+interruption leaves either the previous checkpoint or the new one.
+*/
+func (c *checkpoint) Save(written int) error {
+	state := c.state
+	state.Samples = c.skip + written
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint: %v", err)
+	}
+	tmp := c.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("writing checkpoint %s: %v", c.path, err)
+	}
+	return nil
+}
+
+/*
+Skip takes a context and a stream of samples and returns a stream with the
+samples of the given stream but those already imported, which are read and
+discarded. The returned stream is closed once the given one is closed or the
+context is done.
+*/
+func (c *checkpoint) Skip(ctx context.Context, samples <-chan set.Sample) <-chan set.Sample {
+	if c.skip == 0 {
+		return samples
+	}
+	skipped := make(chan set.Sample)
+	go func() {
+		defer close(skipped)
+		i := 0
+		for s := range samples {
+			if i < c.skip {
+				i++
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case skipped <- s:
+			}
+		}
+	}()
+	return skipped
+}
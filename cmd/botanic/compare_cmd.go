@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/csv"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/pbanos/botanic/tree"
+	"github.com/spf13/cobra"
+)
+
+type compareCmdConfig struct {
+	*treeCmdConfig
+	treeA         string
+	treeB         string
+	dataInput     string
+	disagreements string
+}
+
+func compareCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &compareCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "compare",
+		Short: "Compare the predictions of two trees",
+		Long:  `Compare the predictions of two trees over a stream of samples to decide whether one can replace the other`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			a, err := loadTree(config.Context(), config.treeA, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			b, err := loadTree(config.Context(), config.treeB, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			if a.ClassFeature.Name() != b.ClassFeature.Name() {
+				fmt.Fprintf(os.Stderr, "trees predict different class features: %s and %s\n", a.ClassFeature.Name(), b.ClassFeature.Name())
+				exit(4)
+			}
+			c, err := config.compare(a, b, features)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "comparing trees: %v\n", err)
+				exit(5)
+			}
+			printComparison(c)
+		},
+	}
+	cmd.PersistentFlags().StringVar(&(config.treeA), "a", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree currently in use will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().StringVar(&(config.treeB), "b", "", "path to a file from which the candidate tree will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv) or JSON Lines (.jsonl or .ndjson) file, SQLite3 (.db) file, or PostgreSQL DB connection URL or Cassandra keyspace URL with the stream of samples to compare the trees on (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVar(&(config.disagreements), "disagreements", "", "path to a CSV (.csv) or JSON Lines (.jsonl or .ndjson) file to which the samples the trees disagree on will be written")
+	return cmd
+}
+
+func (ccc *compareCmdConfig) Validate() error {
+	if ccc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if ccc.treeA == "" {
+		return fmt.Errorf("required a flag was not set")
+	}
+	if ccc.treeB == "" {
+		return fmt.Errorf("required b flag was not set")
+	}
+	return nil
+}
+
+/*
+compare takes trees A and B and the features of the samples and compares the
+trees over the samples read one at a time from the configured input, writing
+the samples they disagree on to the configured disagreements file if any.
+It returns the resulting comparison or an error.
+*/
+func (ccc *compareCmdConfig) compare(a, b *tree.Tree, features []feature.Feature) (*tree.Comparison, error) {
+	var dw csv.Writer
+	if ccc.disagreements != "" {
+		df, err := os.Create(ccc.disagreements)
+		if err != nil {
+			return nil, err
+		}
+		defer df.Close()
+		dw, err = ccc.newSetWriter(df, ccc.disagreements, features)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(ccc.Context())
+	defer cancel()
+	sampleStream, errStream, err := opener.Stream(ctx, ccc.dataInput, features, &opener.Options{Name: "samples"})
+	if err != nil {
+		return nil, err
+	}
+	c := tree.NewComparison(a.ClassFeature)
+	for s := range sampleStream {
+		agree, err := c.Add(ctx, a, b, s)
+		if err == nil && !agree && dw != nil {
+			_, err = dw.Write(ctx, []set.Sample{s})
+		}
+		if err != nil {
+			cancel()
+			for range sampleStream {
+			}
+			<-errStream
+			return nil, err
+		}
+	}
+	err = <-errStream
+	if err != nil {
+		return nil, err
+	}
+	if dw != nil {
+		err = dw.Flush()
+		if err != nil {
+			return nil, err
+		}
+		ccc.Info("Samples the trees disagree on written", "samples", dw.Count(), "path", ccc.disagreements)
+	}
+	return c, nil
+}
+
+func printComparison(c *tree.Comparison) {
+	fmt.Printf("%f agreement rate, %d/%d samples predicted alike\n", c.AgreementRate(), c.Agreements, c.Samples)
+	if c.Labelled == 0 {
+		fmt.Printf("no samples with a value for %s to compare success rates\n", c.ClassFeature.Name())
+		return
+	}
+	aRate, aLower, aUpper := tree.WilsonInterval(c.ASuccesses, c.Labelled, tree.DefaultConfidenceZ)
+	bRate, bLower, bUpper := tree.WilsonInterval(c.BSuccesses, c.Labelled, tree.DefaultConfidenceZ)
+	fmt.Printf("tree a: %f success rate, %d/%d samples predicted correctly, confidence interval [%f, %f]\n", aRate, c.ASuccesses, c.Labelled, aLower, aUpper)
+	fmt.Printf("tree b: %f success rate, %d/%d samples predicted correctly, confidence interval [%f, %f]\n", bRate, c.BSuccesses, c.Labelled, bLower, bUpper)
+	fmt.Printf("disagreements: %d only tree a predicted correctly, %d only tree b predicted correctly, %d neither predicted correctly\n", c.AOnlySuccesses, c.BOnlySuccesses, c.BothFailures)
+	for _, cc := range c.Classes() {
+		fmt.Printf("class %s: %d samples, %d disagreements, tree a predicted %d correctly, tree b predicted %d correctly\n", cc.Value, cc.Samples, cc.Disagreements, cc.ASuccesses, cc.BSuccesses)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pbanos/botanic/set/csv"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+/*
+uncompressedPath takes the path to a set file and returns it without its .gz
+or .zst extension, if any, so that the format of the set is that of the
+compressed file, as in data.csv.gz or data.jsonl.gz.
+*/
+func uncompressedPath(path string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".zst")
+}
+
+/*
+decompress takes a reader for a set and returns a reader of its contents,
+decompressed if they are gzip compressed, which is detected from their first
+bytes regardless of the extension of the file, so that compressed sets can
+also be read from STDIN. It returns an error if the contents are zstd
+compressed, which is not supported, or cannot be read.
+*/
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(magic, zstdMagic) {
+		return nil, fmt.Errorf("zstd compressed sets are not supported, decompress them with zstd -d first")
+	}
+	if !bytes.HasPrefix(magic, gzipMagic) {
+		return br, nil
+	}
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading gzip compressed set: %v", err)
+	}
+	return gr, nil
+}
+
+/*
+gzipSetWriter writes a set compressed with gzip. Flushing it ends the
+compressed stream, so no samples can be written after it.
+*/
+type gzipSetWriter struct {
+	csv.Writer
+	gz *gzip.Writer
+}
+
+func (gw *gzipSetWriter) Flush() error {
+	err := gw.Writer.Flush()
+	if err != nil {
+		return err
+	}
+	return gw.gz.Close()
+}
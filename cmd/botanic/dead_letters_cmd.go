@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pbanos/botanic/coordinator"
+	"github.com/pbanos/botanic/queue"
+	"github.com/spf13/cobra"
+)
+
+type deadLettersCmdConfig struct {
+	*workCmdConfig
+	requeue    []string
+	requeueAll bool
+}
+
+func deadLettersCmd(workConfig *workCmdConfig) *cobra.Command {
+	config := &deadLettersCmdConfig{workCmdConfig: workConfig}
+	cmd := &cobra.Command{
+		Use:   "dead-letters",
+		Short: "List and requeue the tasks kept as dead letters by a coordinator",
+		Long:  `List the tasks a grow command started with the coordinator-addr and max-attempts flags keeps as dead letters, after workers pulled them the maximum number of times without developing their nodes, or requeue them so that workers develop them again`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				config.Fail(1, "dead-letters", err)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				config.Fail(2, "dead-letters", err)
+			}
+			q := coordinator.NewClient(config.coordinatorURL, features).Queue().(queue.DeadLetterQueue)
+			deadLetters, err := q.DeadLetters(config.Context())
+			if err != nil {
+				config.Fail(3, "dead-letters", fmt.Errorf("retrieving dead letters from coordinator: %v", err))
+			}
+			if !config.requeueAll && len(config.requeue) == 0 {
+				for _, t := range deadLetters {
+					fmt.Printf("task %s: depth %d, %d samples, %d attempts, created %s\n", t.ID(), t.Depth, t.SubsetCount, t.Attempts, t.CreatedAt.UTC().Format(time.RFC3339))
+				}
+				return
+			}
+			ids := config.requeue
+			if config.requeueAll {
+				ids = make([]string, 0, len(deadLetters))
+				for _, t := range deadLetters {
+					ids = append(ids, t.ID())
+				}
+			}
+			for _, id := range ids {
+				err := q.Requeue(config.Context(), id)
+				if err != nil {
+					config.Fail(4, "dead-letters", fmt.Errorf("requeuing task %s: %v", id, err))
+				}
+				config.Info("Requeued dead letter", "task", id)
+			}
+		},
+	}
+	cmd.Flags().StringSliceVar(&(config.requeue), "requeue", nil, "IDs of the dead letters to requeue, separated by commas, instead of listing them")
+	cmd.Flags().BoolVar(&(config.requeueAll), "requeue-all", false, "requeue every dead letter instead of listing them")
+	return cmd
+}
+
+func (dlcc *deadLettersCmdConfig) Validate() error {
+	if dlcc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if dlcc.coordinatorURL == "" {
+		return fmt.Errorf("required coordinator flag was not set")
+	}
+	if dlcc.requeueAll && len(dlcc.requeue) > 0 {
+		return fmt.Errorf("requeue flag cannot be combined with the requeue-all flag")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pbanos/botanic/tree"
+	"github.com/spf13/cobra"
+)
+
+type diffCmdConfig struct {
+	*treeCmdConfig
+	treeA     string
+	treeB     string
+	dataInput string
+}
+
+func diffCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &diffCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "diff [A B]",
+		Short: "Report the differences between two trees",
+		Long:  `Report the structural differences between two trees, the splits added, removed or changed and the leaves that predict a different value, together with summaries of both trees and, given a set, how their predictions diverge on it, to review model updates`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 2 {
+				config.treeA, config.treeB = args[0], args[1]
+			}
+			err := config.Validate(args)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			a, err := loadTree(config.Context(), config.treeA, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			b, err := loadTree(config.Context(), config.treeB, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			if a.ClassFeature.Name() != b.ClassFeature.Name() {
+				fmt.Fprintf(os.Stderr, "trees predict different class features: %s and %s\n", a.ClassFeature.Name(), b.ClassFeature.Name())
+				exit(4)
+			}
+			d, err := tree.NewDiff(config.Context(), a, b)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "comparing trees: %v\n", err)
+				exit(5)
+			}
+			printDiff(d)
+			if config.dataInput == "" {
+				return
+			}
+			ccc := &compareCmdConfig{treeCmdConfig: config.treeCmdConfig, dataInput: config.dataInput}
+			c, err := ccc.compare(a, b, features)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "comparing trees: %v\n", err)
+				exit(5)
+			}
+			printComparison(c)
+		},
+	}
+	cmd.PersistentFlags().StringVar(&(config.treeA), "a", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree currently in use will be read and parsed as JSON or the binary encoding, which can also be given as the first argument (required)")
+	cmd.PersistentFlags().StringVar(&(config.treeB), "b", "", "path to a file, or s3:// or gs:// URI of an object, from which the candidate tree will be read and parsed as JSON or the binary encoding, which can also be given as the second argument (required)")
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv) or JSON Lines (.jsonl or .ndjson) file, SQLite3 (.db) file, or PostgreSQL DB connection URL or Cassandra keyspace URL with a set on which to compare the predictions of the trees (defaults to none)")
+	return cmd
+}
+
+func (dcc *diffCmdConfig) Validate(args []string) error {
+	if len(args) != 0 && len(args) != 2 {
+		return fmt.Errorf("expected the paths to trees A and B as arguments, got %d arguments", len(args))
+	}
+	if dcc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if dcc.treeA == "" {
+		return fmt.Errorf("required a flag was not set")
+	}
+	if dcc.treeB == "" {
+		return fmt.Errorf("required b flag was not set")
+	}
+	return nil
+}
+
+func printDiff(d *tree.Diff) {
+	fmt.Printf("tree a: %d nodes, %d leaves, depth %d\n", d.AStats.Nodes, d.AStats.Leaves, d.AStats.Depth)
+	fmt.Printf("tree b: %d nodes, %d leaves, depth %d\n", d.BStats.Nodes, d.BStats.Leaves, d.BStats.Depth)
+	fmt.Printf("%d differences: %d splits added, %d splits removed, %d splits changed, %d predictions changed\n", len(d.Changes), d.Count(tree.DiffSplitAdded), d.Count(tree.DiffSplitRemoved), d.Count(tree.DiffSplitChanged), d.Count(tree.DiffPredictionChanged))
+	for _, c := range d.Changes {
+		fmt.Println(c)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+	"github.com/spf13/cobra"
+)
+
+type exportCmdConfig struct {
+	*treeCmdConfig
+	nodeStoreURI  string
+	rootID        string
+	classFeature  string
+	missingValues string
+	output        string
+}
+
+func exportCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &exportCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export a tree from a node store",
+		Long:  `Export a tree whose nodes are kept on a node store, such as one grown with the node-store flag, to a standalone tree in JSON format`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			config.Context()
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			var classFeature feature.Feature
+			for _, f := range features {
+				if f.Name() == config.classFeature {
+					classFeature = f
+					break
+				}
+			}
+			if classFeature == nil {
+				fmt.Fprintf(os.Stderr, "class feature '%s' is not defined\n", config.classFeature)
+				exit(3)
+			}
+			missingValueStrategy, err := tree.ParseMissingValueStrategy(config.missingValues)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			ns, err := openNodeStore(config.nodeStoreURI, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			t := tree.New(config.rootID, ns, classFeature)
+			t.MissingValueStrategy = missingValueStrategy
+			config.Info("Reading tree from node store", "uri", config.nodeStoreURI, "rootID", config.rootID)
+			exported, err := t.CopyTo(config.Context(), tree.NewMemoryNodeStore())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "reading tree from node store: %v\n", err)
+				exit(5)
+			}
+			config.Info("Done")
+			_, err = outputTree(config.Context(), config.output, exported)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(6)
+			}
+		},
+	}
+	cmd.PersistentFlags().StringVar(&(config.nodeStoreURI), "node-store", "", "s3:// or gs:// URI of the prefix on object storage under which the nodes of the tree are stored (required)")
+	cmd.PersistentFlags().StringVar(&(config.rootID), "root-id", "", "ID of the root node of the tree on the node store (required)")
+	cmd.PersistentFlags().StringVarP(&(config.classFeature), "class-feature", "c", "", "name of the feature the tree predicts (required)")
+	cmd.PersistentFlags().StringVar(&(config.missingValues), "missing-values", "undefined", "strategy the tree follows to predict samples with a missing value when no undefined subtree applies, the following are valid: undefined, majority, fractional, surrogate")
+	cmd.PersistentFlags().StringVarP(&(config.output), "output", "o", "", "path to a file, or s3:// or gs:// URI of an object, to which the exported tree will be written in JSON format, or in a compact binary encoding if it ends in .bin or .bin.gz (defaults to STDOUT)")
+	return cmd
+}
+
+func (ecc *exportCmdConfig) Validate() error {
+	if ecc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if ecc.nodeStoreURI == "" {
+		return fmt.Errorf("required node-store flag was not set")
+	}
+	if ecc.rootID == "" {
+		return fmt.Errorf("required root-id flag was not set")
+	}
+	if ecc.classFeature == "" {
+		return fmt.Errorf("required class-feature flag was not set")
+	}
+	return nil
+}
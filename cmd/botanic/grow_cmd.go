@@ -1,24 +1,38 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pbanos/botanic"
+	"github.com/pbanos/botanic/coordinator"
 	"github.com/pbanos/botanic/feature"
-	"github.com/pbanos/botanic/feature/yaml"
+	"github.com/pbanos/botanic/logging"
+	"github.com/pbanos/botanic/metrics"
+	"github.com/pbanos/botanic/objectstore"
 	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/queue/pgqueue"
 	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/cached"
+	"github.com/pbanos/botanic/set/cached/rediscache"
 	"github.com/pbanos/botanic/set/csv"
+	"github.com/pbanos/botanic/set/opener"
 	"github.com/pbanos/botanic/set/sqlset"
-	"github.com/pbanos/botanic/set/sqlset/pgadapter"
-	"github.com/pbanos/botanic/set/sqlset/sqlite3adapter"
 	"github.com/pbanos/botanic/tree"
+	"github.com/pbanos/botanic/tree/binary"
 	"github.com/pbanos/botanic/tree/json"
+	"github.com/pbanos/botanic/webhook"
 	"github.com/spf13/cobra"
 )
 
@@ -28,9 +42,39 @@ type growCmdConfig struct {
 	output             string
 	classFeature       string
 	pruneStrategy      string
+	missingValues      string
+	weightFeature      string
 	cpuIntensiveSet    bool
 	memoryIntensiveSet bool
+	columnarSet        bool
+	maxDepth           int
+	minSamplesSplit    int
+	minSamplesLeaf     int
+	maxThresholds      int
+	discreteSplit      string
+	smoothing          string
+	boost              int
 	concurrency        int
+	featureConcurrency int
+	explainQueries     bool
+	explainAnalyze     bool
+	explainEvery       int
+	progressInterval   time.Duration
+	modelName          string
+	modelVersion       string
+	metricsAddr        string
+	nodeStoreURI       string
+	nodeCacheSize      int
+	nodeFlushSize      int
+	nodeFlushInterval  time.Duration
+	coordinatorAddr    string
+	taskTimeout        time.Duration
+	maxAttempts        int
+	queueBackend       string
+	growthMetrics      *metrics.GrowthMetrics
+	cacheURL           string
+	cacheTTL           time.Duration
+	sampler            sampler
 	ctx                context.Context
 }
 
@@ -43,20 +87,29 @@ func growCmd(treeConfig *treeCmdConfig) *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			err := config.Validate()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				config.Fail(1, "grow", err)
 			}
 			config.Context()
-			features, err := yaml.ReadFeaturesFromFile(config.metadataInput)
+			err = config.serveMetrics()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
+				config.Fail(1, "grow", err)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				config.Fail(2, "grow", err)
 			}
 
-			trainingSet, err := config.trainingSet(features)
+			weightFeature, err := config.weightFeatureFrom(features)
+			if err != nil {
+				config.Fail(3, "grow", err)
+			}
+			trainingSet, err := config.trainingSet(features, weightFeature)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(4)
+				config.Fail(4, "grow", err)
+			}
+			trainingSet, err = config.cachedSet(trainingSet)
+			if err != nil {
+				config.Fail(4, "grow", err)
 			}
 			var classFeature feature.Feature
 			for i, f := range features {
@@ -67,55 +120,130 @@ func growCmd(treeConfig *treeCmdConfig) *cobra.Command {
 				}
 			}
 			if classFeature == nil {
-				fmt.Fprintf(os.Stderr, "class feature '%s' is not defined\n", config.classFeature)
-				os.Exit(5)
+				config.Fail(5, "grow", fmt.Errorf("class feature '%s' is not defined", config.classFeature))
 			}
 			pruner, err := pruningStrategy(config.pruneStrategy)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(6)
+				config.Fail(6, "grow", err)
+			}
+			pruner.MaxDepth = config.maxDepth
+			pruner.MinSamplesSplit = config.minSamplesSplit
+			pruner.MinSamplesLeaf = config.minSamplesLeaf
+			discreteSplit, err := botanic.ParseDiscreteSplit(config.discreteSplit)
+			if err != nil {
+				config.Fail(6, "grow", err)
+			}
+			smoothing, err := tree.ParseSmoothing(config.smoothing)
+			if err != nil {
+				config.Fail(6, "grow", err)
+			}
+			missingValueStrategy, err := tree.ParseMissingValueStrategy(config.missingValues)
+			if err != nil {
+				config.Fail(6, "grow", err)
+			}
+			availableFeatures := make([]feature.Feature, 0, len(features)-1)
+			for _, f := range features[0 : len(features)-1] {
+				if f != weightFeature {
+					availableFeatures = append(availableFeatures, f)
+				}
 			}
-			q := queue.New()
-			ns := tree.NewMemoryNodeStore()
-			t, err := botanic.Seed(config.Context(), classFeature, features[0:len(features)-1], trainingSet, q, ns)
 			count, err := trainingSet.Count(config.Context())
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "counting training set samples: %v\n", err)
-				os.Exit(7)
-			}
-			config.Logf("Growing tree from a set with %d samples and %d features to predict %s ...", count, len(features)-1, classFeature.Name())
-			ctx, cancel := context.WithCancel(config.Context())
-			for i := 0; i < config.concurrency; i++ {
-				go func(n int) {
-					err := botanic.Work(ctx, t, q, pruner, time.Second)
-					if err != nil {
-						config.Logf("Worker %d came across an error: %v", n, err)
-						cancel()
-					}
-				}(i)
-			}
-			err = queue.WaitFor(ctx, q)
-			cancel()
+				config.Fail(7, "grow", fmt.Errorf("counting training set samples: %v", err))
+			}
+			config.Notify(webhook.EventGrowthStarted, map[string]interface{}{
+				"classFeature": classFeature.Name(),
+				"samples":      count,
+				"features":     len(availableFeatures),
+				"boost":        config.boost,
+			})
+			grow := func(ctx context.Context, s set.Set) (*tree.Tree, error) {
+				return config.growTree(ctx, classFeature, availableFeatures, s, pruner, missingValueStrategy, smoothing, botanic.WithDiscreteSplit(discreteSplit), botanic.WithMaxThresholds(config.maxThresholds), botanic.WithFeatureConcurrency(config.featureConcurrency), botanic.WithLogger(config.Logger()))
+			}
+			if config.boost > 0 {
+				config.Info("Boosting trees", "trees", config.boost, "samples", count, "features", len(availableFeatures), "classFeature", classFeature.Name())
+				e, err := botanic.Boost(config.Context(), trainingSet, classFeature, config.boost, config.setGenerator(), grow)
+				if err != nil {
+					config.Fail(8, "grow", fmt.Errorf("boosting trees: %v", err))
+				}
+				config.Info("Done")
+				size, err := outputEnsemble(config.Context(), config.output, e)
+				if err != nil {
+					config.Fail(9, "grow", err)
+				}
+				config.Notify(webhook.EventGrowthCompleted, map[string]interface{}{
+					"output": config.output,
+					"bytes":  size,
+					"trees":  len(e.Trees),
+				})
+				config.CloseNotifier()
+				fmt.Fprintf(os.Stderr, "Ensemble of %d trees written (%s)\n", len(e.Trees), byteSize(size))
+				return
+			}
+			config.Info("Growing tree", "samples", count, "features", len(availableFeatures), "classFeature", classFeature.Name())
+			t, err := grow(config.Context(), trainingSet)
+			if err != nil {
+				config.Fail(8, "grow", err)
+			}
+			config.Debug("Tree grown", "tree", t)
+			size, err := outputTree(config.Context(), config.output, t)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "growing the tree: %v\n", err)
-				os.Exit(8)
+				config.Fail(9, "grow", err)
 			}
-			config.Logf("Done")
-			config.Logf("%v", t)
-			err = outputTree(config.Context(), config.output, t)
+			stats, err := t.Stats(config.Context())
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(9)
+				config.Fail(10, "grow", fmt.Errorf("computing tree stats: %v", err))
 			}
+			config.Notify(webhook.EventGrowthCompleted, map[string]interface{}{
+				"output": config.output,
+				"bytes":  size,
+				"nodes":  stats.Nodes,
+				"leaves": stats.Leaves,
+				"depth":  stats.Depth,
+			})
+			config.CloseNotifier()
+			fmt.Fprintf(os.Stderr, "Tree with %d nodes, %d leaves and depth %d written (%s)\n", stats.Nodes, stats.Leaves, stats.Depth, byteSize(size))
 		},
 	}
-	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv) or SQLite3 (.db) file, or a PostgreSQL DB connection URL with data to use to grow the tree (defaults to STDIN, interpreted as CSV)")
-	cmd.PersistentFlags().StringVarP(&(config.output), "output", "o", "", "path to a file to which the generated tree will be written in JSON format (defaults to STDOUT)")
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL with data to use to grow the tree (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.output), "output", "o", "", "path to a file, or s3:// or gs:// URI of an object, to which the generated tree will be written in JSON format, or in a compact binary encoding if it ends in .bin or .bin.gz (defaults to STDOUT)")
 	cmd.PersistentFlags().StringVarP(&(config.classFeature), "class-feature", "c", "", "name of the feature the generated tree should predict (required)")
-	cmd.PersistentFlags().StringVarP(&(config.pruneStrategy), "prune", "p", "default", "pruning strategy to apply, the following are valid: default, minimum-information-gain:[VALUE], none")
+	cmd.PersistentFlags().StringVarP(&(config.pruneStrategy), "prune", "p", "default", "pruning strategy to apply, the following are valid: default, minimum-information-gain:[VALUE], cost-complexity:[ALPHA], none")
+	cmd.PersistentFlags().StringVar(&(config.missingValues), "missing-values", "undefined", "strategy the tree follows to predict samples with a missing value when no undefined subtree applies, the following are valid: undefined, majority, fractional, surrogate")
+	cmd.PersistentFlags().StringVarP(&(config.weightFeature), "weight-feature", "w", "", "name of a continuous feature whose value is the weight of every sample of the training set, samples with no value weigh 1 (defaults to all samples weighing 1)")
 	cmd.PersistentFlags().BoolVar(&(config.memoryIntensiveSet), "memory-intensive", false, "force the use of memory-intensive subsetting to decrease time at the cost of increasing memory use")
+	cmd.PersistentFlags().BoolVar(&(config.columnarSet), "columnar", false, "force the use of columnar subsetting, which keeps the values of the samples in columns to decrease time at the cost of the memory of the columns")
 	cmd.PersistentFlags().BoolVar(&(config.cpuIntensiveSet), "cpu-intensive", false, "force the use of cpu-intensive subsetting to decrease memory use at the cost of increasing time")
+	cmd.PersistentFlags().IntVar(&(config.maxDepth), "max-depth", 0, "maximum depth of the nodes of the tree, the root being at depth 0 (defaults to 0, no limit)")
+	cmd.PersistentFlags().IntVar(&(config.minSamplesSplit), "min-samples-split", 0, "minimum number of training samples a node must have to be branched out (defaults to 0, no minimum)")
+	cmd.PersistentFlags().IntVar(&(config.minSamplesLeaf), "min-samples-leaf", 0, "minimum number of training samples for every subtree with samples of a node branched out, branchings with smaller subtrees are pruned (defaults to 0, no minimum)")
+	cmd.PersistentFlags().IntVar(&(config.maxThresholds), "max-thresholds", 64, "maximum number of candidate thresholds evaluated to split a range of values of a continuous feature, taken at the quantiles of the values when there are more (0 for no limit)")
+	cmd.PersistentFlags().StringVar(&(config.discreteSplit), "discrete-split", "multiway", "how nodes are branched out on discrete features, the following are valid: multiway, for a subtree for every value of the feature, binary, for two subtrees with the subset of values that gives the most information gain and the rest")
+	cmd.PersistentFlags().StringVar(&(config.smoothing), "smoothing", "none", "smoothing of the probabilities of the predictions of the nodes, so that small leaves do not predict overconfident probabilities, the following are valid: none, laplace, m-estimate:M (with M samples of the prediction of the parent node added)")
+	cmd.PersistentFlags().IntVar(&(config.boost), "boost", 0, "number of rounds of AdaBoost (SAMME) to grow an ensemble of trees instead of a single tree, usually combined with a small max-depth (defaults to 0, no boosting)")
+	cmd.PersistentFlags().StringVar(&(config.cacheURL), "cache-url", "", "URL of a Redis server (redis://[:PASSWORD@]HOST[:PORT][/DB]) on which to cache the aggregates computed on the training set and its subsets, to share them with other processes growing trees from the same set, or memory to cache them only for the growth of this tree (defaults to no cache)")
+	cmd.PersistentFlags().DurationVar(&(config.cacheTTL), "cache-ttl", 0, "time to live of the aggregates cached with the cache-url flag (defaults to 0, no expiration)")
+	cmd.PersistentFlags().IntVar(&(config.sampler.limit), "limit", 0, "maximum number of samples read from the training set, the first ones on it, which are loaded in memory for SQLite3, PostgreSQL and Cassandra sets (defaults to 0, no limit)")
+	cmd.PersistentFlags().Float64Var(&(config.sampler.fraction), "sample", 0, "probability between 0 and 1 that every sample of the training set is used to grow the tree, to grow it quickly from a random sample of that fraction of it, which is loaded in memory for SQLite3, PostgreSQL and Cassandra sets (defaults to 0, every sample)")
+	cmd.PersistentFlags().IntVar(&(config.sampler.size), "sample-size", 0, "number of samples of a uniform random sample of the training set to grow the tree from instead of all of it (defaults to 0, every sample)")
+	cmd.PersistentFlags().Int64Var(&(config.sampler.seed), "sample-seed", 0, "seed of the random sample taken with the sample or sample-size flags, so that growing a tree from the same training set with the same seed uses the same samples (defaults to 0, a seed taken from the current time)")
 	cmd.PersistentFlags().IntVar(&(config.concurrency), "concurrency", 1, "limit to concurrent workers on the tree and on DB connections opened at a time (defaults to 1)")
+	cmd.PersistentFlags().IntVar(&(config.featureConcurrency), "feature-concurrency", 1, "limit to features whose partitions are computed concurrently by every worker when branching out a node (defaults to 1)")
+	cmd.PersistentFlags().StringVar(&(config.nodeStoreURI), "node-store", "", "s3:// or gs:// URI of a prefix on object storage under which the nodes of the tree are stored as JSON objects while it grows, with the consolidated tree written to its tree.json object when done (defaults to keeping nodes in memory)")
+	cmd.PersistentFlags().IntVar(&(config.nodeCacheSize), "node-cache-size", 0, "number of recently used nodes of the tree kept in memory in front of the node store given with the node-store flag, whose updates are written to it behind the scenes in batches (defaults to 0, no cache)")
+	cmd.PersistentFlags().IntVar(&(config.nodeFlushSize), "node-flush-size", 64, "number of updated nodes cached with the node-cache-size flag that triggers writing them to the node store")
+	cmd.PersistentFlags().DurationVar(&(config.nodeFlushInterval), "node-flush-interval", time.Second, "interval at which the updated nodes cached with the node-cache-size flag are written to the node store (0 to write them only when node-flush-size are pending)")
+	cmd.PersistentFlags().StringVar(&(config.coordinatorAddr), "coordinator-addr", "", "address (such as :7070) on which to serve the queue of tasks and the nodes of the tree to workers started with the tree work command on other machines, which grow the tree instead of this process (defaults to growing the tree in process)")
+	cmd.PersistentFlags().IntVar(&(config.maxAttempts), "max-attempts", 0, "number of times a task served with the coordinator-addr flag can be pulled by workers before it is kept as a dead letter instead of being given to another worker, to be inspected and requeued with the dead-letters subcommand of the work command (defaults to 0, no limit)")
+	cmd.PersistentFlags().StringVar(&(config.queueBackend), "queue-backend", "memory", "where the queue of tasks served with the coordinator-addr flag is kept, along with the samples of their sets, the following are valid: memory, or a PostgreSQL DB connection URL (postgres:// or postgresql://) with a tasks table that has no tasks, which is created if missing")
+	cmd.PersistentFlags().DurationVar(&(config.taskTimeout), "task-timeout", 0, "time a worker has to develop a node pulled from the coordinator started with the coordinator-addr flag before it is given to another worker, which should be well over the time to develop any node (defaults to 0, no timeout)")
+	cmd.PersistentFlags().StringVar(&(config.metricsAddr), "metrics-addr", "", "address (such as :9090) on which an HTTP server exposes metrics on the growth of the tree, such as the tasks developed and the latency of the queries on the training set, on the /metrics path in the Prometheus text format (defaults to no metrics)")
+	cmd.PersistentFlags().DurationVar(&(config.progressInterval), "progress", 0, "interval at which the progress of the growth of the tree, such as the nodes developed and pending and the depth reached, is written to STDERR (defaults to 0, no progress)")
+	cmd.PersistentFlags().BoolVar(&(config.explainQueries), "explain-queries", false, "log the queries run on a SQLite3 or PostgreSQL training set with their plans and the hash of the criteria of the subset they read, to find the indexes the set lacks")
+	cmd.PersistentFlags().BoolVar(&(config.explainAnalyze), "explain-analyze", false, "obtain the plans of the queries logged with the explain-queries flag with EXPLAIN ANALYZE on PostgreSQL, running them twice to report their actual times")
+	cmd.PersistentFlags().IntVar(&(config.explainEvery), "explain-every", 1, "log only one of every given number of queries with the explain-queries flag (defaults to 1, every query)")
+	cmd.PersistentFlags().StringVar(&(config.modelName), "model-name", "", "name of the model recorded with the provenance of the tree in its JSON output (defaults to none)")
+	cmd.PersistentFlags().StringVar(&(config.modelVersion), "model-version", "", "version of the model recorded with the provenance of the tree in its JSON output (defaults to none)")
 	return cmd
 }
 
@@ -126,93 +254,654 @@ func (gcc *growCmdConfig) Validate() error {
 	if gcc.classFeature == "" {
 		return fmt.Errorf("required class-feature flag was not set")
 	}
+	if gcc.weightFeature != "" && gcc.weightFeature == gcc.classFeature {
+		return fmt.Errorf("the class feature cannot be the weight feature")
+	}
 	if gcc.cpuIntensiveSet && gcc.memoryIntensiveSet {
 		return fmt.Errorf("cannot set both memory-intensive and cpu-intensive flags at the same time")
 	}
+	if gcc.columnarSet && (gcc.cpuIntensiveSet || gcc.memoryIntensiveSet) {
+		return fmt.Errorf("cannot set the columnar flag together with the memory-intensive or cpu-intensive flags")
+	}
+	if gcc.maxDepth < 0 {
+		return fmt.Errorf("max-depth flag cannot be negative")
+	}
+	if gcc.minSamplesSplit < 0 {
+		return fmt.Errorf("min-samples-split flag cannot be negative")
+	}
+	if gcc.minSamplesLeaf < 0 {
+		return fmt.Errorf("min-samples-leaf flag cannot be negative")
+	}
+	if gcc.maxThresholds < 0 {
+		return fmt.Errorf("max-thresholds flag cannot be negative")
+	}
+	if gcc.boost < 0 {
+		return fmt.Errorf("boost flag cannot be negative")
+	}
+	if gcc.nodeStoreURI != "" {
+		if !objectstore.IsURI(gcc.nodeStoreURI) {
+			return fmt.Errorf("node-store flag must be an s3:// or gs:// URI")
+		}
+		if gcc.boost > 0 {
+			return fmt.Errorf("node-store flag cannot be combined with the boost flag")
+		}
+	}
+	if gcc.coordinatorAddr != "" && gcc.boost > 0 {
+		return fmt.Errorf("coordinator-addr flag cannot be combined with the boost flag")
+	}
+	if gcc.taskTimeout < 0 {
+		return fmt.Errorf("task-timeout flag cannot be negative")
+	}
+	if gcc.taskTimeout > 0 && gcc.coordinatorAddr == "" {
+		return fmt.Errorf("task-timeout flag requires the coordinator-addr flag")
+	}
+	if gcc.maxAttempts < 0 {
+		return fmt.Errorf("max-attempts flag cannot be negative")
+	}
+	if gcc.maxAttempts > 0 && gcc.coordinatorAddr == "" {
+		return fmt.Errorf("max-attempts flag requires the coordinator-addr flag")
+	}
+	if gcc.queueBackend != "memory" {
+		if !strings.HasPrefix(gcc.queueBackend, "postgres://") && !strings.HasPrefix(gcc.queueBackend, "postgresql://") {
+			return fmt.Errorf("queue-backend flag must be memory or a postgres:// or postgresql:// URL")
+		}
+		if gcc.coordinatorAddr == "" {
+			return fmt.Errorf("queue-backend flag requires the coordinator-addr flag")
+		}
+	}
+	if gcc.nodeCacheSize < 0 {
+		return fmt.Errorf("node-cache-size flag cannot be negative")
+	}
+	if gcc.nodeCacheSize > 0 && gcc.nodeStoreURI == "" {
+		return fmt.Errorf("node-cache-size flag requires the node-store flag")
+	}
+	if gcc.nodeFlushSize < 1 {
+		return fmt.Errorf("node-flush-size flag must be at least 1")
+	}
+	if gcc.nodeFlushInterval < 0 {
+		return fmt.Errorf("node-flush-interval flag cannot be negative")
+	}
+	if gcc.progressInterval < 0 {
+		return fmt.Errorf("progress flag cannot be negative")
+	}
+	if gcc.cacheTTL < 0 {
+		return fmt.Errorf("cache-ttl flag cannot be negative")
+	}
+	err := gcc.sampler.Validate()
+	if err != nil {
+		return err
+	}
 	if gcc.concurrency < 1 {
 		return fmt.Errorf("cannot grow a tree without workers")
 	}
+	if gcc.featureConcurrency < 1 {
+		return fmt.Errorf("feature-concurrency flag must be at least 1")
+	}
+	if gcc.explainEvery < 1 {
+		return fmt.Errorf("explain-every flag must be at least 1")
+	}
+	if (gcc.explainAnalyze || gcc.explainEvery > 1) && !gcc.explainQueries {
+		return fmt.Errorf("explain-analyze and explain-every flags require the explain-queries flag")
+	}
 	return nil
 }
 
-func (gcc *growCmdConfig) setGenerator() csv.SetGenerator {
-	if gcc.memoryIntensiveSet {
-		return csv.SetGenerator(set.NewMemoryIntensive)
+/*
+serveMetrics starts serving the metrics of the growth of trees in the
+Prometheus text format on the /metrics path of an HTTP server listening on
+the configured metrics address, if any, until the process exits. It returns
+an error if the address cannot be listened on.
+*/
+func (gcc *growCmdConfig) serveMetrics() error {
+	if gcc.metricsAddr == "" {
+		return nil
 	}
-	if gcc.cpuIntensiveSet {
-		return csv.SetGenerator(set.NewCPUIntensive)
+	l, err := net.Listen("tcp", gcc.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("listening for metrics on %s: %v", gcc.metricsAddr, err)
 	}
-	return csv.SetGenerator(set.New)
+	r := metrics.NewRegistry()
+	gcc.growthMetrics = metrics.NewGrowthMetrics(r)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	go func() {
+		err := http.Serve(l, mux)
+		if err != nil {
+			gcc.Warn("Serving metrics", "error", err)
+		}
+	}()
+	gcc.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", l.Addr()))
+	return nil
 }
 
-func (gcc *growCmdConfig) trainingSet(features []feature.Feature) (set.Set, error) {
-	var f *os.File
-	if gcc.dataInput == "" {
-		gcc.Logf("Reading training set from STDIN...")
-		f = os.Stdin
+/*
+reportProgress takes a ProgressObserver and logs its progress at info level,
+regardless of the configured log level, every configured progress interval
+until the returned function is called, which logs it one last time.
+*/
+func (gcc *growCmdConfig) reportProgress(po *botanic.ProgressObserver) func() {
+	logger := gcc.newLogger(logging.LevelInfo)
+	report := func() {
+		p := po.Progress()
+		logger.Log(logging.LevelInfo, "Growth progress", "nodes", p.Nodes, "running", p.Running, "pending", p.Pending, "depth", p.Depth, "prunedPartitions", p.Pruned)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(gcc.progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		report()
+	}
+}
+
+/*
+growTree takes a context, a class feature, the features available to grow a
+tree, a training set, a pruning strategy, a missing value strategy, the
+smoothing of its predictions and any number of GrowOptions for the other
+settings of the growth and grows a tree from the set with the configured
+concurrency, applying cost-complexity pruning to it afterwards if the
+configured pruning strategy requires it. The nodes are kept in memory, or as
+objects under the configured node store URI, in which case the consolidated
+tree is written to its tree.json object when done and the configured number
+of recently used nodes may be cached in front of it. If a coordinator
+address is configured the tree is grown by workers on other machines, as
+described for coordinateGrowth. Otherwise, as the tree is grown by this
+process alone, it is grown in process without a queue. A growth milestone is
+notified to the configured webhook every milestoneNodes nodes developed. It
+returns the grown tree or an error.
+*/
+func (gcc *growCmdConfig) growTree(ctx context.Context, classFeature feature.Feature, availableFeatures []feature.Feature, s set.Set, pruner *botanic.PruningStrategy, missingValueStrategy tree.MissingValueStrategy, smoothing tree.Smoothing, opts ...botanic.GrowOption) (*tree.Tree, error) {
+	started := time.Now().UTC()
+	ns := tree.NewMemoryNodeStore()
+	if gcc.nodeStoreURI != "" {
+		var err error
+		ns, err = openNodeStore(gcc.nodeStoreURI, append([]feature.Feature{classFeature}, availableFeatures...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	t := tree.New("", ns, classFeature)
+	t.MissingValueStrategy = missingValueStrategy
+	t.Smoothing = smoothing
+	var cns *tree.CachedNodeStore
+	if gcc.nodeCacheSize > 0 {
+		cns = tree.NewCachedNodeStore(ns, gcc.nodeCacheSize, gcc.nodeFlushSize, gcc.nodeFlushInterval)
+		t.NodeStore = cns
+	}
+	if gcc.webhookURL != "" {
+		t.NodeStore = &milestoneNodeStore{NodeStore: t.NodeStore, milestone: func(nodes int64) {
+			gcc.Notify(webhook.EventGrowthMilestone, map[string]interface{}{
+				"classFeature": classFeature.Name(),
+				"nodes":        nodes,
+			})
+		}}
+	}
+	var observers []botanic.Observer
+	if gcc.growthMetrics != nil {
+		observers = append(observers, gcc.growthMetrics)
+		s = gcc.growthMetrics.Set(s)
+	}
+	if gcc.progressInterval > 0 {
+		po := &botanic.ProgressObserver{}
+		observers = append(observers, po)
+		stop := gcc.reportProgress(po)
+		defer stop()
+	}
+	if len(observers) > 0 {
+		opts = append(opts, botanic.WithObserver(botanic.MultiObserver(observers...)))
+	}
+	var err error
+	if gcc.coordinatorAddr != "" {
+		err = gcc.coordinateGrowth(ctx, t, availableFeatures, s)
 	} else {
-		if strings.HasPrefix(gcc.dataInput, "postgresql://") {
-			return gcc.PostgreSQLTrainingSet(features)
+		err = botanic.GrowInProcess(ctx, t, availableFeatures, s, pruner, gcc.concurrency, opts...)
+	}
+	if cns != nil {
+		// Closing the cache writes the pending nodes to the node store,
+		// whose Close does nothing, so it can still be used afterwards.
+		cerr := cns.Close(ctx)
+		if err == nil && cerr != nil {
+			err = cerr
 		}
-		if strings.HasSuffix(gcc.dataInput, ".db") {
-			return gcc.Sqlite3TrainingSet(features)
+	}
+	t.NodeStore = ns
+	if err != nil {
+		return nil, fmt.Errorf("growing the tree: %v", err)
+	}
+	gcc.Info("Done")
+	if alpha, ok, _ := costComplexityAlpha(gcc.pruneStrategy); ok {
+		gcc.Info("Pruning tree with cost-complexity", "alpha", alpha)
+		deleted, err := botanic.CostComplexityPrune(ctx, t, alpha)
+		if err != nil {
+			return nil, fmt.Errorf("pruning the tree: %v", err)
 		}
-		gcc.Logf("Opening %s to read training set...", gcc.dataInput)
-		var err error
-		f, err = os.Open(gcc.dataInput)
+		gcc.Info("Done", "prunedNodes", deleted)
+	}
+	t.Metadata, err = gcc.treeMetadata(ctx, classFeature, availableFeatures, s, started)
+	if err != nil {
+		return nil, err
+	}
+	if gcc.nodeStoreURI != "" {
+		treeURI := strings.TrimSuffix(gcc.nodeStoreURI, "/") + "/tree.json"
+		gcc.Info("Writing consolidated tree", "uri", treeURI, "rootID", t.RootID)
+		_, err = outputTree(ctx, treeURI, t)
 		if err != nil {
-			err = fmt.Errorf("opening training set at %s: %v", gcc.dataInput, err)
 			return nil, err
 		}
-		defer f.Close()
 	}
-	trainingSet, err := csv.ReadSet(f, features, gcc.setGenerator())
+	return t, nil
+}
+
+/*
+treeMetadata takes a context, the class feature and the features available
+to grow a tree, the set it was grown from and the time its growth started
+and returns the provenance of the tree to record with it, or an error if
+the samples of the set cannot be counted. The credentials on the URL of the
+input set are redacted, and the settings recorded are only those of the
+flags that change the tree grown.
+*/
+func (gcc *growCmdConfig) treeMetadata(ctx context.Context, classFeature feature.Feature, availableFeatures []feature.Feature, s set.Set, started time.Time) (*tree.Metadata, error) {
+	count, err := s.Count(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("reading training set: %v", err)
+		return nil, fmt.Errorf("counting training set samples: %v", err)
+	}
+	settings := map[string]string{
+		"class-feature":     gcc.classFeature,
+		"prune":             gcc.pruneStrategy,
+		"missing-values":    gcc.missingValues,
+		"max-depth":         strconv.Itoa(gcc.maxDepth),
+		"min-samples-split": strconv.Itoa(gcc.minSamplesSplit),
+		"min-samples-leaf":  strconv.Itoa(gcc.minSamplesLeaf),
+		"max-thresholds":    strconv.Itoa(gcc.maxThresholds),
+		"discrete-split":    gcc.discreteSplit,
+		"smoothing":         gcc.smoothing,
+	}
+	if gcc.weightFeature != "" {
+		settings["weight-feature"] = gcc.weightFeature
+	}
+	if gcc.boost > 0 {
+		settings["boost"] = strconv.Itoa(gcc.boost)
 	}
-	return trainingSet, nil
+	if gcc.sampler.limit > 0 {
+		settings["limit"] = strconv.Itoa(gcc.sampler.limit)
+	}
+	if gcc.sampler.fraction > 0 {
+		settings["sample"] = strconv.FormatFloat(gcc.sampler.fraction, 'g', -1, 64)
+	}
+	if gcc.sampler.size > 0 {
+		settings["sample-size"] = strconv.Itoa(gcc.sampler.size)
+	}
+	if gcc.sampler.fraction > 0 || gcc.sampler.size > 0 {
+		settings["sample-seed"] = strconv.FormatInt(gcc.sampler.seed, 10)
+	}
+	finished := time.Now().UTC()
+	return &tree.Metadata{
+		Name:         gcc.modelName,
+		Version:      gcc.modelVersion,
+		Dataset:      redactedURI(gcc.dataInput),
+		Samples:      count,
+		GrowSettings: settings,
+		Pruning:      gcc.pruneStrategy,
+		Smoothing:    gcc.smoothing,
+		FeaturesHash: feature.MetadataHash(append([]feature.Feature{classFeature}, availableFeatures...)),
+		GrowStarted:  &started,
+		GrowFinished: &finished,
+	}, nil
 }
 
-func (gcc *growCmdConfig) Sqlite3TrainingSet(features []feature.Feature) (set.Set, error) {
-	gcc.Logf("Creating SQLite3 adapter for file %s to read training set...", gcc.dataInput)
-	adapter, err := sqlite3adapter.New(gcc.dataInput, gcc.concurrency)
+/*
+redactedURI takes the path or URL of a set and returns it with the password
+of the URL, if any, redacted.
+*/
+func redactedURI(input string) string {
+	if !strings.Contains(input, "://") {
+		return input
+	}
+	u, err := url.Parse(input)
 	if err != nil {
-		return nil, err
+		return "(unparseable URL)"
 	}
-	gcc.Logf("Opening set over SQLite3 adapter for file %s to read training set...", gcc.dataInput)
-	return sqlset.Open(gcc.Context(), adapter, features)
+	return u.Redacted()
 }
 
-func (gcc *growCmdConfig) PostgreSQLTrainingSet(features []feature.Feature) (set.Set, error) {
-	gcc.Logf("Creating PostgreSQL adapter for url %s to read training set...", gcc.dataInput)
-	adapter, err := pgadapter.New(gcc.dataInput)
+/*
+coordinateGrowth takes a context, a tree, the features available to grow it
+and a training set and seeds the tree on the queue returned by taskQueue,
+serving the queue and the tree's node store to workers started with the tree
+work command on the configured coordinator address until the process exits.
+It returns once no tasks are pending, running or kept as dead letters, as
+described for waitForWorkers, or an error if the address cannot be listened
+on, the queue cannot be opened or the tree cannot be seeded.
+*/
+func (gcc *growCmdConfig) coordinateGrowth(ctx context.Context, t *tree.Tree, availableFeatures []feature.Feature, s set.Set) error {
+	l, err := net.Listen("tcp", gcc.coordinatorAddr)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("listening for workers on %s: %v", gcc.coordinatorAddr, err)
+	}
+	q, err := gcc.taskQueue(ctx, append([]feature.Feature{t.ClassFeature}, availableFeatures...))
+	if err != nil {
+		l.Close()
+		return err
+	}
+	defer q.Stop(ctx)
+	seeded, err := botanic.Seed(ctx, t.ClassFeature, availableFeatures, s, q, t.NodeStore)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	t.RootID = seeded.RootID
+	server := coordinator.NewServer(t, q, append([]feature.Feature{t.ClassFeature}, availableFeatures...))
+	server.TaskTimeout = gcc.taskTimeout
+	go func() {
+		err := http.Serve(l, server)
+		if err != nil {
+			gcc.Warn("Serving workers", "error", err)
+		}
+	}()
+	gcc.Info("Waiting for workers to grow the tree", "url", fmt.Sprintf("http://%s", l.Addr()))
+	return gcc.waitForWorkers(ctx, q)
+}
+
+/*
+taskQueue takes a context and the features of a tree and returns the queue
+to serve to workers on the configured queue backend, which keeps tasks
+pulled the configured maximum attempts as dead letters: an in-memory queue,
+or a queue on the tasks table of a PostgreSQL database, where tasks left
+from other growths would be developed as part of this one, so it returns an
+error if the table has any, as well as if it cannot be created. The
+coordinator gives the tasks of workers that exceed the configured task
+timeout to other workers itself, so tasks on PostgreSQL have no visibility
+timeout.
+*/
+func (gcc *growCmdConfig) taskQueue(ctx context.Context, features []feature.Feature) (queue.DeadLetterQueue, error) {
+	if gcc.queueBackend == "memory" {
+		return queue.NewWithMaxAttempts(gcc.maxAttempts), nil
+	}
+	gcc.Info("Keeping tasks on PostgreSQL", "url", redactedURI(gcc.queueBackend))
+	q, err := pgqueue.New(ctx, gcc.queueBackend, features, &pgqueue.Options{MaxAttempts: gcc.maxAttempts})
+	if err != nil {
+		return nil, fmt.Errorf("opening queue backend: %v", err)
+	}
+	pending, running, err := q.Count(ctx)
+	if err != nil {
+		q.Stop(ctx)
+		return nil, fmt.Errorf("counting tasks on queue backend: %v", err)
+	}
+	deadLetters, err := q.DeadLetters(ctx)
+	if err != nil {
+		q.Stop(ctx)
+		return nil, fmt.Errorf("retrieving dead letters from queue backend: %v", err)
+	}
+	if pending+running+len(deadLetters) > 0 {
+		q.Stop(ctx)
+		return nil, fmt.Errorf("queue backend has %d tasks pending, %d running and %d dead letters left from another growth", pending, running, len(deadLetters))
+	}
+	return q, nil
+}
+
+/*
+waitForWorkers takes a context and the queue served to workers and waits
+until no tasks are pending, running or kept as dead letters. While only dead
+letters are left, their nodes are still to be developed, so it warns about
+them every time their number changes and keeps waiting for them to be
+requeued with the dead-letters subcommand of the tree work command and
+developed by workers. It returns an error if the context is done or the
+tasks cannot be counted.
+*/
+func (gcc *growCmdConfig) waitForWorkers(ctx context.Context, q queue.DeadLetterQueue) error {
+	reported := 0
+	for {
+		err := queue.WaitFor(ctx, q)
+		if err != nil {
+			return err
+		}
+		deadLetters, err := q.DeadLetters(ctx)
+		if err != nil {
+			return err
+		}
+		if len(deadLetters) == 0 {
+			return nil
+		}
+		if len(deadLetters) != reported {
+			gcc.Warn("Waiting for tasks kept as dead letters to be requeued", "deadLetters", len(deadLetters))
+			reported = len(deadLetters)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+/*
+cachedSet takes the training set and returns it decorated to cache its
+aggregates on the Redis server at the configured cache URL, in memory for
+the growth of this tree if the cache URL is "memory", or as is if no cache
+URL is configured. The cache namespace is derived from the input, the
+weight feature and the sampling flags, so that only processes growing trees
+from the same training set share aggregates. It returns an error if the
+cache URL is not valid.
+*/
+func (gcc *growCmdConfig) cachedSet(s set.Set) (set.Set, error) {
+	if gcc.cacheURL == "" {
+		return s, nil
+	}
+	gcc.Info("Caching training set aggregates", "url", gcc.cacheURL)
+	var cache cached.Cache
+	if gcc.cacheURL == "memory" {
+		cache = cached.NewMemoryCache()
+	} else {
+		var err error
+		cache, err = rediscache.New(gcc.cacheURL, gcc.cacheTTL, gcc.concurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sum := sha256.Sum256([]byte(gcc.dataInput + "\x00" + gcc.weightFeature + "\x00" + gcc.sampler.String()))
+	return cached.New(s, cache, fmt.Sprintf("botanic:%x", sum[:8])), nil
+}
+
+func (gcc *growCmdConfig) setGenerator() csv.SetGenerator {
+	if gcc.memoryIntensiveSet {
+		return csv.SetGenerator(set.NewMemoryIntensive)
+	}
+	if gcc.cpuIntensiveSet {
+		return csv.SetGenerator(set.NewCPUIntensive)
+	}
+	if gcc.columnarSet {
+		return csv.SetGenerator(set.NewColumnar)
+	}
+	return csv.SetGenerator(set.New)
+}
+
+func (gcc *growCmdConfig) weightFeatureFrom(features []feature.Feature) (*feature.ContinuousFeature, error) {
+	if gcc.weightFeature == "" {
+		return nil, nil
+	}
+	for _, f := range features {
+		if f.Name() == gcc.weightFeature {
+			cf, ok := f.(*feature.ContinuousFeature)
+			if !ok {
+				return nil, fmt.Errorf("weight feature '%s' is not continuous", gcc.weightFeature)
+			}
+			return cf, nil
+		}
+	}
+	return nil, fmt.Errorf("weight feature '%s' is not defined", gcc.weightFeature)
+}
+
+func (gcc *growCmdConfig) trainingSet(features []feature.Feature, weightFeature *feature.ContinuousFeature) (set.Set, error) {
+	opts := &opener.Options{
+		Name:          "training set",
+		MaxConn:       gcc.concurrency,
+		WeightFeature: weightFeature,
+		SetGenerator:  gcc.setGenerator(),
+	}
+	if qe := gcc.queryExplainer(); qe != nil {
+		opts.Backend = map[string]interface{}{queryExplainerOption: qe}
+	}
+	if gcc.sampler.Enabled() {
+		gcc.Info("Sampling training set", "limit", gcc.sampler.limit, "sample", gcc.sampler.fraction, "sampleSize", gcc.sampler.size, "seed", gcc.sampler.seed)
+		opts.Sampler = &gcc.sampler
+	}
+	return opener.Open(gcc.Context(), gcc.dataInput, features, opts)
+}
+
+/*
+queryExplainer returns the QueryExplainer configured with the explain-queries,
+explain-analyze and explain-every flags, which logs at info level regardless
+of the configured log level, or nil if the explain-queries flag was not set.
+*/
+func (gcc *growCmdConfig) queryExplainer() *sqlset.QueryExplainer {
+	if !gcc.explainQueries {
+		return nil
+	}
+	return &sqlset.QueryExplainer{
+		Logger:  gcc.newLogger(logging.LevelInfo),
+		Every:   gcc.explainEvery,
+		Analyze: gcc.explainAnalyze,
 	}
-	gcc.Logf("Opening set over PostgreSQL adapter for url %s to read training set...", gcc.dataInput)
-	return sqlset.Open(gcc.Context(), adapter, features)
 }
 
 func (gcc *growCmdConfig) Context() context.Context {
 	if gcc.ctx == nil {
-		gcc.ctx = context.Background()
+		gcc.ctx = gcc.treeCmdConfig.Context()
 	}
 	return gcc.ctx
 }
 
-func outputTree(ctx context.Context, outputPath string, tree *tree.Tree) error {
-	var f *os.File
+/*
+outputTree takes a context, an output path and a tree and writes the tree in
+JSON format, or in the binary encoding if the path ends in .bin or .bin.gz,
+to the output as described for writeTreeFile. The nodes of the tree are
+compacted before being written. It returns the number of bytes written or an
+error.
+*/
+func outputTree(ctx context.Context, outputPath string, t *tree.Tree) (int64, error) {
+	err := tree.Compact(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	return writeTreeFile(ctx, outputPath, func(w io.Writer) error {
+		if isBinaryPath(outputPath) {
+			return binary.WriteBinaryTree(ctx, t, w)
+		}
+		return json.WriteJSONTree(ctx, t, w)
+	})
+}
+
+/*
+outputEnsemble takes a context, an output path and an ensemble and writes the
+ensemble in JSON format, or in the binary encoding if the path ends in .bin
+or .bin.gz, to the output as described for writeTreeFile. The
+nodes of every tree are compacted before being written. It returns the
+number of bytes written or an error.
+*/
+func outputEnsemble(ctx context.Context, outputPath string, e *tree.Ensemble) (int64, error) {
+	for _, t := range e.Trees {
+		err := tree.Compact(ctx, t)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return writeTreeFile(ctx, outputPath, func(w io.Writer) error {
+		if isBinaryPath(outputPath) {
+			return binary.WriteBinaryEnsemble(ctx, e, w)
+		}
+		return json.WriteJSONEnsemble(ctx, e, w)
+	})
+}
+
+/*
+isBinaryPath takes an output path and returns whether trees written to it
+should use the binary encoding, that is, whether it ends in .bin or .bin.gz.
+*/
+func isBinaryPath(outputPath string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(outputPath, ".gz"), ".bin")
+}
+
+/*
+writeTreeFile takes a context, an output path and a function that writes a
+tree or ensemble of trees and calls the function with a writer to the file
+at the path, to the object at the path if it is an s3:// or gs:// URI, or to
+STDOUT if the path is empty. If the path ends in .gz the serialization is
+compressed with gzip. Objects are stored once the function returns.
+It returns the number of bytes written or an error.
+*/
+func writeTreeFile(ctx context.Context, outputPath string, write func(io.Writer) error) (int64, error) {
+	var w io.Writer
+	var buf bytes.Buffer
+	switch {
+	case outputPath == "":
+		w = os.Stdout
+	case objectstore.IsURI(outputPath):
+		w = &buf
+	default:
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		w = f
+	}
+	cw := &countingWriter{w: w}
 	var err error
-	if outputPath == "" {
-		f = os.Stdout
+	if !strings.HasSuffix(outputPath, ".gz") {
+		err = write(cw)
 	} else {
-		f, err = os.Create(outputPath)
-		if err != nil {
-			return err
+		gw := gzip.NewWriter(cw)
+		err = write(gw)
+		if err == nil {
+			err = gw.Close()
+		} else {
+			gw.Close()
 		}
 	}
-	defer f.Close()
-	return json.WriteJSONTree(ctx, tree, f)
+	if err != nil || !objectstore.IsURI(outputPath) {
+		return cw.n, err
+	}
+	b, key, err := objectstore.Open(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	contentType := "application/json"
+	if strings.HasSuffix(outputPath, ".gz") {
+		contentType = "application/gzip"
+	}
+	err = b.Put(ctx, key, buf.Bytes(), contentType)
+	if err != nil {
+		return 0, fmt.Errorf("writing tree to %s: %v", outputPath, err)
+	}
+	return cw.n, nil
+}
+
+/*
+countingWriter is an io.Writer that counts the bytes written through it
+onto its underlying io.Writer.
+*/
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
 }
 
 func pruningStrategy(ps string) (*botanic.PruningStrategy, error) {
@@ -230,6 +919,15 @@ func pruningStrategy(ps string) (*botanic.PruningStrategy, error) {
 			return nil, fmt.Errorf("parsing minimum-information-gain parameter: %v", err)
 		}
 		return &botanic.PruningStrategy{Pruner: botanic.FixedInformationGainPruner(minimum), MinimumEntropy: 0}, nil
+	case "cost-complexity":
+		_, ok, err := costComplexityAlpha(strings.Join(parsedPS, ":"))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("cost-complexity pruning strategy requires an alpha parameter")
+		}
+		return &botanic.PruningStrategy{Pruner: botanic.NoPruner(), MinimumEntropy: 0}, nil
 	}
 	return nil, fmt.Errorf("unknown pruning strategy %s", ps)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+type importCmdConfig struct {
+	*treeCmdConfig
+	nodeStoreURI string
+}
+
+func importCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &importCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import a tree into a node store",
+		Long:  `Import a tree in JSON format or the binary encoding into a node store, writing the ID of its root node on the store to STDOUT`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			config.Context()
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			t, err := loadTree(config.Context(), config.treeInput, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			ns, err := openNodeStore(config.nodeStoreURI, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			config.Info("Writing tree to node store", "uri", config.nodeStoreURI)
+			imported, err := t.CopyTo(config.Context(), ns)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "writing tree to node store: %v\n", err)
+				exit(5)
+			}
+			config.Info("Done", "rootID", imported.RootID)
+			fmt.Println(imported.RootID)
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree to import will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().StringVar(&(config.nodeStoreURI), "node-store", "", "s3:// or gs:// URI of the prefix on object storage under which the nodes of the tree will be stored (required)")
+	return cmd
+}
+
+func (icc *importCmdConfig) Validate() error {
+	if icc.treeInput == "" {
+		return fmt.Errorf("required tree flag was not set")
+	}
+	if icc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if icc.nodeStoreURI == "" {
+		return fmt.Errorf("required node-store flag was not set")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pbanos/botanic"
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/spf13/cobra"
+)
+
+type importancesCmdConfig struct {
+	*treeCmdConfig
+	dataInput  string
+	jsonOutput bool
+}
+
+type jsonFeatureImportance struct {
+	Feature    string  `json:"feature"`
+	Importance float64 `json:"importance"`
+}
+
+func importancesCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &importancesCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "importances",
+		Short: "Rank the features of a tree by importance",
+		Long:  `Rank the features a tree branches out on by the decrease in impurity they achieve over a data set`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			s, err := config.importanceSet(features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			t, err := loadTree(config.Context(), config.treeInput, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			config.Info("Computing feature importances")
+			importances, err := botanic.FeatureImportances(config.Context(), t, s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "computing feature importances: %v\n", err)
+				exit(5)
+			}
+			config.Info("Done")
+			if config.jsonOutput {
+				jfis := make([]*jsonFeatureImportance, 0, len(importances))
+				for _, fi := range importances {
+					jfis = append(jfis, &jsonFeatureImportance{fi.Feature.Name(), fi.Importance})
+				}
+				err = json.NewEncoder(os.Stdout).Encode(jfis)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exit(6)
+				}
+				return
+			}
+			for i, fi := range importances {
+				fmt.Printf("%d\t%f\t%s\n", i+1, fi.Importance, fi.Feature.Name())
+			}
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL with data to compute the importances with, usually the training set of the tree (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().BoolVar(&(config.jsonOutput), "json", false, "print the importances as a JSON array instead of a table")
+	return cmd
+}
+
+func (icc *importancesCmdConfig) Validate() error {
+	if icc.treeInput == "" {
+		return fmt.Errorf("required tree flag was not set")
+	}
+	if icc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	return nil
+}
+
+func (icc *importancesCmdConfig) importanceSet(features []feature.Feature) (set.Set, error) {
+	return opener.Open(icc.Context(), icc.dataInput, features, &opener.Options{Name: "set"})
+}
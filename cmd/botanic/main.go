@@ -1,30 +1,238 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/logging"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/csv"
 	"github.com/spf13/cobra"
 )
 
 type rootCmdConfig struct {
-	verbose bool
+	verbose            bool
+	csvSeparator       string
+	csvMissingValue    string
+	csvColumns         string
+	csvLazyQuotes      bool
+	csvIgnoreExtra     bool
+	skipInvalidRows    bool
+	rejectsPath        string
+	rejects            *csv.Rejects
+	decimalSeparator   string
+	thousandsSeparator string
+	timeout            time.Duration
+	deadline           string
+	deadlineTime       time.Time
+	profiler           profiler
+	logLevel           string
+	logFormat          string
+	logger             logging.Logger
 }
 
-func (rcc *rootCmdConfig) Logf(format string, a ...interface{}) {
-	if !rcc.verbose {
+/*
+setUpLogger sets up the logger of the commands with the level and format
+given with the log-level and log-format flags, or returns an error if they
+are not valid. With no log-level, messages are logged from info level on if
+the verbose flag is set and from warn level on otherwise.
+*/
+func (rcc *rootCmdConfig) setUpLogger() error {
+	level := logging.LevelWarn
+	if rcc.verbose {
+		level = logging.LevelInfo
+	}
+	if rcc.logLevel != "" {
+		var err error
+		level, err = logging.ParseLevel(rcc.logLevel)
+		if err != nil {
+			return err
+		}
+	}
+	if rcc.logFormat != "text" && rcc.logFormat != "json" {
+		return fmt.Errorf("unknown log format %q, the following are valid: text, json", rcc.logFormat)
+	}
+	rcc.logger = rcc.newLogger(level)
+	return nil
+}
+
+/*
+newLogger takes a level and returns a logger that writes the messages from
+that level on to STDERR in the format given with the log-format flag.
+*/
+func (rcc *rootCmdConfig) newLogger(level logging.Level) logging.Logger {
+	if rcc.logFormat == "json" {
+		return logging.NewJSON(os.Stderr, level)
+	}
+	return logging.NewText(os.Stderr, level)
+}
+
+/*
+Logger returns the logger of the commands.
+*/
+func (rcc *rootCmdConfig) Logger() logging.Logger {
+	if rcc.logger == nil {
+		rcc.logger = logging.NewText(os.Stderr, logging.LevelWarn)
+	}
+	return rcc.logger
+}
+
+func (rcc *rootCmdConfig) Debug(msg string, keyvals ...interface{}) {
+	rcc.Logger().Log(logging.LevelDebug, msg, keyvals...)
+}
+
+func (rcc *rootCmdConfig) Info(msg string, keyvals ...interface{}) {
+	rcc.Logger().Log(logging.LevelInfo, msg, keyvals...)
+}
+
+func (rcc *rootCmdConfig) Warn(msg string, keyvals ...interface{}) {
+	rcc.Logger().Log(logging.LevelWarn, msg, keyvals...)
+}
+
+/*
+numberFormat returns the number format configured with the decimal-separator
+and thousands-separator flags or an error if they are not valid.
+*/
+func (rcc *rootCmdConfig) numberFormat() (set.NumberFormat, error) {
+	return set.NewNumberFormat(rcc.decimalSeparator, rcc.thousandsSeparator)
+}
+
+/*
+csvOptions returns the options to read and write sets in CSV format
+configured with the csv-separator, decimal-separator, thousands-separator
+and the rest of the csv flags or an error if they are not valid. The
+separator can be given as \t or tab for tab-separated sets.
+*/
+func (rcc *rootCmdConfig) csvOptions() (*csv.Options, error) {
+	nf, err := rcc.numberFormat()
+	if err != nil {
+		return nil, err
+	}
+	opts := &csv.Options{
+		NumberFormat:       nf,
+		MissingValue:       rcc.csvMissingValue,
+		NoHeader:           rcc.csvColumns != "",
+		LazyQuotes:         rcc.csvLazyQuotes,
+		IgnoreExtraColumns: rcc.csvIgnoreExtra,
+	}
+	if rcc.csvSeparator == `\t` || rcc.csvSeparator == "tab" {
+		opts.Comma = '\t'
+	} else if rcc.csvSeparator != "" {
+		runes := []rune(rcc.csvSeparator)
+		if len(runes) != 1 {
+			return nil, fmt.Errorf("CSV separator must be a single character, got %q", rcc.csvSeparator)
+		}
+		opts.Comma = runes[0]
+	}
+	return opts, nil
+}
+
+/*
+csvFeatures takes a slice of features and returns them in the order of the
+columns given with the csv-columns flag for CSV sets without a header row,
+or as they are if it was not set. It returns an error if a column is not
+among the features.
+*/
+func (rcc *rootCmdConfig) csvFeatures(features []feature.Feature) ([]feature.Feature, error) {
+	if rcc.csvColumns == "" {
+		return features, nil
+	}
+	byName := make(map[string]feature.Feature, len(features))
+	for _, f := range features {
+		byName[f.Name()] = f
+	}
+	var columns []feature.Feature
+	for _, name := range strings.Split(rcc.csvColumns, ",") {
+		f, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature %s in csv-columns flag", strings.TrimSpace(name))
+		}
+		columns = append(columns, f)
+	}
+	return columns, nil
+}
+
+/*
+rowRejects returns the csv.Rejects to which invalid rows of the sets read
+are handed when the skip-invalid-rows or rejects flags are given, creating
+the file given with the latter on the first call, or nil if invalid rows
+must make reading fail. It returns an error if the file cannot be created.
+*/
+func (rcc *rootCmdConfig) rowRejects() (*csv.Rejects, error) {
+	if rcc.rejects != nil || (!rcc.skipInvalidRows && rcc.rejectsPath == "") {
+		return rcc.rejects, nil
+	}
+	if rcc.rejectsPath == "" {
+		rcc.rejects = csv.NewRejects(nil)
+		return rcc.rejects, nil
+	}
+	rcc.Info("Creating file to write invalid rows", "path", rcc.rejectsPath)
+	f, err := os.Create(rcc.rejectsPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating rejects file %s: %v", rcc.rejectsPath, err)
+	}
+	rcc.rejects = csv.NewRejects(f)
+	return rcc.rejects, nil
+}
+
+/*
+reportRejects takes the number of rows rejected before reading a set and
+logs a warning with the number of invalid rows skipped while reading it, if
+any.
+*/
+func (rcc *rootCmdConfig) reportRejects(before int) {
+	if rcc.rejects == nil {
 		return
 	}
-	fmt.Fprintf(os.Stderr, format, a...)
-	fmt.Fprintln(os.Stderr, "")
+	if n := rcc.rejects.Count() - before; n > 0 {
+		rcc.Warn("Invalid rows skipped", "rows", n, "rejects", rcc.rejectsPath)
+	}
+}
+
+/*
+parseDeadline parses the time given with the deadline flag, if any, and
+returns an error if it is not a valid RFC 3339 time.
+*/
+func (rcc *rootCmdConfig) parseDeadline() error {
+	if rcc.deadline == "" {
+		return nil
+	}
+	d, err := time.Parse(time.RFC3339, rcc.deadline)
+	if err != nil {
+		return fmt.Errorf("deadline flag must be a time in RFC 3339 format, such as 2006-01-02T15:04:05Z07:00: %v", err)
+	}
+	rcc.deadlineTime = d
+	return nil
+}
+
+/*
+rootContext returns the context from which commands derive the contexts of
+their operations, which is done when the time given with the timeout flag
+passes or the time given with the deadline flag arrives, whichever happens
+first, together with the function that cancels it.
+*/
+func (rcc *rootCmdConfig) rootContext() (context.Context, context.CancelFunc) {
+	deadline := rcc.deadlineTime
+	if rcc.timeout > 0 {
+		if t := time.Now().Add(rcc.timeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), deadline)
 }
 
 func main() {
-	//defer profile.Start(profile.MemProfile).Stop()
-	//defer profile.Start(profile.CPUProfile).Stop()
 	if err := cliParser().Execute(); err != nil {
-		os.Exit(1)
+		exit(1)
 	}
+	exit(0)
 }
 
 func cliParser() *cobra.Command {
@@ -34,7 +242,35 @@ func cliParser() *cobra.Command {
 		Long:  `A tool to grow regression trees from your data, test them, and use them to make predictions`,
 	}
 	config := &rootCmdConfig{}
+	config.registerOpeners()
 	rootCmd.PersistentFlags().BoolVarP(&(config.verbose), "verbose", "v", false, "")
+	rootCmd.PersistentFlags().StringVar(&(config.logLevel), "log-level", "", "minimum level of the messages logged to STDERR, the following are valid: debug, info, warn, error (defaults to info with the verbose flag and to warn otherwise)")
+	rootCmd.PersistentFlags().StringVar(&(config.logFormat), "log-format", "text", "format of the messages logged to STDERR, the following are valid: text, json")
+	rootCmd.PersistentFlags().StringVar(&(config.csvSeparator), "csv-separator", "", "character separating the fields of CSV sets, or \\t or tab for tab-separated sets (defaults to ,)")
+	rootCmd.PersistentFlags().StringVar(&(config.csvMissingValue), "csv-missing-value", "", "string standing for undefined values in CSV sets (defaults to ?)")
+	rootCmd.PersistentFlags().StringVar(&(config.csvColumns), "csv-columns", "", "comma-separated names of the features of the columns of CSV sets without a header row, in order, which are then read and written without it (defaults to none, sets with a header row)")
+	rootCmd.PersistentFlags().BoolVar(&(config.csvLazyQuotes), "csv-lazy-quotes", false, "allow quotes in unquoted fields and non-doubled quotes in quoted fields of CSV sets")
+	rootCmd.PersistentFlags().BoolVar(&(config.csvIgnoreExtra), "csv-ignore-extra-columns", false, "ignore the columns of CSV sets that are not features of the metadata, and allow rows with missing or extra fields, taking missing ones as undefined")
+	rootCmd.PersistentFlags().BoolVar(&(config.skipInvalidRows), "skip-invalid-rows", false, "skip the rows of CSV and JSON Lines sets that cannot be read or parsed instead of failing on them, logging how many were skipped")
+	rootCmd.PersistentFlags().StringVar(&(config.rejectsPath), "rejects", "", "path to a CSV file on which to write the line, the error and the contents of the rows skipped when reading sets, which implies skip-invalid-rows (defaults to none)")
+	rootCmd.PersistentFlags().StringVar(&(config.decimalSeparator), "decimal-separator", "", "character separating the decimals of numbers in CSV sets and predict answers (defaults to .)")
+	rootCmd.PersistentFlags().StringVar(&(config.thousandsSeparator), "thousands-separator", "", "character separating groups of thousands of numbers in CSV sets and predict answers, ignored when reading them and not written (defaults to none)")
+	rootCmd.PersistentFlags().DurationVar(&(config.timeout), "timeout", 0, "maximum time commands may run before they are aborted (defaults to 0, no limit)")
+	rootCmd.PersistentFlags().StringVar(&(config.deadline), "deadline", "", "time in RFC 3339 format, such as 2006-01-02T15:04:05Z, at which commands are aborted if they are still running (defaults to none)")
+	rootCmd.PersistentFlags().StringVar(&(config.profiler.cpuProfile), "cpuprofile", "", "path to a file on which to write a pprof CPU profile of the command (defaults to none)")
+	rootCmd.PersistentFlags().StringVar(&(config.profiler.memProfile), "memprofile", "", "path to a file on which to write a pprof heap profile when the command ends (defaults to none)")
+	rootCmd.PersistentFlags().StringVar(&(config.profiler.trace), "trace", "", "path to a file on which to write an execution trace of the command, to be read with go tool trace (defaults to none)")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		err := config.parseDeadline()
+		if err != nil {
+			return err
+		}
+		err = config.setUpLogger()
+		if err != nil {
+			return err
+		}
+		return config.profiler.Start()
+	}
 	rootCmd.AddCommand(versionCmd(), treeCmd(config), setCmd(config))
 	return rootCmd
 }
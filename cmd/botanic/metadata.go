@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/feature/jsonmeta"
+	"github.com/pbanos/botanic/feature/yaml"
+)
+
+/*
+readFeaturesFromFile takes the path to a metadata file and returns the
+features it specifies, parsing it as JSON if the path ends in .json and as
+YAML otherwise, or an error.
+*/
+func readFeaturesFromFile(filepath string) ([]feature.Feature, error) {
+	if strings.HasSuffix(strings.ToLower(filepath), ".json") {
+		return jsonmeta.ReadFeaturesFromFile(filepath)
+	}
+	return yaml.ReadFeaturesFromFile(filepath)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/pbanos/botanic/set/sqlset"
+	"github.com/pbanos/botanic/set/sqlset/pgadapter"
+	"github.com/pbanos/botanic/set/sqlset/sqlite3adapter"
+)
+
+// queryExplainerOption is the name of the option.Options Backend setting
+// with the *sqlset.QueryExplainer of the sets on SQLite3 and PostgreSQL
+// databases.
+const queryExplainerOption = "queryExplainer"
+
+/*
+registerOpeners registers the functions that open the sets read by the
+commands on files, with the CSV and the rest of the reading flags of the
+root command, and on SQLite3, PostgreSQL and Cassandra databases, and the
+function that reads files as a stream.
+*/
+func (rcc *rootCmdConfig) registerOpeners() {
+	opener.Register(opener.FileScheme, rcc.openFileSet)
+	opener.RegisterStreamer(opener.FileScheme, rcc.streamFileSet)
+	opener.Register(opener.SQLite3Scheme, rcc.openSQLite3Set)
+	opener.Register("postgresql", rcc.openPostgreSQLSet)
+	opener.Register("cassandra", rcc.openCassandraSet)
+}
+
+/*
+openFileSet takes a context, the path to a CSV or JSON Lines file, or an
+empty path for STDIN, a slice of features and options and returns a set in
+memory with the samples read from it, taken by the sampler of the options
+if any, or an error if it cannot be read.
+*/
+func (rcc *rootCmdConfig) openFileSet(ctx context.Context, path string, features []feature.Feature, opts *opener.Options) (set.Set, error) {
+	var f *os.File
+	if path == "" {
+		rcc.Info("Reading " + opts.Name + " from STDIN")
+		f = os.Stdin
+	} else {
+		rcc.Info("Opening file to read "+opts.Name, "path", path)
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s at %s: %v", opts.Name, path, err)
+		}
+		defer f.Close()
+	}
+	if opts.Sampler == nil && opts.WeightFeature == nil {
+		s, err := rcc.readSet(f, path, features, opts.SetGenerator)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", opts.Name, err)
+		}
+		return s, nil
+	}
+	read := func(lambda func(int, set.Sample) (bool, error)) error {
+		return rcc.readSetBySample(f, path, features, lambda)
+	}
+	var samples []set.Sample
+	lambda := func(_ int, s set.Sample) (bool, error) {
+		if opts.WeightFeature != nil {
+			ws, err := set.WithWeightFeature(s, opts.WeightFeature)
+			if err != nil {
+				return false, err
+			}
+			s = ws
+		}
+		samples = append(samples, s)
+		return true, nil
+	}
+	var err error
+	if opts.Sampler != nil {
+		err = opts.Sampler.Read(read, lambda)
+	} else {
+		err = read(lambda)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", opts.Name, err)
+	}
+	return opts.SetGenerator(samples), nil
+}
+
+/*
+streamFileSet takes a context, the path to a CSV or JSON Lines file, or an
+empty path for STDIN, a slice of features and options and returns the
+streams of the samples read one at a time from it and of the error reading
+them, or an error if it cannot be opened.
+*/
+func (rcc *rootCmdConfig) streamFileSet(ctx context.Context, path string, features []feature.Feature, opts *opener.Options) (<-chan set.Sample, <-chan error, error) {
+	var f *os.File
+	if path == "" {
+		rcc.Info("Reading " + opts.Name + " from STDIN")
+		f = os.Stdin
+	} else {
+		rcc.Info("Opening file to read "+opts.Name, "path", path)
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening %s at %s: %v", opts.Name, path, err)
+		}
+	}
+	sampleStream := make(chan set.Sample)
+	errStream := make(chan error, 1)
+	go func() {
+		defer close(errStream)
+		defer close(sampleStream)
+		if path != "" {
+			defer f.Close()
+		}
+		err := rcc.readSetBySample(f, path, features, func(_ int, s set.Sample) (bool, error) {
+			if opts.WeightFeature != nil {
+				ws, err := set.WithWeightFeature(s, opts.WeightFeature)
+				if err != nil {
+					return false, err
+				}
+				s = ws
+			}
+			select {
+			case <-ctx.Done():
+				return false, nil
+			case sampleStream <- s:
+			}
+			return true, nil
+		})
+		if err != nil {
+			errStream <- fmt.Errorf("reading %s: %v", opts.Name, err)
+		}
+	}()
+	return sampleStream, errStream, nil
+}
+
+/*
+queryExplainer takes options and returns the *sqlset.QueryExplainer they
+hold as the queryExplainerOption Backend setting, or nil if they hold none.
+*/
+func queryExplainer(opts *opener.Options) *sqlset.QueryExplainer {
+	qe, _ := opts.Backend[queryExplainerOption].(*sqlset.QueryExplainer)
+	return qe
+}
+
+func (rcc *rootCmdConfig) openSQLite3Set(ctx context.Context, path string, features []feature.Feature, opts *opener.Options) (set.Set, error) {
+	rcc.Info("Creating SQLite3 adapter to read "+opts.Name, "path", path)
+	adapter, err := newSQLite3AdapterWithOptions(path, &sqlite3adapter.Options{MaxConn: opts.MaxConn, QueryExplainer: queryExplainer(opts)})
+	if err != nil {
+		return nil, err
+	}
+	rcc.Info("Opening set over SQLite3 adapter to read "+opts.Name, "path", path)
+	return openSQLSet(ctx, adapter, features, opts.WeightFeature)
+}
+
+func (rcc *rootCmdConfig) openPostgreSQLSet(ctx context.Context, url string, features []feature.Feature, opts *opener.Options) (set.Set, error) {
+	rcc.Info("Creating PostgreSQL adapter to read "+opts.Name, "url", url)
+	adapter, err := newPostgreSQLAdapter(url, &pgadapter.Options{QueryExplainer: queryExplainer(opts)})
+	if err != nil {
+		return nil, err
+	}
+	rcc.Info("Opening set over PostgreSQL adapter to read "+opts.Name, "url", url)
+	return openSQLSet(ctx, adapter, features, opts.WeightFeature)
+}
+
+func (rcc *rootCmdConfig) openCassandraSet(ctx context.Context, url string, features []feature.Feature, opts *opener.Options) (set.Set, error) {
+	rcc.Info("Creating Cassandra adapter to read "+opts.Name, "url", url)
+	adapter, err := newCassandraAdapter(url)
+	if err != nil {
+		return nil, err
+	}
+	rcc.Info("Opening set over Cassandra adapter to read "+opts.Name, "url", url)
+	return openSQLSet(ctx, adapter, features, opts.WeightFeature)
+}
+
+/*
+openSQLSet takes a context, an adapter, a slice of features and the weight
+feature, if any, and returns the set over the adapter or an error.
+*/
+func openSQLSet(ctx context.Context, adapter sqlset.Adapter, features []feature.Feature, weightFeature *feature.ContinuousFeature) (set.Set, error) {
+	if weightFeature == nil {
+		return sqlset.Open(ctx, adapter, features)
+	}
+	return sqlset.OpenWithWeightFeature(ctx, adapter, features, weightFeature)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+pipeline copies a stream of samples onto a writer. With a concurrency of 1
+every sample is transformed and written as soon as it is read. With a higher
+concurrency the samples are gathered in batches of the batch size, or of the
+samples read within the flush interval, if any, and that number of workers
+transform and write the batches concurrently, so that database backends are
+kept busy during conversions. Batches are written in the order of the stream
+unless the pipeline is unordered, in which case they are written as soon as
+they are transformed, concurrently.
+
+If the pipeline has a checkpoint function and is not unordered, it is
+called with the number of samples written every time at least
+checkpointEvery samples have been written since the last call, while no
+other samples are being written.
+*/
+type pipeline struct {
+	concurrency     int
+	batchSize       int
+	flushInterval   time.Duration
+	unordered       bool
+	checkpointEvery int
+	checkpoint      func(int) error
+}
+
+type pipelineBatch struct {
+	seq     int
+	samples []set.Sample
+}
+
+/*
+Run takes a context, a stream of samples, a function that transforms every
+sample before it is written and a writer, which must be safe for concurrent
+use if the concurrency of the pipeline is above 1, and writes the
+transformed samples of the stream on the writer until the stream is closed.
+It returns the number of samples written and the first error transforming
+or writing them, if any, in which case it stops reading the stream and
+returns without waiting for it to be closed.
+*/
+func (p *pipeline) Run(ctx context.Context, samples <-chan set.Sample, transform func(set.Sample) (set.Sample, error), w sampleWriter) (int, error) {
+	if p.concurrency <= 1 {
+		count, checkpointed := 0, 0
+		for s := range samples {
+			s, err := transform(s)
+			if err != nil {
+				return count, err
+			}
+			n, err := w.Write(ctx, []set.Sample{s})
+			count += n
+			if err == nil && p.checkpointDue(count, checkpointed) {
+				checkpointed = count
+				err = p.checkpoint(count)
+			}
+			if err != nil {
+				return count, err
+			}
+		}
+		return count, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batches := make(chan pipelineBatch)
+	go p.batch(ctx, samples, batches)
+	var lock sync.Mutex
+	turn := sync.NewCond(&lock)
+	next, count, checkpointed := 0, 0, 0
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				lock.Lock()
+				failed := firstErr != nil
+				lock.Unlock()
+				if failed {
+					continue
+				}
+				var err error
+				for i, s := range b.samples {
+					b.samples[i], err = transform(s)
+					if err != nil {
+						break
+					}
+				}
+				lock.Lock()
+				for err == nil && !p.unordered && next != b.seq && firstErr == nil {
+					turn.Wait()
+				}
+				if firstErr != nil {
+					lock.Unlock()
+					continue
+				}
+				lock.Unlock()
+				var n int
+				if err == nil {
+					n, err = w.Write(ctx, b.samples)
+				}
+				lock.Lock()
+				count += n
+				written, due := count, err == nil && p.checkpointDue(count, checkpointed)
+				if due {
+					checkpointed = count
+				}
+				lock.Unlock()
+				if due {
+					err = p.checkpoint(written)
+				}
+				lock.Lock()
+				next++
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				turn.Broadcast()
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return count, firstErr
+}
+
+/*
+checkpointDue takes the number of samples written and the number written at
+the last checkpoint and returns whether the checkpoint function of the
+pipeline must be called.
+*/
+func (p *pipeline) checkpointDue(count, checkpointed int) bool {
+	return p.checkpoint != nil && !p.unordered && count-checkpointed >= p.checkpointEvery
+}
+
+/*
+batch takes a context, a stream of samples and a stream of batches and
+sends the samples in batches of the batch size of the pipeline, or of the
+samples read within its flush interval, numbered in order, until the stream
+of samples is closed or the context is done. It then closes the stream of
+batches.
+*/
+func (p *pipeline) batch(ctx context.Context, samples <-chan set.Sample, batches chan<- pipelineBatch) {
+	defer close(batches)
+	seq := 0
+	var pending []set.Sample
+	var timeout <-chan time.Time
+	send := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case batches <- pipelineBatch{seq, pending}:
+		}
+		seq++
+		pending = nil
+		timeout = nil
+		return true
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			if !send() {
+				return
+			}
+		case s, ok := <-samples:
+			if !ok {
+				if len(pending) > 0 {
+					send()
+				}
+				return
+			}
+			if len(pending) == 0 && p.flushInterval > 0 {
+				timeout = time.After(p.flushInterval)
+			}
+			pending = append(pending, s)
+			if len(pending) >= p.batchSize && !send() {
+				return
+			}
+		}
+	}
+}
+
+/*
+lockedWriter makes a writable set that is not safe for concurrent use, such
+as a CSV or JSON Lines writer, safe for it by serializing its calls.
+*/
+type lockedWriter struct {
+	writableSet
+	lock sync.Mutex
+}
+
+func (lw *lockedWriter) Write(ctx context.Context, samples []set.Sample) (int, error) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return lw.writableSet.Write(ctx, samples)
+}
+
+func (lw *lockedWriter) Flush() error {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return lw.writableSet.Flush()
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/pbanos/botanic/feature"
-	"github.com/pbanos/botanic/feature/yaml"
+	"github.com/pbanos/botanic/set"
 	"github.com/pbanos/botanic/set/inputsample"
 	"github.com/pbanos/botanic/tree"
 	"github.com/spf13/cobra"
@@ -15,6 +17,10 @@ import (
 type predictCmdConfig struct {
 	*treeCmdConfig
 	undefinedValue string
+	boosted        bool
+	explain        bool
+	threshold      string
+	topK           int
 }
 
 type stdoutFeatureValueRequester string
@@ -29,28 +35,60 @@ func predictCmd(treeConfig *treeCmdConfig) *cobra.Command {
 			err := config.Validate()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				exit(1)
 			}
-			features, err := yaml.ReadFeaturesFromFile(config.metadataInput)
+			nf, err := config.numberFormat()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
+				exit(1)
 			}
-			tree, err := loadTree(context.Background(), config.treeInput, features)
+			features, err := readFeaturesFromFile(config.metadataInput)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(3)
+				exit(2)
+			}
+			var predictor tree.Predictor
+			if config.boosted {
+				predictor, err = loadEnsemble(config.Context(), config.treeInput, features)
+			} else {
+				predictor, err = loadTree(config.Context(), config.treeInput, features)
 			}
-			prediction, err := predict(context.Background(), tree, features, config.undefinedValue)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(4)
+				exit(3)
+			}
+			classFeature := predictorClassFeature(predictor)
+			if err = config.validateThresholdValue(classFeature); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
 			}
-			fmt.Printf("Predicted values along their probabilities are %v\n", prediction)
+			if config.explain {
+				explanation, err := predictor.(*tree.Tree).Explain(config.Context(), predictionSample(features, config.undefinedValue, nf))
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exit(4)
+				}
+				fmt.Printf("Decision path:\n%v", explanation)
+				if explanation.Prediction == nil {
+					exit(4)
+				}
+				config.printPrediction(classFeature, explanation.Prediction)
+				return
+			}
+			prediction, err := predict(config.Context(), predictor, features, config.undefinedValue, nf)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			config.printPrediction(classFeature, prediction)
 		},
 	}
-	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file from which the tree to test will be read and parsed as JSON (required)")
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree to test will be read and parsed as JSON or the binary encoding (required)")
 	cmd.PersistentFlags().StringVarP(&(config.undefinedValue), "undefined-value", "u", "?", "value to input to define a sample's value for a feature as undefined")
+	cmd.PersistentFlags().BoolVar(&(config.boosted), "boosted", false, "read the tree file as an ensemble of trees grown with the boost flag of the grow subcommand")
+	cmd.PersistentFlags().BoolVar(&(config.explain), "explain", false, "print the decision path followed to make the prediction: the nodes the sample went through with their criteria and predictions")
+	cmd.PersistentFlags().StringVar(&(config.threshold), "threshold", "", "value of the class feature and probability, such as yes:0.3, from which the value is predicted instead of the most probable one, which is predicted otherwise among the rest (defaults to none, the most probable value)")
+	cmd.PersistentFlags().IntVar(&(config.topK), "top-k", 0, "number of most probable values of the class feature to print along their probabilities, from the most probable, instead of all of them (defaults to 0, all of them)")
 	return cmd
 }
 
@@ -61,20 +99,134 @@ func (pcc *predictCmdConfig) Validate() error {
 	if pcc.treeInput == "" {
 		return fmt.Errorf("required tree flag was not set")
 	}
+	if pcc.explain && pcc.boosted {
+		return fmt.Errorf("explain flag cannot be used with boosted ensembles")
+	}
+	if pcc.topK < 0 {
+		return fmt.Errorf("top-k flag cannot be negative")
+	}
+	if pcc.threshold != "" {
+		if _, _, err := pcc.parseThreshold(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+parseThreshold returns the value and the probability given with the
+threshold flag, or an error if they are not given as VALUE:PROBABILITY with
+a probability between 0 and 1.
+*/
+func (pcc *predictCmdConfig) parseThreshold() (string, float64, error) {
+	i := strings.LastIndex(pcc.threshold, ":")
+	if i <= 0 {
+		return "", 0.0, fmt.Errorf("invalid threshold %s, expected VALUE:PROBABILITY", pcc.threshold)
+	}
+	p, err := strconv.ParseFloat(pcc.threshold[i+1:], 64)
+	if err != nil || p < 0 || p > 1 {
+		return "", 0.0, fmt.Errorf("invalid threshold probability %s, expected a number between 0 and 1", pcc.threshold[i+1:])
+	}
+	return pcc.threshold[:i], p, nil
+}
+
+/*
+validateThresholdValue takes the class feature of the tree and returns an
+error if the value given with the threshold flag is not valid for it.
+*/
+func (pcc *predictCmdConfig) validateThresholdValue(classFeature feature.Feature) error {
+	if pcc.threshold == "" || classFeature == nil {
+		return nil
+	}
+	value, _, _ := pcc.parseThreshold()
+	if ok, _ := classFeature.Valid(value); !ok {
+		return fmt.Errorf("threshold value %s is not valid for class feature %s", value, classFeature.Name())
+	}
 	return nil
 }
 
-func predict(ctx context.Context, tree *tree.Tree, features []feature.Feature, undefinedValue string) (*tree.Prediction, error) {
-	sample := inputsample.New(os.Stdin, features, stdoutFeatureValueRequester(undefinedValue), undefinedValue)
-	return tree.Predict(ctx, sample)
+/*
+printPrediction takes the class feature of the tree and a prediction and
+prints it to STDOUT: the value decided with the threshold flag, if given,
+and the values of the prediction along their probabilities, only the most
+probable ones if the top-k flag is given.
+*/
+func (pcc *predictCmdConfig) printPrediction(classFeature feature.Feature, prediction *tree.Prediction) {
+	if pcc.threshold != "" {
+		value, threshold, _ := pcc.parseThreshold()
+		decided, prob := prediction.PredictedValueWithThreshold(value, threshold)
+		if decided == "" {
+			decided = otherClassValue(classFeature, value)
+		}
+		fmt.Printf("Predicted value with a threshold of %v on %s is %s (%f)\n", threshold, value, decided, prob)
+	}
+	if pcc.topK == 0 {
+		fmt.Printf("Predicted values along their probabilities are %v\n", prediction)
+		return
+	}
+	fmt.Printf("Top %d predicted values along their probabilities are:\n", pcc.topK)
+	for i, vp := range prediction.TopValues(pcc.topK) {
+		fmt.Printf("%d. %s: %f\n", i+1, vp.Value, vp.Probability)
+	}
+}
+
+/*
+predictorClassFeature takes a tree or an ensemble and returns the feature it
+predicts.
+*/
+func predictorClassFeature(predictor tree.Predictor) feature.Feature {
+	switch p := predictor.(type) {
+	case *tree.Tree:
+		return p.ClassFeature
+	case *tree.Ensemble:
+		return p.ClassFeature
+	}
+	return nil
+}
+
+/*
+otherClassValue takes a class feature and one of its values and returns
+the first of the rest of values available for the feature, or an empty
+string if it is not a discrete feature or has no other values.
+*/
+func otherClassValue(classFeature feature.Feature, value string) string {
+	df, ok := classFeature.(*feature.DiscreteFeature)
+	if !ok {
+		return ""
+	}
+	for _, v := range df.AvailableValues() {
+		if v != value {
+			return v
+		}
+	}
+	return ""
+}
+
+func predict(ctx context.Context, predictor tree.Predictor, features []feature.Feature, undefinedValue string, nf set.NumberFormat) (*tree.Prediction, error) {
+	return predictor.Predict(ctx, predictionSample(features, undefinedValue, nf))
+}
+
+/*
+predictionSample takes a slice of features, the value that stands for an
+undefined value and a number format and returns a sample whose values are
+requested on STDOUT and read from STDIN as they are needed.
+*/
+func predictionSample(features []feature.Feature, undefinedValue string, nf set.NumberFormat) feature.Sample {
+	return inputsample.NewWithNumberFormat(os.Stdin, features, stdoutFeatureValueRequester(undefinedValue), undefinedValue, nf)
 }
 
 func (sfvr stdoutFeatureValueRequester) RequestValueFor(f feature.Feature) error {
 	switch f := f.(type) {
 	case *feature.DiscreteFeature:
+		if f.Buckets() > 0 {
+			fmt.Printf("Please provide the sample's %s:\n(any value is valid or %s if undefined)\n", f.Name(), string(sfvr))
+			break
+		}
 		fmt.Printf("Please provide the sample's %s:\n(valid values are %v or %s if undefined)\n", f.Name(), f.AvailableValues(), string(sfvr))
 	case *feature.ContinuousFeature:
 		fmt.Printf("Please provide the sample's %s:\n(valid values are real numbers or %s if undefined)\n", f.Name(), string(sfvr))
+	case *feature.BooleanFeature:
+		fmt.Printf("Please provide the sample's %s:\n(valid values are true, false, 1 and 0 or %s if undefined)\n", f.Name(), string(sfvr))
 	default:
 		return fmt.Errorf("unknown feature type %T", f)
 	}
@@ -87,6 +239,8 @@ func (sfvr stdoutFeatureValueRequester) RejectValueFor(f feature.Feature, value
 		fmt.Printf("%v is not a valid value for the sample's %s. Please provide one of %v or %s if undefined.\n", value, f.Name(), f.AvailableValues(), string(sfvr))
 	case *feature.ContinuousFeature:
 		fmt.Printf("%v is not a valid value for the sample's %s. Please provide a real number or %s if undefined.\n", value, f.Name(), string(sfvr))
+	case *feature.BooleanFeature:
+		fmt.Printf("%v is not a valid value for the sample's %s. Please provide true, false, 1 or 0 or %s if undefined.\n", value, f.Name(), string(sfvr))
 	default:
 		return fmt.Errorf("unknown feature type %T", f)
 	}
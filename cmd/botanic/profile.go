@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"syscall"
+)
+
+/*
+profiler records the CPU profile, the heap profile and the execution trace
+of a command on the files given with the cpuprofile, memprofile and trace
+flags, so that users can send them to diagnose performance issues with
+their sets. The profiles are written when the command ends, whether it
+succeeds, fails or is interrupted with a signal.
+*/
+type profiler struct {
+	cpuProfile string
+	memProfile string
+	trace      string
+	cpuFile    *os.File
+	traceFile  *os.File
+	once       sync.Once
+}
+
+/*
+activeProfiler is the profiler started for the running command, if any,
+which exit stops before the process exits.
+*/
+var activeProfiler *profiler
+
+/*
+Start starts recording the CPU profile and the execution trace if they were
+requested, sets the profiler as the active one and, if any profile was
+requested, handles interrupt and termination signals to write the profiles
+before exiting. It returns an error if the files for the profiles cannot be
+created or the recording cannot start.
+*/
+func (p *profiler) Start() error {
+	if p.cpuProfile == "" && p.memProfile == "" && p.trace == "" {
+		return nil
+	}
+	if p.cpuProfile != "" {
+		f, err := os.Create(p.cpuProfile)
+		if err != nil {
+			return fmt.Errorf("creating CPU profile: %v", err)
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("starting CPU profile: %v", err)
+		}
+		p.cpuFile = f
+	}
+	if p.trace != "" {
+		f, err := os.Create(p.trace)
+		if err != nil {
+			p.Stop()
+			return fmt.Errorf("creating execution trace: %v", err)
+		}
+		err = trace.Start(f)
+		if err != nil {
+			f.Close()
+			p.Stop()
+			return fmt.Errorf("starting execution trace: %v", err)
+		}
+		p.traceFile = f
+	}
+	activeProfiler = p
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-signals
+		fmt.Fprintf(os.Stderr, "%v received, writing profiles and exiting\n", s)
+		code := 1
+		if ss, ok := s.(syscall.Signal); ok {
+			code = 128 + int(ss)
+		}
+		exit(code)
+	}()
+	return nil
+}
+
+/*
+Stop stops recording the CPU profile and the execution trace and writes the
+heap profile, if they were requested. Only the first call has any effect.
+Errors are reported on STDERR, as the command has already ended.
+*/
+func (p *profiler) Stop() {
+	p.once.Do(func() {
+		if p.cpuFile != nil {
+			pprof.StopCPUProfile()
+			closeProfile("CPU profile", p.cpuFile)
+		}
+		if p.traceFile != nil {
+			trace.Stop()
+			closeProfile("execution trace", p.traceFile)
+		}
+		if p.memProfile != "" {
+			f, err := os.Create(p.memProfile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "creating heap profile: %v\n", err)
+				return
+			}
+			runtime.GC()
+			err = pprof.WriteHeapProfile(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "writing heap profile: %v\n", err)
+			}
+			closeProfile("heap profile", f)
+		}
+	})
+}
+
+func closeProfile(name string, f *os.File) {
+	err := f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "closing %s: %v\n", name, err)
+	}
+}
+
+/*
+exit stops the active profiler, if any, so that the profiles requested are
+written, and exits with the given code. Commands must exit with it instead
+of os.Exit.
+*/
+func exit(code int) {
+	if activeProfiler != nil {
+		activeProfiler.Stop()
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+projection restricts the features of the samples written to an output set
+to those given with the select flag, in their order, and renames those given
+with the rename flag, so that messy schemas can be cleaned up while a set
+is copied. The input and output features are pairs at the same position of
+its slices.
+*/
+type projection struct {
+	selection string
+	renaming  string
+	input     []feature.Feature
+	output    []feature.Feature
+}
+
+/*
+Enabled returns whether the projection selects or renames any feature.
+*/
+func (p *projection) Enabled() bool {
+	return p.selection != "" || p.renaming != ""
+}
+
+/*
+Features takes the slice of features of the input set and returns the
+features of the output set, or an error if the select or rename flags name
+features that are not among them, or if two output features would end up
+with the same name. It must be called before Sample.
+*/
+func (p *projection) Features(features []feature.Feature) ([]feature.Feature, error) {
+	byName := make(map[string]feature.Feature, len(features))
+	for _, f := range features {
+		byName[f.Name()] = f
+	}
+	p.input = features
+	if p.selection != "" {
+		p.input = nil
+		for _, name := range strings.Split(p.selection, ",") {
+			name = strings.TrimSpace(name)
+			f, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("cannot select unknown feature %s", name)
+			}
+			p.input = append(p.input, f)
+		}
+	}
+	renames := make(map[string]string)
+	if p.renaming != "" {
+		for _, pair := range strings.Split(p.renaming, ",") {
+			names := strings.SplitN(pair, ":", 2)
+			if len(names) != 2 || strings.TrimSpace(names[1]) == "" {
+				return nil, fmt.Errorf("invalid rename %q, expected OLD:NEW", pair)
+			}
+			from, to := strings.TrimSpace(names[0]), strings.TrimSpace(names[1])
+			if _, ok := byName[from]; !ok {
+				return nil, fmt.Errorf("cannot rename unknown feature %s", from)
+			}
+			renames[from] = to
+		}
+	}
+	p.output = make([]feature.Feature, 0, len(p.input))
+	names := make(map[string]bool, len(p.input))
+	for _, f := range p.input {
+		if to, ok := renames[f.Name()]; ok {
+			var err error
+			f, err = feature.Rename(f, to)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if names[f.Name()] {
+			return nil, fmt.Errorf("duplicate feature %s on output set", f.Name())
+		}
+		names[f.Name()] = true
+		p.output = append(p.output, f)
+	}
+	return p.output, nil
+}
+
+/*
+Sample takes a sample of the input set and returns the sample to write on
+the output set, with the values of the input features for the output ones
+and the same weight, or an error if its values cannot be retrieved.
+*/
+func (p *projection) Sample(s set.Sample) (set.Sample, error) {
+	values := make(map[string]interface{}, len(p.output))
+	for i, f := range p.input {
+		v, err := s.ValueFor(f)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			values[p.output[i].Name()] = v
+		}
+	}
+	if ws, ok := s.(set.WeightedSample); ok {
+		return set.NewWeightedSample(values, ws.Weight()), nil
+	}
+	return set.NewSample(values), nil
+}
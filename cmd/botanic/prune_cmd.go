@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbanos/botanic"
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/pbanos/botanic/tree"
+	"github.com/spf13/cobra"
+)
+
+type pruneCmdConfig struct {
+	*treeCmdConfig
+	dataInput string
+	output    string
+	strategy  string
+}
+
+func pruneCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &pruneCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Prune a grown tree with a validation set",
+		Long:  `Prune a grown tree collapsing the subtrees that do not improve its accuracy over a validation data set`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			config.Context()
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			validationSet, err := config.validationSet(features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			tree, err := loadTree(config.Context(), config.treeInput, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			count, err := validationSet.Count(config.Context())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "counting validation set samples: %v\n", err)
+				exit(5)
+			}
+			config.Info("Pruning tree with validation set", "samples", count, "strategy", config.strategy)
+			deleted, strategy, err := config.prune(tree, validationSet)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pruning tree: %v\n", err)
+				exit(6)
+			}
+			config.Info("Done", "prunedNodes", deleted)
+			recordPostPruning(tree, strategy)
+			_, err = outputTree(config.Context(), config.output, tree)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(7)
+			}
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL with data to use to validate the tree (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree to prune will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().StringVarP(&(config.output), "output", "o", "", "path to a file, or s3:// or gs:// URI of an object, to which the pruned tree will be written in JSON format, or in a compact binary encoding if it ends in .bin or .bin.gz (defaults to STDOUT)")
+	cmd.PersistentFlags().StringVarP(&(config.strategy), "strategy", "s", "reduced-error", "post-pruning strategy to apply, the following are valid: reduced-error, cost-complexity[:ALPHA] (the alpha is selected with the validation set when not given)")
+	return cmd
+}
+
+func (pcc *pruneCmdConfig) Validate() error {
+	if pcc.treeInput == "" {
+		return fmt.Errorf("required tree flag was not set")
+	}
+	if pcc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if pcc.strategy != "reduced-error" && pcc.strategy != "cost-complexity" && !strings.HasPrefix(pcc.strategy, "cost-complexity:") {
+		return fmt.Errorf("unknown post-pruning strategy %s", pcc.strategy)
+	}
+	if _, _, err := costComplexityAlpha(pcc.strategy); err != nil {
+		return err
+	}
+	return nil
+}
+
+/*
+recordPostPruning takes a tree and the post-pruning strategy applied to it
+and records the strategy and the current time on the metadata of the tree,
+keeping the rest of its provenance.
+*/
+func recordPostPruning(t *tree.Tree, strategy string) {
+	m := &tree.Metadata{}
+	if t.Metadata != nil {
+		*m = *t.Metadata
+	}
+	pruned := time.Now().UTC()
+	m.PostPruning = strategy
+	m.Pruned = &pruned
+	t.Metadata = m
+}
+
+/*
+prune takes a tree and a validation set and prunes the tree with the
+post-pruning strategy of the command: reduced-error pruning over the
+validation set or cost-complexity pruning with the alpha of the strategy or,
+if it has none, the one selected with the validation set. It returns the
+number of nodes deleted from the tree and the strategy applied, with the
+alpha selected if any, or an error.
+*/
+func (pcc *pruneCmdConfig) prune(t *tree.Tree, validationSet set.Set) (int, string, error) {
+	if pcc.strategy == "reduced-error" {
+		deleted, err := botanic.PostPrune(pcc.Context(), t, validationSet)
+		return deleted, pcc.strategy, err
+	}
+	alpha, ok, err := costComplexityAlpha(pcc.strategy)
+	if err != nil {
+		return 0, "", err
+	}
+	if !ok {
+		alpha, err = botanic.SelectCostComplexityAlpha(pcc.Context(), t, validationSet)
+		if err != nil {
+			return 0, "", fmt.Errorf("selecting cost-complexity alpha: %v", err)
+		}
+		pcc.Info("Selected cost-complexity alpha with validation set", "alpha", alpha)
+	}
+	deleted, err := botanic.CostComplexityPrune(pcc.Context(), t, alpha)
+	return deleted, fmt.Sprintf("cost-complexity:%v", alpha), err
+}
+
+func (pcc *pruneCmdConfig) validationSet(features []feature.Feature) (set.Set, error) {
+	return opener.Open(pcc.Context(), pcc.dataInput, features, &opener.Options{Name: "validation set"})
+}
+
+/*
+costComplexityAlpha takes a pruning strategy and, if it is a cost-complexity
+strategy with an alpha parameter in the form cost-complexity:ALPHA, returns
+the alpha and true. It returns false if the strategy is not a cost-complexity
+one or has no alpha, and an error if the alpha cannot be parsed.
+*/
+func costComplexityAlpha(ps string) (float64, bool, error) {
+	parsedPS := strings.SplitN(ps, ":", 2)
+	if parsedPS[0] != "cost-complexity" || len(parsedPS) < 2 || parsedPS[1] == "" {
+		return 0.0, false, nil
+	}
+	alpha, err := strconv.ParseFloat(parsedPS[1], 64)
+	if err != nil {
+		return 0.0, false, fmt.Errorf("parsing cost-complexity parameter: %v", err)
+	}
+	if alpha < 0 {
+		return 0.0, false, fmt.Errorf("cost-complexity parameter cannot be negative")
+	}
+	return alpha, true, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/spf13/cobra"
+)
+
+type routeStatsCmdConfig struct {
+	*treeCmdConfig
+	dataInput  string
+	jsonOutput bool
+}
+
+type jsonNodeRouting struct {
+	NodeID    string  `json:"nodeId"`
+	ParentID  string  `json:"parentId,omitempty"`
+	Depth     int     `json:"depth"`
+	Criterion string  `json:"criterion,omitempty"`
+	Samples   int     `json:"samples"`
+	Fraction  float64 `json:"fraction"`
+}
+
+func routeStatsCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &routeStatsCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "route-stats",
+		Short: "Count the samples of a set flowing through every node of a tree",
+		Long:  `Count how many samples of a set flow through every node of a tree when predicting them, to estimate the traffic every branch of the tree receives`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			s, err := config.routingSet(features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			t, err := loadTree(config.Context(), config.treeInput, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			config.Info("Routing samples through the tree")
+			routing, err := t.Routing(config.Context(), s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "routing samples through the tree: %v\n", err)
+				exit(5)
+			}
+			config.Info("Done")
+			if config.jsonOutput {
+				jnrs := make([]*jsonNodeRouting, 0, len(routing))
+				for _, nr := range routing {
+					jnr := &jsonNodeRouting{
+						NodeID:   nr.Node.ID,
+						ParentID: nr.Node.ParentID,
+						Depth:    nr.Depth,
+						Samples:  nr.Samples,
+						Fraction: nr.Fraction,
+					}
+					if nr.Node.FeatureCriterion != nil {
+						jnr.Criterion = fmt.Sprintf("%v", nr.Node.FeatureCriterion)
+					}
+					jnrs = append(jnrs, jnr)
+				}
+				err = json.NewEncoder(os.Stdout).Encode(jnrs)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exit(6)
+				}
+				return
+			}
+			for _, nr := range routing {
+				criterion := "root"
+				if nr.Node.FeatureCriterion != nil {
+					criterion = fmt.Sprintf("%v", nr.Node.FeatureCriterion)
+				}
+				fmt.Printf("%s%s\t%d\t%f\t%s\n", strings.Repeat("  ", nr.Depth), nr.Node.ID, nr.Samples, nr.Fraction, criterion)
+			}
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL with the samples to route through the tree, usually a sample of the requests it serves (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().BoolVar(&(config.jsonOutput), "json", false, "print the counts as a JSON array instead of a table")
+	return cmd
+}
+
+func (rcc *routeStatsCmdConfig) Validate() error {
+	if rcc.treeInput == "" {
+		return fmt.Errorf("required tree flag was not set")
+	}
+	if rcc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	return nil
+}
+
+func (rcc *routeStatsCmdConfig) routingSet(features []feature.Feature) (set.Set, error) {
+	return opener.Open(rcc.Context(), rcc.dataInput, features, &opener.Options{Name: "set"})
+}
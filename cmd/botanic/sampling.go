@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+sampler restricts the samples read from an input set to the first ones, with
+the limit flag, and to a random sample of them, either of a fraction of the
+samples with the sample flag or of a fixed number of samples with the
+sample-size flag, so that users can iterate quickly on a part of a large
+set. The random sample is taken from the samples within the limit, using the
+seed given with the sample-seed flag or one taken from the current time.
+*/
+type sampler struct {
+	limit    int
+	fraction float64
+	size     int
+	seed     int64
+}
+
+/*
+Validate returns an error if the flags of the sampler are not valid, and
+otherwise sets its seed from the current time if none was given, so that it
+can be reported.
+*/
+func (s *sampler) Validate() error {
+	if s.limit < 0 {
+		return fmt.Errorf("limit flag cannot be negative")
+	}
+	if s.fraction < 0 || s.fraction > 1 {
+		return fmt.Errorf("sample flag must be between 0 and 1")
+	}
+	if s.size < 0 {
+		return fmt.Errorf("sample-size flag cannot be negative")
+	}
+	if s.fraction > 0 && s.size > 0 {
+		return fmt.Errorf("cannot set both sample and sample-size flags at the same time")
+	}
+	if s.seed == 0 {
+		s.seed = time.Now().UnixNano()
+	}
+	return nil
+}
+
+/*
+Enabled returns whether the sampler restricts the samples read in any way.
+*/
+func (s *sampler) Enabled() bool {
+	return s.limit > 0 || s.fraction > 0 || s.size > 0
+}
+
+/*
+String returns a description of the restrictions of the sampler, which is
+empty if it is not enabled.
+*/
+func (s *sampler) String() string {
+	if !s.Enabled() {
+		return ""
+	}
+	return fmt.Sprintf("limit=%d sample=%v sample-size=%d sample-seed=%d", s.limit, s.fraction, s.size, s.seed)
+}
+
+/*
+Read takes a function that reads a stream of samples calling the function it
+is given with every sample, such as the readSetBySample method of the root
+command, and a lambda function, and calls the read function so that the
+lambda is only called with the samples within the limit and the random
+sample. With the sample-size flag, the lambda is called once the whole
+stream has been read. It returns the error returned by the read function or
+the lambda, if any.
+*/
+func (s *sampler) Read(read func(func(int, set.Sample) (bool, error)) error, lambda func(int, set.Sample) (bool, error)) error {
+	r := rand.New(rand.NewSource(s.seed))
+	decorated := lambda
+	var reservoir *set.Reservoir
+	if s.size > 0 {
+		reservoir = set.NewReservoir(s.size, r)
+		decorated = reservoir.Add
+	} else if s.fraction > 0 {
+		decorated = set.Bernoulli(s.fraction, r, lambda)
+	}
+	if s.limit > 0 {
+		decorated = set.Limit(s.limit, decorated)
+	}
+	err := read(decorated)
+	if err != nil || reservoir == nil {
+		return err
+	}
+	for i, sample := range reservoir.Samples() {
+		ok, err := lambda(i, sample)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Stream takes a context, the function that cancels the reading of a stream
+of samples and the stream with its stream of errors and returns the streams
+of the samples within the limit and the random sample, and of errors. Once
+no more samples are needed, or the context is done, the reading of the
+original stream is cancelled and it is drained. If the sampler is not
+enabled, the streams are returned unchanged.
+*/
+func (s *sampler) Stream(ctx context.Context, cancel context.CancelFunc, samples <-chan set.Sample, errs <-chan error) (<-chan set.Sample, <-chan error) {
+	if !s.Enabled() {
+		return samples, errs
+	}
+	sampleStream := make(chan set.Sample)
+	errStream := make(chan error, 1)
+	go func() {
+		defer close(errStream)
+		err := s.Read(func(lambda func(int, set.Sample) (bool, error)) error {
+			i := 0
+			for sample := range samples {
+				ok, err := lambda(i, sample)
+				i++
+				if err != nil || !ok {
+					cancel()
+					for range samples {
+					}
+					for range errs {
+					}
+					return err
+				}
+			}
+			return <-errs
+		}, func(_ int, sample set.Sample) (bool, error) {
+			select {
+			case <-ctx.Done():
+				return false, nil
+			case sampleStream <- sample:
+			}
+			return true, nil
+		})
+		close(sampleStream)
+		if err != nil {
+			errStream <- err
+		}
+	}()
+	return sampleStream, errStream
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pbanos/botanic/feature"
-	"github.com/pbanos/botanic/feature/yaml"
+	"github.com/pbanos/botanic/feature/jsonmeta"
 	"github.com/pbanos/botanic/set"
 	"github.com/pbanos/botanic/set/csv"
+	"github.com/pbanos/botanic/set/jsonl"
+	"github.com/pbanos/botanic/set/opener"
 	"github.com/pbanos/botanic/set/sqlset"
+	"github.com/pbanos/botanic/set/sqlset/cqladapter"
 	"github.com/pbanos/botanic/set/sqlset/pgadapter"
 	"github.com/pbanos/botanic/set/sqlset/sqlite3adapter"
 	"github.com/spf13/cobra"
@@ -18,11 +25,22 @@ import (
 
 type setCmdConfig struct {
 	*rootCmdConfig
-	setInput      string
-	metadataInput string
-	setOutput     string
-	ctx           context.Context
-	cancelFunc    context.CancelFunc
+	setInput       string
+	metadataInput  string
+	setOutput      string
+	batchSize      int
+	flushInterval  time.Duration
+	indexes        bool
+	sampler        sampler
+	where          string
+	projection     projection
+	metadataOutput string
+	concurrency    int
+	unordered      bool
+	checkpoint     checkpoint
+	sqlOutput      sqlset.Set
+	ctx            context.Context
+	cancelFunc     context.CancelFunc
 }
 
 type sampleWriter interface {
@@ -34,10 +52,6 @@ type writableSet interface {
 	Flush() error
 }
 
-type flushableSampleWriter struct {
-	sampleWriter
-}
-
 func setCmd(rootConfig *rootCmdConfig) *cobra.Command {
 	config := &setCmdConfig{rootCmdConfig: rootConfig}
 	cmd := &cobra.Command{
@@ -48,58 +62,120 @@ func setCmd(rootConfig *rootCmdConfig) *cobra.Command {
 			err := config.Validate()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				exit(1)
 			}
 			config.Context()
-			config.Logf("Reading features from metadata at %s...", config.metadataInput)
-			features, err := yaml.ReadFeaturesFromFile(config.metadataInput)
+			config.Info("Reading features from metadata", "path", config.metadataInput)
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			config.Info("Features from metadata read")
+			outputFeatures, err := config.OutputFeatures(features)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
+				exit(3)
+			}
+
+			err = config.checkpoint.Load(checkpointState{
+				Input:   config.setInput,
+				Output:  config.setOutput,
+				Where:   config.where,
+				Sampler: config.sampler.String(),
+				Select:  config.projection.selection,
+				Rename:  config.projection.renaming,
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			if config.checkpoint.Resuming() {
+				config.Info("Resuming import from checkpoint", "path", config.checkpoint.path, "samples", config.checkpoint.skip)
 			}
-			config.Logf("Features from metadata read")
 
-			output, err := config.OutputWriter(features)
+			output, err := config.OutputWriter(outputFeatures)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(3)
+				exit(3)
 			}
 
 			inputStream, errStream, err := config.InputStream(features)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(7)
+				exit(7)
 			}
+			inputStream = config.checkpoint.Skip(config.Context(), inputStream)
 
-			for s := range inputStream {
-				_, err = output.Write(config.Context(), []set.Sample{s})
-				if err != nil {
-					config.ContextCancelFunc()
-					break
+			pl := config.Pipeline()
+			if config.checkpoint.Enabled() {
+				pl.checkpointEvery = pl.batchSize
+				if pl.checkpointEvery <= 0 {
+					pl.checkpointEvery = sqlset.DefaultBatchSize
+				}
+				pl.checkpoint = func(written int) error {
+					err := output.Flush()
+					if err != nil {
+						return err
+					}
+					return config.checkpoint.Save(written)
 				}
 			}
+			written, err := pl.Run(config.Context(), inputStream, config.OutputSample, output)
 			if err != nil {
+				config.ContextCancelFunc()
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(8)
+				exit(8)
 			}
 			err = <-errStream
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(9)
+				exit(9)
 			}
-			config.Logf("Flushing output set...")
+			config.Info("Flushing output set")
 			err = output.Flush()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(9)
+				exit(9)
+			}
+			if config.indexes && config.sqlOutput != nil {
+				config.Info("Creating indexes on the feature columns of the output set")
+				err = sqlset.CreateIndexes(config.Context(), config.sqlOutput)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exit(9)
+				}
 			}
-			config.Logf("Done")
+			if config.checkpoint.Enabled() {
+				err = config.checkpoint.Save(written)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exit(9)
+				}
+			}
+			config.Info("Done")
 		},
 	}
-	cmd.PersistentFlags().StringVarP(&(config.setInput), "input", "i", "", "path to an input CSV (.csv) or SQLite3 (.db) file, or a PostgreSQL DB connection URL with data to use to grow the tree (defaults to STDIN, interpreted as CSV)")
-	cmd.PersistentFlags().StringVarP(&(config.metadataInput), "metadata", "m", "", "path to a YML file with metadata describing the different features available available on the input file (required)")
-	cmd.PersistentFlags().StringVarP(&(config.setOutput), "output", "o", "", "path to a CSV (.csv) or SQLite3 (.db) file, or a PostgreSQL DB connection URL to dump the output set (defaults to STDOUT in CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.setInput), "input", "i", "", "path to an input CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL with data to use to grow the tree (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.metadataInput), "metadata", "m", "", "path to a YML file, or a JSON file if it ends in .json, with metadata describing the different features available on the input file (required)")
+	cmd.PersistentFlags().StringVarP(&(config.setOutput), "output", "o", "", "path to a CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL to dump the output set (defaults to STDOUT in CSV)")
+	cmd.PersistentFlags().IntVar(&(config.batchSize), "batch-size", sqlset.DefaultBatchSize, "number of samples written together on SQLite3 and PostgreSQL output sets, and copied together by every worker with the concurrency flag")
+	cmd.PersistentFlags().IntVar(&(config.concurrency), "concurrency", 1, "number of workers copying batches of samples onto the output sets concurrently (defaults to 1, samples are copied one by one)")
+	cmd.PersistentFlags().BoolVar(&(config.unordered), "unordered", false, "with the concurrency flag, write batches of samples on the output sets as soon as they are ready, concurrently, instead of in the order of the input set")
+	cmd.PersistentFlags().BoolVar(&(config.indexes), "index", false, "create an index on every feature column of SQLite3, PostgreSQL and Cassandra output sets")
+	cmd.PersistentFlags().DurationVar(&(config.flushInterval), "flush-interval", 0, "maximum time samples are buffered before being written on SQLite3 and PostgreSQL output sets (defaults to 0, no limit)")
+	cmd.PersistentFlags().StringVar(&(config.where), "where", "", "conditions the samples read from the input set must satisfy, joined by AND, such as \"age >= 30 AND country is 'ES'\", which are run by the database on SQLite3, PostgreSQL and Cassandra input sets (defaults to none, every sample)")
+	cmd.PersistentFlags().StringVar(&(config.projection.selection), "select", "", "comma-separated names of the features written to the output set, in that order (defaults to none, every feature)")
+	cmd.PersistentFlags().StringVar(&(config.projection.renaming), "rename", "", "comma-separated pairs of names OLD:NEW of features renamed on the output set (defaults to none)")
+	cmd.PersistentFlags().StringVar(&(config.metadataOutput), "metadata-output", "", "path to a JSON file on which to write the metadata of the features of the output set, after the select and rename flags (defaults to none)")
+	cmd.PersistentFlags().IntVar(&(config.sampler.limit), "limit", 0, "maximum number of samples read from the input set, the first ones on it (defaults to 0, no limit)")
+	cmd.PersistentFlags().Float64Var(&(config.sampler.fraction), "sample", 0, "probability between 0 and 1 that every sample read from the input set is taken, to work on a random sample of that fraction of it (defaults to 0, every sample)")
+	cmd.PersistentFlags().IntVar(&(config.sampler.size), "sample-size", 0, "number of samples of a uniform random sample of the input set to take instead of all of it, which are kept in memory until the whole input set is read (defaults to 0, every sample)")
+	cmd.PersistentFlags().Int64Var(&(config.sampler.seed), "sample-seed", 0, "seed of the random sample taken with the sample or sample-size flags, so that sampling the same input set with the same seed yields the same samples (defaults to 0, a seed taken from the current time)")
+	cmd.Flags().StringVar(&(config.checkpoint.path), "checkpoint", "", "path to a file on which to record the number of samples imported into an SQLite3, PostgreSQL or Cassandra output set every batch-size samples, to resume the import with the resume flag if it is interrupted (defaults to none)")
+	cmd.Flags().BoolVar(&(config.checkpoint.resume), "resume", false, "resume the import recorded on the checkpoint file, skipping the samples of the input set already imported into the output set, or start it if the file does not exist")
 	cmd.AddCommand(splitCmd(config))
+	cmd.AddCommand(validateCmd(config))
 	return cmd
 }
 
@@ -107,7 +183,24 @@ func (scc *setCmdConfig) Validate() error {
 	if scc.metadataInput == "" {
 		return fmt.Errorf("required metadata flag was not set")
 	}
-	return nil
+	if scc.concurrency < 1 {
+		return fmt.Errorf("concurrency flag must be at least 1")
+	}
+	if scc.checkpoint.resume && !scc.checkpoint.Enabled() {
+		return fmt.Errorf("resume flag requires the checkpoint flag")
+	}
+	if scc.checkpoint.Enabled() {
+		if !strings.HasPrefix(scc.setOutput, "postgresql://") && !strings.HasPrefix(scc.setOutput, "cassandra://") && !isSQLite3(scc.setOutput) {
+			return fmt.Errorf("checkpoint flag requires an SQLite3, PostgreSQL or Cassandra output set")
+		}
+		if scc.unordered {
+			return fmt.Errorf("cannot set both checkpoint and unordered flags at the same time")
+		}
+		if (scc.sampler.fraction > 0 || scc.sampler.size > 0) && scc.sampler.seed == 0 {
+			return fmt.Errorf("checkpoint flag requires the sample-seed flag with the sample or sample-size flags, so that a resumed import takes the same random sample")
+		}
+	}
+	return scc.sampler.Validate()
 }
 
 func (scc *setCmdConfig) OutputWriter(features []feature.Feature) (writableSet, error) {
@@ -117,54 +210,163 @@ func (scc *setCmdConfig) OutputWriter(features []feature.Feature) (writableSet,
 		if strings.HasPrefix(scc.setOutput, "postgresql://") {
 			return scc.PostgreSQLOutputWriter(features)
 		}
-		if strings.HasSuffix(scc.setOutput, ".db") {
+		if strings.HasPrefix(scc.setOutput, "cassandra://") {
+			return scc.CassandraOutputWriter(features)
+		}
+		if isSQLite3(scc.setOutput) {
 			return scc.Sqlite3OutputWriter(features)
 		}
-		scc.Logf("Creating %s to dump output set...", scc.setOutput)
+		scc.Info("Creating file to dump output set", "path", scc.setOutput)
 		outputFile, err = os.Create(scc.setOutput)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		scc.Logf("Using STDOUT to dump output set...")
+		scc.Info("Using STDOUT to dump output set")
 		outputFile = os.Stdout
 	}
-	scc.Logf("Preparing to write output set...")
-	output, err := csv.NewWriter(outputFile, features)
+	scc.Info("Preparing to write output set")
+	output, err := scc.newSetWriter(outputFile, scc.setOutput, features)
 	if err != nil {
 		return nil, err
 	}
-	return output, nil
+	return &lockedWriter{writableSet: output}, nil
+}
+
+/*
+Pipeline returns the pipeline that copies the samples of the input set onto
+an output set, with the concurrency, batch size, flush interval and order
+given with the flags.
+*/
+func (scc *setCmdConfig) Pipeline() *pipeline {
+	if scc.concurrency > 1 {
+		scc.Info("Copying samples concurrently", "workers", scc.concurrency, "batchSize", scc.batchSize, "unordered", scc.unordered)
+	}
+	return &pipeline{
+		concurrency:   scc.concurrency,
+		batchSize:     scc.batchSize,
+		flushInterval: scc.flushInterval,
+		unordered:     scc.unordered,
+	}
+}
+
+/*
+sqlite3OutputMaxConn returns the maximum number of connections to open to
+SQLite3 output sets. SQLite3 databases take a single writer at a time, so
+that concurrent workers share a single connection instead of failing on
+locked databases.
+*/
+func (scc *setCmdConfig) sqlite3OutputMaxConn() int {
+	if scc.concurrency > 1 {
+		return 1
+	}
+	return 0
+}
+
+/*
+OutputFeatures takes the slice of features of the input set and returns the
+features of the output set, restricted and renamed as given with the select
+and rename flags, after writing their metadata on the file given with the
+metadata-output flag, if any. It returns an error if the flags are not valid
+for the features or the metadata cannot be written.
+*/
+func (scc *setCmdConfig) OutputFeatures(features []feature.Feature) ([]feature.Feature, error) {
+	outputFeatures := features
+	if scc.projection.Enabled() {
+		var err error
+		outputFeatures, err = scc.projection.Features(features)
+		if err != nil {
+			return nil, err
+		}
+		scc.Info("Projecting features of output set", "select", scc.projection.selection, "rename", scc.projection.renaming)
+	}
+	if scc.metadataOutput != "" {
+		scc.Info("Writing metadata of output set", "path", scc.metadataOutput)
+		err := jsonmeta.WriteFeaturesToFile(scc.metadataOutput, outputFeatures)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return outputFeatures, nil
 }
 
+/*
+OutputSample takes a sample of the input set and returns the sample to
+write on the output set, with the features selected and renamed with the
+select and rename flags, or an error if its values cannot be retrieved.
+*/
+func (scc *setCmdConfig) OutputSample(s set.Sample) (set.Sample, error) {
+	if !scc.projection.Enabled() {
+		return s, nil
+	}
+	return scc.projection.Sample(s)
+}
+
+/*
+InputStream takes a slice of features and returns the streams of samples of
+the input set and of errors reading them, restricted to the samples that
+satisfy the conditions of the where flag and taken by the limit and sampling
+flags, or an error if the conditions are not valid or the input set cannot
+be read.
+*/
 func (scc *setCmdConfig) InputStream(features []feature.Feature) (<-chan set.Sample, <-chan error, error) {
+	var criteria []feature.Criterion
+	if scc.where != "" {
+		var err error
+		criteria, err = feature.ParseCriteria(scc.where, features)
+		if err != nil {
+			return nil, nil, err
+		}
+		scc.Info("Filtering input set", "where", scc.where)
+	}
+	ctx, cancel := context.WithCancel(scc.Context())
+	sampleStream, errStream, err := scc.inputStream(ctx, features, criteria)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if scc.sampler.Enabled() {
+		scc.Info("Sampling input set", "limit", scc.sampler.limit, "sample", scc.sampler.fraction, "sampleSize", scc.sampler.size, "seed", scc.sampler.seed)
+	}
+	sampleStream, errStream = scc.sampler.Stream(scc.Context(), cancel, sampleStream, errStream)
+	return sampleStream, errStream, nil
+}
+
+func (scc *setCmdConfig) inputStream(ctx context.Context, features []feature.Feature, criteria []feature.Criterion) (<-chan set.Sample, <-chan error, error) {
+	if opener.Scheme(scc.setInput) != opener.FileScheme {
+		s, err := opener.Open(scc.Context(), scc.setInput, features, &opener.Options{Name: "input set"})
+		if err != nil {
+			return nil, nil, err
+		}
+		return readSubset(ctx, s, criteria)
+	}
 	var f *os.File
 	if scc.setInput == "" {
-		scc.Logf("Reading input set from STDIN and dumping it into output set...")
+		scc.Info("Reading input set from STDIN and dumping it into output set")
 		f = os.Stdin
 	} else {
-		if strings.HasPrefix(scc.setInput, "postgresql://") {
-			return scc.PostgreSQLInputStream(features)
-		}
-		if strings.HasSuffix(scc.setInput, ".db") {
-			return scc.Sqlite3InputStream(features)
-		}
-		scc.Logf("Opening %s to read input set...", scc.setInput)
+		scc.Info("Opening file to read input set", "path", scc.setInput)
 		var err error
 		f, err = os.Open(scc.setInput)
 		if err != nil {
 			err = fmt.Errorf("reading input set from %s: %v", scc.setInput, err)
 			return nil, nil, err
 		}
-		scc.Logf("Dumping input set into output set...")
+		scc.Info("Dumping input set into output set")
 	}
 	sampleStream := make(chan set.Sample)
 	errStream := make(chan error)
 	go func() {
 		defer f.Close()
-		err := csv.ReadSetBySample(f, features, func(i int, s set.Sample) (bool, error) {
+		err := scc.readSetBySample(f, scc.setInput, features, func(i int, s set.Sample) (bool, error) {
+			for _, c := range criteria {
+				ok, err := c.SatisfiedBy(s)
+				if err != nil || !ok {
+					return err == nil, err
+				}
+			}
 			select {
-			case <-scc.Context().Done():
+			case <-ctx.Done():
 				return false, nil
 			case sampleStream <- s:
 			}
@@ -183,62 +385,91 @@ func (scc *setCmdConfig) InputStream(features []feature.Feature) (<-chan set.Sam
 	return sampleStream, errStream, nil
 }
 
-func (scc *setCmdConfig) Sqlite3InputStream(features []feature.Feature) (<-chan set.Sample, <-chan error, error) {
-	scc.Logf("Creating SQLite3 adapter for file %s to read input set...", scc.setInput)
-	adapter, err := sqlite3adapter.New(scc.setInput, 0)
-	if err != nil {
-		return nil, nil, err
+/*
+readSubset takes a context, a set on a database and a slice of criteria and
+returns the streams of the samples of the set that satisfy the criteria,
+which are run by the database, and of errors reading them, or an error if
+the criteria cannot be run on the set or its subset cannot be read as a
+stream.
+*/
+func readSubset(ctx context.Context, s set.Set, criteria []feature.Criterion) (<-chan set.Sample, <-chan error, error) {
+	for _, c := range criteria {
+		var err error
+		s, err = s.SubsetWith(ctx, c)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	scc.Logf("Opening set over SQLite3 adapter for file %s to read input set...", scc.setInput)
-	set, err := sqlset.Open(scc.Context(), adapter, features)
-	if err != nil {
-		return nil, nil, err
+	r, ok := s.(set.Reader)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot read subset of type %T", s)
 	}
-	sampleStream, errStream := set.Read(scc.Context())
+	sampleStream, errStream := r.Read(ctx)
 	return sampleStream, errStream, nil
 }
 
-func (scc *setCmdConfig) PostgreSQLInputStream(features []feature.Feature) (<-chan set.Sample, <-chan error, error) {
-	scc.Logf("Creating PostgreSQL adapter for url %s to read input set...", scc.setInput)
-	adapter, err := pgadapter.New(scc.setInput)
+func (scc *setCmdConfig) Sqlite3OutputWriter(features []feature.Feature) (writableSet, error) {
+	scc.Info("Creating SQLite3 adapter to dump output set", "path", scc.setOutput)
+	adapter, err := newSQLite3Adapter(scc.setOutput, scc.sqlite3OutputMaxConn())
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	scc.Logf("Opening set over PostgreSQL adapter for url %s to read input set...", scc.setInput)
-	set, err := sqlset.Open(scc.Context(), adapter, features)
+	scc.Info("Opening set over SQLite3 adapter to dump output set", "path", scc.setOutput)
+	set, err := scc.createOutputSet(adapter, features)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	sampleStream, errStream := set.Read(scc.Context())
-	return sampleStream, errStream, nil
+	return sqlset.NewBufferedWriter(scc.Context(), set, scc.batchSize, scc.flushInterval), nil
 }
 
-func (scc *setCmdConfig) Sqlite3OutputWriter(features []feature.Feature) (writableSet, error) {
-	scc.Logf("Creating SQLite3 adapter for file %s to dump output set...", scc.setOutput)
-	adapter, err := sqlite3adapter.New(scc.setOutput, 0)
+func (scc *setCmdConfig) PostgreSQLOutputWriter(features []feature.Feature) (writableSet, error) {
+	scc.Info("Creating PostgreSQL adapter to dump output set", "url", scc.setOutput)
+	adapter, err := newPostgreSQLAdapter(scc.setOutput, &pgadapter.Options{CopyWrites: true})
 	if err != nil {
 		return nil, err
 	}
-	scc.Logf("Opening set over SQLite3 adapter for file %s to dump output set...", scc.setOutput)
-	set, err := sqlset.Create(scc.Context(), adapter, features)
+	scc.Info("Opening set over PostgreSQL adapter to dump output set", "url", scc.setOutput)
+	set, err := scc.createOutputSet(adapter, features)
 	if err != nil {
 		return nil, err
 	}
-	return &flushableSampleWriter{set}, nil
+	return sqlset.NewBufferedWriter(scc.Context(), set, scc.batchSize, scc.flushInterval), nil
 }
 
-func (scc *setCmdConfig) PostgreSQLOutputWriter(features []feature.Feature) (writableSet, error) {
-	scc.Logf("Creating PostgreSQL adapter for url %s to dump output set...", scc.setOutput)
-	adapter, err := pgadapter.New(scc.setOutput)
+func (scc *setCmdConfig) CassandraOutputWriter(features []feature.Feature) (writableSet, error) {
+	scc.Info("Creating Cassandra adapter to dump output set", "url", scc.setOutput)
+	adapter, err := newCassandraAdapter(scc.setOutput)
 	if err != nil {
 		return nil, err
 	}
-	scc.Logf("Opening set over PostgreSQL adapter for url %s to dump output set...", scc.setOutput)
-	set, err := sqlset.Create(scc.Context(), adapter, features)
+	scc.Info("Opening set over Cassandra adapter to dump output set", "url", scc.setOutput)
+	set, err := scc.createOutputSet(adapter, features)
 	if err != nil {
 		return nil, err
 	}
-	return &flushableSampleWriter{set}, nil
+	return sqlset.NewBufferedWriter(scc.Context(), set, scc.batchSize, scc.flushInterval), nil
+}
+
+/*
+createOutputSet takes an adapter and a slice of features and returns a set
+created over the adapter for the features or an error. When resuming an
+import, the set is opened instead, keeping the samples already imported.
+The set is kept on the config so that its feature columns can be indexed
+once every sample has been written, if the index flag was set.
+*/
+func (scc *setCmdConfig) createOutputSet(adapter sqlset.Adapter, features []feature.Feature) (sqlset.Set, error) {
+	var s sqlset.Set
+	var err error
+	if scc.checkpoint.Resuming() {
+		s, err = sqlset.Open(scc.Context(), adapter, features)
+	} else {
+		s, err = sqlset.Create(scc.Context(), adapter, features)
+	}
+	if err != nil {
+		return nil, err
+	}
+	scc.sqlOutput = s
+	return s, nil
 }
 
 func (scc *setCmdConfig) Context() context.Context {
@@ -253,10 +484,261 @@ func (scc *setCmdConfig) ContextCancelFunc() context.CancelFunc {
 
 func (scc *setCmdConfig) setContextAndCancelFunc() {
 	if scc.ctx == nil {
-		scc.ctx, scc.cancelFunc = context.WithCancel(context.Background())
+		scc.ctx, scc.cancelFunc = scc.rootContext()
+	}
+}
+
+/*
+isSQLite3 takes the path to a set and returns whether the set is on an
+SQLite3 database, that is whether the path ends in .db, not counting the
+tables parameter taken by splitTablePrefix.
+*/
+func isSQLite3(path string) bool {
+	path, _ = splitTablePrefix(path)
+	return strings.HasSuffix(path, ".db")
+}
+
+/*
+splitTablePrefix takes the path to an SQLite3 database or the URL of a
+PostgreSQL database and returns it without its tables query parameter, if
+any, and the value of the parameter, which is the prefix of the tables of
+the set on the database, as in postgresql://host/db?tables=mydataset or
+data.db?tables=mydataset.
+*/
+func splitTablePrefix(location string) (string, string) {
+	i := strings.Index(location, "?")
+	if i < 0 {
+		return location, ""
+	}
+	query, err := url.ParseQuery(location[i+1:])
+	if err != nil {
+		return location, ""
+	}
+	prefix := query.Get("tables")
+	if _, ok := query["tables"]; !ok {
+		return location, ""
+	}
+	query.Del("tables")
+	location = location[:i]
+	if q := query.Encode(); q != "" {
+		location = location + "?" + q
+	}
+	return location, prefix
+}
+
+/*
+newSQLite3Adapter takes the path to an SQLite3 database, with an optional
+tables parameter, and a maximum number of connections and returns an
+adapter for the set on the database or an error.
+*/
+func newSQLite3Adapter(path string, maxConn int) (sqlset.Adapter, error) {
+	return newSQLite3AdapterWithOptions(path, &sqlite3adapter.Options{MaxConn: maxConn})
+}
+
+/*
+newSQLite3AdapterWithOptions takes the path to an SQLite3 database, with an
+optional tables parameter, and options and returns an adapter for the set on
+the database or an error.
+*/
+func newSQLite3AdapterWithOptions(path string, opts *sqlite3adapter.Options) (sqlset.Adapter, error) {
+	path, prefix := splitTablePrefix(path)
+	opts.TablePrefix = prefix
+	return sqlite3adapter.NewWithOptions(path, opts)
+}
+
+/*
+newPostgreSQLAdapter takes the URL of a PostgreSQL database, with an
+optional tables parameter, and options and returns an adapter for the set
+on the database or an error. The adapter retries read operations when the
+connection to the database is lost.
+*/
+func newPostgreSQLAdapter(url string, opts *pgadapter.Options) (sqlset.Adapter, error) {
+	url, prefix := splitTablePrefix(url)
+	if opts == nil {
+		opts = &pgadapter.Options{}
+	}
+	opts.TablePrefix = prefix
+	adapter, err := pgadapter.NewWithOptions(url, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sqlset.NewRetryingAdapter(adapter, sqlset.DefaultRetryAttempts, sqlset.DefaultRetryBackoff), nil
+}
+
+/*
+newCassandraAdapter takes the URL of a keyspace on a Cassandra or ScyllaDB
+cluster, with optional tables and partition-key parameters, and returns an
+adapter for the set on the keyspace or an error. The partition-key parameter
+is the name of a discrete feature by which the samples of the set are
+partitioned, as in cassandra://host/keyspace?partition-key=country.
+*/
+func newCassandraAdapter(location string) (sqlset.Adapter, error) {
+	location, prefix := splitTablePrefix(location)
+	opts := &cqladapter.Options{TablePrefix: prefix}
+	if i := strings.Index(location, "?"); i >= 0 {
+		query, err := url.ParseQuery(location[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("parsing parameters of %s: %v", location, err)
+		}
+		opts.PartitionKey = query.Get("partition-key")
+		query.Del("partition-key")
+		if len(query) > 0 {
+			return nil, fmt.Errorf("unsupported parameters on %s: only tables and partition-key are allowed", location)
+		}
+		location = location[:i]
+	}
+	return cqladapter.NewWithOptions(location, opts)
+}
+
+/*
+isJSONLines takes the path to a set file and returns whether the set is in
+JSON Lines format, that is whether the path ends in .jsonl or .ndjson, not
+counting a compression extension. Otherwise the set is in CSV format.
+*/
+func isJSONLines(path string) bool {
+	path = uncompressedPath(path)
+	return strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".ndjson")
+}
+
+/*
+readSetBySample takes a reader for a set, the path of the set, a slice of
+features and a lambda function and calls the ReadSetBySample function of the
+jsonl package with them if the path is that of a JSON Lines file, or the
+ReadSetBySampleWithOptions function of the csv package with the configured
+CSV options otherwise. Gzip compressed sets are decompressed as they are
+read. Invalid rows are skipped and handed to the configured rejects, if any.
+*/
+func (rcc *rootCmdConfig) readSetBySample(r io.Reader, path string, features []feature.Feature, lambda func(int, set.Sample) (bool, error)) error {
+	r, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	rejects, err := rcc.rowRejects()
+	if err != nil {
+		return err
+	}
+	if rejects != nil {
+		defer rcc.reportRejects(rejects.Count())
+	}
+	if isJSONLines(path) {
+		if rejects != nil {
+			return jsonl.ReadSetBySampleWithRejects(r, features, rejects, lambda)
+		}
+		return jsonl.ReadSetBySample(r, features, lambda)
+	}
+	opts, err := rcc.csvOptions()
+	if err != nil {
+		return err
+	}
+	opts.Rejects = rejects
+	features, err = rcc.csvFeatures(features)
+	if err != nil {
+		return err
 	}
+	return csv.ReadSetBySampleWithOptions(r, features, opts, lambda)
 }
 
-func (fsw *flushableSampleWriter) Flush() error {
-	return nil
+/*
+readSetByRow takes a reader for a set file, the path of the set, a slice of
+features and a lambda function and calls the ReadRows function of the jsonl
+package with them if the path is that of a JSON Lines file, or the
+ReadRowsWithOptions function of the csv package with the configured CSV
+options otherwise, so that the lambda is called with every row, valid or
+not. Gzip compressed sets are decompressed as they are read.
+*/
+func (rcc *rootCmdConfig) readSetByRow(r io.Reader, path string, features []feature.Feature, lambda func(int, set.Sample, error) (bool, error)) error {
+	r, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	if isJSONLines(path) {
+		return jsonl.ReadRows(r, features, lambda)
+	}
+	opts, err := rcc.csvOptions()
+	if err != nil {
+		return err
+	}
+	features, err = rcc.csvFeatures(features)
+	if err != nil {
+		return err
+	}
+	return csv.ReadRowsWithOptions(r, features, opts, lambda)
+}
+
+/*
+readSet takes a reader for a set, the path of the set, a slice of features
+and a set generator and returns the set read with the ReadSet function of the
+jsonl package if the path is that of a JSON Lines file, or the
+ReadSetWithOptions function of the csv package with the configured CSV
+options otherwise. Gzip compressed sets are decompressed as they are read.
+Invalid rows are skipped and handed to the configured rejects, if any.
+*/
+func (rcc *rootCmdConfig) readSet(r io.Reader, path string, features []feature.Feature, sg csv.SetGenerator) (set.Set, error) {
+	r, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	rejects, err := rcc.rowRejects()
+	if err != nil {
+		return nil, err
+	}
+	if rejects != nil {
+		defer rcc.reportRejects(rejects.Count())
+	}
+	if isJSONLines(path) {
+		if rejects == nil {
+			return jsonl.ReadSet(r, features, sg)
+		}
+		samples := []set.Sample{}
+		err = jsonl.ReadSetBySampleWithRejects(r, features, rejects, func(_ int, s set.Sample) (bool, error) {
+			samples = append(samples, s)
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return sg(samples), nil
+	}
+	opts, err := rcc.csvOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.Rejects = rejects
+	features, err = rcc.csvFeatures(features)
+	if err != nil {
+		return nil, err
+	}
+	return csv.ReadSetWithOptions(r, features, sg, opts)
+}
+
+/*
+newSetWriter takes a writer, the path of the set to write and a slice of
+features and returns a writer of samples in JSON Lines format if the path
+is that of a JSON Lines file, or in CSV format with the configured CSV
+options otherwise. Sets are compressed with gzip if the path ends in .gz.
+*/
+func (rcc *rootCmdConfig) newSetWriter(w io.Writer, path string, features []feature.Feature) (csv.Writer, error) {
+	if strings.HasSuffix(path, ".zst") {
+		return nil, fmt.Errorf("zstd compressed sets are not supported, write them with a .gz extension to compress them with gzip")
+	}
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(w)
+		sw, err := rcc.newSetWriter(gz, uncompressedPath(path), features)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipSetWriter{Writer: sw, gz: gz}, nil
+	}
+	if isJSONLines(path) {
+		return jsonl.NewWriter(w, features)
+	}
+	opts, err := rcc.csvOptions()
+	if err != nil {
+		return nil, err
+	}
+	features, err = rcc.csvFeatures(features)
+	if err != nil {
+		return nil, err
+	}
+	return csv.NewWriterWithOptions(w, features, opts)
 }
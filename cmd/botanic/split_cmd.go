@@ -2,18 +2,17 @@ package main
 
 import (
 	"fmt"
+	"hash/fnv"
 	"math/rand"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pbanos/botanic/feature"
-	"github.com/pbanos/botanic/feature/yaml"
 	"github.com/pbanos/botanic/set"
-	"github.com/pbanos/botanic/set/csv"
 	"github.com/pbanos/botanic/set/sqlset"
 	"github.com/pbanos/botanic/set/sqlset/pgadapter"
-	"github.com/pbanos/botanic/set/sqlset/sqlite3adapter"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +20,63 @@ type splitCmdConfig struct {
 	*setCmdConfig
 	splitOutput      string
 	splitProbability int
+	seed             int64
+	stratifyBy       string
+	hashKey          string
+}
+
+/*
+splitter decides to which set the samples of a stream are assigned. Its
+Split method takes a sample and returns whether it is assigned to the split
+set, or an error if it cannot be decided.
+*/
+type splitter interface {
+	Split(set.Sample) (bool, error)
+}
+
+/*
+randomSplitter assigns every sample to the split set with the split
+probability, independently of the rest.
+*/
+type randomSplitter struct {
+	r       *rand.Rand
+	percent int
+}
+
+/*
+hashSplitter assigns the samples of a stream to the split set by a hash of
+their value for a key feature, so that the samples with the same key, such
+as those of the same user, are always assigned to the same set, on every
+run and on every input set split with the same key feature and split
+probability. Samples with no value for the key feature are assigned to the
+output set.
+*/
+type hashSplitter struct {
+	feature feature.Feature
+	percent int
+}
+
+/*
+stratifier assigns the samples of a stream to the split set so that the
+fraction of the samples with every value of a feature that end up on it
+stays as close as possible to the split probability, instead of drifting
+with the random draws as it does for values with few samples. Samples with
+no value for the feature are handled as if that was another value.
+*/
+type stratifier struct {
+	feature     feature.Feature
+	probability float64
+	r           *rand.Rand
+	strata      map[interface{}]*stratum
+}
+
+/*
+stratum holds the number of samples with a value of the feature of a
+stratifier seen so far, and how many of them were assigned to the split set.
+*/
+type stratum struct {
+	count int
+	split int
 }
 
 func splitCmd(setConfig *setCmdConfig) *cobra.Command {
@@ -33,106 +89,169 @@ func splitCmd(setConfig *setCmdConfig) *cobra.Command {
 			err := setConfig.Validate()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				exit(1)
 			}
 			err = config.Validate()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				exit(1)
 			}
 			config.Context()
-			config.Logf("Reading features from metadata at %s...", setConfig.metadataInput)
-			features, err := yaml.ReadFeaturesFromFile(setConfig.metadataInput)
+			config.Info("Reading features from metadata", "path", setConfig.metadataInput)
+			features, err := readFeaturesFromFile(setConfig.metadataInput)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
+				exit(2)
+			}
+			config.Info("Features from metadata read")
+			sp, err := config.splitter(features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+
+			outputFeatures, err := config.OutputFeatures(features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
 			}
-			config.Logf("Features from metadata read")
 
-			output, err := config.OutputWriter(features)
+			output, err := config.OutputWriter(outputFeatures)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(4)
+				exit(4)
 			}
 
-			splitOutput, err := config.SplitOutputWriter(features)
+			splitOutput, err := config.SplitOutputWriter(outputFeatures)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(6)
+				exit(6)
 			}
 
 			inputStream, errStream, err := setConfig.InputStream(features)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(7)
+				exit(7)
 			}
 
-			randomizer := rand.New(rand.NewSource(time.Now().UnixNano()))
+			outputStream, splitStream, splitErrStream := config.splitStream(sp, inputStream)
+			pl := config.Pipeline()
 			var outputCount, splitCount int
-			for s := range inputStream {
-				var n int
-				if (100 * randomizer.Float32()) > float32(config.splitProbability) {
-					n, err = output.Write(config.Context(), []set.Sample{s})
-					outputCount += n
-				} else {
-					n, err = splitOutput.Write(config.Context(), []set.Sample{s})
-					splitCount += n
+			var outputErr, splitErr error
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				outputCount, outputErr = pl.Run(config.Context(), outputStream, config.OutputSample, output)
+				if outputErr != nil {
+					config.ContextCancelFunc()
 				}
-				if err != nil {
+			}()
+			go func() {
+				defer wg.Done()
+				splitCount, splitErr = pl.Run(config.Context(), splitStream, config.OutputSample, splitOutput)
+				if splitErr != nil {
 					config.ContextCancelFunc()
-					break
 				}
+			}()
+			wg.Wait()
+			err = <-splitErrStream
+			if err == nil {
+				err = outputErr
+			}
+			if err == nil {
+				err = splitErr
 			}
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(8)
+				exit(8)
 			}
 			err = <-errStream
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(9)
+				exit(9)
 			}
 
-			config.Logf("Flushing output set...")
+			config.Info("Flushing output set")
 			err = output.Flush()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(10)
+				exit(10)
 			}
-			config.Logf("Flushing split set...")
+			config.Info("Flushing split set")
 			err = splitOutput.Flush()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(11)
+				exit(11)
 			}
-			config.Logf("Done")
-			config.Logf("Input set with %d samples was split into sets with %d and %d samples", outputCount+splitCount, outputCount, splitCount)
+			config.Info("Done")
+			config.Info("Input set split", "samples", outputCount+splitCount, "outputSamples", outputCount, "splitSamples", splitCount)
 		},
 	}
 	cmd.PersistentFlags().IntVarP(&(config.splitProbability), "split-probability", "p", 20, "probability as percent integer that a sample of the set will be assigned to the split set")
-	cmd.PersistentFlags().StringVarP(&(config.splitOutput), "split-output", "s", "", "path to a CSV (.csv) or SQLite3 (.db) file, or a PostgreSQL DB connection URL to dump the output of the split set (required)")
+	cmd.PersistentFlags().Int64Var(&(config.seed), "seed", 0, "seed of the random assignment of samples to the split set, so that splitting the same input set with the same seed yields the same sets (defaults to 0, a seed taken from the current time)")
+	cmd.PersistentFlags().StringVar(&(config.hashKey), "hash-key", "", "name of a feature, such as an ID of the users or entities the samples belong to, by whose value samples are assigned to the split set with a hash instead of at random, so that the samples with the same value always end up on the same set on every run and input set (defaults to none, a random assignment)")
+	cmd.PersistentFlags().StringVar(&(config.stratifyBy), "stratify-by", "", "name of a discrete or boolean feature, such as the class feature of the trees to grow, whose values keep the same proportions on the output and split sets as on the input set, assigning the samples with every value to the split set with the split probability (defaults to none, a purely random assignment)")
+	cmd.PersistentFlags().StringVarP(&(config.splitOutput), "split-output", "s", "", "path to a CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL to dump the output of the split set (required)")
 	return cmd
 }
 
+/*
+splitStream takes a splitter and a stream of samples and returns the streams
+of the samples assigned to the output set and to the split set by the
+splitter, which splits them in the order of the stream, and a stream with
+the error splitting them, if any. The streams of samples are closed once
+the stream is read, the context of the command is done or a sample cannot
+be split, in which case the context is cancelled.
+*/
+func (scc *splitCmdConfig) splitStream(sp splitter, samples <-chan set.Sample) (<-chan set.Sample, <-chan set.Sample, <-chan error) {
+	outputStream := make(chan set.Sample)
+	splitStream := make(chan set.Sample)
+	errStream := make(chan error, 1)
+	go func() {
+		defer close(errStream)
+		defer close(splitStream)
+		defer close(outputStream)
+		for s := range samples {
+			toSplit, err := sp.Split(s)
+			if err != nil {
+				scc.ContextCancelFunc()
+				errStream <- err
+				return
+			}
+			stream := outputStream
+			if toSplit {
+				stream = splitStream
+			}
+			select {
+			case <-scc.Context().Done():
+				return
+			case stream <- s:
+			}
+		}
+	}()
+	return outputStream, splitStream, errStream
+}
+
 func (scc *splitCmdConfig) SplitOutputWriter(features []feature.Feature) (writableSet, error) {
 	var splitOutputFile *os.File
 	if strings.HasPrefix(scc.splitOutput, "postgresql://") {
 		return scc.PostgreSQLSplitOutputWriter(features)
 	}
-	if strings.HasSuffix(scc.splitOutput, ".db") {
+	if isSQLite3(scc.splitOutput) {
 		return scc.Sqlite3SplitOutputWriter(features)
 	}
-	scc.Logf("Creating %s to dump split set...", scc.splitOutput)
+	scc.Info("Creating file to dump split set", "path", scc.splitOutput)
 	splitOutputFile, err := os.Create(scc.splitOutput)
 	if err != nil {
 		return nil, err
 	}
-	scc.Logf("Preparing to write split output set...")
-	splitOutput, err := csv.NewWriter(splitOutputFile, features)
+	scc.Info("Preparing to write split output set")
+	splitOutput, err := scc.newSetWriter(splitOutputFile, scc.splitOutput, features)
 	if err != nil {
 		return nil, err
 	}
-	return splitOutput, nil
+	return &lockedWriter{writableSet: splitOutput}, nil
 }
 
 func (scc *splitCmdConfig) Validate() error {
@@ -142,33 +261,132 @@ func (scc *splitCmdConfig) Validate() error {
 	if scc.splitProbability <= 0 || scc.splitProbability > 100 {
 		return fmt.Errorf("split-percent flag was set to an invalid value: it must be set to an integer between 1 and 100")
 	}
+	if scc.hashKey != "" && scc.stratifyBy != "" {
+		return fmt.Errorf("cannot set both hash-key and stratify-by flags at the same time")
+	}
 	return nil
 }
 
+/*
+splitter takes a slice of features and returns the splitter that assigns
+the samples to the split set as configured with the flags: by a hash of
+their value for the hash-key feature, stratified by the values of the
+stratify-by feature or at random. It returns an error if the given features
+cannot be used.
+*/
+func (scc *splitCmdConfig) splitter(features []feature.Feature) (splitter, error) {
+	if scc.hashKey != "" {
+		for _, f := range features {
+			if f.Name() == scc.hashKey {
+				scc.Info("Splitting samples by hash", "feature", scc.hashKey)
+				return &hashSplitter{f, scc.splitProbability}, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot split by hash of unknown feature %s", scc.hashKey)
+	}
+	seed := scc.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	scc.Info("Splitting samples", "seed", seed)
+	randomizer := rand.New(rand.NewSource(seed))
+	if scc.stratifyBy != "" {
+		scc.Info("Stratifying split", "feature", scc.stratifyBy)
+		return newStratifier(features, scc.stratifyBy, float64(scc.splitProbability)/100, randomizer)
+	}
+	return &randomSplitter{randomizer, scc.splitProbability}, nil
+}
+
+func (rs *randomSplitter) Split(s set.Sample) (bool, error) {
+	return (100 * rs.r.Float32()) <= float32(rs.percent), nil
+}
+
+/*
+Split takes a sample and returns whether it is assigned to the split set,
+which happens when the FNV-1a hash of its value for the key feature, as
+formatted by fmt.Sprint, modulo 100 is below the split probability as a
+percent. It returns an error if the value of the sample cannot be obtained.
+*/
+func (hs *hashSplitter) Split(s set.Sample) (bool, error) {
+	v, err := s.ValueFor(hs.feature)
+	if err != nil || v == nil {
+		return false, err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprint(v)))
+	return h.Sum64()%100 < uint64(hs.percent), nil
+}
+
+/*
+newStratifier takes a slice of features, the name of the feature to stratify
+by, the split probability and a source of random numbers and returns a
+stratifier for the feature, or an error if it is not among the features or
+is not a discrete or boolean feature.
+*/
+func newStratifier(features []feature.Feature, name string, probability float64, r *rand.Rand) (*stratifier, error) {
+	for _, f := range features {
+		if f.Name() != name {
+			continue
+		}
+		switch f.(type) {
+		case *feature.DiscreteFeature, *feature.BooleanFeature:
+			return &stratifier{f, probability, r, make(map[interface{}]*stratum)}, nil
+		}
+		return nil, fmt.Errorf("cannot stratify by feature %s: only discrete and boolean features are allowed", name)
+	}
+	return nil, fmt.Errorf("cannot stratify by unknown feature %s", name)
+}
+
+/*
+Split takes a sample and returns whether it is assigned to the split set, or
+an error if its value for the feature cannot be obtained. The sample is assigned with a probability equal
+to the number of samples with its value that the split set lacks to hold the
+split probability of them, once the sample is counted, so that the number
+of samples with any value on the split set always differs by less than one
+from the split probability of those read.
+*/
+func (st *stratifier) Split(s set.Sample) (bool, error) {
+	v, err := s.ValueFor(st.feature)
+	if err != nil {
+		return false, err
+	}
+	stm, ok := st.strata[v]
+	if !ok {
+		stm = &stratum{}
+		st.strata[v] = stm
+	}
+	stm.count++
+	if st.r.Float64() < st.probability*float64(stm.count)-float64(stm.split) {
+		stm.split++
+		return true, nil
+	}
+	return false, nil
+}
+
 func (scc *splitCmdConfig) Sqlite3SplitOutputWriter(features []feature.Feature) (writableSet, error) {
-	scc.Logf("Creating SQLite3 adapter for file %s to dump split set...", scc.splitOutput)
-	adapter, err := sqlite3adapter.New(scc.splitOutput, 0)
+	scc.Info("Creating SQLite3 adapter to dump split set", "path", scc.splitOutput)
+	adapter, err := newSQLite3Adapter(scc.splitOutput, scc.sqlite3OutputMaxConn())
 	if err != nil {
 		return nil, err
 	}
-	scc.Logf("Opening set over SQLite3 adapter for file %s to dump split set...", scc.splitOutput)
-	set, err := sqlset.Create(scc.Context(), adapter, features)
+	scc.Info("Opening set over SQLite3 adapter to dump split set", "path", scc.splitOutput)
+	set, err := scc.createOutputSet(adapter, features)
 	if err != nil {
 		return nil, err
 	}
-	return &flushableSampleWriter{set}, nil
+	return sqlset.NewBufferedWriter(scc.Context(), set, scc.batchSize, scc.flushInterval), nil
 }
 
 func (scc *splitCmdConfig) PostgreSQLSplitOutputWriter(features []feature.Feature) (writableSet, error) {
-	scc.Logf("Creating PostgreSQL adapter for url %s to dump split set...", scc.splitOutput)
-	adapter, err := pgadapter.New(scc.splitOutput)
+	scc.Info("Creating PostgreSQL adapter to dump split set", "url", scc.splitOutput)
+	adapter, err := newPostgreSQLAdapter(scc.splitOutput, &pgadapter.Options{CopyWrites: true})
 	if err != nil {
 		return nil, err
 	}
-	scc.Logf("Opening set over PostgreSQL adapter for url %s to dump split set...", scc.splitOutput)
-	set, err := sqlset.Create(scc.Context(), adapter, features)
+	scc.Info("Opening set over PostgreSQL adapter to dump split set", "url", scc.splitOutput)
+	set, err := scc.createOutputSet(adapter, features)
 	if err != nil {
 		return nil, err
 	}
-	return &flushableSampleWriter{set}, nil
+	return sqlset.NewBufferedWriter(scc.Context(), set, scc.batchSize, scc.flushInterval), nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pbanos/botanic/tree"
+	"github.com/spf13/cobra"
+)
+
+type statsCmdConfig struct {
+	*treeCmdConfig
+	format string
+}
+
+func statsCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &statsCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Report statistics on the structure of a tree",
+		Long:  `Report the number of nodes and leaves of a tree, its depth, the number of leaves at every depth and by size, the average weight of its leaves and the number of splits on every feature`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			t, err := loadTree(config.Context(), config.treeInput, features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			stats, err := t.Stats(config.Context())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "computing tree stats: %v\n", err)
+				exit(4)
+			}
+			if config.format == "json" {
+				err = json.NewEncoder(os.Stdout).Encode(stats)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exit(5)
+				}
+				return
+			}
+			printStats(stats)
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().StringVar(&(config.format), "format", "text", "format of the statistics written to STDOUT, the following are valid: text, json")
+	return cmd
+}
+
+func (scc *statsCmdConfig) Validate() error {
+	if scc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if scc.treeInput == "" {
+		return fmt.Errorf("required tree flag was not set")
+	}
+	if scc.format != "text" && scc.format != "json" {
+		return fmt.Errorf("unknown format %s, the following are valid: text, json", scc.format)
+	}
+	return nil
+}
+
+func printStats(s *tree.Stats) {
+	fmt.Printf("%d nodes, %d leaves, depth %d\n", s.Nodes, s.Leaves, s.Depth)
+	fmt.Printf("%f average leaf weight\n", s.AverageLeafWeight)
+	fmt.Println("leaves by depth:")
+	for d, n := range s.LeavesByDepth {
+		if n > 0 {
+			fmt.Printf("  %d: %d\n", d, n)
+		}
+	}
+	fmt.Println("leaves by weight:")
+	for i, n := range s.LeafSizes {
+		if n == 0 {
+			continue
+		}
+		if i == 0 {
+			fmt.Printf("  [0, 2): %d\n", n)
+			continue
+		}
+		fmt.Printf("  [%d, %d): %d\n", 1<<uint(i), 1<<uint(i+1), n)
+	}
+	fmt.Println("splits by feature:")
+	for _, name := range s.SplitFeatures() {
+		fmt.Printf("  %s: %d\n", name, s.Splits[name])
+	}
+	if s.Metadata != nil {
+		printMetadata(s.Metadata)
+	}
+}
+
+/*
+printMetadata takes the metadata of a tree and writes its known fields to
+STDOUT.
+*/
+func printMetadata(m *tree.Metadata) {
+	fmt.Println("provenance:")
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Printf("  %s: %s\n", name, value)
+		}
+	}
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	field("name", m.Name)
+	field("version", m.Version)
+	field("dataset", m.Dataset)
+	if m.Samples > 0 {
+		field("samples", strconv.Itoa(m.Samples))
+	}
+	field("pruning", m.Pruning)
+	field("post-pruning", m.PostPruning)
+	field("smoothing", m.Smoothing)
+	field("features hash", m.FeaturesHash)
+	field("grow started", timestamp(m.GrowStarted))
+	field("grow finished", timestamp(m.GrowFinished))
+	field("pruned", timestamp(m.Pruned))
+	if len(m.GrowSettings) > 0 {
+		names := make([]string, 0, len(m.GrowSettings))
+		for name := range m.GrowSettings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("  grow settings:")
+		for _, name := range names {
+			fmt.Printf("    %s: %s\n", name, m.GrowSettings[name])
+		}
+	}
+}
@@ -1,24 +1,30 @@
 package main
 
 import (
-	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/pbanos/botanic/feature"
-	"github.com/pbanos/botanic/feature/yaml"
 	"github.com/pbanos/botanic/set"
-	"github.com/pbanos/botanic/set/csv"
-	"github.com/pbanos/botanic/set/sqlset"
-	"github.com/pbanos/botanic/set/sqlset/pgadapter"
-	"github.com/pbanos/botanic/set/sqlset/sqlite3adapter"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/pbanos/botanic/tree"
+	"github.com/pbanos/botanic/webhook"
 	"github.com/spf13/cobra"
 )
 
 type testCmdConfig struct {
 	*treeCmdConfig
-	dataInput string
+	dataInput   string
+	confidenceZ float64
+	leaves      bool
+	boosted     bool
+	concurrency int
+	curves      string
+	curvesOut   string
 }
 
 func testCmd(treeConfig *treeCmdConfig) *cobra.Command {
@@ -30,43 +36,85 @@ func testCmd(treeConfig *treeCmdConfig) *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			err := config.Validate()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				config.Fail(1, "test", err)
 			}
 			config.Context()
-			features, err := yaml.ReadFeaturesFromFile(config.metadataInput)
+			features, err := readFeaturesFromFile(config.metadataInput)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
+				config.Fail(2, "test", err)
 			}
 
 			testingSet, err := config.testingSet(features)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(4)
-			}
-			tree, err := loadTree(context.Background(), config.treeInput, features)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(4)
+				config.Fail(4, "test", err)
 			}
 			count, err := testingSet.Count(config.Context())
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "counting testing set samples: %v\n", err)
-				os.Exit(5)
+				config.Fail(5, "test", fmt.Errorf("counting testing set samples: %v", err))
+			}
+			var ev *tree.Evaluation
+			if config.boosted {
+				var e *tree.Ensemble
+				e, err = loadEnsemble(config.Context(), config.treeInput, features)
+				if err != nil {
+					config.Fail(4, "test", err)
+				}
+				config.Info("Testing ensemble against testset", "trees", len(e.Trees), "samples", count)
+				ev, err = e.EvaluateConcurrently(config.Context(), testingSet, config.confidenceZ, config.concurrency)
+			} else {
+				var t *tree.Tree
+				t, err = loadTree(config.Context(), config.treeInput, features)
+				if err != nil {
+					config.Fail(4, "test", err)
+				}
+				config.Info("Testing tree against testset", "samples", count)
+				ev, err = t.EvaluateConcurrently(config.Context(), testingSet, config.confidenceZ, config.concurrency)
 			}
-			config.Logf("Testing tree against testset with %d samples...", count)
-			successRate, errorCount, err := tree.Test(config.Context(), testingSet)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "testing tree: %v\n", err)
-				os.Exit(6)
+				config.Fail(6, "test", fmt.Errorf("testing tree: %v", err))
+			}
+			config.Info("Done")
+			config.Notify(webhook.EventAccuracyComputed, map[string]interface{}{
+				"tree":        config.treeInput,
+				"input":       config.dataInput,
+				"samples":     ev.Samples,
+				"successes":   ev.Successes,
+				"unpredicted": ev.Unpredicted,
+				"successRate": ev.SuccessRate,
+				"lowerBound":  ev.LowerBound,
+				"upperBound":  ev.UpperBound,
+			})
+			config.CloseNotifier()
+			fmt.Printf("%f success rate, failed to make a prediction for %d samples\n", ev.SuccessRate, ev.Unpredicted)
+			fmt.Printf("%d/%d samples predicted correctly, confidence interval [%f, %f]\n", ev.Successes, ev.Samples, ev.LowerBound, ev.UpperBound)
+			if config.leaves {
+				for _, le := range ev.Leaves {
+					fmt.Printf("leaf %s (support %d): %f success rate, %d/%d samples predicted correctly, confidence interval [%f, %f]\n", le.NodeID, le.Support, le.SuccessRate, le.Successes, le.Samples, le.LowerBound, le.UpperBound)
+				}
+			}
+			if config.curves != "" {
+				c, err := ev.Curves(config.curves)
+				if err != nil {
+					config.Fail(7, "test", err)
+				}
+				printCurves(c)
+				if config.curvesOut != "" {
+					err = writeCurves(config.curvesOut, c)
+					if err != nil {
+						config.Fail(8, "test", err)
+					}
+				}
 			}
-			config.Logf("Done")
-			fmt.Printf("%f success rate, failed to make a prediction for %d samples\n", successRate, errorCount)
 		},
 	}
-	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv) or SQLite3 (.db) file, or a PostgreSQL DB connection URL with data to use to grow the tree (defaults to STDIN, interpreted as CSV)")
-	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file from which the tree to test will be read and parsed as JSON (required)")
+	cmd.PersistentFlags().StringVarP(&(config.dataInput), "input", "i", "", "path to an input CSV (.csv), JSON Lines (.jsonl or .ndjson) or SQLite3 (.db) file, or a PostgreSQL DB connection URL or Cassandra keyspace URL with data to use to grow the tree (defaults to STDIN, interpreted as CSV)")
+	cmd.PersistentFlags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree to test will be read and parsed as JSON or the binary encoding (required)")
+	cmd.PersistentFlags().Float64Var(&(config.confidenceZ), "confidence-z", tree.DefaultConfidenceZ, "z-score used to compute the Wilson confidence intervals of success rates (defaults to 1.96, a 95% confidence level)")
+	cmd.PersistentFlags().BoolVar(&(config.leaves), "leaves", false, "report the success rate, support and confidence interval of every leaf reached by the testing set")
+	cmd.PersistentFlags().BoolVar(&(config.boosted), "boosted", false, "read the tree file as an ensemble of trees grown with the boost flag of the grow subcommand")
+	cmd.PersistentFlags().StringVar(&(config.curves), "curves", "", "value of the class feature taken as positive to report the ROC AUC, the PR AUC and the table of thresholds of the probability of that value against the rest (defaults to none)")
+	cmd.PersistentFlags().StringVar(&(config.curvesOut), "curves-output", "", "path to a CSV (.csv) or JSON (.json) file to which the points of the ROC and precision-recall curves reported with the curves flag are written for plotting (defaults to none)")
+	cmd.PersistentFlags().IntVar(&(config.concurrency), "concurrency", 0, "number of concurrent workers predicting the samples of the testing set (defaults to 0, one for every CPU)")
 	return cmd
 }
 
@@ -77,53 +125,75 @@ func (tcc *testCmdConfig) Validate() error {
 	if tcc.metadataInput == "" {
 		return fmt.Errorf("required metadata flag was not set")
 	}
-	return nil
-}
-
-func (tcc *testCmdConfig) testingSet(features []feature.Feature) (set.Set, error) {
-	var f *os.File
-	if tcc.dataInput == "" {
-		tcc.Logf("Reading testing set from STDIN...")
-		f = os.Stdin
-	} else {
-		if strings.HasPrefix(tcc.dataInput, "postgresql://") {
-			return tcc.PostgreSQLTestingSet(features)
-		}
-		if strings.HasSuffix(tcc.dataInput, ".db") {
-			return tcc.Sqlite3TestingSet(features)
+	if tcc.confidenceZ <= 0 {
+		return fmt.Errorf("confidence-z flag must be positive")
+	}
+	if tcc.concurrency < 0 {
+		return fmt.Errorf("concurrency flag cannot be negative")
+	}
+	if tcc.curvesOut != "" {
+		if tcc.curves == "" {
+			return fmt.Errorf("curves-output flag requires the curves flag")
 		}
-		tcc.Logf("Opening %s to read testing set...", tcc.dataInput)
-		var err error
-		f, err = os.Open(tcc.dataInput)
-		if err != nil {
-			err = fmt.Errorf("opening testing set at %s: %v", tcc.dataInput, err)
-			return nil, err
+		if !strings.HasSuffix(tcc.curvesOut, ".csv") && !strings.HasSuffix(tcc.curvesOut, ".json") {
+			return fmt.Errorf("curves-output flag must be a path to a .csv or .json file")
 		}
-		defer f.Close()
 	}
-	testingSet, err := csv.ReadSet(f, features, set.New)
-	if err != nil {
-		return nil, fmt.Errorf("reading testing set: %v", err)
+	if tcc.boosted && tcc.leaves {
+		return fmt.Errorf("cannot report leaves for an ensemble of trees")
 	}
-	return testingSet, nil
+	return nil
 }
 
-func (tcc *testCmdConfig) Sqlite3TestingSet(features []feature.Feature) (set.Set, error) {
-	tcc.Logf("Creating SQLite3 adapter for file %s to read testing set...", tcc.dataInput)
-	adapter, err := sqlite3adapter.New(tcc.dataInput, 0)
-	if err != nil {
-		return nil, err
+func (tcc *testCmdConfig) testingSet(features []feature.Feature) (set.Set, error) {
+	return opener.Open(tcc.Context(), tcc.dataInput, features, &opener.Options{Name: "testing set"})
+}
+
+/*
+printCurves takes the ROC and precision-recall curves of a testing set and
+prints their areas and their table of thresholds to STDOUT.
+*/
+func printCurves(c *tree.Curves) {
+	fmt.Printf("%s against the rest of values: ROC AUC %f, PR AUC %f, %d positive and %d negative samples\n", c.Positive, c.ROCAUC, c.PRAUC, c.Positives, c.Negatives)
+	for _, p := range c.Points {
+		fmt.Printf("threshold %f: %d true positives, %d false positives, %f true positive rate, %f false positive rate, %f precision\n", p.Threshold, p.TruePositives, p.FalsePositives, p.TruePositiveRate, p.FalsePositiveRate, p.Precision)
 	}
-	tcc.Logf("Opening set over SQLite3 adapter for file %s to read testing set...", tcc.dataInput)
-	return sqlset.Open(tcc.Context(), adapter, features)
 }
 
-func (tcc *testCmdConfig) PostgreSQLTestingSet(features []feature.Feature) (set.Set, error) {
-	tcc.Logf("Creating PostgreSQL adapter for url %s to read testing set...", tcc.dataInput)
-	adapter, err := pgadapter.New(tcc.dataInput)
+/*
+writeCurves takes a path and the ROC and precision-recall curves of a
+testing set and writes them to a file on the path, as JSON if it ends in
+.json and as CSV with a row for every point otherwise. It returns an error
+if the file cannot be written.
+*/
+func writeCurves(path string, c *tree.Curves) error {
+	f, err := os.Create(path)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("creating curves file %s: %v", path, err)
 	}
-	tcc.Logf("Opening set over PostgreSQL adapter for url %s to read testing set...", tcc.dataInput)
-	return sqlset.Open(tcc.Context(), adapter, features)
+	if strings.HasSuffix(path, ".json") {
+		err = json.NewEncoder(f).Encode(c)
+	} else {
+		w := csv.NewWriter(f)
+		w.Write([]string{"threshold", "truePositives", "falsePositives", "truePositiveRate", "falsePositiveRate", "precision"})
+		for _, p := range c.Points {
+			w.Write([]string{
+				strconv.FormatFloat(p.Threshold, 'g', -1, 64),
+				strconv.Itoa(p.TruePositives),
+				strconv.Itoa(p.FalsePositives),
+				strconv.FormatFloat(p.TruePositiveRate, 'g', -1, 64),
+				strconv.FormatFloat(p.FalsePositiveRate, 'g', -1, 64),
+				strconv.FormatFloat(p.Precision, 'g', -1, 64),
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing curves file %s: %v", path, err)
+	}
+	return nil
 }
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/pbanos/botanic/feature"
-	"github.com/pbanos/botanic/feature/yaml"
+	"github.com/pbanos/botanic/objectstore"
 	"github.com/pbanos/botanic/tree"
+	"github.com/pbanos/botanic/tree/binary"
 	"github.com/pbanos/botanic/tree/json"
+	"github.com/pbanos/botanic/webhook"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +23,8 @@ type treeCmdConfig struct {
 	*rootCmdConfig
 	treeInput     string
 	metadataInput string
+	webhookURL    string
+	notifier      *webhook.Notifier
 	ctx           context.Context
 	cancelFunc    context.CancelFunc
 }
@@ -30,27 +39,28 @@ func treeCmd(rootConfig *rootCmdConfig) *cobra.Command {
 			err := config.Validate()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				exit(1)
 			}
 			config.Context()
-			config.Logf("Reading features from metadata at %s...", config.metadataInput)
-			features, err := yaml.ReadFeaturesFromFile(config.metadataInput)
+			config.Info("Reading features from metadata", "path", config.metadataInput)
+			features, err := readFeaturesFromFile(config.metadataInput)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
+				exit(2)
 			}
-			config.Logf("Features from metadata read")
-			tree, err := loadTree(context.Background(), config.treeInput, features)
+			config.Info("Features from metadata read")
+			tree, err := loadTree(config.Context(), config.treeInput, features)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(3)
+				exit(3)
 			}
 			fmt.Println(tree)
 		},
 	}
-	cmd.PersistentFlags().StringVarP(&(config.metadataInput), "metadata", "m", "", "path to a YML file with metadata describing the different features used on a tree or available on an input set (required)")
-	cmd.AddCommand(growCmd(config), testCmd(config), predictCmd(config))
-	cmd.Flags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file from which the tree to show will be read and parsed as JSON (required)")
+	cmd.PersistentFlags().StringVarP(&(config.metadataInput), "metadata", "m", "", "path to a YML file, or a JSON file if it ends in .json, with metadata describing the different features used on a tree or available on an input set (required)")
+	cmd.PersistentFlags().StringVar(&(config.webhookURL), "webhook-url", "", "URL to which events on the growth and testing of trees and errors will be posted in JSON format (defaults to no notifications)")
+	cmd.AddCommand(growCmd(config), testCmd(config), predictCmd(config), pruneCmd(config), compareCmd(config), diffCmd(config), statsCmd(config), importancesCmd(config), routeStatsCmd(config), exportCmd(config), importCmd(config), workCmd(config))
+	cmd.Flags().StringVarP(&(config.treeInput), "tree", "t", "", "path to a file, or s3:// or gs:// URI of an object, from which the tree to show will be read and parsed as JSON or the binary encoding (required)")
 	return cmd
 }
 
@@ -65,22 +75,100 @@ func (tcc *treeCmdConfig) Validate() error {
 }
 
 func loadTree(ctx context.Context, filepath string, features []feature.Feature) (*tree.Tree, error) {
-	f, err := os.Open(filepath)
+	t := &tree.Tree{NodeStore: tree.NewMemoryNodeStore()}
+	err := readTreeFile(ctx, filepath, func(r io.Reader, isBinary bool) error {
+		if isBinary {
+			return binary.ReadBinaryTree(ctx, t, features, r)
+		}
+		return json.ReadJSONTree(ctx, t, features, r)
+	})
+	return t, err
+}
+
+/*
+openNodeStore takes the s3:// or gs:// URI of a prefix on object storage and
+the features of a tree and returns a node store that keeps the nodes of the
+tree as JSON objects under the prefix, or an error if the URI is not valid.
+*/
+func openNodeStore(uri string, features []feature.Feature) (tree.NodeStore, error) {
+	if !objectstore.IsURI(uri) {
+		return nil, fmt.Errorf("node store %s is not an s3:// or gs:// URI", uri)
+	}
+	b, prefix, err := objectstore.Open(uri)
 	if err != nil {
-		return nil, fmt.Errorf("reading tree in JSON from %s: %v", filepath, err)
+		return nil, err
 	}
-	defer f.Close()
-	t := &tree.Tree{NodeStore: tree.NewMemoryNodeStore()}
-	err = json.ReadJSONTree(ctx, t, features, f)
+	return objectstore.NewNodeStore(b, prefix, features), nil
+}
+
+/*
+loadEnsemble takes a context, the path to a file with an ensemble of trees in
+JSON format or the binary encoding, optionally compressed with gzip if the
+path ends in .gz, and a slice of features and returns the ensemble read from the file with every
+tree on its own memory node store, or an error.
+*/
+func loadEnsemble(ctx context.Context, filepath string, features []feature.Feature) (*tree.Ensemble, error) {
+	e := &tree.Ensemble{}
+	err := readTreeFile(ctx, filepath, func(r io.Reader, isBinary bool) error {
+		if isBinary {
+			return binary.ReadBinaryEnsemble(ctx, e, features, r, tree.NewMemoryNodeStore)
+		}
+		return json.ReadJSONEnsemble(ctx, e, features, r, tree.NewMemoryNodeStore)
+	})
+	return e, err
+}
+
+/*
+readTreeFile takes a context, the path to a file with a tree or ensemble of
+trees, or an s3:// or gs:// URI of an object with it, and a function to
+parse it and calls the function with a reader over the contents of the file,
+decompressing them with gzip if the path ends in .gz, and whether they start
+with binary.Magic and must be parsed as the binary encoding instead of JSON.
+It returns an error if the file cannot be read or parsed.
+*/
+func readTreeFile(ctx context.Context, filepath string, parse func(r io.Reader, isBinary bool) error) error {
+	var r io.Reader
+	if objectstore.IsURI(filepath) {
+		b, key, err := objectstore.Open(filepath)
+		if err != nil {
+			return err
+		}
+		contents, ok, err := b.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("reading tree in JSON from %s: %v", filepath, err)
+		}
+		if !ok {
+			return fmt.Errorf("reading tree in JSON from %s: object not found", filepath)
+		}
+		r = bytes.NewReader(contents)
+	} else {
+		f, err := os.Open(filepath)
+		if err != nil {
+			return fmt.Errorf("reading tree in JSON from %s: %v", filepath, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if strings.HasSuffix(filepath, ".gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("reading compressed tree from %s: %v", filepath, err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	br := bufio.NewReader(r)
+	prefix, _ := br.Peek(len(binary.Magic))
+	err := parse(br, binary.IsBinary(prefix))
 	if err != nil {
-		err = fmt.Errorf("parsing tree in JSON from %s: %v", filepath, err)
+		return fmt.Errorf("parsing tree from %s: %v", filepath, err)
 	}
-	return t, err
+	return nil
 }
 
 func (tcc *treeCmdConfig) setContextAndCancelFunc() {
 	if tcc.ctx == nil {
-		tcc.ctx, tcc.cancelFunc = context.WithCancel(context.Background())
+		tcc.ctx, tcc.cancelFunc = tcc.rootContext()
 	}
 }
 
@@ -93,3 +181,20 @@ func (tcc *treeCmdConfig) ContextCancelFunc() context.CancelFunc {
 	tcc.setContextAndCancelFunc()
 	return tcc.cancelFunc
 }
+
+/*
+byteSize takes a number of bytes and returns a human-readable
+representation of it.
+*/
+func byteSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/opener"
+	"github.com/spf13/cobra"
+)
+
+type validateCmdConfig struct {
+	*setCmdConfig
+	maxErrors int
+	required  string
+	format    string
+}
+
+/*
+rowError is an error found on a row of a set, reported as a JSON object on
+the json format. Line is the line of the row on CSV and JSON Lines sets and
+the number of the sample, from 1, on sets on databases.
+*/
+type rowError struct {
+	Line    int    `json:"line"`
+	Feature string `json:"feature,omitempty"`
+	Error   string `json:"error"`
+}
+
+func validateCmd(setConfig *setCmdConfig) *cobra.Command {
+	config := &validateCmdConfig{setCmdConfig: setConfig}
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate a set against its metadata",
+		Long:  `Read a set and report every row with values that are not valid for the features of the metadata, or with undefined values for required features, without stopping on the first one, exiting with status 5 if any is found`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := setConfig.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			err = config.Validate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+			config.Context()
+			config.Info("Reading features from metadata", "path", setConfig.metadataInput)
+			features, err := readFeaturesFromFile(setConfig.metadataInput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(2)
+			}
+			config.Info("Features from metadata read")
+			required, err := config.requiredFeatures(features)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(3)
+			}
+			report := config.reporter(os.Stdout)
+			var rows, invalid int
+			err = config.readRows(features, func(line int, s set.Sample, err error) (bool, error) {
+				rows++
+				errs := validateRow(s, err, features, required)
+				if len(errs) == 0 {
+					return true, nil
+				}
+				invalid++
+				for _, re := range errs {
+					re.Line = line
+					err = report(re)
+					if err != nil {
+						return false, err
+					}
+				}
+				return config.maxErrors == 0 || invalid < config.maxErrors, nil
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(4)
+			}
+			config.Info("Set validated", "rows", rows, "invalidRows", invalid)
+			if config.format == "text" {
+				fmt.Printf("%d rows read, %d invalid\n", rows, invalid)
+			}
+			if invalid > 0 {
+				exit(5)
+			}
+		},
+	}
+	cmd.PersistentFlags().IntVar(&(config.maxErrors), "max-errors", 0, "number of invalid rows after which the validation stops (defaults to 0, no limit)")
+	cmd.PersistentFlags().StringVar(&(config.required), "required", "", "comma-separated names of the features that must have a defined value on every row (defaults to none)")
+	cmd.PersistentFlags().StringVar(&(config.format), "format", "text", "format of the errors written to STDOUT, the following are valid: text, json (JSON Lines with the line, feature and error of every error)")
+	return cmd
+}
+
+func (vcc *validateCmdConfig) Validate() error {
+	if vcc.maxErrors < 0 {
+		return fmt.Errorf("max-errors flag cannot be negative")
+	}
+	if vcc.format != "text" && vcc.format != "json" {
+		return fmt.Errorf("unknown format %s, the following are valid: text, json", vcc.format)
+	}
+	return nil
+}
+
+/*
+requiredFeatures takes a slice of features and returns the set of names of
+those given with the required flag, or an error if any of them is not among
+the features.
+*/
+func (vcc *validateCmdConfig) requiredFeatures(features []feature.Feature) (map[string]bool, error) {
+	required := make(map[string]bool)
+	if vcc.required == "" {
+		return required, nil
+	}
+	names := make(map[string]bool, len(features))
+	for _, f := range features {
+		names[f.Name()] = true
+	}
+	for _, name := range strings.Split(vcc.required, ",") {
+		name = strings.TrimSpace(name)
+		if !names[name] {
+			return nil, fmt.Errorf("unknown required feature %s", name)
+		}
+		required[name] = true
+	}
+	return required, nil
+}
+
+/*
+reporter takes a writer and returns a function that writes the row errors
+it is given on the writer in the format given with the format flag.
+*/
+func (vcc *validateCmdConfig) reporter(w io.Writer) func(rowError) error {
+	if vcc.format == "json" {
+		enc := json.NewEncoder(w)
+		return func(re rowError) error {
+			return enc.Encode(re)
+		}
+	}
+	return func(re rowError) error {
+		var err error
+		if re.Feature != "" {
+			_, err = fmt.Fprintf(w, "line %d: feature %s: %s\n", re.Line, re.Feature, re.Error)
+		} else {
+			_, err = fmt.Fprintf(w, "line %d: %s\n", re.Line, re.Error)
+		}
+		return err
+	}
+}
+
+/*
+readRows takes a slice of features and a lambda function and calls the
+lambda with the line number of every row of the input set and either the
+sample on it or the error reading it. Samples of sets on databases are
+numbered from 1. It returns an error if the set cannot be read or the
+lambda returns one.
+*/
+func (vcc *validateCmdConfig) readRows(features []feature.Feature, lambda func(int, set.Sample, error) (bool, error)) error {
+	if opener.Scheme(vcc.setInput) != opener.FileScheme {
+		ctx, cancel := context.WithCancel(vcc.Context())
+		defer cancel()
+		sampleStream, errStream, err := vcc.inputStream(ctx, features, nil)
+		if err != nil {
+			return err
+		}
+		i := 0
+		for s := range sampleStream {
+			i++
+			ok, err := lambda(i, s, nil)
+			if err != nil || !ok {
+				cancel()
+				for range sampleStream {
+				}
+				return err
+			}
+		}
+		return <-errStream
+	}
+	f := os.Stdin
+	if vcc.setInput != "" {
+		var err error
+		vcc.Info("Opening file to read input set", "path", vcc.setInput)
+		f, err = os.Open(vcc.setInput)
+		if err != nil {
+			return fmt.Errorf("reading input set from %s: %v", vcc.setInput, err)
+		}
+		defer f.Close()
+	} else {
+		vcc.Info("Reading input set from STDIN")
+	}
+	return vcc.readSetByRow(f, vcc.setInput, features, lambda)
+}
+
+/*
+validateRow takes a sample read from a row, the error reading it, a slice of
+features and the set of names of the required ones and returns the errors
+found on the row: the error reading it, if any, or the values of the sample
+that are not valid for their features and the undefined values of required
+features.
+*/
+func validateRow(s set.Sample, err error, features []feature.Feature, required map[string]bool) []rowError {
+	if err != nil {
+		return []rowError{{Error: err.Error()}}
+	}
+	var errs []rowError
+	for _, f := range features {
+		v, err := s.ValueFor(f)
+		if err != nil {
+			errs = append(errs, rowError{Feature: f.Name(), Error: err.Error()})
+			continue
+		}
+		if v == nil {
+			if required[f.Name()] {
+				errs = append(errs, rowError{Feature: f.Name(), Error: "undefined value for required feature"})
+			}
+			continue
+		}
+		if ok, err := f.Valid(v); !ok {
+			msg := fmt.Sprintf("invalid value %v", v)
+			if err != nil {
+				msg = err.Error()
+			}
+			errs = append(errs, rowError{Feature: f.Name(), Error: msg})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pbanos/botanic/tree"
+	"github.com/pbanos/botanic/webhook"
+)
+
+/*
+milestoneNodes is the number of nodes developed between the growth
+milestones notified to the webhook.
+*/
+const milestoneNodes = 1000
+
+/*
+webhookCloseTimeout is the time commands wait for pending events to be
+posted to the webhook before exiting.
+*/
+const webhookCloseTimeout = 30 * time.Second
+
+/*
+Notify takes an event type and the data of the event and posts it to the
+configured webhook URL, if any. Failures to post events are logged and
+otherwise ignored.
+*/
+func (tcc *treeCmdConfig) Notify(eventType string, data map[string]interface{}) {
+	if tcc.webhookURL == "" {
+		return
+	}
+	if tcc.notifier == nil {
+		tcc.notifier = webhook.New(tcc.webhookURL, webhook.DefaultTimeout, func(e *webhook.Event, err error) {
+			tcc.Warn("Posting event to webhook", "event", e.Type, "error", err)
+		})
+	}
+	err := tcc.notifier.Notify(context.Background(), eventType, data)
+	if err != nil {
+		tcc.Warn("Notifying event", "event", eventType, "error", err)
+	}
+}
+
+/*
+CloseNotifier waits for the events notified to be posted to the configured
+webhook URL, if any, for up to webhookCloseTimeout.
+*/
+func (tcc *treeCmdConfig) CloseNotifier() {
+	if tcc.notifier == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookCloseTimeout)
+	defer cancel()
+	err := tcc.notifier.Close(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "waiting for events to be posted to webhook: %v\n", err)
+	}
+	tcc.notifier = nil
+}
+
+/*
+Fail takes an exit code, the name of the failing command and an error,
+notifies the error to the configured webhook URL, if any, prints it on
+STDERR and exits with the code.
+*/
+func (tcc *treeCmdConfig) Fail(code int, command string, err error) {
+	tcc.Notify(webhook.EventError, map[string]interface{}{
+		"command": command,
+		"message": err.Error(),
+		"code":    code,
+	})
+	tcc.CloseNotifier()
+	fmt.Fprintln(os.Stderr, err)
+	exit(code)
+}
+
+/*
+milestoneNodeStore is a tree.NodeStore that counts the nodes stored on the
+node store it wraps, which happens once for every node developed during the
+growth of a tree, and calls its milestone function every milestoneNodes
+nodes.
+*/
+type milestoneNodeStore struct {
+	tree.NodeStore
+	count     int64
+	milestone func(nodes int64)
+}
+
+func (mns *milestoneNodeStore) Store(ctx context.Context, n *tree.Node) error {
+	err := mns.NodeStore.Store(ctx, n)
+	if err != nil {
+		return err
+	}
+	if c := atomic.AddInt64(&mns.count, 1); c%milestoneNodes == 0 {
+		mns.milestone(c)
+	}
+	return nil
+}
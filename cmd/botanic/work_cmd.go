@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic"
+	"github.com/pbanos/botanic/coordinator"
+	"github.com/spf13/cobra"
+)
+
+type workCmdConfig struct {
+	*treeCmdConfig
+	coordinatorURL     string
+	pruneStrategy      string
+	maxDepth           int
+	minSamplesSplit    int
+	minSamplesLeaf     int
+	maxThresholds      int
+	discreteSplit      string
+	concurrency        int
+	featureConcurrency int
+	pollInterval       time.Duration
+	retryAttempts      int
+	retryBackoff       time.Duration
+	retryMaxBackoff    time.Duration
+}
+
+func workCmd(treeConfig *treeCmdConfig) *cobra.Command {
+	config := &workCmdConfig{treeCmdConfig: treeConfig}
+	cmd := &cobra.Command{
+		Use:   "work",
+		Short: "Work on the growth of a tree coordinated by another process",
+		Long:  `Develop the nodes of a tree being grown by a grow command started with the coordinator-addr flag, pulling the tasks to develop them, along with their samples, from it until the tree is grown`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.Validate()
+			if err != nil {
+				config.Fail(1, "work", err)
+			}
+			features, err := readFeaturesFromFile(config.metadataInput)
+			if err != nil {
+				config.Fail(2, "work", err)
+			}
+			pruner, err := pruningStrategy(config.pruneStrategy)
+			if err != nil {
+				config.Fail(3, "work", err)
+			}
+			pruner.MaxDepth = config.maxDepth
+			pruner.MinSamplesSplit = config.minSamplesSplit
+			pruner.MinSamplesLeaf = config.minSamplesLeaf
+			discreteSplit, err := botanic.ParseDiscreteSplit(config.discreteSplit)
+			if err != nil {
+				config.Fail(3, "work", err)
+			}
+			retryPolicy := &botanic.RetryPolicy{
+				MaxAttempts:    config.retryAttempts,
+				InitialBackoff: config.retryBackoff,
+				MaxBackoff:     config.retryMaxBackoff,
+			}
+			client := coordinator.NewClient(config.coordinatorURL, features)
+			t, err := client.Tree(config.Context())
+			if err != nil {
+				config.Fail(4, "work", fmt.Errorf("retrieving tree from coordinator: %v", err))
+			}
+			q := client.Queue()
+			defer q.Stop(context.Background())
+			config.Info("Working on tree", "coordinator", config.coordinatorURL, "rootID", t.RootID, "classFeature", t.ClassFeature.Name(), "workers", config.concurrency)
+			var wg sync.WaitGroup
+			errs := make(chan error, config.concurrency)
+			for i := 0; i < config.concurrency; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- botanic.Work(config.Context(), t, q, pruner, config.pollInterval, botanic.WithDiscreteSplit(discreteSplit), botanic.WithMaxThresholds(config.maxThresholds), botanic.WithFeatureConcurrency(config.featureConcurrency), botanic.WithLogger(config.Logger()), botanic.WithRetryPolicy(retryPolicy))
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					config.Fail(5, "work", fmt.Errorf("working on tree: %v", err))
+				}
+			}
+			config.Info("Done")
+		},
+	}
+	cmd.PersistentFlags().StringVar(&(config.coordinatorURL), "coordinator", "", "URL of the grow command coordinating the growth of the tree, such as http://HOST:7070 for one started with the coordinator-addr flag set to :7070 (required)")
+	cmd.PersistentFlags().StringVarP(&(config.pruneStrategy), "prune", "p", "default", "pruning strategy to apply, the following are valid: default, minimum-information-gain:[VALUE], none; it should be the one of the grow command")
+	cmd.PersistentFlags().IntVar(&(config.maxDepth), "max-depth", 0, "maximum depth of the nodes of the tree, the root being at depth 0, which should be the one of the grow command (defaults to 0, no limit)")
+	cmd.PersistentFlags().IntVar(&(config.minSamplesSplit), "min-samples-split", 0, "minimum number of training samples a node must have to be branched out, which should be the one of the grow command (defaults to 0, no minimum)")
+	cmd.PersistentFlags().IntVar(&(config.minSamplesLeaf), "min-samples-leaf", 0, "minimum number of training samples for every subtree with samples of a node branched out, which should be the one of the grow command (defaults to 0, no minimum)")
+	cmd.PersistentFlags().IntVar(&(config.maxThresholds), "max-thresholds", 64, "maximum number of candidate thresholds evaluated to split a range of values of a continuous feature, which should be the one of the grow command (0 for no limit)")
+	cmd.PersistentFlags().StringVar(&(config.discreteSplit), "discrete-split", "multiway", "how nodes are branched out on discrete features, multiway or binary, which should be the one of the grow command")
+	cmd.PersistentFlags().IntVar(&(config.concurrency), "concurrency", 1, "number of nodes developed concurrently by this process (defaults to 1)")
+	cmd.PersistentFlags().IntVar(&(config.featureConcurrency), "feature-concurrency", 1, "limit to features whose partitions are computed concurrently when branching out a node (defaults to 1)")
+	cmd.PersistentFlags().DurationVar(&(config.pollInterval), "poll-interval", time.Second, "time to wait before pulling again from the coordinator when it has no tasks pending but some running")
+	cmd.PersistentFlags().IntVar(&(config.retryAttempts), "retry-attempts", 4, "number of times an operation on the coordinator that fails with a transient error is attempted, and of consecutive tasks that may fail with one, before giving up")
+	cmd.PersistentFlags().DurationVar(&(config.retryBackoff), "retry-backoff", time.Second, "time to wait before retrying an operation that failed with a transient error, doubled on every further retry")
+	cmd.PersistentFlags().DurationVar(&(config.retryMaxBackoff), "retry-max-backoff", 30*time.Second, "maximum time to wait before retrying an operation that failed with a transient error (0 for no limit)")
+	cmd.AddCommand(deadLettersCmd(config))
+	return cmd
+}
+
+func (wcc *workCmdConfig) Validate() error {
+	if wcc.metadataInput == "" {
+		return fmt.Errorf("required metadata flag was not set")
+	}
+	if wcc.coordinatorURL == "" {
+		return fmt.Errorf("required coordinator flag was not set")
+	}
+	if wcc.maxDepth < 0 {
+		return fmt.Errorf("max-depth flag cannot be negative")
+	}
+	if wcc.minSamplesSplit < 0 {
+		return fmt.Errorf("min-samples-split flag cannot be negative")
+	}
+	if wcc.minSamplesLeaf < 0 {
+		return fmt.Errorf("min-samples-leaf flag cannot be negative")
+	}
+	if wcc.maxThresholds < 0 {
+		return fmt.Errorf("max-thresholds flag cannot be negative")
+	}
+	if wcc.concurrency < 1 {
+		return fmt.Errorf("concurrency flag must be at least 1")
+	}
+	if wcc.featureConcurrency < 1 {
+		return fmt.Errorf("feature-concurrency flag must be at least 1")
+	}
+	if wcc.pollInterval <= 0 {
+		return fmt.Errorf("poll-interval flag must be positive")
+	}
+	if wcc.retryAttempts < 1 {
+		return fmt.Errorf("retry-attempts flag must be at least 1")
+	}
+	if wcc.retryBackoff < 0 {
+		return fmt.Errorf("retry-backoff flag cannot be negative")
+	}
+	if wcc.retryMaxBackoff < 0 {
+		return fmt.Errorf("retry-max-backoff flag cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+Client calls the service of a Server to grow the tree it coordinates.
+*/
+type Client struct {
+	url    string
+	client *http.Client
+	codec  *codec
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+/*
+NewClient takes the URL of a Server and the features of the tree it
+coordinates and returns a Client for it.
+*/
+func NewClient(url string, features []feature.Feature) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{},
+		codec:  newCodec(features),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+/*
+UnavailableError is the error returned by the operations of a Client when
+the server cannot be reached or answers that it is unavailable. Its
+Temporary method returns true, so that workers drop the task they were
+developing and retry it later instead of aborting, and it is both
+queue.ErrUnavailable and tree.ErrNodeStoreUnavailable for errors.Is, as the
+server serves the queue and the node store of the tree.
+*/
+type UnavailableError struct {
+	Err error
+}
+
+func (ue *UnavailableError) Error() string {
+	return fmt.Sprintf("coordinator unavailable: %v", ue.Err)
+}
+
+/*
+Temporary returns true, as the coordinator may become available later.
+*/
+func (ue *UnavailableError) Temporary() bool {
+	return true
+}
+
+/*
+Is returns whether the target is queue.ErrUnavailable or
+tree.ErrNodeStoreUnavailable.
+*/
+func (ue *UnavailableError) Is(target error) bool {
+	return target == queue.ErrUnavailable || target == tree.ErrNodeStoreUnavailable
+}
+
+/*
+Unwrap returns the error calling the server.
+*/
+func (ue *UnavailableError) Unwrap() error {
+	return ue.Err
+}
+
+/*
+LeaseEndedError is the error returned by the operations of a Client made on
+behalf of a task whose lease has ended, such as creating a node, once the
+task has been dropped back into the queue for another worker. Its Temporary
+method returns true, so that workers drop the task and go on with the next
+one instead of aborting.
+*/
+type LeaseEndedError struct {
+	Err error
+}
+
+func (lee *LeaseEndedError) Error() string {
+	return fmt.Sprintf("lease ended: %v", lee.Err)
+}
+
+/*
+Temporary returns true, as the worker can go on with other tasks.
+*/
+func (lee *LeaseEndedError) Temporary() bool {
+	return true
+}
+
+/*
+Unwrap returns the error calling the server.
+*/
+func (lee *LeaseEndedError) Unwrap() error {
+	return lee.Err
+}
+
+/*
+notFoundError is the error returned by the operations of a Client when the
+server answers that the task they refer to was not found. It is
+queue.ErrTaskNotFound for errors.Is.
+*/
+type notFoundError struct {
+	msg string
+}
+
+func (nfe *notFoundError) Error() string {
+	return nfe.msg
+}
+
+/*
+Is returns whether the target is queue.ErrTaskNotFound.
+*/
+func (nfe *notFoundError) Is(target error) bool {
+	return target == queue.ErrTaskNotFound
+}
+
+/*
+Tree takes a context and returns the tree being grown by the server, with
+the client's NodeStore, or an error if it cannot be retrieved.
+*/
+func (c *Client) Tree(ctx context.Context) (*tree.Tree, error) {
+	var tm treeMessage
+	_, err := c.call(ctx, http.MethodGet, "/tree", nil, &tm)
+	if err != nil {
+		return nil, err
+	}
+	classFeature, ok := c.codec.byName[tm.ClassFeature]
+	if !ok {
+		return nil, fmt.Errorf("unknown class feature '%s'", tm.ClassFeature)
+	}
+	t := tree.New(tm.RootID, c.NodeStore(), classFeature)
+	if tm.MissingValueStrategy != "" {
+		t.MissingValueStrategy, err = tree.ParseMissingValueStrategy(tm.MissingValueStrategy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+/*
+Queue returns a queue.Queue backed by the queue of the server. It is a
+queue.BatchQueue and a queue.DeadLetterQueue, and the contexts of the tasks
+pulled from it are cancelled when it is stopped. The contexts also carry the
+lease on the task, which is sent on every call made with them or with
+contexts derived from them. The dead letters it returns are sent without
+their samples, so their sets are empty.
+*/
+func (c *Client) Queue() queue.Queue {
+	return &clientQueue{c}
+}
+
+/*
+NodeStore returns a tree.NodeStore backed by the node store of the server.
+It is a tree.BatchNodeStore and a tree.BatchGetNodeStore that sends a
+single request for every batch.
+*/
+func (c *Client) NodeStore() tree.NodeStore {
+	return &clientNodeStore{c}
+}
+
+/*
+call takes a context, an HTTP method, a path of the service, a value to send
+encoded as JSON, or nil to send none, and a value to decode the response
+into, and calls the service. It returns false if the server answered with no
+content, or an error if the call fails.
+*/
+func (c *Client) call(ctx context.Context, method, path string, in, out interface{}) (bool, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return false, fmt.Errorf("%s %s: %v", method, path, err)
+		}
+	}
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("%s %s: %v", method, path, err)
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if lease, ok := ctx.Value(leaseKey{}).(string); ok {
+		req.Header.Set(leaseHeader, lease)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return false, &UnavailableError{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return false, &UnavailableError{fmt.Errorf("%s %s: %s", method, path, resp.Status)}
+	case http.StatusConflict:
+		return false, &LeaseEndedError{fmt.Errorf("%s %s: %s", method, path, resp.Status)}
+	default:
+		var em errorMessage
+		b, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(b, &em) != nil || em.Error == "" {
+			em.Error = strings.TrimSpace(string(b))
+		} else if resp.StatusCode == http.StatusNotFound {
+			return false, &notFoundError{fmt.Sprintf("%s %s: %s", method, path, em.Error)}
+		}
+		return false, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, em.Error)
+	}
+	if out == nil {
+		return true, nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return false, fmt.Errorf("%s %s: decoding response: %v", method, path, err)
+	}
+	return true, nil
+}
+
+type clientQueue struct {
+	*Client
+}
+
+/*
+leaseKey is the key of the lease on a task on the contexts returned when it
+is pulled.
+*/
+type leaseKey struct{}
+
+// clientQueue must implement queue.BatchQueue and
+// queue.DeadLetterQueue, checked at compile time
+var _ queue.BatchQueue = (*clientQueue)(nil)
+var _ queue.DeadLetterQueue = (*clientQueue)(nil)
+
+func (cq *clientQueue) Push(ctx context.Context, t *queue.Task) error {
+	return cq.PushAll(ctx, []*queue.Task{t})
+}
+
+func (cq *clientQueue) PushAll(ctx context.Context, tasks []*queue.Task) error {
+	tms := make([]*taskMessage, 0, len(tasks))
+	for _, t := range tasks {
+		tm, err := cq.codec.encodeTask(ctx, t)
+		if err != nil {
+			return err
+		}
+		tms = append(tms, tm)
+	}
+	_, err := cq.call(ctx, http.MethodPost, "/tasks/push", tms, nil)
+	return err
+}
+
+func (cq *clientQueue) Pull(ctx context.Context) (*queue.Task, context.Context, error) {
+	var tm taskMessage
+	ok, err := cq.call(ctx, http.MethodPost, "/tasks/pull", struct{}{}, &tm)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	t, err := cq.codec.decodeTask(&tm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tm.Lease == "" {
+		return t, cq.ctx, nil
+	}
+	return t, context.WithValue(cq.ctx, leaseKey{}, tm.Lease), nil
+}
+
+func (cq *clientQueue) Drop(ctx context.Context, id string) error {
+	_, err := cq.call(ctx, http.MethodPost, "/tasks/drop", &idMessage{id}, nil)
+	return err
+}
+
+func (cq *clientQueue) Complete(ctx context.Context, id string) error {
+	_, err := cq.call(ctx, http.MethodPost, "/tasks/complete", &idMessage{id}, nil)
+	return err
+}
+
+func (cq *clientQueue) Count(ctx context.Context) (int, int, error) {
+	var cm countMessage
+	_, err := cq.call(ctx, http.MethodGet, "/tasks/count", nil, &cm)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cm.Pending, cm.Running, nil
+}
+
+func (cq *clientQueue) DeadLetters(ctx context.Context) ([]*queue.Task, error) {
+	var tms []*taskMessage
+	_, err := cq.call(ctx, http.MethodGet, "/tasks/dead-letters", nil, &tms)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]*queue.Task, 0, len(tms))
+	for _, tm := range tms {
+		t, err := cq.codec.decodeTask(tm)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+func (cq *clientQueue) Requeue(ctx context.Context, id string) error {
+	_, err := cq.call(ctx, http.MethodPost, "/tasks/requeue", &idMessage{id}, nil)
+	return err
+}
+
+func (cq *clientQueue) Stop(ctx context.Context) error {
+	cq.cancel()
+	return nil
+}
+
+type clientNodeStore struct {
+	*Client
+}
+
+// clientNodeStore must implement tree.BatchNodeStore and
+// tree.BatchGetNodeStore, checked at compile time
+var _ tree.BatchNodeStore = (*clientNodeStore)(nil)
+var _ tree.BatchGetNodeStore = (*clientNodeStore)(nil)
+
+func (cns *clientNodeStore) Create(ctx context.Context, n *tree.Node) error {
+	created := *n
+	created.ID = newNodeID
+	b, err := cns.codec.encodeNode(&created)
+	if err != nil {
+		return err
+	}
+	var im idMessage
+	_, err = cns.call(ctx, http.MethodPost, "/nodes/create", b, &im)
+	if err != nil {
+		return err
+	}
+	n.ID = im.ID
+	return nil
+}
+
+func (cns *clientNodeStore) Get(ctx context.Context, id string) (*tree.Node, error) {
+	nodes, err := cns.GetBatch(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+func (cns *clientNodeStore) GetBatch(ctx context.Context, ids []string) ([]*tree.Node, error) {
+	var encoded []json.RawMessage
+	_, err := cns.call(ctx, http.MethodPost, "/nodes/get", &idsMessage{ids}, &encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) != len(ids) {
+		return nil, fmt.Errorf("expected %d nodes from coordinator but got %d", len(ids), len(encoded))
+	}
+	nodes := make([]*tree.Node, len(encoded))
+	for i, b := range encoded {
+		nodes[i], err = cns.codec.decodeNode(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func (cns *clientNodeStore) Store(ctx context.Context, n *tree.Node) error {
+	return cns.StoreBatch(ctx, []*tree.Node{n})
+}
+
+func (cns *clientNodeStore) StoreBatch(ctx context.Context, nodes []*tree.Node) error {
+	encoded := make([]json.RawMessage, 0, len(nodes))
+	for _, n := range nodes {
+		b, err := cns.codec.encodeNode(n)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, b)
+	}
+	_, err := cns.call(ctx, http.MethodPost, "/nodes/store", encoded, nil)
+	return err
+}
+
+func (cns *clientNodeStore) Delete(ctx context.Context, n *tree.Node) error {
+	_, err := cns.call(ctx, http.MethodPost, "/nodes/delete", &idMessage{n.ID}, nil)
+	return err
+}
+
+func (cns *clientNodeStore) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,329 @@
+/*
+Package coordinator provides an HTTP service to coordinate the growth of a
+tree by workers on other machines, and the client that workers use to
+contribute to it through a queue.Queue and a tree.NodeStore.
+
+The process serving a Server keeps the queue of tasks and the node store of
+the tree. Tasks are shipped to workers along with the samples of their
+sets, and the tasks they produce are shipped back the same way, so workers
+need access neither to the training set nor to the storage of the tree:
+only to the coordinator.
+
+Every operation is a call to a path of the service with a JSON request body,
+answered with a JSON response body:
+* GET /tree returns the root node ID, class feature and missing value strategy of the tree
+* POST /tasks/pull pulls a task from the queue, answering 204 No Content if there is none
+* POST /tasks/push pushes an array of tasks to the queue
+* POST /tasks/drop and POST /tasks/complete drop or complete the task with the given ID
+* GET /tasks/count returns the number of pending and running tasks
+* GET /tasks/dead-letters returns the tasks kept as dead letters, without their samples
+* POST /tasks/requeue requeues the dead letter with the given ID, answering 404 Not Found if there is none
+* POST /nodes/create creates a node sent with the ID "new", returning the ID it is given
+* POST /nodes/get returns the nodes with the given IDs, or null for those not found
+* POST /nodes/store stores an array of nodes
+* POST /nodes/delete deletes the node with the given ID
+
+Nodes are encoded as done by json.MarshalJSONNode in the tree/json package.
+Failed calls are answered with an error status and a JSON object with the
+error message in its "error" field.
+
+Every task pulled comes with a lease, which workers send in the
+Botanic-Lease header of the calls they make to develop it. A lease ends when
+the task is completed or dropped, or when the server's TaskTimeout expires
+and the task is dropped back into the queue for another worker, which waits
+for the calls being served with the lease to push tasks or to create, store
+or delete nodes. Calls to push tasks, store or delete nodes, or complete or
+drop a task made with a lease that ended are answered as successful but
+ignored, and calls to create nodes with 409 Conflict, so that a worker that
+outlives its lease cannot push subtasks, leave orphan nodes or overwrite
+nodes of a task pulled again by another worker.
+
+The samples of a task's set are loaded in memory and encoded whole every
+time it is pulled, and tasks are pushed with the samples of their sets, so
+the server must fit the sets of the tasks being pulled and pushed at the
+same time, and the one of the root task is the whole training set. Request
+bodies are limited to the server's MaxRequestSize.
+*/
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+	treejson "github.com/pbanos/botanic/tree/json"
+)
+
+/*
+treeMessage is the description of the tree being grown returned by GET
+/tree.
+*/
+type treeMessage struct {
+	RootID               string `json:"rootId"`
+	ClassFeature         string `json:"classFeature"`
+	MissingValueStrategy string `json:"missingValueStrategy,omitempty"`
+}
+
+/*
+taskMessage is a queue.Task as sent to and from the service, with its node,
+the samples of its set and the names of its available features, and the
+lease of the worker that pulled it when it is sent by /tasks/pull.
+*/
+type taskMessage struct {
+	Node              json.RawMessage `json:"node"`
+	Samples           []sampleMessage `json:"samples"`
+	AvailableFeatures []string        `json:"availableFeatures"`
+	Lease             string          `json:"lease,omitempty"`
+	queue.Metadata
+}
+
+/*
+leaseHeader is the header on which workers send the lease of the task on
+whose behalf they make a call.
+*/
+const leaseHeader = "Botanic-Lease"
+
+/*
+sampleMessage is a sample of the set of a task, with its value for every
+feature that has one and its weight if it is a set.WeightedSample.
+*/
+type sampleMessage struct {
+	Values map[string]interface{} `json:"values"`
+	Weight *float64               `json:"weight,omitempty"`
+}
+
+type idMessage struct {
+	ID string `json:"id"`
+}
+
+type idsMessage struct {
+	IDs []string `json:"ids"`
+}
+
+type countMessage struct {
+	Pending int `json:"pending"`
+	Running int `json:"running"`
+}
+
+type errorMessage struct {
+	Error string `json:"error"`
+}
+
+/*
+codec encodes and decodes tasks and nodes with the features of a tree.
+*/
+type codec struct {
+	features []feature.Feature
+	byName   map[string]feature.Feature
+}
+
+func newCodec(features []feature.Feature) *codec {
+	byName := make(map[string]feature.Feature, len(features))
+	for _, f := range features {
+		byName[f.Name()] = f
+	}
+	return &codec{features, byName}
+}
+
+/*
+encodeTask takes a context and a task and returns its taskMessage, or an
+error if the samples of its set cannot be retrieved or a value of one of them
+cannot be encoded.
+*/
+func (c *codec) encodeTask(ctx context.Context, t *queue.Task) (*taskMessage, error) {
+	tm, err := c.encodeTaskWithoutSamples(t)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := t.Set.Samples(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving samples of task %s: %v", t.ID(), err)
+	}
+	tm.Samples = make([]sampleMessage, 0, len(samples))
+	for _, s := range samples {
+		sm, err := c.encodeSample(s)
+		if err != nil {
+			return nil, fmt.Errorf("encoding sample of task %s: %v", t.ID(), err)
+		}
+		tm.Samples = append(tm.Samples, sm)
+	}
+	return tm, nil
+}
+
+/*
+encodeTaskWithoutSamples takes a task and returns its taskMessage with no
+samples, as dead letters are sent, or an error if its node cannot be
+encoded.
+*/
+func (c *codec) encodeTaskWithoutSamples(t *queue.Task) (*taskMessage, error) {
+	node, err := treejson.MarshalJSONNode(t.Node)
+	if err != nil {
+		return nil, fmt.Errorf("encoding node of task %s: %v", t.ID(), err)
+	}
+	tm := &taskMessage{Node: node, Metadata: t.Metadata}
+	for _, f := range t.AvailableFeatures {
+		tm.AvailableFeatures = append(tm.AvailableFeatures, f.Name())
+	}
+	return tm, nil
+}
+
+func (c *codec) encodeSample(s set.Sample) (sampleMessage, error) {
+	sm := sampleMessage{Values: make(map[string]interface{})}
+	for _, f := range c.features {
+		v, err := s.ValueFor(f)
+		if err != nil {
+			return sm, err
+		}
+		if v == nil {
+			continue
+		}
+		if fv, ok := v.(float64); ok && (math.IsNaN(fv) || math.IsInf(fv, 0)) {
+			return sm, fmt.Errorf("value %v for feature %s cannot be encoded", fv, f.Name())
+		}
+		sm.Values[f.Name()] = v
+	}
+	if ws, ok := s.(set.WeightedSample); ok {
+		w := ws.Weight()
+		sm.Weight = &w
+	}
+	return sm, nil
+}
+
+/*
+decodeTask takes a taskMessage and returns the task it encodes, with a set
+built with set.New, or an error if its node, features or samples are not
+valid, which wraps feature.ErrUnknownFeature for features of other trees
+and set.ErrInvalidSample for invalid values and weights.
+*/
+func (c *codec) decodeTask(tm *taskMessage) (*queue.Task, error) {
+	n, err := c.decodeNode(tm.Node)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("decoding task: no node")
+	}
+	t := &queue.Task{Node: n, Metadata: tm.Metadata}
+	for _, name := range tm.AvailableFeatures {
+		f, ok := c.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("decoding task %s: %w '%s'", n.ID, feature.ErrUnknownFeature, name)
+		}
+		t.AvailableFeatures = append(t.AvailableFeatures, f)
+	}
+	samples := make([]set.Sample, 0, len(tm.Samples))
+	for _, sm := range tm.Samples {
+		s, err := c.decodeSample(sm)
+		if err != nil {
+			return nil, fmt.Errorf("decoding sample of task %s: %w", n.ID, err)
+		}
+		samples = append(samples, s)
+	}
+	t.Set = set.New(samples)
+	return t, nil
+}
+
+func (c *codec) decodeSample(sm sampleMessage) (set.Sample, error) {
+	values := make(map[string]interface{}, len(sm.Values))
+	for name, v := range sm.Values {
+		f, ok := c.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w '%s'", feature.ErrUnknownFeature, name)
+		}
+		if v == nil {
+			continue
+		}
+		if ok, err := f.Valid(v); !ok {
+			return nil, fmt.Errorf("%w: invalid value %v of type %T for feature %s: %v", set.ErrInvalidSample, v, v, name, err)
+		}
+		values[name] = v
+	}
+	if sm.Weight != nil {
+		if *sm.Weight < 0 {
+			return nil, fmt.Errorf("%w: negative weight %f", set.ErrInvalidSample, *sm.Weight)
+		}
+		return set.NewWeightedSample(values, *sm.Weight), nil
+	}
+	return set.NewSample(values), nil
+}
+
+/*
+TaskCodec encodes tasks along with the samples of their sets as a Server
+ships them to workers, and decodes them back, so that queues kept out of
+process, such as the one of the pgqueue package, can store them whole.
+*/
+type TaskCodec struct {
+	codec *codec
+}
+
+/*
+NewTaskCodec takes the features of a tree and returns a TaskCodec for the
+tasks to grow it.
+*/
+func NewTaskCodec(features []feature.Feature) *TaskCodec {
+	return &TaskCodec{newCodec(features)}
+}
+
+/*
+EncodeTask takes a context and a task and returns it encoded in JSON with
+its node, the samples of its set, the names of its available features and
+its metadata, or an error if the samples of its set cannot be retrieved or
+encoded.
+*/
+func (tc *TaskCodec) EncodeTask(ctx context.Context, t *queue.Task) ([]byte, error) {
+	tm, err := tc.codec.encodeTask(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tm)
+}
+
+/*
+DecodeTask takes a task encoded by EncodeTask and returns it with a set
+built with set.New from its samples, or an error if it is not valid, which
+wraps feature.ErrUnknownFeature for features of other trees and
+set.ErrInvalidSample for invalid values and weights.
+*/
+func (tc *TaskCodec) DecodeTask(b []byte) (*queue.Task, error) {
+	tm := &taskMessage{}
+	err := json.Unmarshal(b, tm)
+	if err != nil {
+		return nil, fmt.Errorf("decoding task: %v", err)
+	}
+	return tc.codec.decodeTask(tm)
+}
+
+/*
+newNodeID is the ID nodes are sent with to be created, as encoded nodes must
+have one.
+*/
+const newNodeID = "new"
+
+func (c *codec) encodeNode(n *tree.Node) (json.RawMessage, error) {
+	b, err := treejson.MarshalJSONNode(n)
+	if err != nil {
+		return nil, fmt.Errorf("encoding node %s: %v", n.ID, err)
+	}
+	return b, nil
+}
+
+/*
+decodeNode takes an encoded node and returns it, or nil if it is null, or an
+error if it is not valid.
+*/
+func (c *codec) decodeNode(b json.RawMessage) (*tree.Node, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return nil, nil
+	}
+	n := &tree.Node{}
+	err := treejson.UnmarshalJSONNodeWithFeatures(n, b, c.features)
+	if err != nil {
+		return nil, fmt.Errorf("decoding node: %v", err)
+	}
+	return n, nil
+}
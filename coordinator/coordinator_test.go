@@ -0,0 +1,76 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+func TestDecodeTaskWrapsSampleErrors(t *testing.T) {
+	c := newCodec([]feature.Feature{
+		feature.NewDiscreteFeature("class", []string{"yes", "no"}),
+		feature.NewContinuousFeature("x"),
+	})
+	for _, tc := range []struct {
+		message string
+		target  error
+	}{
+		{`{"node":{"id":"1"},"samples":[{"values":{"unknown":1}}]}`, feature.ErrUnknownFeature},
+		{`{"node":{"id":"1"},"samples":[{"values":{"class":"maybe"}}]}`, set.ErrInvalidSample},
+		{`{"node":{"id":"1"},"samples":[{"values":{"x":"1.5"}}]}`, set.ErrInvalidSample},
+		{`{"node":{"id":"1"},"samples":[{"values":{"x":1.5},"weight":-1}]}`, set.ErrInvalidSample},
+	} {
+		tm := &taskMessage{}
+		if err := json.Unmarshal([]byte(tc.message), tm); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.decodeTask(tm)
+		if !errors.Is(err, tc.target) {
+			t.Errorf("decoding %s: expected an error wrapping %v, got %v", tc.message, tc.target, err)
+		}
+	}
+}
+
+func TestTaskCodecRoundTrip(t *testing.T) {
+	class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+	x := feature.NewContinuousFeature("x")
+	tc := NewTaskCodec([]feature.Feature{class, x})
+	task := queue.NewTask(&tree.Node{ID: "1"}, set.New([]set.Sample{
+		set.NewSample(map[string]interface{}{"class": "yes", "x": 1.5}),
+		set.NewWeightedSample(map[string]interface{}{"class": "no"}, 2),
+	}), []feature.Feature{x})
+	task.Depth, task.Priority, task.Attempts = 2, 3, 1
+	b, err := tc.EncodeTask(context.Background(), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := tc.DecodeTask(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID() != "1" || len(decoded.AvailableFeatures) != 1 || decoded.AvailableFeatures[0] != x {
+		t.Fatalf("expected task 1 with feature x available, got %v with %v", decoded, decoded.AvailableFeatures)
+	}
+	if decoded.Depth != 2 || decoded.Priority != 3 || decoded.Attempts != 1 {
+		t.Fatalf("expected the metadata of the task, got %+v", decoded.Metadata)
+	}
+	samples, err := decoded.Set.Samples(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+	if ws, ok := samples[1].(set.WeightedSample); !ok || ws.Weight() != 2 {
+		t.Fatalf("expected the second sample to weigh 2, got %v", samples[1])
+	}
+	if _, err := tc.DecodeTask([]byte("{")); err == nil {
+		t.Fatal("expected decoding an invalid task to fail")
+	}
+}
@@ -0,0 +1,36 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+FuzzDecodeTask checks that decoding a task sent to the service does not
+panic on any input, and that the tasks decoded have a node and a set.
+*/
+func FuzzDecodeTask(f *testing.F) {
+	f.Add([]byte(`{"node":{"id":"1","featureCriterion":{"feature":"d","value":"a"}},"samples":[{"values":{"class":"yes","x":1.5}},{"values":{"class":"no","d":"b"},"weight":2}],"availableFeatures":["d","x"]}`))
+	f.Add([]byte(`{"node":null,"samples":[]}`))
+	f.Add([]byte(`{"node":{"id":"1"},"samples":[{"values":{"unknown":1}}]}`))
+	c := newCodec([]feature.Feature{
+		feature.NewDiscreteFeature("class", []string{"yes", "no"}),
+		feature.NewDiscreteFeature("d", []string{"a", "b"}),
+		feature.NewContinuousFeature("x"),
+	})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		tm := &taskMessage{}
+		if json.Unmarshal(b, tm) != nil {
+			return
+		}
+		task, err := c.decodeTask(tm)
+		if err != nil {
+			return
+		}
+		if task.Node == nil || task.Set == nil {
+			t.Fatalf("task decoded without errors from %s has no node or set", b)
+		}
+	})
+}
@@ -0,0 +1,454 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+Server is an http.Handler that serves the operations of the queue and node
+store of a tree to workers using a Client, as described in the package
+documentation.
+*/
+type Server struct {
+	// TaskTimeout, if positive, is the time a worker has
+	// to complete or drop a task it pulled before the
+	// server drops it back into the queue itself, so that
+	// the tasks of workers that die are developed by other
+	// workers. It should be well over the time it takes
+	// to develop any node, as the work done on a task
+	// dropped this way is lost: the worker that pulled it
+	// no longer holds its lease, so the tasks and nodes it
+	// pushes, creates and stores for it afterwards are
+	// ignored or rejected.
+	TaskTimeout time.Duration
+	// MaxRequestSize, if positive, is the maximum size
+	// in bytes of the body of a request, which otherwise
+	// is DefaultMaxRequestSize. As the tasks pushed by
+	// workers carry the samples of their sets, it must
+	// be over the size of the set of any task encoded
+	// as JSON.
+	MaxRequestSize int64
+	tree           *tree.Tree
+	queue          queue.Queue
+	codec          *codec
+	mux            *http.ServeMux
+	leases         map[string]*lease
+	lastLease      uint64
+	lock           *sync.Mutex
+}
+
+/*
+DefaultMaxRequestSize is the maximum size in bytes of the body of a request
+to a Server with no MaxRequestSize.
+*/
+const DefaultMaxRequestSize = 1 << 30
+
+/*
+lease is a task pulled by a worker, with the timer that drops it back into
+the queue once the server's TaskTimeout expires, if any, and the writes
+being made with it, which the timer waits for before dropping the task.
+*/
+type lease struct {
+	taskID string
+	timer  *time.Timer
+	writes sync.WaitGroup
+}
+
+/*
+NewServer takes a tree, the queue of tasks to grow it and the features it
+uses and returns a Server for workers to grow the tree. The tree's NodeStore
+is the one served to the workers, and its root node and the task to develop
+it should already be on it and on the queue, as left by botanic.Seed.
+*/
+func NewServer(t *tree.Tree, q queue.Queue, features []feature.Feature) *Server {
+	s := &Server{
+		tree:   t,
+		queue:  q,
+		codec:  newCodec(features),
+		mux:    http.NewServeMux(),
+		leases: make(map[string]*lease),
+		lock:   &sync.Mutex{},
+	}
+	s.handle(http.MethodGet, "/tree", s.getTree)
+	s.handle(http.MethodPost, "/tasks/pull", s.pullTask)
+	s.handle(http.MethodPost, "/tasks/push", s.pushTasks)
+	s.handle(http.MethodPost, "/tasks/drop", s.dropTask)
+	s.handle(http.MethodPost, "/tasks/complete", s.completeTask)
+	s.handle(http.MethodGet, "/tasks/count", s.countTasks)
+	s.handle(http.MethodGet, "/tasks/dead-letters", s.deadLetters)
+	s.handle(http.MethodPost, "/tasks/requeue", s.requeueTask)
+	s.handle(http.MethodPost, "/nodes/create", s.createNode)
+	s.handle(http.MethodPost, "/nodes/get", s.getNodes)
+	s.handle(http.MethodPost, "/nodes/store", s.storeNodes)
+	s.handle(http.MethodPost, "/nodes/delete", s.deleteNode)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+/*
+handle takes an HTTP method, a path and a function that serves a request to
+the path, returning the status and body of the response or an error, and
+registers it on the server's mux. Requests with other methods are answered
+with 405 Method Not Allowed and errors with 500 Internal Server Error, both
+with a JSON object with the error message. Reading more than the server's
+maximum request size from the body of a request fails.
+*/
+func (s *Server) handle(method, path string, f func(*http.Request) (int, interface{}, error)) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, &errorMessage{fmt.Sprintf("method %s not allowed on %s", r.Method, path)})
+			return
+		}
+		maxSize := s.MaxRequestSize
+		if maxSize <= 0 {
+			maxSize = DefaultMaxRequestSize
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		status, body, err := f(r)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &errorMessage{err.Error()})
+			return
+		}
+		writeJSON(w, status, body)
+	})
+}
+
+func (s *Server) getTree(r *http.Request) (int, interface{}, error) {
+	tm := &treeMessage{RootID: s.tree.RootID, ClassFeature: s.tree.ClassFeature.Name()}
+	if s.tree.MissingValueStrategy != tree.MissingValueUndefined {
+		tm.MissingValueStrategy = s.tree.MissingValueStrategy.String()
+	}
+	return http.StatusOK, tm, nil
+}
+
+/*
+pullTask pulls a task from the queue and answers with it and the lease of
+the worker on it, or with no content if there is none. If the task cannot
+be encoded it is dropped back into the queue.
+*/
+func (s *Server) pullTask(r *http.Request) (int, interface{}, error) {
+	task, _, err := s.queue.Pull(r.Context())
+	if err != nil {
+		return 0, nil, err
+	}
+	if task == nil {
+		return http.StatusNoContent, nil, nil
+	}
+	tm, err := s.codec.encodeTask(r.Context(), task)
+	if err != nil {
+		s.queue.Drop(context.Background(), task.ID())
+		return 0, nil, err
+	}
+	tm.Lease = s.startLease(task.ID())
+	return http.StatusOK, tm, nil
+}
+
+func (s *Server) pushTasks(r *http.Request) (int, interface{}, error) {
+	var tms []*taskMessage
+	err := readJSON(r, &tms)
+	if err != nil {
+		return 0, nil, err
+	}
+	release, held := s.holdLease(r)
+	if !held {
+		return http.StatusOK, struct{}{}, nil
+	}
+	defer release()
+	tasks := make([]*queue.Task, 0, len(tms))
+	for _, tm := range tms {
+		task, err := s.codec.decodeTask(tm)
+		if err != nil {
+			return 0, nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return http.StatusOK, struct{}{}, queue.PushAll(r.Context(), s.queue, tasks)
+}
+
+func (s *Server) dropTask(r *http.Request) (int, interface{}, error) {
+	var im idMessage
+	err := readJSON(r, &im)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !s.endLease(r, im.ID) {
+		return http.StatusOK, struct{}{}, nil
+	}
+	return http.StatusOK, struct{}{}, s.queue.Drop(r.Context(), im.ID)
+}
+
+func (s *Server) completeTask(r *http.Request) (int, interface{}, error) {
+	var im idMessage
+	err := readJSON(r, &im)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !s.endLease(r, im.ID) {
+		return http.StatusOK, struct{}{}, nil
+	}
+	return http.StatusOK, struct{}{}, s.queue.Complete(r.Context(), im.ID)
+}
+
+func (s *Server) countTasks(r *http.Request) (int, interface{}, error) {
+	pending, running, err := s.queue.Count(r.Context())
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, &countMessage{pending, running}, nil
+}
+
+/*
+deadLetters answers with the tasks kept as dead letters by the queue, if it
+is a queue.DeadLetterQueue, without the samples of their sets.
+*/
+func (s *Server) deadLetters(r *http.Request) (int, interface{}, error) {
+	tms := []*taskMessage{}
+	dlq, ok := s.queue.(queue.DeadLetterQueue)
+	if !ok {
+		return http.StatusOK, tms, nil
+	}
+	tasks, err := dlq.DeadLetters(r.Context())
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, t := range tasks {
+		tm, err := s.codec.encodeTaskWithoutSamples(t)
+		if err != nil {
+			return 0, nil, err
+		}
+		tms = append(tms, tm)
+	}
+	return http.StatusOK, tms, nil
+}
+
+/*
+requeueTask requeues the dead letter with the given ID, answering with 404
+Not Found if the queue has no such dead letter or is no
+queue.DeadLetterQueue.
+*/
+func (s *Server) requeueTask(r *http.Request) (int, interface{}, error) {
+	var im idMessage
+	err := readJSON(r, &im)
+	if err != nil {
+		return 0, nil, err
+	}
+	dlq, ok := s.queue.(queue.DeadLetterQueue)
+	if !ok {
+		return http.StatusNotFound, &errorMessage{fmt.Sprintf("dead letter %v: %s", queue.ErrTaskNotFound, im.ID)}, nil
+	}
+	err = dlq.Requeue(r.Context(), im.ID)
+	if errors.Is(err, queue.ErrTaskNotFound) {
+		return http.StatusNotFound, &errorMessage{err.Error()}, nil
+	}
+	return http.StatusOK, struct{}{}, err
+}
+
+func (s *Server) createNode(r *http.Request) (int, interface{}, error) {
+	var b json.RawMessage
+	err := readJSON(r, &b)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := s.codec.decodeNode(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n == nil {
+		return 0, nil, fmt.Errorf("creating node: no node")
+	}
+	release, held := s.holdLease(r)
+	if !held {
+		return http.StatusConflict, &errorMessage{"lease ended"}, nil
+	}
+	defer release()
+	n.ID = ""
+	err = s.tree.NodeStore.Create(r.Context(), n)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, &idMessage{n.ID}, nil
+}
+
+func (s *Server) getNodes(r *http.Request) (int, interface{}, error) {
+	var im idsMessage
+	err := readJSON(r, &im)
+	if err != nil {
+		return 0, nil, err
+	}
+	nodes, err := tree.GetNodes(r.Context(), s.tree.NodeStore, im.IDs)
+	if err != nil {
+		return 0, nil, err
+	}
+	encoded := make([]json.RawMessage, len(nodes))
+	for i, n := range nodes {
+		if n == nil {
+			encoded[i] = json.RawMessage("null")
+			continue
+		}
+		encoded[i], err = s.codec.encodeNode(n)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return http.StatusOK, encoded, nil
+}
+
+func (s *Server) storeNodes(r *http.Request) (int, interface{}, error) {
+	var encoded []json.RawMessage
+	err := readJSON(r, &encoded)
+	if err != nil {
+		return 0, nil, err
+	}
+	nodes := make([]*tree.Node, 0, len(encoded))
+	for _, b := range encoded {
+		n, err := s.codec.decodeNode(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n == nil {
+			return 0, nil, fmt.Errorf("storing nodes: null node")
+		}
+		nodes = append(nodes, n)
+	}
+	release, held := s.holdLease(r)
+	if !held {
+		return http.StatusOK, struct{}{}, nil
+	}
+	defer release()
+	return http.StatusOK, struct{}{}, tree.StoreNodes(r.Context(), s.tree.NodeStore, nodes)
+}
+
+func (s *Server) deleteNode(r *http.Request) (int, interface{}, error) {
+	var im idMessage
+	err := readJSON(r, &im)
+	if err != nil {
+		return 0, nil, err
+	}
+	release, held := s.holdLease(r)
+	if !held {
+		return http.StatusOK, struct{}{}, nil
+	}
+	defer release()
+	return http.StatusOK, struct{}{}, s.tree.NodeStore.Delete(r.Context(), &tree.Node{ID: im.ID})
+}
+
+/*
+startLease takes the ID of a task pulled by a worker and returns a new lease
+for the worker on it. If the server has a TaskTimeout, the lease ends once
+it expires, and the task is dropped back into the queue once the writes
+being made with the lease are done.
+*/
+func (s *Server) startLease(id string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastLease++
+	token := strconv.FormatUint(s.lastLease, 10)
+	l := &lease{taskID: id}
+	if s.TaskTimeout > 0 {
+		l.timer = time.AfterFunc(s.TaskTimeout, func() {
+			s.lock.Lock()
+			_, held := s.leases[token]
+			delete(s.leases, token)
+			s.lock.Unlock()
+			if held {
+				l.writes.Wait()
+				s.queue.Drop(context.Background(), id)
+			}
+		})
+	}
+	s.leases[token] = l
+	return token
+}
+
+/*
+holdLease takes a request to write on behalf of a task and returns whether
+the lease it was sent with, if any, is still held and, if so, a function to
+call once the write is done. Until it is called, a lease whose TaskTimeout
+expires does not drop its task back into the queue, so that the task is not
+pulled again while the write is being made. Requests sent with no lease,
+which are not made on behalf of a task, are taken as held.
+*/
+func (s *Server) holdLease(r *http.Request) (func(), bool) {
+	token := r.Header.Get(leaseHeader)
+	if token == "" {
+		return func() {}, true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	l, held := s.leases[token]
+	if !held {
+		return nil, false
+	}
+	l.writes.Add(1)
+	return l.writes.Done, true
+}
+
+/*
+endLease takes a request to complete or drop the task with the given ID
+and ends the lease it was sent with, stopping its timer. It returns false if
+the lease was no longer held or is for another task, in which case the
+request must be ignored. Requests sent with no lease end every lease on the
+task.
+*/
+func (s *Server) endLease(r *http.Request, id string) bool {
+	token := r.Header.Get(leaseHeader)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if token == "" {
+		for t, l := range s.leases {
+			if l.taskID == id {
+				s.stopLease(t)
+			}
+		}
+		return true
+	}
+	l, held := s.leases[token]
+	if !held || l.taskID != id {
+		return false
+	}
+	s.stopLease(token)
+	return true
+}
+
+/*
+stopLease takes a lease and stops its timer, if any, and removes it from
+the leases held. It must be called with the server's lock held.
+*/
+func (s *Server) stopLease(token string) {
+	if l := s.leases[token]; l.timer != nil {
+		l.timer.Stop()
+	}
+	delete(s.leases, token)
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding request to %s: %v", r.URL.Path, err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,132 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/queue/queuetest"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+func TestServerIgnoresCallsWithExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+	features := []feature.Feature{class}
+	samples := []set.Sample{set.NewSample(map[string]interface{}{"class": "yes"})}
+	ns := tree.NewMemoryNodeStore()
+	root := &tree.Node{}
+	if err := ns.Create(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	q := queue.New()
+	if err := q.Push(ctx, queue.NewTask(root, set.New(samples), nil)); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(tree.New(root.ID, ns, class), q, features)
+	server.TaskTimeout = 500 * time.Millisecond
+	hs := httptest.NewServer(server)
+	defer hs.Close()
+
+	stale := NewClient(hs.URL, features).Queue()
+	task, staleCtx, err := stale.Pull(ctx)
+	if err != nil || task == nil {
+		t.Fatalf("pulling task: %v, %v", task, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		pending, _, err := q.Count(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("task was not dropped back into the queue once its timeout expired")
+		}
+	}
+	current := NewClient(hs.URL, features).Queue()
+	task, currentCtx, err := current.Pull(ctx)
+	if err != nil || task == nil {
+		t.Fatalf("pulling task again: %v, %v", task, err)
+	}
+
+	subtask := queue.NewTask(&tree.Node{ID: "2", ParentID: root.ID}, set.New(samples), nil)
+	if err := stale.(queue.BatchQueue).PushAll(staleCtx, []*queue.Task{subtask}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Complete(staleCtx, task.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Drop(staleCtx, task.ID()); err != nil {
+		t.Fatal(err)
+	}
+	orphan := &tree.Node{ParentID: root.ID}
+	var lee *LeaseEndedError
+	if err := NewClient(hs.URL, features).NodeStore().Create(staleCtx, orphan); !errors.As(err, &lee) {
+		t.Fatalf("expected creating a node with an expired lease to fail with a LeaseEndedError, got node %q and error %v", orphan.ID, err)
+	}
+	if pending, running, _ := q.Count(ctx); pending != 0 || running != 1 {
+		t.Fatalf("expected calls with an expired lease to be ignored, got %d pending and %d running tasks", pending, running)
+	}
+
+	if err := current.Complete(currentCtx, task.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if pending, running, _ := q.Count(ctx); pending != 0 || running != 0 {
+		t.Fatalf("expected the task to be completed, got %d pending and %d running tasks", pending, running)
+	}
+}
+
+func TestServerDropsTasksOnceWritesWithTheirLeaseAreDone(t *testing.T) {
+	ctx := context.Background()
+	class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+	root := &tree.Node{ID: "1"}
+	q := queue.New()
+	if err := q.Push(ctx, queue.NewTask(root, set.New(nil), nil)); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(tree.New(root.ID, tree.NewMemoryNodeStore(), class), q, []feature.Feature{class})
+	server.TaskTimeout = 50 * time.Millisecond
+	task, _, err := q.Pull(ctx)
+	if err != nil || task == nil {
+		t.Fatalf("pulling task: %v, %v", task, err)
+	}
+	r := httptest.NewRequest("POST", "/nodes/store", nil)
+	r.Header.Set(leaseHeader, server.startLease(task.ID()))
+	release, held := server.holdLease(r)
+	if !held {
+		t.Fatal("expected the lease to be held")
+	}
+	time.Sleep(4 * server.TaskTimeout)
+	if _, held := server.holdLease(r); held {
+		t.Fatal("expected the lease to end once its timeout expired")
+	}
+	if pending, _, _ := q.Count(ctx); pending != 0 {
+		t.Fatal("expected the task not to be dropped while a write with its lease is being made")
+	}
+	release()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if pending, _, _ := q.Count(ctx); pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("task was not dropped back into the queue once the write was done")
+		}
+	}
+}
+
+func TestClientQueue(t *testing.T) {
+	queuetest.Run(t, func(maxAttempts int) (queue.Queue, error) {
+		class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+		q := queue.NewWithMaxAttempts(maxAttempts)
+		hs := httptest.NewServer(NewServer(tree.New("", tree.NewMemoryNodeStore(), class), q, []feature.Feature{class}))
+		t.Cleanup(hs.Close)
+		return NewClient(hs.URL, []feature.Feature{class}).Queue(), nil
+	})
+}
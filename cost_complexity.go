@@ -0,0 +1,247 @@
+package botanic
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+ccNode holds the information on a tree node needed to compute its cost
+complexity.
+*/
+type ccNode struct {
+	id        string
+	subtrees  []*ccNode
+	leafError float64
+	// undefined is whether the node is the subtree for samples with
+	// an undefined value that is grown with the whole set of its parent.
+	undefined bool
+	// alpha is the value of the complexity parameter from which the
+	// node is turned into a leaf, +Inf if it is never collapsed.
+	alpha float64
+}
+
+/*
+CostComplexityAlphas takes a context and a fully-grown tree and returns the
+increasing sequence of values for the complexity parameter alpha at which
+weakest link pruning collapses nodes of the tree, as defined for CART's
+cost-complexity pruning. The error rate of a node used to compute the
+sequence is the proportion of training samples, over the training samples
+at the root of the tree, misclassified by the node's prediction. Pruning
+the tree with any value between two consecutive alphas of the sequence
+produces the same tree.
+
+An error is returned if the nodes of the tree cannot be retrieved from its
+node store.
+*/
+func CostComplexityAlphas(ctx context.Context, t *tree.Tree) ([]float64, error) {
+	_, alphas, err := costComplexity(ctx, t)
+	return alphas, err
+}
+
+/*
+CostComplexityPrune takes a context, a fully-grown tree and a value for the
+complexity parameter alpha, and turns into a leaf every node of the tree
+whose cost complexity with that alpha is not improved by its subtrees, as
+CART's cost-complexity pruning does. The nodes under a collapsed node are
+deleted from the tree's node store.
+
+It returns the number of nodes deleted from the tree or an error if the tree
+cannot be traversed or its node store cannot be updated.
+*/
+func CostComplexityPrune(ctx context.Context, t *tree.Tree, alpha float64) (int, error) {
+	nodes, _, err := costComplexity(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int
+	err = t.Traverse(ctx, false, func(ctx context.Context, n *tree.Node) error {
+		ccn, ok := nodes[n.ID]
+		if !ok || len(n.SubtreeIDs) == 0 || ccn.alpha > alpha {
+			return nil
+		}
+		count, err := collapse(ctx, t, n)
+		deleted += count
+		return err
+	})
+	return deleted, err
+}
+
+/*
+SelectCostComplexityAlpha takes a context, a fully-grown tree and a validation
+set and returns the value for the complexity parameter alpha, among those
+returned by CostComplexityAlphas and 0, that produces the pruned tree with the
+highest success rate predicting the samples in the validation set. Ties are
+resolved in favour of the highest alpha, which produces the smallest tree.
+
+An error is returned if the validation set cannot be read or the tree cannot
+be traversed.
+*/
+func SelectCostComplexityAlpha(ctx context.Context, t *tree.Tree, validation set.Set) (float64, error) {
+	nodes, alphas, err := costComplexity(ctx, t)
+	if err != nil {
+		return 0.0, err
+	}
+	candidates := append([]float64{0.0}, alphas...)
+	hits := make([]int, len(candidates))
+	samples, err := validation.Samples(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+	for _, s := range samples {
+		v, err := s.ValueFor(t.ClassFeature)
+		if err != nil {
+			return 0.0, err
+		}
+		path, err := t.Path(ctx, s)
+		if err != nil {
+			return 0.0, err
+		}
+		for i, alpha := range candidates {
+			n := predictingNode(path, nodes, alpha)
+			if n == nil || n.Prediction == nil {
+				continue
+			}
+			pv, _ := n.Prediction.PredictedValue()
+			if pv == v {
+				hits[i]++
+			}
+		}
+	}
+	var selected int
+	for i := range candidates {
+		if hits[i] >= hits[selected] {
+			selected = i
+		}
+	}
+	return candidates[selected], nil
+}
+
+/*
+predictingNode takes the path of nodes a sample goes through on a tree,
+the cost complexity information of the tree nodes and a value for alpha
+and returns the node whose prediction applies to the sample on the tree
+pruned with that alpha, or nil if there is none.
+*/
+func predictingNode(path []*tree.Node, nodes map[string]*ccNode, alpha float64) *tree.Node {
+	for _, n := range path {
+		if ccn, ok := nodes[n.ID]; ok && ccn.alpha <= alpha {
+			return n
+		}
+	}
+	n := path[len(path)-1]
+	if n.SubtreeFeature != nil {
+		return nil
+	}
+	return n
+}
+
+/*
+costComplexity takes a context and a tree and runs weakest link pruning on
+an in-memory copy of its structure. It returns the information of every node
+indexed by ID, including the alpha from which it is collapsed, and the
+increasing sequence of alphas at which nodes are collapsed.
+*/
+func costComplexity(ctx context.Context, t *tree.Tree) (map[string]*ccNode, []float64, error) {
+	nodes := make(map[string]*ccNode)
+	var root *ccNode
+	err := t.Traverse(ctx, true, func(ctx context.Context, n *tree.Node) error {
+		ccn := &ccNode{id: n.ID, alpha: math.Inf(1)}
+		_, ccn.undefined = n.FeatureCriterion.(feature.UndefinedCriterion)
+		if n.Prediction != nil {
+			_, prob := n.Prediction.PredictedValue()
+			ccn.leafError = float64(n.Prediction.Weight()) * (1.0 - prob)
+		}
+		for _, id := range n.SubtreeIDs {
+			sn, ok := nodes[id]
+			if !ok {
+				return fmt.Errorf("%w: %s under node %s", tree.ErrNodeNotFound, id, n.ID)
+			}
+			ccn.subtrees = append(ccn.subtrees, sn)
+		}
+		nodes[n.ID] = ccn
+		root = ccn
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == nil {
+		return nodes, nil, nil
+	}
+	rootNode, err := t.NodeStore.Get(ctx, t.RootID)
+	if err != nil {
+		return nil, nil, err
+	}
+	total := 1.0
+	if rootNode.Prediction != nil && rootNode.Prediction.Weight() > 0 {
+		total = float64(rootNode.Prediction.Weight())
+	}
+	var alphas []float64
+	for len(root.subtrees) > 0 && math.IsInf(root.alpha, 1) {
+		if err = ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		var weakest []*ccNode
+		minG := math.Inf(1)
+		root.weakestLinks(total, &weakest, &minG)
+		alpha := math.Max(minG, 0.0)
+		if len(alphas) > 0 {
+			alpha = math.Max(alpha, alphas[len(alphas)-1])
+		}
+		for _, ccn := range weakest {
+			ccn.alpha = alpha
+		}
+		if len(alphas) == 0 || alphas[len(alphas)-1] != alpha {
+			alphas = append(alphas, alpha)
+		}
+	}
+	return nodes, alphas, nil
+}
+
+/*
+weakestLinks takes the total number of training samples, a pointer to a slice
+of nodes and a pointer to a minimum, computes the cost complexity of the
+subtree under the node and returns its error rate and number of leaves. The
+slice is updated to hold the nodes in the subtree with the minimum value found
+so far for g(t), the increase in error rate per leaf pruned when collapsing
+node t, and the minimum is updated to that value.
+
+Subtrees for undefined values grown with the whole set of their parent, as
+UndefinedPolicyParent does, add no error to the subtree of their parent: no
+training sample is routed to them, and counting the error of the parent's
+whole set twice would make every split look worse than its node.
+*/
+func (ccn *ccNode) weakestLinks(total float64, weakest *[]*ccNode, minG *float64) (float64, int) {
+	leafRate := ccn.leafError / total
+	if len(ccn.subtrees) == 0 || !math.IsInf(ccn.alpha, 1) {
+		return leafRate, 1
+	}
+	var subtreeRate float64
+	var leaves int
+	for _, st := range ccn.subtrees {
+		r, l := st.weakestLinks(total, weakest, minG)
+		if !st.undefined {
+			subtreeRate += r
+		}
+		leaves += l
+	}
+	pruned := leaves - 1
+	if pruned < 1 {
+		pruned = 1
+	}
+	g := (leafRate - subtreeRate) / float64(pruned)
+	switch {
+	case g < *minG-1e-12:
+		*minG = g
+		*weakest = []*ccNode{ccn}
+	case g <= *minG+1e-12:
+		*weakest = append(*weakest, ccn)
+	}
+	return subtreeRate, leaves
+}
@@ -0,0 +1,44 @@
+package botanic
+
+import "fmt"
+
+/*
+DiscreteSplit determines how the set of a node is split with a discrete
+feature when the node is branched out on it.
+*/
+type DiscreteSplit int
+
+const (
+	// DiscreteSplitMultiway splits the set into a subset for every
+	// available value of the feature. This is the default.
+	DiscreteSplitMultiway DiscreteSplit = iota
+	// DiscreteSplitBinary splits the set in two: the samples with a value
+	// among the subset of values of the feature that gives the most
+	// information gain and the samples with any other value.
+	DiscreteSplitBinary
+)
+
+var discreteSplitNames = map[DiscreteSplit]string{
+	DiscreteSplitMultiway: "multiway",
+	DiscreteSplitBinary:   "binary",
+}
+
+/*
+ParseDiscreteSplit takes a string and returns the DiscreteSplit it names or
+an error if it names none. Valid names are "multiway" and "binary".
+*/
+func ParseDiscreteSplit(name string) (DiscreteSplit, error) {
+	for ds, n := range discreteSplitNames {
+		if n == name {
+			return ds, nil
+		}
+	}
+	return DiscreteSplitMultiway, fmt.Errorf("unknown discrete split '%s'", name)
+}
+
+func (ds DiscreteSplit) String() string {
+	if n, ok := discreteSplitNames[ds]; ok {
+		return n
+	}
+	return fmt.Sprintf("DiscreteSplit(%d)", int(ds))
+}
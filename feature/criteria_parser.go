@@ -0,0 +1,301 @@
+package feature
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenName
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+/*
+ParseCriteria takes an expression and a slice of features and returns the
+criteria the expression stands for, all of which a sample must satisfy to
+satisfy the expression, or an error if the expression is not valid for the
+features.
+
+The expression is a list of conditions joined by AND, such as
+"age >= 30 AND country is 'ES'". Every condition starts with the name of a
+feature, within double quotes if it has spaces or symbols, followed by:
+  - = or is and a value, satisfied by samples with that value for the
+    feature. Values are numbers for continuous features, true or false for
+    boolean features and values of discrete features, within single quotes
+    if they have spaces or symbols;
+  - <, <=, > or >= and a number, for continuous features only;
+  - in and a list of values within parentheses separated by commas, such as
+    "country in ('ES', 'PT')", for discrete features only;
+  - is undefined, satisfied by samples with no value for the feature.
+
+Keywords are not case sensitive, and the values of discrete features are
+hashed with their HashValue method, so that they can be given for features
+that hash their values.
+*/
+func ParseCriteria(expr string, features []Feature) ([]Criterion, error) {
+	tokens, err := tokenizeCriteria(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing criteria %q: %w", expr, err)
+	}
+	byName := make(map[string]Feature, len(features))
+	for _, f := range features {
+		byName[f.Name()] = f
+	}
+	p := &criteriaParser{tokens: tokens, features: byName}
+	var criteria []Criterion
+	for {
+		c, err := p.condition()
+		if err != nil {
+			return nil, fmt.Errorf("parsing criteria %q: %w", expr, err)
+		}
+		criteria = append(criteria, c)
+		if p.done() {
+			return criteria, nil
+		}
+		if t := p.next(); t.kind != tokenWord || !strings.EqualFold(t.text, "and") {
+			return nil, fmt.Errorf("parsing criteria %q: expected AND, got %s", expr, t.text)
+		}
+	}
+}
+
+/*
+tokenizeCriteria takes an expression and splits it into tokens, or returns
+an error if a quoted name or value is not closed.
+*/
+func tokenizeCriteria(expr string) ([]token, error) {
+	var tokens []token
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLeftParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRightParen, ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ","})
+			i++
+		case r == '\'' || r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				j++
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("unterminated %c", r)
+			}
+			kind := tokenString
+			if r == '"' {
+				kind = tokenName
+			}
+			tokens = append(tokens, token{kind, string(rs[i+1 : j])})
+			i = j + 1
+		case strings.ContainsRune("<>=", r):
+			j := i + 1
+			if j < len(rs) && rs[j] == '=' {
+				j++
+			}
+			tokens = append(tokens, token{tokenOperator, string(rs[i:j])})
+			i = j
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("()',\"<>=", rs[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, string(rs[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type criteriaParser struct {
+	tokens   []token
+	i        int
+	features map[string]Feature
+}
+
+func (p *criteriaParser) done() bool {
+	return p.i >= len(p.tokens)
+}
+
+func (p *criteriaParser) next() token {
+	if p.done() {
+		return token{tokenWord, "end of expression"}
+	}
+	t := p.tokens[p.i]
+	p.i++
+	return t
+}
+
+/*
+value takes the next token as a value and returns it, or an error if it is
+not a bare word or a quoted value.
+*/
+func (p *criteriaParser) value() (string, error) {
+	t := p.next()
+	if t.kind != tokenWord && t.kind != tokenString {
+		return "", fmt.Errorf("expected a value, got %s", t.text)
+	}
+	return t.text, nil
+}
+
+/*
+condition parses the next condition of the expression and returns its
+criterion, or an error if it is not valid.
+*/
+func (p *criteriaParser) condition() (Criterion, error) {
+	t := p.next()
+	if t.kind != tokenWord && t.kind != tokenName {
+		return nil, fmt.Errorf("expected a feature name, got %s", t.text)
+	}
+	f, ok := p.features[t.text]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownFeature, t.text)
+	}
+	op := p.next()
+	switch {
+	case op.kind == tokenOperator && (op.text == "=" || op.text == "=="):
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return equalityCriterion(f, v)
+	case op.kind == tokenOperator:
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return comparisonCriterion(f, op.text, v)
+	case op.kind == tokenWord && strings.EqualFold(op.text, "is"):
+		if !p.done() && p.tokens[p.i].kind == tokenWord && strings.EqualFold(p.tokens[p.i].text, "undefined") {
+			p.i++
+			return NewUndefinedValueCriterion(f), nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return equalityCriterion(f, v)
+	case op.kind == tokenWord && strings.EqualFold(op.text, "in"):
+		df, ok := f.(*DiscreteFeature)
+		if !ok {
+			return nil, fmt.Errorf("in cannot be used with feature %s, which is not discrete", f.Name())
+		}
+		if t := p.next(); t.kind != tokenLeftParen {
+			return nil, fmt.Errorf("expected ( after in, got %s", t.text)
+		}
+		var values []string
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v, err = discreteValue(df, v)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			t := p.next()
+			if t.kind == tokenRightParen {
+				break
+			}
+			if t.kind != tokenComma {
+				return nil, fmt.Errorf("expected , or ) in list of values, got %s", t.text)
+			}
+		}
+		return NewDiscreteValuesCriterion(df, values), nil
+	}
+	return nil, fmt.Errorf("expected an operator after feature %s, got %s", f.Name(), op.text)
+}
+
+/*
+equalityCriterion takes a feature and a value and returns the criterion
+satisfied by the samples with the value for the feature, or an error if the
+value is not valid for the feature.
+*/
+func equalityCriterion(f Feature, v string) (Criterion, error) {
+	switch tf := f.(type) {
+	case *DiscreteFeature:
+		v, err := discreteValue(tf, v)
+		if err != nil {
+			return nil, err
+		}
+		return NewDiscreteCriterion(tf, v), nil
+	case *ContinuousFeature:
+		fv, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %s for continuous feature %s", v, tf.Name())
+		}
+		return NewContinuousCriterion(tf, fv, math.Nextafter(fv, math.Inf(1))), nil
+	case *BooleanFeature:
+		bv, err := ParseBoolean(v)
+		if err != nil {
+			return nil, fmt.Errorf("boolean feature %s: %v", tf.Name(), err)
+		}
+		return NewBooleanCriterion(tf, bv), nil
+	}
+	return nil, fmt.Errorf("cannot compare values of feature %s of type %T", f.Name(), f)
+}
+
+/*
+comparisonCriterion takes a continuous feature, a comparison operator and a
+number and returns the criterion satisfied by the samples whose value for
+the feature compares that way to the number, or an error if the feature is
+not continuous or the number is not valid.
+*/
+func comparisonCriterion(f Feature, op, v string) (Criterion, error) {
+	cf, ok := f.(*ContinuousFeature)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot be used with feature %s, which is not continuous", op, f.Name())
+	}
+	fv, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %s for continuous feature %s", v, cf.Name())
+	}
+	a, b := math.Inf(-1), math.Inf(1)
+	switch op {
+	case "<":
+		b = fv
+	case "<=":
+		b = math.Nextafter(fv, math.Inf(1))
+	case ">":
+		a = math.Nextafter(fv, math.Inf(1))
+	case ">=":
+		a = fv
+	default:
+		return nil, fmt.Errorf("unknown operator %s", op)
+	}
+	return NewContinuousCriterion(cf, a, b), nil
+}
+
+/*
+discreteValue takes a discrete feature and a value and returns the value
+hashed with the HashValue method of the feature, or an error if it is not
+one of the available values of the feature.
+*/
+func discreteValue(df *DiscreteFeature, v string) (string, error) {
+	v = df.HashValue(v)
+	if _, err := df.Valid(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
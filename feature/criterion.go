@@ -3,6 +3,7 @@ package feature
 import (
 	"fmt"
 	"math"
+	"strings"
 )
 
 /*
@@ -53,6 +54,29 @@ type DiscreteCriterion interface {
 	Value() string
 }
 
+/*
+DiscreteValuesCriterion represents a constraint on a discrete feature, a
+set of values it may take.
+
+Its Values method returns the values to which the feature is constrained as
+a slice of strings.
+*/
+type DiscreteValuesCriterion interface {
+	Criterion
+	Values() []string
+}
+
+/*
+BooleanCriterion represents a constraint on a boolean feature, the value it
+must take.
+
+Its Value method returns the value to which the feature is constrained.
+*/
+type BooleanCriterion interface {
+	Criterion
+	Value() bool
+}
+
 /*
 UndefinedCriterion represents the lack of constraint on a specific feature.
 */
@@ -61,20 +85,59 @@ type UndefinedCriterion interface {
 	IsUndefinedCriterion() bool
 }
 
+/*
+UndefinedValueCriterion represents the constraint of a sample not having
+a value defined for a specific feature.
+*/
+type UndefinedValueCriterion interface {
+	Criterion
+	IsUndefinedValueCriterion() bool
+}
+
+/*
+UndefinedInclusiveCriterion represents a criterion that may also be
+satisfied by samples that have no value defined for its feature.
+
+Its IncludesUndefined method returns true when samples with no value
+defined for the feature satisfy the criterion.
+*/
+type UndefinedInclusiveCriterion interface {
+	Criterion
+	IncludesUndefined() bool
+}
+
 type continuousCriterion struct {
-	feature *ContinuousFeature
-	a, b    float64
+	feature          *ContinuousFeature
+	a, b             float64
+	includeUndefined bool
 }
 
 type discreteCriterion struct {
-	feature *DiscreteFeature
-	value   string
+	feature          *DiscreteFeature
+	value            string
+	includeUndefined bool
+}
+
+type discreteValuesCriterion struct {
+	feature          *DiscreteFeature
+	values           []string
+	includeUndefined bool
+}
+
+type booleanCriterion struct {
+	feature          *BooleanFeature
+	value            bool
+	includeUndefined bool
 }
 
 type undefinedCriterion struct {
 	feature Feature
 }
 
+type undefinedValueCriterion struct {
+	feature Feature
+}
+
 /*
 NewContinuousCriterion takes a ContinuousFeature feature and a pair of
 float64 values indicating the start and the end of an interval and return a
@@ -82,7 +145,7 @@ ContinuousCriterion with the feature and interval. The interval can be
 open on any end by providing -Inf and/or +Inf.
 */
 func NewContinuousCriterion(feature *ContinuousFeature, a float64, b float64) ContinuousCriterion {
-	return &continuousCriterion{feature, a, b}
+	return &continuousCriterion{feature: feature, a: a, b: b}
 }
 
 /*
@@ -92,7 +155,25 @@ DiscreteCriterion with the feature and interval. The interval can be
 open on any end by providing -Inf and/or +Inf.
 */
 func NewDiscreteCriterion(feature *DiscreteFeature, value string) DiscreteCriterion {
-	return &discreteCriterion{feature, value}
+	return &discreteCriterion{feature: feature, value: value}
+}
+
+/*
+NewDiscreteValuesCriterion takes a DiscreteFeature feature and a slice of
+string values and returns a DiscreteValuesCriterion satisfied by samples
+taking any of the values for the feature.
+*/
+func NewDiscreteValuesCriterion(feature *DiscreteFeature, values []string) DiscreteValuesCriterion {
+	return &discreteValuesCriterion{feature: feature, values: values}
+}
+
+/*
+NewBooleanCriterion takes a BooleanFeature feature and a bool value and
+returns a BooleanCriterion satisfied by samples taking the value for the
+feature.
+*/
+func NewBooleanCriterion(feature *BooleanFeature, value bool) BooleanCriterion {
+	return &booleanCriterion{feature: feature, value: value}
 }
 
 /*
@@ -103,6 +184,45 @@ func NewUndefinedCriterion(f Feature) UndefinedCriterion {
 	return &undefinedCriterion{f}
 }
 
+/*
+NewUndefinedValueCriterion takes a Feature and returns a Criterion that
+is only satisfied by samples that have no value defined for it.
+*/
+func NewUndefinedValueCriterion(f Feature) UndefinedValueCriterion {
+	return &undefinedValueCriterion{f}
+}
+
+/*
+IncludingUndefined takes a Criterion and returns an equivalent one that is
+also satisfied by samples that have no value defined for its feature. Only
+criteria created with NewContinuousCriterion, NewDiscreteCriterion,
+NewDiscreteValuesCriterion and NewBooleanCriterion can be extended this way,
+any other criterion is returned unchanged.
+*/
+func IncludingUndefined(c Criterion) Criterion {
+	switch c := c.(type) {
+	case *continuousCriterion:
+		return &continuousCriterion{feature: c.feature, a: c.a, b: c.b, includeUndefined: true}
+	case *discreteCriterion:
+		return &discreteCriterion{feature: c.feature, value: c.value, includeUndefined: true}
+	case *discreteValuesCriterion:
+		return &discreteValuesCriterion{feature: c.feature, values: c.values, includeUndefined: true}
+	case *booleanCriterion:
+		return &booleanCriterion{feature: c.feature, value: c.value, includeUndefined: true}
+	}
+	return c
+}
+
+/*
+IncludesUndefined takes a Criterion and returns whether it is satisfied by
+samples that have no value defined for its feature, other than by being an
+UndefinedCriterion or an UndefinedValueCriterion.
+*/
+func IncludesUndefined(c Criterion) bool {
+	uic, ok := c.(UndefinedInclusiveCriterion)
+	return ok && uic.IncludesUndefined()
+}
+
 /*
 Feature returns the feature to which the constraint applies.
 */
@@ -113,8 +233,9 @@ func (cfc *continuousCriterion) Feature() Feature {
 /*
 SatisfiedBy receives a sample as parameter and returns a boolean indicating if the
 sample satisfies the criterion. Specifically, it returns false if the sample does
-not define a value for the feature, true if the value, being a float64, is in the
-range defined by the criterion; and false otherwise.
+not define a value for the feature (unless the criterion includes undefined values),
+true if the value, being a float64, is in the range defined by the criterion; and
+false otherwise.
 */
 func (cfc *continuousCriterion) SatisfiedBy(sample Sample) (bool, error) {
 	val, err := sample.ValueFor(cfc.feature)
@@ -122,7 +243,7 @@ func (cfc *continuousCriterion) SatisfiedBy(sample Sample) (bool, error) {
 		return false, err
 	}
 	if val == nil {
-		return false, nil
+		return cfc.includeUndefined, nil
 	}
 	floatVal, ok := val.(float64)
 	if !ok {
@@ -135,14 +256,23 @@ func (cfc *continuousCriterion) Interval() (float64, float64) {
 	return cfc.a, cfc.b
 }
 
+func (cfc *continuousCriterion) IncludesUndefined() bool {
+	return cfc.includeUndefined
+}
+
 func (cfc *continuousCriterion) String() string {
+	var result string
 	if math.IsInf(cfc.a, 0) {
-		return fmt.Sprintf("%s < %f", cfc.feature.Name(), cfc.b)
+		result = fmt.Sprintf("%s < %f", cfc.feature.Name(), cfc.b)
+	} else if math.IsInf(cfc.b, 0) {
+		result = fmt.Sprintf("%f <= %s", cfc.a, cfc.feature.Name())
+	} else {
+		result = fmt.Sprintf("%f <= %s < %f", cfc.a, cfc.feature.Name(), cfc.b)
 	}
-	if math.IsInf(cfc.b, 0) {
-		return fmt.Sprintf("%f <= %s", cfc.a, cfc.feature.Name())
+	if cfc.includeUndefined {
+		result = fmt.Sprintf("%s or not defined", result)
 	}
-	return fmt.Sprintf("%f <= %s < %f", cfc.a, cfc.feature.Name(), cfc.b)
+	return result
 }
 
 /*
@@ -155,8 +285,9 @@ func (dfc *discreteCriterion) Feature() Feature {
 /*
 SatisfiedBy receives a sample as parameter and returns a boolean indicating if the
 sample satisfies the criterion. Specifically, it returns false if the sample does
-not define a value for the feature, true if the value, being a string, equals the
-value on the criterion; and false otherwise.
+not define a value for the feature (unless the criterion includes undefined values),
+true if the value, being a string, equals the value on the criterion once hashed
+with the feature's HashValue; and false otherwise.
 */
 func (dfc *discreteCriterion) SatisfiedBy(sample Sample) (bool, error) {
 	val, err := sample.ValueFor(dfc.feature)
@@ -164,23 +295,125 @@ func (dfc *discreteCriterion) SatisfiedBy(sample Sample) (bool, error) {
 		return false, err
 	}
 	if val == nil {
-		return false, nil
+		return dfc.includeUndefined, nil
 	}
 	stringVal, ok := val.(string)
 	if !ok {
 		return false, nil
 	}
-	return dfc.value == stringVal, nil
+	return dfc.value == dfc.feature.HashValue(stringVal), nil
 }
 
 func (dfc *discreteCriterion) Value() string {
 	return dfc.value
 }
 
+func (dfc *discreteCriterion) IncludesUndefined() bool {
+	return dfc.includeUndefined
+}
+
 func (dfc *discreteCriterion) String() string {
+	if dfc.includeUndefined {
+		return fmt.Sprintf("%s is %s or not defined", dfc.feature.Name(), dfc.value)
+	}
 	return fmt.Sprintf("%s is %s", dfc.feature.Name(), dfc.value)
 }
 
+/*
+Feature returns the feature to which the constraint applies.
+*/
+func (dvc *discreteValuesCriterion) Feature() Feature {
+	return dvc.feature
+}
+
+/*
+SatisfiedBy receives a sample as parameter and returns a boolean indicating if the
+sample satisfies the criterion. Specifically, it returns false if the sample does
+not define a value for the feature (unless the criterion includes undefined values),
+true if the value, being a string, equals any of the values on the criterion once
+hashed with the feature's HashValue; and false otherwise.
+*/
+func (dvc *discreteValuesCriterion) SatisfiedBy(sample Sample) (bool, error) {
+	val, err := sample.ValueFor(dvc.feature)
+	if err != nil {
+		return false, err
+	}
+	if val == nil {
+		return dvc.includeUndefined, nil
+	}
+	stringVal, ok := val.(string)
+	if !ok {
+		return false, nil
+	}
+	stringVal = dvc.feature.HashValue(stringVal)
+	for _, v := range dvc.values {
+		if v == stringVal {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (dvc *discreteValuesCriterion) Values() []string {
+	return dvc.values
+}
+
+func (dvc *discreteValuesCriterion) IncludesUndefined() bool {
+	return dvc.includeUndefined
+}
+
+func (dvc *discreteValuesCriterion) String() string {
+	result := fmt.Sprintf("%s is one of %s", dvc.feature.Name(), strings.Join(dvc.values, ", "))
+	if dvc.includeUndefined {
+		result = fmt.Sprintf("%s or not defined", result)
+	}
+	return result
+}
+
+/*
+Feature returns the feature to which the constraint applies.
+*/
+func (bc *booleanCriterion) Feature() Feature {
+	return bc.feature
+}
+
+/*
+SatisfiedBy receives a sample as parameter and returns a boolean indicating if the
+sample satisfies the criterion. Specifically, it returns false if the sample does
+not define a value for the feature (unless the criterion includes undefined values),
+true if the value, being a bool, equals the value on the criterion; and false
+otherwise.
+*/
+func (bc *booleanCriterion) SatisfiedBy(sample Sample) (bool, error) {
+	val, err := sample.ValueFor(bc.feature)
+	if err != nil {
+		return false, err
+	}
+	if val == nil {
+		return bc.includeUndefined, nil
+	}
+	boolVal, ok := val.(bool)
+	if !ok {
+		return false, nil
+	}
+	return bc.value == boolVal, nil
+}
+
+func (bc *booleanCriterion) Value() bool {
+	return bc.value
+}
+
+func (bc *booleanCriterion) IncludesUndefined() bool {
+	return bc.includeUndefined
+}
+
+func (bc *booleanCriterion) String() string {
+	if bc.includeUndefined {
+		return fmt.Sprintf("%s is %t or not defined", bc.feature.Name(), bc.value)
+	}
+	return fmt.Sprintf("%s is %t", bc.feature.Name(), bc.value)
+}
+
 func (u *undefinedCriterion) Feature() Feature {
 	return u.feature
 }
@@ -196,3 +429,28 @@ func (u *undefinedCriterion) IsUndefinedCriterion() bool {
 func (u *undefinedCriterion) String() string {
 	return fmt.Sprintf("%s not defined", u.feature.Name())
 }
+
+func (u *undefinedValueCriterion) Feature() Feature {
+	return u.feature
+}
+
+/*
+SatisfiedBy receives a sample as parameter and returns a boolean indicating if the
+sample satisfies the criterion, that is, if the sample does not define a value
+for the feature.
+*/
+func (u *undefinedValueCriterion) SatisfiedBy(sample Sample) (bool, error) {
+	val, err := sample.ValueFor(u.feature)
+	if err != nil {
+		return false, err
+	}
+	return val == nil, nil
+}
+
+func (u *undefinedValueCriterion) IsUndefinedValueCriterion() bool {
+	return true
+}
+
+func (u *undefinedValueCriterion) String() string {
+	return fmt.Sprintf("%s is undefined", u.feature.Name())
+}
@@ -1,6 +1,20 @@
 package feature
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+/*
+ErrUnknownFeature is the error wrapped by the errors returned when a name
+does not match any of the features given, such as the columns of a CSV
+header or the features of a criteria expression, so that callers can tell
+it with errors.Is.
+*/
+var ErrUnknownFeature = errors.New("unknown feature")
 
 /*
 Feature represents a property that can be observed
@@ -12,11 +26,16 @@ type Feature interface {
 
 /*
 DiscreteFeature represents a property that can be observed and that can only
-take a value among a finite set.
+take a value among a finite set. A discrete feature can also hash its values
+into a number of buckets, which then are the values it can take.
 */
 type DiscreteFeature struct {
 	name            string
 	availableValues []string
+	undefinedPolicy UndefinedPolicy
+	missingValue    *string
+	buckets         int
+	normalizer      *Normalizer
 }
 
 /*
@@ -24,7 +43,18 @@ ContinuousFeature represents a property that can be observed and that can take
 a numeric value
 */
 type ContinuousFeature struct {
-	name string
+	name            string
+	undefinedPolicy UndefinedPolicy
+	missingValue    *float64
+}
+
+/*
+BooleanFeature represents a property that can be observed and that can only
+be either true or false. Its values are bool values.
+*/
+type BooleanFeature struct {
+	name            string
+	undefinedPolicy UndefinedPolicy
 }
 
 /*
@@ -32,7 +62,26 @@ NewDiscreteFeature takes a name string and a slice of available value strings
 and returns a discrete feature with the given names and available values.
 */
 func NewDiscreteFeature(name string, availableValues []string) *DiscreteFeature {
-	return &DiscreteFeature{name, availableValues}
+	return &DiscreteFeature{name: name, availableValues: availableValues}
+}
+
+/*
+NewHashedDiscreteFeature takes a name string and a number of buckets and
+returns a discrete feature with the given name that hashes its values into
+the given number of buckets. Its available values are the names of the
+buckets, "#0" to "#N-1" for N buckets, so that features with too many values
+to list them, such as user agents or cities, can be used in trees and sets
+with a bounded number of values. A number of buckets under 1 is taken as 1.
+*/
+func NewHashedDiscreteFeature(name string, buckets int) *DiscreteFeature {
+	if buckets < 1 {
+		buckets = 1
+	}
+	availableValues := make([]string, 0, buckets)
+	for i := 0; i < buckets; i++ {
+		availableValues = append(availableValues, bucketValue(i))
+	}
+	return &DiscreteFeature{name: name, availableValues: availableValues, buckets: buckets}
 }
 
 /*
@@ -40,7 +89,54 @@ NewContinuousFeature takes a name string and returns a continuous feature with
 the given name.
 */
 func NewContinuousFeature(name string) *ContinuousFeature {
-	return &ContinuousFeature{name}
+	return &ContinuousFeature{name: name}
+}
+
+/*
+NewBooleanFeature takes a name string and returns a boolean feature with the
+given name.
+*/
+func NewBooleanFeature(name string) *BooleanFeature {
+	return &BooleanFeature{name: name}
+}
+
+/*
+ParseBoolean takes a string and returns the value of a boolean feature it
+represents: true for "true" or "1" and false for "false" or "0", regardless
+of case and surrounding spaces. It returns an error for any other string.
+*/
+func ParseBoolean(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean value %q, expected true, false, 1 or 0", s)
+}
+
+/*
+Rename takes a feature and a name and returns a copy of the feature with the
+given name, keeping its kind, values, undefined policy, missing value and
+normalizer, or
+an error if the feature is not continuous, discrete or boolean.
+*/
+func Rename(f Feature, name string) (Feature, error) {
+	switch tf := f.(type) {
+	case *DiscreteFeature:
+		df := *tf
+		df.name = name
+		return &df, nil
+	case *ContinuousFeature:
+		cf := *tf
+		cf.name = name
+		return &cf, nil
+	case *BooleanFeature:
+		bf := *tf
+		bf.name = name
+		return &bf, nil
+	}
+	return nil, fmt.Errorf("cannot rename feature %s of type %T", f.Name(), f)
 }
 
 /*
@@ -79,6 +175,117 @@ func (df *DiscreteFeature) AvailableValues() []string {
 	return df.availableValues
 }
 
+/*
+Buckets returns the number of buckets the feature hashes its values into, or
+0 if it does not hash them.
+*/
+func (df *DiscreteFeature) Buckets() int {
+	return df.buckets
+}
+
+/*
+HashValue takes a value of the feature and returns the value it stands for:
+the value normalized with the normalizer of the feature, if any, and then
+the name of the bucket it is hashed into for features that hash their
+values, or the normalized value for the rest. The names of the buckets are
+returned as they are, so hashing a value more than once is harmless.
+Readers of sets and samples hash the values of discrete features with it,
+so that the same value always ends up in the same bucket when growing a tree
+and when predicting with it.
+*/
+func (df *DiscreteFeature) HashValue(value string) string {
+	if df.normalizer != nil {
+		value = df.normalizer.Normalize(value)
+	}
+	if df.buckets == 0 {
+		return value
+	}
+	if strings.HasPrefix(value, "#") {
+		if i, err := strconv.Atoi(value[1:]); err == nil && i >= 0 && i < df.buckets && bucketValue(i) == value {
+			return value
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return bucketValue(int(h.Sum32() % uint32(df.buckets)))
+}
+
+func bucketValue(i int) string {
+	return "#" + strconv.Itoa(i)
+}
+
+/*
+UndefinedPolicy returns the UndefinedPolicy to apply to samples with an
+undefined value for the feature when partitioning a set with it.
+*/
+func (df *DiscreteFeature) UndefinedPolicy() UndefinedPolicy {
+	return df.undefinedPolicy
+}
+
+/*
+SetUndefinedPolicy takes an UndefinedPolicy and sets it as the one to apply
+to samples with an undefined value for the feature.
+*/
+func (df *DiscreteFeature) SetUndefinedPolicy(p UndefinedPolicy) {
+	df.undefinedPolicy = p
+}
+
+/*
+MissingValue returns the value that stands for a missing value of the
+feature on stored sets and true, or an empty string and false if the
+feature has none and missing values are stored as such.
+*/
+func (df *DiscreteFeature) MissingValue() (string, bool) {
+	if df.missingValue == nil {
+		return "", false
+	}
+	return *df.missingValue, true
+}
+
+/*
+SetMissingValue takes a string and sets it as the value that stands for a
+missing value of the feature on stored sets.
+*/
+func (df *DiscreteFeature) SetMissingValue(v string) {
+	df.missingValue = &v
+}
+
+/*
+Normalizer returns the normalizer of the values of the feature, or nil if
+it has none.
+*/
+func (df *DiscreteFeature) Normalizer() *Normalizer {
+	return df.normalizer
+}
+
+/*
+SetNormalizer takes a normalizer and sets it as the one of the values of the
+feature, or removes it if nil or empty. It returns an error if the normalizer
+would change any of the available values of the feature, for features that
+do not hash their values, or any of the values it maps others to, as values
+must be normalized only once.
+*/
+func (df *DiscreteFeature) SetNormalizer(n *Normalizer) error {
+	if n == nil || n.Empty() {
+		df.normalizer = nil
+		return nil
+	}
+	if df.buckets == 0 {
+		for _, v := range df.availableValues {
+			if nv := n.Normalize(v); nv != v {
+				return fmt.Errorf("normalizer of discrete feature %s changes its value %q into %q", df.name, v, nv)
+			}
+		}
+	}
+	for _, v := range n.Mapping {
+		if nv := n.Normalize(v); nv != v {
+			return fmt.Errorf("normalizer of discrete feature %s changes the value %q it maps to into %q", df.name, v, nv)
+		}
+	}
+	df.normalizer = n
+	return nil
+}
+
 func (df *DiscreteFeature) String() string {
 	return df.name
 }
@@ -106,6 +313,85 @@ func (cf *ContinuousFeature) Valid(value interface{}) (bool, error) {
 	return true, nil
 }
 
+/*
+UndefinedPolicy returns the UndefinedPolicy to apply to samples with an
+undefined value for the feature when partitioning a set with it.
+*/
+func (cf *ContinuousFeature) UndefinedPolicy() UndefinedPolicy {
+	return cf.undefinedPolicy
+}
+
+/*
+SetUndefinedPolicy takes an UndefinedPolicy and sets it as the one to apply
+to samples with an undefined value for the feature.
+*/
+func (cf *ContinuousFeature) SetUndefinedPolicy(p UndefinedPolicy) {
+	cf.undefinedPolicy = p
+}
+
+/*
+MissingValue returns the value that stands for a missing value of the
+feature on stored sets and true, or 0 and false if the feature has none
+and missing values are stored as such.
+*/
+func (cf *ContinuousFeature) MissingValue() (float64, bool) {
+	if cf.missingValue == nil {
+		return 0, false
+	}
+	return *cf.missingValue, true
+}
+
+/*
+SetMissingValue takes a float64 and sets it as the value that stands for a
+missing value of the feature on stored sets.
+*/
+func (cf *ContinuousFeature) SetMissingValue(v float64) {
+	cf.missingValue = &v
+}
+
 func (cf *ContinuousFeature) String() string {
 	return cf.name
 }
+
+/*
+Name returns a string with the name of the feature
+*/
+func (bf *BooleanFeature) Name() string {
+	return bf.name
+}
+
+/*
+Valid receives an interface value and returns a boolean and an error. When the
+value parameter is a bool it returns true and nil, otherwise it returns
+false and an error describing the reason.
+*/
+func (bf *BooleanFeature) Valid(value interface{}) (bool, error) {
+	if value == nil {
+		return true, nil
+	}
+	_, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("boolean feature %s expects bool value, got %T value", bf.Name(), value)
+	}
+	return true, nil
+}
+
+/*
+UndefinedPolicy returns the UndefinedPolicy to apply to samples with an
+undefined value for the feature when partitioning a set with it.
+*/
+func (bf *BooleanFeature) UndefinedPolicy() UndefinedPolicy {
+	return bf.undefinedPolicy
+}
+
+/*
+SetUndefinedPolicy takes an UndefinedPolicy and sets it as the one to apply
+to samples with an undefined value for the feature.
+*/
+func (bf *BooleanFeature) SetUndefinedPolicy(p UndefinedPolicy) {
+	bf.undefinedPolicy = p
+}
+
+func (bf *BooleanFeature) String() string {
+	return bf.name
+}
@@ -0,0 +1,202 @@
+/*
+Package jsonmeta provides methods to parse feature.Feature specifications,
+also known as metadata, from JSON documents and to write them as JSON
+documents, for programs that generate metadata. The format mirrors the YAML
+one of the feature/yaml package.
+*/
+package jsonmeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+ReadFeatures takes a slice of bytes with a feature specification in JSON and
+returns a slice of features parsed from it or an error.
+The JSON is expected to be an object containing a features property. The value
+for this should be an object with a property for each feature with its name
+and its specification, as described for feature.ParseSpec: either a string
+value of "continuous" for continuous features, "boolean" for boolean features,
+an array of valid values for discrete features or an object with its type,
+values, buckets, undefined policy, missing value and normalizer.
+
+The features are returned sorted by name.
+*/
+func ReadFeatures(md []byte) ([]feature.Feature, error) {
+	metadata := struct {
+		Features map[string]interface{} `json:"features"`
+	}{}
+	dec := json.NewDecoder(bytes.NewReader(md))
+	dec.UseNumber()
+	err := dec.Decode(&metadata)
+	if err != nil {
+		return nil, fmt.Errorf("parsing json features: %v", err)
+	}
+	if metadata.Features == nil {
+		return nil, fmt.Errorf("metadata file has no feature information")
+	}
+	specs := make(map[string]interface{}, len(metadata.Features))
+	for fn, spec := range metadata.Features {
+		specs[fn] = specNumbers(spec)
+	}
+	return feature.ParseSpecs(specs)
+}
+
+/*
+ReadFeaturesFromFile takes a filepath string, reads its contents and uses
+ReadFeatures to parse it and return a slice of parsed features or an error.
+If the file indicated by the filepath cannot be opened for reading an error
+will be returned.
+*/
+func ReadFeaturesFromFile(filepath string) ([]feature.Feature, error) {
+	md, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("reading features json file %s: %v", filepath, err)
+	}
+	features, err := ReadFeatures(md)
+	if err != nil {
+		err = fmt.Errorf("parsing features json file %s: %v", filepath, err)
+	}
+	return features, err
+}
+
+/*
+WriteFeatures takes an io.Writer and a slice of features and writes the
+specification of the features in JSON onto the io.Writer, in the format read
+by ReadFeatures. Features with the default undefined policy, no missing
+value and no normalizer are written in their short form, as "continuous", as "boolean" or as
+the array of their values, and discrete features that hash their values are
+written with their number of buckets. It returns an error if a feature is
+not continuous, discrete or boolean or if the specification cannot be
+written.
+*/
+func WriteFeatures(w io.Writer, features []feature.Feature) error {
+	specs := make(map[string]interface{}, len(features))
+	for _, f := range features {
+		if _, ok := specs[f.Name()]; ok {
+			return fmt.Errorf("duplicate feature %s", f.Name())
+		}
+		spec, err := featureSpec(f)
+		if err != nil {
+			return err
+		}
+		specs[f.Name()] = spec
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{"features": specs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding json features: %v", err)
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+/*
+WriteFeaturesToFile takes a filepath string and a slice of features and
+uses WriteFeatures to write them to the file at the filepath, creating it or
+truncating it if it exists. It returns an error if the file cannot be
+written.
+*/
+func WriteFeaturesToFile(filepath string, features []feature.Feature) error {
+	f, err := os.Create(filepath)
+	if err != nil {
+		return fmt.Errorf("creating features json file %s: %v", filepath, err)
+	}
+	err = WriteFeatures(f, features)
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing features json file %s: %v", filepath, err)
+	}
+	return nil
+}
+
+func featureSpec(f feature.Feature) (interface{}, error) {
+	switch tf := f.(type) {
+	case *feature.ContinuousFeature:
+		mv, hasMissing := tf.MissingValue()
+		if tf.UndefinedPolicy() == feature.UndefinedPolicyParent && !hasMissing {
+			return "continuous", nil
+		}
+		spec := map[string]interface{}{"type": "continuous", "undefined": tf.UndefinedPolicy().String()}
+		if hasMissing {
+			spec["missing"] = mv
+		}
+		return spec, nil
+	case *feature.DiscreteFeature:
+		values := tf.AvailableValues()
+		if values == nil {
+			values = []string{}
+		}
+		mv, hasMissing := tf.MissingValue()
+		n := tf.Normalizer()
+		if tf.UndefinedPolicy() == feature.UndefinedPolicyParent && !hasMissing && tf.Buckets() == 0 && n == nil {
+			return values, nil
+		}
+		spec := map[string]interface{}{"type": "discrete", "undefined": tf.UndefinedPolicy().String()}
+		if tf.Buckets() > 0 {
+			spec["buckets"] = tf.Buckets()
+		} else {
+			spec["values"] = values
+		}
+		if hasMissing {
+			spec["missing"] = mv
+		}
+		if n != nil {
+			ns := map[string]interface{}{}
+			if n.Trim {
+				ns["trim"] = true
+			}
+			if n.Lowercase {
+				ns["lowercase"] = true
+			}
+			if len(n.Mapping) > 0 {
+				ns["map"] = n.Mapping
+			}
+			spec["normalize"] = ns
+		}
+		return spec, nil
+	case *feature.BooleanFeature:
+		if tf.UndefinedPolicy() == feature.UndefinedPolicyParent {
+			return "boolean", nil
+		}
+		return map[string]interface{}{"type": "boolean", "undefined": tf.UndefinedPolicy().String()}, nil
+	}
+	return nil, fmt.Errorf("cannot write feature %s of type %T", f.Name(), f)
+}
+
+/*
+specNumbers takes a specification decoded from JSON with json.Number numbers
+and returns it with integers as int64 and other numbers as float64, as
+expected by feature.ParseSpec.
+*/
+func specNumbers(spec interface{}) interface{} {
+	switch s := spec.(type) {
+	case json.Number:
+		if i, err := s.Int64(); err == nil {
+			return i
+		}
+		f, err := s.Float64()
+		if err != nil {
+			return s.String()
+		}
+		return f
+	case []interface{}:
+		for i, v := range s {
+			s[i] = specNumbers(v)
+		}
+	case map[string]interface{}:
+		for k, v := range s {
+			s[k] = specNumbers(v)
+		}
+	}
+	return spec
+}
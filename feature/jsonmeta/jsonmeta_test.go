@@ -0,0 +1,58 @@
+package jsonmeta
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pbanos/botanic/feature/yaml"
+)
+
+func TestReadFeaturesAgreesWithYAML(t *testing.T) {
+	// JSON documents are YAML documents too, so both packages must parse
+	// them into the same features.
+	valid := []string{
+		`{"features": {"a": "continuous", "b": "boolean", "c": ["x", 1, 2.5, true]}}`,
+		`{"features": {"a": {"type": "continuous", "undefined": "skip", "missing": -1}}}`,
+		`{"features": {"a": {"values": ["x", "y"], "missing": 0, "normalize": {"trim": true, "map": {"X": "x", "one": 1}}}}}`,
+		`{"features": {"a": {"buckets": 16, "undefined": "dedicated", "missing": "?"}}}`,
+	}
+	for _, doc := range valid {
+		jsonFeatures, err := ReadFeatures([]byte(doc))
+		if err != nil {
+			t.Fatalf("reading %s as JSON: %v", doc, err)
+		}
+		yamlFeatures, err := yaml.ReadFeatures([]byte(doc))
+		if err != nil {
+			t.Fatalf("reading %s as YAML: %v", doc, err)
+		}
+		var fromJSON, fromYAML bytes.Buffer
+		if err := WriteFeatures(&fromJSON, jsonFeatures); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFeatures(&fromYAML, yamlFeatures); err != nil {
+			t.Fatal(err)
+		}
+		if fromJSON.String() != fromYAML.String() {
+			t.Errorf("reading %s, got\n%s\nfrom JSON and\n%s\nfrom YAML", doc, fromJSON.String(), fromYAML.String())
+		}
+	}
+	invalid := []string{
+		`{"features": {"a": "discrete"}}`,
+		`{"features": {"a": ["x", null]}}`,
+		`{"features": {"a": ["x", ["y"]]}}`,
+		`{"features": {"a": {"type": 1, "values": ["x"]}}}`,
+		`{"features": {"a": {"buckets": 2.5}}}`,
+		`{"features": {"a": {"type": "continuous", "missing": "?"}}}`,
+		`{"features": {"a": {"values": ["x"], "missing": ["?"]}}}`,
+		`{"features": {"a": {"values": ["x"], "normalize": {"map": {"X": null}}}}}`,
+		`{"features": {"a": {"type": "boolean", "normalize": {"trim": true}}}}`,
+	}
+	for _, doc := range invalid {
+		if _, err := ReadFeatures([]byte(doc)); err == nil {
+			t.Errorf("expected reading %s as JSON to fail", doc)
+		}
+		if _, err := yaml.ReadFeatures([]byte(doc)); err == nil {
+			t.Errorf("expected reading %s as YAML to fail", doc)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package feature
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+MetadataHash takes a slice of features and returns the hex-encoded SHA-256
+hash of their metadata: the name and kind of every feature and the
+available values of the discrete ones, or the number of buckets of those
+that hash their values, and their normalizers, regardless of the order of the
+features and of their values. Features with the same name are only hashed
+once. It allows checking that a model is used with the same features it
+was built with.
+*/
+func MetadataHash(features []Feature) string {
+	byName := make(map[string]Feature, len(features))
+	names := make([]string, 0, len(features))
+	for _, f := range features {
+		if _, ok := byName[f.Name()]; !ok {
+			names = append(names, f.Name())
+		}
+		byName[f.Name()] = f
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		switch f := byName[name].(type) {
+		case *DiscreteFeature:
+			if n := f.Normalizer(); n != nil {
+				fmt.Fprintf(h, "%q normalized %s\n", name, n)
+			}
+			if f.Buckets() > 0 {
+				fmt.Fprintf(h, "%q hashed %d\n", name, f.Buckets())
+				continue
+			}
+			values := append([]string(nil), f.AvailableValues()...)
+			sort.Strings(values)
+			fmt.Fprintf(h, "%q discrete %q\n", name, strings.Join(values, "\x00"))
+		case *ContinuousFeature:
+			fmt.Fprintf(h, "%q continuous\n", name)
+		case *BooleanFeature:
+			fmt.Fprintf(h, "%q boolean\n", name)
+		default:
+			fmt.Fprintf(h, "%q %T\n", name, f)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
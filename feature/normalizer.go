@@ -0,0 +1,66 @@
+package feature
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Normalizer rewrites the values of a discrete feature before they are
+validated, hashed or compared, so that spellings of the same value, such as
+"Yes", "yes " and "YES", collapse into one. Values are trimmed of
+surrounding spaces if Trim is set, then lowercased if Lowercase is set, and
+then replaced by their entry in Mapping, if they have one.
+*/
+type Normalizer struct {
+	Trim      bool
+	Lowercase bool
+	Mapping   map[string]string
+}
+
+/*
+Normalize takes a value and returns it normalized.
+*/
+func (n *Normalizer) Normalize(value string) string {
+	if n.Trim {
+		value = strings.TrimSpace(value)
+	}
+	if n.Lowercase {
+		value = strings.ToLower(value)
+	}
+	if mv, ok := n.Mapping[value]; ok {
+		value = mv
+	}
+	return value
+}
+
+/*
+Empty returns whether the normalizer leaves every value as it is.
+*/
+func (n *Normalizer) Empty() bool {
+	return !n.Trim && !n.Lowercase && len(n.Mapping) == 0
+}
+
+func (n *Normalizer) String() string {
+	var parts []string
+	if n.Trim {
+		parts = append(parts, "trim")
+	}
+	if n.Lowercase {
+		parts = append(parts, "lowercase")
+	}
+	if len(n.Mapping) > 0 {
+		keys := make([]string, 0, len(n.Mapping))
+		for k := range n.Mapping {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, fmt.Sprintf("%q:%q", k, n.Mapping[k]))
+		}
+		parts = append(parts, "map{"+strings.Join(pairs, ",")+"}")
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,296 @@
+package feature
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+/*
+ParseSpecs takes the specifications of a set of features indexed by their
+names, as decoded from metadata documents, and returns the features they
+specify sorted by name or an error. Each specification is parsed with
+ParseSpec.
+*/
+func ParseSpecs(specs map[string]interface{}) ([]Feature, error) {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	features := make([]Feature, 0, len(names))
+	for _, name := range names {
+		f, err := ParseSpec(name, specs[name])
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+/*
+ParseSpec takes the name of a feature and its specification as decoded from
+a metadata document, made of strings, booleans, numbers (int, int64, uint64
+or float64), slices of interface{} and maps of strings to interface{}, and
+returns the feature it specifies or an error.
+The specification is either the string "continuous" for continuous
+features, the string "boolean" for boolean features, a list of valid values
+for discrete features or a map with the following keys:
+  - type: either "continuous", "discrete" or "boolean". It can be omitted if
+    values or buckets are given, in which case it defaults to "discrete".
+  - values: the list of valid values for a discrete feature.
+  - buckets: the number of buckets a discrete feature with too many values to
+    list them hashes its values into, instead of giving its values.
+  - undefined: the name of the UndefinedPolicy to apply to samples with an
+    undefined value for the feature, that is "parent" (the default), "skip",
+    "majority" or "dedicated".
+  - missing: the value that stands for a missing value of the feature on sets
+    stored on SQL databases, which hold it instead of NULL for undefined
+    values and read both it and NULL as undefined values. It must be a number
+    for continuous features, and boolean features cannot have one.
+  - normalize: a map with the Normalizer of the values of a discrete
+    feature, so that spellings of the same value collapse into one, with the
+    following keys: "trim", true to trim surrounding spaces off values;
+    "lowercase", true to lowercase them; and "map", a map with the values to
+    replace as keys and their replacements as values, looked up after
+    trimming and lowercasing them.
+
+Values, missing values of discrete features and replacements may be given as
+strings, booleans or numbers, which are taken as the strings they are
+written as.
+*/
+func ParseSpec(name string, spec interface{}) (Feature, error) {
+	switch s := spec.(type) {
+	case string:
+		switch s {
+		case "continuous":
+			return NewContinuousFeature(name), nil
+		case "boolean":
+			return NewBooleanFeature(name), nil
+		}
+		return nil, fmt.Errorf("invalid type '%s' for feature %s", s, name)
+	case []interface{}:
+		values, err := specValues(name, s)
+		if err != nil {
+			return nil, err
+		}
+		return NewDiscreteFeature(name, values), nil
+	case map[string]interface{}:
+		return parseSpecObject(name, s)
+	}
+	return nil, fmt.Errorf("invalid declaration of type %T for feature %s", spec, name)
+}
+
+func parseSpecObject(name string, spec map[string]interface{}) (Feature, error) {
+	var values []string
+	var policy UndefinedPolicy
+	var featureType string
+	if t, ok := spec["type"]; ok {
+		featureType, ok = t.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid type declaration of type %T for feature %s", t, name)
+		}
+	}
+	if vs, ok := spec["values"]; ok {
+		ivs, ok := vs.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid values declaration of type %T for feature %s", vs, name)
+		}
+		var err error
+		values, err = specValues(name, ivs)
+		if err != nil {
+			return nil, err
+		}
+		if featureType == "" {
+			featureType = "discrete"
+		}
+	}
+	var buckets int
+	if b, ok := spec["buckets"]; ok {
+		n, ok := specInt(b)
+		if !ok || n < 1 {
+			return nil, fmt.Errorf("invalid buckets declaration %v for feature %s, expected a positive integer", b, name)
+		}
+		if values != nil {
+			return nil, fmt.Errorf("feature %s cannot have both values and buckets", name)
+		}
+		if featureType == "" {
+			featureType = "discrete"
+		}
+		if featureType != "discrete" {
+			return nil, fmt.Errorf("%s feature %s cannot have buckets", featureType, name)
+		}
+		buckets = n
+	}
+	if u, ok := spec["undefined"]; ok {
+		us, ok := u.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid undefined declaration of type %T for feature %s", u, name)
+		}
+		var err error
+		policy, err = ParseUndefinedPolicy(us)
+		if err != nil {
+			return nil, fmt.Errorf("feature %s: %v", name, err)
+		}
+	}
+	var normalizer *Normalizer
+	if n, ok := spec["normalize"]; ok {
+		if featureType != "discrete" {
+			return nil, fmt.Errorf("%s feature %s cannot have a normalizer", featureType, name)
+		}
+		var err error
+		normalizer, err = specNormalizer(name, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	missing, hasMissing := spec["missing"]
+	switch featureType {
+	case "continuous":
+		f := NewContinuousFeature(name)
+		f.SetUndefinedPolicy(policy)
+		if hasMissing {
+			mv, ok := specFloat(missing)
+			if !ok {
+				return nil, fmt.Errorf("invalid missing value declaration of type %T for continuous feature %s", missing, name)
+			}
+			f.SetMissingValue(mv)
+		}
+		return f, nil
+	case "discrete":
+		f := NewDiscreteFeature(name, values)
+		if buckets > 0 {
+			f = NewHashedDiscreteFeature(name, buckets)
+		}
+		f.SetUndefinedPolicy(policy)
+		if hasMissing {
+			mv, ok := specString(missing)
+			if !ok {
+				return nil, fmt.Errorf("invalid missing value declaration of type %T for discrete feature %s", missing, name)
+			}
+			f.SetMissingValue(mv)
+		}
+		err := f.SetNormalizer(normalizer)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case "boolean":
+		if hasMissing {
+			return nil, fmt.Errorf("boolean feature %s cannot have a missing value", name)
+		}
+		f := NewBooleanFeature(name)
+		f.SetUndefinedPolicy(policy)
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid type '%s' for feature %s", featureType, name)
+}
+
+func specNormalizer(name string, spec interface{}) (*Normalizer, error) {
+	ns, ok := spec.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid normalize declaration of type %T for feature %s", spec, name)
+	}
+	n := &Normalizer{}
+	for k, v := range ns {
+		switch k {
+		case "trim", "lowercase":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("invalid normalize %s declaration %v for feature %s, expected true or false", k, v, name)
+			}
+			if k == "trim" {
+				n.Trim = b
+			} else {
+				n.Lowercase = b
+			}
+		case "map":
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid normalize map declaration of type %T for feature %s", v, name)
+			}
+			n.Mapping = make(map[string]string, len(m))
+			for from, to := range m {
+				s, ok := specString(to)
+				if !ok {
+					return nil, fmt.Errorf("invalid normalize map replacement of type %T for value %s of feature %s", to, from, name)
+				}
+				n.Mapping[from] = s
+			}
+		default:
+			return nil, fmt.Errorf("unknown normalize property %s for feature %s, the following are valid: trim, lowercase, map", k, name)
+		}
+	}
+	return n, nil
+}
+
+func specValues(name string, ivs []interface{}) ([]string, error) {
+	values := make([]string, 0, len(ivs))
+	for _, v := range ivs {
+		s, ok := specString(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid value declaration of type %T for feature %s", v, name)
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+/*
+specString takes a scalar of a specification and returns it as a string and
+true, or false if it is not a string, a boolean or a number.
+*/
+func specString(v interface{}) (string, bool) {
+	switch tv := v.(type) {
+	case string:
+		return tv, true
+	case bool:
+		return strconv.FormatBool(tv), true
+	case float64:
+		return strconv.FormatFloat(tv, 'g', -1, 64), true
+	case int, int64, uint64:
+		return fmt.Sprintf("%d", tv), true
+	}
+	return "", false
+}
+
+/*
+specFloat takes a scalar of a specification and returns it as a float64 and
+true, or false if it is not a number.
+*/
+func specFloat(v interface{}) (float64, bool) {
+	switch tv := v.(type) {
+	case float64:
+		return tv, true
+	case int:
+		return float64(tv), true
+	case int64:
+		return float64(tv), true
+	case uint64:
+		return float64(tv), true
+	}
+	return 0, false
+}
+
+/*
+specInt takes a scalar of a specification and returns it as an int and true,
+or false if it is not an integer that fits an int.
+*/
+func specInt(v interface{}) (int, bool) {
+	switch tv := v.(type) {
+	case int:
+		return tv, true
+	case int64:
+		return int(tv), int64(int(tv)) == tv
+	case uint64:
+		return int(tv), int(tv) >= 0 && uint64(int(tv)) == tv
+	case float64:
+		if tv != math.Trunc(tv) || math.Abs(tv) > math.MaxInt32 {
+			return 0, false
+		}
+		return int(tv), true
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package feature
+
+import "fmt"
+
+/*
+UndefinedPolicy determines how samples with an undefined value for a feature
+are handled when a set is partitioned using that feature.
+*/
+type UndefinedPolicy int
+
+const (
+	// UndefinedPolicyParent develops a subtree for samples with an undefined
+	// value using all the samples of the partitioned set. This is the default
+	// policy.
+	UndefinedPolicyParent UndefinedPolicy = iota
+	// UndefinedPolicySkip develops no subtree for samples with an undefined
+	// value.
+	UndefinedPolicySkip
+	// UndefinedPolicyMajority routes samples with an undefined value into
+	// the subtree with the largest number of samples.
+	UndefinedPolicyMajority
+	// UndefinedPolicyDedicated develops a subtree for samples with an
+	// undefined value using only the samples of the partitioned set that
+	// have an undefined value for the feature.
+	UndefinedPolicyDedicated
+)
+
+var undefinedPolicyNames = map[UndefinedPolicy]string{
+	UndefinedPolicyParent:    "parent",
+	UndefinedPolicySkip:      "skip",
+	UndefinedPolicyMajority:  "majority",
+	UndefinedPolicyDedicated: "dedicated",
+}
+
+/*
+ParseUndefinedPolicy takes a string and returns the UndefinedPolicy it names
+or an error if it names none. Valid names are "parent", "skip", "majority"
+and "dedicated".
+*/
+func ParseUndefinedPolicy(name string) (UndefinedPolicy, error) {
+	for p, n := range undefinedPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return UndefinedPolicyParent, fmt.Errorf("unknown undefined value policy '%s'", name)
+}
+
+func (p UndefinedPolicy) String() string {
+	if n, ok := undefinedPolicyNames[p]; ok {
+		return n
+	}
+	return fmt.Sprintf("UndefinedPolicy(%d)", int(p))
+}
@@ -16,9 +16,13 @@ import (
 ReadFeatures takes a slice of bytes with a feature specification in YML and
 returns a slice of features parsed from it or an error.
 The YML is expected to be an object containing a features property. The value for this
-should be an object with a property for each feature with its name and either a
-string value of 'continuous' for continuous features or a list of valid values
-for discrete features.
+should be an object with a property for each feature with its name and its
+specification, as described for feature.ParseSpec: either a string value of
+'continuous' for continuous features, 'boolean' for boolean features, a list
+of valid values for discrete features or an object with its type, values,
+buckets, undefined policy, missing value and normalizer.
+
+The features are returned sorted by name.
 */
 func ReadFeatures(md []byte) ([]feature.Feature, error) {
 	metadata := struct {
@@ -31,24 +35,11 @@ func ReadFeatures(md []byte) ([]feature.Feature, error) {
 	if metadata.Features == nil {
 		return nil, fmt.Errorf("metadata file has no feature information")
 	}
-	features := []feature.Feature{}
-	for fn, vs := range metadata.Features {
-		switch values := vs.(type) {
-		case string:
-			features = append(features, feature.NewContinuousFeature(fn))
-		case []interface{}:
-			stringVs := []string{}
-			for _, v := range values {
-				stringVs = append(stringVs, fmt.Sprintf("%v", v))
-			}
-			features = append(features, feature.NewDiscreteFeature(fn, stringVs))
-		case []string:
-			features = append(features, feature.NewDiscreteFeature(fn, values))
-		default:
-			return nil, fmt.Errorf("invalid feature declaration of type %T", vs)
-		}
+	specs := make(map[string]interface{}, len(metadata.Features))
+	for fn, spec := range metadata.Features {
+		specs[fn] = specMaps(spec)
 	}
-	return features, nil
+	return feature.ParseSpecs(specs)
 }
 
 /*
@@ -68,3 +59,23 @@ func ReadFeaturesFromFile(filepath string) ([]feature.Feature, error) {
 	}
 	return features, err
 }
+
+/*
+specMaps takes a specification decoded from YML and returns it with its maps
+keyed by strings, as expected by feature.ParseSpec.
+*/
+func specMaps(spec interface{}) interface{} {
+	switch s := spec.(type) {
+	case []interface{}:
+		for i, v := range s {
+			s[i] = specMaps(v)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(s))
+		for k, v := range s {
+			m[fmt.Sprintf("%v", k)] = specMaps(v)
+		}
+		return m
+	}
+	return spec
+}
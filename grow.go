@@ -0,0 +1,365 @@
+package botanic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/logging"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+GrowConfig holds everything needed to grow a tree with Grow.
+*/
+type GrowConfig struct {
+	// ClassFeature is the feature predicted by
+	// the tree.
+	ClassFeature feature.Feature
+	// Features are the features available to
+	// grow the tree.
+	Features []feature.Feature
+	// Set is the training set the tree is grown
+	// from.
+	Set set.Set
+	// PruningStrategy decides when nodes are not
+	// developed further. A nil PruningStrategy
+	// prunes partitions with the DefaultPruner.
+	PruningStrategy *PruningStrategy
+	// MissingValueStrategy is the strategy of
+	// the tree for samples without a value for
+	// the feature of a split.
+	MissingValueStrategy tree.MissingValueStrategy
+	// DiscreteSplit is how the set of a node is
+	// split with a discrete feature: into a subset
+	// for every value of the feature, the default,
+	// or in two with the subset of its values that
+	// gives the most information gain.
+	DiscreteSplit DiscreteSplit
+	// MaxThresholds is the maximum number of
+	// candidate thresholds evaluated to split the
+	// range of a continuous feature in two. When a
+	// range has more distinct values, thresholds are
+	// only taken at the quantiles of the values. A
+	// MaxThresholds of 0 imposes no limit.
+	MaxThresholds int
+	// Smoothing is the smoothing the tree applies
+	// to the probabilities it predicts. The zero
+	// value applies none.
+	Smoothing tree.Smoothing
+	// FeatureConcurrency is the maximum number of
+	// features whose partitions of a node's set are
+	// computed at the same time when branching it
+	// out. A FeatureConcurrency of 0 or 1 computes
+	// them one after another.
+	FeatureConcurrency int
+	// NodeStore is the store on which the nodes
+	// of the tree are created. A nil NodeStore
+	// keeps them in memory.
+	NodeStore tree.NodeStore
+	// Queue, if not nil, is the queue on which
+	// the tree is seeded and from which its
+	// tasks are worked on, which workers on
+	// other processes can share. A nil Queue
+	// grows the tree with GrowInProcess.
+	Queue queue.Queue
+	// Workers is the number of goroutines
+	// growing the tree, 1 if it is lower.
+	Workers int
+	// EmptyQueueSleep is the time workers on a
+	// Queue that is not a queue.WaitingQueue
+	// sleep when no task can be pulled from it,
+	// 1 second if it is 0.
+	EmptyQueueSleep time.Duration
+	// OnProgress, if not nil, is called with the
+	// progress of the growth every
+	// ProgressInterval, if it is positive, and
+	// once the growth ends.
+	OnProgress       func(*Progress)
+	ProgressInterval time.Duration
+	// Observer, if not nil, is notified of the
+	// events of the growth of the tree, such as
+	// the start and completion of the development
+	// of every node.
+	Observer Observer
+	// Logger, if not nil, logs the development
+	// of every node at debug level, and the
+	// temporary errors workers recover from.
+	Logger logging.Logger
+	// Retry, if not nil, is the RetryPolicy of
+	// workers for the operations that fail with
+	// transient errors. Workers retry them up to
+	// 3 times, waiting the time they sleep on an
+	// empty queue, if it is nil.
+	Retry *RetryPolicy
+}
+
+/*
+observer returns the Observer of the GrowConfig, or one that ignores every
+event if it has none.
+*/
+func (gc *GrowConfig) observer() Observer {
+	if gc.Observer == nil {
+		return nopObserver{}
+	}
+	return gc.Observer
+}
+
+/*
+retryPolicy takes the time a worker sleeps on an empty queue and returns
+the RetryPolicy of the GrowConfig, or the default one for that time if it
+has none.
+*/
+func (gc *GrowConfig) retryPolicy(emptyQueueSleep time.Duration) *RetryPolicy {
+	if gc.Retry == nil {
+		return defaultRetryPolicy(emptyQueueSleep)
+	}
+	return gc.Retry
+}
+
+/*
+logger returns the Logger of the GrowConfig, or one that discards every
+message if it has none.
+*/
+func (gc *GrowConfig) logger() logging.Logger {
+	if gc.Logger == nil {
+		return logging.Nop()
+	}
+	return gc.Logger
+}
+
+/*
+Grow takes a context and a GrowConfig and returns a tree grown as
+configured, so that programs embedding botanic do not have to create the
+node store and seed the tree, start workers and wait for them themselves.
+Without a Queue in the configuration the tree is grown with GrowInProcess.
+With one, the tree is seeded on it with Seed and Workers goroutines Work on
+it until no tasks are left, the first error of any of them cancelling the
+rest.
+
+The given GrowOptions are applied in order to a copy of the GrowConfig,
+with a copy of its PruningStrategy, so neither is modified.
+
+Grow returns an error if the tree cannot be seeded or grown, or if the
+given context times out or is cancelled.
+*/
+func Grow(ctx context.Context, gc GrowConfig, opts ...GrowOption) (*tree.Tree, error) {
+	ps := &PruningStrategy{Pruner: DefaultPruner()}
+	if gc.PruningStrategy != nil {
+		strategy := *gc.PruningStrategy
+		ps = &strategy
+	}
+	gc.PruningStrategy = ps
+	for _, opt := range opts {
+		opt(&gc)
+	}
+	ns := gc.NodeStore
+	if ns == nil {
+		ns = tree.NewMemoryNodeStore()
+	}
+	if gc.OnProgress != nil {
+		po := &ProgressObserver{}
+		if gc.Observer != nil {
+			gc.Observer = MultiObserver(gc.Observer, po)
+		} else {
+			gc.Observer = po
+		}
+		stop := reportProgress(po, gc.OnProgress, gc.ProgressInterval)
+		defer stop()
+	}
+	if gc.Queue == nil {
+		t := tree.New("", ns, gc.ClassFeature)
+		t.MissingValueStrategy = gc.MissingValueStrategy
+		t.Smoothing = gc.Smoothing
+		err := growInProcess(ctx, t, gc.Features, gc.Set, &gc, gc.Workers)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	t, err := Seed(ctx, gc.ClassFeature, gc.Features, gc.Set, gc.Queue, ns)
+	if err != nil {
+		return nil, err
+	}
+	t.MissingValueStrategy = gc.MissingValueStrategy
+	t.Smoothing = gc.Smoothing
+	err = work(ctx, t, gc.Queue, &gc, gc.Workers, gc.EmptyQueueSleep)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+/*
+work takes a context, a tree, a queue, a growth configuration, a number of
+workers and the time they sleep on an empty queue and runs that number of
+goroutines that Work on the queue until no tasks are left. It returns the
+first error returned by any of them, which cancels the rest.
+*/
+func work(ctx context.Context, t *tree.Tree, q queue.Queue, gc *GrowConfig, workers int, emptyQueueSleep time.Duration) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if emptyQueueSleep <= 0 {
+		emptyQueueSleep = time.Second
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runWorker(ctx, t, q, gc, emptyQueueSleep)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+/*
+reportProgress takes a ProgressObserver, a function to call with its
+progress and an interval and calls the function with the progress every
+interval, if it is positive, until the returned function is called, which
+calls it one last time.
+*/
+func reportProgress(po *ProgressObserver, onProgress func(*Progress), interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if interval <= 0 {
+			<-done
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				onProgress(po.Progress())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		onProgress(po.Progress())
+	}
+}
+
+/*
+GrowInProcess takes a context, a tree with no nodes, a slice of features, a
+set of training data, a pruning strategy, a concurrency limit and any number
+of GrowOptions for the settings of the growth other than those of the
+strategy, such as WithDiscreteSplit, and grows
+the tree to predict its class feature using the features in the slice and
+according to the training data on the set. It creates the root node of the
+tree on the tree's node store, setting the tree's RootID, and branches out
+the nodes with BranchOut as Work does, but without a queue: the resulting
+tasks are developed recursively on the same goroutine or, while fewer than
+concurrency goroutines are growing the tree, on new ones.
+
+This is the fastest way to grow a tree when all its nodes can be grown by
+the current process. Use Seed and Work to grow a tree with workers on
+different processes instead.
+
+GrowInProcess returns when the tree is fully grown or an error if the root
+node cannot be created, BranchOut returns a non-nil error or the given
+context times out or is cancelled.
+*/
+func GrowInProcess(ctx context.Context, t *tree.Tree, features []feature.Feature, s set.Set, ps *PruningStrategy, concurrency int, opts ...GrowOption) error {
+	return growInProcess(ctx, t, features, s, growConfig(ps, opts), concurrency)
+}
+
+/*
+growInProcess works like GrowInProcess, with the pruning strategy and the
+rest of the settings of the growth on a GrowConfig.
+*/
+func growInProcess(ctx context.Context, t *tree.Tree, features []feature.Feature, s set.Set, gc *GrowConfig, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	n := &tree.Node{}
+	err := t.NodeStore.Create(ctx, n)
+	if err != nil {
+		return err
+	}
+	t.RootID = n.ID
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g := &inProcessGrowth{
+		t:      t,
+		gc:     gc,
+		slots:  make(chan struct{}, concurrency-1),
+		cancel: cancel,
+	}
+	g.grow(ctx, queue.NewTask(n, s, features))
+	g.wg.Wait()
+	return g.err
+}
+
+/*
+inProcessGrowth holds the state shared by the goroutines growing a tree with
+GrowInProcess: the tree, the growth configuration, a channel with a slot for
+every goroutine that can be started, and the first error found, which
+cancels the growth.
+*/
+type inProcessGrowth struct {
+	t      *tree.Tree
+	gc     *GrowConfig
+	slots  chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+	cancel context.CancelFunc
+}
+
+func (g *inProcessGrowth) grow(ctx context.Context, task *queue.Task) {
+	if err := ctx.Err(); err != nil {
+		g.fail(err)
+		return
+	}
+	g.gc.observer().OnTaskStarted(ctx, task)
+	tasks, err := branchOut(ctx, task, g.t, g.gc)
+	g.gc.observer().OnTaskCompleted(ctx, task, err)
+	if err != nil {
+		g.fail(err)
+		return
+	}
+	for _, st := range tasks {
+		select {
+		case g.slots <- struct{}{}:
+			g.wg.Add(1)
+			go func(st *queue.Task) {
+				defer func() {
+					<-g.slots
+					g.wg.Done()
+				}()
+				g.grow(ctx, st)
+			}(st)
+		default:
+			g.grow(ctx, st)
+		}
+	}
+}
+
+func (g *inProcessGrowth) fail(err error) {
+	g.once.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
@@ -0,0 +1,231 @@
+package botanic
+
+import (
+	"time"
+
+	"github.com/pbanos/botanic/logging"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+GrowOption is a function that changes a setting of a GrowConfig, so that
+programs growing trees with Grow can set only the settings they need, and
+new settings can be added without changing the signature of Grow. Options
+on the PruningStrategy of the GrowConfig expect it not to be nil, as Grow
+and NewPruningStrategy ensure.
+*/
+type GrowOption func(*GrowConfig)
+
+/*
+NewPruningStrategy takes any number of GrowOptions and returns a
+PruningStrategy with the DefaultPruner and the settings of the options that
+apply to it, to grow trees with Work and BranchOut with the same options
+given to Grow. The options on other settings of the growth, such as
+WithDiscreteSplit, must be given to them along with the strategy.
+*/
+func NewPruningStrategy(opts ...GrowOption) *PruningStrategy {
+	gc := &GrowConfig{PruningStrategy: &PruningStrategy{Pruner: DefaultPruner()}}
+	for _, opt := range opts {
+		opt(gc)
+	}
+	return gc.PruningStrategy
+}
+
+/*
+growConfig takes a pruning strategy and the GrowOptions given along with it
+and returns a GrowConfig with a copy of the strategy, or of one with the
+DefaultPruner if it is nil, and the options applied, to grow trees with
+Work, BranchOut and GrowInProcess.
+*/
+func growConfig(ps *PruningStrategy, opts []GrowOption) *GrowConfig {
+	strategy := PruningStrategy{Pruner: DefaultPruner()}
+	if ps != nil {
+		strategy = *ps
+	}
+	gc := &GrowConfig{PruningStrategy: &strategy}
+	for _, opt := range opts {
+		opt(gc)
+	}
+	return gc
+}
+
+/*
+WithPruner takes a Pruner and returns a GrowOption that prunes partitions
+with it.
+*/
+func WithPruner(p Pruner) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.PruningStrategy.Pruner = p
+	}
+}
+
+/*
+WithMinimumEntropy takes an entropy and returns a GrowOption that does not
+develop nodes whose set has that entropy or less.
+*/
+func WithMinimumEntropy(entropy float64) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.PruningStrategy.MinimumEntropy = entropy
+	}
+}
+
+/*
+WithMaxDepth takes a depth and returns a GrowOption that does not develop
+the nodes at that depth, with 0 imposing no limit.
+*/
+func WithMaxDepth(depth int) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.PruningStrategy.MaxDepth = depth
+	}
+}
+
+/*
+WithMinSamplesSplit takes a number of samples and returns a GrowOption that
+does not develop the nodes with fewer samples.
+*/
+func WithMinSamplesSplit(n int) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.PruningStrategy.MinSamplesSplit = n
+	}
+}
+
+/*
+WithMinSamplesLeaf takes a number of samples and returns a GrowOption that
+prunes the partitions with a subtree with samples but fewer than them.
+*/
+func WithMinSamplesLeaf(n int) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.PruningStrategy.MinSamplesLeaf = n
+	}
+}
+
+/*
+WithMaxThresholds takes a number of thresholds and returns a GrowOption that
+evaluates at most that number of candidate thresholds to split the range of
+a continuous feature, with 0 imposing no limit.
+*/
+func WithMaxThresholds(n int) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.MaxThresholds = n
+	}
+}
+
+/*
+WithDiscreteSplit takes a DiscreteSplit and returns a GrowOption that splits
+the sets of nodes with discrete features as it determines.
+*/
+func WithDiscreteSplit(ds DiscreteSplit) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.DiscreteSplit = ds
+	}
+}
+
+/*
+WithFeatureConcurrency takes a number of features and returns a GrowOption
+that computes the partitions of up to that number of features at the same
+time when branching out a node.
+*/
+func WithFeatureConcurrency(n int) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.FeatureConcurrency = n
+	}
+}
+
+/*
+WithSmoothing takes a Smoothing and returns a GrowOption that gives it to
+the tree grown, to smooth the probabilities it predicts.
+*/
+func WithSmoothing(sm tree.Smoothing) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.Smoothing = sm
+	}
+}
+
+/*
+WithObserver takes an Observer and returns a GrowOption that notifies it of
+the events of the growth, together with the Observer already set, if any.
+*/
+func WithObserver(o Observer) GrowOption {
+	return func(gc *GrowConfig) {
+		if gc.Observer != nil {
+			o = MultiObserver(gc.Observer, o)
+		}
+		gc.Observer = o
+	}
+}
+
+/*
+WithLogger takes a Logger and returns a GrowOption that logs the development
+of every node with it at debug level, and the temporary errors workers
+recover from.
+*/
+func WithLogger(l logging.Logger) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.Logger = l
+	}
+}
+
+/*
+WithRetryPolicy takes a RetryPolicy and returns a GrowOption that retries
+the operations of workers that fail with transient errors as it determines.
+*/
+func WithRetryPolicy(rp *RetryPolicy) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.Retry = rp
+	}
+}
+
+/*
+WithMissingValueStrategy takes a MissingValueStrategy and returns a
+GrowOption that gives it to the tree grown.
+*/
+func WithMissingValueStrategy(mvs tree.MissingValueStrategy) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.MissingValueStrategy = mvs
+	}
+}
+
+/*
+WithNodeStore takes a NodeStore and returns a GrowOption that creates the
+nodes of the tree on it.
+*/
+func WithNodeStore(ns tree.NodeStore) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.NodeStore = ns
+	}
+}
+
+/*
+WithQueue takes a queue and the time workers sleep when no task can be
+pulled from it and returns a GrowOption that seeds the tree on the queue and
+works on its tasks.
+*/
+func WithQueue(q queue.Queue, emptyQueueSleep time.Duration) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.Queue = q
+		gc.EmptyQueueSleep = emptyQueueSleep
+	}
+}
+
+/*
+WithConcurrency takes a number of goroutines and returns a GrowOption that
+grows the tree with them.
+*/
+func WithConcurrency(n int) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.Workers = n
+	}
+}
+
+/*
+WithProgress takes an interval and a function and returns a GrowOption that
+calls the function with the progress of the growth every interval, if it is
+positive, and once the growth ends.
+*/
+func WithProgress(interval time.Duration, onProgress func(*Progress)) GrowOption {
+	return func(gc *GrowConfig) {
+		gc.ProgressInterval = interval
+		gc.OnProgress = onProgress
+	}
+}
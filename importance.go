@@ -0,0 +1,133 @@
+package botanic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+FeatureImportance holds the importance of a feature for a tree: the share
+of the decrease in impurity achieved by the tree that comes from the nodes
+that branch out on the feature.
+*/
+type FeatureImportance struct {
+	Feature    feature.Feature
+	Importance float64
+}
+
+/*
+importanceNode holds the weight of the samples of a set that go through a
+node of a tree, in total and for every value of the class feature.
+*/
+type importanceNode struct {
+	weight      float64
+	valueWeight map[string]float64
+}
+
+func (in *importanceNode) impurity() float64 {
+	var result float64
+	for _, w := range in.valueWeight {
+		if w <= 0 {
+			continue
+		}
+		p := w / in.weight
+		result -= p * math.Log(p)
+	}
+	return in.weight * result
+}
+
+/*
+FeatureImportances takes a context, a tree and a set and returns the
+importance of every feature the tree branches out on, sorted by decreasing
+importance. The samples of the set are sent down the tree following its
+Path, and the decrease in impurity of every node that branches out, that is
+the weighted entropy of the class feature for the samples reaching the node
+minus that of the samples reaching its subtrees, is attributed to the
+feature the node branches out on. The importances are normalized to add up
+to 1, unless the tree achieves no decrease in impurity on the set, in which
+case they are all 0.
+
+An error is returned if the samples cannot be retrieved from the set or the
+tree cannot be traversed for a sample.
+*/
+func FeatureImportances(ctx context.Context, t *tree.Tree, s set.Set) ([]*FeatureImportance, error) {
+	samples, err := s.Samples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]*importanceNode)
+	for _, sample := range samples {
+		v, err := sample.ValueFor(t.ClassFeature)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		value := fmt.Sprintf("%v", v)
+		w := set.SampleWeight(sample)
+		path, err := t.Path(ctx, sample)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range path {
+			in, ok := nodes[n.ID]
+			if !ok {
+				in = &importanceNode{valueWeight: make(map[string]float64)}
+				nodes[n.ID] = in
+			}
+			in.weight += w
+			in.valueWeight[value] += w
+		}
+	}
+	decreases := make(map[string]float64)
+	var features []feature.Feature
+	var total float64
+	err = t.Traverse(ctx, false, func(ctx context.Context, n *tree.Node) error {
+		if n.SubtreeFeature == nil {
+			return nil
+		}
+		name := n.SubtreeFeature.Name()
+		if _, ok := decreases[name]; !ok {
+			decreases[name] = 0.0
+			features = append(features, n.SubtreeFeature)
+		}
+		in, ok := nodes[n.ID]
+		if !ok {
+			return nil
+		}
+		decrease := in.impurity()
+		for _, id := range n.SubtreeIDs {
+			if sin, ok := nodes[id]; ok {
+				decrease -= sin.impurity()
+			}
+		}
+		if decrease < 0 {
+			decrease = 0
+		}
+		decreases[name] += decrease
+		total += decrease
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*FeatureImportance, 0, len(features))
+	for _, f := range features {
+		fi := &FeatureImportance{Feature: f}
+		if total > 0 {
+			fi.Importance = decreases[f.Name()] / total
+		}
+		result = append(result, fi)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Importance > result[j].Importance
+	})
+	return result, nil
+}
@@ -0,0 +1,205 @@
+/*
+Package logging provides a leveled, structured Logger interface for the
+messages of the growth of trees and the tools around it, with
+implementations that write them as human-readable lines or as JSON objects,
+one per line, so that the logs of long runs can be processed by machines.
+*/
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Level is the severity of a message.
+*/
+type Level int
+
+/*
+Levels of messages, from the least to the most severe.
+*/
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+/*
+Logger is an interface for types that log messages.
+
+Its Log method takes the level of a message, the message and pairs of field
+names and values that give the details of the message, such as the node
+being developed, and logs the message if its level is enabled. The message
+should not include the values of the fields. An unpaired field name is
+given a nil value. Implementations must be safe for concurrent use.
+*/
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+type writerLogger struct {
+	lock  sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+	now   func() time.Time
+}
+
+type nopLogger struct{}
+
+type fieldLogger struct {
+	l       Logger
+	keyvals []interface{}
+}
+
+/*
+ParseLevel takes the name of a level (debug, info, warn or error) and
+returns the level or an error if the name is not valid.
+*/
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, the following are valid: %s", name, strings.Join(levelNames, ", "))
+}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+/*
+NewText takes a writer and a level and returns a Logger that writes the
+messages with that level or a more severe one to the writer, one per line,
+with the time, the level, the message and its fields in name=value form,
+quoting the values that contain spaces.
+*/
+func NewText(w io.Writer, level Level) Logger {
+	return &writerLogger{w: w, level: level, now: time.Now}
+}
+
+/*
+NewJSON takes a writer and a level and returns a Logger that writes the
+messages with that level or a more severe one to the writer as JSON objects,
+one per line, with the time, level and message under the time, level and msg
+keys and every field under its name. Values that cannot be encoded as JSON
+are written as strings, as are errors.
+*/
+func NewJSON(w io.Writer, level Level) Logger {
+	return &writerLogger{w: w, level: level, json: true, now: time.Now}
+}
+
+/*
+Nop returns a Logger that discards every message.
+*/
+func Nop() Logger {
+	return nopLogger{}
+}
+
+/*
+With takes a logger and pairs of field names and values and returns a
+Logger that logs every message with the given logger, adding the fields to
+those of the message.
+*/
+func With(l Logger, keyvals ...interface{}) Logger {
+	if fl, ok := l.(*fieldLogger); ok {
+		return &fieldLogger{fl.l, append(append([]interface{}(nil), fl.keyvals...), keyvals...)}
+	}
+	return &fieldLogger{l, keyvals}
+}
+
+func (nopLogger) Log(Level, string, ...interface{}) {}
+
+func (fl *fieldLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	fl.l.Log(level, msg, append(append([]interface{}(nil), fl.keyvals...), keyvals...)...)
+}
+
+func (wl *writerLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < wl.level {
+		return
+	}
+	t := wl.now().Format(time.RFC3339)
+	var buf bytes.Buffer
+	if wl.json {
+		writeJSON(&buf, t, level, msg, keyvals)
+	} else {
+		writeText(&buf, t, level, msg, keyvals)
+	}
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	buf.WriteTo(wl.w)
+}
+
+/*
+writeText writes to the buffer the line of a message in the format
+described for NewText.
+*/
+func writeText(buf *bytes.Buffer, t string, level Level, msg string, keyvals []interface{}) {
+	fmt.Fprintf(buf, "%s %-5s %s", t, strings.ToUpper(level.String()), msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{}
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		s := fmt.Sprint(v)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(buf, " %v=%s", keyvals[i], s)
+	}
+	buf.WriteByte('\n')
+}
+
+/*
+writeJSON writes to the buffer the line of a message in the format described
+for NewJSON. Fields are written sorted by name, and a field with the name of
+a previous one replaces its value.
+*/
+func writeJSON(buf *bytes.Buffer, t string, level Level, msg string, keyvals []interface{}) {
+	fields := map[string]interface{}{"time": t, "level": level.String(), "msg": msg}
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{}
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields[fmt.Sprint(keyvals[i])] = v
+	}
+	names := make([]string, 0, len(fields))
+	for n := range fields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	buf.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(n)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(fields[n])
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[n]))
+		}
+		buf.Write(v)
+	}
+	buf.WriteString("}\n")
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+GrowthMetrics holds the metrics of the growth of trees by a process: the
+tasks pulled, completed and failed, the tasks running and pending, the
+duration of the development of every node, the partitions discarded, the
+operations retried after transient errors and the latency of the queries
+made on the training sets.
+
+It implements botanic.RetryObserver, so it records the events of the growth
+of the trees grown with it as Observer, given with botanic.WithObserver, and
+its Set method decorates a training set to record the latency of its
+queries.
+*/
+type GrowthMetrics struct {
+	tasksPulled    *Counter
+	tasksCompleted *Counter
+	tasksFailed    *Counter
+	tasksRunning   *Gauge
+	tasksPending   *Gauge
+	pruned         *Counter
+	branchDuration *Histogram
+	queryDurations map[string]*Histogram
+	retries        map[string]*Counter
+	lock           sync.Mutex
+	startedAt      map[*queue.Task]time.Time
+}
+
+/*
+queueCountTimeout is the time the number of tasks in a queue is waited for
+when exposing its gauges.
+*/
+const queueCountTimeout = 5 * time.Second
+
+/*
+setOperations are the names of the methods of a set.Set whose latency is
+recorded, as they are given in the operation label of the metric.
+*/
+var setOperations = []string{"entropy", "subset", "values", "counts", "weights", "samples", "count", "weight", "histograms"}
+
+/*
+retryOperations are the names of the operations that workers retry after
+transient errors, as they are given in the operation label of the metric.
+*/
+var retryOperations = []string{"pull", "count", "complete", "dead-letters", "task"}
+
+/*
+NewGrowthMetrics takes a registry and returns GrowthMetrics with their
+metrics registered on it.
+*/
+func NewGrowthMetrics(r *Registry) *GrowthMetrics {
+	gm := &GrowthMetrics{
+		tasksPulled:    r.NewCounter("botanic_tasks_pulled_total", "Number of tasks to develop a node whose development has started."),
+		tasksCompleted: r.NewCounter("botanic_tasks_completed_total", "Number of tasks to develop a node completed."),
+		tasksFailed:    r.NewCounter("botanic_tasks_failed_total", "Number of tasks to develop a node that failed."),
+		tasksRunning:   r.NewGauge("botanic_tasks_running", "Number of tasks to develop a node being developed."),
+		tasksPending:   r.NewGauge("botanic_tasks_pending", "Number of tasks created by the nodes branched out by the process minus those it has started, which is the number of nodes waiting to be developed when a single process grows the tree."),
+		pruned:         r.NewCounter("botanic_partitions_pruned_total", "Number of partitions of the sets of nodes discarded when branching them out."),
+		branchDuration: r.NewHistogram("botanic_branch_duration_seconds", "Time spent developing a node, from the start to the end of its task.", nil),
+		queryDurations: make(map[string]*Histogram),
+		retries:        make(map[string]*Counter),
+		startedAt:      make(map[*queue.Task]time.Time),
+	}
+	for _, op := range setOperations {
+		gm.queryDurations[op] = r.NewHistogram("botanic_set_query_duration_seconds", "Time spent querying the training set and its subsets, by operation.", nil, "operation", op)
+	}
+	for _, op := range retryOperations {
+		gm.retries[op] = r.NewCounter("botanic_retries_total", "Number of operations retried by workers after a transient error, by operation.", "operation", op)
+	}
+	return gm
+}
+
+/*
+RegisterQueue takes a registry and a queue and registers on the registry
+gauges with the number of pending and running tasks in the queue, obtained
+with its Count method every time the metrics are exposed. Gauges are -1 if
+the queue cannot be counted in time.
+*/
+func RegisterQueue(r *Registry, q queue.Queue) {
+	count := func(running bool) func() float64 {
+		return func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), queueCountTimeout)
+			defer cancel()
+			p, r, err := q.Count(ctx)
+			if err != nil {
+				return -1
+			}
+			if running {
+				return float64(r)
+			}
+			return float64(p)
+		}
+	}
+	r.NewGaugeFunc("botanic_queue_pending_tasks", "Number of pending tasks in the queue.", count(false))
+	r.NewGaugeFunc("botanic_queue_running_tasks", "Number of running tasks in the queue.", count(true))
+}
+
+/*
+OnTaskStarted counts the task as pulled and running, and no longer pending
+unless it develops a root node, and records when it started.
+*/
+func (gm *GrowthMetrics) OnTaskStarted(ctx context.Context, task *queue.Task) {
+	gm.tasksPulled.Inc()
+	gm.tasksRunning.Add(1)
+	if task.Depth > 0 {
+		gm.tasksPending.Add(-1)
+	}
+	gm.lock.Lock()
+	gm.startedAt[task] = time.Now()
+	gm.lock.Unlock()
+}
+
+/*
+OnNodeBranched counts the subtasks as pending.
+*/
+func (gm *GrowthMetrics) OnNodeBranched(ctx context.Context, task *queue.Task, subtasks []*queue.Task) {
+	gm.tasksPending.Add(float64(len(subtasks)))
+}
+
+/*
+OnPruned counts the discarded partition.
+*/
+func (gm *GrowthMetrics) OnPruned(ctx context.Context, task *queue.Task, f feature.Feature) {
+	gm.pruned.Inc()
+}
+
+/*
+OnTaskCompleted counts the task as completed or failed and records the
+duration of its development. Failed tasks other than the root are counted
+as pending again, as workers drop them back into the queue.
+*/
+func (gm *GrowthMetrics) OnTaskCompleted(ctx context.Context, task *queue.Task, err error) {
+	gm.lock.Lock()
+	startedAt, ok := gm.startedAt[task]
+	delete(gm.startedAt, task)
+	gm.lock.Unlock()
+	if ok {
+		gm.branchDuration.Observe(time.Since(startedAt).Seconds())
+	}
+	gm.tasksRunning.Add(-1)
+	if err != nil {
+		gm.tasksFailed.Inc()
+		if task.Depth > 0 {
+			gm.tasksPending.Add(1)
+		}
+		return
+	}
+	gm.tasksCompleted.Inc()
+}
+
+/*
+OnRetry counts the retry of the operation.
+*/
+func (gm *GrowthMetrics) OnRetry(ctx context.Context, op string, attempt int, err error) {
+	if c, ok := gm.retries[op]; ok {
+		c.Inc()
+	}
+}
+
+/*
+Set takes a set and returns a set.Set that decorates it, recording the
+latency of the queries made on it and on the subsets obtained from it. If
+the set is a set.Aggregator, so is the returned set.
+*/
+func (gm *GrowthMetrics) Set(s set.Set) set.Set {
+	ms := &measuredSet{s, gm}
+	if agg, ok := s.(set.Aggregator); ok {
+		return &measuredAggregatorSet{ms, agg}
+	}
+	return ms
+}
+
+type measuredSet struct {
+	set.Set
+	gm *GrowthMetrics
+}
+
+type measuredAggregatorSet struct {
+	*measuredSet
+	agg set.Aggregator
+}
+
+func (mas *measuredAggregatorSet) LabelHistogramByFeatureValue(ctx context.Context, f *feature.ContinuousFeature, labelFeature feature.Feature) (map[float64]*set.LabelHistogram, error) {
+	defer mas.observe("histograms", time.Now())
+	return mas.agg.LabelHistogramByFeatureValue(ctx, f, labelFeature)
+}
+
+func (mas *measuredAggregatorSet) LabelHistogramByDiscreteValue(ctx context.Context, f *feature.DiscreteFeature, labelFeature feature.Feature) (map[string]*set.LabelHistogram, error) {
+	defer mas.observe("histograms", time.Now())
+	return mas.agg.LabelHistogramByDiscreteValue(ctx, f, labelFeature)
+}
+
+func (ms *measuredSet) observe(op string, start time.Time) {
+	ms.gm.queryDurations[op].Observe(time.Since(start).Seconds())
+}
+
+func (ms *measuredSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
+	defer ms.observe("entropy", time.Now())
+	return ms.Set.Entropy(ctx, f)
+}
+
+func (ms *measuredSet) SubsetWith(ctx context.Context, c feature.Criterion) (set.Set, error) {
+	defer ms.observe("subset", time.Now())
+	s, err := ms.Set.SubsetWith(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return ms.gm.Set(s), nil
+}
+
+func (ms *measuredSet) FeatureValues(ctx context.Context, f feature.Feature) ([]interface{}, error) {
+	defer ms.observe("values", time.Now())
+	return ms.Set.FeatureValues(ctx, f)
+}
+
+func (ms *measuredSet) CountFeatureValues(ctx context.Context, f feature.Feature) (map[string]int, error) {
+	defer ms.observe("counts", time.Now())
+	return ms.Set.CountFeatureValues(ctx, f)
+}
+
+func (ms *measuredSet) FeatureValueWeights(ctx context.Context, f feature.Feature) (map[string]float64, error) {
+	defer ms.observe("weights", time.Now())
+	return ms.Set.FeatureValueWeights(ctx, f)
+}
+
+func (ms *measuredSet) Samples(ctx context.Context) ([]set.Sample, error) {
+	defer ms.observe("samples", time.Now())
+	return ms.Set.Samples(ctx)
+}
+
+func (ms *measuredSet) Count(ctx context.Context) (int, error) {
+	defer ms.observe("count", time.Now())
+	return ms.Set.Count(ctx)
+}
+
+func (ms *measuredSet) Weight(ctx context.Context) (float64, error) {
+	defer ms.observe("weight", time.Now())
+	return ms.Set.Weight(ctx)
+}
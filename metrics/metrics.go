@@ -0,0 +1,352 @@
+/*
+Package metrics provides counters, gauges and histograms that can be exposed
+over HTTP in the Prometheus text format, and the metrics of the growth of
+trees, so that workers growing trees can be monitored.
+
+It writes the text exposition format directly, and only supports the
+metrics used to monitor the growth of trees.
+*/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+DefaultBuckets are the upper bounds in seconds of the buckets of histograms
+of durations when no other buckets are given.
+*/
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+/*
+Registry holds the metrics that are exposed together. It implements
+http.Handler, serving the current values of its metrics in the Prometheus
+text format.
+*/
+type Registry struct {
+	lock    sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	help() string
+	kind() string
+	write(io.Writer)
+}
+
+/*
+Counter is a metric whose value only increases, such as a number of tasks.
+*/
+type Counter struct {
+	desc
+	lock  sync.Mutex
+	value float64
+}
+
+/*
+Gauge is a metric whose value can increase and decrease, such as a number of
+running tasks.
+*/
+type Gauge struct {
+	desc
+	lock  sync.Mutex
+	value float64
+}
+
+/*
+Histogram is a metric that counts observations, such as durations, in
+buckets with increasing upper bounds, and keeps their sum.
+*/
+type Histogram struct {
+	desc
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+type gaugeFunc struct {
+	desc
+	f func() float64
+}
+
+type desc struct {
+	metricName string
+	metricHelp string
+	labels     string
+}
+
+/*
+NewRegistry returns a Registry with no metrics.
+*/
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+/*
+NewCounter takes a name, a help text and pairs of label names and values and
+returns a Counter registered with them on the registry. Metrics with the same
+name must have the same help text and different label values.
+*/
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: newDesc(name, help, labels)}
+	r.register(c)
+	return c
+}
+
+/*
+NewGauge takes a name, a help text and pairs of label names and values and
+returns a Gauge registered with them on the registry.
+*/
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: newDesc(name, help, labels)}
+	r.register(g)
+	return g
+}
+
+/*
+NewGaugeFunc takes a name, a help text, a function and pairs of label names
+and values and registers on the registry a gauge whose value is the one
+returned by the function every time the metrics are exposed. The function
+must be safe for concurrent use.
+*/
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64, labels ...string) {
+	r.register(&gaugeFunc{newDesc(name, help, labels), f})
+}
+
+/*
+NewHistogram takes a name, a help text, the upper bounds of its buckets and
+pairs of label names and values and returns a Histogram registered with them
+on the registry. If no buckets are given, DefaultBuckets are used.
+*/
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{
+		desc:    newDesc(name, help, labels),
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.register(h)
+	return h
+}
+
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+/*
+WriteTo takes a writer and writes to it the current values of the metrics
+of the registry in the Prometheus text format, grouping the metrics with the
+same name. It returns the number of bytes written and an error if they
+cannot be written.
+*/
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.lock.Unlock()
+	var names []string
+	byName := make(map[string][]metric)
+	for _, m := range metrics {
+		if _, ok := byName[m.name()]; !ok {
+			names = append(names, m.name())
+		}
+		byName[m.name()] = append(byName[m.name()], m)
+	}
+	var buf bytes.Buffer
+	for _, name := range names {
+		ms := byName[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp(ms[0].help()))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, ms[0].kind())
+		for _, m := range ms {
+			m.write(&buf)
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+/*
+ServeHTTP writes the current values of the metrics of the registry to the
+response in the Prometheus text format.
+*/
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+/*
+Inc increments the counter by 1.
+*/
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+/*
+Add takes a non-negative value and adds it to the counter. Negative values
+are ignored, as counters cannot decrease.
+*/
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.lock.Lock()
+	c.value += v
+	c.lock.Unlock()
+}
+
+func (c *Counter) kind() string {
+	return "counter"
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.lock.Lock()
+	v := c.value
+	c.lock.Unlock()
+	fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelSet(""), formatFloat(v))
+}
+
+/*
+Set takes a value and sets the gauge to it.
+*/
+func (g *Gauge) Set(v float64) {
+	g.lock.Lock()
+	g.value = v
+	g.lock.Unlock()
+}
+
+/*
+Add takes a value, which may be negative, and adds it to the gauge.
+*/
+func (g *Gauge) Add(v float64) {
+	g.lock.Lock()
+	g.value += v
+	g.lock.Unlock()
+}
+
+func (g *Gauge) kind() string {
+	return "gauge"
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.lock.Lock()
+	v := g.value
+	g.lock.Unlock()
+	fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.labelSet(""), formatFloat(v))
+}
+
+func (gf *gaugeFunc) kind() string {
+	return "gauge"
+}
+
+func (gf *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %s\n", gf.metricName, gf.labelSet(""), formatFloat(gf.f()))
+}
+
+/*
+Observe takes a value and counts it in the buckets of the histogram whose
+upper bound is not lower than it, adding it to the sum of observations.
+*/
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.lock.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.lock.Unlock()
+}
+
+func (h *Histogram) kind() string {
+	return "histogram"
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.lock.Unlock()
+	var cumulative uint64
+	for i, b := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelSet(formatFloat(b)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelSet("+Inf"), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelSet(""), formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelSet(""), count)
+}
+
+/*
+newDesc takes the name and help text of a metric and pairs of label names
+and values and returns its description, with the labels formatted as they
+are exposed. An unpaired label name is given an empty value.
+*/
+func newDesc(name, help string, labels []string) desc {
+	pairs := make([]string, 0, (len(labels)+1)/2)
+	for i := 0; i < len(labels); i += 2 {
+		var value string
+		if i+1 < len(labels) {
+			value = labels[i+1]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(value)))
+	}
+	return desc{name, help, strings.Join(pairs, ",")}
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) help() string {
+	return d.metricHelp
+}
+
+/*
+labelSet takes the upper bound of a histogram bucket, or an empty string for
+other samples, and returns the set of labels of the metric, with the bound
+as the le label if given, or an empty string if there are no labels.
+*/
+func (d desc) labelSet(le string) string {
+	labels := d.labels
+	if le != "" {
+		if labels != "" {
+			labels += ","
+		}
+		labels += fmt.Sprintf("le=%q", le)
+	}
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
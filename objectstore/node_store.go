@@ -0,0 +1,147 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+	"github.com/pbanos/botanic/tree/json"
+)
+
+/*
+batchConcurrency is the number of requests sent at a time to retrieve or
+store a batch of nodes.
+*/
+const batchConcurrency = 16
+
+type nodeStore struct {
+	bucket   *Bucket
+	prefix   string
+	features []feature.Feature
+}
+
+/*
+NewNodeStore takes a bucket, a prefix and the features used by a tree and
+returns a tree.NodeStore that keeps every node as a JSON object, in the
+format of json.MarshalJSONNode, under the nodes/ path of the prefix on the
+bucket. Nodes are given random IDs, so that processes sharing the store can
+create nodes without coordinating, and their criteria and subtree features
+are decoded with the given features.
+
+The returned store is a tree.BatchNodeStore and a tree.BatchGetNodeStore
+that sends the requests for the nodes of a batch concurrently, so that
+storing or retrieving the subtrees of a node does not take a round trip per
+node.
+*/
+func NewNodeStore(b *Bucket, prefix string, features []feature.Feature) tree.NodeStore {
+	return &nodeStore{b, prefix, features}
+}
+
+func (ns *nodeStore) Create(ctx context.Context, n *tree.Node) error {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return fmt.Errorf("generating node ID: %v", err)
+	}
+	n.ID = hex.EncodeToString(id)
+	return ns.Store(ctx, n)
+}
+
+func (ns *nodeStore) Get(ctx context.Context, id string) (*tree.Node, error) {
+	b, ok, err := ns.bucket.Get(ctx, ns.key(id))
+	if err != nil || !ok {
+		return nil, err
+	}
+	n := &tree.Node{}
+	err = json.UnmarshalJSONNodeWithFeatures(n, b, ns.features)
+	if err != nil {
+		return nil, fmt.Errorf("reading node %s: %v", id, err)
+	}
+	return n, nil
+}
+
+func (ns *nodeStore) Store(ctx context.Context, n *tree.Node) error {
+	b, err := json.MarshalJSONNode(n)
+	if err != nil {
+		return fmt.Errorf("storing node %s: %v", n.ID, err)
+	}
+	return ns.bucket.Put(ctx, ns.key(n.ID), b, "application/json")
+}
+
+func (ns *nodeStore) GetBatch(ctx context.Context, ids []string) ([]*tree.Node, error) {
+	nodes := make([]*tree.Node, len(ids))
+	err := concurrently(ctx, len(ids), func(ctx context.Context, i int) error {
+		n, err := ns.Get(ctx, ids[i])
+		nodes[i] = n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (ns *nodeStore) StoreBatch(ctx context.Context, nodes []*tree.Node) error {
+	return concurrently(ctx, len(nodes), func(ctx context.Context, i int) error {
+		return ns.Store(ctx, nodes[i])
+	})
+}
+
+func (ns *nodeStore) Delete(ctx context.Context, n *tree.Node) error {
+	return ns.bucket.Delete(ctx, ns.key(n.ID))
+}
+
+func (ns *nodeStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (ns *nodeStore) key(id string) string {
+	return path.Join(ns.prefix, "nodes", id+".json")
+}
+
+/*
+concurrently takes a context, a number of operations and a function and
+calls the function with a context and every index from 0 to the number of
+operations, with up to batchConcurrency calls running at a time. It returns
+the first error returned by a call, cancelling the context of the rest.
+*/
+func concurrently(ctx context.Context, count int, f func(context.Context, int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < batchConcurrency && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				err := f(ctx, i)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,349 @@
+/*
+Package objectstore provides a minimal client for buckets of object storage
+services with an S3-compatible API, such as Amazon S3 and Google Cloud
+Storage, and a tree.NodeStore that keeps the nodes of a tree as JSON objects
+on a bucket, so that trees can be grown and stored without any other
+backend.
+
+It signs requests with AWS Signature Version 4 directly, and only uses the
+GET, PUT and DELETE object operations.
+*/
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Options holds the configuration to access a bucket.
+
+AccessKeyID, SecretAccessKey and SessionToken are the credentials requests
+are signed with. If AccessKeyID is empty requests are not signed, which only
+works with public buckets.
+
+Region is the region of the bucket, us-east-1 if empty.
+
+Endpoint is the URL of the service, such as http://localhost:9000 for a
+local MinIO server. If empty, the Amazon S3 endpoint of the region is used
+with virtual-hosted-style URLs. Buckets on other endpoints are addressed
+with path-style URLs.
+*/
+type Options struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Endpoint        string
+}
+
+/*
+Bucket is a bucket on an object storage service.
+*/
+type Bucket struct {
+	name   string
+	opts   Options
+	base   *url.URL
+	client *http.Client
+}
+
+/*
+IsURI takes a string and returns whether it is the URI of an object or
+prefix on a bucket that can be opened with Open, that is, whether it starts
+with s3:// or gs://.
+*/
+func IsURI(s string) bool {
+	return strings.HasPrefix(s, "s3://") || strings.HasPrefix(s, "gs://")
+}
+
+/*
+Open takes the URI of an object or prefix on a bucket, such as
+s3://bucket/path/to/tree.json or gs://bucket/path, and returns the bucket
+and the key or prefix in it, or an error if the URI is not valid.
+
+The bucket is accessed with options read from environment variables. For
+s3:// URIs these are AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+AWS_SESSION_TOKEN, AWS_REGION (or AWS_DEFAULT_REGION) and AWS_ENDPOINT_URL.
+For gs:// URIs the bucket is accessed through the XML API of Google Cloud
+Storage at https://storage.googleapis.com with the HMAC key given by
+GS_ACCESS_KEY_ID and GS_SECRET_ACCESS_KEY.
+*/
+func Open(uri string) (*Bucket, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing object storage URI: %v", err)
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("object storage URI %s has no bucket", uri)
+	}
+	var opts *Options
+	switch u.Scheme {
+	case "s3":
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		opts = &Options{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Region:          region,
+			Endpoint:        os.Getenv("AWS_ENDPOINT_URL"),
+		}
+	case "gs":
+		opts = &Options{
+			AccessKeyID:     os.Getenv("GS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("GS_SECRET_ACCESS_KEY"),
+			Region:          "auto",
+			Endpoint:        "https://storage.googleapis.com",
+		}
+	default:
+		return nil, "", fmt.Errorf("unsupported scheme %s for object storage URI, expected s3 or gs", u.Scheme)
+	}
+	b, err := NewBucket(u.Host, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+/*
+NewBucket takes the name of a bucket and the options to access it and
+returns the Bucket, or an error if the endpoint in the options is not a
+valid URL.
+*/
+func NewBucket(name string, opts *Options) (*Bucket, error) {
+	b := &Bucket{name: name, opts: *opts, client: http.DefaultClient}
+	if b.opts.Region == "" {
+		b.opts.Region = "us-east-1"
+	}
+	if b.opts.Endpoint == "" {
+		b.base = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", name, b.opts.Region), Path: "/"}
+		return b, nil
+	}
+	base, err := url.Parse(b.opts.Endpoint)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid object storage endpoint %q", b.opts.Endpoint)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + name + "/"
+	b.base = base
+	return b, nil
+}
+
+/*
+Get takes a context and a key and returns the contents of the object with
+the key on the bucket and true, false if there is no such object, or an
+error if it cannot be read.
+*/
+func (b *Bucket) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, responseError(http.MethodGet, key, resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading object %s: %v", key, err)
+	}
+	return body, true, nil
+}
+
+/*
+Put takes a context, a key, the contents of an object and their content
+type and stores them as the object with the key on the bucket, replacing
+it if it exists. It returns an error if the object cannot be stored.
+*/
+func (b *Bucket) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	resp, err := b.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(http.MethodPut, key, resp)
+	}
+	return nil
+}
+
+/*
+Delete takes a context and a key and deletes the object with the key from
+the bucket, if it exists. It returns an error if the object cannot be
+deleted.
+*/
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError(http.MethodDelete, key, resp)
+	}
+	return nil
+}
+
+func (b *Bucket) String() string {
+	return b.name
+}
+
+/*
+do takes a context, an HTTP method, a key, a body and its content type and
+sends the signed request for the object with the key, returning its
+response or an error if it cannot be sent.
+*/
+func (b *Bucket) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	u := *b.base
+	u.Path += key
+	u.RawPath = escapePath(u.Path)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s object %s: %v", method, key, err)
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, body, time.Now().UTC())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s object %s: %v", method, key, err)
+	}
+	return resp, nil
+}
+
+/*
+sign takes a request, its body and the time it is made and adds to it the
+headers of AWS Signature Version 4, unless the bucket has no credentials.
+*/
+func (b *Bucket) sign(req *http.Request, body []byte, t time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.opts.SessionToken)
+	}
+	if b.opts.AccessKeyID == "" {
+		return
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, b.opts.Region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+b.opts.SecretAccessKey), date)
+	for _, s := range []string{b.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+/*
+escapePath takes the path of a URL and returns it with every byte other
+than unreserved characters and slashes percent-encoded, as required to
+sign requests.
+*/
+func escapePath(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+/*
+canonicalQuery takes the parameters of the query of a URL and returns them
+as required to sign requests: sorted by name and value and joined as
+name=value pairs with every byte other than unreserved characters
+percent-encoded.
+*/
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	escaped := make(map[string]string, len(query))
+	for name := range query {
+		escaped[name] = escapeQueryComponent(name)
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return escaped[names[i]] < escaped[names[j]] })
+	var pairs []string
+	for _, name := range names {
+		values := make([]string, 0, len(query[name]))
+		for _, v := range query[name] {
+			values = append(values, escapeQueryComponent(v))
+		}
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, escaped[name]+"="+v)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func escapeQueryComponent(s string) string {
+	return strings.Replace(escapePath(s), "/", "%2F", -1)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+/*
+responseError takes an HTTP method, a key and an unsuccessful response and
+returns an error with its status and the start of its body, which holds
+the reason of the failure.
+*/
+func responseError(method, key string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s object %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,185 @@
+package botanic
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+)
+
+/*
+Observer is an interface for types that are notified of the events of the
+growth of a tree, so that its progress can be reported or instrumented.
+Methods are called from every goroutine growing the tree, so implementations
+must be safe for concurrent use, and they should return quickly as growth
+waits for them.
+
+OnTaskStarted is called when the development of the node of a task starts.
+
+OnNodeBranched is called when the node of a task is branched out, with the
+tasks to develop its new subtrees.
+
+OnPruned is called for every feature whose partition of the set of a task is
+discarded, either because the pruning strategy prunes it or because the
+feature cannot split the set at all.
+
+OnTaskCompleted is called when the development of the node of a task ends,
+with the error that aborted it, if any.
+*/
+type Observer interface {
+	OnTaskStarted(ctx context.Context, task *queue.Task)
+	OnNodeBranched(ctx context.Context, task *queue.Task, subtasks []*queue.Task)
+	OnPruned(ctx context.Context, task *queue.Task, f feature.Feature)
+	OnTaskCompleted(ctx context.Context, task *queue.Task, err error)
+}
+
+/*
+RetryObserver is an optional interface for Observers that are also notified
+of the operations that workers retry after a transient error, as determined
+by the RetryPolicy they are given with WithRetryPolicy.
+
+OnRetry is called with the name of the operation, the number of the attempt
+that failed, counting from 1, and its error, before waiting to retry it.
+*/
+type RetryObserver interface {
+	Observer
+	OnRetry(ctx context.Context, op string, attempt int, err error)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) OnTaskStarted(context.Context, *queue.Task)                 {}
+func (nopObserver) OnNodeBranched(context.Context, *queue.Task, []*queue.Task) {}
+func (nopObserver) OnPruned(context.Context, *queue.Task, feature.Feature)     {}
+func (nopObserver) OnTaskCompleted(context.Context, *queue.Task, error)        {}
+
+/*
+MultiObserver takes any number of observers and returns an Observer that
+notifies every event to all of them, in the given order. It is a
+RetryObserver that notifies retries to those that are RetryObservers.
+*/
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(append([]Observer(nil), observers...))
+}
+
+type multiObserver []Observer
+
+func (mo multiObserver) OnTaskStarted(ctx context.Context, task *queue.Task) {
+	for _, o := range mo {
+		o.OnTaskStarted(ctx, task)
+	}
+}
+
+func (mo multiObserver) OnNodeBranched(ctx context.Context, task *queue.Task, subtasks []*queue.Task) {
+	for _, o := range mo {
+		o.OnNodeBranched(ctx, task, subtasks)
+	}
+}
+
+func (mo multiObserver) OnPruned(ctx context.Context, task *queue.Task, f feature.Feature) {
+	for _, o := range mo {
+		o.OnPruned(ctx, task, f)
+	}
+}
+
+func (mo multiObserver) OnTaskCompleted(ctx context.Context, task *queue.Task, err error) {
+	for _, o := range mo {
+		o.OnTaskCompleted(ctx, task, err)
+	}
+}
+
+func (mo multiObserver) OnRetry(ctx context.Context, op string, attempt int, err error) {
+	for _, o := range mo {
+		if ro, ok := o.(RetryObserver); ok {
+			ro.OnRetry(ctx, op, attempt, err)
+		}
+	}
+}
+
+/*
+ProgressObserver is an Observer that keeps count of the progress of the
+growth of a tree, which can be retrieved at any time with its Progress
+method. The zero value is ready to use.
+*/
+type ProgressObserver struct {
+	created   int64
+	started   int64
+	completed int64
+	failed    int64
+	pruned    int64
+	depth     int64
+}
+
+/*
+Progress holds the progress of the growth of a tree as observed by a
+ProgressObserver: the number of nodes developed, the depth of the deepest
+node whose development started, the number of nodes being developed and
+waiting to be developed, and the number of partitions discarded.
+*/
+type Progress struct {
+	Nodes   int
+	Depth   int
+	Running int
+	Pending int
+	Pruned  int
+}
+
+/*
+OnTaskStarted counts the task as running and records its depth.
+*/
+func (po *ProgressObserver) OnTaskStarted(ctx context.Context, task *queue.Task) {
+	atomic.AddInt64(&po.started, 1)
+	d := int64(task.Depth)
+	for {
+		current := atomic.LoadInt64(&po.depth)
+		if d <= current || atomic.CompareAndSwapInt64(&po.depth, current, d) {
+			return
+		}
+	}
+}
+
+/*
+OnNodeBranched counts the subtasks as pending.
+*/
+func (po *ProgressObserver) OnNodeBranched(ctx context.Context, task *queue.Task, subtasks []*queue.Task) {
+	atomic.AddInt64(&po.created, int64(len(subtasks)))
+}
+
+/*
+OnPruned counts the discarded partition.
+*/
+func (po *ProgressObserver) OnPruned(ctx context.Context, task *queue.Task, f feature.Feature) {
+	atomic.AddInt64(&po.pruned, 1)
+}
+
+/*
+OnTaskCompleted counts the task as developed, or as pending again if it
+failed, as workers drop failed tasks back into the queue.
+*/
+func (po *ProgressObserver) OnTaskCompleted(ctx context.Context, task *queue.Task, err error) {
+	if err != nil {
+		atomic.AddInt64(&po.failed, 1)
+		return
+	}
+	atomic.AddInt64(&po.completed, 1)
+}
+
+/*
+Progress returns the progress of the growth observed so far. Nodes are
+counted as pending from the root of the tree on, so counts are only
+accurate when the observer sees the whole growth of the tree.
+*/
+func (po *ProgressObserver) Progress() *Progress {
+	started := atomic.LoadInt64(&po.started)
+	completed := atomic.LoadInt64(&po.completed)
+	failed := atomic.LoadInt64(&po.failed)
+	running := started - completed - failed
+	return &Progress{
+		Nodes:   int(completed),
+		Depth:   int(atomic.LoadInt64(&po.depth)),
+		Running: int(running),
+		Pending: int(1 + atomic.LoadInt64(&po.created) - completed - running),
+		Pruned:  int(atomic.LoadInt64(&po.pruned)),
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"sync"
 
 	"github.com/pbanos/botanic/feature"
 	"github.com/pbanos/botanic/queue"
@@ -25,22 +26,260 @@ type Partition struct {
 /*
 NewDiscretePartition takes a context.Context, a set, a discrete feature and a class
 feature and returns a partition of the set for the given feature. The result may be
-nil if the obtained information gain is considered insufficient
+nil if the obtained information gain is considered insufficient. Samples with an
+undefined value for the feature are handled according to the feature's
+UndefinedPolicy.
 */
 func NewDiscretePartition(ctx context.Context, s set.Set, f *feature.DiscreteFeature, classFeature feature.Feature, p Pruner) (*Partition, error) {
-	availableValues := f.AvailableValues()
-	tasks := make([]*queue.Task, 0, len(availableValues)+1)
+	criteria := make([]feature.Criterion, 0, len(f.AvailableValues()))
+	for _, value := range f.AvailableValues() {
+		criteria = append(criteria, feature.NewDiscreteCriterion(f, value))
+	}
+	return newValuesPartition(ctx, s, f, criteria, classFeature, p, f.UndefinedPolicy())
+}
+
+/*
+maxExhaustiveBinarySplitValues is the maximum number of values of a discrete
+feature taken by the samples of a set for which NewBinaryDiscretePartition
+evaluates every split of the values in two. Splits of sets with more values
+are searched greedily.
+*/
+const maxExhaustiveBinarySplitValues = 12
+
+/*
+NewBinaryDiscretePartition takes a context.Context, a set, a discrete feature
+and a class feature and returns a partition of the set for the given feature
+in two: the samples with a value among the subset of values of the feature
+that gives the most information gain and the samples with any other value.
+Every split of the values taken by the samples of the set is evaluated when
+they are no more than maxExhaustiveBinarySplitValues, otherwise the subset is
+built greedily, adding the value that lowers the entropy of the split the
+most one at a time. Values no sample takes go with the heaviest subset. The
+result may be nil if the samples take fewer than 2 values or if the obtained
+information gain is considered insufficient. Samples with an undefined value
+for the feature are handled according to the feature's UndefinedPolicy.
+*/
+func NewBinaryDiscretePartition(ctx context.Context, s set.Set, f *feature.DiscreteFeature, classFeature feature.Feature, p Pruner) (*Partition, error) {
+	histogramsByValue, err := discreteLabelHistograms(ctx, s, f, classFeature)
+	if err != nil {
+		return nil, err
+	}
+	var values, absentValues []string
+	var histograms []*set.LabelHistogram
+	for _, value := range f.AvailableValues() {
+		lh, ok := histogramsByValue[value]
+		if !ok {
+			absentValues = append(absentValues, value)
+			continue
+		}
+		values = append(values, value)
+		histograms = append(histograms, lh)
+	}
+	if len(values) < 2 {
+		return nil, nil
+	}
+	var inLeft []bool
+	if len(values) <= maxExhaustiveBinarySplitValues {
+		inLeft = exhaustiveBinarySplit(histograms)
+	} else {
+		inLeft = greedyBinarySplit(histograms)
+	}
+	var leftValues, rightValues []string
+	var leftWeight, rightWeight float64
+	for i, value := range values {
+		if inLeft[i] {
+			leftValues = append(leftValues, value)
+			leftWeight += histograms[i].Weight
+		} else {
+			rightValues = append(rightValues, value)
+			rightWeight += histograms[i].Weight
+		}
+	}
+	if leftWeight > rightWeight {
+		leftValues = append(leftValues, absentValues...)
+	} else {
+		rightValues = append(rightValues, absentValues...)
+	}
+	criteria := []feature.Criterion{
+		feature.NewDiscreteValuesCriterion(f, leftValues),
+		feature.NewDiscreteValuesCriterion(f, rightValues),
+	}
+	return newValuesPartition(ctx, s, f, criteria, classFeature, p, f.UndefinedPolicy())
+}
+
+/*
+discreteLabelHistograms takes a context.Context, a set, a discrete feature
+and a class feature and returns the label histogram of the samples of the
+set with every value of the feature they take, keyed by the value. It is
+computed by the set if it is a set.Aggregator, and with a single pass over
+its samples otherwise.
+*/
+func discreteLabelHistograms(ctx context.Context, s set.Set, f *feature.DiscreteFeature, classFeature feature.Feature) (map[string]*set.LabelHistogram, error) {
+	if agg, ok := s.(set.Aggregator); ok {
+		return agg.LabelHistogramByDiscreteValue(ctx, f, classFeature)
+	}
+	samples, err := s.Samples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	histograms := make(map[string]*set.LabelHistogram)
+	for _, sample := range samples {
+		v, err := sample.ValueFor(f)
+		if err != nil {
+			return nil, err
+		}
+		sv, ok := v.(string)
+		if !ok {
+			continue
+		}
+		value := f.HashValue(sv)
+		lh, ok := histograms[value]
+		if !ok {
+			lh = &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+			histograms[value] = lh
+		}
+		w := set.SampleWeight(sample)
+		lh.Weight += w
+		label, err := sample.ValueFor(classFeature)
+		if err != nil {
+			return nil, err
+		}
+		if label != nil {
+			lh.LabelWeights[fmt.Sprintf("%v", label)] += w
+		}
+	}
+	return histograms, nil
+}
+
+/*
+exhaustiveBinarySplit takes the label histograms of the samples with every
+value of a discrete feature and returns which values go into the left
+subset of the split in two of the values with the lowest entropy, among all
+of them. The last value always goes into the right subset, so that every
+split is only evaluated once.
+*/
+func exhaustiveBinarySplit(histograms []*set.LabelHistogram) []bool {
+	n := len(histograms)
+	var best []bool
+	var bestEntropy float64
+	for mask := 1; mask < 1<<uint(n-1); mask++ {
+		left := &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+		right := &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+		inLeft := make([]bool, n)
+		for i, lh := range histograms {
+			if mask&(1<<uint(i)) != 0 {
+				inLeft[i] = true
+				addLabelHistogram(left, lh, 1)
+			} else {
+				addLabelHistogram(right, lh, 1)
+			}
+		}
+		entropy := splitEntropy(left, right)
+		if best == nil || entropy < bestEntropy {
+			best = inLeft
+			bestEntropy = entropy
+		}
+	}
+	return best
+}
+
+/*
+greedyBinarySplit takes the label histograms of the samples with every value
+of a discrete feature and returns which values go into the left subset of a
+split in two of the values. Starting with every value in the right subset, it
+moves into the left one the value that gives the split with the lowest
+entropy at a time, and returns the split with the lowest entropy found this
+way.
+*/
+func greedyBinarySplit(histograms []*set.LabelHistogram) []bool {
+	n := len(histograms)
+	left := &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+	right := &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+	for _, lh := range histograms {
+		addLabelHistogram(right, lh, 1)
+	}
+	inLeft := make([]bool, n)
+	var best []bool
+	var bestEntropy float64
+	for step := 1; step < n; step++ {
+		candidate := -1
+		var candidateEntropy float64
+		for i, lh := range histograms {
+			if inLeft[i] {
+				continue
+			}
+			addLabelHistogram(left, lh, 1)
+			addLabelHistogram(right, lh, -1)
+			entropy := splitEntropy(left, right)
+			addLabelHistogram(left, lh, -1)
+			addLabelHistogram(right, lh, 1)
+			if candidate < 0 || entropy < candidateEntropy {
+				candidate = i
+				candidateEntropy = entropy
+			}
+		}
+		inLeft[candidate] = true
+		addLabelHistogram(left, histograms[candidate], 1)
+		addLabelHistogram(right, histograms[candidate], -1)
+		if best == nil || candidateEntropy < bestEntropy {
+			best = append([]bool(nil), inLeft...)
+			bestEntropy = candidateEntropy
+		}
+	}
+	return best
+}
+
+/*
+addLabelHistogram takes a label histogram, another one and a sign and adds
+the weights of the second histogram to the first one, or subtracts them if
+the sign is negative.
+*/
+func addLabelHistogram(to, lh *set.LabelHistogram, sign float64) {
+	to.Weight += sign * lh.Weight
+	for l, w := range lh.LabelWeights {
+		to.LabelWeights[l] += sign * w
+	}
+}
+
+/*
+splitEntropy takes the label histograms of the two subsets of a split and
+returns the sum of their entropies weighted by their weights.
+*/
+func splitEntropy(left, right *set.LabelHistogram) float64 {
+	return labelHistogramEntropy(left)*left.Weight + labelHistogramEntropy(right)*right.Weight
+}
+
+/*
+NewBooleanPartition takes a context.Context, a set, a boolean feature and a class
+feature and returns a partition of the set for the given feature into the samples
+with a true value and those with a false value. The result may be nil if the
+obtained information gain is considered insufficient. Samples with an undefined
+value for the feature are handled according to the feature's UndefinedPolicy.
+*/
+func NewBooleanPartition(ctx context.Context, s set.Set, f *feature.BooleanFeature, classFeature feature.Feature, p Pruner) (*Partition, error) {
+	criteria := []feature.Criterion{feature.NewBooleanCriterion(f, true), feature.NewBooleanCriterion(f, false)}
+	return newValuesPartition(ctx, s, f, criteria, classFeature, p, f.UndefinedPolicy())
+}
+
+/*
+newValuesPartition takes a context.Context, a set, a feature, the criteria
+for every value of the feature, a class feature, a pruner and the
+UndefinedPolicy of the feature and returns the partition of the set into a
+subset for every criterion, or nil if the pruner prunes it. Samples with an
+undefined value for the feature are handled according to the policy.
+*/
+func newValuesPartition(ctx context.Context, s set.Set, f feature.Feature, criteria []feature.Criterion, classFeature feature.Feature, p Pruner, policy feature.UndefinedPolicy) (*Partition, error) {
+	tasks := make([]*queue.Task, 0, len(criteria)+1)
 	informationGain, err := s.Entropy(ctx, classFeature)
 	if err != nil {
 		return nil, err
 	}
-	count, err := s.Count(ctx)
+	totalWeight, err := s.Weight(ctx)
 	if err != nil {
 		return nil, err
 	}
-	totalCount := float64(count)
-	for _, value := range availableValues {
-		n := &tree.Node{FeatureCriterion: feature.NewDiscreteCriterion(f, value)}
+	for _, fc := range criteria {
+		n := &tree.Node{FeatureCriterion: fc}
 		ns, err := s.SubsetWith(ctx, n.FeatureCriterion)
 		if err != nil {
 			return nil, err
@@ -54,11 +293,11 @@ func NewDiscretePartition(ctx context.Context, s set.Set, f *feature.DiscreteFea
 		if err != nil {
 			return nil, err
 		}
-		subtreeCount, err := ns.Count(ctx)
+		subtreeWeight, err := ns.Weight(ctx)
 		if err != nil {
 			return nil, err
 		}
-		informationGain -= nEntropy * float64(subtreeCount) / totalCount
+		informationGain -= nEntropy * subtreeWeight / totalWeight
 	}
 	result := &Partition{f, tasks, informationGain}
 	ok, err := p.Prune(ctx, s, result, classFeature)
@@ -68,25 +307,37 @@ func NewDiscretePartition(ctx context.Context, s set.Set, f *feature.DiscreteFea
 	if ok {
 		return nil, nil
 	}
-	task := &queue.Task{
-		Node: &tree.Node{FeatureCriterion: feature.NewUndefinedCriterion(f)},
-		Set:  s,
+	err = addUndefinedTask(ctx, s, result, policy)
+	if err != nil {
+		return nil, err
 	}
-	result.Tasks = append(result.Tasks, task)
 	return result, nil
 }
 
 /*
 NewContinuousPartition takes a context.Context, a set, a continuous feature and
 a class feature and returns a partition of the set for the given feature. The
-result may be nil if the obtained information gain is considered insufficient
+result may be nil if the obtained information gain is considered insufficient.
+Samples with an undefined value for the feature are handled according to the
+feature's UndefinedPolicy.
 */
 func NewContinuousPartition(ctx context.Context, s set.Set, f *feature.ContinuousFeature, classFeature feature.Feature, p Pruner) (*Partition, error) {
+	return continuousPartition(ctx, s, f, classFeature, p, 0)
+}
+
+/*
+continuousPartition works like NewContinuousPartition, but evaluates at most
+maxThresholds candidate thresholds every time it splits a range of values
+of the feature in two, taking them at the quantiles of the values of the
+samples in the range when there are more. A maxThresholds of 0 imposes no
+limit.
+*/
+func continuousPartition(ctx context.Context, s set.Set, f *feature.ContinuousFeature, classFeature feature.Feature, p Pruner, maxThresholds int) (*Partition, error) {
 	sEntropy, err := s.Entropy(ctx, classFeature)
 	if err != nil {
 		return nil, err
 	}
-	result, err := newContinuousPartition(ctx, s, f, classFeature, sEntropy, math.Inf(-1), math.Inf(1), p)
+	result, err := newContinuousPartition(ctx, s, f, classFeature, sEntropy, math.Inf(-1), math.Inf(1), p, maxThresholds)
 	if err != nil {
 		return nil, err
 	}
@@ -100,29 +351,165 @@ func NewContinuousPartition(ctx context.Context, s set.Set, f *feature.Continuou
 	if ok {
 		return nil, nil
 	}
-	task := &queue.Task{
-		Node: &tree.Node{FeatureCriterion: feature.NewUndefinedCriterion(f)},
-		Set:  s,
+	err = addUndefinedTask(ctx, s, result, f.UndefinedPolicy())
+	if err != nil {
+		return nil, err
 	}
-	result.Tasks = append(result.Tasks, task)
 	return result, nil
 }
 
-func partition(ctx context.Context, s set.Set, f feature.Feature, cf feature.Feature, p Pruner) (*Partition, error) {
+func partition(ctx context.Context, s set.Set, f feature.Feature, cf feature.Feature, gc *GrowConfig) (*Partition, error) {
+	ps := gc.PruningStrategy
 	switch f := f.(type) {
 	default:
 		return nil, fmt.Errorf("unknown feature type %T for feature %v", f, f.Name())
 	case *feature.DiscreteFeature:
-		return NewDiscretePartition(ctx, s, f, cf, p)
+		if gc.DiscreteSplit == DiscreteSplitBinary {
+			return NewBinaryDiscretePartition(ctx, s, f, cf, ps)
+		}
+		return NewDiscretePartition(ctx, s, f, cf, ps)
 	case *feature.ContinuousFeature:
-		return NewContinuousPartition(ctx, s, f, cf, p)
+		return continuousPartition(ctx, s, f, cf, ps, gc.MaxThresholds)
+	case *feature.BooleanFeature:
+		return NewBooleanPartition(ctx, s, f, cf, ps)
+	}
+}
+
+/*
+featurePartitions takes a context.Context, a set, a slice of features, a class
+feature and a growth configuration and returns a slice with the partition of
+the set with every feature, in the same order as the features, and nil for
+features whose partition was pruned. Up to the FeatureConcurrency of the
+configuration partitions are computed at a time, each on its own goroutine.
+The first error found cancels the partitions still being computed and is
+returned, and so is the error of the context if it is done before every
+partition is computed.
+*/
+func featurePartitions(ctx context.Context, s set.Set, features []feature.Feature, cf feature.Feature, gc *GrowConfig) ([]*Partition, error) {
+	partitions := make([]*Partition, len(features))
+	if gc.FeatureConcurrency <= 1 || len(features) < 2 {
+		for i, f := range features {
+			part, err := partition(ctx, s, f, cf, gc)
+			if err != nil {
+				return nil, err
+			}
+			partitions[i] = part
+		}
+		return partitions, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	slots := make(chan struct{}, gc.FeatureConcurrency)
+	for i, f := range features {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			break
+		}
+		wg.Add(1)
+		go func(i int, f feature.Feature) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			part, err := partition(ctx, s, f, cf, gc)
+			if err != nil {
+				fail(err)
+				return
+			}
+			partitions[i] = part
+		}(i, f)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		fail(err)
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return partitions, nil
+}
+
+/*
+addUndefinedTask takes a context.Context, the set a partition was obtained from,
+the partition and an UndefinedPolicy and alters the tasks of the partition
+so that samples with an undefined value for the partition feature are handled
+according to the policy:
+  * UndefinedPolicyParent adds a task with an undefined criterion over the
+    whole set.
+  * UndefinedPolicySkip leaves the partition unaltered.
+  * UndefinedPolicyMajority extends the criterion of the task with the
+    largest set, by weight, to include undefined values, and subsets its set again with it.
+  * UndefinedPolicyDedicated adds a task with an undefined value criterion
+    over the samples of the set with an undefined value for the feature.
+*/
+func addUndefinedTask(ctx context.Context, s set.Set, p *Partition, policy feature.UndefinedPolicy) error {
+	switch policy {
+	case feature.UndefinedPolicySkip:
+		return nil
+	case feature.UndefinedPolicyMajority:
+		var majorityTask *queue.Task
+		var majorityWeight float64
+		for _, t := range p.Tasks {
+			weight, err := t.Set.Weight(ctx)
+			if err != nil {
+				return err
+			}
+			if majorityTask == nil || weight > majorityWeight {
+				majorityTask = t
+				majorityWeight = weight
+			}
+		}
+		if majorityTask == nil {
+			return nil
+		}
+		fc := feature.IncludingUndefined(majorityTask.Node.FeatureCriterion)
+		ns, err := s.SubsetWith(ctx, fc)
+		if err != nil {
+			return err
+		}
+		majorityTask.Node.FeatureCriterion = fc
+		majorityTask.Set = ns
+		return nil
+	case feature.UndefinedPolicyDedicated:
+		fc := feature.NewUndefinedValueCriterion(p.Feature)
+		ns, err := s.SubsetWith(ctx, fc)
+		if err != nil {
+			return err
+		}
+		p.Tasks = append(p.Tasks, &queue.Task{
+			Node: &tree.Node{FeatureCriterion: fc},
+			Set:  ns,
+		})
+		return nil
 	}
+	p.Tasks = append(p.Tasks, &queue.Task{
+		Node: &tree.Node{FeatureCriterion: feature.NewUndefinedCriterion(p.Feature)},
+		Set:  s,
+	})
+	return nil
 }
 
 /*
-newRangePartition returns the partition of the given range in 2 parts that generates the most information gain
+newRangePartition returns the partition of the given range in 2 parts that generates the most information gain,
+among the candidate thresholds chosen by thresholdIndexes for the given maximum
 */
-func newRangePartition(ctx context.Context, s set.Set, f *feature.ContinuousFeature, classFeature feature.Feature, entropy, a, b float64) (*Partition, error) {
+func newRangePartition(ctx context.Context, s set.Set, f *feature.ContinuousFeature, classFeature feature.Feature, entropy, a, b float64, maxThresholds int) (*Partition, error) {
+	if agg, ok := s.(set.Aggregator); ok {
+		return newAggregatedRangePartition(ctx, s, agg, f, classFeature, entropy, a, b, maxThresholds)
+	}
 	var floatValues []float64
 	sfvs, err := s.FeatureValues(ctx, f)
 	if err != nil {
@@ -136,9 +523,20 @@ func newRangePartition(ctx context.Context, s set.Set, f *feature.ContinuousFeat
 		return nil, nil
 	}
 	sort.Float64s(floatValues)
+	var weights []float64
+	if maxThresholds > 0 && len(floatValues)-1 > maxThresholds {
+		valueWeights, err := s.FeatureValueWeights(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		weights = make([]float64, len(floatValues))
+		for i, v := range floatValues {
+			weights[i] = valueWeights[fmt.Sprintf("%v", v)]
+		}
+	}
 	var result *Partition
-	for i, vf := range floatValues[1:] {
-		threshold := (floatValues[i] + vf) / 2.0
+	for _, i := range thresholdIndexes(len(floatValues), weights, maxThresholds) {
+		threshold := (floatValues[i] + floatValues[i+1]) / 2.0
 
 		n := &tree.Node{FeatureCriterion: feature.NewContinuousCriterion(f, a, threshold)}
 		ns, err := s.SubsetWith(ctx, n.FeatureCriterion)
@@ -161,21 +559,20 @@ func newRangePartition(ctx context.Context, s set.Set, f *feature.ContinuousFeat
 		}
 		tasks := []*queue.Task{t1, t2}
 		informationGain := entropy
-		count, err := s.Count(ctx)
+		totalWeight, err := s.Weight(ctx)
 		if err != nil {
 			return nil, err
 		}
-		totalCount := float64(count)
 		for _, task := range tasks {
 			taskEntropy, err := task.Set.Entropy(ctx, classFeature)
 			if err != nil {
 				return nil, err
 			}
-			taskCount, err := task.Set.Count(ctx)
+			taskWeight, err := task.Set.Weight(ctx)
 			if err != nil {
 				return nil, err
 			}
-			informationGain -= taskEntropy * float64(taskCount) / totalCount
+			informationGain -= taskEntropy * taskWeight / totalWeight
 		}
 		if result == nil || result.informationGain < informationGain {
 			result = &Partition{f, tasks, informationGain}
@@ -184,17 +581,142 @@ func newRangePartition(ctx context.Context, s set.Set, f *feature.ContinuousFeat
 	return result, nil
 }
 
+/*
+newAggregatedRangePartition works like newRangePartition for sets that
+implement set.Aggregator: the information gain of every candidate threshold
+is computed from a single label histogram of the set by value of the feature,
+and the set is only subset with the criteria of the best threshold.
+*/
+func newAggregatedRangePartition(ctx context.Context, s set.Set, agg set.Aggregator, f *feature.ContinuousFeature, classFeature feature.Feature, entropy, a, b float64, maxThresholds int) (*Partition, error) {
+	histogram, err := agg.LabelHistogramByFeatureValue(ctx, f, classFeature)
+	if err != nil {
+		return nil, err
+	}
+	if len(histogram) < 2 {
+		return nil, nil
+	}
+	floatValues := make([]float64, 0, len(histogram))
+	for v := range histogram {
+		floatValues = append(floatValues, v)
+	}
+	sort.Float64s(floatValues)
+	totalWeight, err := s.Weight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	left := &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+	right := &set.LabelHistogram{LabelWeights: make(map[string]float64)}
+	for _, lh := range histogram {
+		right.Weight += lh.Weight
+		for l, w := range lh.LabelWeights {
+			right.LabelWeights[l] += w
+		}
+	}
+	weights := make([]float64, len(floatValues))
+	for i, v := range floatValues {
+		weights[i] = histogram[v].Weight
+	}
+	candidates := thresholdIndexes(len(floatValues), weights, maxThresholds)
+	var threshold, informationGain float64
+	var i int
+	for ci, candidate := range candidates {
+		for ; i <= candidate; i++ {
+			lh := histogram[floatValues[i]]
+			left.Weight += lh.Weight
+			right.Weight -= lh.Weight
+			for l, w := range lh.LabelWeights {
+				left.LabelWeights[l] += w
+				right.LabelWeights[l] -= w
+			}
+		}
+		ig := entropy - labelHistogramEntropy(left)*left.Weight/totalWeight - labelHistogramEntropy(right)*right.Weight/totalWeight
+		if ci == 0 || informationGain < ig {
+			threshold = (floatValues[candidate] + floatValues[candidate+1]) / 2.0
+			informationGain = ig
+		}
+	}
+	var tasks []*queue.Task
+	for _, fc := range []feature.Criterion{feature.NewContinuousCriterion(f, a, threshold), feature.NewContinuousCriterion(f, threshold, b)} {
+		n := &tree.Node{FeatureCriterion: fc}
+		ns, err := s.SubsetWith(ctx, fc)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, &queue.Task{Node: n, Set: ns})
+	}
+	return &Partition{f, tasks, informationGain}, nil
+}
+
+/*
+thresholdIndexes takes the number n of sorted distinct values of a continuous
+feature in a range, the weights of the samples with each of them and a
+maximum number of thresholds and returns the indexes i of the values such
+that a threshold between the values at i and i+1 should be evaluated to
+split the range. With no maximum, or no more than the maximum candidates,
+every index is returned and the weights are not needed. Otherwise the
+indexes are those of the quantiles dividing the samples into maxThresholds+1
+bins of similar weight, or the last index if the weight of the last value is
+so large that no quantile falls before it.
+*/
+func thresholdIndexes(n int, weights []float64, maxThresholds int) []int {
+	result := make([]int, 0, n-1)
+	if maxThresholds <= 0 || n-1 <= maxThresholds {
+		for i := 0; i < n-1; i++ {
+			result = append(result, i)
+		}
+		return result
+	}
+	var total, cumulative float64
+	for _, w := range weights {
+		total += w
+	}
+	bins := float64(maxThresholds + 1)
+	k := 1
+	for i := 0; i < n-1 && k <= maxThresholds; i++ {
+		cumulative += weights[i]
+		if cumulative >= total*float64(k)/bins {
+			result = append(result, i)
+			for k <= maxThresholds && cumulative >= total*float64(k)/bins {
+				k++
+			}
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, n-2)
+	}
+	return result
+}
+
+/*
+labelHistogramEntropy takes a label histogram and returns the entropy of the
+distribution of the weights of its label values.
+*/
+func labelHistogramEntropy(lh *set.LabelHistogram) float64 {
+	var total, result float64
+	for _, w := range lh.LabelWeights {
+		total += w
+	}
+	for _, w := range lh.LabelWeights {
+		if w <= 0 {
+			continue
+		}
+		p := w / total
+		result -= p * math.Log(p)
+	}
+	return result
+}
+
 /*
 newContinuousPartition takes a context.Context, a set, a continuous feature,
 a class Feature, the entropy of the given set, an range of float64 numbers
-a-b and a pruner and returns a partition of the set for the given range or
-an error.
+a-b, a pruner and a maximum number of thresholds and returns a partition of
+the set for the given range or an error.
 The partition is built using newRangePartition to split the range into 2 ranges
 and then recursively call itself until the range can no longer be splitted or
 the pruner prunes the obtained range partition.
 */
-func newContinuousPartition(ctx context.Context, s set.Set, f *feature.ContinuousFeature, classFeature feature.Feature, entropy, a, b float64, p Pruner) (*Partition, error) {
-	initialPartition, err := newRangePartition(ctx, s, f, classFeature, entropy, a, b)
+func newContinuousPartition(ctx context.Context, s set.Set, f *feature.ContinuousFeature, classFeature feature.Feature, entropy, a, b float64, p Pruner, maxThresholds int) (*Partition, error) {
+	initialPartition, err := newRangePartition(ctx, s, f, classFeature, entropy, a, b, maxThresholds)
 	if err != nil {
 		return nil, err
 	}
@@ -210,11 +732,10 @@ func newContinuousPartition(ctx context.Context, s set.Set, f *feature.Continuou
 	}
 	var resultTasks []*queue.Task
 	informationGain := entropy
-	count, err := s.Count(ctx)
+	totalWeight, err := s.Weight(ctx)
 	if err != nil {
 		return nil, err
 	}
-	totalCount := float64(count)
 	for _, task := range initialPartition.Tasks {
 		fc, _ := task.Node.FeatureCriterion.(feature.ContinuousCriterion)
 		a, b := fc.Interval()
@@ -222,28 +743,28 @@ func newContinuousPartition(ctx context.Context, s set.Set, f *feature.Continuou
 		if err != nil {
 			return nil, err
 		}
-		subpartition, err := newContinuousPartition(ctx, task.Set, f, classFeature, subsetEntropy, a, b, p)
+		subpartition, err := newContinuousPartition(ctx, task.Set, f, classFeature, subsetEntropy, a, b, p, maxThresholds)
 		if err != nil {
 			return nil, err
 		}
 		if subpartition == nil {
-			taskCount, err := task.Set.Count(ctx)
+			taskWeight, err := task.Set.Weight(ctx)
 			if err != nil {
 				return nil, err
 			}
 			resultTasks = append(resultTasks, task)
-			informationGain -= subsetEntropy * float64(taskCount) / totalCount
+			informationGain -= subsetEntropy * taskWeight / totalWeight
 		} else {
 			for _, st := range subpartition.Tasks {
 				stEntropy, err := st.Set.Entropy(ctx, classFeature)
 				if err != nil {
 					return nil, err
 				}
-				stCount, err := st.Set.Count(ctx)
+				stWeight, err := st.Set.Weight(ctx)
 				if err != nil {
 					return nil, err
 				}
-				informationGain -= stEntropy * float64(stCount) / totalCount
+				informationGain -= stEntropy * stWeight / totalWeight
 				resultTasks = append(resultTasks, st)
 			}
 		}
@@ -0,0 +1,63 @@
+package botanic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+cancellingSet is a set that cancels a context the first time the entropy
+of any of its subsets is computed, to cancel a growth while the partitions
+of a node are being computed.
+*/
+type cancellingSet struct {
+	set.Set
+	once   *sync.Once
+	cancel context.CancelFunc
+}
+
+func (s *cancellingSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
+	s.once.Do(s.cancel)
+	return s.Set.Entropy(ctx, f)
+}
+
+func TestFeaturePartitionsFailsWhenCancelledMidLoop(t *testing.T) {
+	class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+	var features []feature.Feature
+	for i := 0; i < 32; i++ {
+		features = append(features, feature.NewDiscreteFeature(fmt.Sprintf("f%d", i), []string{"x", "y"}))
+	}
+	var samples []set.Sample
+	for i := 0; i < 16; i++ {
+		values := map[string]interface{}{"class": "yes"}
+		if i%2 == 0 {
+			values["class"] = "no"
+		}
+		for j, f := range features {
+			values[f.Name()] = "x"
+			if (i+j)%3 == 0 {
+				values[f.Name()] = "y"
+			}
+		}
+		samples = append(samples, set.NewSample(values))
+	}
+	gc := growConfig(nil, []GrowOption{WithPruner(NoPruner()), WithFeatureConcurrency(len(features))})
+	// With a slot for every feature, the loop starting the partitions
+	// may see the context done either before or after taking a slot, so
+	// the growth is cancelled several times to go through both.
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := &cancellingSet{Set: set.New(samples), once: &sync.Once{}, cancel: cancel}
+		partitions, err := featurePartitions(ctx, s, features, class, gc)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected a context.Canceled error, got partitions %v and error %v", partitions, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package botanic
+
+import (
+	"context"
+
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+PostPrune takes a context, a fully-grown tree and a validation set and
+applies reduced error pruning to the tree: it traverses the tree bottom-up
+and collapses into a leaf every node whose subtrees do not predict the
+samples of the validation set reaching it better than the node's own
+prediction does. The nodes under a collapsed node are deleted from the
+tree's node store.
+
+It returns the number of nodes deleted from the tree or an error if the
+validation set cannot be read, the tree cannot be traversed or the node
+store cannot be updated.
+*/
+func PostPrune(ctx context.Context, t *tree.Tree, validation set.Set) (int, error) {
+	leafHits, err := validationLeafHits(ctx, t, validation)
+	if err != nil {
+		return 0, err
+	}
+	subtreeHits := make(map[string]int)
+	var deleted int
+	err = t.Traverse(ctx, true, func(ctx context.Context, n *tree.Node) error {
+		if len(n.SubtreeIDs) == 0 {
+			subtreeHits[n.ID] = leafHits[n.ID]
+			return nil
+		}
+		var hits int
+		for _, id := range n.SubtreeIDs {
+			hits += subtreeHits[id]
+			delete(subtreeHits, id)
+		}
+		if leafHits[n.ID] < hits {
+			subtreeHits[n.ID] = hits
+			return nil
+		}
+		count, err := collapse(ctx, t, n)
+		if err != nil {
+			return err
+		}
+		deleted += count
+		subtreeHits[n.ID] = leafHits[n.ID]
+		return nil
+	})
+	return deleted, err
+}
+
+/*
+validationLeafHits takes a context, a tree and a validation set and returns
+a map with the number of samples in the set that every node in the tree
+would predict correctly if it were a leaf, indexed by node ID.
+*/
+func validationLeafHits(ctx context.Context, t *tree.Tree, validation set.Set) (map[string]int, error) {
+	samples, err := validation.Samples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	leafHits := make(map[string]int)
+	for _, s := range samples {
+		v, err := s.ValueFor(t.ClassFeature)
+		if err != nil {
+			return nil, err
+		}
+		path, err := t.Path(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range path {
+			if n.Prediction == nil {
+				continue
+			}
+			pv, _ := n.Prediction.PredictedValue()
+			if pv == v {
+				leafHits[n.ID]++
+			}
+		}
+	}
+	return leafHits, nil
+}
+
+/*
+collapse takes a context, a tree and a node from it and turns the node into
+a leaf, deleting all the nodes under it from the tree's node store. It returns
+the number of deleted nodes or an error.
+*/
+func collapse(ctx context.Context, t *tree.Tree, n *tree.Node) (int, error) {
+	var deleted int
+	subnodes, err := tree.GetNodes(ctx, t.NodeStore, n.SubtreeIDs)
+	if err != nil {
+		return deleted, err
+	}
+	for _, sn := range subnodes {
+		if sn == nil {
+			continue
+		}
+		count, err := collapse(ctx, t, sn)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		err = t.NodeStore.Delete(ctx, sn)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	n.SubtreeIDs = nil
+	n.SubtreeFeature = nil
+	n.Surrogate = nil
+	n.InformationGain = 0
+	return deleted, t.NodeStore.Store(ctx, n)
+}
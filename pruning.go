@@ -24,6 +24,41 @@ type PruningStrategy struct {
 	// entropy equal or below this will not be
 	// developed.
 	MinimumEntropy float64
+	// MaxDepth is the maximum depth of the nodes
+	// of the tree, the root node being at depth 0.
+	// Nodes at this depth will not be developed.
+	// A MaxDepth of 0 imposes no limit.
+	MaxDepth int
+	// MinSamplesSplit is the minimum number of
+	// samples in a node's training set for it to
+	// be developed.
+	MinSamplesSplit int
+	// MinSamplesLeaf is the minimum number of
+	// samples in the training set of every subtree
+	// with samples of a partition. Partitions with
+	// smaller subtrees are pruned.
+	MinSamplesLeaf int
+}
+
+/*
+Prune takes a context.Context, a set, a partition and a class Feature and
+returns true if any of the subtrees of the partition with samples has fewer
+than MinSamplesLeaf samples, or the result of the PruningStrategy's Pruner
+otherwise.
+*/
+func (ps *PruningStrategy) Prune(ctx context.Context, s set.Set, p *Partition, classFeature feature.Feature) (bool, error) {
+	if ps.MinSamplesLeaf > 1 {
+		for _, t := range p.Tasks {
+			count, err := t.Set.Count(ctx)
+			if err != nil {
+				return false, err
+			}
+			if count > 0 && count < ps.MinSamplesLeaf {
+				return true, nil
+			}
+		}
+	}
+	return ps.Pruner.Prune(ctx, s, p, classFeature)
 }
 
 /*
@@ -0,0 +1,402 @@
+// Package pgqueue provides an implementation of the Queue
+// interface in the queue package that keeps the tasks, along
+// with the samples of their sets, on a table of a PostgreSQL
+// database, so that the memory of the processes pushing and
+// pulling them does not have to fit the tasks pending, and
+// workers on other hosts can pull them.
+//
+// Tasks are encoded with the coordinator.TaskCodec and pulled
+// with SELECT ... FOR UPDATE SKIP LOCKED, so that concurrent
+// workers never pull the same task. Tasks pulled by a worker
+// that crashes are made pending again once the visibility
+// timeout of the queue expires, as if they had been dropped.
+package pgqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/coordinator"
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set/sqlset"
+
+	// Import of PostgreSQL driver
+	_ "github.com/lib/pq"
+)
+
+const (
+	// tasksTable is the name of the table of the tasks, or
+	// its suffix when a table prefix is given.
+	tasksTable = "tasks"
+
+	tableCreateStmt = `CREATE TABLE IF NOT EXISTS %s (
+		seq BIGSERIAL,
+		id TEXT PRIMARY KEY,
+		priority INTEGER NOT NULL,
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		lease TEXT,
+		visible_until TIMESTAMPTZ,
+		task BYTEA NOT NULL)`
+
+	indexCreateStmt = `CREATE INDEX IF NOT EXISTS %[1]s_pending ON %[1]s (priority DESC, seq DESC) WHERE state = 'pending'`
+
+	insertStmt = `INSERT INTO %s (id, priority, state, attempts, task) VALUES ($1, $2, 'pending', $3, $4)`
+
+	// pullStmt takes the pending task with the highest
+	// priority, or the one pushed last among those with
+	// the same priority, skipping those being pulled by
+	// other workers, and marks it as running with the
+	// lease $1 until $2 seconds from now, or for good if
+	// $2 is 0.
+	pullStmt = `UPDATE %[1]s SET state = 'running', attempts = attempts + 1, lease = $1,
+		visible_until = CASE WHEN $2::float8 > 0 THEN now() + $2::float8 * interval '1 second' END
+		WHERE id = (SELECT id FROM %[1]s WHERE state = 'pending' ORDER BY priority DESC, seq DESC LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, attempts, task`
+
+	// dropState is the state of a task dropped with the
+	// maximum attempts $1: dead once it has been pulled
+	// that many times, pending otherwise.
+	dropState = `CASE WHEN $1::integer > 0 AND attempts >= $1::integer THEN 'dead' ELSE 'pending' END`
+
+	// expireStmt drops the running tasks whose visibility
+	// timeout expired.
+	expireStmt = `UPDATE %[1]s SET state = ` + dropState + `, lease = NULL, visible_until = NULL, seq = nextval(pg_get_serial_sequence('%[1]s', 'seq'))
+		WHERE state = 'running' AND visible_until < now()`
+
+	dropStmt = `UPDATE %[1]s SET state = ` + dropState + `, lease = NULL, visible_until = NULL, seq = nextval(pg_get_serial_sequence('%[1]s', 'seq'))
+		WHERE id = $2 AND state = 'running' AND lease = $3`
+
+	completeStmt = `DELETE FROM %s WHERE id = $1 AND state = 'running' AND lease = $2`
+
+	countStmt = `SELECT count(*) FILTER (WHERE state = 'pending'), count(*) FILTER (WHERE state = 'running') FROM %s`
+
+	deadLettersStmt = `SELECT attempts, task FROM %s WHERE state = 'dead' ORDER BY seq`
+
+	requeueStmt = `UPDATE %[1]s SET state = 'pending', attempts = 0, seq = nextval(pg_get_serial_sequence('%[1]s', 'seq'))
+		WHERE id = $1 AND state = 'dead'`
+)
+
+var tablePrefixRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Options holds the settings of a queue.
+//
+// TablePrefix is prepended with an underscore to the name of
+// the tasks table, so that the tasks of several trees can be
+// kept on the same database. It may only contain lowercase
+// letters, digits and underscores, and cannot start with a
+// digit.
+//
+// VisibilityTimeout is the time a worker has to complete or
+// drop a task it pulled before it is made pending again for
+// other workers, which should be well over the time to develop
+// any node. The context returned with the task is cancelled
+// once it expires. If it is not positive tasks are never made
+// pending again on their own.
+//
+// MaxAttempts is the number of times a task can be pulled
+// before it is kept as a dead letter instead of being made
+// pending again when it is dropped or its visibility timeout
+// expires. If it is not positive tasks can be pulled any
+// number of times.
+type Options struct {
+	TablePrefix       string
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+}
+
+type pgQueue struct {
+	db                *sql.DB
+	table             string
+	codec             *coordinator.TaskCodec
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	leases            map[string]*lease
+	lock              *sync.Mutex
+	ctx               context.Context
+	ctxCancel         context.CancelFunc
+}
+
+// lease is a task pulled by the process, with the token it is
+// marked with on the table while running and the function to
+// cancel the context returned with it.
+type lease struct {
+	token  string
+	cancel context.CancelFunc
+}
+
+// pgQueue must implement DeadLetterQueue and BatchQueue,
+// checked at compile time
+var _ queue.DeadLetterQueue = (*pgQueue)(nil)
+var _ queue.BatchQueue = (*pgQueue)(nil)
+
+// New takes a context, a PostgreSQL database connection URL,
+// the features of the tree whose tasks it will hold and
+// options, and returns a queue on the tasks table of the
+// database, which is created if it does not exist, or an error
+// if the options are not valid or the table cannot be created.
+// Nil options are the same as the zero Options.
+//
+// The queue only completes and drops the tasks pulled from it,
+// so every worker must complete or drop its tasks on the queue
+// it pulled them from. The tasks on the table remain there
+// when the queue is stopped.
+func New(ctx context.Context, url string, features []feature.Feature, opts *Options) (queue.DeadLetterQueue, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	table := tasksTable
+	if opts.TablePrefix != "" {
+		if !tablePrefixRegexp.MatchString(opts.TablePrefix) {
+			return nil, fmt.Errorf("invalid table prefix %q: it may only contain lowercase letters, digits and underscores and cannot start with a digit", opts.TablePrefix)
+		}
+		table = opts.TablePrefix + "_" + tasksTable
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{tableCreateStmt, indexCreateStmt} {
+		_, err = db.ExecContext(ctx, fmt.Sprintf(stmt, table))
+		if err != nil {
+			db.Close()
+			return nil, wrapError(fmt.Errorf("creating tasks table %s: %w", table, err))
+		}
+	}
+	qctx, cancel := context.WithCancel(context.Background())
+	return &pgQueue{
+		db:                db,
+		table:             table,
+		codec:             coordinator.NewTaskCodec(features),
+		visibilityTimeout: opts.VisibilityTimeout,
+		maxAttempts:       opts.MaxAttempts,
+		leases:            make(map[string]*lease),
+		lock:              &sync.Mutex{},
+		ctx:               qctx,
+		ctxCancel:         cancel,
+	}, nil
+}
+
+// wrapError takes an error of an operation on the database
+// and returns it wrapping queue.ErrUnavailable if it was
+// caused by the loss of the connection to it, or as is
+// otherwise.
+func wrapError(err error) error {
+	if err != nil && sqlset.IsConnectionLost(err) {
+		return fmt.Errorf("%w: %v", queue.ErrUnavailable, err)
+	}
+	return err
+}
+
+func (pq *pgQueue) Push(ctx context.Context, t *queue.Task) error {
+	return pq.PushAll(ctx, []*queue.Task{t})
+}
+
+func (pq *pgQueue) PushAll(ctx context.Context, tasks []*queue.Task) error {
+	encoded := make([][]byte, 0, len(tasks))
+	for _, t := range tasks {
+		b, err := pq.codec.EncodeTask(ctx, t)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, b)
+	}
+	tx, err := pq.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(insertStmt, pq.table))
+	if err != nil {
+		return wrapError(err)
+	}
+	defer stmt.Close()
+	for i, t := range tasks {
+		_, err = stmt.ExecContext(ctx, t.ID(), t.Priority, t.Attempts, encoded[i])
+		if err != nil {
+			return wrapError(fmt.Errorf("pushing task %s: %w", t.ID(), err))
+		}
+	}
+	return wrapError(tx.Commit())
+}
+
+func (pq *pgQueue) Pull(ctx context.Context) (*queue.Task, context.Context, error) {
+	err := pq.expire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	var id string
+	var attempts int
+	var b []byte
+	err = pq.db.QueryRowContext(ctx, fmt.Sprintf(pullStmt, pq.table), token, pq.visibilityTimeout.Seconds()).Scan(&id, &attempts, &b)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+	var tctx context.Context
+	var cancel context.CancelFunc
+	if pq.visibilityTimeout > 0 {
+		tctx, cancel = context.WithTimeout(pq.ctx, pq.visibilityTimeout)
+	} else {
+		tctx, cancel = context.WithCancel(pq.ctx)
+	}
+	pq.lock.Lock()
+	if l, ok := pq.leases[id]; ok {
+		l.cancel()
+	}
+	pq.leases[id] = &lease{token, cancel}
+	pq.lock.Unlock()
+	task, err := pq.codec.DecodeTask(b)
+	if err != nil {
+		pq.Drop(context.Background(), id)
+		return nil, nil, err
+	}
+	task.Attempts = attempts
+	return task, tctx, nil
+}
+
+// expire drops the running tasks whose visibility timeout
+// expired, keeping those that ran out of attempts as dead
+// letters. It is run before pulling and counting tasks, so
+// that the tasks of workers that crashed are pulled again.
+func (pq *pgQueue) expire(ctx context.Context) error {
+	_, err := pq.db.ExecContext(ctx, fmt.Sprintf(expireStmt, pq.table), pq.maxAttempts)
+	if err != nil {
+		return wrapError(fmt.Errorf("expiring tasks: %w", err))
+	}
+	return nil
+}
+
+// endLease takes the ID of a task and returns the token of
+// the lease of the process on it, if any, cancelling the
+// context returned with the task and forgetting the lease.
+func (pq *pgQueue) endLease(id string) (string, bool) {
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+	l, ok := pq.leases[id]
+	if !ok {
+		return "", false
+	}
+	delete(pq.leases, id)
+	l.cancel()
+	return l.token, true
+}
+
+func (pq *pgQueue) Drop(ctx context.Context, id string) error {
+	token, ok := pq.endLease(id)
+	if !ok {
+		return nil
+	}
+	_, err := pq.db.ExecContext(ctx, fmt.Sprintf(dropStmt, pq.table), pq.maxAttempts, id, token)
+	if err != nil {
+		return wrapError(fmt.Errorf("dropping task %s: %w", id, err))
+	}
+	return nil
+}
+
+func (pq *pgQueue) Complete(ctx context.Context, id string) error {
+	pq.lock.Lock()
+	l, ok := pq.leases[id]
+	pq.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	_, err := pq.db.ExecContext(ctx, fmt.Sprintf(completeStmt, pq.table), id, l.token)
+	if err != nil {
+		return wrapError(fmt.Errorf("completing task %s: %w", id, err))
+	}
+	pq.endLease(id)
+	return nil
+}
+
+func (pq *pgQueue) Count(ctx context.Context) (int, int, error) {
+	err := pq.expire(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	var pending, running int
+	err = pq.db.QueryRowContext(ctx, fmt.Sprintf(countStmt, pq.table)).Scan(&pending, &running)
+	if err != nil {
+		return 0, 0, wrapError(fmt.Errorf("counting tasks: %w", err))
+	}
+	return pending, running, nil
+}
+
+func (pq *pgQueue) DeadLetters(ctx context.Context) ([]*queue.Task, error) {
+	rows, err := pq.db.QueryContext(ctx, fmt.Sprintf(deadLettersStmt, pq.table))
+	if err != nil {
+		return nil, wrapError(fmt.Errorf("listing dead letters: %w", err))
+	}
+	defer rows.Close()
+	var tasks []*queue.Task
+	for rows.Next() {
+		var attempts int
+		var b []byte
+		err = rows.Scan(&attempts, &b)
+		if err != nil {
+			return nil, wrapError(fmt.Errorf("listing dead letters: %w", err))
+		}
+		task, err := pq.codec.DecodeTask(b)
+		if err != nil {
+			return nil, err
+		}
+		task.Attempts = attempts
+		tasks = append(tasks, task)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, wrapError(fmt.Errorf("listing dead letters: %w", err))
+	}
+	return tasks, nil
+}
+
+func (pq *pgQueue) Requeue(ctx context.Context, id string) error {
+	result, err := pq.db.ExecContext(ctx, fmt.Sprintf(requeueStmt, pq.table), id)
+	if err != nil {
+		return wrapError(fmt.Errorf("requeuing task %s: %w", id, err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("dead letter %w: %s", queue.ErrTaskNotFound, id)
+	}
+	return nil
+}
+
+func (pq *pgQueue) Stop(ctx context.Context) error {
+	pq.ctxCancel()
+	pq.lock.Lock()
+	for id, l := range pq.leases {
+		l.cancel()
+		delete(pq.leases, id)
+	}
+	pq.lock.Unlock()
+	return pq.db.Close()
+}
+
+// newToken returns a random token for a lease, so that the
+// leases of processes pulling from the same table never
+// collide, or an error if it cannot be generated.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generating lease: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
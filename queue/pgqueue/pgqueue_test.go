@@ -0,0 +1,83 @@
+package pgqueue_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/queue/pgqueue"
+	"github.com/pbanos/botanic/queue/queuetest"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+// testDB returns the URL of the PostgreSQL database at the
+// BOTANIC_TEST_POSTGRESQL_URL environment variable and a function
+// that returns table prefixes whose tasks tables are dropped when
+// the test ends, skipping the test if the variable is not set.
+func testDB(t *testing.T) (string, func() string) {
+	url := os.Getenv("BOTANIC_TEST_POSTGRESQL_URL")
+	if url == "" {
+		t.Skip("BOTANIC_TEST_POSTGRESQL_URL not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	t.Cleanup(func() {
+		for _, table := range tables {
+			db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
+		}
+		db.Close()
+	})
+	return url, func() string {
+		prefix := fmt.Sprintf("pgqueue_test_%d_%d", os.Getpid(), len(tables))
+		tables = append(tables, prefix+"_tasks")
+		return prefix
+	}
+}
+
+func TestPostgreSQLQueue(t *testing.T) {
+	url, tablePrefix := testDB(t)
+	queuetest.Run(t, func(maxAttempts int) (queue.Queue, error) {
+		return pgqueue.New(context.Background(), url, nil, &pgqueue.Options{TablePrefix: tablePrefix(), MaxAttempts: maxAttempts})
+	})
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	url, tablePrefix := testDB(t)
+	ctx := context.Background()
+	q, err := pgqueue.New(ctx, url, nil, &pgqueue.Options{TablePrefix: tablePrefix(), VisibilityTimeout: 100 * time.Millisecond, MaxAttempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop(ctx)
+	if err := q.Push(ctx, queue.NewTask(&tree.Node{ID: "a"}, set.New(nil), nil)); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		task, tctx, err := q.Pull(ctx)
+		if err != nil || task == nil || task.Attempts != attempt {
+			t.Fatalf("expected to pull task a on attempt %d, got %v, %v", attempt, task, err)
+		}
+		select {
+		case <-tctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the context of the task to be done once its visibility timeout expired")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	pending, running, err := q.Count(ctx)
+	if err != nil || pending != 0 || running != 0 {
+		t.Fatalf("expected no tasks pending or running once the attempts ran out, got %d, %d, %v", pending, running, err)
+	}
+	deadLetters, err := q.DeadLetters(ctx)
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].ID() != "a" {
+		t.Fatalf("expected task a as the only dead letter, got %v, %v", deadLetters, err)
+	}
+}
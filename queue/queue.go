@@ -1,7 +1,9 @@
 package queue
 
 import (
+	"container/heap"
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -24,6 +26,8 @@ type Queue interface {
 	// a timeout or allow its cancellation, or an error.
 	// The pulled task will be counted as running from
 	// then on.
+	// Implementations should return the pending task
+	// with the highest Priority first.
 	// If there are no tasks to pull, implementations
 	// should not return an error, but 3 nil values.
 	// In case of cancellation, workers should still
@@ -49,19 +53,112 @@ type Queue interface {
 	Stop(context.Context) error
 }
 
+// ErrUnavailable is the error wrapped by the errors of
+// queues that cannot be reached, such as those served by
+// another process, so that callers can tell them with
+// errors.Is from the rest and retry later.
+var ErrUnavailable = errors.New("queue unavailable")
+
+// ErrTaskNotFound is the error wrapped by the errors
+// returned when an ID does not match any task of a queue,
+// such as that of a dead letter to requeue.
+var ErrTaskNotFound = errors.New("task not found")
+
+// DeadLetterQueue is an optional interface for queues
+// that stop making tasks available for pulling once they
+// have been dropped after a maximum number of attempts,
+// keeping them apart as dead letters instead. Dead
+// letters count neither as pending nor as running.
+type DeadLetterQueue interface {
+	Queue
+	// DeadLetters returns the tasks kept as dead
+	// letters or an error.
+	DeadLetters(context.Context) ([]*Task, error)
+	// Requeue takes the ID of a task kept as a dead
+	// letter and makes it available for pulling again,
+	// with its attempts reset, or returns an error.
+	Requeue(context.Context, string) error
+}
+
+// WaitingQueue is an optional interface for queues that
+// let workers block until there is a task to pull instead
+// of polling them.
+type WaitingQueue interface {
+	Queue
+	// PullWait works as Pull, but if there are no
+	// tasks to pull while some are running, it waits
+	// until a task can be pulled or none is running.
+	// It returns 3 nil values if there are no tasks
+	// pending or running, and an error if the given
+	// context is done or the queue is stopped while
+	// waiting.
+	PullWait(context.Context) (*Task, context.Context, error)
+}
+
+// BatchQueue is an optional interface for queues that can
+// push several tasks at once.
+type BatchQueue interface {
+	Queue
+	// PushAll takes a slice of tasks and stores them
+	// all in the queue as pending, or none of them if
+	// it returns an error.
+	PushAll(context.Context, []*Task) error
+}
+
+// memQueue must implement DeadLetterQueue, WaitingQueue
+// and BatchQueue, checked at compile time
+var _ DeadLetterQueue = (*memQueue)(nil)
+var _ WaitingQueue = (*memQueue)(nil)
+var _ BatchQueue = (*memQueue)(nil)
+
+// PushAll takes a context, a queue and a slice of tasks and
+// pushes the tasks into the queue, all at once if the queue
+// is a BatchQueue or one by one otherwise, in which case the
+// tasks pushed before one that fails remain in the queue.
+// It returns an error if any of the tasks cannot be pushed.
+func PushAll(ctx context.Context, q Queue, tasks []*Task) error {
+	if bq, ok := q.(BatchQueue); ok {
+		return bq.PushAll(ctx, tasks)
+	}
+	for _, t := range tasks {
+		err := q.Push(ctx, t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type memQueue struct {
-	pendingTasks []*Task
+	pendingTasks taskHeap
+	pushes       uint64
 	runningTasks map[string]*Task
+	deadLetters  []*Task
+	maxAttempts  int
+	changed      chan struct{}
 	lock         *sync.RWMutex
 	ctx          context.Context
 	ctxCancel    context.CancelFunc
 }
 
 // New returns a queue backed only by the process memory
+// that returns pending tasks by descending priority, and
+// those with the same priority last in, first out.
 func New() Queue {
+	return NewWithMaxAttempts(0)
+}
+
+// NewWithMaxAttempts takes a maximum number of attempts
+// and returns a queue like the one returned by New, but
+// that keeps tasks dropped after being pulled that many
+// times as dead letters instead of making them pending
+// again. A maximum of 0 or less allows unlimited attempts.
+func NewWithMaxAttempts(maxAttempts int) DeadLetterQueue {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &memQueue{
 		runningTasks: make(map[string]*Task),
+		maxAttempts:  maxAttempts,
+		changed:      make(chan struct{}),
 		lock:         &sync.RWMutex{},
 		ctx:          ctx,
 		ctxCancel:    cancel,
@@ -103,16 +200,19 @@ func (mq *memQueue) Push(ctx context.Context, t *Task) error {
 	})
 }
 
+func (mq *memQueue) PushAll(ctx context.Context, tasks []*Task) error {
+	return mq.withLock(ctx, func(ctx context.Context) error {
+		for _, t := range tasks {
+			mq.push(t)
+		}
+		return nil
+	})
+}
+
 func (mq *memQueue) Pull(ctx context.Context) (*Task, context.Context, error) {
 	var task *Task
 	err := mq.withLock(ctx, func(ctx context.Context) error {
-		if len(mq.pendingTasks) == 0 {
-			return nil
-		}
-		task = mq.pendingTasks[len(mq.pendingTasks)-1]
-		mq.pendingTasks[len(mq.pendingTasks)-1] = nil
-		mq.pendingTasks = mq.pendingTasks[:len(mq.pendingTasks)-1]
-		mq.runningTasks[task.ID()] = task
+		task = mq.pull()
 		return nil
 	})
 	if err != nil {
@@ -124,6 +224,36 @@ func (mq *memQueue) Pull(ctx context.Context) (*Task, context.Context, error) {
 	return task, mq.ctx, nil
 }
 
+func (mq *memQueue) PullWait(ctx context.Context) (*Task, context.Context, error) {
+	for {
+		var task *Task
+		var changed chan struct{}
+		err := mq.withLock(ctx, func(ctx context.Context) error {
+			task = mq.pull()
+			if task == nil && len(mq.runningTasks) > 0 {
+				changed = mq.changed
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if task != nil {
+			return task, mq.ctx, nil
+		}
+		if changed == nil {
+			return nil, nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-mq.ctx.Done():
+			return nil, nil, mq.ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
 func (mq *memQueue) Drop(ctx context.Context, id string) error {
 	return mq.withLock(ctx, func(ctx context.Context) error {
 		t, ok := mq.runningTasks[id]
@@ -131,14 +261,45 @@ func (mq *memQueue) Drop(ctx context.Context, id string) error {
 			return nil
 		}
 		delete(mq.runningTasks, id)
+		if mq.maxAttempts > 0 && t.Attempts >= mq.maxAttempts {
+			mq.deadLetters = append(mq.deadLetters, t)
+			mq.notify()
+			return nil
+		}
 		mq.push(t)
 		return nil
 	})
 }
 
+func (mq *memQueue) DeadLetters(ctx context.Context) ([]*Task, error) {
+	var tasks []*Task
+	err := mq.withRLock(ctx, func(ctx context.Context) error {
+		tasks = append(tasks, mq.deadLetters...)
+		return nil
+	})
+	return tasks, err
+}
+
+func (mq *memQueue) Requeue(ctx context.Context, id string) error {
+	return mq.withLock(ctx, func(ctx context.Context) error {
+		for i, t := range mq.deadLetters {
+			if t.ID() == id {
+				mq.deadLetters = append(mq.deadLetters[:i], mq.deadLetters[i+1:]...)
+				t.Attempts = 0
+				mq.push(t)
+				return nil
+			}
+		}
+		return fmt.Errorf("dead letter %w: %s", ErrTaskNotFound, id)
+	})
+}
+
 func (mq *memQueue) Complete(ctx context.Context, id string) error {
 	return mq.withLock(ctx, func(ctx context.Context) error {
-		delete(mq.runningTasks, id)
+		if _, ok := mq.runningTasks[id]; ok {
+			delete(mq.runningTasks, id)
+			mq.notify()
+		}
 		return nil
 	})
 }
@@ -166,7 +327,75 @@ func (mq *memQueue) String() string {
 }
 
 func (mq *memQueue) push(t *Task) {
-	mq.pendingTasks = append(mq.pendingTasks, t)
+	mq.pushes++
+	heap.Push(&mq.pendingTasks, &pendingTask{t, mq.pushes})
+	mq.notify()
+}
+
+// pull takes the pending task with the highest priority,
+// if any, and returns it as running, or nil if there are
+// no pending tasks.
+func (mq *memQueue) pull() *Task {
+	if len(mq.pendingTasks) == 0 {
+		return nil
+	}
+	task := heap.Pop(&mq.pendingTasks).(*pendingTask).Task
+	task.Attempts++
+	mq.runningTasks[task.ID()] = task
+	return task
+}
+
+// notify wakes up the workers waiting on PullWait so that
+// they check the queue again. It must be called with the
+// lock held whenever tasks are pushed or stop running.
+func (mq *memQueue) notify() {
+	close(mq.changed)
+	mq.changed = make(chan struct{})
+}
+
+// pendingTask is a task pending on a memQueue with
+// the number of the push that made it pending.
+type pendingTask struct {
+	*Task
+	push uint64
+}
+
+func (pt *pendingTask) String() string {
+	return pt.Task.String()
+}
+
+// taskHeap is a heap of pending tasks whose first
+// task is the one with the highest priority, or the
+// one pushed last among those with the same priority,
+// so that tasks with the same priority are pulled
+// depth first.
+type taskHeap []*pendingTask
+
+func (th taskHeap) Len() int {
+	return len(th)
+}
+
+func (th taskHeap) Less(i, j int) bool {
+	if th[i].Priority != th[j].Priority {
+		return th[i].Priority > th[j].Priority
+	}
+	return th[i].push > th[j].push
+}
+
+func (th taskHeap) Swap(i, j int) {
+	th[i], th[j] = th[j], th[i]
+}
+
+func (th *taskHeap) Push(x interface{}) {
+	*th = append(*th, x.(*pendingTask))
+}
+
+func (th *taskHeap) Pop() interface{} {
+	old := *th
+	pt := old[len(old)-1]
+	old[len(old)-1] = nil
+	*th = old[:len(old)-1]
+	return pt
 }
 
 func (mq *memQueue) withLock(ctx context.Context, f func(ctx context.Context) error) error {
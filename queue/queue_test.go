@@ -0,0 +1,14 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/queue/queuetest"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	queuetest.Run(t, func(maxAttempts int) (queue.Queue, error) {
+		return queue.NewWithMaxAttempts(maxAttempts), nil
+	})
+}
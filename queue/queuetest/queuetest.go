@@ -0,0 +1,288 @@
+// Package queuetest provides a suite of tests of the behaviour
+// expected from every queue.Queue, so that implementations of the
+// interface other than the one of the queue package can check
+// they can be used to grow trees the same way.
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+// NewQueueFunc takes a maximum number of attempts and returns a
+// new empty queue to test or an error. If the queue is a
+// queue.DeadLetterQueue, it must keep the tasks dropped after
+// being pulled that many times as dead letters, with a maximum
+// of 0 allowing unlimited attempts.
+type NewQueueFunc func(maxAttempts int) (queue.Queue, error)
+
+// waitTime is the time the tests wait for a call that must
+// block to return before taking it as blocked.
+const waitTime = 100 * time.Millisecond
+
+// Run takes a testing.T and a NewQueueFunc and runs every test
+// of the suite as a subtest on a new queue returned by the
+// function, stopping it afterwards. The tests on the optional
+// interfaces of the queue package are skipped for queues that
+// do not implement them.
+func Run(t *testing.T, newQueue NewQueueFunc) {
+	for _, test := range []struct {
+		name        string
+		maxAttempts int
+		f           func(*testing.T, queue.Queue)
+	}{
+		{"PullOrder", 0, testPullOrder},
+		{"Count", 0, testCount},
+		{"Drop", 0, testDrop},
+		{"PushAll", 0, testPushAll},
+		{"PullWaitWakeUp", 0, testPullWaitWakeUp},
+		{"PullWaitTermination", 0, testPullWaitTermination},
+		{"PullWaitCancellation", 0, testPullWaitCancellation},
+		{"DeadLetters", 2, testDeadLetters},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q, err := newQueue(test.maxAttempts)
+			if err != nil {
+				t.Fatalf("creating queue: %v", err)
+			}
+			defer q.Stop(context.Background())
+			test.f(t, q)
+		})
+	}
+}
+
+// newTask takes an ID and a priority and returns a task with
+// them on a node with the ID and an empty set.
+func newTask(id string, priority int) *queue.Task {
+	task := queue.NewTask(&tree.Node{ID: id}, set.New(nil), nil)
+	task.Priority = priority
+	return task
+}
+
+func push(t *testing.T, q queue.Queue, tasks ...*queue.Task) {
+	t.Helper()
+	for _, task := range tasks {
+		if err := q.Push(context.Background(), task); err != nil {
+			t.Fatalf("pushing task %s: %v", task.ID(), err)
+		}
+	}
+}
+
+// pull pulls a task from the queue and fails the test unless it
+// has the given ID, or is nil for an empty ID.
+func pull(t *testing.T, q queue.Queue, id string) *queue.Task {
+	t.Helper()
+	task, _, err := q.Pull(context.Background())
+	if err != nil {
+		t.Fatalf("pulling task: %v", err)
+	}
+	if got := taskID(task); got != id {
+		t.Fatalf("expected to pull task %q, got %q", id, got)
+	}
+	return task
+}
+
+func expectCount(t *testing.T, q queue.Queue, pending, running int) {
+	t.Helper()
+	p, r, err := q.Count(context.Background())
+	if err != nil {
+		t.Fatalf("counting tasks: %v", err)
+	}
+	if p != pending || r != running {
+		t.Fatalf("expected %d pending and %d running tasks, got %d and %d", pending, running, p, r)
+	}
+}
+
+func taskID(task *queue.Task) string {
+	if task == nil {
+		return ""
+	}
+	return task.ID()
+}
+
+func testPullOrder(t *testing.T, q queue.Queue) {
+	pull(t, q, "")
+	push(t, q, newTask("a", 1), newTask("b", 3), newTask("c", 3), newTask("d", 2))
+	for _, id := range []string{"c", "b", "d", "a", ""} {
+		pull(t, q, id)
+	}
+}
+
+func testCount(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	expectCount(t, q, 0, 0)
+	push(t, q, newTask("a", 0), newTask("b", 0), newTask("c", 0))
+	expectCount(t, q, 3, 0)
+	task := pull(t, q, "c")
+	expectCount(t, q, 2, 1)
+	if err := q.Complete(ctx, task.ID()); err != nil {
+		t.Fatalf("completing task: %v", err)
+	}
+	expectCount(t, q, 2, 0)
+	if err := q.Complete(ctx, task.ID()); err != nil {
+		t.Fatalf("completing task again: %v", err)
+	}
+	if err := q.Drop(ctx, task.ID()); err != nil {
+		t.Fatalf("dropping completed task: %v", err)
+	}
+	expectCount(t, q, 2, 0)
+}
+
+func testDrop(t *testing.T, q queue.Queue) {
+	push(t, q, newTask("a", 0))
+	task := pull(t, q, "a")
+	if task.Attempts != 1 {
+		t.Fatalf("expected a task pulled once to have 1 attempt, got %d", task.Attempts)
+	}
+	if err := q.Drop(context.Background(), task.ID()); err != nil {
+		t.Fatalf("dropping task: %v", err)
+	}
+	expectCount(t, q, 1, 0)
+	task = pull(t, q, "a")
+	if task.Attempts != 2 {
+		t.Fatalf("expected a task pulled twice to have 2 attempts, got %d", task.Attempts)
+	}
+}
+
+func testPushAll(t *testing.T, q queue.Queue) {
+	bq, ok := q.(queue.BatchQueue)
+	if !ok {
+		t.Skip("not a queue.BatchQueue")
+	}
+	err := bq.PushAll(context.Background(), []*queue.Task{newTask("a", 1), newTask("b", 2)})
+	if err != nil {
+		t.Fatalf("pushing tasks: %v", err)
+	}
+	expectCount(t, q, 2, 0)
+	pull(t, q, "b")
+	pull(t, q, "a")
+}
+
+// pullWait calls PullWait on the queue with the given context
+// on a goroutine and returns a channel on which the ID of the
+// task pulled, or the error, is sent once it returns.
+func pullWait(ctx context.Context, wq queue.WaitingQueue) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		task, _, err := wq.PullWait(ctx)
+		if err != nil {
+			result <- fmt.Sprintf("error: %v", err)
+			return
+		}
+		result <- taskID(task)
+	}()
+	return result
+}
+
+// expectBlocked fails the test if PullWait returned within the
+// waitTime.
+func expectBlocked(t *testing.T, result <-chan string) {
+	t.Helper()
+	select {
+	case r := <-result:
+		t.Fatalf("expected PullWait to wait while a task is running, got %q", r)
+	case <-time.After(waitTime):
+	}
+}
+
+// expectResult fails the test unless PullWait returns the given
+// result within a few seconds.
+func expectResult(t *testing.T, result <-chan string, expected string) {
+	t.Helper()
+	select {
+	case r := <-result:
+		if r != expected {
+			t.Fatalf("expected PullWait to return %q, got %q", expected, r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PullWait did not return")
+	}
+}
+
+func testPullWaitWakeUp(t *testing.T, q queue.Queue) {
+	wq, ok := q.(queue.WaitingQueue)
+	if !ok {
+		t.Skip("not a queue.WaitingQueue")
+	}
+	push(t, q, newTask("a", 0))
+	pull(t, q, "a")
+	result := pullWait(context.Background(), wq)
+	expectBlocked(t, result)
+	push(t, q, newTask("b", 0))
+	expectResult(t, result, "b")
+}
+
+func testPullWaitTermination(t *testing.T, q queue.Queue) {
+	wq, ok := q.(queue.WaitingQueue)
+	if !ok {
+		t.Skip("not a queue.WaitingQueue")
+	}
+	expectResult(t, pullWait(context.Background(), wq), "")
+	push(t, q, newTask("a", 0))
+	pull(t, q, "a")
+	result := pullWait(context.Background(), wq)
+	expectBlocked(t, result)
+	if err := q.Complete(context.Background(), "a"); err != nil {
+		t.Fatalf("completing task: %v", err)
+	}
+	expectResult(t, result, "")
+}
+
+func testPullWaitCancellation(t *testing.T, q queue.Queue) {
+	wq, ok := q.(queue.WaitingQueue)
+	if !ok {
+		t.Skip("not a queue.WaitingQueue")
+	}
+	push(t, q, newTask("a", 0))
+	pull(t, q, "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	result := pullWait(ctx, wq)
+	expectBlocked(t, result)
+	cancel()
+	expectResult(t, result, fmt.Sprintf("error: %v", context.Canceled))
+}
+
+func testDeadLetters(t *testing.T, q queue.Queue) {
+	dlq, ok := q.(queue.DeadLetterQueue)
+	if !ok {
+		t.Skip("not a queue.DeadLetterQueue")
+	}
+	ctx := context.Background()
+	push(t, q, newTask("a", 0))
+	for i := 0; i < 2; i++ {
+		pull(t, q, "a")
+		if err := q.Drop(ctx, "a"); err != nil {
+			t.Fatalf("dropping task: %v", err)
+		}
+	}
+	expectCount(t, q, 0, 0)
+	deadLetters, err := dlq.DeadLetters(ctx)
+	if err != nil {
+		t.Fatalf("listing dead letters: %v", err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].ID() != "a" {
+		t.Fatalf("expected task a as the only dead letter, got %v", deadLetters)
+	}
+	if err := dlq.Requeue(ctx, "b"); !errors.Is(err, queue.ErrTaskNotFound) {
+		t.Fatalf("expected requeuing an unknown dead letter to fail with queue.ErrTaskNotFound, got %v", err)
+	}
+	if err := dlq.Requeue(ctx, "a"); err != nil {
+		t.Fatalf("requeuing dead letter: %v", err)
+	}
+	expectCount(t, q, 1, 0)
+	if deadLetters, err := dlq.DeadLetters(ctx); err != nil || len(deadLetters) != 0 {
+		t.Fatalf("expected no dead letters once requeued, got %v, %v", deadLetters, err)
+	}
+	task := pull(t, q, "a")
+	if task.Attempts != 1 {
+		t.Fatalf("expected a requeued task to have its attempts reset, got %d", task.Attempts)
+	}
+}
@@ -1,7 +1,9 @@
 package queue
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/pbanos/botanic/feature"
 	"github.com/pbanos/botanic/set"
@@ -22,6 +24,49 @@ type Task struct {
 	// It should exclude the features used in
 	// ancestor nodes.
 	AvailableFeatures []feature.Feature
+	// Information about the task that queues
+	// and workers can use without querying
+	// the set or the node store.
+	Metadata
+}
+
+// Metadata holds information about a task:
+// its depth, the ID of the task that produced
+// it, the number of samples in its set, the
+// time it was created, its priority and the
+// number of times it has been pulled.
+type Metadata struct {
+	// The depth of the node in the tree,
+	// 0 for the root node.
+	Depth int `json:"depth"`
+	// The ID of the task for the parent
+	// node, empty for the root node.
+	ParentID string `json:"parentId,omitempty"`
+	// The number of samples in the set of
+	// the task, or 0 if it is not known.
+	SubsetCount int `json:"subsetCount,omitempty"`
+	// The time the task was created.
+	CreatedAt time.Time `json:"createdAt"`
+	// The priority of the task. Queues
+	// should let tasks with a higher
+	// priority be pulled first.
+	Priority int `json:"priority,omitempty"`
+	// The number of times the task has
+	// been pulled from a queue.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// NewTask takes a node, a set and the features
+// available to split the node and returns a task
+// to develop the node created now, with the
+// metadata for a root node.
+func NewTask(n *tree.Node, s set.Set, availableFeatures []feature.Feature) *Task {
+	return &Task{
+		Node:              n,
+		Set:               s,
+		AvailableFeatures: availableFeatures,
+		Metadata:          Metadata{CreatedAt: time.Now()},
+	}
 }
 
 // ID returns a string that identifies the
@@ -33,3 +78,19 @@ func (t *Task) ID() string {
 func (t *Task) String() string {
 	return fmt.Sprintf("{Task %s}", t.Node.ID)
 }
+
+// MarshalJSON encodes the task as a JSON object
+// with its ID, the names of its available features
+// and its metadata. The node and set are not
+// encoded.
+func (t *Task) MarshalJSON() ([]byte, error) {
+	featureNames := make([]string, 0, len(t.AvailableFeatures))
+	for _, f := range t.AvailableFeatures {
+		featureNames = append(featureNames, f.Name())
+	}
+	return json.Marshal(&struct {
+		ID                string   `json:"id"`
+		AvailableFeatures []string `json:"availableFeatures"`
+		Metadata
+	}{t.ID(), featureNames, t.Metadata})
+}
@@ -0,0 +1,157 @@
+package botanic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pbanos/botanic/logging"
+	"github.com/pbanos/botanic/queue"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+RetryPolicy determines how Work retries the operations that fail with
+transient errors, such as those of a database or a coordinator that cannot
+be reached for a moment, instead of giving up and ending the growth of the
+tree. The operations on the queue are retried on their own, whereas tasks
+whose development fails are dropped back into the queue and the worker
+waits before pulling again.
+
+MaxAttempts is the number of times an operation is attempted, counting the
+first one, or the number of consecutive tasks that fail before the worker
+gives up. A MaxAttempts below 1 attempts operations once.
+
+InitialBackoff is the time waited before the first retry, which is
+multiplied by Multiplier, or 2 if it is below 1, for every retry after
+it, up to MaxBackoff if it is positive.
+
+Retryable decides whether an error is transient, and defaults to
+IsTransient if it is nil.
+
+OnRetry, if not nil, is called with the name of the operation, the number
+of the attempt that failed, its error and the time waited before retrying
+it, for every retry.
+*/
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Retryable      func(error) bool
+	OnRetry        func(op string, attempt int, err error, backoff time.Duration)
+}
+
+/*
+IsTransient takes an error and returns whether it is transient: whether it
+has a Temporary method that returns true or it is set.ErrBackendUnavailable,
+queue.ErrUnavailable or tree.ErrNodeStoreUnavailable for errors.Is.
+*/
+func IsTransient(err error) bool {
+	var te interface{ Temporary() bool }
+	if errors.As(err, &te) && te.Temporary() {
+		return true
+	}
+	return errors.Is(err, set.ErrBackendUnavailable) || errors.Is(err, queue.ErrUnavailable) || errors.Is(err, tree.ErrNodeStoreUnavailable)
+}
+
+/*
+defaultRetryPolicy takes the time a worker sleeps on an empty queue and
+returns the RetryPolicy of workers given none, which waits that time before
+every retry and gives up after maxTemporaryErrors retries.
+*/
+func defaultRetryPolicy(emptyQueueSleep time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    maxTemporaryErrors + 1,
+		InitialBackoff: emptyQueueSleep,
+		Multiplier:     1,
+	}
+}
+
+/*
+retryable takes the error of the attempt-th attempt of an operation and
+returns whether the operation must be attempted again.
+*/
+func (rp *RetryPolicy) retryable(err error, attempt int) bool {
+	if attempt >= rp.MaxAttempts {
+		return false
+	}
+	if rp.Retryable == nil {
+		return IsTransient(err)
+	}
+	return rp.Retryable(err)
+}
+
+/*
+backoff takes the number of the attempt of an operation that failed and
+returns the time to wait before attempting it again.
+*/
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	backoff := float64(rp.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+	}
+	if rp.MaxBackoff > 0 && backoff > float64(rp.MaxBackoff) {
+		return rp.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+/*
+wait takes a context, a growth configuration, the name of an operation, the
+number of its attempt that failed, its error and any number of key-value
+pairs describing the operation and, if the operation must be attempted
+again, logs the error as a warning with the configuration's Logger, along
+with the key-value pairs, notifies the retry to OnRetry and to the
+configuration's Observer if it is a RetryObserver and waits before
+returning true. It returns false if the operation must not be attempted
+again, and the error of the context if it is done while waiting.
+*/
+func (rp *RetryPolicy) wait(ctx context.Context, gc *GrowConfig, op string, attempt int, err error, keyvals ...interface{}) (bool, error) {
+	if !rp.retryable(err, attempt) {
+		return false, nil
+	}
+	backoff := rp.backoff(attempt)
+	keyvals = append([]interface{}{"operation", op, "attempt", attempt, "error", err, "backoff", backoff}, keyvals...)
+	gc.logger().Log(logging.LevelWarn, "Retrying operation after a transient error", keyvals...)
+	if rp.OnRetry != nil {
+		rp.OnRetry(op, attempt, err, backoff)
+	}
+	if ro, ok := gc.observer().(RetryObserver); ok {
+		ro.OnRetry(ctx, op, attempt, err)
+	}
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(backoff):
+	}
+	return true, nil
+}
+
+/*
+do takes a context, a growth configuration, the name of an operation and a
+function performing it and calls the function until it succeeds or
+returns an error that must not be retried, waiting between attempts. It
+returns the last error of the function, or that of the context if it is
+done while waiting.
+*/
+func (rp *RetryPolicy) do(ctx context.Context, gc *GrowConfig, op string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		retry, werr := rp.wait(ctx, gc, op, attempt, err)
+		if werr != nil {
+			return werr
+		}
+		if !retry {
+			return err
+		}
+	}
+}
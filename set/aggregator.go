@@ -0,0 +1,41 @@
+package set
+
+import (
+	"context"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+Aggregator is an optional interface for sets that can compute aggregates of
+their samples in a single pass over them, such as sets backed by a database
+that can compute them with a single query. Partitioning uses it when
+available to evaluate every candidate split of a continuous feature at once
+instead of subsetting the set for each of them, and every split in two of
+the values of a discrete feature.
+
+Its LabelHistogramByFeatureValue method takes a continuous feature and a
+label feature and returns a LabelHistogram for every value the samples of
+the set take for the continuous feature. Samples with an undefined value for
+the continuous feature are left out.
+
+Its LabelHistogramByDiscreteValue method does the same for a discrete
+feature, keying the histograms by the values of the feature as satisfying
+the criteria returned by feature.NewDiscreteCriterion, that is hashed into
+their buckets for features that hash their values.
+*/
+type Aggregator interface {
+	LabelHistogramByFeatureValue(ctx context.Context, f *feature.ContinuousFeature, labelFeature feature.Feature) (map[float64]*LabelHistogram, error)
+	LabelHistogramByDiscreteValue(ctx context.Context, f *feature.DiscreteFeature, labelFeature feature.Feature) (map[string]*LabelHistogram, error)
+}
+
+/*
+LabelHistogram holds the weight of some samples, in total and for every value
+of a label feature, keyed as in the result of the FeatureValueWeights method
+of sets. Samples with an undefined value for the label feature add to the
+total weight but to no value.
+*/
+type LabelHistogram struct {
+	Weight       float64
+	LabelWeights map[string]float64
+}
@@ -0,0 +1,343 @@
+/*
+Package cached provides a set.Set decorator that memoizes the results of the
+aggregates computed on a set and its subsets in a Cache, so that processes
+growing trees from the same set, such as workers on the same SQL set, do not
+compute the same aggregates for the same subsets more than once.
+*/
+package cached
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+Cache is a store of values by key shared by cached sets.
+
+Its Get method takes a context and a key and returns the value stored for
+the key, whether a value was found and an error if the cache cannot be
+queried.
+
+Its Set method takes a context, a key and a value and stores the value for
+the key, returning an error if it cannot be stored.
+*/
+type Cache interface {
+	Get(context.Context, string) ([]byte, bool, error)
+	Set(context.Context, string, []byte) error
+}
+
+type cachedSet struct {
+	set.Set
+	cache     Cache
+	namespace string
+	criteria  []feature.Criterion
+	key       string
+}
+
+type aggregatorCachedSet struct {
+	*cachedSet
+	agg set.Aggregator
+}
+
+type labelHistogramEntry struct {
+	Value        float64            `json:"v"`
+	Weight       float64            `json:"w"`
+	LabelWeights map[string]float64 `json:"l,omitempty"`
+}
+
+type memoryCache struct {
+	lock   sync.RWMutex
+	values map[string][]byte
+}
+
+/*
+New takes a set, a cache and a namespace and returns a set.Set that
+decorates the given set, memoizing in the cache the results of its
+Entropy, FeatureValues, CountFeatureValues, FeatureValueWeights, Count and
+Weight methods, and those of the subsets obtained from it. If the given set
+is a set.Aggregator, so is the returned one, and the results of its
+LabelHistogramByFeatureValue and LabelHistogramByDiscreteValue methods are
+memoized too.
+
+Results are stored in the cache under keys that start with the namespace,
+which should identify the data of the set, followed by the criteria that
+define the subset and the aggregate computed, so that sets decorated with
+the same namespace on different processes share the results for the same
+subsets. The criteria are normalized, so that subsets obtained applying the
+same criteria in a different order, such as the subsets of different
+branches of a tree that end up with the same samples, share their results
+too. Only the aggregates of sets with the same data should share a
+namespace.
+
+Errors querying the cache or storing results on it are returned by the
+methods of the set.
+*/
+func New(s set.Set, cache Cache, namespace string) set.Set {
+	return wrap(&cachedSet{s, cache, namespace, nil, namespace})
+}
+
+/*
+NewMemoryCache returns a Cache that keeps the values in memory, to share
+results between the sets of a single process.
+*/
+func NewMemoryCache() Cache {
+	return &memoryCache{values: make(map[string][]byte)}
+}
+
+func (cs *cachedSet) SubsetWith(ctx context.Context, c feature.Criterion) (set.Set, error) {
+	s, err := cs.Set.SubsetWith(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	criteria := append(cs.criteria[:len(cs.criteria):len(cs.criteria)], c)
+	return wrap(&cachedSet{s, cs.cache, cs.namespace, criteria, subsetKey(cs.namespace, criteria)}), nil
+}
+
+func (cs *cachedSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
+	var result float64
+	err := cs.memoize(ctx, "entropy:"+f.Name(), &result, func() (err error) {
+		result, err = cs.Set.Entropy(ctx, f)
+		return
+	})
+	return result, err
+}
+
+func (cs *cachedSet) FeatureValues(ctx context.Context, f feature.Feature) ([]interface{}, error) {
+	var result []interface{}
+	err := cs.memoize(ctx, "values:"+f.Name(), &result, func() (err error) {
+		result, err = cs.Set.FeatureValues(ctx, f)
+		return
+	})
+	return result, err
+}
+
+func (cs *cachedSet) CountFeatureValues(ctx context.Context, f feature.Feature) (map[string]int, error) {
+	var result map[string]int
+	err := cs.memoize(ctx, "counts:"+f.Name(), &result, func() (err error) {
+		result, err = cs.Set.CountFeatureValues(ctx, f)
+		return
+	})
+	return result, err
+}
+
+func (cs *cachedSet) FeatureValueWeights(ctx context.Context, f feature.Feature) (map[string]float64, error) {
+	var result map[string]float64
+	err := cs.memoize(ctx, "weights:"+f.Name(), &result, func() (err error) {
+		result, err = cs.Set.FeatureValueWeights(ctx, f)
+		return
+	})
+	return result, err
+}
+
+func (cs *cachedSet) Count(ctx context.Context) (int, error) {
+	var result int
+	err := cs.memoize(ctx, "count", &result, func() (err error) {
+		result, err = cs.Set.Count(ctx)
+		return
+	})
+	return result, err
+}
+
+func (cs *cachedSet) Weight(ctx context.Context) (float64, error) {
+	var result float64
+	err := cs.memoize(ctx, "weight", &result, func() (err error) {
+		result, err = cs.Set.Weight(ctx)
+		return
+	})
+	return result, err
+}
+
+func (acs *aggregatorCachedSet) LabelHistogramByFeatureValue(ctx context.Context, f *feature.ContinuousFeature, labelFeature feature.Feature) (map[float64]*set.LabelHistogram, error) {
+	var entries []labelHistogramEntry
+	err := acs.memoize(ctx, "histogram:"+f.Name()+":"+labelFeature.Name(), &entries, func() error {
+		histogram, err := acs.agg.LabelHistogramByFeatureValue(ctx, f, labelFeature)
+		if err != nil {
+			return err
+		}
+		entries = make([]labelHistogramEntry, 0, len(histogram))
+		for v, lh := range histogram {
+			entries = append(entries, labelHistogramEntry{v, lh.Weight, lh.LabelWeights})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]*set.LabelHistogram, len(entries))
+	for _, e := range entries {
+		result[e.Value] = &set.LabelHistogram{Weight: e.Weight, LabelWeights: e.LabelWeights}
+	}
+	return result, nil
+}
+
+func (acs *aggregatorCachedSet) LabelHistogramByDiscreteValue(ctx context.Context, f *feature.DiscreteFeature, labelFeature feature.Feature) (map[string]*set.LabelHistogram, error) {
+	var result map[string]*set.LabelHistogram
+	err := acs.memoize(ctx, "discrete-histogram:"+f.Name()+":"+labelFeature.Name(), &result, func() (err error) {
+		result, err = acs.agg.LabelHistogramByDiscreteValue(ctx, f, labelFeature)
+		return
+	})
+	return result, err
+}
+
+/*
+wrap takes a cachedSet and returns it as a set.Set that is also a
+set.Aggregator if the set it decorates is one.
+*/
+func wrap(cs *cachedSet) set.Set {
+	if agg, ok := cs.Set.(set.Aggregator); ok {
+		return &aggregatorCachedSet{cs, agg}
+	}
+	return cs
+}
+
+/*
+memoize takes a context, the name of an aggregate, a pointer to a result and
+a function that computes the result. If a result for the aggregate of the
+set is found in the cache, it is decoded into the pointer. Otherwise the
+function is called and the result it computes is stored in the cache. It
+returns an error if the cache cannot be queried or updated, the cached
+result cannot be decoded or the function fails.
+*/
+func (cs *cachedSet) memoize(ctx context.Context, aggregate string, result interface{}, compute func() error) error {
+	key := cs.key + "#" + aggregate
+	value, ok, err := cs.cache.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("querying cache for %s: %v", key, err)
+	}
+	if ok {
+		err = json.Unmarshal(value, result)
+		if err != nil {
+			return fmt.Errorf("decoding cached %s: %v", key, err)
+		}
+		return nil
+	}
+	err = compute()
+	if err != nil {
+		return err
+	}
+	value, err = json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("encoding %s to cache it: %v", key, err)
+	}
+	err = cs.cache.Set(ctx, key, value)
+	if err != nil {
+		return fmt.Errorf("caching %s: %v", key, err)
+	}
+	return nil
+}
+
+/*
+subsetKey takes a namespace and the criteria that define a subset, in the
+order they were applied, and returns the key of the subset in the cache.
+The criteria are normalized so that equivalent ones yield the same key:
+criteria that impose no condition are left out, criteria on the same
+continuous feature are intersected into one and the keys of the rest are
+deduplicated and sorted.
+*/
+func subsetKey(namespace string, criteria []feature.Criterion) string {
+	type interval struct {
+		a, b             float64
+		includeUndefined bool
+	}
+	intervals := make(map[string]*interval)
+	keys := make(map[string]bool)
+	for _, c := range criteria {
+		switch tc := c.(type) {
+		case feature.UndefinedCriterion:
+		case feature.UndefinedValueCriterion:
+			keys[criterionKey(c)] = true
+		case feature.ContinuousCriterion:
+			a, b := tc.Interval()
+			i, ok := intervals[c.Feature().Name()]
+			if !ok {
+				intervals[c.Feature().Name()] = &interval{a, b, feature.IncludesUndefined(c)}
+				continue
+			}
+			i.a = math.Max(i.a, a)
+			i.b = math.Min(i.b, b)
+			i.includeUndefined = i.includeUndefined && feature.IncludesUndefined(c)
+		default:
+			keys[criterionKey(c)] = true
+		}
+	}
+	for name, i := range intervals {
+		keys[intervalKey(name, i.a, i.b, i.includeUndefined)] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return strings.Join(append([]string{namespace}, sorted...), "/")
+}
+
+/*
+criterionKey takes a criterion that is not a continuous one and returns a
+string that identifies it within a cache key. The values of discrete values
+criteria are sorted, so that it does not depend on their order.
+*/
+func criterionKey(c feature.Criterion) string {
+	var b strings.Builder
+	b.WriteString(strconv.Quote(c.Feature().Name()))
+	switch c := c.(type) {
+	case feature.UndefinedValueCriterion:
+		b.WriteString(":undefined")
+	case feature.DiscreteCriterion:
+		b.WriteString(":=")
+		b.WriteString(strconv.Quote(c.Value()))
+	case feature.BooleanCriterion:
+		b.WriteString(":=")
+		b.WriteString(strconv.FormatBool(c.Value()))
+	case feature.DiscreteValuesCriterion:
+		values := append([]string{}, c.Values()...)
+		sort.Strings(values)
+		b.WriteString(":in")
+		for _, v := range values {
+			b.WriteString(",")
+			b.WriteString(strconv.Quote(v))
+		}
+	default:
+		b.WriteString(":*")
+	}
+	if feature.IncludesUndefined(c) {
+		b.WriteString("?")
+	}
+	return b.String()
+}
+
+/*
+intervalKey takes the name of a continuous feature, the limits of an interval
+of its values and whether samples with no value for it are included and
+returns a string that identifies the criterion within a cache key. Unlike the
+String method of criteria, it does not lose precision on the limits.
+*/
+func intervalKey(name string, a, z float64, includeUndefined bool) string {
+	key := fmt.Sprintf("%s:[%s,%s)", strconv.Quote(name), strconv.FormatFloat(a, 'g', -1, 64), strconv.FormatFloat(z, 'g', -1, 64))
+	if includeUndefined {
+		key += "?"
+	}
+	return key
+}
+
+func (mc *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+	value, ok := mc.values[key]
+	return value, ok, nil
+}
+
+func (mc *memoryCache) Set(ctx context.Context, key string, value []byte) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.values[key] = value
+	return nil
+}
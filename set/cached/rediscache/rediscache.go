@@ -0,0 +1,248 @@
+/*
+Package rediscache provides a cached.Cache backed by a Redis server, so that
+the aggregates of sets can be shared between processes on different hosts.
+
+It speaks the Redis serialization protocol (RESP) directly, and only uses
+the AUTH, SELECT, GET and SET commands.
+*/
+package rediscache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbanos/botanic/set/cached"
+)
+
+/*
+DefaultMaxConns is the number of connections to the Redis server a cache
+keeps open at most when no other limit is given.
+*/
+const DefaultMaxConns = 10
+
+type redisCache struct {
+	address  string
+	password string
+	db       int
+	ttl      time.Duration
+	conns    chan *conn
+	slots    chan struct{}
+}
+
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+/*
+New takes the URL of a Redis server, a time to live for the cached values
+and a maximum number of connections and returns a cached.Cache that stores
+values on the server, or an error if the URL is not valid.
+
+The URL has the form redis://[:PASSWORD@]HOST[:PORT][/DB], where the port
+defaults to 6379 and the database number to 0. If the time to live is not
+positive cached values do not expire. If the maximum number of connections
+is not positive, DefaultMaxConns is used. Connections are opened when they
+are first needed and reused afterwards.
+*/
+func New(redisURL string, ttl time.Duration, maxConns int) (cached.Cache, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Redis URL: %v", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %s for Redis URL, expected redis", u.Scheme)
+	}
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConns
+	}
+	rc := &redisCache{
+		address: u.Host,
+		ttl:     ttl,
+		conns:   make(chan *conn, maxConns),
+		slots:   make(chan struct{}, maxConns),
+	}
+	if u.Port() == "" {
+		rc.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		rc.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		rc.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Redis database number %s: %v", db, err)
+		}
+	}
+	return rc, nil
+}
+
+func (rc *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := rc.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected reply %v to GET", reply)
+	}
+	return value, true, nil
+}
+
+func (rc *redisCache) Set(ctx context.Context, key string, value []byte) error {
+	args := []string{"SET", key, string(value)}
+	if rc.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(rc.ttl/time.Millisecond), 10))
+	}
+	_, err := rc.do(ctx, args...)
+	return err
+}
+
+/*
+do takes a context and the arguments of a command, sends the command to the
+server on a connection from the pool and returns its reply or an error. On
+any error other than a Redis error reply the connection is discarded.
+*/
+func (rc *redisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := rc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.c.SetDeadline(deadline)
+	} else {
+		c.c.SetDeadline(time.Time{})
+	}
+	reply, err := c.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.c.Close()
+		<-rc.slots
+		return nil, err
+	}
+	rc.conns <- c
+	return reply, err
+}
+
+/*
+conn takes a context and returns an idle connection from the pool or a new
+one if there are none and fewer than the maximum are open, waiting for a
+connection to be released otherwise. It returns an error if the context is
+done before a connection is available or a new one cannot be opened.
+*/
+func (rc *redisCache) conn(ctx context.Context) (*conn, error) {
+	select {
+	case c := <-rc.conns:
+		return c, nil
+	default:
+	}
+	select {
+	case c := <-rc.conns:
+		return c, nil
+	case rc.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c, err := rc.dial(ctx)
+	if err != nil {
+		<-rc.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+func (rc *redisCache) dial(ctx context.Context) (*conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", rc.address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Redis at %s: %v", rc.address, err)
+	}
+	c := &conn{nc, bufio.NewReader(nc), bufio.NewWriter(nc)}
+	if rc.password != "" {
+		_, err = c.do("AUTH", rc.password)
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("authenticating on Redis at %s: %v", rc.address, err)
+		}
+	}
+	if rc.db != 0 {
+		_, err = c.do("SELECT", strconv.Itoa(rc.db))
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("selecting Redis database %d: %v", rc.db, err)
+		}
+	}
+	return c, nil
+}
+
+/*
+redisError is an error reply from the server, after which the connection
+can still be used.
+*/
+type redisError string
+
+func (re redisError) Error() string {
+	return string(re)
+}
+
+func (c *conn) do(args ...string) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	err := c.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+/*
+readReply reads a reply from the connection and returns a string for simple
+strings, an int64 for integers, a byte slice for bulk strings and nil for
+null bulk strings. Arrays are not expected for the commands sent and are
+returned as an error.
+*/
+func (c *conn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed Redis reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed Redis bulk string length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+	return nil, fmt.Errorf("unexpected Redis reply %q", line)
+}
@@ -0,0 +1,264 @@
+package set
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+columnarSet is a Set that keeps the values of its samples in columns, one for
+every feature, and is defined by the rows of those columns that belong to it.
+Subsets share the columns of the set they come from.
+*/
+type columnarSet struct {
+	entropy *float64
+	store   *columnStore
+	rows    []int
+}
+
+/*
+columnStore holds the samples of a columnar set and its subsets, their
+weights and the columns of their values, which are built the first time a
+feature is needed.
+*/
+type columnStore struct {
+	samples []Sample
+	weights []float64
+	lock    sync.Mutex
+	columns map[string]*column
+}
+
+/*
+column holds the values of the samples of a columnStore for a feature
+dictionary-encoded: the distinct values, including nil for the undefined
+value, their string representations and the code of every sample, that is
+the index of its value among the distinct ones.
+*/
+type column struct {
+	values []interface{}
+	keys   []string
+	codes  []int32
+}
+
+/*
+valueSample is a Sample with a single value for a feature, used to evaluate
+a criterion on a value of a column.
+*/
+type valueSample struct {
+	name  string
+	value interface{}
+}
+
+/*
+NewColumnar takes a slice of samples and returns a Set built with them. A
+columnar set is an implementation that reads the values of its samples for
+a feature once, the first time the feature is used, and keeps them
+dictionary-encoded in a column. Subsets are defined by the rows of the
+columns that belong to them and share the columns with the set. Entropies,
+counts and weights are computed with scans over the codes of the columns, and
+criteria are evaluated once per distinct value of their feature instead of
+once per sample. This avoids most calls to the ValueFor method of the samples
+and the conversion of their values, at the cost of the memory of the
+columns.
+*/
+func NewColumnar(samples []Sample) Set {
+	weights := make([]float64, len(samples))
+	rows := make([]int, len(samples))
+	for i, s := range samples {
+		weights[i] = SampleWeight(s)
+		rows[i] = i
+	}
+	store := &columnStore{samples: samples, weights: weights, columns: make(map[string]*column)}
+	return &columnarSet{nil, store, rows}
+}
+
+func (s *columnarSet) Count(ctx context.Context) (int, error) {
+	return len(s.rows), nil
+}
+
+func (s *columnarSet) Weight(ctx context.Context) (float64, error) {
+	var result float64
+	for _, r := range s.rows {
+		result += s.store.weights[r]
+	}
+	return result, nil
+}
+
+func (s *columnarSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
+	if s.entropy != nil {
+		return *s.entropy, nil
+	}
+	weights, c, err := s.codeWeights(f)
+	if err != nil {
+		return 0.0, err
+	}
+	featureValueWeights := make(map[string]float64)
+	var count float64
+	for code, w := range weights {
+		if c.values[code] == nil || w == 0 {
+			continue
+		}
+		featureValueWeights[c.keys[code]] += w
+		count += w
+	}
+	result := weightsEntropy(featureValueWeights, count)
+	s.entropy = &result
+	return result, nil
+}
+
+func (s *columnarSet) FeatureValues(ctx context.Context, f feature.Feature) ([]interface{}, error) {
+	c, err := s.store.column(f)
+	if err != nil {
+		return nil, err
+	}
+	result := []interface{}{}
+	encountered := make([]bool, len(c.values))
+	for _, r := range s.rows {
+		code := c.codes[r]
+		if !encountered[code] {
+			encountered[code] = true
+			result = append(result, c.values[code])
+		}
+	}
+	return result, nil
+}
+
+func (s *columnarSet) SubsetWith(ctx context.Context, fc feature.Criterion) (Set, error) {
+	f := fc.Feature()
+	c, err := s.store.column(f)
+	if err != nil {
+		return nil, err
+	}
+	satisfied := make([]bool, len(c.values))
+	for code, v := range c.values {
+		satisfied[code], err = fc.SatisfiedBy(&valueSample{f.Name(), v})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var rows []int
+	for _, r := range s.rows {
+		if satisfied[c.codes[r]] {
+			rows = append(rows, r)
+		}
+	}
+	return &columnarSet{nil, s.store, rows}, nil
+}
+
+func (s *columnarSet) Samples(ctx context.Context) ([]Sample, error) {
+	samples := make([]Sample, 0, len(s.rows))
+	for _, r := range s.rows {
+		samples = append(samples, s.store.samples[r])
+	}
+	return samples, nil
+}
+
+func (s *columnarSet) CountFeatureValues(ctx context.Context, f feature.Feature) (map[string]int, error) {
+	c, err := s.store.column(f)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int, len(c.values))
+	for _, r := range s.rows {
+		counts[c.codes[r]]++
+	}
+	result := make(map[string]int)
+	for code, n := range counts {
+		if n > 0 {
+			result[c.keys[code]] += n
+		}
+	}
+	return result, nil
+}
+
+func (s *columnarSet) FeatureValueWeights(ctx context.Context, f feature.Feature) (map[string]float64, error) {
+	weights, c, err := s.codeWeights(f)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64)
+	for code, w := range weights {
+		if w != 0 {
+			result[c.keys[code]] += w
+		}
+	}
+	return result, nil
+}
+
+/*
+codeWeights takes a feature and returns the column of the feature and the
+weight of the samples of the set for every code on it, or an error if the
+column cannot be built.
+*/
+func (s *columnarSet) codeWeights(f feature.Feature) ([]float64, *column, error) {
+	c, err := s.store.column(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	weights := make([]float64, len(c.values))
+	for _, r := range s.rows {
+		weights[c.codes[r]] += s.store.weights[r]
+	}
+	return weights, c, nil
+}
+
+/*
+column takes a feature and returns the column of values of the samples on
+the store for it, building it if it does not exist yet, or an error if a
+value cannot be retrieved from a sample.
+*/
+func (cs *columnStore) column(f feature.Feature) (*column, error) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if c, ok := cs.columns[f.Name()]; ok {
+		return c, nil
+	}
+	c := &column{codes: make([]int32, len(cs.samples))}
+	codes := make(map[string]int32)
+	undefinedCode := int32(-1)
+	for i, sample := range cs.samples {
+		v, err := sample.ValueFor(f)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			if undefinedCode < 0 {
+				undefinedCode = c.add(v, fmt.Sprintf("%v", v))
+			}
+			c.codes[i] = undefinedCode
+			continue
+		}
+		key, ok := v.(string)
+		if !ok {
+			key = fmt.Sprintf("%v", v)
+		}
+		code, ok := codes[key]
+		if !ok {
+			code = c.add(v, key)
+			codes[key] = code
+		}
+		c.codes[i] = code
+	}
+	cs.columns[f.Name()] = c
+	return c, nil
+}
+
+/*
+add takes a value and its string representation, adds them to the distinct
+values of the column and returns their code.
+*/
+func (c *column) add(v interface{}, key string) int32 {
+	c.values = append(c.values, v)
+	c.keys = append(c.keys, key)
+	return int32(len(c.values) - 1)
+}
+
+func (vs *valueSample) ValueFor(f feature.Feature) (interface{}, error) {
+	if f.Name() != vs.name {
+		return nil, nil
+	}
+	return vs.value, nil
+}
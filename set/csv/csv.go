@@ -4,12 +4,13 @@ Package csv provides functions to read/write a set.Set as CSV
 package csv
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"strings"
 
 	"github.com/pbanos/botanic/feature"
 	"github.com/pbanos/botanic/set"
@@ -40,10 +41,52 @@ and generates a set with them.
 */
 type SetGenerator func([]set.Sample) set.Set
 
+/*
+Options holds the settings to read and write sets in CSV format that vary
+between locales and sources: the character separating the fields of every
+row, ',' if not set, and the number format of the values of continuous
+features, as well as the following:
+  - MissingValue is the string that stands for an undefined value, '?' if
+    not set.
+  - NoHeader makes the content have no header row, its columns being the
+    given features in their order.
+  - LazyQuotes allows quotes in unquoted fields and non-doubled quotes in
+    quoted fields when reading.
+  - IgnoreExtraColumns ignores the columns of the header that are not among
+    the given features when reading, wherever they are, and allows rows with
+    a different number of fields than the header: missing fields are taken
+    as undefined values and extra fields are ignored.
+  - Rejects makes the ReadSet and ReadSetBySample functions skip the rows
+    that cannot be read or parsed, handing them to it, instead of failing
+    on the first one.
+*/
+type Options struct {
+	Comma              rune
+	NumberFormat       set.NumberFormat
+	MissingValue       string
+	NoHeader           bool
+	LazyQuotes         bool
+	IgnoreExtraColumns bool
+	Rejects            *Rejects
+}
+
+/*
+missingValue returns the string that stands for an undefined value with the
+options.
+*/
+func (opts *Options) missingValue() string {
+	if opts.MissingValue == "" {
+		return "?"
+	}
+	return opts.MissingValue
+}
+
 type csvWriter struct {
-	count    int
-	features []feature.Feature
-	w        *csv.Writer
+	count        int
+	features     []feature.Feature
+	numberFormat set.NumberFormat
+	missingValue string
+	w            *csv.Writer
 }
 
 /*
@@ -54,10 +97,22 @@ samples parsed from the reader or an error.
 The header or first row of the CSV content is expected to consist of the names
 of the features in the given slice. The rest of the rows should consist of valid
 values for the all features and/or the '?' string to indicate an undefined value.
+Values of boolean features are true, false, 1 or 0, and values of discrete
+features that hash their values are hashed into their buckets.
 */
 func ReadSet(reader io.Reader, features []feature.Feature, sg SetGenerator) (set.Set, error) {
+	return ReadSetWithOptions(reader, features, sg, nil)
+}
+
+/*
+ReadSetWithOptions works as ReadSet but parses the CSV content according to
+the given options. Nil options are equivalent to the zero value: ',' as
+field separator, numbers in the format used by Go, '?' for undefined values
+and a header row with the names of the features.
+*/
+func ReadSetWithOptions(reader io.Reader, features []feature.Feature, sg SetGenerator, opts *Options) (set.Set, error) {
 	samples := []set.Sample{}
-	err := ReadSetBySample(reader, features, func(_ int, s set.Sample) (bool, error) {
+	err := ReadSetBySampleWithOptions(reader, features, opts, func(_ int, s set.Sample) (bool, error) {
 		samples = append(samples, s)
 		return true, nil
 	})
@@ -78,31 +133,102 @@ returned if something goes wrong when reading the file or parsing a sample.
 The header or first row of the CSV content is expected to consist of the names
 of the features in the given slice. The rest of the rows should consist of valid
 values for the all features and/or the '?' string to indicate an undefined value.
+Values of boolean features are true, false, 1 or 0, and values of discrete
+features that hash their values are hashed into their buckets.
 */
 func ReadSetBySample(reader io.Reader, features []feature.Feature, lambda func(int, set.Sample) (bool, error)) error {
+	return ReadSetBySampleWithOptions(reader, features, nil, lambda)
+}
+
+/*
+ReadSetBySampleWithOptions works as ReadSetBySample but parses the CSV
+content according to the given options. Nil options are equivalent to the
+zero value: ',' as field separator, numbers in the format used by Go, '?'
+for undefined values and a header row with the names of the features.
+*/
+func ReadSetBySampleWithOptions(reader io.Reader, features []feature.Feature, opts *Options, lambda func(int, set.Sample) (bool, error)) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	i := 0
+	return readRows(reader, features, opts, func(l int, row []string, s set.Sample, err error) (bool, error) {
+		if err != nil {
+			if opts.Rejects == nil {
+				return false, err
+			}
+			return true, opts.Rejects.Reject(l, joinRow(row, opts.Comma), err)
+		}
+		ok, err := lambda(i, s)
+		i++
+		return ok, err
+	})
+}
+
+/*
+ReadRowsWithOptions works as ReadSetBySampleWithOptions, but calls the
+lambda function with the line number of every row of the CSV content and
+either the sample parsed from it or the error reading or parsing it, instead
+of stopping on the first invalid row, so that invalid rows can be reported
+or skipped. It stops when the lambda function returns false or an error, and
+returns an error if the header is not valid or the content cannot be read.
+*/
+func ReadRowsWithOptions(reader io.Reader, features []feature.Feature, opts *Options, lambda func(int, set.Sample, error) (bool, error)) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return readRows(reader, features, opts, func(l int, _ []string, s set.Sample, err error) (bool, error) {
+		return lambda(l, s, err)
+	})
+}
+
+/*
+readRows works as ReadRowsWithOptions, taking non-nil options, but also
+calls the lambda function with the fields of every row, which are nil if
+they could not be read.
+*/
+func readRows(reader io.Reader, features []feature.Feature, opts *Options, lambda func(int, []string, set.Sample, error) (bool, error)) error {
 	featuresByName := featureSliceToMap(features)
 	r := csv.NewReader(reader)
-	header, err := r.Read()
-	if err != nil {
-		return fmt.Errorf("reading header: %v", err)
+	if opts.Comma != 0 {
+		r.Comma = opts.Comma
 	}
-	features, err = parseFeaturesFromCSVHeader(header, featuresByName)
-	if err != nil {
-		return err
+	r.LazyQuotes = opts.LazyQuotes
+	if opts.IgnoreExtraColumns {
+		r.FieldsPerRecord = -1
 	}
-	for l := 2; ; l++ {
+	firstLine := 1
+	if opts.NoHeader {
+		if !opts.IgnoreExtraColumns {
+			r.FieldsPerRecord = len(features)
+		}
+	} else {
+		header, err := r.Read()
+		if err != nil {
+			return fmt.Errorf("reading header: %v", err)
+		}
+		features, err = parseFeaturesFromCSVHeader(header, featuresByName, opts.IgnoreExtraColumns)
+		if err != nil {
+			return err
+		}
+		firstLine = 2
+	}
+	for l := firstLine; ; l++ {
+		var sample set.Sample
 		row, err := r.Read()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			err = fmt.Errorf("reading body: %w: %v", set.ErrInvalidSample, err)
+		} else if err != nil {
 			return fmt.Errorf("reading body: %v", err)
+		} else {
+			sample, err = parseSampleFromCSVRow(row, features, opts.NumberFormat, opts.missingValue())
+			if err != nil {
+				err = fmt.Errorf("parsing line %d: %w: %v", l, set.ErrInvalidSample, err)
+			}
 		}
-		sample, err := parseSampleFromCSVRow(row, features)
-		if err != nil {
-			return fmt.Errorf("parsing line %d from %v: %v", l, reader, err)
-		}
-		ok, err := lambda(l-2, sample)
+		ok, err := lambda(l, row, sample, err)
 		if err != nil {
 			return err
 		}
@@ -113,6 +239,24 @@ func ReadSetBySample(reader io.Reader, features []feature.Feature, lambda func(i
 	return nil
 }
 
+/*
+joinRow takes the fields of a CSV row and the character separating them, ','
+if 0, and returns the row as it would be written, without a line break.
+*/
+func joinRow(row []string, comma rune) string {
+	if row == nil {
+		return ""
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if comma != 0 {
+		w.Comma = comma
+	}
+	w.Write(row)
+	w.Flush()
+	return strings.TrimRight(b.String(), "\r\n")
+}
+
 /*
 ReadSetFromFilePath takes a filepath string, a slice of features and a SetGenerator,
 opens the file to which the filepath points to and uses ReadSet to return a
@@ -127,13 +271,13 @@ func ReadSetFromFilePath(filepath string, features []feature.Feature, sg SetGene
 	} else {
 		f, err = os.Open(filepath)
 		if err != nil {
-			return nil, fmt.Errorf("reading training set: %v", err)
+			return nil, fmt.Errorf("reading training set: %w", err)
 		}
 	}
 	defer f.Close()
 	set, err := ReadSet(f, features, sg)
 	if err != nil {
-		err = fmt.Errorf("parsing CSV file %s: %v", filepath, err)
+		err = fmt.Errorf("parsing CSV file %s: %w", filepath, err)
 	}
 	return set, err
 }
@@ -151,6 +295,8 @@ when reading the file or parsing a sample.
 The header or first row of the CSV content is expected to consist of the names
 of the features in the given slice. The rest of the rows should consist of valid
 values for the all features and/or the '?' string to indicate an undefined value.
+Values of boolean features are true, false, 1 or 0, and values of discrete
+features that hash their values are hashed into their buckets.
 */
 func ReadSetBySampleFromFilePath(filepath string, features []feature.Feature, lambda func(int, set.Sample) (bool, error)) error {
 	var f *os.File
@@ -160,7 +306,7 @@ func ReadSetBySampleFromFilePath(filepath string, features []feature.Feature, la
 	} else {
 		f, err = os.Open(filepath)
 		if err != nil {
-			return fmt.Errorf("reading training set: %v", err)
+			return fmt.Errorf("reading training set: %w", err)
 		}
 	}
 	defer f.Close()
@@ -176,16 +322,34 @@ NewWriter takes an io.Writer and a slice of feature.Features and
 returns a Writer that will write any samples on the io.Writer.
 */
 func NewWriter(writer io.Writer, features []feature.Feature) (Writer, error) {
+	return NewWriterWithOptions(writer, features, nil)
+}
+
+/*
+NewWriterWithOptions works as NewWriter but writes the samples according to
+the given options. Nil options are equivalent to the zero value: ',' as
+field separator, numbers in the format used by Go, '?' for undefined values
+and a header row with the names of the features.
+*/
+func NewWriterWithOptions(writer io.Writer, features []feature.Feature, opts *Options) (Writer, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
 	w := csv.NewWriter(writer)
-	record := make([]string, len(features))
-	for i, f := range features {
-		record[i] = f.Name()
+	if opts.Comma != 0 {
+		w.Comma = opts.Comma
 	}
-	err := w.Write(record)
-	if err != nil {
-		return nil, fmt.Errorf("writing CSV header: %v", err)
+	if !opts.NoHeader {
+		record := make([]string, len(features))
+		for i, f := range features {
+			record[i] = f.Name()
+		}
+		err := w.Write(record)
+		if err != nil {
+			return nil, fmt.Errorf("writing CSV header: %v", err)
+		}
 	}
-	return &csvWriter{features: features, w: w}, nil
+	return &csvWriter{features: features, numberFormat: opts.NumberFormat, missingValue: opts.missingValue(), w: w}, nil
 }
 
 /*
@@ -210,35 +374,56 @@ func WriteCSVSet(ctx context.Context, writer io.Writer, s set.Set, features []fe
 	return cw.Flush()
 }
 
-func parseFeaturesFromCSVHeader(header []string, features map[string]feature.Feature) ([]feature.Feature, error) {
-	featureOrder := []feature.Feature{}
+/*
+parseFeaturesFromCSVHeader takes the header of a CSV set, the features by
+name and whether to ignore unknown columns and returns the feature of every
+column of the header, which is nil for the ignored ones. Without ignoring
+unknown columns only the last one can be unknown, and an error is returned
+otherwise.
+*/
+func parseFeaturesFromCSVHeader(header []string, features map[string]feature.Feature, ignoreUnknown bool) ([]feature.Feature, error) {
+	featureOrder := make([]feature.Feature, len(header))
 	for i, name := range header {
 		f, ok := features[name]
 		if ok {
-			featureOrder = append(featureOrder, f)
+			featureOrder[i] = f
 		} else {
-			if i != len(header)-1 {
-				return nil, fmt.Errorf("parsing header: reference to unknown feature %s", name)
+			if i != len(header)-1 && !ignoreUnknown {
+				return nil, fmt.Errorf("parsing header: reference to %w %s", feature.ErrUnknownFeature, name)
 			}
 		}
 	}
 	return featureOrder, nil
 }
 
-func parseSampleFromCSVRow(row []string, featureOrder []feature.Feature) (set.Sample, error) {
+func parseSampleFromCSVRow(row []string, featureOrder []feature.Feature, nf set.NumberFormat, missingValue string) (set.Sample, error) {
 	featureValues := make(map[string]interface{})
 	for i, f := range featureOrder {
-		v := row[i]
+		if f == nil {
+			continue
+		}
+		v := missingValue
+		if i < len(row) {
+			v = row[i]
+		}
 		var value interface{}
 		var err error
 		var ok bool
-		if v != "?" {
-			if _, ok = f.(*feature.ContinuousFeature); ok {
-				value, err = strconv.ParseFloat(v, 64)
+		if v != missingValue {
+			switch f := f.(type) {
+			case *feature.ContinuousFeature:
+				value, err = nf.ParseFloat(v)
 				if err != nil {
 					return nil, fmt.Errorf("converting %s to float64: %v", v, err)
 				}
-			} else {
+			case *feature.BooleanFeature:
+				value, err = feature.ParseBoolean(v)
+				if err != nil {
+					return nil, fmt.Errorf("converting %s to bool: %v", v, err)
+				}
+			case *feature.DiscreteFeature:
+				value = f.HashValue(v)
+			default:
 				value = v
 			}
 		}
@@ -274,7 +459,9 @@ func (cw *csvWriter) WriteSample(sample set.Sample) error {
 			return err
 		}
 		if v == nil {
-			record[j] = "?"
+			record[j] = cw.missingValue
+		} else if fv, ok := v.(float64); ok {
+			record[j] = cw.numberFormat.FormatFloat(fv)
 		} else {
 			record[j] = fmt.Sprintf("%v", v)
 		}
@@ -0,0 +1,62 @@
+package csv
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"sync"
+)
+
+/*
+Rejects takes the invalid rows skipped when reading sets, counting them and
+writing them on its writer, if any, in CSV format with the line of every
+row, the reason it was rejected and the row as it was read, under a
+line,error,row header, so that they can be inspected and fixed later. It is
+safe for concurrent use.
+*/
+type Rejects struct {
+	lock  sync.Mutex
+	w     *csv.Writer
+	count int
+}
+
+/*
+NewRejects takes an io.Writer, which can be nil to only count the rejected
+rows, and returns a Rejects that writes them on it.
+*/
+func NewRejects(w io.Writer) *Rejects {
+	r := &Rejects{}
+	if w != nil {
+		r.w = csv.NewWriter(w)
+	}
+	return r
+}
+
+/*
+Reject takes the line of a rejected row, the row as it was read and the
+reason it was rejected and counts it, writing it on the writer of the
+Rejects, if any. It returns an error if the row cannot be written.
+*/
+func (r *Rejects) Reject(line int, row string, reason error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.count++
+	if r.w == nil {
+		return nil
+	}
+	if r.count == 1 {
+		r.w.Write([]string{"line", "error", "row"})
+	}
+	r.w.Write([]string{strconv.Itoa(line), reason.Error(), row})
+	r.w.Flush()
+	return r.w.Error()
+}
+
+/*
+Count returns the number of rows rejected.
+*/
+func (r *Rejects) Count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.count
+}
@@ -0,0 +1,15 @@
+package set
+
+import "errors"
+
+var (
+	// ErrBackendUnavailable is the error wrapped by the errors of sets
+	// whose backend, such as a database, cannot be reached, so that
+	// callers can tell them with errors.Is from those of bad data and
+	// retry later.
+	ErrBackendUnavailable = errors.New("set backend unavailable")
+	// ErrInvalidSample is the error wrapped by the errors returned when a
+	// sample cannot be read or parsed, such as a row with a value that is
+	// not valid for its feature, or when it has an invalid weight.
+	ErrInvalidSample = errors.New("invalid sample")
+)
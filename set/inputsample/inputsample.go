@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 
 	"github.com/pbanos/botanic/feature"
 	"github.com/pbanos/botanic/set"
@@ -26,6 +25,7 @@ type readSample struct {
 	scanner               *bufio.Scanner
 	featureValueRequester FeatureValueRequester
 	features              []feature.Feature
+	numberFormat          set.NumberFormat
 }
 
 /*
@@ -56,16 +56,31 @@ reader until a line containing a valid float64 number is found.
 
 For a feature.DiscreteFeature, lines will be read from the
 reader until a line with a valid value for the feature is found.
+Any line is valid for features that hash their values, as it is
+hashed into one of their buckets.
 
-For both kind of feature.Feature, non accepted values will be
+For a feature.BooleanFeature, lines will be read from the
+reader until a line with a value accepted by feature.ParseBoolean
+is found.
+
+For every kind of feature.Feature, non accepted values will be
 rejected with the FeatureValueRequester's RejectValueFor method.
 
 Attempting to obtain a value for Feature not in the given
 features slice, or for another type of feature will return nil.
 */
 func New(r io.Reader, features []feature.Feature, featureValueRequester FeatureValueRequester, undefinedValue string) set.Sample {
+	return NewWithNumberFormat(r, features, featureValueRequester, undefinedValue, set.NumberFormat{})
+}
+
+/*
+NewWithNumberFormat works as New, but the values for continuous features are
+parsed according to the given number format, so that they can be provided
+with the decimal and thousands separators of the user's locale.
+*/
+func NewWithNumberFormat(r io.Reader, features []feature.Feature, featureValueRequester FeatureValueRequester, undefinedValue string, nf set.NumberFormat) set.Sample {
 	scanner := bufio.NewScanner(os.Stdin)
-	return &readSample{make(map[string]interface{}), undefinedValue, scanner, featureValueRequester, features}
+	return &readSample{make(map[string]interface{}), undefinedValue, scanner, featureValueRequester, features, nf}
 }
 
 func (rs *readSample) ValueFor(f feature.Feature) (interface{}, error) {
@@ -91,6 +106,8 @@ func (rs *readSample) ValueFor(f feature.Feature) (interface{}, error) {
 		return rs.readContinuousFeature(featureWithInfo)
 	case *feature.DiscreteFeature:
 		return rs.readDiscreteFeature(featureWithInfo)
+	case *feature.BooleanFeature:
+		return rs.readBooleanFeature(featureWithInfo)
 	}
 	return nil, fmt.Errorf("do not know how to read a value for features of type %T", featureWithInfo)
 }
@@ -104,7 +121,7 @@ func (rs *readSample) readContinuousFeature(f feature.Feature) (interface{}, err
 			rs.obtainedValues[f.Name()] = nil
 			return nil, nil
 		}
-		value, err = strconv.ParseFloat(line, 64)
+		value, err = rs.numberFormat.ParseFloat(line)
 		if err == nil {
 			rs.obtainedValues[f.Name()] = value
 			return value, nil
@@ -132,8 +149,9 @@ func (rs *readSample) readDiscreteFeature(df *feature.DiscreteFeature) (interfac
 			rs.obtainedValues[df.Name()] = nil
 			return nil, nil
 		}
+		hashed := df.HashValue(line)
 		for _, v := range df.AvailableValues() {
-			if v == line {
+			if v == hashed {
 				rs.obtainedValues[df.Name()] = v
 				return v, nil
 			}
@@ -152,3 +170,31 @@ func (rs *readSample) readDiscreteFeature(df *feature.DiscreteFeature) (interfac
 	}
 	return nil, fmt.Errorf("EOF when requesting value")
 }
+
+func (rs *readSample) readBooleanFeature(bf *feature.BooleanFeature) (interface{}, error) {
+	var err error
+	for rs.scanner.Scan() {
+		line := rs.scanner.Text()
+		if line == rs.undefinedValue {
+			rs.obtainedValues[bf.Name()] = nil
+			return nil, nil
+		}
+		value, perr := feature.ParseBoolean(line)
+		if perr == nil {
+			rs.obtainedValues[bf.Name()] = value
+			return value, nil
+		}
+		err = rs.featureValueRequester.RejectValueFor(bf, line)
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = rs.scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("EOF when requesting value")
+}
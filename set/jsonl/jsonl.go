@@ -0,0 +1,364 @@
+/*
+Package jsonl provides functions to read/write a set.Set as JSON Lines, also
+known as newline-delimited JSON (NDJSON): a JSON object per line, with a
+property for every feature of a sample.
+*/
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+	"github.com/pbanos/botanic/set/csv"
+)
+
+type jsonlWriter struct {
+	count    int
+	features []feature.Feature
+	keys     [][]byte
+	w        *bufio.Writer
+}
+
+/*
+ReadSet takes an io.Reader for a JSON Lines stream, a slice of features and a
+SetGenerator and returns set.Set built with the SetGenerator and the
+samples parsed from the reader or an error.
+
+Every non-blank line of the content is expected to be a JSON object with the
+values of a sample for the features in the given slice as properties named
+after them. Continuous features take numbers, discrete features take
+strings, which are hashed into their buckets for features that hash their
+values, and boolean features take true or false, or the strings and numbers
+accepted by feature.ParseBoolean. A null value, the '?' string or the
+absence of the property indicate an undefined value. Properties not named
+after any of the features are ignored.
+*/
+func ReadSet(reader io.Reader, features []feature.Feature, sg csv.SetGenerator) (set.Set, error) {
+	samples := []set.Sample{}
+	err := ReadSetBySample(reader, features, func(_ int, s set.Sample) (bool, error) {
+		samples = append(samples, s)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sg(samples), nil
+}
+
+/*
+ReadSetBySample takes an io.Reader for a JSON Lines stream, a slice of
+features and a lambda function on an integer and a set.Sample that returns a
+boolean value. It parses the samples from the reader and for each it calls the
+lambda function with the sample and its index as parameters. If the lambda
+function returns true, it will continue processing the next sample, otherwise
+it will stop. An error is returned if something goes wrong when reading the
+stream or parsing a sample.
+
+Every non-blank line of the content is expected to be a JSON object with the
+values of a sample for the features in the given slice as properties named
+after them. Continuous features take numbers, discrete features take
+strings, which are hashed into their buckets for features that hash their
+values, and boolean features take true or false, or the strings and numbers
+accepted by feature.ParseBoolean. A null value, the '?' string or the
+absence of the property indicate an undefined value. Properties not named
+after any of the features are ignored.
+*/
+func ReadSetBySample(reader io.Reader, features []feature.Feature, lambda func(int, set.Sample) (bool, error)) error {
+	i := 0
+	return ReadRows(reader, features, func(_ int, s set.Sample, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		ok, err := lambda(i, s)
+		i++
+		return ok, err
+	})
+}
+
+/*
+ReadRows works as ReadSetBySample, but calls the lambda function with the
+line number of every non-blank line of the JSON Lines stream and either the
+sample parsed from it or the error parsing it, instead of stopping on the
+first invalid line, so that invalid lines can be reported or skipped. It
+stops when the lambda function returns false or an error, and returns an
+error if the stream cannot be read.
+*/
+func ReadRows(reader io.Reader, features []feature.Feature, lambda func(int, set.Sample, error) (bool, error)) error {
+	return readRows(reader, features, func(l int, _ []byte, s set.Sample, err error) (bool, error) {
+		return lambda(l, s, err)
+	})
+}
+
+/*
+ReadSetBySampleWithRejects works as ReadSetBySample, but skips the lines
+that cannot be parsed instead of failing on them, handing them to the given
+csv.Rejects with the error parsing them. It returns an error if the stream
+cannot be read, the rejected lines cannot be written or the lambda function
+returns one.
+*/
+func ReadSetBySampleWithRejects(reader io.Reader, features []feature.Feature, rejects *csv.Rejects, lambda func(int, set.Sample) (bool, error)) error {
+	i := 0
+	return readRows(reader, features, func(l int, line []byte, s set.Sample, err error) (bool, error) {
+		if err != nil {
+			return true, rejects.Reject(l, string(line), err)
+		}
+		ok, err := lambda(i, s)
+		i++
+		return ok, err
+	})
+}
+
+/*
+readRows works as ReadRows, but also calls the lambda function with every
+non-blank line, trimmed.
+*/
+func readRows(reader io.Reader, features []feature.Feature, lambda func(int, []byte, set.Sample, error) (bool, error)) error {
+	r := bufio.NewReader(reader)
+	for l := 1; ; l++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading line %d: %v", l, err)
+		}
+		eof := err == io.EOF
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			sample, err := parseSampleFromJSONLine(line, features)
+			if err != nil {
+				err = fmt.Errorf("parsing line %d: %w: %v", l, set.ErrInvalidSample, err)
+			}
+			ok, err := lambda(l, line, sample, err)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+		}
+		if eof {
+			break
+		}
+	}
+	return nil
+}
+
+/*
+ReadSetFromFilePath takes a filepath string, a slice of features and a SetGenerator,
+opens the file to which the filepath points to and uses ReadSet to return a
+set.Set or an error read from it. It will return an error if the given filepath
+cannot be opened for reading.
+*/
+func ReadSetFromFilePath(filepath string, features []feature.Feature, sg csv.SetGenerator) (set.Set, error) {
+	var f *os.File
+	var err error
+	if filepath == "" {
+		f = os.Stdin
+	} else {
+		f, err = os.Open(filepath)
+		if err != nil {
+			return nil, fmt.Errorf("reading set: %w", err)
+		}
+	}
+	defer f.Close()
+	set, err := ReadSet(f, features, sg)
+	if err != nil {
+		err = fmt.Errorf("parsing JSON Lines file %s: %w", filepath, err)
+	}
+	return set, err
+}
+
+/*
+ReadSetBySampleFromFilePath takes an filepath string for a JSON Lines
+stream, a slice of features and a lambda function on an integer and a
+set.Sample that returns a boolean value. It opens the file for reading (if
+the filepath is "" os.Stdin is used instead) and uses ReadSetBySample to call
+the lambda function for every sample parsed from it. An error is returned if
+something goes wrong when reading the file or parsing a sample.
+*/
+func ReadSetBySampleFromFilePath(filepath string, features []feature.Feature, lambda func(int, set.Sample) (bool, error)) error {
+	var f *os.File
+	var err error
+	if filepath == "" {
+		f = os.Stdin
+	} else {
+		f, err = os.Open(filepath)
+		if err != nil {
+			return fmt.Errorf("reading set: %w", err)
+		}
+	}
+	defer f.Close()
+	return ReadSetBySample(f, features, lambda)
+}
+
+/*
+NewWriter takes an io.Writer and a slice of feature.Features and
+returns a csv.Writer that will write any samples on the io.Writer as JSON
+Lines, with a property for every feature in the slice in the same order.
+Undefined values are written as null.
+*/
+func NewWriter(writer io.Writer, features []feature.Feature) (csv.Writer, error) {
+	keys := make([][]byte, len(features))
+	for i, f := range features {
+		k, err := json.Marshal(f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("encoding name of feature %s: %v", f.Name(), err)
+		}
+		keys[i] = k
+	}
+	return &jsonlWriter{features: features, keys: keys, w: bufio.NewWriter(writer)}, nil
+}
+
+/*
+WriteJSONLSet takes a writer, a set.Set and a slice of features and
+dumps to the writer the set in JSON Lines format, specifying only the features
+in the given slice for the samples. It returns an error if something
+went wrong when writing to the writer, or codifying the samples.
+*/
+func WriteJSONLSet(ctx context.Context, writer io.Writer, s set.Set, features []feature.Feature) error {
+	jw, err := NewWriter(writer, features)
+	if err != nil {
+		return err
+	}
+	samples, err := s.Samples(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = jw.Write(ctx, samples)
+	if err != nil {
+		return err
+	}
+	return jw.Flush()
+}
+
+func parseSampleFromJSONLine(line []byte, features []feature.Feature) (set.Sample, error) {
+	var record map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	err := dec.Decode(&record)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	featureValues := make(map[string]interface{})
+	for _, f := range features {
+		value, err := parseValue(record[f.Name()], f)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := f.Valid(value); !ok {
+			return nil, fmt.Errorf("invalid value %v of type %T for feature %s: %v", value, value, f.Name(), err)
+		}
+		featureValues[f.Name()] = value
+	}
+	return set.NewSample(featureValues), nil
+}
+
+func parseValue(v interface{}, f feature.Feature) (interface{}, error) {
+	if v == nil || v == "?" {
+		return nil, nil
+	}
+	if _, ok := f.(*feature.ContinuousFeature); ok {
+		switch v := v.(type) {
+		case json.Number:
+			value, err := v.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("converting %s to float64 for feature %s: %v", v, f.Name(), err)
+			}
+			return value, nil
+		case string:
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("converting %s to float64 for feature %s: %v", v, f.Name(), err)
+			}
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, fmt.Errorf("invalid non-finite value %s for continuous feature %s", v, f.Name())
+			}
+			return value, nil
+		}
+		return nil, fmt.Errorf("invalid value %v of type %T for continuous feature %s", v, v, f.Name())
+	}
+	if _, ok := f.(*feature.BooleanFeature); ok {
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string, json.Number:
+			value, err := feature.ParseBoolean(fmt.Sprintf("%v", v))
+			if err != nil {
+				return nil, fmt.Errorf("converting %v to bool for feature %s: %v", v, f.Name(), err)
+			}
+			return value, nil
+		}
+		return nil, fmt.Errorf("invalid value %v of type %T for boolean feature %s", v, v, f.Name())
+	}
+	var value string
+	switch v := v.(type) {
+	case string:
+		value = v
+	case json.Number:
+		value = v.String()
+	case bool:
+		value = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("invalid value %v of type %T for discrete feature %s", v, v, f.Name())
+	}
+	if df, ok := f.(*feature.DiscreteFeature); ok {
+		return df.HashValue(value), nil
+	}
+	return value, nil
+}
+
+func (jw *jsonlWriter) Count() int {
+	return jw.count
+}
+
+func (jw *jsonlWriter) Write(ctx context.Context, samples []set.Sample) (int, error) {
+	for n, sample := range samples {
+		err := jw.WriteSample(sample)
+		if err != nil {
+			return n, err
+		}
+	}
+	return len(samples), nil
+}
+
+func (jw *jsonlWriter) WriteSample(sample set.Sample) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range jw.features {
+		v, err := sample.ValueFor(f)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encoding value %v for feature %s of sample %d: %v", v, f.Name(), jw.count+1, err)
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(jw.keys[i])
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	_, err := jw.w.Write(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("writing JSON line for sample %d: %v", jw.count+1, err)
+	}
+	jw.count++
+	return nil
+}
+
+func (jw *jsonlWriter) Flush() error {
+	return jw.w.Flush()
+}
@@ -0,0 +1,112 @@
+package set
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+NumberFormat describes how the values of continuous features are written as
+text in a given locale: the character that separates the integer part of a
+number from its decimals and the one, if any, that separates groups of
+thousands in its integer part. The zero value describes the format used by
+Go, with '.' as decimal separator and no thousands separator.
+*/
+type NumberFormat struct {
+	DecimalSeparator   rune
+	ThousandsSeparator rune
+}
+
+/*
+NewNumberFormat takes the decimal and thousands separators of a number
+format as strings and returns the number format or an error if the
+separators are not single characters, or are the same character, or are
+digits or signs. An empty decimal separator stands for '.' and an empty
+thousands separator for no separator.
+*/
+func NewNumberFormat(decimalSeparator, thousandsSeparator string) (NumberFormat, error) {
+	nf := NumberFormat{}
+	var err error
+	nf.DecimalSeparator, err = separatorRune("decimal", decimalSeparator)
+	if err != nil {
+		return nf, err
+	}
+	nf.ThousandsSeparator, err = separatorRune("thousands", thousandsSeparator)
+	if err != nil {
+		return nf, err
+	}
+	if nf.ThousandsSeparator != 0 && nf.ThousandsSeparator == nf.decimalSeparator() {
+		return nf, fmt.Errorf("decimal and thousands separators cannot be the same")
+	}
+	return nf, nil
+}
+
+/*
+ParseFloat takes a number written in the number format and returns it as a
+float64 or an error if it cannot be parsed. Thousands separators are
+accepted anywhere in the integer part of the number, and '.' is only
+accepted as the decimal separator or the thousands separator of the format.
+*/
+func (nf NumberFormat) ParseFloat(s string) (float64, error) {
+	ds := nf.decimalSeparator()
+	if ds == '.' && nf.ThousandsSeparator == 0 {
+		return strconv.ParseFloat(s, 64)
+	}
+	var b strings.Builder
+	decimals := false
+	for _, r := range s {
+		switch {
+		case r == ds:
+			if decimals {
+				return 0.0, fmt.Errorf("parsing %q: more than one decimal separator", s)
+			}
+			decimals = true
+			b.WriteRune('.')
+		case nf.ThousandsSeparator != 0 && r == nf.ThousandsSeparator:
+			if decimals {
+				return 0.0, fmt.Errorf("parsing %q: thousands separator after the decimal separator", s)
+			}
+		case r == '.':
+			return 0.0, fmt.Errorf("parsing %q: unexpected '.', the decimal separator is %q", s, ds)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strconv.ParseFloat(b.String(), 64)
+}
+
+/*
+FormatFloat takes a float64 and returns it written in the number format. No
+thousands separators are written, so that the result can be read back
+unambiguously.
+*/
+func (nf NumberFormat) FormatFloat(f float64) string {
+	s := fmt.Sprintf("%v", f)
+	if ds := nf.decimalSeparator(); ds != '.' {
+		s = strings.Replace(s, ".", string(ds), 1)
+	}
+	return s
+}
+
+func (nf NumberFormat) decimalSeparator() rune {
+	if nf.DecimalSeparator == 0 {
+		return '.'
+	}
+	return nf.DecimalSeparator
+}
+
+func separatorRune(name, separator string) (rune, error) {
+	if separator == "" {
+		return 0, nil
+	}
+	runes := []rune(separator)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("%s separator must be a single character, got %q", name, separator)
+	}
+	r := runes[0]
+	if strings.ContainsRune("0123456789+-eE", r) {
+		return 0, fmt.Errorf("%s separator cannot be %q", name, separator)
+	}
+	return r, nil
+}
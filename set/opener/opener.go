@@ -0,0 +1,248 @@
+/*
+Package opener provides a registry of the functions that open sets by the
+scheme of their location, so that every command reading sets opens them
+with a single Open function, and supporting a new backend only requires
+registering its OpenerFunc once. Sets can also be read as a stream of
+samples with Stream, with the StreamerFunc registered for their scheme if
+any, so that reading them does not require loading them in memory.
+*/
+package opener
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+)
+
+const (
+	// FileScheme is the scheme of sets on files, and on STDIN, which is
+	// given as an empty location.
+	FileScheme = "file"
+	// SQLite3Scheme is the scheme of sets on SQLite3 databases, whose
+	// location is a path ending in .db, optionally followed by query
+	// parameters such as tables.
+	SQLite3Scheme = "sqlite3"
+)
+
+/*
+Sampler takes samples from the sets opened, such as the first ones or a
+random sample of them.
+
+Its Read method takes a function that reads a stream of samples calling the
+function it is given with every sample and a lambda function, and calls the
+read function so that the lambda is only called with the samples taken. It
+returns the error returned by the read function or the lambda, if any.
+
+Its Stream method takes a context, the function that cancels the reading of
+a stream of samples and the stream with its stream of errors and returns
+the streams of the samples taken and of errors.
+*/
+type Sampler interface {
+	Read(read func(func(int, set.Sample) (bool, error)) error, lambda func(int, set.Sample) (bool, error)) error
+	Stream(ctx context.Context, cancel context.CancelFunc, samples <-chan set.Sample, errs <-chan error) (<-chan set.Sample, <-chan error)
+}
+
+/*
+Options holds the settings with which sets are opened. Openers ignore the
+settings that do not apply to their backend.
+
+Name describes the set opened, such as "training set", for the messages
+logged and the errors returned. MaxConn is the maximum number of
+connections to open to a database, with 0 meaning the default of its
+adapter. WeightFeature is the feature holding the weight of every sample, if
+any. SetGenerator creates the sets in memory with the samples read, and
+defaults to set.New. Sampler, if not nil, restricts the set opened to the
+samples it takes from it, which are then loaded in memory.
+
+Backend holds the settings that only apply to some backends by name, such
+as the QueryExplainer of SQL databases, for the OpenerFuncs registered for
+them to look up and type-assert, so that this package does not depend on
+any backend.
+*/
+type Options struct {
+	Name          string
+	MaxConn       int
+	WeightFeature *feature.ContinuousFeature
+	SetGenerator  func([]set.Sample) set.Set
+	Sampler       Sampler
+	Backend       map[string]interface{}
+}
+
+/*
+OpenerFunc takes a context, the location of a set, a slice of features and
+options and returns the set at the location with the features or an error
+if it cannot be opened. OpenerFuncs are given options with a SetGenerator
+and a Name. If the options have a Sampler, an OpenerFunc may apply it
+itself, as those reading files do, and otherwise Open applies it to the set
+returned if it is a set.Reader.
+*/
+type OpenerFunc func(ctx context.Context, location string, features []feature.Feature, opts *Options) (set.Set, error)
+
+/*
+StreamerFunc takes a context, the location of a set, a slice of features and
+options and returns a channel on which the samples of the set at the
+location are sent as they are read and a channel on which the error reading
+them, if any, is sent once the channel of samples is closed, or an error if
+the set cannot be opened. Cancelling the context stops sending samples.
+StreamerFuncs are given options with a SetGenerator and a Name, and without
+a Sampler, which Stream applies to the samples they send.
+*/
+type StreamerFunc func(ctx context.Context, location string, features []feature.Feature, opts *Options) (<-chan set.Sample, <-chan error, error)
+
+var (
+	lock      sync.RWMutex
+	openers   = make(map[string]OpenerFunc)
+	streamers = make(map[string]StreamerFunc)
+)
+
+/*
+Register takes a scheme and an OpenerFunc and registers the function to
+open the sets whose location has the scheme, replacing the one registered
+before for it, if any.
+*/
+func Register(scheme string, f OpenerFunc) {
+	lock.Lock()
+	defer lock.Unlock()
+	openers[scheme] = f
+}
+
+/*
+RegisterStreamer takes a scheme and a StreamerFunc and registers the
+function to read the sets whose location has the scheme as a stream with
+Stream, replacing the one registered before for it, if any.
+*/
+func RegisterStreamer(scheme string, f StreamerFunc) {
+	lock.Lock()
+	defer lock.Unlock()
+	streamers[scheme] = f
+}
+
+/*
+Scheme takes the location of a set and returns its scheme: the part before
+:// for URLs such as postgresql://host/db, SQLite3Scheme for paths ending in
+.db and FileScheme for the rest of paths and the empty location of STDIN.
+*/
+func Scheme(location string) string {
+	if i := strings.Index(location, "://"); i > 0 {
+		return location[:i]
+	}
+	path := location
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	if strings.HasSuffix(path, ".db") {
+		return SQLite3Scheme
+	}
+	return FileScheme
+}
+
+/*
+Open takes a context, the location of a set, a slice of features and
+options and returns the set at the location opened with the OpenerFunc
+registered for its scheme, or an error if none is registered or the set
+cannot be opened. Nil options are the same as the zero Options. If the
+options have a Sampler and the OpenerFunc returns a set.Reader, the samples
+taken from it are read and returned in a set in memory.
+*/
+func Open(ctx context.Context, location string, features []feature.Feature, opts *Options) (set.Set, error) {
+	scheme := Scheme(location)
+	lock.RLock()
+	f, ok := openers[scheme]
+	lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no opener registered for %s sets at %s", scheme, location)
+	}
+	o := withDefaults(opts)
+	s, err := f(ctx, location, features, &o)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := s.(set.Reader)
+	if o.Sampler == nil || !ok {
+		return s, nil
+	}
+	return sample(ctx, r, &o)
+}
+
+/*
+Stream takes a context, the location of a set, a slice of features and
+options and returns a channel on which the samples of the set at the
+location are sent and a channel on which the error reading them, if any, is
+sent once the channel of samples is closed, or an error if the set cannot
+be opened. The set is read with the StreamerFunc registered for its scheme,
+and the samples taken from it by the Sampler of the options, if any, are
+sent as they are read. Without a StreamerFunc for the scheme, the set is
+opened with Open and read with set.Stream. Nil options are the same as the
+zero Options. Cancelling the context stops sending samples.
+*/
+func Stream(ctx context.Context, location string, features []feature.Feature, opts *Options) (<-chan set.Sample, <-chan error, error) {
+	lock.RLock()
+	f, ok := streamers[Scheme(location)]
+	lock.RUnlock()
+	if !ok {
+		s, err := Open(ctx, location, features, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		sampleStream, errStream := set.Stream(ctx, s)
+		return sampleStream, errStream, nil
+	}
+	o := withDefaults(opts)
+	sampler := o.Sampler
+	o.Sampler = nil
+	if sampler == nil {
+		return f(ctx, location, features, &o)
+	}
+	readCtx, cancel := context.WithCancel(ctx)
+	sampleStream, errStream, err := f(readCtx, location, features, &o)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	sampleStream, errStream = sampler.Stream(ctx, cancel, sampleStream, errStream)
+	return sampleStream, errStream, nil
+}
+
+/*
+withDefaults takes options, which may be nil, and returns a copy of them
+with the default Name and SetGenerator set if they have none.
+*/
+func withDefaults(opts *Options) Options {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Name == "" {
+		o.Name = "set"
+	}
+	if o.SetGenerator == nil {
+		o.SetGenerator = set.New
+	}
+	return o
+}
+
+/*
+sample takes a context, a set that can be read as a stream and options with
+a Sampler and returns a set in memory with the samples taken by the Sampler
+from the set, stopping the reading once no more are needed, or an error if
+they cannot be read.
+*/
+func sample(ctx context.Context, r set.Reader, opts *Options) (set.Set, error) {
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sampleStream, errStream := r.Read(readCtx)
+	sampleStream, errStream = opts.Sampler.Stream(ctx, cancel, sampleStream, errStream)
+	var samples []set.Sample
+	for s := range sampleStream {
+		samples = append(samples, s)
+	}
+	err := <-errStream
+	if err != nil {
+		return nil, fmt.Errorf("reading sample of %s: %v", opts.Name, err)
+	}
+	return opts.SetGenerator(samples), nil
+}
@@ -35,3 +35,80 @@ func (s *sample) ValueFor(feature feature.Feature) (interface{}, error) {
 func (s *sample) String() string {
 	return fmt.Sprintf("[%v]", s.featureValues)
 }
+
+/*
+WeightedSample is a Sample with a weight that determines how much it counts
+towards the statistics of the sets it belongs to, such as their entropy or
+the probabilities of the predictions made from them.
+
+Its Weight method returns the weight of the sample.
+*/
+type WeightedSample interface {
+	Sample
+	Weight() float64
+}
+
+/*
+SampleWeight takes a sample and returns its weight: the value returned by
+its Weight method if it is a WeightedSample or 1 otherwise.
+*/
+func SampleWeight(s Sample) float64 {
+	if ws, ok := s.(WeightedSample); ok {
+		return ws.Weight()
+	}
+	return 1.0
+}
+
+type weightedSample struct {
+	Sample
+	weight float64
+}
+
+/*
+NewWeightedSample takes a map of feature string names to values and a weight
+and returns a WeightedSample with them.
+*/
+func NewWeightedSample(featureValues map[string]interface{}, weight float64) WeightedSample {
+	return &weightedSample{&sample{featureValues}, weight}
+}
+
+/*
+WithWeightFeature takes a sample and a continuous feature and returns a
+WeightedSample with the same values as the sample and the value for the
+feature as weight. Samples with an undefined value for the feature weigh 1.
+An error is returned if the value for the feature cannot be retrieved, is
+not a number or is negative.
+*/
+func WithWeightFeature(s Sample, f *feature.ContinuousFeature) (WeightedSample, error) {
+	v, err := s.ValueFor(f)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return &weightedSample{s, 1.0}, nil
+	}
+	w, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected float64 value for weight feature %s, got %T", ErrInvalidSample, f.Name(), v)
+	}
+	if w < 0 {
+		return nil, fmt.Errorf("%w: negative weight %f", ErrInvalidSample, w)
+	}
+	return &weightedSample{s, w}, nil
+}
+
+/*
+WithWeight takes a sample and a weight and returns a WeightedSample with the
+same values as the sample and the given weight, which replaces any weight the
+sample may already have.
+*/
+func WithWeight(s Sample, weight float64) WeightedSample {
+	if ws, ok := s.(*weightedSample); ok {
+		s = ws.Sample
+	}
+	return &weightedSample{s, weight}
+}
+
+func (ws *weightedSample) Weight() float64 {
+	return ws.weight
+}
@@ -0,0 +1,84 @@
+package set
+
+import "math/rand"
+
+/*
+Limit takes a number of samples n and a function to call with the samples of
+a stream, such as the lambdas taken by the ReadSetBySample functions of the
+csv and jsonl packages, and returns a function that calls it with the first
+n samples of the stream only, stopping the iteration after them.
+*/
+func Limit(n int, lambda func(int, Sample) (bool, error)) func(int, Sample) (bool, error) {
+	count := 0
+	return func(i int, s Sample) (bool, error) {
+		if count >= n {
+			return false, nil
+		}
+		count++
+		ok, err := lambda(i, s)
+		if err != nil || !ok {
+			return ok, err
+		}
+		return count < n, nil
+	}
+}
+
+/*
+Bernoulli takes a probability p, a source of random numbers and a function
+to call with the samples of a stream and returns a function that calls it
+with every sample of the stream with probability p, independently of the
+rest, so that a fraction p of the samples is taken on average.
+*/
+func Bernoulli(p float64, r *rand.Rand, lambda func(int, Sample) (bool, error)) func(int, Sample) (bool, error) {
+	return func(i int, s Sample) (bool, error) {
+		if r.Float64() >= p {
+			return true, nil
+		}
+		return lambda(i, s)
+	}
+}
+
+/*
+Reservoir takes a uniform random sample of a fixed number of samples from a
+stream of samples of unknown length, keeping no more than that number of
+samples in memory.
+*/
+type Reservoir struct {
+	size    int
+	seen    int
+	samples []Sample
+	r       *rand.Rand
+}
+
+/*
+NewReservoir takes a number of samples and a source of random numbers and
+returns an empty Reservoir that takes a sample of that size.
+*/
+func NewReservoir(size int, r *rand.Rand) *Reservoir {
+	return &Reservoir{size: size, r: r}
+}
+
+/*
+Add takes the index of a sample in a stream and the sample and considers it
+for the reservoir. It always returns true and no error, so that it can be
+given as the function to call with the samples of a stream.
+*/
+func (rs *Reservoir) Add(i int, s Sample) (bool, error) {
+	rs.seen++
+	if len(rs.samples) < rs.size {
+		rs.samples = append(rs.samples, s)
+		return true, nil
+	}
+	if j := rs.r.Intn(rs.seen); j < rs.size {
+		rs.samples[j] = s
+	}
+	return true, nil
+}
+
+/*
+Samples returns the samples in the reservoir, which are all the samples
+added to it if they were no more than its size.
+*/
+func (rs *Reservoir) Samples() []Sample {
+	return rs.samples
+}
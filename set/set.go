@@ -26,14 +26,22 @@ Its SubsetWith method takes a feature.Criterion and returns a subset that only
 contains samples that satisfy it.
 
 Its Samples method returns the samples it contains
+
+Its Count and CountFeatureValues methods return the number of samples in the
+set and the number of samples with every value for a feature, whereas its
+Weight and FeatureValueWeights methods return the same figures adding up the
+weights of the samples (see SampleWeight) instead. Entropy is computed with
+the weights of the samples.
 */
 type Set interface {
 	Entropy(context.Context, feature.Feature) (float64, error)
 	SubsetWith(context.Context, feature.Criterion) (Set, error)
 	FeatureValues(context.Context, feature.Feature) ([]interface{}, error)
 	CountFeatureValues(context.Context, feature.Feature) (map[string]int, error)
+	FeatureValueWeights(context.Context, feature.Feature) (map[string]float64, error)
 	Samples(context.Context) ([]Sample, error)
 	Count(context.Context) (int, error)
+	Weight(context.Context) (float64, error)
 }
 
 type memoryIntensiveSubsettingSet struct {
@@ -101,6 +109,23 @@ func (s *cpuIntensiveSubsettingSet) Count(ctx context.Context) (int, error) {
 	return length, nil
 }
 
+func (s *memoryIntensiveSubsettingSet) Weight(ctx context.Context) (float64, error) {
+	var result float64
+	for _, sample := range s.samples {
+		result += SampleWeight(sample)
+	}
+	return result, nil
+}
+
+func (s *cpuIntensiveSubsettingSet) Weight(ctx context.Context) (float64, error) {
+	var result float64
+	err := s.iterateOnSet(func(sample Sample) (bool, error) {
+		result += SampleWeight(sample)
+		return true, nil
+	})
+	return result, err
+}
+
 func (s *memoryIntensiveSubsettingSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
 	if s.entropy != nil {
 		return *s.entropy, nil
@@ -118,14 +143,12 @@ func (s *memoryIntensiveSubsettingSet) Entropy(ctx context.Context, f feature.Fe
 			if !ok {
 				vString = fmt.Sprintf("%v", v)
 			}
-			count += 1.0
-			featureValueCounts[vString] += 1.0
+			w := SampleWeight(sample)
+			count += w
+			featureValueCounts[vString] += w
 		}
 	}
-	for _, v := range featureValueCounts {
-		probValue := v / count
-		result -= probValue * math.Log(probValue)
-	}
+	result = weightsEntropy(featureValueCounts, count)
 	s.entropy = &result
 	return result, nil
 }
@@ -147,18 +170,16 @@ func (s *cpuIntensiveSubsettingSet) Entropy(ctx context.Context, f feature.Featu
 			if !ok {
 				vString = fmt.Sprintf("%v", v)
 			}
-			count += 1.0
-			featureValueCounts[vString] += 1.0
+			w := SampleWeight(sample)
+			count += w
+			featureValueCounts[vString] += w
 		}
 		return true, nil
 	})
 	if err != nil {
 		return result, err
 	}
-	for _, v := range featureValueCounts {
-		probValue := v / count
-		result -= probValue * math.Log(probValue)
-	}
+	result = weightsEntropy(featureValueCounts, count)
 	s.entropy = &result
 	return result, nil
 }
@@ -266,6 +287,52 @@ func (s *cpuIntensiveSubsettingSet) CountFeatureValues(ctx context.Context, f fe
 	return result, nil
 }
 
+func (s *memoryIntensiveSubsettingSet) FeatureValueWeights(ctx context.Context, f feature.Feature) (map[string]float64, error) {
+	result := make(map[string]float64)
+	for _, sample := range s.samples {
+		v, err := sample.ValueFor(f)
+		if err != nil {
+			return nil, err
+		}
+		vString := fmt.Sprintf("%v", v)
+		result[vString] += SampleWeight(sample)
+	}
+	return result, nil
+}
+
+func (s *cpuIntensiveSubsettingSet) FeatureValueWeights(ctx context.Context, f feature.Feature) (map[string]float64, error) {
+	result := make(map[string]float64)
+	err := s.iterateOnSet(func(sample Sample) (bool, error) {
+		v, err := sample.ValueFor(f)
+		if err != nil {
+			return false, err
+		}
+		vString := fmt.Sprintf("%v", v)
+		result[vString] += SampleWeight(sample)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/*
+weightsEntropy takes a map of values to the weight of the samples with that
+value and the total weight and returns the entropy of the distribution.
+*/
+func weightsEntropy(weights map[string]float64, total float64) float64 {
+	var result float64
+	for _, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		probValue := w / total
+		result -= probValue * math.Log(probValue)
+	}
+	return result
+}
+
 func (s *cpuIntensiveSubsettingSet) iterateOnSet(lambda func(Sample) (bool, error)) error {
 	for _, sample := range s.samples {
 		skip := false
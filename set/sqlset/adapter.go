@@ -1,6 +1,12 @@
 package sqlset
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+var tablePrefixRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
 
 /*
 Adapter is an interface providing the methods
@@ -15,11 +21,18 @@ samples of the working sets.
 
 CreateSampleTable should create a table for the samples,
 using foreign keys to the discrete value table for discrete
-features and a suitable float64 representation for continuous
-ones. It should also generate an id column.
+features, a suitable float64 representation for continuous
+ones and a boolean column, or the closest the database offers,
+for boolean ones. It should also generate an id column.
+
+CreateIndexes should create an index on the samples table for each
+of the given columns, if it does not exist yet, so that queries
+filtering samples on them do not need to scan the whole table.
 
 AddDiscreteValues should add to the discrete value table the
 given discrete values, and return an error if any cannot be added.
+Values already on the table should be ignored, so that sets can be
+created again on the same database.
 
 ListDiscreteValues should return a map of integer to string that
 relates numeric ids of the discrete values to their string values,
@@ -27,15 +40,19 @@ or an error.
 
 AddSamples should add a sample to the samples table for each
 rawSample received. A rawSample here is a map of column name to an
-interface containing the numeric id for a discrete feature value
-or a float64 for a continuous feature value. Samples should be
-added considering all discrete and continuous feature columns only.
+interface containing the numeric id for a discrete feature value,
+a float64 for a continuous feature value or a bool for a boolean
+feature value. Samples should be added considering all discrete,
+continuous and boolean feature columns only.
 NULL values should be used for column values not available in the
 rawSample. The number of samples added or an error must be returned.
+If a chunk of the samples cannot be inserted, the error should be a
+*PartialWriteError describing it.
 
 ListSamples should provide a slice of rawSamples as described above
 satisfying the given feature criteria and specifying the values for
-the given discrete and continuous feature columns, or an error.
+the given discrete, continuous and boolean feature columns, or an
+error.
 
 IterateOnSamples is similar to ListSamples, but takes an additional
 lambda to iterate on the samples rather than returned them all. This
@@ -54,10 +71,10 @@ a slice of feature criteria and should return an slice with the numeric
 IDs for the different values for the given feature column name on
 samples satisfying the given criteria, or an error.
 
-ListSampleContinuousFeatureValues takes a continuous feature column name
-and a slice of feature criteria and should return an slice with the
-different values for the given feature column name on samples satisfying
-the given criteria, or an error.
+ListSampleContinuousFeatureValues and ListSampleBooleanFeatureValues take
+a continuous or boolean feature column name and a slice of feature criteria
+and should return an slice with the different values for the given feature
+column name on samples satisfying the given criteria, or an error.
 
 CountSampleDiscreteFeatureValues takes a discrete feature column name
 and a slice of feature criteria and should return a map relating the
@@ -66,28 +83,87 @@ samples in the table satisfying the given criteria to the number of
 times they appear among the samples satisfying the given criteria or
 an error.
 
-CountSampleContinuousFeatureValues takes a continuous feature column
-name and a slice of feature criteria and should return a map relating
-the continuous values for the given column name on samples in the
-table satisfying the given criteria to the number of times they
-appear among the samples satisfying the given criteria or an error.
+CountSampleContinuousFeatureValues and CountSampleBooleanFeatureValues take
+a continuous or boolean feature column name and a slice of feature criteria
+and should return a map relating the values for the given column name on
+samples in the table satisfying the given criteria to the number of times
+they appear among the samples satisfying the given criteria or an error.
+
+SumSampleWeights takes a weight column name and a slice of feature
+criteria and should return the sum of the values for the weight column
+on samples satisfying the given criteria, taking NULL values as 1, or
+an error.
+
+SumSampleDiscreteFeatureValueWeights, SumSampleContinuousFeatureValueWeights
+and SumSampleBooleanFeatureValueWeights are similar to
+CountSampleDiscreteFeatureValues, CountSampleContinuousFeatureValues and
+CountSampleBooleanFeatureValues, but take an additional weight column
+name after the feature column name and should relate every value to
+the sum of the values for the weight column on the samples with it,
+taking NULL weights as 1, instead of the number of samples with it.
+
+SumSampleContinuousFeatureValueLabelWeights takes a continuous or discrete
+feature column name, a label column name, a weight column name and a slice
+of feature criteria and should relate every non-NULL value of the feature
+column on samples satisfying the criteria to a LabelWeights with the sum
+of the weights of the samples with it, in total and for every non-NULL
+value of the label column, with a single query. Feature and label values
+should be returned as float64 for both discrete and continuous columns. An
+empty weight column name means every sample weighs 1.
 */
 type Adapter interface {
 	ColumnName(string) (string, error)
 
 	CreateDiscreteValuesTable(ctx context.Context) error
-	CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns []string) error
+	CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) error
+	CreateIndexes(ctx context.Context, columns []string) error
 
 	AddDiscreteValues(context.Context, []string) (int, error)
 	ListDiscreteValues(ctx context.Context) (map[int]string, error)
 
-	AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns []string) (int, error)
-	ListSamples(ctx context.Context, criteria []*FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns []string) ([]map[string]interface{}, error)
-	IterateOnSamples(ctx context.Context, criteria []*FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error
+	AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) (int, error)
+	ListSamples(ctx context.Context, criteria []*FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) ([]map[string]interface{}, error)
+	IterateOnSamples(ctx context.Context, criteria []*FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error
 	CountSamples(context.Context, []*FeatureCriterion) (int, error)
 
 	ListSampleDiscreteFeatureValues(context.Context, string, []*FeatureCriterion) ([]int, error)
 	ListSampleContinuousFeatureValues(context.Context, string, []*FeatureCriterion) ([]float64, error)
 	CountSampleDiscreteFeatureValues(context.Context, string, []*FeatureCriterion) (map[int]int, error)
 	CountSampleContinuousFeatureValues(context.Context, string, []*FeatureCriterion) (map[float64]int, error)
+	ListSampleBooleanFeatureValues(context.Context, string, []*FeatureCriterion) ([]bool, error)
+	CountSampleBooleanFeatureValues(context.Context, string, []*FeatureCriterion) (map[bool]int, error)
+
+	SumSampleWeights(context.Context, string, []*FeatureCriterion) (float64, error)
+	SumSampleDiscreteFeatureValueWeights(context.Context, string, string, []*FeatureCriterion) (map[int]float64, error)
+	SumSampleContinuousFeatureValueWeights(context.Context, string, string, []*FeatureCriterion) (map[float64]float64, error)
+	SumSampleBooleanFeatureValueWeights(context.Context, string, string, []*FeatureCriterion) (map[bool]float64, error)
+	SumSampleContinuousFeatureValueLabelWeights(ctx context.Context, fc, lc, wc string, criteria []*FeatureCriterion) (map[float64]*LabelWeights, error)
+}
+
+/*
+LabelWeights holds the sum of the weights of some samples, in total and for
+every value of a label column they hold.
+*/
+type LabelWeights struct {
+	Weight float64
+	Labels map[float64]float64
+}
+
+/*
+TableNames takes a table prefix and returns the names of the samples and
+discrete values tables for it, which adapters should use for the set, or an
+error if the prefix is not valid. An empty prefix yields the samples and
+discreteValues names, and any other prefix is prepended to them with an
+underscore. Prefixes may only contain lowercase letters, digits and
+underscores, and cannot start with a digit, so that table names do not need
+to be quoted on any database.
+*/
+func TableNames(prefix string) (string, string, error) {
+	if prefix == "" {
+		return "samples", "discreteValues", nil
+	}
+	if !tablePrefixRegexp.MatchString(prefix) {
+		return "", "", fmt.Errorf("invalid table prefix %q: it may only contain lowercase letters, digits and underscores and cannot start with a digit", prefix)
+	}
+	return prefix + "_samples", prefix + "_discreteValues", nil
 }
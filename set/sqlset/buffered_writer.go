@@ -0,0 +1,163 @@
+package sqlset
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+DefaultBatchSize is the number of samples a BufferedWriter buffers before
+writing them on its set when no other batch size is given.
+*/
+const DefaultBatchSize = 1000
+
+/*
+BufferedWriter buffers the samples written on it and writes them on a Set
+in batches, so that they are inserted with as few statements as possible
+instead of one statement per call to Write. Batches are written when the
+batch size is reached, when the flush interval, if any, has passed since the
+first sample of the batch was buffered, and when the writer is flushed.
+
+Errors writing a batch in the background because of the flush interval are
+returned by the next call to Write or Flush. A BufferedWriter is safe for
+concurrent use, and full batches are written without holding it, so that
+concurrent calls to Write insert their batches concurrently.
+*/
+type BufferedWriter struct {
+	set           Set
+	ctx           context.Context
+	batchSize     int
+	flushInterval time.Duration
+	lock          sync.Mutex
+	written       *sync.Cond
+	writing       int
+	pending       []set.Sample
+	timer         *time.Timer
+	err           error
+}
+
+/*
+NewBufferedWriter takes a context, a Set, a batch size and a flush interval
+and returns a BufferedWriter that writes batches of samples on the set with
+the context. If the batch size is not positive DefaultBatchSize is used. If
+the flush interval is not positive buffered samples are only written when
+the batch is full or the writer is flushed.
+*/
+func NewBufferedWriter(ctx context.Context, s Set, batchSize int, flushInterval time.Duration) *BufferedWriter {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	bw := &BufferedWriter{
+		set:           s,
+		ctx:           ctx,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+	bw.written = sync.NewCond(&bw.lock)
+	return bw
+}
+
+/*
+Write takes a context and a slice of samples and buffers the samples,
+writing the buffered ones on the set if the batch size is reached. It
+returns the number of samples accepted, or an error if writing a batch,
+this one or a previous one written in the background, failed.
+*/
+func (bw *BufferedWriter) Write(ctx context.Context, samples []set.Sample) (int, error) {
+	bw.lock.Lock()
+	if bw.err != nil {
+		defer bw.lock.Unlock()
+		return 0, bw.err
+	}
+	if len(samples) == 0 {
+		bw.lock.Unlock()
+		return 0, nil
+	}
+	if len(bw.pending) == 0 && bw.flushInterval > 0 {
+		bw.timer = time.AfterFunc(bw.flushInterval, bw.flushOnTimer)
+	}
+	bw.pending = append(bw.pending, samples...)
+	if len(bw.pending) < bw.batchSize {
+		bw.lock.Unlock()
+		return len(samples), nil
+	}
+	batch := bw.takePending()
+	bw.lock.Unlock()
+	err := bw.write(ctx, batch)
+	if err != nil {
+		return 0, err
+	}
+	return len(samples), nil
+}
+
+/*
+Flush writes the buffered samples on the set, waits for the batches being
+written to be done and returns an error if they cannot be written or a
+previous batch failed.
+*/
+func (bw *BufferedWriter) Flush() error {
+	bw.lock.Lock()
+	if bw.err != nil {
+		defer bw.lock.Unlock()
+		return bw.err
+	}
+	batch := bw.takePending()
+	bw.lock.Unlock()
+	bw.write(bw.ctx, batch)
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+	for bw.writing > 0 {
+		bw.written.Wait()
+	}
+	return bw.err
+}
+
+func (bw *BufferedWriter) flushOnTimer() {
+	bw.lock.Lock()
+	if bw.err != nil {
+		bw.lock.Unlock()
+		return
+	}
+	batch := bw.takePending()
+	bw.lock.Unlock()
+	bw.write(bw.ctx, batch)
+}
+
+/*
+takePending stops the flush timer and returns the buffered samples, leaving
+none buffered, and counts them as a batch being written. It must be called
+with the lock of the writer held, and the batch must then be given to write.
+*/
+func (bw *BufferedWriter) takePending() []set.Sample {
+	if bw.timer != nil {
+		bw.timer.Stop()
+		bw.timer = nil
+	}
+	batch := bw.pending
+	bw.pending = nil
+	bw.writing++
+	return batch
+}
+
+/*
+write takes a context and a batch taken with takePending and writes it on
+the set with the context, without holding the lock of the writer, keeping
+the error, if any, to return it on following calls.
+*/
+func (bw *BufferedWriter) write(ctx context.Context, batch []set.Sample) error {
+	var err error
+	if len(batch) > 0 {
+		_, err = bw.set.Write(ctx, batch)
+	}
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+	if err != nil && bw.err == nil {
+		bw.err = err
+	}
+	bw.writing--
+	bw.written.Broadcast()
+	return err
+}
@@ -0,0 +1,571 @@
+/*
+Package cqladapter provides an implementation of the
+Adapter interface in the sqlset package that works
+over a keyspace of a Cassandra or ScyllaDB cluster.
+
+Wide-column stores cannot group, aggregate or filter on
+arbitrary columns like SQL databases, so the adapter
+streams the samples it needs page by page and computes
+the aggregates on the client. Criteria on the partition
+key of the samples table are pushed down to the cluster
+so that only the partitions holding the samples are
+read, and comparisons on other columns are pushed down
+with ALLOW FILTERING. The rest of the criteria are
+applied on the client.
+*/
+package cqladapter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/pbanos/botanic/set/sqlset"
+)
+
+const (
+	discreteValueTableCreateStmt = `CREATE TABLE IF NOT EXISTS %s (
+		id bigint PRIMARY KEY,
+		value text)`
+
+	// MaxSampleInsertionsPerBatch is the maximum number
+	// of samples that are inserted with a single batch
+	// with the AddSamples method of the adapter.
+	// Trying to add more will result in executing more batches
+	MaxSampleInsertionsPerBatch = 10
+
+	// DefaultPageSize is the number of rows fetched from the
+	// cluster at a time when reading samples if the options of
+	// the adapter do not set any.
+	DefaultPageSize = 5000
+
+	// undefinedPartitionKey is the value stored on the partition
+	// key column for samples with an undefined value for its
+	// feature, as columns of the partition key cannot be NULL.
+	// Discrete value IDs are never negative.
+	undefinedPartitionKey = -1
+)
+
+var nonIdentifierRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type adapter struct {
+	session             *gocql.Session
+	samplesTable        string
+	discreteValuesTable string
+	partitionKey        string
+	pageSize            int
+}
+
+/*
+Options holds the settings of an adapter.
+
+TablePrefix, if not empty, is prepended with an underscore to the names of
+the samples and discreteValues tables, so that several sets can be kept on
+the same keyspace. It may only contain lowercase letters, digits and
+underscores, and cannot start with a digit.
+
+PartitionKey, if not empty, is the column of a discrete feature that is the
+partition key of the samples table, so that the samples with the same value
+for the feature are kept on the same partition. Samples read with a
+criterion on the feature, as those of the subtrees of a node branched out on
+it, are then read from their partitions alone. Without it, the samples table
+is partitioned by sample. It must be set to the same column every time the
+set is opened.
+
+PageSize, if greater than 0, is the number of rows fetched from the cluster
+at a time when reading samples. It defaults to DefaultPageSize.
+*/
+type Options struct {
+	TablePrefix  string
+	PartitionKey string
+	PageSize     int
+}
+
+/*
+New takes a Cassandra URL in the form
+cassandra://[USER[:PASSWORD]@]HOST[:PORT][,HOST[:PORT]...]/KEYSPACE and
+returns an Adapter that works on the keyspace or an error if it fails to
+connect to the cluster.
+*/
+func New(url string) (sqlset.Adapter, error) {
+	return NewWithOptions(url, nil)
+}
+
+/*
+NewWithOptions takes a Cassandra URL as described for New and options and
+returns an Adapter that works on the keyspace with the options, or an error
+if it fails to connect to the cluster. Nil options are the same as the zero
+Options. The keyspace must exist already.
+*/
+func NewWithOptions(url string, opts *Options) (sqlset.Adapter, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	samplesTable, discreteValuesTable, err := sqlset.TableNames(opts.TablePrefix)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := clusterConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &adapter{
+		session:             session,
+		samplesTable:        samplesTable,
+		discreteValuesTable: discreteValuesTable,
+		partitionKey:        opts.PartitionKey,
+		pageSize:            pageSize,
+	}, nil
+}
+
+/*
+clusterConfig takes a Cassandra URL as described for New and returns the
+configuration of a token-aware session on its cluster and keyspace, or an
+error if the URL is not valid.
+*/
+func clusterConfig(location string) (*gocql.ClusterConfig, error) {
+	if !strings.HasPrefix(location, "cassandra://") {
+		return nil, fmt.Errorf("invalid Cassandra URL %q: it must start with cassandra://", location)
+	}
+	rest := strings.TrimPrefix(location, "cassandra://")
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid Cassandra URL %q: no keyspace given", location)
+	}
+	hosts, keyspace := rest[:i], rest[i+1:]
+	if strings.Contains(keyspace, "?") {
+		return nil, fmt.Errorf("invalid Cassandra URL %q: parameters are not supported", location)
+	}
+	var user, password string
+	if j := strings.LastIndex(hosts, "@"); j >= 0 {
+		user, hosts = hosts[:j], hosts[j+1:]
+		if k := strings.Index(user, ":"); k >= 0 {
+			user, password = user[:k], user[k+1:]
+		}
+		var err error
+		user, err = url.PathUnescape(user)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Cassandra URL %q: %v", location, err)
+		}
+		password, err = url.PathUnescape(password)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Cassandra URL %q: %v", location, err)
+		}
+	}
+	if hosts == "" || keyspace == "" {
+		return nil, fmt.Errorf("invalid Cassandra URL %q: hosts and keyspace are required", location)
+	}
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Keyspace = keyspace
+	cluster.Consistency = gocql.Quorum
+	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+	if user != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: user, Password: password}
+	}
+	return cluster, nil
+}
+
+func (a *adapter) ColumnName(featureName string) (string, error) {
+	if featureName == "id" {
+		return "", fmt.Errorf(`'%s' is reserved and cannot be used as feature name`, featureName)
+	}
+	if strings.ContainsAny(featureName, `"`) {
+		return "", fmt.Errorf(`feature name '%s' contains invalid character '"'`, featureName)
+	}
+	return featureName, nil
+}
+
+func (a *adapter) CreateDiscreteValuesTable(ctx context.Context) error {
+	err := a.session.Query(fmt.Sprintf(discreteValueTableCreateStmt, a.discreteValuesTable)).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("creating discreteValues table: %v", err)
+	}
+	return nil
+}
+
+func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) error {
+	var createStmtBuf bytes.Buffer
+	createStmtBuf.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id timeuuid", a.samplesTable))
+	partitionKeyFound := false
+	for _, dfc := range discreteFeatureColumns {
+		createStmtBuf.WriteString(fmt.Sprintf(`, "%s" bigint`, dfc))
+		if dfc == a.partitionKey {
+			partitionKeyFound = true
+		}
+	}
+	for _, cfc := range continuousFeatureColumns {
+		createStmtBuf.WriteString(fmt.Sprintf(`, "%s" double`, cfc))
+	}
+	for _, bfc := range booleanFeatureColumns {
+		createStmtBuf.WriteString(fmt.Sprintf(`, "%s" boolean`, bfc))
+	}
+	if a.partitionKey == "" {
+		createStmtBuf.WriteString(", PRIMARY KEY (id))")
+	} else {
+		if !partitionKeyFound {
+			return fmt.Errorf("partition key column %s is not the column of a discrete feature", a.partitionKey)
+		}
+		createStmtBuf.WriteString(fmt.Sprintf(`, PRIMARY KEY (("%s"), id))`, a.partitionKey))
+	}
+	err := a.session.Query(createStmtBuf.String()).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("creating samples table: %v", err)
+	}
+	return nil
+}
+
+/*
+CreateIndexes creates a secondary index on the samples table for every given
+column but the partition key, which cannot be indexed. Comparisons with
+values on indexed columns are answered by the cluster from the index instead
+of filtering every row of the table.
+*/
+func (a *adapter) CreateIndexes(ctx context.Context, columns []string) error {
+	for _, c := range columns {
+		if c == a.partitionKey {
+			continue
+		}
+		index := nonIdentifierRegexp.ReplaceAllString(fmt.Sprintf("%s_%s_idx", a.samplesTable, c), "_")
+		err := a.session.Query(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s ("%s")`, index, a.samplesTable, c)).WithContext(ctx).Exec()
+		if err != nil {
+			return fmt.Errorf("creating index on column %s: %v", c, err)
+		}
+	}
+	return nil
+}
+
+/*
+AddDiscreteValues adds every value to the discrete values table with an ID
+derived from a hash of the value, as the cluster cannot generate sequential
+IDs, using a lightweight transaction so that values already on the table
+are ignored. An error is returned if two different values have the same ID.
+*/
+func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int, error) {
+	insertStmt := fmt.Sprintf(`INSERT INTO %s (id, value) VALUES (?, ?) IF NOT EXISTS`, a.discreteValuesTable)
+	for i, v := range values {
+		id := discreteValueID(v)
+		var existingID int64
+		var existingValue string
+		applied, err := a.session.Query(insertStmt, id, v).WithContext(ctx).ScanCAS(&existingID, &existingValue)
+		if err != nil {
+			return i, fmt.Errorf("inserting value %q: %v", v, err)
+		}
+		if !applied && existingValue != v {
+			return i, fmt.Errorf("inserting value %q: its ID %d is already taken by value %q", v, id, existingValue)
+		}
+	}
+	return len(values), nil
+}
+
+/*
+discreteValueID takes a discrete value and returns the ID for it on the
+discrete values table, a non-negative 63-bit FNV-1a hash of the value.
+*/
+func discreteValueID(value string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return int64(h.Sum64() & math.MaxInt64)
+}
+
+func (a *adapter) ListDiscreteValues(ctx context.Context) (map[int]string, error) {
+	iter := a.session.Query(`SELECT id, value FROM ` + a.discreteValuesTable).WithContext(ctx).PageSize(a.pageSize).Iter()
+	result := make(map[int]string)
+	var id int64
+	var value string
+	for iter.Scan(&id, &value) {
+		result[int(id)] = value
+	}
+	err := iter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/*
+AddSamples inserts the samples in unlogged batches of up to
+MaxSampleInsertionsPerBatch samples. Columns with no value on a sample are
+left out of its insertion rather than set to NULL, so that no tombstones are
+written for them.
+*/
+func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) (int, error) {
+	columns := make([]string, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns)+len(booleanFeatureColumns))
+	columns = append(columns, discreteFeatureColumns...)
+	columns = append(columns, continuousFeatureColumns...)
+	columns = append(columns, booleanFeatureColumns...)
+	for start := 0; start < len(rawSamples); start += MaxSampleInsertionsPerBatch {
+		end := start + MaxSampleInsertionsPerBatch
+		if end > len(rawSamples) {
+			end = len(rawSamples)
+		}
+		batch := a.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		for _, rs := range rawSamples[start:end] {
+			insertStmt, values := a.insertStatement(rs, columns)
+			batch.Query(insertStmt, values...)
+		}
+		err := a.session.ExecuteBatch(batch)
+		if err != nil {
+			return start, &sqlset.PartialWriteError{
+				Written: start,
+				Offset:  start,
+				Chunk:   rawSamples[start:end],
+				Err:     fmt.Errorf("inserting %d samples: %v", end-start, err),
+			}
+		}
+	}
+	return len(rawSamples), nil
+}
+
+/*
+insertStatement takes a raw sample and the feature columns of the samples
+table and returns the statement to insert the sample and its values.
+*/
+func (a *adapter) insertStatement(rawSample map[string]interface{}, columns []string) (string, []interface{}) {
+	var insertStmtBuffer bytes.Buffer
+	values := []interface{}{gocql.TimeUUID()}
+	insertStmtBuffer.WriteString(fmt.Sprintf("INSERT INTO %s (id", a.samplesTable))
+	for _, c := range columns {
+		v, ok := rawSample[c]
+		if !ok || v == nil {
+			if c != a.partitionKey {
+				continue
+			}
+			v = undefinedPartitionKey
+		}
+		insertStmtBuffer.WriteString(fmt.Sprintf(`, "%s"`, c))
+		values = append(values, v)
+	}
+	insertStmtBuffer.WriteString(") VALUES (?")
+	insertStmtBuffer.WriteString(strings.Repeat(", ?", len(values)-1))
+	insertStmtBuffer.WriteString(")")
+	return insertStmtBuffer.String(), values
+}
+
+func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) ([]map[string]interface{}, error) {
+	var result []map[string]interface{}
+	err := a.IterateOnSamples(ctx, criteria, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns, func(_ int, rawSample map[string]interface{}) (bool, error) {
+		result = append(result, rawSample)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error {
+	columns := make([]string, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns)+len(booleanFeatureColumns))
+	columns = append(columns, discreteFeatureColumns...)
+	columns = append(columns, continuousFeatureColumns...)
+	columns = append(columns, booleanFeatureColumns...)
+	var j int
+	return a.iterate(ctx, criteria, columns, func(values map[string]interface{}) (bool, error) {
+		rawSample := make(map[string]interface{}, len(columns))
+		for _, c := range columns {
+			if v, ok := values[c]; ok {
+				rawSample[c] = v
+			}
+		}
+		ok, err := lambda(j, rawSample)
+		j++
+		return ok, err
+	})
+}
+
+func (a *adapter) CountSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion) (int, error) {
+	var count int
+	err := a.iterate(ctx, criteria, nil, func(map[string]interface{}) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+func (a *adapter) ListSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]int, error) {
+	counts, err := a.CountSampleDiscreteFeatureValues(ctx, fc, criteria)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]int, 0, len(counts))
+	for v := range counts {
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func (a *adapter) ListSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]float64, error) {
+	counts, err := a.CountSampleContinuousFeatureValues(ctx, fc, criteria)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]float64, 0, len(counts))
+	for v := range counts {
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func (a *adapter) ListSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]bool, error) {
+	counts, err := a.CountSampleBooleanFeatureValues(ctx, fc, criteria)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bool, 0, len(counts))
+	for v := range counts {
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func (a *adapter) CountSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[int]int, error) {
+	result := make(map[int]int)
+	err := a.iterate(ctx, criteria, []string{fc}, func(values map[string]interface{}) (bool, error) {
+		if v, ok := values[fc].(int); ok {
+			result[v]++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) CountSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[float64]int, error) {
+	result := make(map[float64]int)
+	err := a.iterate(ctx, criteria, []string{fc}, func(values map[string]interface{}) (bool, error) {
+		if v, ok := values[fc].(float64); ok {
+			result[v]++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) CountSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[bool]int, error) {
+	result := make(map[bool]int)
+	err := a.iterate(ctx, criteria, []string{fc}, func(values map[string]interface{}) (bool, error) {
+		if v, ok := values[fc].(bool); ok {
+			result[v]++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) SumSampleWeights(ctx context.Context, wc string, criteria []*sqlset.FeatureCriterion) (float64, error) {
+	var result float64
+	err := a.iterate(ctx, criteria, []string{wc}, func(values map[string]interface{}) (bool, error) {
+		result += weight(values, wc)
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+func (a *adapter) SumSampleDiscreteFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[int]float64, error) {
+	result := make(map[int]float64)
+	err := a.iterate(ctx, criteria, []string{fc, wc}, func(values map[string]interface{}) (bool, error) {
+		if v, ok := values[fc].(int); ok {
+			result[v] += weight(values, wc)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) SumSampleContinuousFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[float64]float64, error) {
+	result := make(map[float64]float64)
+	err := a.iterate(ctx, criteria, []string{fc, wc}, func(values map[string]interface{}) (bool, error) {
+		if v, ok := values[fc].(float64); ok {
+			result[v] += weight(values, wc)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) SumSampleBooleanFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[bool]float64, error) {
+	result := make(map[bool]float64)
+	err := a.iterate(ctx, criteria, []string{fc, wc}, func(values map[string]interface{}) (bool, error) {
+		if v, ok := values[fc].(bool); ok {
+			result[v] += weight(values, wc)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *adapter) SumSampleContinuousFeatureValueLabelWeights(ctx context.Context, fc, lc, wc string, criteria []*sqlset.FeatureCriterion) (map[float64]*sqlset.LabelWeights, error) {
+	columns := []string{fc, lc}
+	if wc != "" {
+		columns = append(columns, wc)
+	}
+	result := make(map[float64]*sqlset.LabelWeights)
+	err := a.iterate(ctx, criteria, columns, func(values map[string]interface{}) (bool, error) {
+		v, ok := toFloat64(values[fc])
+		if !ok {
+			return true, nil
+		}
+		lw, ok := result[v]
+		if !ok {
+			lw = &sqlset.LabelWeights{Labels: make(map[float64]float64)}
+			result[v] = lw
+		}
+		w := 1.0
+		if wc != "" {
+			w = weight(values, wc)
+		}
+		lw.Weight += w
+		if label, ok := toFloat64(values[lc]); ok {
+			lw.Labels[label] += w
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/*
+weight takes the values of a sample and a weight column and returns the
+weight of the sample, which is 1 if it has no value for the column.
+*/
+func weight(values map[string]interface{}, wc string) float64 {
+	if w, ok := values[wc].(float64); ok {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,263 @@
+package cqladapter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/pbanos/botanic/set/sqlset"
+)
+
+/*
+pushDown holds the part of some feature criteria that can be applied by the
+cluster when reading samples: the values of the partition key of the
+partitions the samples must be read from, if any criterion restricts them,
+and the conditions on other columns with their values.
+*/
+type pushDown struct {
+	partitionKeys []int64
+	conditions    []string
+	values        []interface{}
+}
+
+/*
+pushDownCriteria takes a slice of feature criteria and returns the part of
+them that can be pushed down to the cluster. Only criteria that do not match
+NULL or missing values can be pushed down, as the cluster cannot combine
+conditions with OR. A single criterion on the partition key restricts the
+partitions read, while comparisons with a value on other columns become
+conditions that require ALLOW FILTERING. Every criterion is still applied on
+the client, so pushing one down only saves reading samples that would be
+discarded.
+*/
+func (a *adapter) pushDownCriteria(criteria []*sqlset.FeatureCriterion) *pushDown {
+	pd := &pushDown{}
+	for _, c := range criteria {
+		if c.IncludeNull || c.MissingValue != nil {
+			continue
+		}
+		if c.FeatureColumn == a.partitionKey {
+			if pd.partitionKeys != nil {
+				continue
+			}
+			switch c.Operator {
+			case "=":
+				if v, ok := c.Value.(int); ok {
+					pd.partitionKeys = []int64{int64(v)}
+				}
+			case "IN":
+				dvrs, _ := c.Value.([]int)
+				pd.partitionKeys = make([]int64, 0, len(dvrs))
+				for _, dvr := range dvrs {
+					pd.partitionKeys = append(pd.partitionKeys, int64(dvr))
+				}
+			case "IS NULL":
+				pd.partitionKeys = []int64{undefinedPartitionKey}
+			}
+			continue
+		}
+		switch c.Operator {
+		case "=", "<", ">", "<=", ">=":
+			pd.conditions = append(pd.conditions, fmt.Sprintf(`"%s" %s ?`, c.FeatureColumn, c.Operator))
+			pd.values = append(pd.values, c.Value)
+		}
+	}
+	return pd
+}
+
+/*
+iterate takes a context, a slice of feature criteria, a slice of columns and
+a lambda and calls the lambda with the values of the given columns, and of
+the columns of the criteria, for every sample satisfying the criteria, until
+it returns false or an error. Values of discrete feature columns are ints,
+those of continuous feature columns are float64 and those of boolean feature
+columns are bools, and columns with no value for a sample are left out. Samples are streamed page by page from
+every partition they must be read from. It returns an error if the samples
+cannot be read or the one returned by the lambda.
+*/
+func (a *adapter) iterate(ctx context.Context, criteria []*sqlset.FeatureCriterion, columns []string, lambda func(map[string]interface{}) (bool, error)) error {
+	selected := make([]string, 0, len(columns)+len(criteria))
+	seen := make(map[string]bool)
+	for _, c := range columns {
+		if !seen[c] {
+			seen[c] = true
+			selected = append(selected, c)
+		}
+	}
+	for _, c := range criteria {
+		if !seen[c.FeatureColumn] {
+			seen[c.FeatureColumn] = true
+			selected = append(selected, c.FeatureColumn)
+		}
+	}
+	pd := a.pushDownCriteria(criteria)
+	var queryBuffer bytes.Buffer
+	queryBuffer.WriteString("SELECT id")
+	for _, c := range selected {
+		queryBuffer.WriteString(fmt.Sprintf(`, "%s"`, c))
+	}
+	queryBuffer.WriteString(" FROM " + a.samplesTable)
+	conditions := pd.conditions
+	if pd.partitionKeys != nil {
+		conditions = append([]string{fmt.Sprintf(`"%s" = ?`, a.partitionKey)}, conditions...)
+	}
+	if len(conditions) > 0 {
+		queryBuffer.WriteString(" WHERE ")
+		queryBuffer.WriteString(strings.Join(conditions, " AND "))
+	}
+	if len(pd.conditions) > 0 {
+		queryBuffer.WriteString(" ALLOW FILTERING")
+	}
+	if pd.partitionKeys == nil {
+		_, err := a.iterateQuery(ctx, queryBuffer.String(), pd.values, criteria, selected, lambda)
+		return err
+	}
+	for _, pk := range pd.partitionKeys {
+		values := append([]interface{}{pk}, pd.values...)
+		ok, err := a.iterateQuery(ctx, queryBuffer.String(), values, criteria, selected, lambda)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+iterateQuery takes a context, a query selecting the id and the given
+selected columns of samples, its values, the feature criteria the samples
+must satisfy and a lambda and calls the lambda with the values of every
+sample returned by the query that satisfies the criteria, as described for
+iterate. It returns false if the lambda stopped the iteration, and an error
+if the samples cannot be read or the one returned by the lambda.
+*/
+func (a *adapter) iterateQuery(ctx context.Context, query string, values []interface{}, criteria []*sqlset.FeatureCriterion, selected []string, lambda func(map[string]interface{}) (bool, error)) (bool, error) {
+	iter := a.session.Query(query, values...).WithContext(ctx).PageSize(a.pageSize).Iter()
+	columns := iter.Columns()
+	if len(columns) != len(selected)+1 {
+		err := iter.Close()
+		if err == nil {
+			err = fmt.Errorf("reading samples: %d columns returned instead of %d", len(columns), len(selected)+1)
+		}
+		return false, err
+	}
+	var id gocql.UUID
+	discreteValues := make([]*int64, len(selected))
+	continuousValues := make([]*float64, len(selected))
+	booleanValues := make([]*bool, len(selected))
+	dest := make([]interface{}, 0, len(selected)+1)
+	dest = append(dest, &id)
+	for i := range selected {
+		switch columns[i+1].TypeInfo.Type() {
+		case gocql.TypeBigInt:
+			dest = append(dest, &discreteValues[i])
+		case gocql.TypeBoolean:
+			dest = append(dest, &booleanValues[i])
+		default:
+			dest = append(dest, &continuousValues[i])
+		}
+	}
+	for iter.Scan(dest...) {
+		sample := make(map[string]interface{}, len(selected))
+		for i, c := range selected {
+			if discreteValues[i] != nil {
+				v := int(*discreteValues[i])
+				if c != a.partitionKey || v != undefinedPartitionKey {
+					sample[c] = v
+				}
+			} else if continuousValues[i] != nil {
+				sample[c] = *continuousValues[i]
+			} else if booleanValues[i] != nil {
+				sample[c] = *booleanValues[i]
+			}
+		}
+		if !satisfiesCriteria(sample, criteria) {
+			continue
+		}
+		ok, err := lambda(sample)
+		if err != nil {
+			iter.Close()
+			return false, err
+		}
+		if !ok {
+			return false, iter.Close()
+		}
+	}
+	return true, iter.Close()
+}
+
+/*
+satisfiesCriteria takes the values of a sample and a slice of feature
+criteria and returns whether the sample satisfies all of them, with the same
+semantics as the WHERE clauses built by SQL adapters.
+*/
+func satisfiesCriteria(sample map[string]interface{}, criteria []*sqlset.FeatureCriterion) bool {
+	for _, c := range criteria {
+		if !satisfiesCriterion(sample[c.FeatureColumn], c) {
+			return false
+		}
+	}
+	return true
+}
+
+func satisfiesCriterion(v interface{}, c *sqlset.FeatureCriterion) bool {
+	missing := v == nil || (c.MissingValue != nil && v == c.MissingValue)
+	switch c.Operator {
+	case "IS NULL":
+		return missing
+	case "IS NOT NULL":
+		return !missing
+	}
+	if missing {
+		return c.IncludeNull
+	}
+	if c.Operator == "IN" {
+		dvrs, _ := c.Value.([]int)
+		for _, dvr := range dvrs {
+			if v == dvr {
+				return true
+			}
+		}
+		return false
+	}
+	if b, ok := v.(bool); ok {
+		cb, ok := c.Value.(bool)
+		return ok && c.Operator == "=" && b == cb
+	}
+	fv, ok := toFloat64(v)
+	if !ok {
+		return false
+	}
+	cv, ok := toFloat64(c.Value)
+	if !ok {
+		return false
+	}
+	switch c.Operator {
+	case "=":
+		return fv == cv
+	case "<":
+		return fv < cv
+	case ">":
+		return fv > cv
+	case "<=":
+		return fv <= cv
+	case ">=":
+		return fv >= cv
+	}
+	return false
+}
+
+/*
+toFloat64 takes a value of a discrete or continuous feature column and
+returns it as a float64 and true, or false if it is not a value of either.
+*/
+func toFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
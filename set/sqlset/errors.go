@@ -0,0 +1,78 @@
+package sqlset
+
+import (
+	"fmt"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+PartialWriteError is the error returned by the AddSamples method of the
+adapters, and by the Write method of sets over them, when a chunk of the
+samples given cannot be inserted.
+
+Written is the number of samples that remain added to the set: the ones
+before the failed chunk, or 0 if the write was done in a transaction that
+was rolled back. Offset is the position of the first sample of the failed
+chunk among the samples given, and Chunk holds the raw samples of the chunk,
+so that callers can retry from it. Err is the error inserting the chunk.
+*/
+type PartialWriteError struct {
+	Written int
+	Offset  int
+	Chunk   []map[string]interface{}
+	Err     error
+}
+
+func (pwe *PartialWriteError) Error() string {
+	return fmt.Sprintf("inserting %d samples from sample %d, %d samples written: %v", len(pwe.Chunk), pwe.Offset, pwe.Written, pwe.Err)
+}
+
+/*
+Unwrap returns the error inserting the chunk.
+*/
+func (pwe *PartialWriteError) Unwrap() error {
+	return pwe.Err
+}
+
+/*
+ConnectionError is the error returned by adapters created with
+NewRetryingAdapter when an operation keeps failing because the connection to
+the database is lost after the allowed attempts. Its Temporary method
+returns true, so that workers can drop the task they were developing and
+retry it later instead of aborting, and it is set.ErrBackendUnavailable
+for errors.Is.
+
+Attempts is the number of times the operation was tried and Err is the error
+of the last attempt.
+*/
+type ConnectionError struct {
+	Attempts int
+	Err      error
+}
+
+func (ce *ConnectionError) Error() string {
+	return fmt.Sprintf("connection to database lost after %d attempts: %v", ce.Attempts, ce.Err)
+}
+
+/*
+Temporary returns true, as the connection to the database may be
+reestablished later.
+*/
+func (ce *ConnectionError) Temporary() bool {
+	return true
+}
+
+/*
+Is returns whether the target is set.ErrBackendUnavailable.
+*/
+func (ce *ConnectionError) Is(target error) bool {
+	return target == set.ErrBackendUnavailable
+}
+
+/*
+Unwrap returns the error of the last attempt.
+*/
+func (ce *ConnectionError) Unwrap() error {
+	return ce.Err
+}
@@ -29,7 +29,8 @@ type FeatureCriterion struct {
 		Operator is a string representing the
 		comparison against the value in the criterion
 		that is applied to samples. It must be one of
-		the following: "=", "<", ">", "<=" or ">=".
+		the following: "=", "<", ">", "<=", ">=", "IN",
+		"IS NULL" or "IS NOT NULL".
 		The semantics are the result from reading
 		the criterion as Feature Operator Value
 	*/
@@ -37,10 +38,26 @@ type FeatureCriterion struct {
 	/*
 		Value is the value against which a comparison
 		is applied to samples. It should be either an
-		integer for discrete features or a float64 for
-		continuous features.
+		integer for discrete features, a float64 for
+		continuous features or a bool for boolean
+		features, or a slice of integers for the "IN"
+		operator. It is ignored for the
+		"IS NULL" and "IS NOT NULL" operators.
 	*/
 	Value interface{}
+	/*
+		IncludeNull defines whether samples with a NULL
+		value for the feature column satisfy the criterion
+		too.
+	*/
+	IncludeNull bool
+	/*
+		MissingValue is the value that stands for a
+		missing value of the feature on the column, or
+		nil if it has none. Samples holding it are
+		treated as samples with a NULL value.
+	*/
+	MissingValue interface{}
 }
 
 /*
@@ -61,10 +78,19 @@ feature of the feature criterion, or if the given feature.Criterion is a
 feature.DiscreteCriterion and its value has no representation defined
 on the given dictionary.
 
-For a feature.Criterion that is no feature.DiscreteCriterion nor
-feature.ContinuousCriterion it returns an empty slice and no error. In
-other words, it is interpreted as an undefined feature criterion, which imposes
-no conditions on samples.
+A feature.DiscreteValuesCriterion is translated into an "IN" criterion, a
+feature.BooleanCriterion into an "=" criterion on a bool value and a
+feature.UndefinedValueCriterion into an "IS NULL" criterion. A
+feature.ContinuousCriterion over the whole real line that does not include
+undefined values is translated into an "IS NOT NULL" criterion. The criteria
+obtained from a feature.Criterion that includes undefined values have
+IncludeNull set to true.
+
+For a feature.Criterion that is no feature.DiscreteCriterion,
+feature.DiscreteValuesCriterion, feature.ContinuousCriterion,
+feature.BooleanCriterion nor feature.UndefinedValueCriterion it returns
+an empty slice and no error. In other words, it is interpreted as an
+undefined feature criterion, which imposes no conditions on samples.
 */
 func NewFeatureCriteria(fc feature.Criterion, cnf ColumnNameFunc, dictionary map[string]int) ([]*FeatureCriterion, error) {
 	columnName, err := cnf(fc.Feature().Name())
@@ -72,21 +98,40 @@ func NewFeatureCriteria(fc feature.Criterion, cnf ColumnNameFunc, dictionary map
 		return nil, fmt.Errorf("cannot obtain column name for feature '%s': %v", fc.Feature().Name(), err)
 	}
 	result := []*FeatureCriterion{}
+	includeNull := feature.IncludesUndefined(fc)
 	switch fc := fc.(type) {
 	case feature.ContinuousCriterion:
 		a, b := fc.Interval()
 		if !math.IsInf(a, 0) {
-			result = append(result, &FeatureCriterion{columnName, false, ">=", a})
+			result = append(result, &FeatureCriterion{columnName, false, ">=", a, includeNull, nil})
 		}
 		if !math.IsInf(b, 0) {
-			result = append(result, &FeatureCriterion{columnName, false, "<", b})
+			result = append(result, &FeatureCriterion{columnName, false, "<", b, includeNull, nil})
+		}
+		if len(result) == 0 && !includeNull {
+			result = append(result, &FeatureCriterion{columnName, false, "IS NOT NULL", nil, false, nil})
 		}
 	case feature.DiscreteCriterion:
 		dvr, ok := dictionary[fc.Value()]
 		if !ok {
 			return nil, fmt.Errorf("non representable discrete value '%s' in feature criterion", fc.Value())
 		}
-		result = append(result, &FeatureCriterion{columnName, true, "=", dvr})
+		result = append(result, &FeatureCriterion{columnName, true, "=", dvr, includeNull, nil})
+	case feature.DiscreteValuesCriterion:
+		dvrs := make([]int, 0, len(fc.Values()))
+		for _, v := range fc.Values() {
+			dvr, ok := dictionary[v]
+			if !ok {
+				return nil, fmt.Errorf("non representable discrete value '%s' in feature criterion", v)
+			}
+			dvrs = append(dvrs, dvr)
+		}
+		result = append(result, &FeatureCriterion{columnName, true, "IN", dvrs, includeNull, nil})
+	case feature.BooleanCriterion:
+		result = append(result, &FeatureCriterion{columnName, false, "=", fc.Value(), includeNull, nil})
+	case feature.UndefinedValueCriterion:
+		_, discrete := fc.Feature().(*feature.DiscreteFeature)
+		result = append(result, &FeatureCriterion{columnName, discrete, "IS NULL", nil, false, nil})
 	}
 	return result, nil
 }
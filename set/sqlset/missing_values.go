@@ -0,0 +1,56 @@
+package sqlset
+
+import "github.com/pbanos/botanic/feature"
+
+/*
+initMissingValues sets the representation on the database of the missing
+value of every feature of the set that has one, keyed by the column of the
+feature. Samples holding it for a feature are read as samples with an
+undefined value for the feature, just like samples with a NULL value. The
+discrete values table is expected to
+be loaded already: missing values of discrete features that are not on it
+cannot be held by any sample and are ignored.
+*/
+func (ss *sqlSet) initMissingValues() {
+	ss.missingValues = make(map[string]interface{})
+	for _, f := range ss.features {
+		column := ss.featureNamesColumns[f.Name()]
+		switch f := f.(type) {
+		case *feature.DiscreteFeature:
+			if mv, ok := f.MissingValue(); ok {
+				if id, ok := ss.inverseDiscreteValues[mv]; ok {
+					ss.missingValues[column] = id
+				}
+			}
+		case *feature.ContinuousFeature:
+			if mv, ok := f.MissingValue(); ok {
+				ss.missingValues[column] = mv
+			}
+		}
+	}
+}
+
+/*
+isMissingValue takes a map of columns to the representation of their
+missing values, a column and a value for it from the database and returns
+whether the value stands for a missing value of the feature on the column.
+*/
+func isMissingValue(missingValues map[string]interface{}, column string, v interface{}) bool {
+	mv, ok := missingValues[column]
+	return ok && v == mv
+}
+
+/*
+withMissingValues takes a slice of FeatureCriterion and sets on each of them
+the representation of the missing value of their feature column, if any, so
+that it is matched as an undefined value when building the WHERE clause of
+queries.
+*/
+func (ss *sqlSet) withMissingValues(criteria []*FeatureCriterion) []*FeatureCriterion {
+	for _, c := range criteria {
+		if mv, ok := ss.missingValues[c.FeatureColumn]; ok {
+			c.MissingValue = mv
+		}
+	}
+	return criteria
+}
@@ -0,0 +1,123 @@
+package pgadapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pbanos/botanic/set/sqlset"
+)
+
+/*
+copyDiscreteValues takes a context and a slice of discrete values and adds
+them to the discreteValues table in a transaction, copying them with a
+COPY FROM STDIN command into a temporary table first and inserting them from
+it ignoring the values already on the discreteValues table. It returns the
+number of values given or an error, in which case none of them are added.
+*/
+func (a *adapter) copyDiscreteValues(ctx context.Context, values []string) (int, error) {
+	rows := make([][]interface{}, 0, len(values))
+	for _, v := range values {
+		rows = append(rows, []interface{}{v})
+	}
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction to copy %d values: %v", len(values), err)
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TEMPORARY TABLE copied_discrete_values (value TEXT NOT NULL) ON COMMIT DROP`)
+	if err == nil {
+		err = copyRowsInTx(ctx, tx, "copied_discrete_values", []string{"value"}, rows)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO `+a.discreteValuesTable+` (value) SELECT DISTINCT value FROM copied_discrete_values`+discreteValueInsertConflictClause)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("copying %d values: %v", len(values), err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction to copy %d values: %v", len(values), err)
+	}
+	return len(values), nil
+}
+
+/*
+copySamples takes a context, a slice of raw samples and the feature columns
+and adds the samples to the samples table with a single COPY FROM STDIN
+command in a transaction. It returns the number of samples added or a *sqlset.PartialWriteError with all of them as the failed
+chunk, as none of them are added on failure.
+*/
+func (a *adapter) copySamples(ctx context.Context, rawSamples []map[string]interface{}, columns []string) (int, error) {
+	rows := make([][]interface{}, 0, len(rawSamples))
+	for _, rs := range rawSamples {
+		row := make([]interface{}, 0, len(columns))
+		for _, c := range columns {
+			row = append(row, rs[c])
+		}
+		rows = append(rows, row)
+	}
+	err := a.copyRows(ctx, a.samplesTable, columns, rows)
+	if err != nil {
+		return 0, &sqlset.PartialWriteError{
+			Chunk: rawSamples,
+			Err:   fmt.Errorf("copying %d samples: %v", len(rawSamples), err),
+		}
+	}
+	return len(rawSamples), nil
+}
+
+/*
+copyRows takes a context, a table name, its columns and a slice of rows with
+a value for every column and copies the rows into the table with a COPY FROM
+STDIN command in a transaction, returning an error if the rows cannot be
+copied or the transaction committed.
+*/
+func (a *adapter) copyRows(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	err = copyRowsInTx(ctx, tx, table, columns, rows)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
+func copyRowsInTx(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	copyStmt, err := tx.PrepareContext(ctx, copyInStmt(table, columns))
+	if err != nil {
+		return fmt.Errorf("preparing copy command: %v", err)
+	}
+	defer copyStmt.Close()
+	for _, row := range rows {
+		_, err = copyStmt.ExecContext(ctx, row...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = copyStmt.ExecContext(ctx)
+	return err
+}
+
+/*
+copyInStmt takes a table name and its columns and returns the COPY FROM STDIN
+command to copy rows into them. Unlike pq.CopyIn, it leaves the table name
+unquoted, as tables are created with unquoted names that PostgreSQL folds to
+lowercase, while the columns are quoted as they are on creation.
+*/
+func copyInStmt(table string, columns []string) string {
+	quotedColumns := make([]string, 0, len(columns))
+	for _, c := range columns {
+		quotedColumns = append(quotedColumns, pq.QuoteIdentifier(c))
+	}
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN", table, strings.Join(quotedColumns, ", "))
+}
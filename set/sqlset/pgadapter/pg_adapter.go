@@ -10,7 +10,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"hash/fnv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/pbanos/botanic/set/sqlset"
 
@@ -19,10 +21,14 @@ import (
 )
 
 const (
-	discreteValueTableCreateStmt = `CREATE TABLE IF NOT EXISTS discreteValues (
+	discreteValueTableCreateStmt = `CREATE TABLE IF NOT EXISTS %s (
 		id SERIAL PRIMARY KEY,
 		value TEXT UNIQUE NOT NULL)`
 
+	// discreteValueInsertConflictClause makes insertions of discrete values
+	// already on the discreteValues table be ignored
+	discreteValueInsertConflictClause = ` ON CONFLICT (value) DO NOTHING`
+
 	// MaxDiscreteValueInsertionsPerStatement is the maximum number
 	// of discrete values that are allowed to be added with a single
 	// insert command with the AddDiscreteValues method of the adapter.
@@ -34,10 +40,54 @@ const (
 	// insert command with the AddSamples method of the adapter.
 	// Trying to add more will result in making more insertion commands
 	MaxSampleInsertionsPerStatement = 10
+
+	// maxIdentifierLength is the maximum length in bytes of identifiers
+	// on PostgreSQL, which silently truncates longer ones
+	maxIdentifierLength = 63
 )
 
 type adapter struct {
-	db *sql.DB
+	db                     *sql.DB
+	nonTransactionalWrites bool
+	copyWrites             bool
+	samplesTable           string
+	discreteValuesTable    string
+	queryExplainer         *sqlset.QueryExplainer
+}
+
+/*
+preparer is implemented by sql.DB and sql.Tx, so that samples can be
+inserted with or without a transaction.
+*/
+type preparer interface {
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+}
+
+/*
+Options holds the settings of an adapter.
+
+NonTransactionalWrites disables the transaction in which the AddSamples
+method of the adapter inserts every slice of samples. Without it, samples
+inserted before a failure remain on the database.
+
+CopyWrites makes the AddSamples and AddDiscreteValues methods of the adapter
+load the data with COPY FROM STDIN commands instead of multi-row INSERT
+commands, which is much faster for large imports. COPY commands are always
+run in a transaction, so NonTransactionalWrites is ignored with it.
+
+TablePrefix, if not empty, is prepended with an underscore to the names of
+the samples and discreteValues tables, so that several sets can be kept on
+the same database. It may only contain lowercase letters, digits and
+underscores, and cannot start with a digit.
+
+QueryExplainer, if not nil, logs the queries the adapter runs to read the
+samples of the set and their aggregates together with their plans.
+*/
+type Options struct {
+	NonTransactionalWrites bool
+	CopyWrites             bool
+	TablePrefix            string
+	QueryExplainer         *sqlset.QueryExplainer
 }
 
 /*
@@ -45,11 +95,34 @@ New takes a PostgreSQL database connection URL and returns
 an Adapter that works on the database or an error if it fails to connect to it.
 */
 func New(url string) (sqlset.Adapter, error) {
+	return NewWithOptions(url, nil)
+}
+
+/*
+NewWithOptions takes a PostgreSQL database connection URL and options and
+returns an Adapter that works on the database with the options, or an error
+if it fails to connect to it. Nil options are the same as the zero Options.
+*/
+func NewWithOptions(url string, opts *Options) (sqlset.Adapter, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	samplesTable, discreteValuesTable, err := sqlset.TableNames(opts.TablePrefix)
+	if err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("postgres", url)
 	if err != nil {
 		return nil, err
 	}
-	return &adapter{db}, nil
+	return &adapter{
+		db:                     db,
+		nonTransactionalWrites: opts.NonTransactionalWrites,
+		copyWrites:             opts.CopyWrites,
+		samplesTable:           samplesTable,
+		discreteValuesTable:    discreteValuesTable,
+		queryExplainer:         opts.QueryExplainer,
+	}, nil
 }
 
 func (a *adapter) ColumnName(featureName string) (string, error) {
@@ -63,7 +136,7 @@ func (a *adapter) ColumnName(featureName string) (string, error) {
 }
 
 func (a *adapter) CreateDiscreteValuesTable(ctx context.Context) error {
-	createStmt, err := a.db.PrepareContext(ctx, discreteValueTableCreateStmt)
+	createStmt, err := a.db.PrepareContext(ctx, fmt.Sprintf(discreteValueTableCreateStmt, a.discreteValuesTable))
 	if err != nil {
 		return fmt.Errorf("preparing discreteValues creation statement: %v", err)
 	}
@@ -75,15 +148,18 @@ func (a *adapter) CreateDiscreteValuesTable(ctx context.Context) error {
 	return nil
 }
 
-func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns []string) error {
+func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) error {
 	var createStmtBuf bytes.Buffer
-	createStmtBuf.WriteString("CREATE TABLE IF NOT EXISTS samples(")
+	createStmtBuf.WriteString("CREATE TABLE IF NOT EXISTS " + a.samplesTable + "(")
 	for _, c := range discreteFeatureColumns {
-		createStmtBuf.WriteString(fmt.Sprintf(`"%s" INTEGER NULL REFERENCES discreteValues(id), `, c))
+		createStmtBuf.WriteString(fmt.Sprintf(`"%s" INTEGER NULL REFERENCES %s(id), `, c, a.discreteValuesTable))
 	}
 	for _, c := range continuousFeatureColumns {
 		createStmtBuf.WriteString(fmt.Sprintf(`"%s" REAL NULL, `, c))
 	}
+	for _, c := range booleanFeatureColumns {
+		createStmtBuf.WriteString(fmt.Sprintf(`"%s" BOOLEAN NULL, `, c))
+	}
 	createStmtBuf.WriteString(`"id" SERIAL PRIMARY KEY)`)
 	createStmt, err := a.db.PrepareContext(ctx, createStmtBuf.String())
 	if err != nil {
@@ -97,6 +173,37 @@ func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns,
 	return nil
 }
 
+func (a *adapter) CreateIndexes(ctx context.Context, columns []string) error {
+	for _, c := range columns {
+		_, err := a.db.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s" ON %s ("%s")`, indexName(a.samplesTable, c), a.samplesTable, c))
+		if err != nil {
+			return fmt.Errorf("creating index on samples column %s: %v", c, err)
+		}
+	}
+	return nil
+}
+
+/*
+indexName takes the name of a table and one of its columns and returns the
+name of the index on the column. Names longer than PostgreSQL identifiers
+can be are shortened and suffixed with a hash of the whole name, so that the
+indexes on columns sharing a long prefix do not end up with the same name.
+*/
+func indexName(table, column string) string {
+	name := fmt.Sprintf("%s_%s_idx", table, column)
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	suffix := fmt.Sprintf("_%08x", h.Sum32())
+	prefix := name[:maxIdentifierLength-len(suffix)]
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + suffix
+}
+
 func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int, error) {
 	var (
 		chunkStart       = 0
@@ -106,12 +213,16 @@ func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int,
 	if len(values) == 0 {
 		return 0, nil
 	}
-	insertStmtStart := "INSERT INTO discreteValues (value) VALUES ($1)"
+	if a.copyWrites {
+		return a.copyDiscreteValues(ctx, values)
+	}
+	insertStmtStart := "INSERT INTO " + a.discreteValuesTable + " (value) VALUES ($1)"
 	if len(values) > MaxDiscreteValueInsertionsPerStatement {
 		insertStmtBuffer.WriteString(insertStmtStart)
 		for i := 1; i < MaxDiscreteValueInsertionsPerStatement; i++ {
 			insertStmtBuffer.WriteString(fmt.Sprintf(", ($%d)", i+1))
 		}
+		insertStmtBuffer.WriteString(discreteValueInsertConflictClause)
 		insertStmt, err := a.db.PrepareContext(ctx, insertStmtBuffer.String())
 		if err != nil {
 			return 0, fmt.Errorf("preparing insert command for %d values: %v", MaxDiscreteValueInsertionsPerStatement, err)
@@ -141,6 +252,7 @@ func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int,
 		for i := 1; i < len(lastValues); i++ {
 			insertStmtBuffer.WriteString(fmt.Sprintf(", ($%d)", i+1))
 		}
+		insertStmtBuffer.WriteString(discreteValueInsertConflictClause)
 		insertStmt, err := a.db.PrepareContext(ctx, insertStmtBuffer.String())
 		if err != nil {
 			return chunkStart, fmt.Errorf("preparing insert command for %d values: %v", len(lastValues), err)
@@ -162,7 +274,7 @@ func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int,
 }
 
 func (a *adapter) ListDiscreteValues(ctx context.Context) (map[int]string, error) {
-	rows, err := a.db.QueryContext(ctx, `SELECT id, value FROM discreteValues`)
+	rows, err := a.db.QueryContext(ctx, `SELECT id, value FROM `+a.discreteValuesTable)
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +296,37 @@ func (a *adapter) ListDiscreteValues(ctx context.Context) (map[int]string, error
 	return result, err
 }
 
-func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns []string) (int, error) {
+func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) (int, error) {
+	columns := make([]string, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns)+len(booleanFeatureColumns))
+	columns = append(columns, discreteFeatureColumns...)
+	columns = append(columns, continuousFeatureColumns...)
+	columns = append(columns, booleanFeatureColumns...)
+	if a.copyWrites && len(rawSamples) > 0 {
+		return a.copySamples(ctx, rawSamples, columns)
+	}
+	if a.nonTransactionalWrites {
+		return a.addSamples(ctx, a.db, rawSamples, columns)
+	}
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction to insert samples: %v", err)
+	}
+	n, err := a.addSamples(ctx, tx, rawSamples, columns)
+	if err != nil {
+		tx.Rollback()
+		if pwe, ok := err.(*sqlset.PartialWriteError); ok {
+			pwe.Written = 0
+		}
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction to insert samples: %v", err)
+	}
+	return n, nil
+}
+
+func (a *adapter) addSamples(ctx context.Context, p preparer, rawSamples []map[string]interface{}, columns []string) (int, error) {
 	var (
 		chunkStart            = 0
 		chunkEnd              = MaxSampleInsertionsPerStatement
@@ -194,17 +336,13 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 	if len(rawSamples) == 0 {
 		return 0, nil
 	}
-	if len(discreteFeatureColumns)+len(continuousFeatureColumns) == 0 {
+	if len(columns) == 0 {
 		return 0, fmt.Errorf("no features to store")
 	}
-	insertStmtStartBuffer.WriteString(`INSERT INTO samples ("`)
-	insertStmtStartBuffer.WriteString(strings.Join(discreteFeatureColumns, `", "`))
-	if len(discreteFeatureColumns) > 0 && len(continuousFeatureColumns) > 0 {
-		insertStmtStartBuffer.WriteString(`", "`)
-	}
-	insertStmtStartBuffer.WriteString(strings.Join(continuousFeatureColumns, `", "`))
+	insertStmtStartBuffer.WriteString(`INSERT INTO ` + a.samplesTable + ` ("`)
+	insertStmtStartBuffer.WriteString(strings.Join(columns, `", "`))
 	insertStmtStartBuffer.WriteString(`") VALUES ($1`)
-	for i := 1; i < len(discreteFeatureColumns)+len(continuousFeatureColumns); i++ {
+	for i := 1; i < len(columns); i++ {
 		insertStmtStartBuffer.WriteString(fmt.Sprintf(", $%d", i+1))
 	}
 	insertStmtStartBuffer.WriteString(`)`)
@@ -212,29 +350,32 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 	if len(rawSamples) > MaxSampleInsertionsPerStatement {
 		insertStmtBuffer.WriteString(insertStmtStart)
 		for i := 1; i < MaxSampleInsertionsPerStatement; i++ {
-			insertStmtBuffer.WriteString(fmt.Sprintf(", ($%d", 1+i*(len(discreteFeatureColumns)+len(continuousFeatureColumns))))
-			for j := 1; j < len(discreteFeatureColumns)+len(continuousFeatureColumns); j++ {
-				insertStmtStartBuffer.WriteString(fmt.Sprintf(", $%d", j+1+i*(len(discreteFeatureColumns)+len(continuousFeatureColumns))))
+			insertStmtBuffer.WriteString(fmt.Sprintf(", ($%d", 1+i*(len(columns))))
+			for j := 1; j < len(columns); j++ {
+				insertStmtBuffer.WriteString(fmt.Sprintf(", $%d", j+1+i*(len(columns))))
 			}
-			insertStmtStartBuffer.WriteString(`)`)
+			insertStmtBuffer.WriteString(`)`)
 		}
-		insertStmt, err := a.db.PrepareContext(ctx, insertStmtBuffer.String())
+		insertStmt, err := p.PrepareContext(ctx, insertStmtBuffer.String())
 		if err != nil {
 			return 0, fmt.Errorf("preparing insert command for %d samples: %v", MaxSampleInsertionsPerStatement, err)
 		}
 		for c := 0; c < len(rawSamples)/MaxSampleInsertionsPerStatement; c++ {
-			irs := make([]interface{}, 0, MaxSampleInsertionsPerStatement*(len(discreteFeatureColumns)+len(continuousFeatureColumns)))
+			irs := make([]interface{}, 0, MaxSampleInsertionsPerStatement*(len(columns)))
 			for _, rs := range rawSamples[chunkStart:chunkEnd] {
-				for _, f := range discreteFeatureColumns {
-					irs = append(irs, rs[f])
-				}
-				for _, f := range continuousFeatureColumns {
+				for _, f := range columns {
 					irs = append(irs, rs[f])
 				}
 			}
 			_, err = insertStmt.ExecContext(ctx, irs...)
 			if err != nil {
-				return chunkStart, fmt.Errorf("inserting the %dth %d samples: %v", c+1, MaxSampleInsertionsPerStatement, err)
+				insertStmt.Close()
+				return chunkStart, &sqlset.PartialWriteError{
+					Written: chunkStart,
+					Offset:  chunkStart,
+					Chunk:   rawSamples[chunkStart:chunkEnd],
+					Err:     fmt.Errorf("inserting the %dth %d samples: %v", c+1, MaxSampleInsertionsPerStatement, err),
+				}
 			}
 			chunkStart += MaxSampleInsertionsPerStatement
 			chunkEnd += MaxSampleInsertionsPerStatement
@@ -250,28 +391,31 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 		insertStmtBuffer = bytes.Buffer{}
 		insertStmtBuffer.WriteString(insertStmtStart)
 		for i := 1; i < len(lastRawSamples); i++ {
-			insertStmtBuffer.WriteString(fmt.Sprintf(", ($%d", 1+i*(len(discreteFeatureColumns)+len(continuousFeatureColumns))))
-			for j := 1; j < len(discreteFeatureColumns)+len(continuousFeatureColumns); j++ {
-				insertStmtStartBuffer.WriteString(fmt.Sprintf(", $%d", j+1+i*(len(discreteFeatureColumns)+len(continuousFeatureColumns))))
+			insertStmtBuffer.WriteString(fmt.Sprintf(", ($%d", 1+i*(len(columns))))
+			for j := 1; j < len(columns); j++ {
+				insertStmtBuffer.WriteString(fmt.Sprintf(", $%d", j+1+i*(len(columns))))
 			}
-			insertStmtStartBuffer.WriteString(`)`)
+			insertStmtBuffer.WriteString(`)`)
 		}
-		insertStmt, err := a.db.PrepareContext(ctx, insertStmtBuffer.String())
+		insertStmt, err := p.PrepareContext(ctx, insertStmtBuffer.String())
 		if err != nil {
 			return chunkStart, fmt.Errorf("preparing insert command for %d values: %v", len(lastRawSamples), err)
 		}
-		ilrs := make([]interface{}, 0, len(lastRawSamples)*(len(discreteFeatureColumns)+len(continuousFeatureColumns)))
+		ilrs := make([]interface{}, 0, len(lastRawSamples)*(len(columns)))
 		for _, rs := range rawSamples[chunkStart:chunkEnd] {
-			for _, f := range discreteFeatureColumns {
-				ilrs = append(ilrs, rs[f])
-			}
-			for _, f := range continuousFeatureColumns {
+			for _, f := range columns {
 				ilrs = append(ilrs, rs[f])
 			}
 		}
 		_, err = insertStmt.ExecContext(ctx, ilrs...)
 		if err != nil {
-			return chunkStart, fmt.Errorf("inserting the last %d values: %v", len(lastRawSamples), err)
+			insertStmt.Close()
+			return chunkStart, &sqlset.PartialWriteError{
+				Written: chunkStart,
+				Offset:  chunkStart,
+				Chunk:   lastRawSamples,
+				Err:     fmt.Errorf("inserting the last %d values: %v", len(lastRawSamples), err),
+			}
 		}
 		err = insertStmt.Close()
 		if err != nil {
@@ -281,13 +425,14 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 	return chunkEnd, nil
 }
 
-func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns []string) ([]map[string]interface{}, error) {
+func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) ([]map[string]interface{}, error) {
 	var result []map[string]interface{}
 	err := a.IterateOnSamples(
 		ctx,
 		criteria,
 		discreteFeatureColumns,
 		continuousFeatureColumns,
+		booleanFeatureColumns,
 		func(_ int, rawSample map[string]interface{}) (bool, error) {
 			result = append(result, rawSample)
 			return true, nil
@@ -298,22 +443,22 @@ func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCri
 	return result, nil
 }
 
-func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error {
+func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
+	columns := make([]string, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns)+len(booleanFeatureColumns))
+	columns = append(columns, discreteFeatureColumns...)
+	columns = append(columns, continuousFeatureColumns...)
+	columns = append(columns, booleanFeatureColumns...)
 	queryBuffer.WriteString(`SELECT "`)
-	queryBuffer.WriteString(strings.Join(discreteFeatureColumns, `", "`))
-	if len(discreteFeatureColumns) > 0 && len(continuousFeatureColumns) > 0 {
-		queryBuffer.WriteString(`", "`)
-	}
-	queryBuffer.WriteString(strings.Join(continuousFeatureColumns, `", "`))
-	queryBuffer.WriteString(`" FROM samples`)
+	queryBuffer.WriteString(strings.Join(columns, `", "`))
+	queryBuffer.WriteString(`" FROM ` + a.samplesTable)
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return err
 	}
@@ -321,13 +466,17 @@ func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.Featu
 		rawSample := make(map[string]interface{})
 		discreteValues := make([]sql.NullInt64, len(discreteFeatureColumns))
 		continuousValues := make([]sql.NullFloat64, len(continuousFeatureColumns))
-		values := make([]interface{}, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns))
+		booleanValues := make([]sql.NullBool, len(booleanFeatureColumns))
+		values := make([]interface{}, 0, len(columns))
 		for i := range discreteValues {
 			values = append(values, &discreteValues[i])
 		}
 		for i := range continuousValues {
 			values = append(values, &continuousValues[i])
 		}
+		for i := range booleanValues {
+			values = append(values, &booleanValues[i])
+		}
 		err = rows.Scan(values...)
 		if err != nil {
 			return err
@@ -342,6 +491,11 @@ func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.Featu
 				rawSample[c] = continuousValues[i].Float64
 			}
 		}
+		for i, c := range booleanFeatureColumns {
+			if booleanValues[i].Valid {
+				rawSample[c] = booleanValues[i].Bool
+			}
+		}
 		ok, err := lambda(j, rawSample)
 		if err != nil {
 			return err
@@ -361,13 +515,13 @@ func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.Featu
 func (a *adapter) CountSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion) (int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(`SELECT COUNT(*) FROM samples`)
+	queryBuffer.WriteString(`SELECT COUNT(*) FROM ` + a.samplesTable)
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return 0, err
 	}
@@ -386,13 +540,13 @@ func (a *adapter) CountSamples(ctx context.Context, criteria []*sqlset.FeatureCr
 func (a *adapter) ListSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM samples`, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s`, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -418,13 +572,13 @@ func (a *adapter) ListSampleDiscreteFeatureValues(ctx context.Context, fc string
 func (a *adapter) ListSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]float64, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM samples`, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s`, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -450,14 +604,14 @@ func (a *adapter) ListSampleContinuousFeatureValues(ctx context.Context, fc stri
 func (a *adapter) CountSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[int]int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM samples`, fc, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM %s`, fc, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
 	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -484,14 +638,14 @@ func (a *adapter) CountSampleDiscreteFeatureValues(ctx context.Context, fc strin
 func (a *adapter) CountSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[float64]int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM samples`, fc, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM %s`, fc, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
 	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -515,6 +669,289 @@ func (a *adapter) CountSampleContinuousFeatureValues(ctx context.Context, fc str
 	return result, err
 }
 
+func (a *adapter) ListSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]bool, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s`, fc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	var result []bool
+	for rows.Next() {
+		var value sql.NullBool
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result = append(result, value.Bool)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) CountSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[bool]int, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM %s`, fc, fc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[bool]int)
+	for rows.Next() {
+		var value sql.NullBool
+		var count int
+		err = rows.Scan(&value, &count)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[value.Bool] = count
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleWeights(ctx context.Context, wc string, criteria []*sqlset.FeatureCriterion) (float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT SUM(COALESCE("%s", 1.0)) FROM %s`, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return 0.0, err
+	}
+	if !rows.Next() {
+		return 0.0, rows.Err()
+	}
+	var weight sql.NullFloat64
+	err = rows.Scan(&weight)
+	if err != nil {
+		return 0.0, err
+	}
+	err = rows.Close()
+	return weight.Float64, err
+}
+
+func (a *adapter) SumSampleDiscreteFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[int]float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", SUM(COALESCE("%s", 1.0)) FROM %s`, fc, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]float64)
+	for rows.Next() {
+		var value sql.NullInt64
+		var weight float64
+		err = rows.Scan(&value, &weight)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[int(value.Int64)] = weight
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleContinuousFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[float64]float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", SUM(COALESCE("%s", 1.0)) FROM %s`, fc, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]float64)
+	for rows.Next() {
+		var value sql.NullFloat64
+		var weight float64
+		err = rows.Scan(&value, &weight)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[value.Float64] = weight
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleBooleanFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[bool]float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", SUM(COALESCE("%s", 1.0)) FROM %s`, fc, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[bool]float64)
+	for rows.Next() {
+		var value sql.NullBool
+		var weight float64
+		err = rows.Scan(&value, &weight)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[value.Bool] = weight
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleContinuousFeatureValueLabelWeights(ctx context.Context, fc, lc, wc string, criteria []*sqlset.FeatureCriterion) (map[float64]*sqlset.LabelWeights, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	weight := "COUNT(*)"
+	if wc != "" {
+		weight = fmt.Sprintf(`SUM(COALESCE("%s", 1.0))`, wc)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", "%s", %s FROM %s`, fc, lc, weight, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s", "%s"`, fc, lc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]*sqlset.LabelWeights)
+	for rows.Next() {
+		var value, label sql.NullFloat64
+		var w float64
+		err = rows.Scan(&value, &label, &w)
+		if err != nil {
+			return nil, err
+		}
+		if !value.Valid {
+			continue
+		}
+		lw, ok := result[value.Float64]
+		if !ok {
+			lw = &sqlset.LabelWeights{Labels: make(map[float64]float64)}
+			result[value.Float64] = lw
+		}
+		lw.Weight += w
+		if label.Valid {
+			lw.Labels[label.Float64] += w
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+/*
+query takes a context, the criteria defining the subset of samples a query
+reads, the query and its arguments and runs the query on the database,
+explaining it first with the adapter's QueryExplainer if it has one.
+*/
+func (a *adapter) query(ctx context.Context, criteria []*sqlset.FeatureCriterion, query string, args ...interface{}) (*sql.Rows, error) {
+	if a.queryExplainer != nil {
+		a.queryExplainer.Explain(ctx, criteria, query, args, a.plan)
+	}
+	return a.db.QueryContext(ctx, query, args...)
+}
+
+/*
+plan takes a context, a query and its arguments and returns the lines of the
+plan PostgreSQL follows to run the query, as returned by EXPLAIN or by
+EXPLAIN ANALYZE if the adapter's QueryExplainer requires it, or an error.
+*/
+func (a *adapter) plan(ctx context.Context, query string, args []interface{}) ([]string, error) {
+	explain := `EXPLAIN `
+	if a.queryExplainer.Analyze {
+		explain = `EXPLAIN ANALYZE `
+	}
+	rows, err := a.db.QueryContext(ctx, explain+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for rows.Next() {
+		var line string
+		err = rows.Scan(&line)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return lines, rows.Close()
+}
+
 func buildWhereClause(criteria []*sqlset.FeatureCriterion) (string, []interface{}) {
 	if len(criteria) == 0 {
 		return "", nil
@@ -522,11 +959,51 @@ func buildWhereClause(criteria []*sqlset.FeatureCriterion) (string, []interface{
 	var buf bytes.Buffer
 	values := make([]interface{}, 0, len(criteria))
 	buf.WriteString(" WHERE ")
-	buf.WriteString(fmt.Sprintf(`"%s" %s $1`, criteria[0].FeatureColumn, criteria[0].Operator))
-	values = append(values, criteria[0].Value)
-	for i := 1; i < len(criteria); i++ {
-		buf.WriteString(fmt.Sprintf(`AND "%s" %s $%d`, criteria[i].FeatureColumn, criteria[i].Operator, i+1))
-		values = append(values, criteria[i].Value)
+	for i, c := range criteria {
+		if i > 0 {
+			buf.WriteString(" AND ")
+		}
+		var condition string
+		switch c.Operator {
+		case "IS NULL":
+			condition = fmt.Sprintf(`"%s" IS NULL`, c.FeatureColumn)
+			if c.MissingValue != nil {
+				values = append(values, c.MissingValue)
+				condition = fmt.Sprintf(`(%s OR "%s" = $%d)`, condition, c.FeatureColumn, len(values))
+			}
+		case "IS NOT NULL":
+			condition = fmt.Sprintf(`"%s" IS NOT NULL`, c.FeatureColumn)
+			if c.MissingValue != nil {
+				values = append(values, c.MissingValue)
+				condition = fmt.Sprintf(`(%s AND "%s" <> $%d)`, condition, c.FeatureColumn, len(values))
+			}
+		case "IN":
+			dvrs, _ := c.Value.([]int)
+			if len(dvrs) == 0 {
+				condition = "1 = 0"
+				break
+			}
+			placeholders := make([]string, 0, len(dvrs))
+			for _, dvr := range dvrs {
+				values = append(values, dvr)
+				placeholders = append(placeholders, fmt.Sprintf("$%d", len(values)))
+			}
+			condition = fmt.Sprintf(`"%s" IN (%s)`, c.FeatureColumn, strings.Join(placeholders, ", "))
+		default:
+			values = append(values, c.Value)
+			condition = fmt.Sprintf(`"%s" %s $%d`, c.FeatureColumn, c.Operator, len(values))
+		}
+		if c.Operator != "IS NULL" && c.Operator != "IS NOT NULL" && c.MissingValue != nil {
+			values = append(values, c.MissingValue)
+			if c.IncludeNull {
+				condition = fmt.Sprintf(`(%s OR "%s" IS NULL OR "%s" = $%d)`, condition, c.FeatureColumn, c.FeatureColumn, len(values))
+			} else {
+				condition = fmt.Sprintf(`(%s AND "%s" <> $%d)`, condition, c.FeatureColumn, len(values))
+			}
+		} else if c.IncludeNull {
+			condition = fmt.Sprintf(`(%s OR "%s" IS NULL)`, condition, c.FeatureColumn)
+		}
+		buf.WriteString(condition)
 	}
 	return buf.String(), values
 }
@@ -0,0 +1,25 @@
+package pgadapter
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestIndexName(t *testing.T) {
+	if name := indexName("samples", "color"); name != "samples_color_idx" {
+		t.Errorf("expected short index name to be kept, got %s", name)
+	}
+	long := strings.Repeat("a", 60)
+	a := indexName("samples", long+"_x")
+	b := indexName("samples", long+"_y")
+	if len(a) > maxIdentifierLength || len(b) > maxIdentifierLength {
+		t.Errorf("expected index names of at most %d bytes, got %s and %s", maxIdentifierLength, a, b)
+	}
+	if a == b {
+		t.Errorf("expected index names of columns sharing a long prefix to differ, got %s for both", a)
+	}
+	if name := indexName("samples", strings.Repeat("ñ", 40)); len(name) > maxIdentifierLength || !utf8.ValidString(name) {
+		t.Errorf("expected a valid UTF-8 index name of at most %d bytes, got %q", maxIdentifierLength, name)
+	}
+}
@@ -0,0 +1,84 @@
+package sqlset
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pbanos/botanic/logging"
+)
+
+/*
+QueryExplainer logs the queries an adapter runs to read the samples of a set
+and their aggregates, together with the plan the database follows to run
+them, so that the indexes missing on the samples table can be identified
+when growing trees from a set is slow.
+
+Every logged query is tagged with the hash of the criteria defining the
+subset it reads, as returned by CriteriaHash, so that the queries made on
+the same subset can be told apart from the rest.
+
+Logger is the logger the queries and their plans are logged with at info
+level, every query with its number, the hash of its criteria, its SQL and
+its arguments on the query, criteria, sql and args fields, and every line
+of its plan on the plan field of a message of its own with the same number
+and hash. Failures to obtain the plan are logged at warn level.
+
+Every, if greater than 1, makes only one of every Every queries be logged,
+to keep the log of long growths manageable.
+
+Analyze makes the plan of the logged queries be obtained by running them
+with EXPLAIN ANALYZE, so that it includes the actual time spent on every
+step of the plan at the cost of running the queries twice. It is ignored by
+adapters over databases that cannot analyze queries, such as SQLite3.
+*/
+type QueryExplainer struct {
+	Logger  logging.Logger
+	Every   int
+	Analyze bool
+	count   uint64
+}
+
+/*
+Explain takes a context, the criteria defining the subset a query reads, the
+query, its arguments and a function that returns the lines of the plan of a
+query and, if the query is sampled, logs the query and its plan with the
+hash of the criteria. Failures to obtain the plan are logged too, as
+explaining a query must not make it fail.
+*/
+func (qe *QueryExplainer) Explain(ctx context.Context, criteria []*FeatureCriterion, query string, args []interface{}, plan func(context.Context, string, []interface{}) ([]string, error)) {
+	n := atomic.AddUint64(&qe.count, 1)
+	if qe.Logger == nil || (qe.Every > 1 && (n-1)%uint64(qe.Every) != 0) {
+		return
+	}
+	hash := CriteriaHash(criteria)
+	qe.Logger.Log(logging.LevelInfo, "Running query", "query", n, "criteria", hash, "sql", query, "args", args)
+	lines, err := plan(ctx, query, args)
+	if err != nil {
+		qe.Logger.Log(logging.LevelWarn, "Explaining query", "query", n, "criteria", hash, "error", err)
+		return
+	}
+	for _, l := range lines {
+		qe.Logger.Log(logging.LevelInfo, "Query plan", "query", n, "criteria", hash, "plan", l)
+	}
+}
+
+/*
+CriteriaHash takes a slice of feature criteria and returns a short
+hexadecimal hash that identifies them regardless of their order.
+*/
+func CriteriaHash(criteria []*FeatureCriterion) string {
+	cs := make([]string, 0, len(criteria))
+	for _, c := range criteria {
+		cs = append(cs, fmt.Sprintf("%s %s %v %v", c.FeatureColumn, c.Operator, c.Value, c.MissingValue))
+	}
+	sort.Strings(cs)
+	h := fnv.New64a()
+	for _, c := range cs {
+		h.Write([]byte(c))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
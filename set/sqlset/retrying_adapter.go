@@ -0,0 +1,283 @@
+package sqlset
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	/*
+		DefaultRetryAttempts is the number of times the adapters created
+		with NewRetryingAdapter try an operation by default.
+	*/
+	DefaultRetryAttempts = 3
+	/*
+		DefaultRetryBackoff is the time the adapters created with
+		NewRetryingAdapter wait by default before the second attempt of
+		an operation. It doubles for every later attempt.
+	*/
+	DefaultRetryBackoff = time.Second
+)
+
+/*
+retryingAdapter is an Adapter that retries the operations of another one
+when they fail because the connection to the database is lost.
+*/
+type retryingAdapter struct {
+	Adapter
+	attempts int
+	backoff  time.Duration
+}
+
+/*
+NewRetryingAdapter takes an Adapter, a number of attempts and a backoff
+duration and returns an Adapter that tries the read operations of the given
+one up to the number of attempts while they fail because the connection to
+the database is lost, waiting for the backoff duration before the second
+attempt and doubling it for every later one. The pool of connections of the
+database/sql package discards broken connections, so every attempt runs on
+a healthy or new connection, which keeps long-running growths alive across
+network partitions shorter than the retries.
+
+Operations that write to the database are tried once, as they may have been
+applied before the connection was lost. They and the read operations that
+run out of attempts return a *ConnectionError when the connection is lost.
+IterateOnSamples is only retried if the connection is lost before any
+sample has been passed to its lambda. A number of attempts lower than 1 is
+taken as DefaultRetryAttempts.
+*/
+func NewRetryingAdapter(a Adapter, attempts int, backoff time.Duration) Adapter {
+	if attempts < 1 {
+		attempts = DefaultRetryAttempts
+	}
+	return &retryingAdapter{a, attempts, backoff}
+}
+
+/*
+IsConnectionLost takes an error and returns whether it was caused by the
+loss of the connection to the database.
+*/
+func IsConnectionLost(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ne net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &ne)
+}
+
+/*
+retry takes a context and an operation and calls the operation until it
+succeeds, fails for a reason other than the loss of the connection to the
+database or the attempts of the adapter run out, in which case a
+*ConnectionError is returned. It also stops when the context is done,
+returning the error of the context.
+*/
+func (ra *retryingAdapter) retry(ctx context.Context, op func() error) error {
+	backoff := ra.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil {
+			return nil
+		}
+		if !IsConnectionLost(err) {
+			return err
+		}
+		if attempt == ra.attempts {
+			return &ConnectionError{attempt, err}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+/*
+once takes an error returned by an operation that cannot be retried and
+returns it as a *ConnectionError if it was caused by the loss of the
+connection to the database, or unchanged otherwise.
+*/
+func once(err error) error {
+	if err != nil && IsConnectionLost(err) {
+		return &ConnectionError{1, err}
+	}
+	return err
+}
+
+func (ra *retryingAdapter) CreateDiscreteValuesTable(ctx context.Context) error {
+	return ra.retry(ctx, func() error {
+		return ra.Adapter.CreateDiscreteValuesTable(ctx)
+	})
+}
+
+func (ra *retryingAdapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) error {
+	return ra.retry(ctx, func() error {
+		return ra.Adapter.CreateSampleTable(ctx, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns)
+	})
+}
+
+func (ra *retryingAdapter) CreateIndexes(ctx context.Context, columns []string) error {
+	return ra.retry(ctx, func() error {
+		return ra.Adapter.CreateIndexes(ctx, columns)
+	})
+}
+
+func (ra *retryingAdapter) AddDiscreteValues(ctx context.Context, values []string) (int, error) {
+	n, err := ra.Adapter.AddDiscreteValues(ctx, values)
+	return n, once(err)
+}
+
+func (ra *retryingAdapter) ListDiscreteValues(ctx context.Context) (result map[int]string, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.ListDiscreteValues(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) (int, error) {
+	n, err := ra.Adapter.AddSamples(ctx, rawSamples, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns)
+	if pwe, ok := err.(*PartialWriteError); ok && IsConnectionLost(pwe.Err) {
+		pwe.Err = &ConnectionError{1, pwe.Err}
+		return n, pwe
+	}
+	return n, once(err)
+}
+
+func (ra *retryingAdapter) ListSamples(ctx context.Context, criteria []*FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) (result []map[string]interface{}, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.ListSamples(ctx, criteria, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) IterateOnSamples(ctx context.Context, criteria []*FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error {
+	var started bool
+	var startedErr error
+	err := ra.retry(ctx, func() error {
+		err := ra.Adapter.IterateOnSamples(ctx, criteria, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns, func(i int, rs map[string]interface{}) (bool, error) {
+			started = true
+			return lambda(i, rs)
+		})
+		if err != nil && started {
+			startedErr = err
+			return nil
+		}
+		return err
+	})
+	if startedErr != nil {
+		return once(startedErr)
+	}
+	return err
+}
+
+func (ra *retryingAdapter) CountSamples(ctx context.Context, criteria []*FeatureCriterion) (result int, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.CountSamples(ctx, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) ListSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*FeatureCriterion) (result []int, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.ListSampleDiscreteFeatureValues(ctx, fc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) ListSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*FeatureCriterion) (result []float64, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.ListSampleContinuousFeatureValues(ctx, fc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) CountSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*FeatureCriterion) (result map[int]int, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.CountSampleDiscreteFeatureValues(ctx, fc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) CountSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*FeatureCriterion) (result map[float64]int, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.CountSampleContinuousFeatureValues(ctx, fc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) ListSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*FeatureCriterion) (result []bool, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.ListSampleBooleanFeatureValues(ctx, fc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) CountSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*FeatureCriterion) (result map[bool]int, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.CountSampleBooleanFeatureValues(ctx, fc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) SumSampleWeights(ctx context.Context, wc string, criteria []*FeatureCriterion) (result float64, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.SumSampleWeights(ctx, wc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) SumSampleDiscreteFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*FeatureCriterion) (result map[int]float64, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.SumSampleDiscreteFeatureValueWeights(ctx, fc, wc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) SumSampleContinuousFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*FeatureCriterion) (result map[float64]float64, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.SumSampleContinuousFeatureValueWeights(ctx, fc, wc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) SumSampleBooleanFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*FeatureCriterion) (result map[bool]float64, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.SumSampleBooleanFeatureValueWeights(ctx, fc, wc, criteria)
+		return err
+	})
+	return result, err
+}
+
+func (ra *retryingAdapter) SumSampleContinuousFeatureValueLabelWeights(ctx context.Context, fc, lc, wc string, criteria []*FeatureCriterion) (result map[float64]*LabelWeights, err error) {
+	err = ra.retry(ctx, func() error {
+		result, err = ra.Adapter.SumSampleContinuousFeatureValueLabelWeights(ctx, fc, lc, wc, criteria)
+		return err
+	})
+	return result, err
+}
@@ -19,7 +19,9 @@ type Sample struct {
 		* an int for the value of a discrete feature the column
 		  is representing or
 		* a float64 for the value of a continuous feature the
-		  column is representing
+		  column is representing or
+		* a bool for the value of a boolean feature the column
+		  is representing
 	*/
 	Values map[string]interface{}
 	/*
@@ -36,12 +38,27 @@ type Sample struct {
 		the feature value on the Sample's Value map.
 	*/
 	FeatureNamesColumns map[string]string
+	/*
+		WeightColumn is the name of the column holding the weight
+		of the sample, or an empty string if samples are not
+		weighted.
+	*/
+	WeightColumn string
+	/*
+		MissingValues is a map of column names to the value
+		that stands for a missing value of the feature the
+		column is representing, for those features that
+		have one. Columns holding it are read as undefined.
+	*/
+	MissingValues map[string]interface{}
 }
 
 /*
 ValueFor takes a feature and returns the value for the feature
-according to the sample or nil if is undefined. For continuous
-feature the value is the one available on its Values map for
+according to the sample or nil if is undefined, either because
+the sample has no value for it or because it holds the missing
+value of the feature. For continuous
+and boolean features the value is the one available on its Values map for
 the name of the column corresponding to the feature's name,
 whereas for discrete features this value is used as key on the
 DiscreteFeaturesValue dictionary to obtain the string
@@ -53,7 +70,7 @@ func (s *Sample) ValueFor(f feature.Feature) (interface{}, error) {
 		return nil, nil
 	}
 	v, ok := s.Values[c]
-	if !ok {
+	if !ok || isMissingValue(s.MissingValues, c, v) {
 		return nil, nil
 	}
 	_, ok = f.(*feature.DiscreteFeature)
@@ -66,3 +83,18 @@ func (s *Sample) ValueFor(f feature.Feature) (interface{}, error) {
 	}
 	return v, nil
 }
+
+/*
+Weight returns the weight of the sample: the value on its Values map for
+the WeightColumn, or 1 if there is no WeightColumn or no value for it.
+*/
+func (s *Sample) Weight() float64 {
+	if s.WeightColumn == "" {
+		return 1.0
+	}
+	w, ok := s.Values[s.WeightColumn].(float64)
+	if !ok {
+		return 1.0
+	}
+	return w
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
+	"sync"
 
 	"github.com/pbanos/botanic/feature"
 	"github.com/pbanos/botanic/set"
@@ -14,11 +16,26 @@ Set is a set.Set to which samples can be added
 
 Its AddSample takes a set.Sample and adds it to the set,
 returning an error if any errors occur or nil otherwise.
+
+Its Stats method returns the aggregates cached on the set,
+and together with the rest of methods can be called
+concurrently on a set and its subsets.
 */
 type Set interface {
 	set.Set
 	Write(context.Context, []set.Sample) (int, error)
 	Read(context.Context) (<-chan set.Sample, <-chan error)
+	Stats() Stats
+}
+
+/*
+Stats holds the aggregates of a Set that have already been computed and
+cached on it, so that they can be reported without querying the database.
+Count and Entropy are nil until they have been computed.
+*/
+type Stats struct {
+	Count   *int
+	Entropy *float64
 }
 
 type sqlSet struct {
@@ -31,8 +48,14 @@ type sqlSet struct {
 	inverseDiscreteValues map[string]int
 	dfColumns             []string
 	cfColumns             []string
+	bfColumns             []string
+	weightColumn          string
+	missingValues         map[string]interface{}
+	statsLock             sync.RWMutex
 	count                 *int
 	entropy               *float64
+	mapping               *discreteValueMapping
+	mappingLock           sync.Mutex
 }
 
 /*
@@ -57,6 +80,33 @@ func Open(ctx context.Context, dbAdapter Adapter, features []feature.Feature) (S
 	return ss, nil
 }
 
+/*
+OpenWithWeightFeature takes an Adapter to a db backend, a slice of
+feature.Feature and a continuous weight feature among them and returns a
+Set backed by the given adapter whose samples weigh the value they have for
+the weight feature, or 1 if they have none, or an error if no set is
+available through the given adapter.
+
+This function has the same expectations on the adapter as Open.
+*/
+func OpenWithWeightFeature(ctx context.Context, dbAdapter Adapter, features []feature.Feature, weightFeature *feature.ContinuousFeature) (Set, error) {
+	ss := &sqlSet{db: dbAdapter, features: features}
+	err := ss.initFeatureColumns()
+	if err != nil {
+		return nil, err
+	}
+	column, ok := ss.featureNamesColumns[weightFeature.Name()]
+	if !ok {
+		return nil, fmt.Errorf("weight feature %s is not among the set features: %w", weightFeature.Name(), feature.ErrUnknownFeature)
+	}
+	ss.weightColumn = column
+	err = ss.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
 /*
 Create takes an Adapter and a slice of feature.Feature and returns a Set
 backed by the given adapter or an error.
@@ -78,92 +128,134 @@ func Create(ctx context.Context, dbAdapter Adapter, features []feature.Feature)
 	return ss, nil
 }
 
+/*
+CreateIndexes takes a context and a Set created with Create or Open and
+ensures that its samples table has an index on the column of every feature,
+so that the queries for the subsets of the set do not need to scan the whole
+table. Indexes slow down the insertion of samples, so it should be called
+once the samples have been written, after flushing any BufferedWriter on the
+set. It returns an error if the set was not created by this package or the
+indexes cannot be created.
+*/
+func CreateIndexes(ctx context.Context, s Set) error {
+	ss, ok := s.(*sqlSet)
+	if !ok {
+		return fmt.Errorf("cannot create indexes on set of type %T", s)
+	}
+	columns := make([]string, 0, len(ss.dfColumns)+len(ss.cfColumns)+len(ss.bfColumns))
+	columns = append(columns, ss.dfColumns...)
+	columns = append(columns, ss.cfColumns...)
+	columns = append(columns, ss.bfColumns...)
+	return ss.db.CreateIndexes(ctx, columns)
+}
+
 func (ss *sqlSet) Count(ctx context.Context) (int, error) {
-	if ss.count != nil {
-		return *ss.count, nil
+	if stats := ss.Stats(); stats.Count != nil {
+		return *stats.Count, nil
 	}
 	result, err := ss.db.CountSamples(ctx, ss.criteria)
 	if err == nil {
+		ss.statsLock.Lock()
 		ss.count = &result
+		ss.statsLock.Unlock()
 	}
 	return result, err
 }
 
+func (ss *sqlSet) Weight(ctx context.Context) (float64, error) {
+	if ss.weightColumn == "" {
+		count, err := ss.Count(ctx)
+		return float64(count), err
+	}
+	return ss.db.SumSampleWeights(ctx, ss.weightColumn, ss.criteria)
+}
+
 func (ss *sqlSet) Entropy(ctx context.Context, f feature.Feature) (float64, error) {
-	if ss.entropy != nil {
-		return *ss.entropy, nil
+	if stats := ss.Stats(); stats.Entropy != nil {
+		return *stats.Entropy, nil
 	}
-	var result, count float64
-	column, ok := ss.featureNamesColumns[f.Name()]
-	if !ok {
-		return 0.0, fmt.Errorf("unknown feature %s", f.Name())
+	var result, total float64
+	featureValueWeights, err := ss.FeatureValueWeights(ctx, f)
+	if err != nil {
+		return 0.0, err
 	}
-	if _, ok = f.(*feature.DiscreteFeature); ok {
-		featureValueCounts, err := ss.db.CountSampleDiscreteFeatureValues(ctx, column, ss.criteria)
-		if err != nil {
-			return 0.0, err
-		}
-		for _, c := range featureValueCounts {
-			count += float64(c)
-		}
-		for _, c := range featureValueCounts {
-			probValue := float64(c) / count
-			result -= probValue * math.Log(probValue)
-		}
-	} else {
-		featureValueCounts, err := ss.db.CountSampleContinuousFeatureValues(ctx, column, ss.criteria)
-		if err != nil {
-			return 0.0, err
-		}
-		for _, c := range featureValueCounts {
-			count += float64(c)
-		}
-		for _, c := range featureValueCounts {
-			probValue := float64(c) / count
-			result -= probValue * math.Log(probValue)
+	for _, w := range featureValueWeights {
+		total += w
+	}
+	for _, w := range featureValueWeights {
+		if w <= 0 {
+			continue
 		}
+		probValue := w / total
+		result -= probValue * math.Log(probValue)
 	}
+	ss.statsLock.Lock()
 	ss.entropy = &result
+	ss.statsLock.Unlock()
 	return result, nil
 }
 
+/*
+Stats returns the aggregates of the set cached so far. It is safe to call
+while other goroutines compute them.
+*/
+func (ss *sqlSet) Stats() Stats {
+	ss.statsLock.RLock()
+	defer ss.statsLock.RUnlock()
+	return Stats{Count: ss.count, Entropy: ss.entropy}
+}
+
 func (ss *sqlSet) FeatureValues(ctx context.Context, f feature.Feature) ([]interface{}, error) {
 	var err error
 	var result []interface{}
 	column, ok := ss.featureNamesColumns[f.Name()]
 	if !ok {
-		return nil, fmt.Errorf("unknown feature %s", f.Name())
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, f.Name())
 	}
-	if _, ok = f.(*feature.DiscreteFeature); ok {
+	switch f.(type) {
+	case *feature.DiscreteFeature:
 		var values []int
 		values, err = ss.db.ListSampleDiscreteFeatureValues(ctx, column, ss.criteria)
 		if err != nil {
 			return nil, err
 		}
+		for _, v := range values {
+			if !isMissingValue(ss.missingValues, column, v) {
+				result = append(result, v)
+			}
+		}
+	case *feature.BooleanFeature:
+		var values []bool
+		values, err = ss.db.ListSampleBooleanFeatureValues(ctx, column, ss.criteria)
+		if err != nil {
+			return nil, err
+		}
 		for _, v := range values {
 			result = append(result, v)
 		}
-	} else {
+	default:
 		var values []float64
 		values, err = ss.db.ListSampleContinuousFeatureValues(ctx, column, ss.criteria)
 		if err != nil {
 			return nil, err
 		}
 		for _, v := range values {
-			result = append(result, v)
+			if !isMissingValue(ss.missingValues, column, v) {
+				result = append(result, v)
+			}
 		}
 	}
 	return result, nil
 }
 
 func (ss *sqlSet) Samples(ctx context.Context) ([]set.Sample, error) {
-	rawSamples, err := ss.db.ListSamples(ctx, ss.criteria, ss.dfColumns, ss.cfColumns)
+	rawSamples, err := ss.db.ListSamples(ctx, ss.criteria, ss.dfColumns, ss.cfColumns, ss.bfColumns)
 	if err != nil {
 		return nil, err
 	}
 	samples := make([]set.Sample, 0, len(rawSamples))
 	for _, s := range rawSamples {
-		samples = append(samples, &Sample{Values: s, DiscreteFeatureValues: ss.discreteValues, FeatureNamesColumns: ss.featureNamesColumns})
+		samples = append(samples, &Sample{Values: s, DiscreteFeatureValues: ss.discreteValues, FeatureNamesColumns: ss.featureNamesColumns, WeightColumn: ss.weightColumn, MissingValues: ss.missingValues})
 	}
 	return samples, nil
 }
@@ -175,7 +267,7 @@ func (ss *sqlSet) SubsetWith(ctx context.Context, fc feature.Criterion) (set.Set
 	}
 	subsetCriteria := make([]*FeatureCriterion, 0, len(ss.criteria)+len(rfc))
 	subsetCriteria = append(subsetCriteria, ss.criteria...)
-	subsetCriteria = append(subsetCriteria, rfc...)
+	subsetCriteria = append(subsetCriteria, ss.withMissingValues(rfc)...)
 	return &sqlSet{
 		db:                    ss.db,
 		features:              ss.features,
@@ -186,6 +278,9 @@ func (ss *sqlSet) SubsetWith(ctx context.Context, fc feature.Criterion) (set.Set
 		columnFeatures:        ss.columnFeatures,
 		dfColumns:             ss.dfColumns,
 		cfColumns:             ss.cfColumns,
+		bfColumns:             ss.bfColumns,
+		weightColumn:          ss.weightColumn,
+		missingValues:         ss.missingValues,
 	}, nil
 }
 
@@ -193,28 +288,282 @@ func (ss *sqlSet) CountFeatureValues(ctx context.Context, f feature.Feature) (ma
 	result := make(map[string]int)
 	column, ok := ss.featureNamesColumns[f.Name()]
 	if !ok {
-		return nil, fmt.Errorf("unknown feature %s", f.Name())
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, f.Name())
 	}
-	if _, ok = f.(*feature.DiscreteFeature); ok {
+	switch f.(type) {
+	case *feature.DiscreteFeature:
 		featureValueCounts, err := ss.db.CountSampleDiscreteFeatureValues(ctx, column, ss.criteria)
 		if err != nil {
 			return nil, err
 		}
 		for k, v := range featureValueCounts {
-			result[ss.discreteValues[k]] = v
+			if !isMissingValue(ss.missingValues, column, k) {
+				result[ss.discreteValues[k]] = v
+			}
 		}
-	} else {
+	case *feature.BooleanFeature:
+		featureValueCounts, err := ss.db.CountSampleBooleanFeatureValues(ctx, column, ss.criteria)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range featureValueCounts {
+			result[strconv.FormatBool(k)] = v
+		}
+	default:
 		featureValueCounts, err := ss.db.CountSampleContinuousFeatureValues(ctx, column, ss.criteria)
 		if err != nil {
 			return nil, err
 		}
 		for k, v := range featureValueCounts {
-			result[fmt.Sprintf("%f", k)] = v
+			if !isMissingValue(ss.missingValues, column, k) {
+				result[fmt.Sprintf("%f", k)] = v
+			}
+		}
+	}
+	return result, nil
+}
+
+func (ss *sqlSet) FeatureValueWeights(ctx context.Context, f feature.Feature) (map[string]float64, error) {
+	result := make(map[string]float64)
+	if ss.weightColumn == "" {
+		featureValueCounts, err := ss.CountFeatureValues(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range featureValueCounts {
+			result[k] = float64(v)
+		}
+		return result, nil
+	}
+	column, ok := ss.featureNamesColumns[f.Name()]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, f.Name())
+	}
+	switch f.(type) {
+	case *feature.DiscreteFeature:
+		featureValueWeights, err := ss.db.SumSampleDiscreteFeatureValueWeights(ctx, column, ss.weightColumn, ss.criteria)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range featureValueWeights {
+			if !isMissingValue(ss.missingValues, column, k) {
+				result[ss.discreteValues[k]] = v
+			}
+		}
+	case *feature.BooleanFeature:
+		featureValueWeights, err := ss.db.SumSampleBooleanFeatureValueWeights(ctx, column, ss.weightColumn, ss.criteria)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range featureValueWeights {
+			result[strconv.FormatBool(k)] = v
+		}
+	default:
+		featureValueWeights, err := ss.db.SumSampleContinuousFeatureValueWeights(ctx, column, ss.weightColumn, ss.criteria)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range featureValueWeights {
+			if !isMissingValue(ss.missingValues, column, k) {
+				result[fmt.Sprintf("%f", k)] = v
+			}
 		}
 	}
 	return result, nil
 }
 
+/*
+LabelHistogramByFeatureValue implements set.Aggregator with a single query
+grouping the samples of the set by their values for the feature and the
+label feature. Missing values of either feature are left out as undefined
+ones.
+*/
+func (ss *sqlSet) LabelHistogramByFeatureValue(ctx context.Context, f *feature.ContinuousFeature, labelFeature feature.Feature) (map[float64]*set.LabelHistogram, error) {
+	column, ok := ss.featureNamesColumns[f.Name()]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, f.Name())
+	}
+	labelColumn, ok := ss.featureNamesColumns[labelFeature.Name()]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, labelFeature.Name())
+	}
+	if _, ok := labelFeature.(*feature.BooleanFeature); ok {
+		return ss.booleanLabelHistogramByFeatureValue(ctx, column, labelColumn)
+	}
+	histogram, err := ss.labelHistogramByColumnValue(ctx, column, labelColumn, labelFeature)
+	if err != nil {
+		return nil, err
+	}
+	for v := range histogram {
+		if isMissingValue(ss.missingValues, column, v) {
+			delete(histogram, v)
+		}
+	}
+	return histogram, nil
+}
+
+/*
+LabelHistogramByDiscreteValue implements set.Aggregator with a single query
+grouping the samples of the set by the IDs of their values for the feature
+and their values for the label feature, or with a query for the whole set
+and another for every value of a boolean label feature. Missing values of
+either feature are left out as undefined ones.
+*/
+func (ss *sqlSet) LabelHistogramByDiscreteValue(ctx context.Context, f *feature.DiscreteFeature, labelFeature feature.Feature) (map[string]*set.LabelHistogram, error) {
+	column, ok := ss.featureNamesColumns[f.Name()]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, f.Name())
+	}
+	labelColumn, ok := ss.featureNamesColumns[labelFeature.Name()]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", feature.ErrUnknownFeature, labelFeature.Name())
+	}
+	if bf, ok := labelFeature.(*feature.BooleanFeature); ok {
+		return ss.booleanLabelHistogramByDiscreteValue(ctx, f, bf)
+	}
+	histogram, err := ss.labelHistogramByColumnValue(ctx, column, labelColumn, labelFeature)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*set.LabelHistogram, len(histogram))
+	for id, lh := range histogram {
+		if isMissingValue(ss.missingValues, column, int(id)) {
+			continue
+		}
+		if v, ok := ss.discreteValues[int(id)]; ok {
+			result[v] = lh
+		}
+	}
+	return result, nil
+}
+
+/*
+labelHistogramByColumnValue takes a context, the column of a continuous or
+discrete feature and the column and feature of a discrete or continuous
+label and returns the label histogram of the set by value of the column,
+with the IDs of the values of discrete features as float64 values. Missing
+values of the label are left out as undefined ones, but not those of the
+column.
+*/
+func (ss *sqlSet) labelHistogramByColumnValue(ctx context.Context, column, labelColumn string, labelFeature feature.Feature) (map[float64]*set.LabelHistogram, error) {
+	_, discreteLabel := labelFeature.(*feature.DiscreteFeature)
+	valueLabelWeights, err := ss.db.SumSampleContinuousFeatureValueLabelWeights(ctx, column, labelColumn, ss.weightColumn, ss.criteria)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]*set.LabelHistogram, len(valueLabelWeights))
+	for v, lw := range valueLabelWeights {
+		lh := &set.LabelHistogram{Weight: lw.Weight, LabelWeights: make(map[string]float64, len(lw.Labels))}
+		for l, w := range lw.Labels {
+			if discreteLabel {
+				if isMissingValue(ss.missingValues, labelColumn, int(l)) {
+					continue
+				}
+				lh.LabelWeights[ss.discreteValues[int(l)]] += w
+			} else {
+				if isMissingValue(ss.missingValues, labelColumn, l) {
+					continue
+				}
+				lh.LabelWeights[fmt.Sprintf("%f", l)] += w
+			}
+		}
+		result[v] = lh
+	}
+	return result, nil
+}
+
+/*
+booleanLabelHistogramByDiscreteValue takes a context, a discrete feature and
+a boolean label feature and returns the label histogram of the set by value
+of the feature, adding up the weights by value of the feature of the whole
+set and of the subsets with each label value, as
+booleanLabelHistogramByFeatureValue does.
+*/
+func (ss *sqlSet) booleanLabelHistogramByDiscreteValue(ctx context.Context, f *feature.DiscreteFeature, labelFeature *feature.BooleanFeature) (map[string]*set.LabelHistogram, error) {
+	weights, err := ss.FeatureValueWeights(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*set.LabelHistogram, len(weights))
+	for v, w := range weights {
+		result[v] = &set.LabelHistogram{Weight: w, LabelWeights: make(map[string]float64, 2)}
+	}
+	for _, label := range []bool{true, false} {
+		subset, err := ss.SubsetWith(ctx, feature.NewBooleanCriterion(labelFeature, label))
+		if err != nil {
+			return nil, err
+		}
+		labelWeights, err := subset.FeatureValueWeights(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		for v, w := range labelWeights {
+			if lh, ok := result[v]; ok {
+				lh.LabelWeights[strconv.FormatBool(label)] += w
+			}
+		}
+	}
+	return result, nil
+}
+
+/*
+booleanLabelHistogramByFeatureValue takes a context, the column of a
+continuous feature and the column of a boolean label feature and returns the
+label histogram of the set by value of the feature. As the label weights
+returned by adapters are keyed by float64 values, which boolean columns
+cannot be read as on every database, it adds up the weights by value of the
+feature of the whole set and of the samples with each label value instead.
+*/
+func (ss *sqlSet) booleanLabelHistogramByFeatureValue(ctx context.Context, column, labelColumn string) (map[float64]*set.LabelHistogram, error) {
+	weights, err := ss.continuousFeatureValueWeights(ctx, column, ss.criteria)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]*set.LabelHistogram, len(weights))
+	for v, w := range weights {
+		if isMissingValue(ss.missingValues, column, v) {
+			continue
+		}
+		result[v] = &set.LabelHistogram{Weight: w, LabelWeights: make(map[string]float64, 2)}
+	}
+	for _, label := range []bool{true, false} {
+		criteria := make([]*FeatureCriterion, 0, len(ss.criteria)+1)
+		criteria = append(criteria, ss.criteria...)
+		criteria = append(criteria, &FeatureCriterion{labelColumn, false, "=", label, false, nil})
+		labelWeights, err := ss.continuousFeatureValueWeights(ctx, column, criteria)
+		if err != nil {
+			return nil, err
+		}
+		for v, w := range labelWeights {
+			if lh, ok := result[v]; ok {
+				lh.LabelWeights[strconv.FormatBool(label)] += w
+			}
+		}
+	}
+	return result, nil
+}
+
+/*
+continuousFeatureValueWeights takes a context, the column of a continuous
+feature and a slice of feature criteria and returns the weights of the
+samples satisfying the criteria by value of the feature, which are their
+counts if the set has no weight column.
+*/
+func (ss *sqlSet) continuousFeatureValueWeights(ctx context.Context, column string, criteria []*FeatureCriterion) (map[float64]float64, error) {
+	if ss.weightColumn != "" {
+		return ss.db.SumSampleContinuousFeatureValueWeights(ctx, column, ss.weightColumn, criteria)
+	}
+	counts, err := ss.db.CountSampleContinuousFeatureValues(ctx, column, criteria)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]float64, len(counts))
+	for v, c := range counts {
+		result[v] = float64(c)
+	}
+	return result, nil
+}
+
 func (ss *sqlSet) Write(ctx context.Context, samples []set.Sample) (int, error) {
 	if len(samples) == 0 {
 		return 0, nil
@@ -227,7 +576,7 @@ func (ss *sqlSet) Write(ctx context.Context, samples []set.Sample) (int, error)
 		}
 		rawSamples = append(rawSamples, rs)
 	}
-	return ss.db.AddSamples(ctx, rawSamples, ss.dfColumns, ss.cfColumns)
+	return ss.db.AddSamples(ctx, rawSamples, ss.dfColumns, ss.cfColumns, ss.bfColumns)
 }
 
 func (ss *sqlSet) Read(ctx context.Context) (<-chan set.Sample, <-chan error) {
@@ -239,11 +588,14 @@ func (ss *sqlSet) Read(ctx context.Context) (<-chan set.Sample, <-chan error) {
 			ss.criteria,
 			ss.dfColumns,
 			ss.cfColumns,
+			ss.bfColumns,
 			func(n int, rs map[string]interface{}) (bool, error) {
 				s := &Sample{
 					Values:                rs,
 					DiscreteFeatureValues: ss.discreteValues,
-					FeatureNamesColumns:   ss.featureNamesColumns}
+					FeatureNamesColumns:   ss.featureNamesColumns,
+					WeightColumn:          ss.weightColumn,
+					MissingValues:         ss.missingValues}
 				select {
 				case <-ctx.Done():
 					return false, nil
@@ -269,7 +621,7 @@ func (ss *sqlSet) initDB(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	err = ss.db.CreateSampleTable(ctx, ss.dfColumns, ss.cfColumns)
+	err = ss.db.CreateSampleTable(ctx, ss.dfColumns, ss.cfColumns, ss.bfColumns)
 	if err != nil {
 		return err
 	}
@@ -294,7 +646,11 @@ func (ss *sqlSet) unavailableDiscreteValues() []string {
 	for _, f := range ss.features {
 		df, ok := f.(*feature.DiscreteFeature)
 		if ok {
-			for _, fv := range df.AvailableValues() {
+			values := df.AvailableValues()
+			if mv, ok := df.MissingValue(); ok {
+				values = append(values[:len(values):len(values)], mv)
+			}
+			for _, fv := range values {
 				var present bool
 				for _, pv := range ss.discreteValues {
 					if fv == pv {
@@ -329,24 +685,32 @@ func (ss *sqlSet) init(ctx context.Context) error {
 	for k, v := range ss.discreteValues {
 		ss.inverseDiscreteValues[v] = k
 	}
+	ss.initMissingValues()
 	return nil
 }
 
 func (ss *sqlSet) newRawSample(s set.Sample) (map[string]interface{}, error) {
+	if sqls, ok := s.(*Sample); ok {
+		return ss.newMappedRawSample(sqls)
+	}
 	rs := make(map[string]interface{})
 	for _, f := range ss.features {
 		v, err := s.ValueFor(f)
 		if err != nil {
 			return nil, err
 		}
-		if v != nil {
-			_, ok := f.(*feature.DiscreteFeature)
+		if v == nil {
+			if mv, ok := ss.missingValues[ss.featureNamesColumns[f.Name()]]; ok {
+				rs[f.Name()] = mv
+			}
+		} else {
+			df, ok := f.(*feature.DiscreteFeature)
 			if ok {
 				vs, ok := v.(string)
 				if !ok {
 					return nil, fmt.Errorf("expected string value for discrete feature %s of sample, got %T", f.Name(), v)
 				}
-				v, ok = ss.inverseDiscreteValues[vs]
+				v, ok = ss.inverseDiscreteValues[df.HashValue(vs)]
 			}
 			rs[f.Name()] = v
 		}
@@ -370,9 +734,12 @@ func (ss *sqlSet) initFeatureColumns() error {
 		ss.featureNamesColumns[f.Name()] = column
 	}
 	for _, f := range ss.features {
-		if _, ok := f.(*feature.DiscreteFeature); ok {
+		switch f.(type) {
+		case *feature.DiscreteFeature:
 			ss.dfColumns = append(ss.dfColumns, ss.featureNamesColumns[f.Name()])
-		} else {
+		case *feature.BooleanFeature:
+			ss.bfColumns = append(ss.bfColumns, ss.featureNamesColumns[f.Name()])
+		default:
 			ss.cfColumns = append(ss.cfColumns, ss.featureNamesColumns[f.Name()])
 		}
 	}
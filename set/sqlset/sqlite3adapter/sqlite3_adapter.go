@@ -19,7 +19,7 @@ import (
 )
 
 const (
-	discreteValueTableCreateStmt = `CREATE TABLE IF NOT EXISTS discreteValues (
+	discreteValueTableCreateStmt = `CREATE TABLE IF NOT EXISTS %s (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		value TEXT UNIQUE NOT NULL)`
 
@@ -37,7 +37,44 @@ const (
 )
 
 type adapter struct {
-	db *sql.DB
+	db                     *sql.DB
+	nonTransactionalWrites bool
+	samplesTable           string
+	discreteValuesTable    string
+	queryExplainer         *sqlset.QueryExplainer
+}
+
+/*
+preparer is implemented by sql.DB and sql.Tx, so that samples can be
+inserted with or without a transaction.
+*/
+type preparer interface {
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+}
+
+/*
+Options holds the settings of an adapter.
+
+MaxConn, if greater than 0, is the maximum number of concurrent connections
+to the database that will be used.
+
+NonTransactionalWrites disables the transaction in which the AddSamples
+method of the adapter inserts every slice of samples. Without it, samples
+inserted before a failure remain on the database.
+
+TablePrefix, if not empty, is prepended with an underscore to the names of
+the samples and discreteValues tables, so that several sets can be kept on
+the same database. It may only contain lowercase letters, digits and
+underscores, and cannot start with a digit.
+
+QueryExplainer, if not nil, logs the queries the adapter runs to read the
+samples of the set and their aggregates together with their plans.
+*/
+type Options struct {
+	MaxConn                int
+	NonTransactionalWrites bool
+	TablePrefix            string
+	QueryExplainer         *sqlset.QueryExplainer
 }
 
 /*
@@ -50,12 +87,35 @@ This limit is useful when the OS limits the number of files a process
 can open, which is the case for Mac OS X.
 */
 func New(path string, maxConn int) (sqlset.Adapter, error) {
+	return NewWithOptions(path, &Options{MaxConn: maxConn})
+}
+
+/*
+NewWithOptions takes a path to an SQLite3 database file and options and
+returns an Adapter that works on the file's database with the options, or
+an error if it fails to open as an sqlite3 database. Nil options are the
+same as the zero Options.
+*/
+func NewWithOptions(path string, opts *Options) (sqlset.Adapter, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	samplesTable, discreteValuesTable, err := sqlset.TableNames(opts.TablePrefix)
+	if err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(maxConn)
-	return &adapter{db}, nil
+	db.SetMaxOpenConns(opts.MaxConn)
+	return &adapter{
+		db:                     db,
+		nonTransactionalWrites: opts.NonTransactionalWrites,
+		samplesTable:           samplesTable,
+		discreteValuesTable:    discreteValuesTable,
+		queryExplainer:         opts.QueryExplainer,
+	}, nil
 }
 
 func (a *adapter) ColumnName(featureName string) (string, error) {
@@ -69,7 +129,7 @@ func (a *adapter) ColumnName(featureName string) (string, error) {
 }
 
 func (a *adapter) CreateDiscreteValuesTable(ctx context.Context) error {
-	createStmt, err := a.db.PrepareContext(ctx, discreteValueTableCreateStmt)
+	createStmt, err := a.db.PrepareContext(ctx, fmt.Sprintf(discreteValueTableCreateStmt, a.discreteValuesTable))
 	if err != nil {
 		return fmt.Errorf("preparing discreteValues creation statement: %v", err)
 	}
@@ -81,19 +141,22 @@ func (a *adapter) CreateDiscreteValuesTable(ctx context.Context) error {
 	return nil
 }
 
-func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns []string) error {
+func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) error {
 	var createStmtBuf bytes.Buffer
 	_, err := a.db.ExecContext(ctx, "PRAGMA foreign_keys=ON")
 	if err != nil {
 		return err
 	}
-	createStmtBuf.WriteString("CREATE TABLE IF NOT EXISTS samples(")
+	createStmtBuf.WriteString("CREATE TABLE IF NOT EXISTS " + a.samplesTable + "(")
 	for _, c := range discreteFeatureColumns {
-		createStmtBuf.WriteString(fmt.Sprintf(`"%s" INTEGER NULL REFERENCES discreteValues(id), `, c))
+		createStmtBuf.WriteString(fmt.Sprintf(`"%s" INTEGER NULL REFERENCES %s(id), `, c, a.discreteValuesTable))
 	}
 	for _, c := range continuousFeatureColumns {
 		createStmtBuf.WriteString(fmt.Sprintf(`"%s" REAL NULL, `, c))
 	}
+	for _, c := range booleanFeatureColumns {
+		createStmtBuf.WriteString(fmt.Sprintf(`"%s" BOOLEAN NULL, `, c))
+	}
 	createStmtBuf.WriteString(`"id" INTEGER PRIMARY KEY AUTOINCREMENT)`)
 	createStmt, err := a.db.PrepareContext(ctx, createStmtBuf.String())
 	if err != nil {
@@ -107,6 +170,16 @@ func (a *adapter) CreateSampleTable(ctx context.Context, discreteFeatureColumns,
 	return nil
 }
 
+func (a *adapter) CreateIndexes(ctx context.Context, columns []string) error {
+	for _, c := range columns {
+		_, err := a.db.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_%s_idx" ON %s ("%s")`, a.samplesTable, c, a.samplesTable, c))
+		if err != nil {
+			return fmt.Errorf("creating index on samples column %s: %v", c, err)
+		}
+	}
+	return nil
+}
+
 func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int, error) {
 	var (
 		chunkStart       = 0
@@ -116,7 +189,7 @@ func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int,
 	if len(values) == 0 {
 		return 0, nil
 	}
-	insertStmtStart := "INSERT INTO discreteValues (value) VALUES (?)"
+	insertStmtStart := "INSERT OR IGNORE INTO " + a.discreteValuesTable + " (value) VALUES (?)"
 	if len(values) > MaxDiscreteValueInsertionsPerStatement {
 		insertStmtBuffer.WriteString(insertStmtStart)
 		for i := 1; i < MaxDiscreteValueInsertionsPerStatement; i++ {
@@ -172,7 +245,7 @@ func (a *adapter) AddDiscreteValues(ctx context.Context, values []string) (int,
 }
 
 func (a *adapter) ListDiscreteValues(ctx context.Context) (map[int]string, error) {
-	rows, err := a.db.QueryContext(ctx, `SELECT id, value FROM discreteValues`)
+	rows, err := a.db.QueryContext(ctx, `SELECT id, value FROM `+a.discreteValuesTable)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +267,34 @@ func (a *adapter) ListDiscreteValues(ctx context.Context) (map[int]string, error
 	return result, err
 }
 
-func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns []string) (int, error) {
+func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interface{}, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) (int, error) {
+	columns := make([]string, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns)+len(booleanFeatureColumns))
+	columns = append(columns, discreteFeatureColumns...)
+	columns = append(columns, continuousFeatureColumns...)
+	columns = append(columns, booleanFeatureColumns...)
+	if a.nonTransactionalWrites {
+		return a.addSamples(ctx, a.db, rawSamples, columns)
+	}
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction to insert samples: %v", err)
+	}
+	n, err := a.addSamples(ctx, tx, rawSamples, columns)
+	if err != nil {
+		tx.Rollback()
+		if pwe, ok := err.(*sqlset.PartialWriteError); ok {
+			pwe.Written = 0
+		}
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction to insert samples: %v", err)
+	}
+	return n, nil
+}
+
+func (a *adapter) addSamples(ctx context.Context, p preparer, rawSamples []map[string]interface{}, columns []string) (int, error) {
 	var (
 		chunkStart            = 0
 		chunkEnd              = MaxSampleInsertionsPerStatement
@@ -204,17 +304,13 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 	if len(rawSamples) == 0 {
 		return 0, nil
 	}
-	if len(discreteFeatureColumns)+len(continuousFeatureColumns) == 0 {
+	if len(columns) == 0 {
 		return 0, fmt.Errorf("no features to store")
 	}
-	insertStmtStartBuffer.WriteString(`INSERT INTO samples ("`)
-	insertStmtStartBuffer.WriteString(strings.Join(discreteFeatureColumns, `", "`))
-	if len(discreteFeatureColumns) > 0 && len(continuousFeatureColumns) > 0 {
-		insertStmtStartBuffer.WriteString(`", "`)
-	}
-	insertStmtStartBuffer.WriteString(strings.Join(continuousFeatureColumns, `", "`))
+	insertStmtStartBuffer.WriteString(`INSERT INTO ` + a.samplesTable + ` ("`)
+	insertStmtStartBuffer.WriteString(strings.Join(columns, `", "`))
 	insertStmtStartBuffer.WriteString(`") VALUES (?`)
-	for i := 1; i < len(discreteFeatureColumns)+len(continuousFeatureColumns); i++ {
+	for i := 1; i < len(columns); i++ {
 		insertStmtStartBuffer.WriteString(", ?")
 	}
 	insertStmtStartBuffer.WriteString(`)`)
@@ -223,28 +319,31 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 		insertStmtBuffer.WriteString(insertStmtStart)
 		for i := 1; i < MaxSampleInsertionsPerStatement; i++ {
 			insertStmtBuffer.WriteString(", (?")
-			for j := 1; j < len(discreteFeatureColumns)+len(continuousFeatureColumns); j++ {
-				insertStmtStartBuffer.WriteString(", ?")
+			for j := 1; j < len(columns); j++ {
+				insertStmtBuffer.WriteString(", ?")
 			}
-			insertStmtStartBuffer.WriteString(`)`)
+			insertStmtBuffer.WriteString(`)`)
 		}
-		insertStmt, err := a.db.PrepareContext(ctx, insertStmtBuffer.String())
+		insertStmt, err := p.PrepareContext(ctx, insertStmtBuffer.String())
 		if err != nil {
 			return 0, fmt.Errorf("preparing insert command for %d samples: %v", MaxSampleInsertionsPerStatement, err)
 		}
 		for c := 0; c < len(rawSamples)/MaxSampleInsertionsPerStatement; c++ {
-			irs := make([]interface{}, 0, MaxSampleInsertionsPerStatement*(len(discreteFeatureColumns)+len(continuousFeatureColumns)))
+			irs := make([]interface{}, 0, MaxSampleInsertionsPerStatement*(len(columns)))
 			for _, rs := range rawSamples[chunkStart:chunkEnd] {
-				for _, f := range discreteFeatureColumns {
-					irs = append(irs, rs[f])
-				}
-				for _, f := range continuousFeatureColumns {
+				for _, f := range columns {
 					irs = append(irs, rs[f])
 				}
 			}
 			_, err = insertStmt.ExecContext(ctx, irs...)
 			if err != nil {
-				return chunkStart, fmt.Errorf("inserting the %dth %d samples: %v", c+1, MaxSampleInsertionsPerStatement, err)
+				insertStmt.Close()
+				return chunkStart, &sqlset.PartialWriteError{
+					Written: chunkStart,
+					Offset:  chunkStart,
+					Chunk:   rawSamples[chunkStart:chunkEnd],
+					Err:     fmt.Errorf("inserting the %dth %d samples: %v", c+1, MaxSampleInsertionsPerStatement, err),
+				}
 			}
 			chunkStart += MaxSampleInsertionsPerStatement
 			chunkEnd += MaxSampleInsertionsPerStatement
@@ -261,27 +360,30 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 		insertStmtBuffer.WriteString(insertStmtStart)
 		for i := 1; i < len(lastRawSamples); i++ {
 			insertStmtBuffer.WriteString(", (?")
-			for j := 1; j < len(discreteFeatureColumns)+len(continuousFeatureColumns); j++ {
-				insertStmtStartBuffer.WriteString(", ?")
+			for j := 1; j < len(columns); j++ {
+				insertStmtBuffer.WriteString(", ?")
 			}
-			insertStmtStartBuffer.WriteString(`)`)
+			insertStmtBuffer.WriteString(`)`)
 		}
-		insertStmt, err := a.db.PrepareContext(ctx, insertStmtBuffer.String())
+		insertStmt, err := p.PrepareContext(ctx, insertStmtBuffer.String())
 		if err != nil {
 			return chunkStart, fmt.Errorf("preparing insert command for %d values: %v", len(lastRawSamples), err)
 		}
-		ilrs := make([]interface{}, 0, len(lastRawSamples)*(len(discreteFeatureColumns)+len(continuousFeatureColumns)))
+		ilrs := make([]interface{}, 0, len(lastRawSamples)*(len(columns)))
 		for _, rs := range rawSamples[chunkStart:chunkEnd] {
-			for _, f := range discreteFeatureColumns {
-				ilrs = append(ilrs, rs[f])
-			}
-			for _, f := range continuousFeatureColumns {
+			for _, f := range columns {
 				ilrs = append(ilrs, rs[f])
 			}
 		}
 		_, err = insertStmt.ExecContext(ctx, ilrs...)
 		if err != nil {
-			return chunkStart, fmt.Errorf("inserting the last %d values: %v", len(lastRawSamples), err)
+			insertStmt.Close()
+			return chunkStart, &sqlset.PartialWriteError{
+				Written: chunkStart,
+				Offset:  chunkStart,
+				Chunk:   lastRawSamples,
+				Err:     fmt.Errorf("inserting the last %d values: %v", len(lastRawSamples), err),
+			}
 		}
 		err = insertStmt.Close()
 		if err != nil {
@@ -291,13 +393,14 @@ func (a *adapter) AddSamples(ctx context.Context, rawSamples []map[string]interf
 	return chunkEnd, nil
 }
 
-func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns []string) ([]map[string]interface{}, error) {
+func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string) ([]map[string]interface{}, error) {
 	var result []map[string]interface{}
 	err := a.IterateOnSamples(
 		ctx,
 		criteria,
 		discreteFeatureColumns,
 		continuousFeatureColumns,
+		booleanFeatureColumns,
 		func(_ int, rawSample map[string]interface{}) (bool, error) {
 			result = append(result, rawSample)
 			return true, nil
@@ -308,22 +411,22 @@ func (a *adapter) ListSamples(ctx context.Context, criteria []*sqlset.FeatureCri
 	return result, nil
 }
 
-func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error {
+func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion, discreteFeatureColumns, continuousFeatureColumns, booleanFeatureColumns []string, lambda func(int, map[string]interface{}) (bool, error)) error {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
+	columns := make([]string, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns)+len(booleanFeatureColumns))
+	columns = append(columns, discreteFeatureColumns...)
+	columns = append(columns, continuousFeatureColumns...)
+	columns = append(columns, booleanFeatureColumns...)
 	queryBuffer.WriteString(`SELECT "`)
-	queryBuffer.WriteString(strings.Join(discreteFeatureColumns, `", "`))
-	if len(discreteFeatureColumns) > 0 && len(continuousFeatureColumns) > 0 {
-		queryBuffer.WriteString(`", "`)
-	}
-	queryBuffer.WriteString(strings.Join(continuousFeatureColumns, `", "`))
-	queryBuffer.WriteString(`" FROM samples`)
+	queryBuffer.WriteString(strings.Join(columns, `", "`))
+	queryBuffer.WriteString(`" FROM ` + a.samplesTable)
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return err
 	}
@@ -331,13 +434,17 @@ func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.Featu
 		rawSample := make(map[string]interface{})
 		discreteValues := make([]sql.NullInt64, len(discreteFeatureColumns))
 		continuousValues := make([]sql.NullFloat64, len(continuousFeatureColumns))
-		values := make([]interface{}, 0, len(discreteFeatureColumns)+len(continuousFeatureColumns))
+		booleanValues := make([]sql.NullBool, len(booleanFeatureColumns))
+		values := make([]interface{}, 0, len(columns))
 		for i := range discreteValues {
 			values = append(values, &discreteValues[i])
 		}
 		for i := range continuousValues {
 			values = append(values, &continuousValues[i])
 		}
+		for i := range booleanValues {
+			values = append(values, &booleanValues[i])
+		}
 		err = rows.Scan(values...)
 		if err != nil {
 			return err
@@ -352,6 +459,11 @@ func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.Featu
 				rawSample[c] = continuousValues[i].Float64
 			}
 		}
+		for i, c := range booleanFeatureColumns {
+			if booleanValues[i].Valid {
+				rawSample[c] = booleanValues[i].Bool
+			}
+		}
 		ok, err := lambda(j, rawSample)
 		if err != nil {
 			return err
@@ -371,13 +483,13 @@ func (a *adapter) IterateOnSamples(ctx context.Context, criteria []*sqlset.Featu
 func (a *adapter) CountSamples(ctx context.Context, criteria []*sqlset.FeatureCriterion) (int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(`SELECT COUNT(*) FROM samples`)
+	queryBuffer.WriteString(`SELECT COUNT(*) FROM ` + a.samplesTable)
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return 0, err
 	}
@@ -396,13 +508,13 @@ func (a *adapter) CountSamples(ctx context.Context, criteria []*sqlset.FeatureCr
 func (a *adapter) ListSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM samples`, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s`, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -428,13 +540,13 @@ func (a *adapter) ListSampleDiscreteFeatureValues(ctx context.Context, fc string
 func (a *adapter) ListSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]float64, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM samples`, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s`, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -460,14 +572,14 @@ func (a *adapter) ListSampleContinuousFeatureValues(ctx context.Context, fc stri
 func (a *adapter) CountSampleDiscreteFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[int]int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM samples`, fc, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM %s`, fc, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
 	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -494,14 +606,14 @@ func (a *adapter) CountSampleDiscreteFeatureValues(ctx context.Context, fc strin
 func (a *adapter) CountSampleContinuousFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[float64]int, error) {
 	var queryBuffer bytes.Buffer
 	var whereValues []interface{}
-	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM samples`, fc, fc))
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM %s`, fc, fc, a.samplesTable))
 	if len(criteria) > 0 {
 		var whereClause string
 		whereClause, whereValues = buildWhereClause(criteria)
 		queryBuffer.WriteString(whereClause)
 	}
 	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
-	rows, err := a.db.QueryContext(ctx, queryBuffer.String(), whereValues...)
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
 	if err != nil {
 		return nil, err
 	}
@@ -525,6 +637,287 @@ func (a *adapter) CountSampleContinuousFeatureValues(ctx context.Context, fc str
 	return result, err
 }
 
+func (a *adapter) ListSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) ([]bool, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s`, fc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	var result []bool
+	for rows.Next() {
+		var value sql.NullBool
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result = append(result, value.Bool)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) CountSampleBooleanFeatureValues(ctx context.Context, fc string, criteria []*sqlset.FeatureCriterion) (map[bool]int, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", COUNT("%s") FROM %s`, fc, fc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[bool]int)
+	for rows.Next() {
+		var value sql.NullBool
+		var count int
+		err = rows.Scan(&value, &count)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[value.Bool] = count
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleWeights(ctx context.Context, wc string, criteria []*sqlset.FeatureCriterion) (float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT SUM(COALESCE("%s", 1.0)) FROM %s`, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return 0.0, err
+	}
+	if !rows.Next() {
+		return 0.0, rows.Err()
+	}
+	var weight sql.NullFloat64
+	err = rows.Scan(&weight)
+	if err != nil {
+		return 0.0, err
+	}
+	err = rows.Close()
+	return weight.Float64, err
+}
+
+func (a *adapter) SumSampleDiscreteFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[int]float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", SUM(COALESCE("%s", 1.0)) FROM %s`, fc, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]float64)
+	for rows.Next() {
+		var value sql.NullInt64
+		var weight float64
+		err = rows.Scan(&value, &weight)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[int(value.Int64)] = weight
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleContinuousFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[float64]float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", SUM(COALESCE("%s", 1.0)) FROM %s`, fc, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]float64)
+	for rows.Next() {
+		var value sql.NullFloat64
+		var weight float64
+		err = rows.Scan(&value, &weight)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[value.Float64] = weight
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleBooleanFeatureValueWeights(ctx context.Context, fc, wc string, criteria []*sqlset.FeatureCriterion) (map[bool]float64, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", SUM(COALESCE("%s", 1.0)) FROM %s`, fc, wc, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s"`, fc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[bool]float64)
+	for rows.Next() {
+		var value sql.NullBool
+		var weight float64
+		err = rows.Scan(&value, &weight)
+		if err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			result[value.Bool] = weight
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+func (a *adapter) SumSampleContinuousFeatureValueLabelWeights(ctx context.Context, fc, lc, wc string, criteria []*sqlset.FeatureCriterion) (map[float64]*sqlset.LabelWeights, error) {
+	var queryBuffer bytes.Buffer
+	var whereValues []interface{}
+	weight := "COUNT(*)"
+	if wc != "" {
+		weight = fmt.Sprintf(`SUM(COALESCE("%s", 1.0))`, wc)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(`SELECT "%s", "%s", %s FROM %s`, fc, lc, weight, a.samplesTable))
+	if len(criteria) > 0 {
+		var whereClause string
+		whereClause, whereValues = buildWhereClause(criteria)
+		queryBuffer.WriteString(whereClause)
+	}
+	queryBuffer.WriteString(fmt.Sprintf(` GROUP BY "%s", "%s"`, fc, lc))
+	rows, err := a.query(ctx, criteria, queryBuffer.String(), whereValues...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[float64]*sqlset.LabelWeights)
+	for rows.Next() {
+		var value, label sql.NullFloat64
+		var w float64
+		err = rows.Scan(&value, &label, &w)
+		if err != nil {
+			return nil, err
+		}
+		if !value.Valid {
+			continue
+		}
+		lw, ok := result[value.Float64]
+		if !ok {
+			lw = &sqlset.LabelWeights{Labels: make(map[float64]float64)}
+			result[value.Float64] = lw
+		}
+		lw.Weight += w
+		if label.Valid {
+			lw.Labels[label.Float64] += w
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	err = rows.Close()
+	return result, err
+}
+
+/*
+query takes a context, the criteria defining the subset of samples a query
+reads, the query and its arguments and runs the query on the database,
+explaining it first with the adapter's QueryExplainer if it has one.
+*/
+func (a *adapter) query(ctx context.Context, criteria []*sqlset.FeatureCriterion, query string, args ...interface{}) (*sql.Rows, error) {
+	if a.queryExplainer != nil {
+		a.queryExplainer.Explain(ctx, criteria, query, args, a.plan)
+	}
+	return a.db.QueryContext(ctx, query, args...)
+}
+
+/*
+plan takes a context, a query and its arguments and returns the details of
+the steps of the plan SQLite3 follows to run the query, as returned by
+EXPLAIN QUERY PLAN, or an error. SQLite3 cannot analyze queries, so the
+Analyze setting of the adapter's QueryExplainer is ignored.
+*/
+func (a *adapter) plan(ctx context.Context, query string, args []interface{}) ([]string, error) {
+	rows, err := a.db.QueryContext(ctx, `EXPLAIN QUERY PLAN `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		err = rows.Scan(&id, &parent, &notUsed, &detail)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lines = append(lines, detail)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return lines, rows.Close()
+}
+
 func buildWhereClause(criteria []*sqlset.FeatureCriterion) (string, []interface{}) {
 	if len(criteria) == 0 {
 		return "", nil
@@ -532,11 +925,49 @@ func buildWhereClause(criteria []*sqlset.FeatureCriterion) (string, []interface{
 	var buf bytes.Buffer
 	values := make([]interface{}, 0, len(criteria))
 	buf.WriteString(" WHERE ")
-	buf.WriteString(fmt.Sprintf(`"%s" %s ?`, criteria[0].FeatureColumn, criteria[0].Operator))
-	values = append(values, criteria[0].Value)
-	for i := 1; i < len(criteria); i++ {
-		buf.WriteString(fmt.Sprintf(`AND "%s" %s ?`, criteria[i].FeatureColumn, criteria[i].Operator))
-		values = append(values, criteria[i].Value)
+	for i, c := range criteria {
+		if i > 0 {
+			buf.WriteString(" AND ")
+		}
+		var condition string
+		switch c.Operator {
+		case "IS NULL":
+			condition = fmt.Sprintf(`"%s" IS NULL`, c.FeatureColumn)
+			if c.MissingValue != nil {
+				values = append(values, c.MissingValue)
+				condition = fmt.Sprintf(`(%s OR "%s" = ?)`, condition, c.FeatureColumn)
+			}
+		case "IS NOT NULL":
+			condition = fmt.Sprintf(`"%s" IS NOT NULL`, c.FeatureColumn)
+			if c.MissingValue != nil {
+				values = append(values, c.MissingValue)
+				condition = fmt.Sprintf(`(%s AND "%s" <> ?)`, condition, c.FeatureColumn)
+			}
+		case "IN":
+			dvrs, _ := c.Value.([]int)
+			if len(dvrs) == 0 {
+				condition = "1 = 0"
+				break
+			}
+			for _, dvr := range dvrs {
+				values = append(values, dvr)
+			}
+			condition = fmt.Sprintf(`"%s" IN (?%s)`, c.FeatureColumn, strings.Repeat(", ?", len(dvrs)-1))
+		default:
+			values = append(values, c.Value)
+			condition = fmt.Sprintf(`"%s" %s ?`, c.FeatureColumn, c.Operator)
+		}
+		if c.Operator != "IS NULL" && c.Operator != "IS NOT NULL" && c.MissingValue != nil {
+			values = append(values, c.MissingValue)
+			if c.IncludeNull {
+				condition = fmt.Sprintf(`(%s OR "%s" IS NULL OR "%s" = ?)`, condition, c.FeatureColumn, c.FeatureColumn)
+			} else {
+				condition = fmt.Sprintf(`(%s AND "%s" <> ?)`, condition, c.FeatureColumn)
+			}
+		} else if c.IncludeNull {
+			condition = fmt.Sprintf(`(%s OR "%s" IS NULL)`, condition, c.FeatureColumn)
+		}
+		buf.WriteString(condition)
 	}
 	return buf.String(), values
 }
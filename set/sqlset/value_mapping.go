@@ -0,0 +1,75 @@
+package sqlset
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+discreteValueMapping relates the numeric IDs of the discrete values on the
+dictionary of the samples read from a Set, their DiscreteFeatureValues, to
+the IDs of the same values on the dictionary of the Set they are written
+to. The source dictionary is kept to identify it and to prevent it from
+being collected while the mapping is in use.
+*/
+type discreteValueMapping struct {
+	source map[int]string
+	ids    map[int]int
+}
+
+/*
+discreteValueIDs takes the dictionary of discrete values of a sample read
+from a Set and returns the mapping of its IDs to those of the set. The
+mapping is resolved in bulk for the whole dictionary the first time it is
+seen and reused for the following samples with the same dictionary, which
+copies from another Set share. Values missing from the dictionary of the
+set map to 0, as they do when writing any other sample.
+*/
+func (ss *sqlSet) discreteValueIDs(source map[int]string) map[int]int {
+	ss.mappingLock.Lock()
+	defer ss.mappingLock.Unlock()
+	if ss.mapping != nil && reflect.ValueOf(ss.mapping.source).Pointer() == reflect.ValueOf(source).Pointer() {
+		return ss.mapping.ids
+	}
+	ids := make(map[int]int, len(source))
+	for id, v := range source {
+		ids[id] = ss.inverseDiscreteValues[v]
+	}
+	ss.mapping = &discreteValueMapping{source, ids}
+	return ids
+}
+
+/*
+newMappedRawSample takes a sample read from a Set and returns a raw sample
+for it, as newRawSample does, but translating the IDs of its discrete values
+with a discreteValueMapping instead of looking up the string for each of
+them on the source dictionary and then its ID on the dictionary of the set.
+*/
+func (ss *sqlSet) newMappedRawSample(s *Sample) (map[string]interface{}, error) {
+	ids := ss.discreteValueIDs(s.DiscreteFeatureValues)
+	rs := make(map[string]interface{})
+	for _, f := range ss.features {
+		c, ok := s.FeatureNamesColumns[f.Name()]
+		if !ok {
+			continue
+		}
+		v, ok := s.Values[c]
+		if !ok || v == nil || isMissingValue(s.MissingValues, c, v) {
+			if mv, ok := ss.missingValues[ss.featureNamesColumns[f.Name()]]; ok {
+				rs[f.Name()] = mv
+			}
+			continue
+		}
+		if _, ok = f.(*feature.DiscreteFeature); ok {
+			iv, ok := v.(int)
+			if !ok {
+				return nil, fmt.Errorf("expected sql representation for the value of %s to be an int, got %T", f.Name(), v)
+			}
+			v = ids[iv]
+		}
+		rs[f.Name()] = v
+	}
+	return rs, nil
+}
@@ -0,0 +1,50 @@
+package set
+
+import "context"
+
+/*
+Reader is a Set whose samples can be read as a stream, such as the sets on
+SQL databases of the sqlset package, so that they can be gone through
+without loading all of them in memory.
+
+Its Read method takes a context and returns a channel on which the samples
+of the set are sent and a channel on which the error reading them, if any,
+is sent once the channel of samples is closed. Cancelling the context stops
+the reading.
+*/
+type Reader interface {
+	Set
+	Read(context.Context) (<-chan Sample, <-chan error)
+}
+
+/*
+Stream takes a context and a set and returns a channel on which the samples
+of the set are sent and a channel on which the error obtaining them, if
+any, is sent once the channel of samples is closed. Sets that are Readers
+are read as a stream, whereas the samples of the rest are obtained with
+their Samples method. Cancelling the context stops sending samples.
+*/
+func Stream(ctx context.Context, s Set) (<-chan Sample, <-chan error) {
+	if r, ok := s.(Reader); ok {
+		return r.Read(ctx)
+	}
+	sampleStream := make(chan Sample)
+	errStream := make(chan error, 1)
+	go func() {
+		defer close(errStream)
+		defer close(sampleStream)
+		samples, err := s.Samples(ctx)
+		if err != nil {
+			errStream <- err
+			return
+		}
+		for _, sample := range samples {
+			select {
+			case <-ctx.Done():
+				return
+			case sampleStream <- sample:
+			}
+		}
+	}()
+	return sampleStream, errStream
+}
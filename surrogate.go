@@ -0,0 +1,86 @@
+package botanic
+
+import (
+	"context"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+surrogateSplit takes a context.Context, the partition selected to develop a
+node, the IDs of the subtree nodes created for its tasks and the partitions
+obtained for the rest of the available features, and returns the surrogate
+split for the node: the partition on another feature whose subsets, each
+mapped to the subtree it shares the most samples with, best reproduce the
+selected partition. Undefined criteria are ignored on both sides. It returns
+nil if no partition agrees with the selected one on more samples than
+sending every sample to its largest subtree does.
+*/
+func surrogateSplit(ctx context.Context, selected *Partition, subtreeIDs []string, candidates []*Partition) (*tree.Surrogate, error) {
+	var baseline int
+	for _, t := range selected.Tasks {
+		if isUndefinedCriterion(t.Node.FeatureCriterion) {
+			continue
+		}
+		count, err := t.Set.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if count > baseline {
+			baseline = count
+		}
+	}
+	var result *tree.Surrogate
+	bestAgreement := baseline
+	for _, candidate := range candidates {
+		if candidate == nil || candidate.Feature == selected.Feature {
+			continue
+		}
+		surrogate := &tree.Surrogate{Feature: candidate.Feature}
+		var agreement int
+		for _, ct := range candidate.Tasks {
+			if isUndefinedCriterion(ct.Node.FeatureCriterion) {
+				continue
+			}
+			var subtreeID string
+			var maxCount int
+			for i, t := range selected.Tasks {
+				if isUndefinedCriterion(t.Node.FeatureCriterion) {
+					continue
+				}
+				ss, err := ct.Set.SubsetWith(ctx, t.Node.FeatureCriterion)
+				if err != nil {
+					return nil, err
+				}
+				count, err := ss.Count(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if count > maxCount {
+					maxCount = count
+					subtreeID = subtreeIDs[i]
+				}
+			}
+			if subtreeID == "" {
+				continue
+			}
+			agreement += maxCount
+			surrogate.Criteria = append(surrogate.Criteria, ct.Node.FeatureCriterion)
+			surrogate.SubtreeIDs = append(surrogate.SubtreeIDs, subtreeID)
+		}
+		if agreement > bestAgreement {
+			bestAgreement = agreement
+			result = surrogate
+		}
+	}
+	return result, nil
+}
+
+func isUndefinedCriterion(c feature.Criterion) bool {
+	switch c.(type) {
+	case feature.UndefinedCriterion, feature.UndefinedValueCriterion:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+/*
+Package binary provides functions that serialize a tree.Tree or
+tree.Ensemble in a compact binary encoding and read them back, as an
+alternative to the JSON format of the tree/json package for large trees.
+
+A serialization starts with Magic, followed by the version of the format as
+an unsigned varint and a byte telling whether it holds a tree ('t') or an
+ensemble ('e'), and ends with the CRC-32 (IEEE) checksum of all the previous
+bytes in big-endian order. Numbers are written as varints, floats as their
+IEEE 754 bits in big-endian order and strings as their length followed by
+their bytes. The names of features and the values of discrete features are
+written once, and referenced by their position afterwards.
+
+Like JSON serializations, binary serializations record the hash of the
+metadata of the features of every tree, and reading them fails if the
+features given differ.
+*/
+package binary
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+Magic is the sequence of bytes binary serializations start with. As it
+starts with a NUL byte it cannot be the start of a JSON serialization.
+*/
+const Magic = "\x00BTB"
+
+/*
+FormatVersion is the version of the encoding written by WriteBinaryTree and
+WriteBinaryEnsemble. Serializations with a later version cannot be read.
+Version 2 added the smoothing of trees and the total weight of the samples
+of predictions, which serializations of version 1 lack.
+*/
+const FormatVersion = 2
+
+/*
+ErrChecksumMismatch is the error returned, wrapped with the details, when
+the checksum of a serialization does not match its contents, as happens
+when it has been truncated or modified.
+*/
+var ErrChecksumMismatch = errors.New("tree checksum mismatch")
+
+/*
+ErrFeaturesMismatch is the error returned, wrapped with the details, when the
+features a tree was written with differ from the ones given to read it, as
+happens when a tree is read with a metadata file other than the one it was
+grown with.
+*/
+var ErrFeaturesMismatch = errors.New("tree features mismatch")
+
+/*
+Kinds of serialization, written after the format version.
+*/
+const (
+	kindTree     = 't'
+	kindEnsemble = 'e'
+)
+
+/*
+Kinds of criterion, written before the details of a criterion.
+*/
+const (
+	criterionNone = iota
+	criterionContinuous
+	criterionDiscrete
+	criterionUndefined
+	criterionUndefinedValue
+	criterionBoolean
+	criterionDiscreteValues
+)
+
+/*
+nodeBatchSize is the number of nodes ReadBinaryTree stores on the tree's
+NodeStore at a time.
+*/
+const nodeBatchSize = 1000
+
+/*
+bufferSize is the size of the buffers used to write and read
+serializations.
+*/
+const bufferSize = 64 * 1024
+
+/*
+IsBinary takes the first bytes of a serialization and returns whether they
+are those of a binary serialization, as opposed to a JSON one.
+*/
+func IsBinary(prefix []byte) bool {
+	return len(prefix) >= len(Magic) && string(prefix[:len(Magic)]) == Magic
+}
+
+func kindName(kind byte) string {
+	switch kind {
+	case kindTree:
+		return "a tree"
+	case kindEnsemble:
+		return "an ensemble"
+	}
+	return fmt.Sprintf("an unknown kind %q", kind)
+}
+
+/*
+metadataHash takes the features used by a tree by name and returns their
+feature.MetadataHash.
+*/
+func metadataHash(features map[string]feature.Feature) string {
+	fs := make([]feature.Feature, 0, len(features))
+	for _, f := range features {
+		fs = append(fs, f)
+	}
+	return feature.MetadataHash(fs)
+}
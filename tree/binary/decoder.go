@@ -0,0 +1,464 @@
+package binary
+
+import (
+	"bufio"
+	"context"
+	encoding "encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+maxStringLength is the length of the longest string a serialization may
+hold, to fail on corrupt lengths before allocating them.
+*/
+const maxStringLength = 1 << 20
+
+/*
+decoder reads the values of a serialization, keeping its checksum, the
+strings already read so that they can be referenced and the first mismatch
+found between the features of a tree and the given ones, which is reported
+once the checksum is verified.
+*/
+type decoder struct {
+	r           *bufio.Reader
+	crc         hash.Hash32
+	interned    []string
+	features    []feature.Feature
+	featuresErr error
+	version     uint64
+	buf         [8]byte
+	err         error
+}
+
+/*
+ReadBinaryTree takes a context.Context, a pointer to a tree.Tree, a slice
+of features and an io.Reader and unmarshals the binary serialization of a
+tree read from the io.Reader onto the given tree. The nodes are decoded one
+at a time and stored on the tree's NodeStore in batches.
+An error is returned if the serialization cannot be read or is not the one
+of a tree, if its format version is later than FormatVersion or if the
+nodes do not form a tree. The error wraps ErrFeaturesMismatch if the given
+features differ from the ones the tree was written with, and
+ErrChecksumMismatch if the checksum does not match the contents.
+*/
+func ReadBinaryTree(ctx context.Context, t *tree.Tree, features []feature.Feature, r io.Reader) error {
+	dec, err := newDecoder(r, features, kindTree)
+	if err != nil {
+		return err
+	}
+	err = dec.tree(ctx, t)
+	if err != nil {
+		return err
+	}
+	return dec.close()
+}
+
+/*
+ReadBinaryEnsemble takes a context.Context, a pointer to a tree.Ensemble, a
+slice of features, an io.Reader and a function that returns a new
+tree.NodeStore, and unmarshals the binary serialization of an ensemble read
+from the io.Reader onto the given ensemble. Every tree of the ensemble is
+read as ReadBinaryTree does onto a tree with a NodeStore returned by the
+given function. An error is returned as described for ReadBinaryTree.
+*/
+func ReadBinaryEnsemble(ctx context.Context, e *tree.Ensemble, features []feature.Feature, r io.Reader, newNodeStore func() tree.NodeStore) error {
+	dec, err := newDecoder(r, features, kindEnsemble)
+	if err != nil {
+		return err
+	}
+	e.ClassFeature, err = dec.feature()
+	if err != nil {
+		return err
+	}
+	for dec.byte() == 1 {
+		weight := dec.float()
+		t := &tree.Tree{NodeStore: newNodeStore()}
+		err = dec.tree(ctx, t)
+		if err != nil {
+			return err
+		}
+		e.Add(t, weight)
+	}
+	if dec.err != nil {
+		return dec.err
+	}
+	return dec.close()
+}
+
+func newDecoder(r io.Reader, features []feature.Feature, kind byte) (*decoder, error) {
+	dec := &decoder{r: bufio.NewReaderSize(r, bufferSize), crc: crc32.NewIEEE(), features: features}
+	magic := make([]byte, len(Magic))
+	dec.read(magic)
+	if dec.err != nil || !IsBinary(magic) {
+		return nil, fmt.Errorf("not a binary serialization of %s", kindName(kind))
+	}
+	dec.version = dec.uvarint()
+	if dec.err == nil && dec.version > FormatVersion {
+		return nil, fmt.Errorf("format version %d is not supported, the latest supported is %d: upgrade botanic to read it", dec.version, FormatVersion)
+	}
+	k := dec.byte()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if k != kind {
+		return nil, fmt.Errorf("expected a binary serialization of %s but found one of %s", kindName(kind), kindName(k))
+	}
+	return dec, nil
+}
+
+/*
+tree reads a tree written by the encoder onto the given tree, checking that
+its nodes form a tree and that the features used by them have the metadata
+hash it was written with.
+*/
+func (dec *decoder) tree(ctx context.Context, t *tree.Tree) error {
+	rootID := dec.string()
+	classFeature, err := dec.feature()
+	if err != nil {
+		return err
+	}
+	strategy := dec.string()
+	var smoothing string
+	if dec.version >= 2 {
+		smoothing = dec.string()
+	}
+	if dec.err != nil {
+		return dec.err
+	}
+	used := map[string]feature.Feature{classFeature.Name(): classFeature}
+	ni := tree.NewNodeIndex()
+	batch := make([]*tree.Node, 0, nodeBatchSize)
+	for dec.byte() == 1 {
+		n, err := dec.node()
+		if err != nil {
+			return err
+		}
+		err = ni.Add(n)
+		if err != nil {
+			return err
+		}
+		for _, f := range n.Features() {
+			used[f.Name()] = f
+		}
+		batch = append(batch, n)
+		if len(batch) == nodeBatchSize {
+			err = tree.StoreNodes(ctx, t.NodeStore, batch)
+			if err != nil {
+				return err
+			}
+			batch = make([]*tree.Node, 0, nodeBatchSize)
+		}
+	}
+	if len(batch) > 0 {
+		err = tree.StoreNodes(ctx, t.NodeStore, batch)
+		if err != nil {
+			return err
+		}
+	}
+	featuresHash := dec.string()
+	if dec.err != nil {
+		return dec.err
+	}
+	if fh := metadataHash(used); fh != featuresHash && dec.featuresErr == nil {
+		dec.featuresErr = fmt.Errorf("%w: the tree was written with features whose metadata hashes to %s, but the given ones hash to %s; check that the metadata is the one the tree was grown with", ErrFeaturesMismatch, featuresHash, fh)
+	}
+	err = ni.Verify(rootID)
+	if err != nil {
+		return err
+	}
+	t.RootID = rootID
+	t.ClassFeature = classFeature
+	t.MissingValueStrategy = tree.MissingValueUndefined
+	if strategy != "" {
+		t.MissingValueStrategy, err = tree.ParseMissingValueStrategy(strategy)
+		if err != nil {
+			return err
+		}
+	}
+	t.Smoothing = tree.Smoothing{}
+	if smoothing != "" {
+		t.Smoothing, err = tree.ParseSmoothing(smoothing)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+close reads the checksum at the end of the serialization and returns an
+error wrapping ErrChecksumMismatch if it does not match the one of the bytes
+read, or the mismatch found on the features of any of its trees otherwise.
+*/
+func (dec *decoder) close() error {
+	sum := dec.crc.Sum32()
+	var b [4]byte
+	_, err := io.ReadFull(dec.r, b[:])
+	if err != nil {
+		return fmt.Errorf("%w: reading checksum: %v; the tree is truncated", ErrChecksumMismatch, err)
+	}
+	if expected := encoding.BigEndian.Uint32(b[:]); expected != sum {
+		return fmt.Errorf("%w: expected %08x but the contents hash to %08x; the tree is corrupt or was modified", ErrChecksumMismatch, expected, sum)
+	}
+	return dec.featuresErr
+}
+
+func (dec *decoder) node() (*tree.Node, error) {
+	n := &tree.Node{}
+	var err error
+	n.ID = dec.string()
+	n.ParentID = dec.string()
+	n.SubtreeIDs = dec.strings()
+	n.FeatureCriterion, err = dec.criterion()
+	if err != nil {
+		return nil, err
+	}
+	if name := dec.internedString(); name != "" {
+		n.SubtreeFeature, err = dec.featureNamed(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dec.byte() == 1 {
+		count := dec.uvarint()
+		probs := make(map[string]float64)
+		for i := uint64(0); i < count && dec.err == nil; i++ {
+			v := dec.internedString()
+			p := dec.float()
+			if p < 0 || p > 1 || math.IsNaN(p) {
+				return nil, fmt.Errorf("invalid probability %v for value %s of node %v", p, v, n.ID)
+			}
+			probs[v] = p
+		}
+		weight := int(dec.uvarint())
+		var totalWeight float64
+		if dec.version >= 2 {
+			totalWeight = dec.float()
+			if totalWeight < 0 || math.IsNaN(totalWeight) {
+				return nil, fmt.Errorf("invalid total weight %v of node %v", totalWeight, n.ID)
+			}
+		}
+		n.Prediction = tree.NewWeightedPrediction(probs, weight, totalWeight)
+	}
+	if dec.byte() == 1 {
+		s := &tree.Surrogate{}
+		s.Feature, err = dec.feature()
+		if err != nil {
+			return nil, err
+		}
+		count := dec.uvarint()
+		for i := uint64(0); i < count && dec.err == nil; i++ {
+			c, err := dec.criterion()
+			if err != nil {
+				return nil, err
+			}
+			s.Criteria = append(s.Criteria, c)
+		}
+		s.SubtreeIDs = dec.strings()
+		n.Surrogate = s
+	}
+	n.InformationGain = dec.float()
+	n.Depth = int(dec.uvarint())
+	n.SampleCount = int(dec.uvarint())
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if n.ID == "" {
+		return nil, fmt.Errorf("node with no id")
+	}
+	return n, nil
+}
+
+/*
+criterion reads a criterion written by the encoder, returning an error if
+its interval is not valid or an error wrapping ErrFeaturesMismatch if its
+feature is unknown or not of the kind of the criterion, or its value is not
+one of the feature.
+*/
+func (dec *decoder) criterion() (feature.Criterion, error) {
+	kind := dec.byte()
+	if kind == criterionNone || dec.err != nil {
+		return nil, dec.err
+	}
+	var includeUndefined bool
+	if kind == criterionContinuous || kind == criterionDiscrete || kind == criterionDiscreteValues || kind == criterionBoolean {
+		includeUndefined = dec.byte() == 1
+	}
+	f, err := dec.feature()
+	if err != nil {
+		return nil, err
+	}
+	var c feature.Criterion
+	switch kind {
+	case criterionContinuous:
+		cf, ok := f.(*feature.ContinuousFeature)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected continuous feature for continuous criterion but found %T feature %v", ErrFeaturesMismatch, f, f.Name())
+		}
+		a, b := dec.float(), dec.float()
+		if math.IsNaN(a) || math.IsNaN(b) || a > b {
+			return nil, fmt.Errorf("invalid interval [%v, %v) for continuous criterion on feature %v", a, b, f.Name())
+		}
+		c = feature.NewContinuousCriterion(cf, a, b)
+	case criterionDiscrete:
+		df, ok := f.(*feature.DiscreteFeature)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected discrete feature for discrete criterion but found %T feature %v", ErrFeaturesMismatch, f, f.Name())
+		}
+		v := dec.internedString()
+		if ok, err := df.Valid(v); !ok {
+			return nil, fmt.Errorf("%w: invalid discrete criterion: %v", ErrFeaturesMismatch, err)
+		}
+		c = feature.NewDiscreteCriterion(df, v)
+	case criterionDiscreteValues:
+		df, ok := f.(*feature.DiscreteFeature)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected discrete feature for discrete values criterion but found %T feature %v", ErrFeaturesMismatch, f, f.Name())
+		}
+		n := dec.uvarint()
+		if dec.err != nil {
+			return nil, dec.err
+		}
+		if n > uint64(len(df.AvailableValues())) {
+			return nil, fmt.Errorf("%w: discrete values criterion on feature %v with %d values but the feature has %d", ErrFeaturesMismatch, f.Name(), n, len(df.AvailableValues()))
+		}
+		values := make([]string, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v := dec.internedString()
+			if ok, err := df.Valid(v); !ok && dec.err == nil {
+				return nil, fmt.Errorf("%w: invalid discrete values criterion: %v", ErrFeaturesMismatch, err)
+			}
+			values = append(values, v)
+		}
+		c = feature.NewDiscreteValuesCriterion(df, values)
+	case criterionBoolean:
+		bf, ok := f.(*feature.BooleanFeature)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected boolean feature for boolean criterion but found %T feature %v", ErrFeaturesMismatch, f, f.Name())
+		}
+		c = feature.NewBooleanCriterion(bf, dec.byte() == 1)
+	case criterionUndefined:
+		return feature.NewUndefinedCriterion(f), nil
+	case criterionUndefinedValue:
+		return feature.NewUndefinedValueCriterion(f), nil
+	default:
+		return nil, fmt.Errorf("unknown feature criterion kind %d", kind)
+	}
+	if includeUndefined {
+		c = feature.IncludingUndefined(c)
+	}
+	return c, dec.err
+}
+
+func (dec *decoder) feature() (feature.Feature, error) {
+	name := dec.internedString()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return dec.featureNamed(name)
+}
+
+func (dec *decoder) featureNamed(name string) (feature.Feature, error) {
+	for _, f := range dec.features {
+		if f.Name() == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown feature '%s'", ErrFeaturesMismatch, name)
+}
+
+func (dec *decoder) read(b []byte) {
+	if dec.err != nil {
+		return
+	}
+	_, err := io.ReadFull(dec.r, b)
+	if err != nil {
+		dec.err = fmt.Errorf("reading binary tree: %v", err)
+		return
+	}
+	dec.crc.Write(b)
+}
+
+func (dec *decoder) byte() byte {
+	dec.read(dec.buf[:1])
+	if dec.err != nil {
+		return 0
+	}
+	return dec.buf[0]
+}
+
+func (dec *decoder) uvarint() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b := dec.byte()
+		if dec.err != nil {
+			return 0
+		}
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v
+		}
+	}
+	if dec.err == nil {
+		dec.err = fmt.Errorf("reading binary tree: varint overflows 64 bits")
+	}
+	return 0
+}
+
+func (dec *decoder) float() float64 {
+	dec.read(dec.buf[:8])
+	if dec.err != nil {
+		return 0
+	}
+	return math.Float64frombits(encoding.BigEndian.Uint64(dec.buf[:8]))
+}
+
+func (dec *decoder) string() string {
+	l := dec.uvarint()
+	if dec.err != nil {
+		return ""
+	}
+	if l > maxStringLength {
+		dec.err = fmt.Errorf("reading binary tree: string of %d bytes is too long", l)
+		return ""
+	}
+	b := make([]byte, l)
+	dec.read(b)
+	return string(b)
+}
+
+func (dec *decoder) strings() []string {
+	count := dec.uvarint()
+	var ss []string
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		ss = append(ss, dec.string())
+	}
+	return ss
+}
+
+/*
+internedString reads a string written by the encoder's internedString.
+*/
+func (dec *decoder) internedString() string {
+	i := dec.uvarint()
+	if dec.err != nil {
+		return ""
+	}
+	if i == 0 {
+		s := dec.string()
+		dec.interned = append(dec.interned, s)
+		return s
+	}
+	if i > uint64(len(dec.interned)) {
+		dec.err = fmt.Errorf("reading binary tree: reference to unknown string %d", i)
+		return ""
+	}
+	return dec.interned[i-1]
+}
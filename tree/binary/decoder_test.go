@@ -0,0 +1,61 @@
+package binary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+renamingNodeStore is a tree.NodeStore that serves the node with ID from
+under the ID to, to write trees whose nodes list subtrees that are missing.
+*/
+type renamingNodeStore struct {
+	tree.NodeStore
+	from, to string
+}
+
+func (rns *renamingNodeStore) Get(ctx context.Context, id string) (*tree.Node, error) {
+	n, err := rns.NodeStore.Get(ctx, id)
+	if err != nil || n == nil || n.ID != rns.from {
+		return n, err
+	}
+	renamed := *n
+	renamed.ID = rns.to
+	return &renamed, nil
+}
+
+func TestReadBinaryTreeRejectsMissingSubtrees(t *testing.T) {
+	ctx := context.Background()
+	class := feature.NewDiscreteFeature("class", []string{"yes", "no"})
+	d := feature.NewDiscreteFeature("d", []string{"a", "b"})
+	ns := tree.NewMemoryNodeStore()
+	root := &tree.Node{SubtreeFeature: d, Prediction: tree.NewPrediction(map[string]float64{"yes": 1}, 2)}
+	if err := ns.Create(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a", "b"} {
+		n := &tree.Node{ParentID: root.ID, FeatureCriterion: feature.NewDiscreteCriterion(d, v), Prediction: tree.NewPrediction(map[string]float64{"yes": 1}, 1)}
+		if err := ns.Create(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		root.SubtreeIDs = append(root.SubtreeIDs, n.ID)
+	}
+	if err := ns.Store(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	dangling := tree.New(root.ID, &renamingNodeStore{NodeStore: ns, from: root.SubtreeIDs[1], to: "renamed"}, class)
+	if err := WriteBinaryTree(ctx, dangling, &buf); err != nil {
+		t.Fatal(err)
+	}
+	read := &tree.Tree{NodeStore: tree.NewMemoryNodeStore()}
+	err := ReadBinaryTree(ctx, read, []feature.Feature{class, d}, &buf)
+	if !errors.Is(err, tree.ErrNodeNotFound) {
+		t.Fatalf("expected an error wrapping tree.ErrNodeNotFound, got %v", err)
+	}
+}
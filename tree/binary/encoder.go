@@ -0,0 +1,274 @@
+package binary
+
+import (
+	"bufio"
+	"context"
+	encoding "encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+encoder writes the values of a serialization, keeping its checksum and the
+positions of the strings already written.
+*/
+type encoder struct {
+	w        *bufio.Writer
+	crc      hash.Hash32
+	interned map[string]uint64
+	buf      [encoding.MaxVarintLen64]byte
+	err      error
+}
+
+/*
+WriteBinaryTree takes a context.Context, a pointer to a tree.Tree and an
+io.Writer and serializes the given tree in the binary encoding onto the
+io.Writer. Nodes are serialized one at a time as the tree is traversed and
+written through a fixed-size buffer, so the whole serialization is never
+held in memory. An error is returned if the tree cannot be traversed,
+serialized or written onto the io.Writer.
+*/
+func WriteBinaryTree(ctx context.Context, t *tree.Tree, w io.Writer) error {
+	enc := newEncoder(w, kindTree)
+	err := enc.tree(ctx, t)
+	if err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+/*
+WriteBinaryEnsemble takes a context.Context, a pointer to a tree.Ensemble
+and an io.Writer and serializes the given ensemble in the binary encoding
+onto the io.Writer, with the class feature of the ensemble followed by the
+weight and serialization of every tree. An error is returned if any of the
+trees cannot be traversed, serialized or written onto the io.Writer.
+*/
+func WriteBinaryEnsemble(ctx context.Context, e *tree.Ensemble, w io.Writer) error {
+	enc := newEncoder(w, kindEnsemble)
+	enc.internedString(e.ClassFeature.Name())
+	for i, t := range e.Trees {
+		enc.byte(1)
+		enc.float(e.Weights[i])
+		err := enc.tree(ctx, t)
+		if err != nil {
+			return err
+		}
+	}
+	enc.byte(0)
+	return enc.close()
+}
+
+func newEncoder(w io.Writer, kind byte) *encoder {
+	enc := &encoder{w: bufio.NewWriterSize(w, bufferSize), crc: crc32.NewIEEE(), interned: make(map[string]uint64)}
+	enc.write([]byte(Magic))
+	enc.uvarint(FormatVersion)
+	enc.byte(kind)
+	return enc
+}
+
+/*
+tree writes the root ID, class feature, missing value strategy and
+smoothing of a tree, its nodes, each preceded by a 1 byte, a 0 byte ending
+them and the metadata hash of the features the tree uses.
+*/
+func (enc *encoder) tree(ctx context.Context, t *tree.Tree) error {
+	var strategy string
+	if t.MissingValueStrategy != tree.MissingValueUndefined {
+		strategy = t.MissingValueStrategy.String()
+	}
+	enc.string(t.RootID)
+	enc.internedString(t.ClassFeature.Name())
+	enc.string(strategy)
+	var smoothing string
+	if t.Smoothing.Method != tree.SmoothingNone {
+		smoothing = t.Smoothing.String()
+	}
+	enc.string(smoothing)
+	features := map[string]feature.Feature{t.ClassFeature.Name(): t.ClassFeature}
+	err := t.Traverse(ctx, false, func(ctx context.Context, n *tree.Node) error {
+		enc.byte(1)
+		enc.node(n)
+		for _, f := range n.Features() {
+			features[f.Name()] = f
+		}
+		return enc.err
+	})
+	if err != nil {
+		return err
+	}
+	enc.byte(0)
+	enc.string(metadataHash(features))
+	return enc.err
+}
+
+/*
+close writes the checksum of the serialization and flushes it.
+*/
+func (enc *encoder) close() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	var sum [4]byte
+	encoding.BigEndian.PutUint32(sum[:], enc.crc.Sum32())
+	_, err := enc.w.Write(sum[:])
+	if err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
+
+func (enc *encoder) node(n *tree.Node) {
+	enc.string(n.ID)
+	enc.string(n.ParentID)
+	enc.strings(n.SubtreeIDs)
+	enc.criterion(n.FeatureCriterion)
+	var subtreeFeature string
+	if n.SubtreeFeature != nil {
+		subtreeFeature = n.SubtreeFeature.Name()
+	}
+	enc.internedString(subtreeFeature)
+	if n.Prediction == nil {
+		enc.byte(0)
+	} else {
+		enc.byte(1)
+		probs := n.Prediction.Probabilities()
+		values := make([]string, 0, len(probs))
+		for v := range probs {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		enc.uvarint(uint64(len(values)))
+		for _, v := range values {
+			enc.internedString(v)
+			enc.float(probs[v])
+		}
+		enc.uvarint(uint64(n.Prediction.Weight()))
+		enc.float(n.Prediction.TotalWeight())
+	}
+	if n.Surrogate == nil {
+		enc.byte(0)
+	} else {
+		enc.byte(1)
+		enc.internedString(n.Surrogate.Feature.Name())
+		enc.uvarint(uint64(len(n.Surrogate.Criteria)))
+		for _, c := range n.Surrogate.Criteria {
+			enc.criterion(c)
+		}
+		enc.strings(n.Surrogate.SubtreeIDs)
+	}
+	enc.float(n.InformationGain)
+	enc.uvarint(uint64(n.Depth))
+	enc.uvarint(uint64(n.SampleCount))
+}
+
+/*
+criterion writes the kind of a criterion, whether it includes undefined
+values for continuous, discrete, discrete values and boolean criteria, the
+name of its feature and its interval, value or values, the latter preceded
+by their number.
+*/
+func (enc *encoder) criterion(fc feature.Criterion) {
+	switch c := fc.(type) {
+	case nil:
+		enc.byte(criterionNone)
+	case feature.ContinuousCriterion:
+		enc.byte(criterionContinuous)
+		enc.bool(feature.IncludesUndefined(c))
+		enc.internedString(c.Feature().Name())
+		a, b := c.Interval()
+		enc.float(a)
+		enc.float(b)
+	case feature.DiscreteCriterion:
+		enc.byte(criterionDiscrete)
+		enc.bool(feature.IncludesUndefined(c))
+		enc.internedString(c.Feature().Name())
+		enc.internedString(c.Value())
+	case feature.DiscreteValuesCriterion:
+		enc.byte(criterionDiscreteValues)
+		enc.bool(feature.IncludesUndefined(c))
+		enc.internedString(c.Feature().Name())
+		enc.uvarint(uint64(len(c.Values())))
+		for _, v := range c.Values() {
+			enc.internedString(v)
+		}
+	case feature.BooleanCriterion:
+		enc.byte(criterionBoolean)
+		enc.bool(feature.IncludesUndefined(c))
+		enc.internedString(c.Feature().Name())
+		enc.bool(c.Value())
+	case feature.UndefinedCriterion:
+		enc.byte(criterionUndefined)
+		enc.internedString(c.Feature().Name())
+	case feature.UndefinedValueCriterion:
+		enc.byte(criterionUndefinedValue)
+		enc.internedString(c.Feature().Name())
+	default:
+		if enc.err == nil {
+			enc.err = fmt.Errorf("unknown type of feature.Criterion %T", fc)
+		}
+	}
+}
+
+func (enc *encoder) write(b []byte) {
+	if enc.err != nil {
+		return
+	}
+	enc.crc.Write(b)
+	_, enc.err = enc.w.Write(b)
+}
+
+func (enc *encoder) byte(b byte) {
+	enc.write([]byte{b})
+}
+
+func (enc *encoder) bool(b bool) {
+	if b {
+		enc.byte(1)
+		return
+	}
+	enc.byte(0)
+}
+
+func (enc *encoder) uvarint(v uint64) {
+	n := encoding.PutUvarint(enc.buf[:], v)
+	enc.write(enc.buf[:n])
+}
+
+func (enc *encoder) float(f float64) {
+	encoding.BigEndian.PutUint64(enc.buf[:8], math.Float64bits(f))
+	enc.write(enc.buf[:8])
+}
+
+func (enc *encoder) string(s string) {
+	enc.uvarint(uint64(len(s)))
+	enc.write([]byte(s))
+}
+
+func (enc *encoder) strings(ss []string) {
+	enc.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		enc.string(s)
+	}
+}
+
+/*
+internedString writes the position of a string already written, counting
+from 1, or 0 followed by the string the first time it is written.
+*/
+func (enc *encoder) internedString(s string) {
+	if i, ok := enc.interned[s]; ok {
+		enc.uvarint(i)
+		return
+	}
+	enc.uvarint(0)
+	enc.string(s)
+	enc.interned[s] = uint64(len(enc.interned) + 1)
+}
@@ -0,0 +1,362 @@
+package tree
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+CachedNodeStore is a NodeStore that decorates another one, keeping the most
+recently used nodes in memory and writing the nodes stored on it to the
+decorated store behind the scenes, in batches.
+
+Nodes stored on a CachedNodeStore are kept as pending writes until they are
+flushed to the decorated store, which happens once enough of them are
+pending, every flush interval, and when the store is flushed or closed.
+Nodes are flushed with StoreNodes, so in a single operation if the decorated
+store is a BatchNodeStore, and nodes not in memory are retrieved in batches
+with GetNodes. Until they are flushed, the pending nodes are
+served from memory, and they are never evicted. Created and deleted nodes go
+to the decorated store right away, as it is the one giving IDs to nodes.
+
+As nodes are served from memory, the store must be the only one updating
+the nodes of the decorated store while it is in use, as happens when a tree
+is grown in process. If a flush fails, the error is returned by the next
+operation that updates nodes, as well as by Flush and Close.
+*/
+type CachedNodeStore struct {
+	ns            NodeStore
+	size          int
+	flushSize     int
+	flushInterval time.Duration
+	lock          sync.Mutex
+	flushLock     sync.Mutex
+	entries       map[string]*list.Element
+	lru           *list.List
+	pending       map[string]*Node
+	err           error
+	flushes       chan struct{}
+	done          chan struct{}
+	stopped       chan struct{}
+	closeOnce     sync.Once
+}
+
+/*
+NewCachedNodeStore takes a NodeStore, the maximum number of nodes to keep in
+memory besides the pending writes, the number of pending writes that
+triggers a flush and the interval between flushes, and returns a
+CachedNodeStore decorating the store. A size of 0 or less keeps no nodes in
+memory besides the pending writes, a flush size of 1 or less flushes every
+node as soon as possible and an interval of 0 or less only flushes when the
+flush size is reached or the store is flushed or closed.
+*/
+func NewCachedNodeStore(ns NodeStore, size, flushSize int, flushInterval time.Duration) *CachedNodeStore {
+	cns := &CachedNodeStore{
+		ns:            ns,
+		size:          size,
+		flushSize:     flushSize,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		pending:       make(map[string]*Node),
+		flushes:       make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		flushInterval: flushInterval,
+	}
+	go cns.run()
+	return cns
+}
+
+func (cns *CachedNodeStore) Create(ctx context.Context, n *Node) error {
+	if err := cns.flushErr(); err != nil {
+		return err
+	}
+	err := cns.ns.Create(ctx, n)
+	if err != nil {
+		return err
+	}
+	cns.lock.Lock()
+	cns.cache(n)
+	cns.lock.Unlock()
+	return nil
+}
+
+func (cns *CachedNodeStore) Get(ctx context.Context, id string) (*Node, error) {
+	cns.lock.Lock()
+	if n, ok := cns.pending[id]; ok {
+		cns.lock.Unlock()
+		return copyNode(n), nil
+	}
+	if e, ok := cns.entries[id]; ok {
+		cns.lru.MoveToFront(e)
+		n := e.Value.(*Node)
+		cns.lock.Unlock()
+		return copyNode(n), nil
+	}
+	cns.lock.Unlock()
+	n, err := cns.ns.Get(ctx, id)
+	if err != nil || n == nil {
+		return n, err
+	}
+	cns.lock.Lock()
+	if _, ok := cns.pending[id]; !ok {
+		cns.cache(n)
+	}
+	cns.lock.Unlock()
+	return n, nil
+}
+
+/*
+GetBatch takes a slice of IDs and returns the nodes with them, as described
+for BatchGetNodeStore, serving those in memory and retrieving the rest from
+the decorated store with GetNodes.
+*/
+func (cns *CachedNodeStore) GetBatch(ctx context.Context, ids []string) ([]*Node, error) {
+	nodes := make([]*Node, len(ids))
+	var missing []string
+	var missingIdx []int
+	cns.lock.Lock()
+	for i, id := range ids {
+		if n, ok := cns.pending[id]; ok {
+			nodes[i] = copyNode(n)
+			continue
+		}
+		if e, ok := cns.entries[id]; ok {
+			cns.lru.MoveToFront(e)
+			nodes[i] = copyNode(e.Value.(*Node))
+			continue
+		}
+		missing = append(missing, id)
+		missingIdx = append(missingIdx, i)
+	}
+	cns.lock.Unlock()
+	if len(missing) == 0 {
+		return nodes, nil
+	}
+	found, err := GetNodes(ctx, cns.ns, missing)
+	if err != nil {
+		return nil, err
+	}
+	cns.lock.Lock()
+	defer cns.lock.Unlock()
+	for j, n := range found {
+		nodes[missingIdx[j]] = n
+		if n == nil {
+			continue
+		}
+		if _, ok := cns.pending[n.ID]; !ok {
+			cns.cache(n)
+		}
+	}
+	return nodes, nil
+}
+
+func (cns *CachedNodeStore) Store(ctx context.Context, n *Node) error {
+	return cns.StoreBatch(ctx, []*Node{n})
+}
+
+/*
+StoreBatch takes a slice of nodes and keeps them as pending writes, so that
+they are flushed to the decorated store later. It returns the error of a
+previous flush if it failed.
+*/
+func (cns *CachedNodeStore) StoreBatch(ctx context.Context, nodes []*Node) error {
+	if err := cns.flushErr(); err != nil {
+		return err
+	}
+	cns.lock.Lock()
+	for _, n := range nodes {
+		if n.ID == "" {
+			cns.lock.Unlock()
+			return fmt.Errorf("storing node: node has no ID")
+		}
+		cns.uncache(n.ID)
+		cns.pending[n.ID] = copyNode(n)
+	}
+	full := len(cns.pending) >= cns.flushSize
+	cns.lock.Unlock()
+	if full {
+		select {
+		case cns.flushes <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (cns *CachedNodeStore) Delete(ctx context.Context, n *Node) error {
+	cns.flushLock.Lock()
+	defer cns.flushLock.Unlock()
+	cns.lock.Lock()
+	delete(cns.pending, n.ID)
+	cns.uncache(n.ID)
+	cns.lock.Unlock()
+	return cns.ns.Delete(ctx, n)
+}
+
+/*
+Flush takes a context and writes the pending nodes to the decorated store,
+returning an error if they cannot be written or a previous flush failed.
+*/
+func (cns *CachedNodeStore) Flush(ctx context.Context) error {
+	cns.flushLock.Lock()
+	defer cns.flushLock.Unlock()
+	return cns.flush(ctx)
+}
+
+/*
+Close takes a context, stops flushing the pending nodes in the background,
+flushes them and closes the decorated store. It returns an error if the
+pending nodes cannot be flushed or the decorated store cannot be closed.
+*/
+func (cns *CachedNodeStore) Close(ctx context.Context) error {
+	cns.closeOnce.Do(func() {
+		close(cns.done)
+	})
+	<-cns.stopped
+	err := cns.Flush(ctx)
+	if err != nil {
+		return err
+	}
+	return cns.ns.Close(ctx)
+}
+
+/*
+run flushes the pending nodes every time a flush is requested or the flush
+interval elapses, until the store is closed.
+*/
+func (cns *CachedNodeStore) run() {
+	defer close(cns.stopped)
+	var tick <-chan time.Time
+	if cns.flushInterval > 0 {
+		ticker := time.NewTicker(cns.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-cns.done:
+			return
+		case <-cns.flushes:
+		case <-tick:
+		}
+		cns.Flush(context.Background())
+	}
+}
+
+/*
+flush writes the pending nodes to the decorated store and drops them from
+the pending writes, keeping them in memory as recently used nodes unless
+they have been stored again in the meantime. A failure is recorded to be
+returned by later operations. It must be called holding the flush lock.
+*/
+func (cns *CachedNodeStore) flush(ctx context.Context) error {
+	cns.lock.Lock()
+	if cns.err != nil || len(cns.pending) == 0 {
+		err := cns.err
+		cns.lock.Unlock()
+		return err
+	}
+	nodes := make([]*Node, 0, len(cns.pending))
+	for _, n := range cns.pending {
+		nodes = append(nodes, n)
+	}
+	cns.lock.Unlock()
+	err := StoreNodes(ctx, cns.ns, nodes)
+	cns.lock.Lock()
+	defer cns.lock.Unlock()
+	if err != nil {
+		cns.err = fmt.Errorf("flushing %d nodes: %w", len(nodes), err)
+		return cns.err
+	}
+	for _, n := range nodes {
+		if cns.pending[n.ID] == n {
+			delete(cns.pending, n.ID)
+			cns.cache(n)
+		}
+	}
+	return nil
+}
+
+func (cns *CachedNodeStore) flushErr() error {
+	cns.lock.Lock()
+	defer cns.lock.Unlock()
+	return cns.err
+}
+
+/*
+cache takes a node and keeps a copy of it as the most recently used node,
+evicting the least recently used ones beyond the size of the store. It must
+be called holding the lock.
+*/
+func (cns *CachedNodeStore) cache(n *Node) {
+	if cns.size <= 0 {
+		return
+	}
+	n = copyNode(n)
+	if e, ok := cns.entries[n.ID]; ok {
+		e.Value = n
+		cns.lru.MoveToFront(e)
+		return
+	}
+	cns.entries[n.ID] = cns.lru.PushFront(n)
+	for cns.lru.Len() > cns.size {
+		e := cns.lru.Back()
+		cns.lru.Remove(e)
+		delete(cns.entries, e.Value.(*Node).ID)
+	}
+}
+
+/*
+uncache takes the ID of a node and drops it from the recently used nodes. It
+must be called holding the lock.
+*/
+func (cns *CachedNodeStore) uncache(id string) {
+	if e, ok := cns.entries[id]; ok {
+		cns.lru.Remove(e)
+		delete(cns.entries, id)
+	}
+}
+
+/*
+copyNode takes a node and returns a copy of it that shares no slices, maps
+or pointers to structs with it, so that changes on either one, such as those
+made on a node when it is branched out, do not reach the other. Criteria and
+features are shared, as they are not changed once created.
+*/
+func copyNode(n *Node) *Node {
+	cn := *n
+	if n.SubtreeIDs != nil {
+		cn.SubtreeIDs = append([]string(nil), n.SubtreeIDs...)
+	}
+	if n.Prediction != nil {
+		cn.Prediction = copyPrediction(n.Prediction)
+	}
+	if n.Surrogate != nil {
+		s := *n.Surrogate
+		if s.Criteria != nil {
+			s.Criteria = append([]feature.Criterion(nil), s.Criteria...)
+		}
+		if s.SubtreeIDs != nil {
+			s.SubtreeIDs = append([]string(nil), s.SubtreeIDs...)
+		}
+		cn.Surrogate = &s
+	}
+	return &cn
+}
+
+func copyPrediction(p *Prediction) *Prediction {
+	cp := *p
+	if p.probabilities != nil {
+		cp.probabilities = make(map[string]float64, len(p.probabilities))
+		for v, prob := range p.probabilities {
+			cp.probabilities[v] = prob
+		}
+	}
+	return &cp
+}
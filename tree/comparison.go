@@ -0,0 +1,170 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+Comparison holds the results of comparing the predictions of two predictors,
+A and B, over a stream of samples: the number of samples compared, how many
+of them the predictors agreed on, that is, predicted the same value for or
+were both unable to predict, and, for the samples with a value for the class
+feature, how many of them each predictor predicted correctly and how the
+predictors fared on the samples they disagreed on. The results are also
+broken down by the value of the class feature of the samples in Classes.
+
+A Comparison is built adding samples one at a time with its Add method, so
+that streams of samples can be compared without holding them in memory.
+*/
+type Comparison struct {
+	ClassFeature feature.Feature
+	Samples      int
+	Agreements   int
+	Labelled     int
+	ASuccesses   int
+	BSuccesses   int
+	// AOnlySuccesses is the number of labelled samples the
+	// predictors disagreed on that only A predicted correctly
+	AOnlySuccesses int
+	// BOnlySuccesses is the number of labelled samples the
+	// predictors disagreed on that only B predicted correctly
+	BOnlySuccesses int
+	// BothFailures is the number of labelled samples the
+	// predictors disagreed on that neither predicted correctly
+	BothFailures int
+	classes      map[string]*ClassComparison
+}
+
+/*
+ClassComparison holds the results of a Comparison for the samples with a
+given value for the class feature: the number of them, how many of them the
+predictors disagreed on and how many of them each predictor predicted
+correctly.
+*/
+type ClassComparison struct {
+	Value         string
+	Samples       int
+	Disagreements int
+	ASuccesses    int
+	BSuccesses    int
+}
+
+/*
+NewComparison takes a class feature and returns an empty Comparison of
+predictors of the feature.
+*/
+func NewComparison(classFeature feature.Feature) *Comparison {
+	return &Comparison{ClassFeature: classFeature, classes: make(map[string]*ClassComparison)}
+}
+
+/*
+Add takes a context, predictors A and B and a sample, predicts the sample
+with both predictors and adds the results to the comparison. It returns
+whether the predictors agreed on the sample, or an error if a prediction
+cannot be made for reasons other than the predictor not being able to do so
+or the value of the class feature cannot be retrieved from the sample.
+*/
+func (c *Comparison) Add(ctx context.Context, a, b Predictor, s feature.Sample) (bool, error) {
+	aValue, err := predictedValue(ctx, a, s)
+	if err != nil {
+		return false, err
+	}
+	bValue, err := predictedValue(ctx, b, s)
+	if err != nil {
+		return false, err
+	}
+	agree := (aValue == nil && bValue == nil) || (aValue != nil && bValue != nil && *aValue == *bValue)
+	c.Samples++
+	if agree {
+		c.Agreements++
+	}
+	v, err := s.ValueFor(c.ClassFeature)
+	if err != nil {
+		return false, err
+	}
+	if v == nil {
+		return agree, nil
+	}
+	value := fmt.Sprintf("%v", v)
+	c.Labelled++
+	cc, ok := c.classes[value]
+	if !ok {
+		cc = &ClassComparison{Value: value}
+		c.classes[value] = cc
+	}
+	cc.Samples++
+	aSuccess := aValue != nil && *aValue == value
+	bSuccess := bValue != nil && *bValue == value
+	if aSuccess {
+		c.ASuccesses++
+		cc.ASuccesses++
+	}
+	if bSuccess {
+		c.BSuccesses++
+		cc.BSuccesses++
+	}
+	if agree {
+		return agree, nil
+	}
+	cc.Disagreements++
+	switch {
+	case aSuccess:
+		c.AOnlySuccesses++
+	case bSuccess:
+		c.BOnlySuccesses++
+	default:
+		c.BothFailures++
+	}
+	return agree, nil
+}
+
+/*
+AgreementRate returns the rate of samples the predictors agreed on over the
+samples compared, 0 if no samples were compared.
+*/
+func (c *Comparison) AgreementRate() float64 {
+	if c.Samples == 0 {
+		return 0.0
+	}
+	return float64(c.Agreements) / float64(c.Samples)
+}
+
+/*
+Classes returns the results of the comparison for every value of the class
+feature found on the labelled samples, sorted by decreasing number of
+disagreements.
+*/
+func (c *Comparison) Classes() []*ClassComparison {
+	result := make([]*ClassComparison, 0, len(c.classes))
+	for _, cc := range c.classes {
+		result = append(result, cc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Disagreements != result[j].Disagreements {
+			return result[i].Disagreements > result[j].Disagreements
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+/*
+predictedValue takes a context, a predictor and a sample and returns a
+pointer to the value predicted for the sample, nil if the predictor cannot
+predict it, or an error.
+*/
+func predictedValue(ctx context.Context, p Predictor, s feature.Sample) (*string, error) {
+	prediction, err := p.Predict(ctx, s)
+	if err != nil {
+		if err == ErrCannotPredictFromSample {
+			return nil, nil
+		}
+		return nil, err
+	}
+	value, _ := prediction.PredictedValue()
+	return &value, nil
+}
@@ -0,0 +1,132 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+Curves holds the ROC and precision-recall curves of the predictions of a
+tree or ensemble for a value of the class feature, the positive value,
+against the rest, scoring every sample with the probability its prediction
+gives the positive value. Points holds a point of the curves for every
+distinct score, from the highest to the lowest, taking as predicted
+positives the samples scored with it or higher. ROCAUC is the area under
+the ROC curve and PRAUC the area under the precision-recall curve, computed
+as the average precision.
+*/
+type Curves struct {
+	Positive  string        `json:"positive"`
+	Positives int           `json:"positives"`
+	Negatives int           `json:"negatives"`
+	ROCAUC    float64       `json:"rocAUC"`
+	PRAUC     float64       `json:"prAUC"`
+	Points    []*CurvePoint `json:"points"`
+}
+
+/*
+CurvePoint is a point of the ROC and precision-recall curves: the threshold
+from which samples are predicted positive, the true and false positives with
+that threshold, the rates of true and false positives, which are the
+coordinates of the point on the ROC curve, and the precision, which together
+with the true positive rate, or recall, are its coordinates on the
+precision-recall curve.
+*/
+type CurvePoint struct {
+	Threshold         float64 `json:"threshold"`
+	TruePositives     int     `json:"truePositives"`
+	FalsePositives    int     `json:"falsePositives"`
+	TruePositiveRate  float64 `json:"truePositiveRate"`
+	FalsePositiveRate float64 `json:"falsePositiveRate"`
+	Precision         float64 `json:"precision"`
+}
+
+/*
+scoreCounts holds, for every value of the class feature, the number of
+positive and negative samples scored with every probability of that value.
+Samples whose prediction does not give a value any probability and whose
+value is not that one are not counted, as they are negatives with a 0
+score that can be worked out from the number of samples predicted.
+*/
+type scoreCounts map[string]map[float64]*[2]int
+
+/*
+add takes the prediction made for a sample and the value of the sample for
+the class feature and counts the sample for every value of the prediction.
+*/
+func (sc scoreCounts) add(p *Prediction, value string) {
+	count := func(v string, score float64, positive bool) {
+		if sc[v] == nil {
+			sc[v] = make(map[float64]*[2]int)
+		}
+		c := sc[v][score]
+		if c == nil {
+			c = &[2]int{}
+			sc[v][score] = c
+		}
+		if positive {
+			c[0]++
+		} else {
+			c[1]++
+		}
+	}
+	probs := p.Probabilities()
+	for v, prob := range probs {
+		count(v, prob, v == value)
+	}
+	if _, ok := probs[value]; !ok {
+		count(value, 0, true)
+	}
+}
+
+/*
+Curves takes a value of the class feature and returns the ROC and
+precision-recall curves of the evaluated predictions for that value
+against the rest. Samples that could not be predicted are left out. It
+returns an error if no sample predicted has the value or every sample
+predicted has it, as the curves are not defined then.
+*/
+func (ev *Evaluation) Curves(positive string) (*Curves, error) {
+	counts := make(map[float64]*[2]int)
+	recorded := 0
+	for score, c := range ev.scores[positive] {
+		counts[score] = &[2]int{c[0], c[1]}
+		recorded += c[0] + c[1]
+	}
+	if unscored := ev.Samples - ev.Unpredicted - recorded; unscored > 0 {
+		if counts[0] == nil {
+			counts[0] = &[2]int{}
+		}
+		counts[0][1] += unscored
+	}
+	c := &Curves{Positive: positive}
+	scores := make([]float64, 0, len(counts))
+	for score, sc := range counts {
+		scores = append(scores, score)
+		c.Positives += sc[0]
+		c.Negatives += sc[1]
+	}
+	if c.Positives == 0 || c.Negatives == 0 {
+		return nil, fmt.Errorf("cannot compute curves for %s with %d positive and %d negative samples predicted", positive, c.Positives, c.Negatives)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+	var tp, fp int
+	var lastTPR, lastFPR float64
+	for _, score := range scores {
+		tp += counts[score][0]
+		fp += counts[score][1]
+		p := &CurvePoint{
+			Threshold:         score,
+			TruePositives:     tp,
+			FalsePositives:    fp,
+			TruePositiveRate:  float64(tp) / float64(c.Positives),
+			FalsePositiveRate: float64(fp) / float64(c.Negatives),
+			Precision:         float64(tp) / float64(tp+fp),
+		}
+		c.ROCAUC += (p.FalsePositiveRate - lastFPR) * (p.TruePositiveRate + lastTPR) / 2
+		c.PRAUC += (p.TruePositiveRate - lastTPR) * p.Precision
+		lastTPR, lastFPR = p.TruePositiveRate, p.FalsePositiveRate
+		c.Points = append(c.Points, p)
+	}
+	return c, nil
+}
@@ -0,0 +1,230 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+DiffKind is the kind of a structural difference between two trees.
+*/
+type DiffKind int
+
+const (
+	// DiffSplitAdded is a leaf of tree A that is split in tree B.
+	DiffSplitAdded DiffKind = iota
+	// DiffSplitRemoved is a node split in tree A that is a leaf in tree B.
+	DiffSplitRemoved
+	// DiffSplitChanged is a node split in both trees on a different
+	// feature or into subtrees with different criteria.
+	DiffSplitChanged
+	// DiffPredictionChanged is a leaf of both trees that predicts a
+	// different value.
+	DiffPredictionChanged
+)
+
+var diffKindNames = map[DiffKind]string{
+	DiffSplitAdded:        "split added",
+	DiffSplitRemoved:      "split removed",
+	DiffSplitChanged:      "split changed",
+	DiffPredictionChanged: "prediction changed",
+}
+
+func (k DiffKind) String() string {
+	if n, ok := diffKindNames[k]; ok {
+		return n
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+/*
+NodeDiff is a structural difference between two trees at the node reached
+following the criteria in Path from the root node, which is the same on
+both trees. A and B are the node on tree A and on tree B, and ASplit and
+BSplit describe them: the feature they split on and the criteria of their
+subtrees, or the value predicted by leaves.
+*/
+type NodeDiff struct {
+	Kind   DiffKind
+	Path   []string
+	A      *Node
+	B      *Node
+	ASplit string
+	BSplit string
+}
+
+/*
+Diff holds the structural differences between two trees, A and B, in the
+order they are found going down the trees, and the stats of both trees.
+*/
+type Diff struct {
+	Changes []*NodeDiff
+	AStats  *Stats
+	BStats  *Stats
+}
+
+/*
+NewDiff takes a context and trees A and B and returns the structural
+differences between them. Nodes are matched by the criteria followed to
+reach them from the root node, as their IDs are not meaningful across
+trees, so that reviewing a retrained tree shows which splits were added,
+removed or changed and which leaves predict a different value. The subtrees
+of the nodes whose split changed are not compared any further, except for
+those with the same criterion on both trees. It returns an error if the
+nodes cannot be retrieved from the node stores of the trees.
+*/
+func NewDiff(ctx context.Context, a, b *Tree) (*Diff, error) {
+	d := &Diff{}
+	var err error
+	d.AStats, err = a.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.BStats, err = b.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	na, err := a.root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := b.root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = d.diffNodes(ctx, a, b, nil, na, nb)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+/*
+Count returns the number of changes of the given kind.
+*/
+func (d *Diff) Count(kind DiffKind) int {
+	count := 0
+	for _, c := range d.Changes {
+		if c.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+func (d *Diff) diffNodes(ctx context.Context, a, b *Tree, path []string, na, nb *Node) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	subnodesA, err := subnodesByCriterion(ctx, a, na)
+	if err != nil {
+		return err
+	}
+	subnodesB, err := subnodesByCriterion(ctx, b, nb)
+	if err != nil {
+		return err
+	}
+	change := &NodeDiff{Path: path, A: na, B: nb, ASplit: describeSplit(na, subnodesA), BSplit: describeSplit(nb, subnodesB)}
+	switch {
+	case na.SubtreeFeature == nil && nb.SubtreeFeature == nil:
+		if predictedValueOf(na) != predictedValueOf(nb) {
+			change.Kind = DiffPredictionChanged
+			d.Changes = append(d.Changes, change)
+		}
+		return nil
+	case na.SubtreeFeature == nil:
+		change.Kind = DiffSplitAdded
+		d.Changes = append(d.Changes, change)
+		return nil
+	case nb.SubtreeFeature == nil:
+		change.Kind = DiffSplitRemoved
+		d.Changes = append(d.Changes, change)
+		return nil
+	}
+	changed := na.SubtreeFeature.Name() != nb.SubtreeFeature.Name() || len(subnodesA) != len(subnodesB)
+	for c := range subnodesA {
+		if _, ok := subnodesB[c]; !ok {
+			changed = true
+		}
+	}
+	if changed {
+		change.Kind = DiffSplitChanged
+		d.Changes = append(d.Changes, change)
+	}
+	criteria := make([]string, 0, len(subnodesA))
+	for c := range subnodesA {
+		if _, ok := subnodesB[c]; ok {
+			criteria = append(criteria, c)
+		}
+	}
+	sort.Strings(criteria)
+	for _, c := range criteria {
+		subpath := append(append([]string(nil), path...), c)
+		err = d.diffNodes(ctx, a, b, subpath, subnodesA[c], subnodesB[c])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nd *NodeDiff) String() string {
+	path := "root"
+	if len(nd.Path) > 0 {
+		path = strings.Join(nd.Path, " > ")
+	}
+	return fmt.Sprintf("%v at %s: %s => %s", nd.Kind, path, nd.ASplit, nd.BSplit)
+}
+
+/*
+describeSplit takes a node and its subtree nodes by criterion and returns
+the description of its split, or of the value it predicts if it is a leaf.
+*/
+func describeSplit(n *Node, subnodes map[string]*Node) string {
+	if n.SubtreeFeature == nil {
+		if n.Prediction == nil {
+			return "leaf with no prediction"
+		}
+		v, p := n.Prediction.PredictedValue()
+		return fmt.Sprintf("leaf predicting %s (%f)", v, p)
+	}
+	criteria := make([]string, 0, len(subnodes))
+	for c := range subnodes {
+		criteria = append(criteria, c)
+	}
+	sort.Strings(criteria)
+	return fmt.Sprintf("split on %s into %s", n.SubtreeFeature.Name(), strings.Join(criteria, "; "))
+}
+
+/*
+predictedValueOf takes a node and returns the value it predicts, or an
+empty string if it has no prediction.
+*/
+func predictedValueOf(n *Node) string {
+	if n.Prediction == nil {
+		return ""
+	}
+	v, _ := n.Prediction.PredictedValue()
+	return v
+}
+
+/*
+subnodesByCriterion takes a context, a tree and one of its nodes and returns
+the subtree nodes of the node by the string of their criterion.
+*/
+func subnodesByCriterion(ctx context.Context, t *Tree, n *Node) (map[string]*Node, error) {
+	subnodes, err := GetNodes(ctx, t.NodeStore, n.SubtreeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving subtrees of node %v: %w", n.ID, err)
+	}
+	result := make(map[string]*Node, len(subnodes))
+	for i, sn := range subnodes {
+		if sn == nil {
+			return nil, fmt.Errorf("%w: %v", ErrNodeNotFound, n.SubtreeIDs[i])
+		}
+		result[fmt.Sprintf("%v", sn.FeatureCriterion)] = sn
+	}
+	return result, nil
+}
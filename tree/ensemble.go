@@ -0,0 +1,130 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+Ensemble represents a boosted ensemble of trees that predict the same class
+feature. Every tree in Trees has the weight at the same position in Weights,
+which determines how much its vote counts towards the predictions of the
+ensemble.
+*/
+type Ensemble struct {
+	ClassFeature feature.Feature
+	Trees        []*Tree
+	Weights      []float64
+}
+
+/*
+NewEnsemble takes a class feature and returns an empty ensemble of trees
+to predict it.
+*/
+func NewEnsemble(classFeature feature.Feature) *Ensemble {
+	return &Ensemble{ClassFeature: classFeature}
+}
+
+/*
+Add takes a tree and a weight and adds the tree to the ensemble with the
+given weight.
+*/
+func (e *Ensemble) Add(t *Tree, weight float64) {
+	e.Trees = append(e.Trees, t)
+	e.Weights = append(e.Weights, weight)
+}
+
+/*
+Predict takes a sample and returns a prediction according to the ensemble
+and an error if the prediction could not be made. Every tree in the ensemble
+able to predict the sample votes for its predicted value with its weight,
+and the probability of every value in the resulting prediction is the share
+of the votes it received. The weight of the prediction is the number of
+trees that voted. If no tree can predict the sample,
+ErrCannotPredictFromSample is returned.
+*/
+func (e *Ensemble) Predict(ctx context.Context, s feature.Sample) (*Prediction, error) {
+	votes := make(map[string]float64)
+	var totalVotes float64
+	var voters int
+	for i, t := range e.Trees {
+		p, err := t.Predict(ctx, s)
+		if err != nil {
+			if err == ErrCannotPredictFromSample {
+				continue
+			}
+			return nil, err
+		}
+		v, _ := p.PredictedValue()
+		votes[v] += e.Weights[i]
+		totalVotes += e.Weights[i]
+		voters++
+	}
+	if voters == 0 || totalVotes <= 0 {
+		return nil, ErrCannotPredictFromSample
+	}
+	for v := range votes {
+		votes[v] /= totalVotes
+	}
+	return NewPrediction(votes, voters), nil
+}
+
+/*
+Evaluate takes a context.Context, a Set and a z-score and tests the ensemble
+against the samples in the set, returning an Evaluation with the success
+rate and its Wilson score confidence interval for the given z-score. If the
+z-score is not positive, DefaultConfidenceZ is used. Samples for which the
+ensemble cannot make a prediction count as failed predictions. As an
+ensemble has no leaves of its own, no leaf evaluations are included. The
+samples are evaluated as EvaluateConcurrently does with a worker for every
+CPU. An error is returned if the samples cannot be retrieved from the set
+or a prediction cannot be made for reasons other than the ensemble not
+being able to do so.
+*/
+func (e *Ensemble) Evaluate(ctx context.Context, s set.Set, z float64) (*Evaluation, error) {
+	return e.EvaluateConcurrently(ctx, s, z, 0)
+}
+
+/*
+EvaluateConcurrently takes a context.Context, a Set, a z-score and a number
+of workers and returns the same Evaluation as Evaluate, with the samples of
+the set evaluated by the given number of concurrent workers, or one for
+every CPU if it is not positive, streaming the sets that are set.Readers.
+*/
+func (e *Ensemble) EvaluateConcurrently(ctx context.Context, s set.Set, z float64, concurrency int) (*Evaluation, error) {
+	if z <= 0 {
+		z = DefaultConfidenceZ
+	}
+	ev := &Evaluation{}
+	err := evaluateSamples(ctx, s, concurrency, e.evaluateSample, ev.add)
+	if err != nil {
+		return nil, err
+	}
+	ev.SuccessRate, ev.LowerBound, ev.UpperBound = WilsonInterval(ev.Successes, ev.Samples, z)
+	return ev, nil
+}
+
+/*
+evaluateSample takes a context and a sample and returns the evaluation of
+the ensemble for the sample, or an error if a prediction cannot be made for
+reasons other than the ensemble not being able to do so or the sample has
+no valid value for the class feature.
+*/
+func (e *Ensemble) evaluateSample(ctx context.Context, sample set.Sample) (*sampleEvaluation, error) {
+	p, err := e.Predict(ctx, sample)
+	if err != nil {
+		if err != ErrCannotPredictFromSample {
+			return nil, err
+		}
+		return &sampleEvaluation{unpredicted: true}, nil
+	}
+	v, err := sample.ValueFor(e.ClassFeature)
+	if err != nil {
+		return nil, err
+	}
+	pV, _ := p.PredictedValue()
+	return &sampleEvaluation{success: pV == v, prediction: p, value: fmt.Sprintf("%v", v)}, nil
+}
@@ -0,0 +1,285 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+DefaultConfidenceZ is the z-score used to compute the confidence intervals
+of an Evaluation when none is given. It corresponds to a 95% confidence
+level.
+*/
+const DefaultConfidenceZ = 1.96
+
+/*
+Evaluation holds the results of testing a tree against a set of samples:
+the number of samples tested, how many of them were predicted correctly,
+how many could not be predicted, the success rate with its Wilson score
+confidence interval and the evaluation of every leaf of the tree that
+was reached by a sample.
+*/
+type Evaluation struct {
+	Samples     int
+	Successes   int
+	Unpredicted int
+	SuccessRate float64
+	LowerBound  float64
+	UpperBound  float64
+	Leaves      []*LeafEvaluation
+	scores      scoreCounts
+}
+
+/*
+LeafEvaluation holds the results of testing a tree against a set of samples
+for a single leaf of the tree: the ID of the leaf node, its support, that is
+the weight of its prediction or number of training samples it was made from,
+the number of test samples that reached it, how many of them it predicted
+correctly and its success rate with its Wilson score confidence interval.
+*/
+type LeafEvaluation struct {
+	NodeID      string
+	Support     int
+	Samples     int
+	Successes   int
+	SuccessRate float64
+	LowerBound  float64
+	UpperBound  float64
+}
+
+/*
+Evaluate takes a context.Context, a Set and a z-score and tests the tree
+against the samples in the set, returning an Evaluation with the overall and
+per-leaf success rates and their Wilson score confidence intervals for the
+given z-score. If the z-score is not positive, DefaultConfidenceZ is used.
+Samples for which the tree cannot make a prediction count as failed
+predictions. Leaves are evaluated on the samples that reach them following
+the tree's Path. The samples are evaluated as EvaluateConcurrently does with
+a worker for every CPU. An error is returned if the samples cannot be
+retrieved from the set or the tree cannot be traversed for a sample.
+*/
+func (t *Tree) Evaluate(ctx context.Context, s set.Set, z float64) (*Evaluation, error) {
+	return t.EvaluateConcurrently(ctx, s, z, 0)
+}
+
+/*
+EvaluateConcurrently takes a context.Context, a Set, a z-score and a number
+of workers and returns the same Evaluation as Evaluate, with the samples of
+the set evaluated by the given number of concurrent workers, or one for
+every CPU if it is not positive. Sets that are set.Readers, such as those
+on SQL databases, are streamed instead of being loaded in memory, so that
+large sets can be tested with a bounded amount of memory.
+*/
+func (t *Tree) EvaluateConcurrently(ctx context.Context, s set.Set, z float64, concurrency int) (*Evaluation, error) {
+	if z <= 0 {
+		z = DefaultConfidenceZ
+	}
+	ev := &Evaluation{}
+	if t == nil {
+		return ev, nil
+	}
+	leaves := make(map[string]*LeafEvaluation)
+	err := evaluateSamples(ctx, s, concurrency, t.evaluateSample, func(se *sampleEvaluation) {
+		ev.add(se)
+		if se.leaf == nil {
+			return
+		}
+		le, ok := leaves[se.leaf.ID]
+		if !ok {
+			le = &LeafEvaluation{NodeID: se.leaf.ID, Support: se.leaf.Prediction.Weight()}
+			leaves[se.leaf.ID] = le
+			ev.Leaves = append(ev.Leaves, le)
+		}
+		le.Samples++
+		if se.success {
+			le.Successes++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	ev.SuccessRate, ev.LowerBound, ev.UpperBound = WilsonInterval(ev.Successes, ev.Samples, z)
+	for _, le := range ev.Leaves {
+		le.SuccessRate, le.LowerBound, le.UpperBound = WilsonInterval(le.Successes, le.Samples, z)
+	}
+	sort.Slice(ev.Leaves, func(i, j int) bool {
+		if ev.Leaves[i].Samples != ev.Leaves[j].Samples {
+			return ev.Leaves[i].Samples > ev.Leaves[j].Samples
+		}
+		return ev.Leaves[i].NodeID < ev.Leaves[j].NodeID
+	})
+	return ev, nil
+}
+
+/*
+sampleEvaluation is the result of testing a tree or ensemble against a
+single sample: whether it could not be predicted or was predicted
+correctly, the leaf of the tree it reached, if any, the prediction made
+for it and its value for the class feature.
+*/
+type sampleEvaluation struct {
+	unpredicted bool
+	success     bool
+	leaf        *Node
+	prediction  *Prediction
+	value       string
+}
+
+/*
+evaluationBufferSize is the number of samples and of sample evaluations
+buffered for every worker of evaluateSamples, so that reading the samples of
+a set and evaluating them overlap.
+*/
+const evaluationBufferSize = 64
+
+/*
+add takes the evaluation of a sample and adds it to the counts of the
+evaluation.
+*/
+func (ev *Evaluation) add(se *sampleEvaluation) {
+	ev.Samples++
+	if se.unpredicted {
+		ev.Unpredicted++
+	}
+	if se.success {
+		ev.Successes++
+	}
+	if se.prediction != nil {
+		if ev.scores == nil {
+			ev.scores = make(scoreCounts)
+		}
+		ev.scores.add(se.prediction, se.value)
+	}
+}
+
+/*
+evaluateSample takes a context and a sample and returns the evaluation of
+the tree for the sample, or an error if the tree cannot be traversed for
+it or the sample has no valid value for the class feature.
+*/
+func (t *Tree) evaluateSample(ctx context.Context, sample set.Sample) (*sampleEvaluation, error) {
+	path, err := t.Path(ctx, sample)
+	if err != nil {
+		return nil, err
+	}
+	n := path[len(path)-1]
+	leaf := n.SubtreeFeature == nil && n.Prediction != nil
+	prediction := n.Prediction
+	if t.MissingValueStrategy == MissingValueFractional {
+		prediction, err = t.Predict(ctx, sample)
+		if err != nil {
+			if err != ErrCannotPredictFromSample {
+				return nil, err
+			}
+			return &sampleEvaluation{unpredicted: true}, nil
+		}
+	} else if !leaf {
+		return &sampleEvaluation{unpredicted: true}, nil
+	}
+	v, err := sample.ValueFor(t.ClassFeature)
+	if err != nil {
+		return nil, err
+	}
+	pV, _ := prediction.PredictedValue()
+	se := &sampleEvaluation{success: pV == v, prediction: prediction, value: fmt.Sprintf("%v", v)}
+	if leaf {
+		se.leaf = n
+	}
+	return se, nil
+}
+
+/*
+evaluateSamples takes a context, a set, a number of workers, a function to
+evaluate a sample and a function to record the evaluation of a sample. It
+streams the samples of the set with set.Stream to the given number of
+concurrent workers, or one for every CPU if it is not positive, which
+evaluate them with the evaluate function, and calls the record function
+with every evaluation from the calling goroutine, so that it needs no
+synchronization. It stops on the first error evaluating a sample and
+returns it, or returns the error streaming the samples of the set or the
+error of the context, if any.
+*/
+func evaluateSamples(ctx context.Context, s set.Set, concurrency int, evaluate func(context.Context, set.Sample) (*sampleEvaluation, error), record func(*sampleEvaluation)) error {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sampleStream, errStream := set.Stream(wctx, s)
+	buffered := make(chan set.Sample, concurrency*evaluationBufferSize)
+	go func() {
+		defer close(buffered)
+		for sample := range sampleStream {
+			select {
+			case buffered <- sample:
+			case <-wctx.Done():
+				for range sampleStream {
+				}
+				return
+			}
+		}
+	}()
+	results := make(chan *sampleEvaluation, concurrency*evaluationBufferSize)
+	errs := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sample := range buffered {
+				se, err := evaluate(wctx, sample)
+				if err != nil {
+					errs <- err
+					cancel()
+					for range buffered {
+					}
+					return
+				}
+				results <- se
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for se := range results {
+		record(se)
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	if err := <-errStream; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+/*
+WilsonInterval takes a number of successes, a total number of trials and a
+z-score and returns the success rate and the lower and upper bounds of its
+Wilson score confidence interval. If there are no trials, the success rate
+is 0 and the interval covers from 0 to 1.
+*/
+func WilsonInterval(successes, total int, z float64) (rate, lower, upper float64) {
+	if total <= 0 {
+		return 0.0, 0.0, 1.0
+	}
+	n := float64(total)
+	rate = float64(successes) / n
+	z2 := z * z
+	denominator := 1 + z2/n
+	center := (rate + z2/(2*n)) / denominator
+	margin := z * math.Sqrt(rate*(1-rate)/n+z2/(4*n*n)) / denominator
+	lower = math.Max(0.0, center-margin)
+	upper = math.Min(1.0, center+margin)
+	return rate, lower, upper
+}
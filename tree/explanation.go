@@ -0,0 +1,94 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+Explanation is the decision path followed by a tree to predict a sample:
+the nodes the sample went through, from the root node on, and the resulting
+prediction, which is nil if the tree could not make one.
+*/
+type Explanation struct {
+	Steps      []*ExplanationStep
+	Prediction *Prediction
+}
+
+/*
+ExplanationStep is a node of the decision path followed by a tree to
+predict a sample, with the criterion that selected it, nil for the root
+node, and the prediction for the samples reaching it when the tree was
+grown. MissingValue is true if the sample did not satisfy the criterion and
+the node was selected by the MissingValueStrategy of the tree instead,
+because the sample had no value for the feature of the criterion.
+*/
+type ExplanationStep struct {
+	NodeID       string
+	Criterion    feature.Criterion
+	Prediction   *Prediction
+	SampleCount  int
+	MissingValue bool
+}
+
+/*
+Explain takes a context and a sample and returns the explanation of the
+prediction of the sample by the tree: the nodes of its Path with the
+criteria that selected them and their predictions, and the prediction
+returned by Predict, so that users can see why a prediction was made. If
+the tree cannot predict the sample, the explanation shows how far it got,
+with a nil prediction. An error is returned if the path or the prediction
+cannot be computed for any other reason.
+*/
+func (t *Tree) Explain(ctx context.Context, s feature.Sample) (*Explanation, error) {
+	path, err := t.Path(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explanation{Steps: make([]*ExplanationStep, 0, len(path))}
+	for _, n := range path {
+		step := &ExplanationStep{
+			NodeID:      n.ID,
+			Criterion:   n.FeatureCriterion,
+			Prediction:  n.Prediction,
+			SampleCount: n.SampleCount,
+		}
+		if n.FeatureCriterion != nil {
+			ok, err := n.FeatureCriterion.SatisfiedBy(s)
+			if err != nil {
+				return nil, err
+			}
+			step.MissingValue = !ok
+		}
+		e.Steps = append(e.Steps, step)
+	}
+	e.Prediction, err = t.Predict(ctx, s)
+	if err != nil && err != ErrCannotPredictFromSample {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Explanation) String() string {
+	var b strings.Builder
+	for i, step := range e.Steps {
+		fmt.Fprintf(&b, "%d. [%s]", i+1, step.NodeID)
+		if step.Criterion != nil {
+			fmt.Fprintf(&b, "{ %v }", step.Criterion)
+		}
+		if step.MissingValue {
+			b.WriteString(" (missing value)")
+		}
+		if step.Prediction != nil {
+			fmt.Fprintf(&b, " -> %v from %d samples", step.Prediction, step.SampleCount)
+		}
+		b.WriteString("\n")
+	}
+	if e.Prediction == nil {
+		fmt.Fprintf(&b, "%v\n", ErrCannotPredictFromSample)
+	}
+	return b.String()
+}
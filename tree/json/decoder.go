@@ -0,0 +1,73 @@
+package json
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+MaxValueSize is the maximum number of bytes ReadJSONTree and
+ReadJSONEnsemble read to decode a single node of a tree, or any other field
+of a tree or an ensemble, so that a hostile payload cannot make them hold
+an unbounded amount of memory.
+*/
+const MaxValueSize = 16 << 20
+
+/*
+ErrTooLarge is the error wrapped by the errors returned when a node or a
+field of a serialized tree takes more than MaxValueSize bytes.
+*/
+var ErrTooLarge = errors.New("JSON value too large")
+
+/*
+decoder is a json.Decoder that reads through a sizeLimit, so that the values
+of trees it decodes have a bounded size.
+*/
+type decoder struct {
+	*json.Decoder
+	limit *sizeLimit
+}
+
+/*
+newDecoder takes an io.Reader and returns a decoder that reads from it
+through a buffer, allowing MaxValueSize bytes between resets of its limit.
+*/
+func newDecoder(r io.Reader) *decoder {
+	sl := &sizeLimit{r: bufio.NewReader(r), max: MaxValueSize}
+	return &decoder{Decoder: json.NewDecoder(sl), limit: sl}
+}
+
+/*
+sizeLimit is an io.Reader that reads from another one and fails with an
+error wrapping ErrTooLarge once more than max bytes have been read since it
+was last reset. The bytes a json.Decoder reads ahead while decoding a value
+count towards that value, so every value may take at most max bytes plus
+those read ahead for the previous one.
+*/
+type sizeLimit struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (sl *sizeLimit) Read(p []byte) (int, error) {
+	if sl.read >= sl.max {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, sl.max)
+	}
+	if int64(len(p)) > sl.max-sl.read {
+		p = p[:sl.max-sl.read]
+	}
+	n, err := sl.r.Read(p)
+	sl.read += int64(n)
+	return n, err
+}
+
+/*
+reset allows max bytes to be read again.
+*/
+func (sl *sizeLimit) reset() {
+	sl.read = 0
+}
@@ -0,0 +1,143 @@
+package json
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+FormatVersion is the version of the format in which WriteJSONTree and
+WriteJSONEnsemble serialize trees and ensembles. Trees and ensembles with a
+later version cannot be read, as they may have fields that change the
+meaning of the rest. Version 2 added the smoothing of trees, which changes
+the meaning of the predictions of their nodes.
+*/
+const FormatVersion = 2
+
+/*
+ErrChecksumMismatch is the error returned, wrapped with the details, when
+the checksum of a serialized tree does not match its contents, as happens
+when the file has been truncated or modified.
+*/
+var ErrChecksumMismatch = errors.New("tree checksum mismatch")
+
+/*
+ErrFeaturesMismatch is the error returned, wrapped with the details, when the
+features a serialized tree was written with differ from the ones given to
+read it, as happens when a tree is read with a metadata file other than the
+one it was grown with.
+*/
+var ErrFeaturesMismatch = errors.New("tree features mismatch")
+
+/*
+treeDigest accumulates the checksum of the nodes of a tree and the features
+they use as they are written or read.
+*/
+type treeDigest struct {
+	nodes    hash.Hash
+	features map[string]feature.Feature
+}
+
+func newTreeDigest(classFeature feature.Feature) *treeDigest {
+	td := &treeDigest{nodes: sha256.New(), features: make(map[string]feature.Feature)}
+	if classFeature != nil {
+		td.features[classFeature.Name()] = classFeature
+	}
+	return td
+}
+
+/*
+add takes a node and its serialization as compact JSON and adds them to the
+digest.
+*/
+func (td *treeDigest) add(n *tree.Node, jn []byte) {
+	td.nodes.Write(jn)
+	td.nodes.Write([]byte{'\n'})
+	for _, f := range n.Features() {
+		td.features[f.Name()] = f
+	}
+}
+
+/*
+addRaw takes a node and its serialization as read, which may not be compact,
+and adds them to the digest.
+*/
+func (td *treeDigest) addRaw(n *tree.Node, raw []byte) error {
+	var buf bytes.Buffer
+	err := json.Compact(&buf, raw)
+	if err != nil {
+		return err
+	}
+	td.add(n, buf.Bytes())
+	return nil
+}
+
+/*
+checksum takes the root ID, class feature name and missing value strategy
+of a tree and returns the hex-encoded SHA-256 checksum of the tree, which
+covers them and every node added to the digest, in order.
+*/
+func (td *treeDigest) checksum(rootID, classFeature, missingValueStrategy, smoothing string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%q\n%q\n", rootID, classFeature, missingValueStrategy)
+	if smoothing != "" {
+		fmt.Fprintf(h, "%q\n", smoothing)
+	}
+	fmt.Fprintf(h, "%x\n", td.nodes.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/*
+featuresHash returns the feature.MetadataHash of the class feature and the
+features used by the nodes added to the digest, so that it changes if a
+tree is read with features other than the ones it was written with.
+*/
+func (td *treeDigest) featuresHash() string {
+	features := make([]feature.Feature, 0, len(td.features))
+	for _, f := range td.features {
+		features = append(features, f)
+	}
+	return feature.MetadataHash(features)
+}
+
+/*
+checkFormatVersion takes the format version of a serialized tree or ensemble
+and returns an error if it cannot be read.
+*/
+func checkFormatVersion(version int) error {
+	if version > FormatVersion {
+		return fmt.Errorf("format version %d is not supported, the latest supported is %d: upgrade botanic to read it", version, FormatVersion)
+	}
+	return nil
+}
+
+/*
+verify takes the root ID, class feature name, missing value strategy, format
+version, features hash and checksum read for a tree and returns an error if
+the features hash or checksum do not match the ones computed on the digest.
+Trees with no format version were written before checksums were added, and
+are not verified.
+*/
+func (td *treeDigest) verify(rootID, classFeature, missingValueStrategy, smoothing string, version int, featuresHash, checksum string) error {
+	if version == 0 {
+		return nil
+	}
+	if featuresHash == "" || checksum == "" {
+		return fmt.Errorf("%w: the tree has format version %d but no features hash or checksum", ErrChecksumMismatch, version)
+	}
+	if fh := td.featuresHash(); fh != featuresHash {
+		return fmt.Errorf("%w: the tree was written with features whose metadata hashes to %s, but the given ones hash to %s; check that the metadata is the one the tree was grown with", ErrFeaturesMismatch, featuresHash, fh)
+	}
+	if cs := td.checksum(rootID, classFeature, missingValueStrategy, smoothing); cs != checksum {
+		return fmt.Errorf("%w: expected %s but the contents hash to %s; the tree is corrupt or was modified", ErrChecksumMismatch, checksum, cs)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package json
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pbanos/botanic/feature"
+
+	"github.com/pbanos/botanic/tree"
+)
+
+/*
+WriteJSONEnsemble takes a context.Context, a pointer to a tree.Ensemble and
+an io.Writer and serializes the given ensemble as JSON onto the io.Writer.
+An ensemble is serialized as a JSON object with the following fields:
+* "formatVersion": a number with the FormatVersion of the serialization
+* "classFeature": a string with the name of the feature the ensemble predicts
+* "trees": an array with an object for every tree of the ensemble with the
+  following fields:
+  * "weight": a number with the weight of the tree in the ensemble
+  * "tree": the tree serialized as WriteJSONTree does
+Trees are written one at a time through a fixed-size buffer, as
+WriteJSONTree does.
+An error is returned if any of the trees cannot be traversed, serialized or
+written onto the io.Writer.
+*/
+func WriteJSONEnsemble(ctx context.Context, e *tree.Ensemble, w io.Writer) error {
+	bw := bufio.NewWriterSize(w, writeBufferSize)
+	jFeatureName, err := json.Marshal(e.ClassFeature.Name())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(bw, `{"formatVersion":%d,"classFeature":%s,"trees":[`, FormatVersion, jFeatureName)
+	if err != nil {
+		return err
+	}
+	for i, t := range e.Trees {
+		if i != 0 {
+			_, err = bw.Write([]byte(","))
+			if err != nil {
+				return err
+			}
+		}
+		jWeight, err := json.Marshal(e.Weights[i])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(bw, `{"weight":%s,"tree":`, jWeight)
+		if err != nil {
+			return err
+		}
+		err = writeJSONTree(ctx, t, bw)
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write([]byte("}"))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = bw.Write([]byte(`]}`))
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+/*
+ReadJSONEnsemble takes a context.Context, a pointer to a tree.Ensemble, a
+slice of features, an io.Reader and a function that returns a new
+tree.NodeStore, and unmarshals the contents of the io.Reader onto the given
+ensemble. Every tree of the ensemble is read as ReadJSONTree does onto a
+tree with a NodeStore returned by the given function.
+An ensemble is expected to be a JSON object with the fields described for
+WriteJSONEnsemble.
+An error is returned if the JSON cannot be read from the io.Reader or
+unmarshalled onto the ensemble, if its format version is later than
+FormatVersion or if any of its trees cannot be read or verified. Fields of
+the ensemble and nodes of its trees may take up to MaxValueSize bytes.
+*/
+func ReadJSONEnsemble(ctx context.Context, e *tree.Ensemble, features []feature.Feature, r io.Reader, newNodeStore func() tree.NodeStore) error {
+	dec := newDecoder(r)
+	err := expectDelim(dec.Decoder, '{')
+	if err != nil {
+		return err
+	}
+	var classFeature string
+	for dec.More() {
+		dec.limit.reset()
+		key, err := objectKey(dec.Decoder)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "formatVersion":
+			var version int
+			err = dec.Decode(&version)
+			if err == nil {
+				err = checkFormatVersion(version)
+			}
+		case "classFeature":
+			err = dec.Decode(&classFeature)
+		case "trees":
+			err = readEnsembleTrees(ctx, e, features, dec, newNodeStore)
+		default:
+			var ignored json.RawMessage
+			err = dec.Decode(&ignored)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = expectDelim(dec.Decoder, '}')
+	if err != nil {
+		return err
+	}
+	e.ClassFeature = featureNamed(features, classFeature)
+	if e.ClassFeature == nil {
+		return fmt.Errorf("no class feature defined")
+	}
+	return nil
+}
+
+/*
+readEnsembleTrees takes a context.Context, an ensemble, a slice of features,
+a decoder positioned at the start of the JSON array of trees of a serialized
+ensemble and a function that returns a new tree.NodeStore, and decodes the
+trees one at a time adding them to the ensemble.
+*/
+func readEnsembleTrees(ctx context.Context, e *tree.Ensemble, features []feature.Feature, dec *decoder, newNodeStore func() tree.NodeStore) error {
+	err := expectDelim(dec.Decoder, '[')
+	if err != nil {
+		return err
+	}
+	for dec.More() {
+		err = expectDelim(dec.Decoder, '{')
+		if err != nil {
+			return err
+		}
+		var weight float64
+		var t *tree.Tree
+		for dec.More() {
+			dec.limit.reset()
+			key, err := objectKey(dec.Decoder)
+			if err != nil {
+				return err
+			}
+			switch key {
+			case "weight":
+				err = dec.Decode(&weight)
+			case "tree":
+				t = &tree.Tree{NodeStore: newNodeStore()}
+				err = readJSONTree(ctx, t, features, dec)
+			default:
+				var ignored json.RawMessage
+				err = dec.Decode(&ignored)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		err = expectDelim(dec.Decoder, '}')
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("ensemble tree with weight %v has no tree", weight)
+		}
+		e.Add(t, weight)
+	}
+	return expectDelim(dec.Decoder, ']')
+}
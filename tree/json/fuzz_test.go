@@ -0,0 +1,214 @@
+package json
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pbanos/botanic/feature"
+	"github.com/pbanos/botanic/tree"
+)
+
+func fuzzFeatures() []feature.Feature {
+	return []feature.Feature{
+		feature.NewDiscreteFeature("class", []string{"yes", "no"}),
+		feature.NewDiscreteFeature("d", []string{"a", "b", "c"}),
+		feature.NewContinuousFeature("x"),
+		feature.NewBooleanFeature("b"),
+	}
+}
+
+/*
+fuzzCriteria returns criteria of every kind on the fuzzFeatures, to seed the
+fuzz targets.
+*/
+func fuzzCriteria() []feature.Criterion {
+	features := fuzzFeatures()
+	d := features[1].(*feature.DiscreteFeature)
+	x := features[2].(*feature.ContinuousFeature)
+	b := features[3].(*feature.BooleanFeature)
+	return []feature.Criterion{
+		feature.NewContinuousCriterion(x, math.Inf(-1), 2),
+		feature.NewContinuousCriterion(x, 2, math.Inf(1)),
+		feature.NewDiscreteCriterion(d, "a"),
+		feature.NewDiscreteValuesCriterion(d, []string{"b", "c"}),
+		feature.NewBooleanCriterion(b, true),
+		feature.NewUndefinedCriterion(d),
+		feature.NewUndefinedValueCriterion(x),
+		feature.IncludingUndefined(feature.NewDiscreteCriterion(d, "b")),
+	}
+}
+
+/*
+fuzzTree returns a tree branched out on every kind of criterion returned by
+fuzzCriteria, serialized by WriteJSONTree, to seed the fuzz targets.
+*/
+func fuzzTree(tb testing.TB) []byte {
+	ctx := context.Background()
+	features := fuzzFeatures()
+	ns := tree.NewMemoryNodeStore()
+	root := &tree.Node{Prediction: tree.NewPrediction(map[string]float64{"yes": 0.5, "no": 0.5}, 8), SubtreeFeature: features[2]}
+	err := ns.Create(ctx, root)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i, c := range fuzzCriteria() {
+		n := &tree.Node{ParentID: root.ID, FeatureCriterion: c, Depth: 1, SampleCount: i}
+		if i%2 == 0 {
+			n.Prediction = tree.NewPrediction(map[string]float64{"yes": 1}, i)
+		}
+		err = ns.Create(ctx, n)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		root.SubtreeIDs = append(root.SubtreeIDs, n.ID)
+	}
+	err = ns.Store(ctx, root)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = WriteJSONTree(ctx, tree.New(root.ID, ns, features[0]), &buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readTree(b []byte) (*tree.Tree, error) {
+	t := &tree.Tree{NodeStore: tree.NewMemoryNodeStore()}
+	err := ReadJSONTree(context.Background(), t, fuzzFeatures(), bytes.NewReader(b))
+	return t, err
+}
+
+func TestReadJSONTreeRejectsMissingSubtrees(t *testing.T) {
+	_, err := readTree([]byte(`{"rootID":"r","classFeature":"class","nodes":[{"id":"r","subtreeIds":["x"]}]}`))
+	if !errors.Is(err, tree.ErrNodeNotFound) {
+		t.Fatalf("expected an error wrapping tree.ErrNodeNotFound, got %v", err)
+	}
+}
+
+func TestReadJSONTreeRejectsTooLargeNodes(t *testing.T) {
+	payload := `{"rootID":"r","classFeature":"class","nodes":[{"id":"r","padding":"` + strings.Repeat("a", MaxValueSize) + `"}]}`
+	_, err := readTree([]byte(payload))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected an error wrapping ErrTooLarge, got %v", err)
+	}
+}
+
+/*
+FuzzReadJSONTree checks that ReadJSONTree does not panic on any input, and
+that every tree it reads can be traversed and printed.
+*/
+func FuzzReadJSONTree(f *testing.F) {
+	seed := fuzzTree(f)
+	if _, err := readTree(seed); err != nil {
+		f.Fatalf("reading seed tree: %v", err)
+	}
+	f.Add(seed)
+	f.Add([]byte(`{"rootID":"r","classFeature":"class","nodes":[{"id":"r","subtreeIds":["x"]}]}`))
+	f.Add([]byte(`{"rootID":"r","classFeature":"class","nodes":[{"id":"r","subtreeIds":["r"]}]}`))
+	f.Add([]byte(`{"rootID":"r","classFeature":"class","nodes":[{"id":"r","subtreeIds":["1"]},{"id":"1","subtreeIds":["r"]}]}`))
+	f.Add([]byte(`{"formatVersion":1,"rootID":"r","classFeature":"class","nodes":[{"id":"r"}],"featuresHash":"","checksum":""}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		tr, err := readTree(b)
+		if err != nil {
+			return
+		}
+		err = tr.Traverse(context.Background(), false, func(ctx context.Context, n *tree.Node) error {
+			if n == nil {
+				return errors.New("nil node traversed")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("traversing tree read without errors: %v", err)
+		}
+		if s := tr.String(); strings.Contains(s, "ERROR") {
+			t.Fatalf("printing tree read without errors: %s", s)
+		}
+	})
+}
+
+/*
+FuzzUnmarshalJSONNode checks that UnmarshalJSONNodeWithFeatures does not
+panic on any input, and that the nodes it decodes are encoded and decoded
+again without errors.
+*/
+func FuzzUnmarshalJSONNode(f *testing.F) {
+	features := fuzzFeatures()
+	for i, c := range fuzzCriteria() {
+		n := &tree.Node{ID: strconv.Itoa(i), ParentID: "r", FeatureCriterion: c, SubtreeIDs: []string{"s"}, SubtreeFeature: features[1], Prediction: tree.NewPrediction(map[string]float64{"no": 1}, i)}
+		b, err := MarshalJSONNode(n)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte(`{"id":"1","criterion":{"type":"continuous","feature":"x","a":"NaN","b":"2"}}`))
+	f.Add([]byte(`{"id":"1","surrogate":{"feature":"b","criteria":[null],"subtreeIds":["2"]}}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		n := &tree.Node{}
+		if UnmarshalJSONNodeWithFeatures(n, b, features) != nil {
+			return
+		}
+		eb, err := MarshalJSONNode(n)
+		if err != nil {
+			t.Fatalf("encoding node decoded without errors: %v", err)
+		}
+		err = UnmarshalJSONNodeWithFeatures(&tree.Node{}, eb, features)
+		if err != nil {
+			t.Fatalf("decoding %s encoded from %s: %v", eb, b, err)
+		}
+	})
+}
+
+/*
+FuzzUnmarshalJSONCriterion checks that UnmarshalJSONCriterion does not panic
+on any input, and that the criteria it decodes are encoded and decoded
+again without errors.
+*/
+func FuzzUnmarshalJSONCriterion(f *testing.F) {
+	for _, c := range fuzzCriteria() {
+		b, err := MarshalJSONCriterion(c)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	features := fuzzFeatures()
+	f.Fuzz(func(t *testing.T, b []byte) {
+		c, err := UnmarshalJSONCriterion(b, features)
+		if err != nil || c == nil {
+			return
+		}
+		eb, err := MarshalJSONCriterion(c)
+		if err != nil {
+			t.Fatalf("encoding criterion decoded without errors: %v", err)
+		}
+		_, err = UnmarshalJSONCriterion(eb, features)
+		if err != nil {
+			t.Fatalf("decoding %s encoded from %s: %v", eb, b, err)
+		}
+	})
+}
+
+/*
+FuzzUnmarshalJSONPrediction checks that UnmarshalJSONPrediction does not
+panic on any input.
+*/
+func FuzzUnmarshalJSONPrediction(f *testing.F) {
+	f.Add([]byte(`{"probabilities":{"yes":0.25,"no":0.75},"weight":4}`))
+	f.Add([]byte(`{"probabilities":{"yes":2},"weight":-1}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p, err := UnmarshalJSONPrediction(b)
+		if err != nil || p == nil {
+			return
+		}
+		p.PredictedValue()
+	})
+}
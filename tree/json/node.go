@@ -17,19 +17,39 @@ type node struct {
 	FeatureCriterion *json.RawMessage `json:"criterion,omitempty"`
 	SubtreeFeature   string           `json:"feature,omitempty"`
 	Prediction       *json.RawMessage `json:"prediction,omitempty"`
+	Surrogate        *jsonSurrogate   `json:"surrogate,omitempty"`
+	InformationGain  float64          `json:"informationGain,omitempty"`
+	Depth            int              `json:"depth,omitempty"`
+	SampleCount      int              `json:"sampleCount,omitempty"`
+}
+
+type jsonSurrogate struct {
+	Feature    string             `json:"feature"`
+	Criteria   []*json.RawMessage `json:"criteria"`
+	SubtreeIDs []string           `json:"subtreeIds"`
 }
 
 type jsonCriterion struct {
-	Type    string `json:"type"`
-	Feature string `json:"feature"`
-	Value   string `json:"value,omitempty"`
-	A       string `json:"a,omitempty"`
-	B       string `json:"b,omitempty"`
+	Type             string   `json:"type"`
+	Feature          string   `json:"feature"`
+	Value            string   `json:"value,omitempty"`
+	A                string   `json:"a,omitempty"`
+	B                string   `json:"b,omitempty"`
+	Values           []string `json:"values,omitempty"`
+	Buckets          int      `json:"buckets,omitempty"`
+	IncludeUndefined bool     `json:"includeUndefined,omitempty"`
 }
 
+/*
+probabilityTolerance is how much the probabilities of an unmarshalled
+prediction may exceed 1 to allow for rounding errors.
+*/
+const probabilityTolerance = 1e-9
+
 type jsonPrediction struct {
 	Probabilities map[string]float64 `json:"probabilities,omitempty"`
 	Weight        int                `json:"weight,omitempty"`
+	TotalWeight   float64            `json:"totalWeight,omitempty"`
 }
 
 /*
@@ -44,11 +64,23 @@ A node serialization includes the following properties:
   samples that distinguish it from its sibling nodes.
   * "feature": the feature on which the subtree nodes have a constraint, that is,
   the feature that is dividing the data
+  * "surrogate": the surrogate split of the node, if any, as an object with a
+  "feature" string with the name of the surrogate feature, a "criteria" array
+  with the criteria on it and a "subtreeIds" array with the ID of the subtree
+  each criterion selects.
+  * "informationGain": the information gain of the split of the node, omitted
+  for leaves.
+  * "depth": the depth of the node in the tree, omitted for the root node.
+  * "sampleCount": the number of training samples of the node, omitted if
+  there were none or it is unknown.
 */
 func MarshalJSONNode(n *tree.Node) ([]byte, error) {
 	jn := &node{
-		ID:       n.ID,
-		ParentID: n.ParentID,
+		ID:              n.ID,
+		ParentID:        n.ParentID,
+		InformationGain: n.InformationGain,
+		Depth:           n.Depth,
+		SampleCount:     n.SampleCount,
 	}
 	if len(n.SubtreeIDs) > 0 {
 		jn.SubtreeIDs = n.SubtreeIDs
@@ -62,7 +94,11 @@ func MarshalJSONNode(n *tree.Node) ([]byte, error) {
 		jn.FeatureCriterion = &rfc
 	}
 	if n.Prediction != nil {
-		p, err := json.Marshal(&jsonPrediction{Probabilities: n.Prediction.Probabilities(), Weight: n.Prediction.Weight()})
+		jp := &jsonPrediction{Probabilities: n.Prediction.Probabilities(), Weight: n.Prediction.Weight()}
+		if tw := n.Prediction.TotalWeight(); tw != float64(jp.Weight) {
+			jp.TotalWeight = tw
+		}
+		p, err := json.Marshal(jp)
 		if err != nil {
 			return nil, err
 		}
@@ -72,6 +108,18 @@ func MarshalJSONNode(n *tree.Node) ([]byte, error) {
 	if n.SubtreeFeature != nil {
 		jn.SubtreeFeature = n.SubtreeFeature.Name()
 	}
+	if n.Surrogate != nil {
+		js := &jsonSurrogate{Feature: n.Surrogate.Feature.Name(), SubtreeIDs: n.Surrogate.SubtreeIDs}
+		for _, c := range n.Surrogate.Criteria {
+			jc, err := MarshalJSONCriterion(c)
+			if err != nil {
+				return nil, err
+			}
+			rjc := json.RawMessage(jc)
+			js.Criteria = append(js.Criteria, &rjc)
+		}
+		jn.Surrogate = js
+	}
 	return json.Marshal(jn)
 }
 
@@ -79,7 +127,9 @@ func MarshalJSONNode(n *tree.Node) ([]byte, error) {
 UnmarshalJSONNodeWithFeatures takes a tree.Node, slice of bytes containing a serialized node
 and a slice with the available features and loads the serialized data into the given node.
 The slice of bytes is expected to have the node serialized in JSON format as generated by
-MarshalJSONNode.
+MarshalJSONNode. Unknown fields are ignored, so that nodes serialized by later versions
+can still be read, but an error is returned for nodes with no id, a negative depth or
+sample count, or subtree ids that are empty, repeated or the id of the node itself.
 */
 func UnmarshalJSONNodeWithFeatures(n *tree.Node, b []byte, features []feature.Feature) error {
 	jn := &node{}
@@ -87,6 +137,10 @@ func UnmarshalJSONNodeWithFeatures(n *tree.Node, b []byte, features []feature.Fe
 	if err != nil {
 		return err
 	}
+	err = jn.validate()
+	if err != nil {
+		return err
+	}
 	if jn.FeatureCriterion != nil {
 		n.FeatureCriterion, err = UnmarshalJSONCriterion(*jn.FeatureCriterion, features)
 		if err != nil {
@@ -101,6 +155,9 @@ func UnmarshalJSONNodeWithFeatures(n *tree.Node, b []byte, features []feature.Fe
 	}
 	n.ID = jn.ID
 	n.ParentID = jn.ParentID
+	n.InformationGain = jn.InformationGain
+	n.Depth = jn.Depth
+	n.SampleCount = jn.SampleCount
 	if len(jn.SubtreeIDs) > 0 {
 		n.SubtreeIDs = jn.SubtreeIDs
 	}
@@ -113,20 +170,81 @@ func UnmarshalJSONNodeWithFeatures(n *tree.Node, b []byte, features []feature.Fe
 			}
 		}
 		if nf == nil {
-			return fmt.Errorf("unmarshalling node %v: unknown feature %v", n.ID, jn.SubtreeFeature)
+			return fmt.Errorf("unmarshalling node %v: %w %v", n.ID, feature.ErrUnknownFeature, jn.SubtreeFeature)
 		}
 		n.SubtreeFeature = nf
 	}
+	if jn.Surrogate != nil {
+		n.Surrogate, err = jn.Surrogate.surrogate(features)
+		if err != nil {
+			return fmt.Errorf("unmarshalling node %v: %v", n.ID, err)
+		}
+	}
 	return nil
 }
 
+/*
+validate returns an error if the node has no id, a negative depth or sample
+count, or subtree ids that are empty, repeated or its own id, which would
+make traversing the tree loop or fail.
+*/
+func (jn *node) validate() error {
+	if jn.ID == "" {
+		return fmt.Errorf("unmarshalling node: no id")
+	}
+	if jn.Depth < 0 {
+		return fmt.Errorf("unmarshalling node %v: negative depth %d", jn.ID, jn.Depth)
+	}
+	if jn.SampleCount < 0 {
+		return fmt.Errorf("unmarshalling node %v: negative sample count %d", jn.ID, jn.SampleCount)
+	}
+	subtreeIDs := make(map[string]bool, len(jn.SubtreeIDs))
+	for _, id := range jn.SubtreeIDs {
+		if id == "" || id == jn.ID || subtreeIDs[id] {
+			return fmt.Errorf("unmarshalling node %v: invalid subtree id %q", jn.ID, id)
+		}
+		subtreeIDs[id] = true
+	}
+	return nil
+}
+
+func (js *jsonSurrogate) surrogate(features []feature.Feature) (*tree.Surrogate, error) {
+	if len(js.Criteria) != len(js.SubtreeIDs) {
+		return nil, fmt.Errorf("surrogate has %d criteria for %d subtrees", len(js.Criteria), len(js.SubtreeIDs))
+	}
+	s := &tree.Surrogate{SubtreeIDs: js.SubtreeIDs}
+	for _, f := range features {
+		if f.Name() == js.Feature {
+			s.Feature = f
+			break
+		}
+	}
+	if s.Feature == nil {
+		return nil, fmt.Errorf("unknown surrogate feature %v", js.Feature)
+	}
+	for _, jc := range js.Criteria {
+		if jc == nil {
+			return nil, fmt.Errorf("surrogate has a null criterion")
+		}
+		c, err := UnmarshalJSONCriterion(*jc, features)
+		if err != nil {
+			return nil, err
+		}
+		s.Criteria = append(s.Criteria, c)
+	}
+	return s, nil
+}
+
 /*
 MarshalJSONCriterion takes a feature.Criterion and returns a slice
 of bytes containing its serialization to JSON. It uses the
-MarshalJSONContinuousCriterion, MarshalJSONDiscreteCriterion and
-MarshalJSONUndefinedCriterion functions to serialize a
-feature.ContinuousCriterion, a feature.DiscreteCriterion or
-a feature.UndefinedCriterion respectively. It returns an error
+MarshalJSONContinuousCriterion, MarshalJSONDiscreteCriterion,
+MarshalJSONDiscreteValuesCriterion, MarshalJSONBooleanCriterion,
+MarshalJSONUndefinedCriterion and MarshalJSONUndefinedValueCriterion
+functions to serialize a feature.ContinuousCriterion, a
+feature.DiscreteCriterion, a feature.DiscreteValuesCriterion, a
+feature.BooleanCriterion, a feature.UndefinedCriterion or a
+feature.UndefinedValueCriterion respectively. It returns an error
 if the feature.Criterion is not one of these or if there is
 an error during the serialization.
 */
@@ -136,8 +254,14 @@ func MarshalJSONCriterion(fc feature.Criterion) ([]byte, error) {
 		return MarshalJSONContinuousCriterion(c)
 	case feature.DiscreteCriterion:
 		return MarshalJSONDiscreteCriterion(c)
+	case feature.DiscreteValuesCriterion:
+		return MarshalJSONDiscreteValuesCriterion(c)
+	case feature.BooleanCriterion:
+		return MarshalJSONBooleanCriterion(c)
 	case feature.UndefinedCriterion:
 		return MarshalJSONUndefinedCriterion(c)
+	case feature.UndefinedValueCriterion:
+		return MarshalJSONUndefinedValueCriterion(c)
 	default:
 		return nil, fmt.Errorf("unknown type of feature.Criterion %T", fc)
 	}
@@ -153,16 +277,19 @@ is a JSON object with the following fields:
 or the string "-Inf" if it has no finite start.
 * "b": a number specifying where the interval of the criterion ends
 or the string "+Inf" if it has no finite end.
+* "includeUndefined": true if the criterion is also satisfied by samples
+with no value for the feature, omitted otherwise.
 */
 func MarshalJSONContinuousCriterion(cfc feature.ContinuousCriterion) ([]byte, error) {
 	a, b := cfc.Interval()
 	sa := fmt.Sprintf("%f", a)
 	sb := fmt.Sprintf("%f", b)
 	return json.Marshal(&jsonCriterion{
-		Type:    "continuous",
-		Feature: cfc.Feature().Name(),
-		A:       sa,
-		B:       sb,
+		Type:             "continuous",
+		Feature:          cfc.Feature().Name(),
+		A:                sa,
+		B:                sb,
+		IncludeUndefined: feature.IncludesUndefined(cfc),
 	})
 }
 
@@ -173,12 +300,66 @@ is a JSON object with the following fields:
 * "type": a string set to "discrete"
 * "feature": a string set to the name of the feature of the criterion
 * "value": a string with the value that satisfies the criterion.
+* "buckets": the number of buckets the feature hashes its values into, for
+features that hash their values, omitted otherwise.
+* "includeUndefined": true if the criterion is also satisfied by samples
+with no value for the feature, omitted otherwise.
 */
 func MarshalJSONDiscreteCriterion(dfc feature.DiscreteCriterion) ([]byte, error) {
+	jc := &jsonCriterion{
+		Type:             "discrete",
+		Feature:          dfc.Feature().Name(),
+		Value:            dfc.Value(),
+		IncludeUndefined: feature.IncludesUndefined(dfc),
+	}
+	if df, ok := dfc.Feature().(*feature.DiscreteFeature); ok {
+		jc.Buckets = df.Buckets()
+	}
+	return json.Marshal(jc)
+}
+
+/*
+MarshalJSONDiscreteValuesCriterion takes a feature.DiscreteValuesCriterion
+and returns a serialization of it into JSON or an error. The serialization
+is a JSON object with the following fields:
+* "type": a string set to "discreteValues"
+* "feature": a string set to the name of the feature of the criterion
+* "values": an array with the values that satisfy the criterion.
+* "buckets": the number of buckets the feature hashes its values into, for
+features that hash their values, omitted otherwise.
+* "includeUndefined": true if the criterion is also satisfied by samples
+with no value for the feature, omitted otherwise.
+*/
+func MarshalJSONDiscreteValuesCriterion(dvc feature.DiscreteValuesCriterion) ([]byte, error) {
+	jc := &jsonCriterion{
+		Type:             "discreteValues",
+		Feature:          dvc.Feature().Name(),
+		Values:           dvc.Values(),
+		IncludeUndefined: feature.IncludesUndefined(dvc),
+	}
+	if df, ok := dvc.Feature().(*feature.DiscreteFeature); ok {
+		jc.Buckets = df.Buckets()
+	}
+	return json.Marshal(jc)
+}
+
+/*
+MarshalJSONBooleanCriterion takes a feature.BooleanCriterion and
+returns a serialization of it into JSON or an error. The serialization
+is a JSON object with the following fields:
+* "type": a string set to "boolean"
+* "feature": a string set to the name of the feature of the criterion
+* "value": the string "true" or "false" with the value that satisfies
+the criterion.
+* "includeUndefined": true if the criterion is also satisfied by samples
+with no value for the feature, omitted otherwise.
+*/
+func MarshalJSONBooleanCriterion(bc feature.BooleanCriterion) ([]byte, error) {
 	return json.Marshal(&jsonCriterion{
-		Type:    "discrete",
-		Feature: dfc.Feature().Name(),
-		Value:   dfc.Value(),
+		Type:             "boolean",
+		Feature:          bc.Feature().Name(),
+		Value:            strconv.FormatBool(bc.Value()),
+		IncludeUndefined: feature.IncludesUndefined(bc),
 	})
 }
 
@@ -196,6 +377,20 @@ func MarshalJSONUndefinedCriterion(u feature.UndefinedCriterion) ([]byte, error)
 	})
 }
 
+/*
+MarshalJSONUndefinedValueCriterion takes a feature.UndefinedValueCriterion
+and returns a serialization of it into JSON or an error. The serialization
+is a JSON object with the following fields:
+* "type": a string set to "undefinedValue"
+* "feature": a string set to the name of the feature of the criterion
+*/
+func MarshalJSONUndefinedValueCriterion(u feature.UndefinedValueCriterion) ([]byte, error) {
+	return json.Marshal(&jsonCriterion{
+		Type:    "undefinedValue",
+		Feature: u.Feature().Name(),
+	})
+}
+
 func (jc *jsonCriterion) Criterion(features []feature.Feature) (feature.Criterion, error) {
 	var f feature.Feature
 	for _, feat := range features {
@@ -205,15 +400,21 @@ func (jc *jsonCriterion) Criterion(features []feature.Feature) (feature.Criterio
 		}
 	}
 	if f == nil {
-		return nil, fmt.Errorf("unknown feature '%s'", jc.Feature)
+		return nil, fmt.Errorf("%w '%s'", feature.ErrUnknownFeature, jc.Feature)
 	}
 	switch jc.Type {
 	case "continuous":
 		return jc.toContinuousCriterion(f)
 	case "discrete":
 		return jc.toDiscreteCriterion(f)
+	case "discreteValues":
+		return jc.toDiscreteValuesCriterion(f)
+	case "boolean":
+		return jc.toBooleanCriterion(f)
 	case "undefined":
 		return jc.toUndefinedCriterion(f)
+	case "undefinedValue":
+		return jc.toUndefinedValueCriterion(f)
 	}
 	return nil, fmt.Errorf("unknown feature criterion type '%s'", jc.Type)
 }
@@ -222,7 +423,12 @@ func (jc *jsonCriterion) Criterion(features []feature.Feature) (feature.Criterio
 // and a slice of features and returns the Criterion or an error. The slice of
 // features should include exactly one feature with the serialized criterion's
 // feature and for continuous and discrete criteria, the feature should be of
-// the correspoding feature type, otherwise an error is returned.
+// the correspoding feature type, otherwise an error is returned. An error is
+// also returned for discrete and discrete values criteria with a value the
+// feature cannot take or
+// with a number of buckets other than the one the feature hashes its values
+// into, wrapping ErrFeaturesMismatch, and for continuous criteria with NaN
+// limits or an interval ending before it starts.
 func UnmarshalJSONCriterion(b []byte, features []feature.Feature) (feature.Criterion, error) {
 	jc := &jsonCriterion{}
 	err := json.Unmarshal(b, jc)
@@ -236,12 +442,62 @@ func (jc *jsonCriterion) toUndefinedCriterion(f feature.Feature) (feature.Criter
 	return feature.NewUndefinedCriterion(f), nil
 }
 
+func (jc *jsonCriterion) toUndefinedValueCriterion(f feature.Feature) (feature.Criterion, error) {
+	return feature.NewUndefinedValueCriterion(f), nil
+}
+
 func (jc *jsonCriterion) toDiscreteCriterion(f feature.Feature) (feature.Criterion, error) {
 	df, ok := f.(*feature.DiscreteFeature)
 	if !ok {
 		return nil, fmt.Errorf("expected discrete feature for discrete criterion but found %T feature %v", f, f.Name())
 	}
-	return feature.NewDiscreteCriterion(df, jc.Value), nil
+	if jc.Buckets != df.Buckets() {
+		return nil, fmt.Errorf("%w: discrete criterion on feature %v hashes values into %d buckets but the feature hashes them into %d", ErrFeaturesMismatch, f.Name(), jc.Buckets, df.Buckets())
+	}
+	if ok, err := df.Valid(jc.Value); !ok {
+		return nil, fmt.Errorf("invalid discrete criterion: %v", err)
+	}
+	var c feature.Criterion = feature.NewDiscreteCriterion(df, jc.Value)
+	if jc.IncludeUndefined {
+		c = feature.IncludingUndefined(c)
+	}
+	return c, nil
+}
+
+func (jc *jsonCriterion) toDiscreteValuesCriterion(f feature.Feature) (feature.Criterion, error) {
+	df, ok := f.(*feature.DiscreteFeature)
+	if !ok {
+		return nil, fmt.Errorf("expected discrete feature for discrete values criterion but found %T feature %v", f, f.Name())
+	}
+	if jc.Buckets != df.Buckets() {
+		return nil, fmt.Errorf("%w: discrete values criterion on feature %v hashes values into %d buckets but the feature hashes them into %d", ErrFeaturesMismatch, f.Name(), jc.Buckets, df.Buckets())
+	}
+	for _, v := range jc.Values {
+		if ok, err := df.Valid(v); !ok {
+			return nil, fmt.Errorf("invalid discrete values criterion: %v", err)
+		}
+	}
+	var c feature.Criterion = feature.NewDiscreteValuesCriterion(df, jc.Values)
+	if jc.IncludeUndefined {
+		c = feature.IncludingUndefined(c)
+	}
+	return c, nil
+}
+
+func (jc *jsonCriterion) toBooleanCriterion(f feature.Feature) (feature.Criterion, error) {
+	bf, ok := f.(*feature.BooleanFeature)
+	if !ok {
+		return nil, fmt.Errorf("expected boolean feature for boolean criterion but found %T feature %v", f, f.Name())
+	}
+	v, err := strconv.ParseBool(jc.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boolean criterion value '%s' on feature %v", jc.Value, f.Name())
+	}
+	var c feature.Criterion = feature.NewBooleanCriterion(bf, v)
+	if jc.IncludeUndefined {
+		c = feature.IncludingUndefined(c)
+	}
+	return c, nil
 }
 
 func (jc *jsonCriterion) toContinuousCriterion(f feature.Feature) (feature.Criterion, error) {
@@ -267,7 +523,14 @@ func (jc *jsonCriterion) toContinuousCriterion(f feature.Feature) (feature.Crite
 			return nil, err
 		}
 	}
-	return feature.NewContinuousCriterion(cf, a, b), nil
+	if math.IsNaN(a) || math.IsNaN(b) || a > b {
+		return nil, fmt.Errorf("invalid interval [%s, %s) for continuous criterion on feature %v", jc.A, jc.B, f.Name())
+	}
+	var c feature.Criterion = feature.NewContinuousCriterion(cf, a, b)
+	if jc.IncludeUndefined {
+		c = feature.IncludingUndefined(c)
+	}
+	return c, nil
 }
 
 /*
@@ -279,6 +542,10 @@ to contain a JSON object with the following fields:
 numeric (float64) values (probability of that value)
 * "weight": a number (integer) corresponding to the number of
 samples in the set from which the prediction was made.
+* "totalWeight": an optional number with the total weight of those
+samples, if it is not their number.
+An error is returned if any probability is not between 0 and 1 or the
+weights are negative.
 */
 func UnmarshalJSONPrediction(b []byte) (*tree.Prediction, error) {
 	jp := &jsonPrediction{}
@@ -286,5 +553,16 @@ func UnmarshalJSONPrediction(b []byte) (*tree.Prediction, error) {
 	if err != nil {
 		return nil, err
 	}
-	return tree.NewPrediction(jp.Probabilities, jp.Weight), nil
+	for v, p := range jp.Probabilities {
+		if p < 0 || p > 1+probabilityTolerance {
+			return nil, fmt.Errorf("invalid probability %v for value %q in prediction", p, v)
+		}
+	}
+	if jp.Weight < 0 {
+		return nil, fmt.Errorf("invalid negative weight %d in prediction", jp.Weight)
+	}
+	if jp.TotalWeight < 0 {
+		return nil, fmt.Errorf("invalid negative total weight %v in prediction", jp.TotalWeight)
+	}
+	return tree.NewWeightedPrediction(jp.Probabilities, jp.Weight, jp.TotalWeight), nil
 }
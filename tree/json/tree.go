@@ -1,6 +1,7 @@
 package json
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -11,32 +12,67 @@ import (
 	"github.com/pbanos/botanic/tree"
 )
 
+/*
+nodeBatchSize is the number of nodes ReadJSONTree stores on the tree's
+NodeStore at a time.
+*/
+const nodeBatchSize = 1000
+
+/*
+writeBufferSize is the size of the buffer WriteJSONTree uses to write
+onto the io.Writer.
+*/
+const writeBufferSize = 64 * 1024
+
 /*
 WriteJSONTree takes a context.Context, a pointer to a tree.Tree and an
 io.Writer and serializes the given tree as JSON onto the io.Writer.
 A tree is serialized as a JSON object with the following fields:
+* "formatVersion": a number with the FormatVersion of the serialization
 * "rootID": a string with the ID of the node at the root of the tree
 * "classFeature": a string with the name of the feature the tree predicts
+* "missingValueStrategy": a string with the name of the tree's
+  MissingValueStrategy, omitted for the default one
+* "smoothing": a string with the tree's Smoothing, omitted for none
+* "metadata": an object with the tree's Metadata, omitted if it has none
 * "nodes": an array containing the nodes that can be traversed on the tree
   serialized by MarshalJSONNode.
+* "featuresHash": a string with the hex-encoded SHA-256 hash of the name,
+  kind and available values of the class feature and the features used by
+  the nodes
+* "checksum": a string with the hex-encoded SHA-256 checksum of the root ID,
+  class feature, missing value strategy, smoothing and nodes of the tree
+Nodes are serialized one at a time as the tree is traversed and written
+through a fixed-size buffer, so the whole serialization is never held in
+memory.
 An error is returned if the tree cannot be traversed, serialized or written
 onto the io.Writer.
 */
 func WriteJSONTree(ctx context.Context, t *tree.Tree, w io.Writer) error {
+	bw := bufio.NewWriterSize(w, writeBufferSize)
+	err := writeJSONTree(ctx, t, bw)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeJSONTree(ctx context.Context, t *tree.Tree, w io.Writer) error {
 	err := marshalJSONTreeHeader(ctx, t, w)
 	if err != nil {
 		return err
 	}
+	td := newTreeDigest(t.ClassFeature)
 	var i int
 	err = t.Traverse(ctx, false, func(ctx context.Context, n *tree.Node) error {
-		err := writeNode(ctx, i, n, w)
+		err := writeNode(ctx, i, n, w, td)
 		i++
 		return err
 	})
 	if err != nil {
 		return err
 	}
-	return marshalJSONTreeFooter(ctx, t, w)
+	return marshalJSONTreeFooter(ctx, t, w, td)
 }
 
 /*
@@ -44,49 +80,208 @@ ReadJSONTree takes a context.Context, a pointer to a tree.Tree and an
 io.Reader and unmarshals the contents of the io.Reader onto the given
 tree.
 A tree is expected to be a JSON object with the following fields:
+* "formatVersion": an optional number with the version of the format
 * "rootID": a string with the ID of the node at the root of the tree
 * "classFeature": a string with the name of the feature the tree predicts
+* "missingValueStrategy": an optional string with the name of the tree's
+  MissingValueStrategy
+* "smoothing": an optional string with the tree's Smoothing
+* "metadata": an optional object with the tree's Metadata
 * "nodes": an array containing the nodes that can be traversed on the tree
   unmarshalled by UnmarshalJSONNodeWithFeatures.
+* "featuresHash" and "checksum": strings with the hashes described for
+  WriteJSONTree, required if the format version is given.
+The nodes are decoded one at a time as they are read and stored on the
+tree's NodeStore in batches, so that only a batch of them is held in memory
+besides the store and their IDs.
 An error is returned if the JSON cannot be read from the io.Reader or
-unmarshalled onto the tree.
+unmarshalled onto the tree, as well as if the nodes do not form a tree: if
+two nodes share an ID, a node is a subtree of more than one node, a subtree
+of a node is missing or the root node is missing or is a subtree of another
+node. An error wrapping ErrTooLarge is returned if a node, or any other
+field of the tree, takes more than MaxValueSize bytes. An error is also returned
+if the format version is later than FormatVersion, wrapping
+ErrFeaturesMismatch if the given features differ from the ones the tree was
+written with, and wrapping ErrChecksumMismatch if the checksum does not
+match the contents. Trees with no format version, written before it was
+added, are not verified. Unknown fields are ignored.
 */
 func ReadJSONTree(ctx context.Context, t *tree.Tree, features []feature.Feature, r io.Reader) error {
-	dec := json.NewDecoder(r)
-	jt := &struct {
-		RootID       string             `json:"rootID"`
-		ClassFeature string             `json:"classFeature"`
-		Nodes        []*json.RawMessage `json:"nodes"`
-	}{}
-	err := dec.Decode(jt)
+	return readJSONTree(ctx, t, features, newDecoder(r))
+}
+
+/*
+readJSONTree takes a context.Context, a tree, a slice of features and a
+decoder positioned at the start of a serialized tree and decodes the tree
+onto the given one as described for ReadJSONTree.
+*/
+func readJSONTree(ctx context.Context, t *tree.Tree, features []feature.Feature, dec *decoder) error {
+	err := expectDelim(dec.Decoder, '{')
 	if err != nil {
 		return err
 	}
-	var cf feature.Feature
-	for _, f := range features {
-		if f.Name() == jt.ClassFeature {
-			cf = f
-			break
+	var rootID, classFeature, missingValueStrategy, smoothing, featuresHash, checksum string
+	var version int
+	var metadata *tree.Metadata
+	ni := tree.NewNodeIndex()
+	td := newTreeDigest(nil)
+	for dec.More() {
+		dec.limit.reset()
+		key, err := objectKey(dec.Decoder)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "formatVersion":
+			err = dec.Decode(&version)
+			if err == nil {
+				err = checkFormatVersion(version)
+			}
+		case "featuresHash":
+			err = dec.Decode(&featuresHash)
+		case "checksum":
+			err = dec.Decode(&checksum)
+		case "rootID":
+			err = dec.Decode(&rootID)
+		case "classFeature":
+			err = dec.Decode(&classFeature)
+		case "missingValueStrategy":
+			err = dec.Decode(&missingValueStrategy)
+		case "smoothing":
+			err = dec.Decode(&smoothing)
+		case "metadata":
+			err = dec.Decode(&metadata)
+		case "nodes":
+			err = readNodes(ctx, t, features, dec, ni, td)
+		default:
+			var ignored json.RawMessage
+			err = dec.Decode(&ignored)
+		}
+		if err != nil {
+			return err
 		}
 	}
+	err = expectDelim(dec.Decoder, '}')
+	if err != nil {
+		return err
+	}
+	cf := featureNamed(features, classFeature)
 	if cf == nil {
 		return fmt.Errorf("no class feature defined")
 	}
-	if jt.RootID == "" {
+	if rootID == "" {
 		return fmt.Errorf("no root node id available")
 	}
+	err = ni.Verify(rootID)
+	if err != nil {
+		return err
+	}
+	td.features[cf.Name()] = cf
+	err = td.verify(rootID, classFeature, missingValueStrategy, smoothing, version, featuresHash, checksum)
+	if err != nil {
+		return err
+	}
 	t.ClassFeature = cf
-	t.RootID = jt.RootID
-	for _, jn := range jt.Nodes {
+	t.RootID = rootID
+	t.Metadata = metadata
+	if missingValueStrategy != "" {
+		t.MissingValueStrategy, err = tree.ParseMissingValueStrategy(missingValueStrategy)
+		if err != nil {
+			return err
+		}
+	}
+	if smoothing != "" {
+		t.Smoothing, err = tree.ParseSmoothing(smoothing)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+readNodes takes a context.Context, a tree, a slice of features, a decoder
+positioned at the start of a JSON array of serialized nodes, a node index and
+a treeDigest and decodes the nodes one at a time, adding them to the index
+and the digest and storing them on the tree's NodeStore in batches of
+nodeBatchSize nodes.
+*/
+func readNodes(ctx context.Context, t *tree.Tree, features []feature.Feature, dec *decoder, ni *tree.NodeIndex, td *treeDigest) error {
+	err := expectDelim(dec.Decoder, '[')
+	if err != nil {
+		return err
+	}
+	batch := make([]*tree.Node, 0, nodeBatchSize)
+	for dec.More() {
+		dec.limit.reset()
+		var jn json.RawMessage
+		err = dec.Decode(&jn)
+		if err != nil {
+			return err
+		}
 		n := &tree.Node{}
-		err = UnmarshalJSONNodeWithFeatures(n, *jn, features)
+		err = UnmarshalJSONNodeWithFeatures(n, jn, features)
+		if err != nil {
+			return err
+		}
+		err = ni.Add(n)
 		if err != nil {
 			return err
 		}
-		err = t.NodeStore.Store(ctx, n)
+		err = td.addRaw(n, jn)
 		if err != nil {
 			return err
 		}
+		batch = append(batch, n)
+		if len(batch) == nodeBatchSize {
+			err = tree.StoreNodes(ctx, t.NodeStore, batch)
+			if err != nil {
+				return err
+			}
+			batch = make([]*tree.Node, 0, nodeBatchSize)
+		}
+	}
+	if len(batch) > 0 {
+		err = tree.StoreNodes(ctx, t.NodeStore, batch)
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec.Decoder, ']')
+}
+
+/*
+featureNamed takes a slice of features and a name and returns the feature in
+the slice with the given name, or nil if there is none.
+*/
+func featureNamed(features []feature.Feature, name string) feature.Feature {
+	for _, f := range features {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func objectKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key but found %v", tok)
+	}
+	return key, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v but found %v", delim, tok)
 	}
 	return nil
 }
@@ -100,12 +295,35 @@ func marshalJSONTreeHeader(ctx context.Context, t *tree.Tree, w io.Writer) error
 	if err != nil {
 		return err
 	}
-	header := fmt.Sprintf(`{"rootID":%s,"classFeature":%s,"nodes":[`, jrootID, jFeatureName)
+	var jStrategy string
+	if t.MissingValueStrategy != tree.MissingValueUndefined {
+		js, err := json.Marshal(t.MissingValueStrategy.String())
+		if err != nil {
+			return err
+		}
+		jStrategy = fmt.Sprintf(`"missingValueStrategy":%s,`, js)
+	}
+	if t.Smoothing.Method != tree.SmoothingNone {
+		js, err := json.Marshal(t.Smoothing.String())
+		if err != nil {
+			return err
+		}
+		jStrategy += fmt.Sprintf(`"smoothing":%s,`, js)
+	}
+	var jMetadata string
+	if t.Metadata != nil {
+		jm, err := json.Marshal(t.Metadata)
+		if err != nil {
+			return err
+		}
+		jMetadata = fmt.Sprintf(`"metadata":%s,`, jm)
+	}
+	header := fmt.Sprintf(`{"formatVersion":%d,"rootID":%s,"classFeature":%s,%s%s"nodes":[`, FormatVersion, jrootID, jFeatureName, jStrategy, jMetadata)
 	_, err = w.Write([]byte(header))
 	return err
 }
 
-func writeNode(ctx context.Context, i int, n *tree.Node, w io.Writer) error {
+func writeNode(ctx context.Context, i int, n *tree.Node, w io.Writer, td *treeDigest) error {
 	if i != 0 {
 		_, err := w.Write([]byte(","))
 		if err != nil {
@@ -116,11 +334,21 @@ func writeNode(ctx context.Context, i int, n *tree.Node, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	td.add(n, jn)
 	_, err = w.Write(jn)
 	return err
 }
 
-func marshalJSONTreeFooter(ctx context.Context, t *tree.Tree, w io.Writer) error {
-	_, err := w.Write([]byte(`]}`))
+func marshalJSONTreeFooter(ctx context.Context, t *tree.Tree, w io.Writer, td *treeDigest) error {
+	var strategy string
+	if t.MissingValueStrategy != tree.MissingValueUndefined {
+		strategy = t.MissingValueStrategy.String()
+	}
+	var smoothing string
+	if t.Smoothing.Method != tree.SmoothingNone {
+		smoothing = t.Smoothing.String()
+	}
+	checksum := td.checksum(t.RootID, t.ClassFeature.Name(), strategy, smoothing)
+	_, err := fmt.Fprintf(w, `],"featuresHash":"%s","checksum":"%s"}`, td.featuresHash(), checksum)
 	return err
 }
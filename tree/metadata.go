@@ -0,0 +1,32 @@
+package tree
+
+import "time"
+
+/*
+Metadata holds the provenance of a tree, so that the trees in use can be
+traced back to how they were trained: the name and version given to the
+model by its users, the set it was grown from and its number of samples,
+the settings of the growth, the pruning strategy applied while growing it
+and the post-pruning strategy applied later, if any, the smoothing of the
+probabilities of its predictions, the hash of the
+metadata of its features as returned by feature.MetadataHash, and when it
+was grown and last post-pruned. Empty fields are unknown.
+
+Metadata is recorded with the trees serialized as JSON, but not with
+those serialized in the binary encoding, and it is not covered by their
+checksums, as it does not change their predictions.
+*/
+type Metadata struct {
+	Name         string            `json:"name,omitempty"`
+	Version      string            `json:"version,omitempty"`
+	Dataset      string            `json:"dataset,omitempty"`
+	Samples      int               `json:"samples,omitempty"`
+	GrowSettings map[string]string `json:"growSettings,omitempty"`
+	Pruning      string            `json:"pruning,omitempty"`
+	PostPruning  string            `json:"postPruning,omitempty"`
+	Smoothing    string            `json:"smoothing,omitempty"`
+	FeaturesHash string            `json:"featuresHash,omitempty"`
+	GrowStarted  *time.Time        `json:"growStarted,omitempty"`
+	GrowFinished *time.Time        `json:"growFinished,omitempty"`
+	Pruned       *time.Time        `json:"pruned,omitempty"`
+}
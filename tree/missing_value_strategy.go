@@ -0,0 +1,59 @@
+package tree
+
+import "fmt"
+
+/*
+MissingValueStrategy determines how a tree predicts a sample with no value
+for the feature a node splits on when no subtree of the node is satisfied by
+the sample, as it happens when no undefined subtree was developed for the
+node.
+*/
+type MissingValueStrategy int
+
+const (
+	// MissingValueUndefined relies exclusively on undefined subtrees, so
+	// the sample cannot be predicted when the node has none. This is the
+	// default strategy.
+	MissingValueUndefined MissingValueStrategy = iota
+	// MissingValueMajority sends the sample down the subtree that was
+	// grown from the largest number of training samples.
+	MissingValueMajority
+	// MissingValueFractional sends the sample down every subtree and
+	// combines their predictions weighting them by the number of training
+	// samples each subtree was grown from.
+	MissingValueFractional
+	// MissingValueSurrogate sends the sample down the subtree selected by
+	// the node's surrogate split, a split on another feature that best
+	// reproduces the node's split on the training samples. Nodes without a
+	// surrogate split or whose surrogate split is not satisfied by the
+	// sample fall back to MissingValueMajority.
+	MissingValueSurrogate
+)
+
+var missingValueStrategyNames = map[MissingValueStrategy]string{
+	MissingValueUndefined:  "undefined",
+	MissingValueMajority:   "majority",
+	MissingValueFractional: "fractional",
+	MissingValueSurrogate:  "surrogate",
+}
+
+/*
+ParseMissingValueStrategy takes a string and returns the MissingValueStrategy
+it names or an error if it names none. Valid names are "undefined",
+"majority", "fractional" and "surrogate".
+*/
+func ParseMissingValueStrategy(name string) (MissingValueStrategy, error) {
+	for s, n := range missingValueStrategyNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return MissingValueUndefined, fmt.Errorf("unknown missing value strategy '%s'", name)
+}
+
+func (s MissingValueStrategy) String() string {
+	if n, ok := missingValueStrategyNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("MissingValueStrategy(%d)", int(s))
+}
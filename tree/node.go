@@ -32,6 +32,47 @@ type Node struct {
 	// below, whereas for fully-grown trees it is the feature to ask about next on the
 	// sample being predicted or tested against.
 	SubtreeFeature feature.Feature
+	// The surrogate split for the node, used to select a subtree for samples
+	// with no value for the SubtreeFeature when the tree's MissingValueStrategy
+	// is MissingValueSurrogate. It is nil if the node has none.
+	Surrogate *Surrogate
+	// The information gain obtained on the node's training set by splitting
+	// it on the SubtreeFeature. It is 0 for leaves.
+	InformationGain float64
+	// The depth of the node in the tree, 0 for the root node.
+	Depth int
+	// The number of samples in the node's training set.
+	SampleCount int
+}
+
+/*
+Surrogate is a split of a node's set on a feature other than the node's
+SubtreeFeature that best reproduces the node's split. Every criterion in
+Criteria, on the surrogate Feature, selects the subtree whose ID is at the
+same position in SubtreeIDs.
+*/
+type Surrogate struct {
+	Feature    feature.Feature
+	Criteria   []feature.Criterion
+	SubtreeIDs []string
+}
+
+/*
+Features returns the features the node uses: the feature of its criterion,
+its subtree feature and the feature of its surrogate split, if any.
+*/
+func (n *Node) Features() []feature.Feature {
+	var features []feature.Feature
+	if n.FeatureCriterion != nil && n.FeatureCriterion.Feature() != nil {
+		features = append(features, n.FeatureCriterion.Feature())
+	}
+	if n.SubtreeFeature != nil {
+		features = append(features, n.SubtreeFeature)
+	}
+	if n.Surrogate != nil && n.Surrogate.Feature != nil {
+		features = append(features, n.Surrogate.Feature)
+	}
+	return features
 }
 
 func (n *Node) String() string {
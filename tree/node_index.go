@@ -0,0 +1,61 @@
+package tree
+
+import "fmt"
+
+/*
+NodeIndex holds the IDs of the nodes read for a tree and the IDs listed as
+subtrees by them, so that decoders can check that the nodes they read form
+a tree before using it.
+*/
+type NodeIndex struct {
+	ids        map[string]bool
+	subtreeIDs map[string]bool
+}
+
+/*
+NewNodeIndex returns a pointer to a new empty NodeIndex.
+*/
+func NewNodeIndex() *NodeIndex {
+	return &NodeIndex{ids: make(map[string]bool), subtreeIDs: make(map[string]bool)}
+}
+
+/*
+Add takes a node and adds it to the index, returning an error if a node
+with the same ID was already added or any of its subtrees is already a
+subtree of another node. As long as no node is a subtree of more than one
+node and the root is a subtree of none, no cycles can be reached from the
+root.
+*/
+func (ni *NodeIndex) Add(n *Node) error {
+	if ni.ids[n.ID] {
+		return fmt.Errorf("duplicate node %v", n.ID)
+	}
+	ni.ids[n.ID] = true
+	for _, id := range n.SubtreeIDs {
+		if ni.subtreeIDs[id] {
+			return fmt.Errorf("node %v is a subtree of more than one node", id)
+		}
+		ni.subtreeIDs[id] = true
+	}
+	return nil
+}
+
+/*
+Verify takes the ID of the root node of a tree and returns an error if the
+root node is a subtree of another node, or an error wrapping ErrNodeNotFound
+if the root node or any subtree of the nodes added was not added.
+*/
+func (ni *NodeIndex) Verify(rootID string) error {
+	if !ni.ids[rootID] {
+		return fmt.Errorf("root %w: %v", ErrNodeNotFound, rootID)
+	}
+	if ni.subtreeIDs[rootID] {
+		return fmt.Errorf("root node %v is a subtree of another node", rootID)
+	}
+	for id := range ni.subtreeIDs {
+		if !ni.ids[id] {
+			return fmt.Errorf("subtree %w: %v", ErrNodeNotFound, id)
+		}
+	}
+	return nil
+}
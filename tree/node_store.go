@@ -2,10 +2,24 @@ package tree
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 )
 
+var (
+	// ErrNodeNotFound is the error wrapped by the errors returned when a
+	// node of a tree is not on its node store, so that callers can tell
+	// it with errors.Is.
+	ErrNodeNotFound = errors.New("node not found")
+	// ErrNodeStoreUnavailable is the error wrapped by the errors of node
+	// stores that cannot be reached, such as those served by another
+	// process, so that callers can tell them with errors.Is from the rest
+	// and retry later.
+	ErrNodeStoreUnavailable = errors.New("node store unavailable")
+)
+
 /*
 NodeStore is an interface to manage a store
 where nodes can be created, retrieved, updated
@@ -45,40 +59,166 @@ type NodeStore interface {
 	Close(ctx context.Context) error
 }
 
+/*
+BatchNodeStore is an interface for NodeStores able to update several nodes
+in a single operation. Its StoreBatch method takes a slice of nodes already
+existing in the store and updates them on the store, returning an error if
+the update cannot be performed.
+*/
+type BatchNodeStore interface {
+	NodeStore
+	StoreBatch(ctx context.Context, nodes []*Node) error
+}
+
+/*
+StoreNodes takes a context, a NodeStore and a slice of nodes and updates the
+nodes on the store, in a single operation if the store is a BatchNodeStore or
+one by one otherwise. It returns an error if any of the nodes cannot be
+stored.
+*/
+func StoreNodes(ctx context.Context, ns NodeStore, nodes []*Node) error {
+	if bns, ok := ns.(BatchNodeStore); ok {
+		return bns.StoreBatch(ctx, nodes)
+	}
+	for _, n := range nodes {
+		err := ns.Store(ctx, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+BatchGetNodeStore is an interface for NodeStores able to retrieve several
+nodes in a single operation. Its GetBatch method takes a slice of IDs and
+returns a slice with the node in the store with every ID, in the same order
+and with nil for the nodes that cannot be found, or an error if the store
+cannot be queried.
+*/
+type BatchGetNodeStore interface {
+	NodeStore
+	GetBatch(ctx context.Context, ids []string) ([]*Node, error)
+}
+
+/*
+GetNodes takes a context, a NodeStore and a slice of IDs and returns a slice
+with the node in the store with every ID, in the same order and with nil for
+the nodes that cannot be found. Nodes are retrieved in a single operation if
+the store is a BatchGetNodeStore or one by one otherwise. It returns an error
+if any of the nodes cannot be retrieved.
+*/
+func GetNodes(ctx context.Context, ns NodeStore, ids []string) ([]*Node, error) {
+	if bns, ok := ns.(BatchGetNodeStore); ok {
+		return bns.GetBatch(ctx, ids)
+	}
+	nodes := make([]*Node, len(ids))
+	for i, id := range ids {
+		n, err := ns.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+/*
+CompactNodeStore is an interface for NodeStores able to renumber the IDs of
+the nodes of a tree. Its Compact method takes the ID of the root node of a
+tree and renumbers the nodes that can be traversed from it with consecutive
+IDs, in the order Traverse goes through them, updating the references
+between them and deleting the nodes that cannot be traversed. It returns
+the new ID of the root node or an error if the compaction cannot be
+performed.
+*/
+type CompactNodeStore interface {
+	NodeStore
+	Compact(ctx context.Context, rootID string) (string, error)
+}
+
+/*
+Compact takes a context and a tree and, if the tree's NodeStore is a
+CompactNodeStore, compacts the tree's nodes and updates its RootID. Trees
+with other NodeStores are left unaltered. It returns an error if the
+compaction cannot be performed.
+*/
+func Compact(ctx context.Context, t *Tree) error {
+	cns, ok := t.NodeStore.(CompactNodeStore)
+	if !ok {
+		return nil
+	}
+	rootID, err := cns.Compact(ctx, t.RootID)
+	if err != nil {
+		return err
+	}
+	t.RootID = rootID
+	return nil
+}
+
+/*
+nodeChunkSize is the number of nodes allocated at a time by the memory
+NodeStore.
+*/
+const nodeChunkSize = 1024
+
+/*
+memoryNodeStore is a NodeStore that keeps nodes in an arena of chunks of
+nodeChunkSize nodes. A node with ID i is kept in slot i-1 of the arena, so
+that nodes are allocated in chunks and looked up without hashing. Nodes
+whose ID does not correspond to a slot of the arena when they are first
+stored, as can happen with nodes read from a serialized tree, are kept in
+an overflow map instead.
+*/
 type memoryNodeStore struct {
-	nodes  map[string]*Node
-	lock   *sync.RWMutex
-	nextID uint64
+	chunks   [][]Node
+	live     []bool
+	overflow map[string]*Node
+	lock     *sync.RWMutex
 }
 
 // NewMemoryNodeStore returns an implementation
 // of NodeStore with the process memory space
-// as underlying backend
+// as underlying backend. The returned NodeStore
+// is a CompactNodeStore, a BatchNodeStore and a
+// BatchGetNodeStore.
 func NewMemoryNodeStore() NodeStore {
 	return &memoryNodeStore{
-		nodes: make(map[string]*Node),
-		lock:  &sync.RWMutex{},
+		overflow: make(map[string]*Node),
+		lock:     &sync.RWMutex{},
 	}
 }
 
 func (mns *memoryNodeStore) Create(ctx context.Context, n *Node) error {
 	return mns.withLock(ctx, func(ctx context.Context) error {
-		taken := true
-		for taken {
+		i := len(mns.live)
+		for {
 			if err := ctx.Err(); err != nil {
 				return err
 			}
-			n.ID = mns.generateRandomNodeID(n.ParentID)
-			_, taken = mns.nodes[n.ID]
+			n.ID = strconv.Itoa(i + 1)
+			if _, taken := mns.overflow[n.ID]; !taken {
+				break
+			}
+			i++
 		}
-		mns.nodes[n.ID] = n
+		mns.put(i, n)
 		return nil
 	})
 }
 
 func (mns *memoryNodeStore) Store(ctx context.Context, n *Node) error {
 	return mns.withLock(ctx, func(ctx context.Context) error {
-		mns.nodes[n.ID] = n
+		mns.store(n)
+		return nil
+	})
+}
+
+func (mns *memoryNodeStore) StoreBatch(ctx context.Context, nodes []*Node) error {
+	return mns.withLock(ctx, func(ctx context.Context) error {
+		for _, n := range nodes {
+			mns.store(n)
+		}
 		return nil
 	})
 }
@@ -86,7 +226,7 @@ func (mns *memoryNodeStore) Store(ctx context.Context, n *Node) error {
 func (mns *memoryNodeStore) Get(ctx context.Context, id string) (*Node, error) {
 	var n *Node
 	err := mns.withRLock(ctx, func(ctx context.Context) error {
-		n = mns.nodes[id]
+		n = mns.get(id)
 		return nil
 	})
 	if err != nil {
@@ -94,9 +234,29 @@ func (mns *memoryNodeStore) Get(ctx context.Context, id string) (*Node, error) {
 	}
 	return n, nil
 }
+
+func (mns *memoryNodeStore) GetBatch(ctx context.Context, ids []string) ([]*Node, error) {
+	nodes := make([]*Node, len(ids))
+	err := mns.withRLock(ctx, func(ctx context.Context) error {
+		for i, id := range ids {
+			nodes[i] = mns.get(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
 func (mns *memoryNodeStore) Delete(ctx context.Context, n *Node) error {
 	return mns.withLock(ctx, func(ctx context.Context) error {
-		delete(mns.nodes, n.ID)
+		if i, ok := mns.slot(n.ID); ok && i < len(mns.live) && mns.live[i] {
+			mns.live[i] = false
+			*mns.node(i) = Node{}
+			return nil
+		}
+		delete(mns.overflow, n.ID)
 		return nil
 	})
 }
@@ -104,10 +264,124 @@ func (mns *memoryNodeStore) Close(ctx context.Context) error {
 	return nil
 }
 
-func (mns *memoryNodeStore) generateRandomNodeID(parentID string) string {
-	mns.nextID++
-	return fmt.Sprintf("%d", mns.nextID)
-	//return fmt.Sprintf("%016x-%016x", uint64(time.Now().UnixNano()), rand.Uint64())
+func (mns *memoryNodeStore) Compact(ctx context.Context, rootID string) (string, error) {
+	var newRootID string
+	err := mns.withLock(ctx, func(ctx context.Context) error {
+		root := mns.get(rootID)
+		if root == nil {
+			return fmt.Errorf("compacting nodes: root %w: %v", ErrNodeNotFound, rootID)
+		}
+		var nodes []*Node
+		ids := make(map[string]string)
+		pending := []*Node{root}
+		for len(pending) > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			nodes = append(nodes, n)
+			ids[n.ID] = strconv.Itoa(len(nodes))
+			for i := len(n.SubtreeIDs) - 1; i >= 0; i-- {
+				if sn := mns.get(n.SubtreeIDs[i]); sn != nil {
+					pending = append(pending, sn)
+				}
+			}
+		}
+		compacted := &memoryNodeStore{overflow: make(map[string]*Node)}
+		for i, n := range nodes {
+			cn := *n
+			cn.ID = ids[n.ID]
+			cn.ParentID = ids[n.ParentID]
+			cn.SubtreeIDs = renumberNodeIDs(n.SubtreeIDs, ids)
+			if n.Surrogate != nil {
+				cs := *n.Surrogate
+				cs.SubtreeIDs = renumberNodeIDs(n.Surrogate.SubtreeIDs, ids)
+				cn.Surrogate = &cs
+			}
+			compacted.put(i, &cn)
+		}
+		mns.chunks = compacted.chunks
+		mns.live = compacted.live
+		mns.overflow = compacted.overflow
+		newRootID = ids[root.ID]
+		return nil
+	})
+	return newRootID, err
+}
+
+/*
+renumberNodeIDs takes a slice of node IDs and a map of old to new node IDs
+and returns a slice with the new IDs of the nodes in the given slice that
+are in the map.
+*/
+func renumberNodeIDs(nodeIDs []string, ids map[string]string) []string {
+	if nodeIDs == nil {
+		return nil
+	}
+	result := make([]string, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		if newID, ok := ids[id]; ok {
+			result = append(result, newID)
+		}
+	}
+	return result
+}
+
+/*
+slot takes a node ID and returns the slot of the arena corresponding to it
+and true, or false if the ID does not correspond to a slot of the arena. IDs
+beyond the end of the arena only correspond to a slot if it is close enough
+to the end for the arena to grow to it.
+*/
+func (mns *memoryNodeStore) slot(id string) (int, bool) {
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 1 || strconv.Itoa(i) != id {
+		return 0, false
+	}
+	i--
+	if i >= 2*len(mns.live)+nodeChunkSize {
+		return 0, false
+	}
+	return i, true
+}
+
+func (mns *memoryNodeStore) node(i int) *Node {
+	return &mns.chunks[i/nodeChunkSize][i%nodeChunkSize]
+}
+
+/*
+put takes a slot of the arena and a node and copies the node into the slot,
+growing the arena up to the slot if needed.
+*/
+func (mns *memoryNodeStore) put(i int, n *Node) {
+	for len(mns.chunks)*nodeChunkSize <= i {
+		mns.chunks = append(mns.chunks, make([]Node, nodeChunkSize))
+	}
+	for len(mns.live) <= i {
+		mns.live = append(mns.live, false)
+	}
+	mns.live[i] = true
+	*mns.node(i) = *n
+}
+
+func (mns *memoryNodeStore) store(n *Node) {
+	if _, ok := mns.overflow[n.ID]; ok {
+		mns.overflow[n.ID] = n
+		return
+	}
+	if i, ok := mns.slot(n.ID); ok {
+		mns.put(i, n)
+		return
+	}
+	mns.overflow[n.ID] = n
+}
+
+func (mns *memoryNodeStore) get(id string) *Node {
+	if i, ok := mns.slot(id); ok && i < len(mns.live) && mns.live[i] {
+		return mns.node(i)
+	}
+	return mns.overflow[id]
 }
 
 func (mns *memoryNodeStore) withLock(ctx context.Context, f func(ctx context.Context) error) error {
@@ -3,6 +3,7 @@ package tree
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/pbanos/botanic/feature"
@@ -15,6 +16,16 @@ Prediction represents a prediction made by a decission regression Tree
 type Prediction struct {
 	probabilities map[string]float64
 	weight        int
+	totalWeight   float64
+}
+
+/*
+Predictor is an interface wrapping the Predict method, implemented by Tree
+and Ensemble, that takes a sample and returns a prediction for it or an
+error if the prediction could not be made.
+*/
+type Predictor interface {
+	Predict(ctx context.Context, s feature.Sample) (*Prediction, error)
 }
 
 // PredictionError represents an error related with predictions
@@ -67,6 +78,19 @@ func (p *Prediction) Weight() int {
 	return p.weight
 }
 
+/*
+TotalWeight returns the total weight of the samples in the set
+from which the prediction was made, which is their number unless
+they are weighted. Together with the probabilities, it gives the
+weight of every value of the class feature on the set.
+*/
+func (p *Prediction) TotalWeight() float64 {
+	if p.totalWeight > 0 {
+		return p.totalWeight
+	}
+	return float64(p.weight)
+}
+
 /*
 NewPrediction takes a map[string]float64 with the probabilities
 of each value in the prediction and an integer with the number
@@ -77,6 +101,15 @@ func NewPrediction(probs map[string]float64, weight int) *Prediction {
 	return &Prediction{probabilities: probs, weight: weight}
 }
 
+/*
+NewWeightedPrediction takes the same arguments as NewPrediction and the
+total weight of the samples in the set, for sets of weighted samples, and
+returns a prediction representing those values.
+*/
+func NewWeightedPrediction(probs map[string]float64, weight int, totalWeight float64) *Prediction {
+	return &Prediction{probabilities: probs, weight: weight, totalWeight: totalWeight}
+}
+
 /*
 PredictedValue returns a string with the most probable value and a float64 with
 its prevalence
@@ -91,6 +124,59 @@ func (p *Prediction) PredictedValue() (value string, prob float64) {
 	return
 }
 
+/*
+ValueProbability holds a value of the class feature and the probability a
+prediction gives it.
+*/
+type ValueProbability struct {
+	Value       string
+	Probability float64
+}
+
+/*
+TopValues takes a number k and returns the k most probable values of the
+prediction along their probabilities, from the most to the least probable
+and those with the same probability in alphabetical order. All of them are
+returned if k is not positive or the prediction has fewer values.
+*/
+func (p *Prediction) TopValues(k int) []ValueProbability {
+	values := make([]ValueProbability, 0, len(p.probabilities))
+	for v, prob := range p.probabilities {
+		values = append(values, ValueProbability{v, prob})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Probability != values[j].Probability {
+			return values[i].Probability > values[j].Probability
+		}
+		return values[i].Value < values[j].Value
+	})
+	if k > 0 && k < len(values) {
+		values = values[:k]
+	}
+	return values
+}
+
+/*
+PredictedValueWithThreshold takes a value and a probability threshold and
+returns the value along its probability if the prediction gives it at least
+the threshold, and the most probable of the rest of values along its
+probability otherwise, so that, for example, the positive value of a binary
+class can be predicted from a probability of 0.3 on. If the threshold is
+not reached and the prediction has no other values, an empty value and a 0
+probability are returned.
+*/
+func (p *Prediction) PredictedValueWithThreshold(value string, threshold float64) (string, float64) {
+	if prob := p.probabilities[value]; prob >= threshold {
+		return value, prob
+	}
+	for _, vp := range p.TopValues(0) {
+		if vp.Value != value {
+			return vp.Value, vp.Probability
+		}
+	}
+	return "", 0.0
+}
+
 func joinPredictions(p1 *Prediction, p2 *Prediction) (*Prediction, error) {
 	totalWeight := p1.weight + p2.weight
 	if totalWeight == 0 {
@@ -105,13 +191,15 @@ func joinPredictions(p1 *Prediction, p2 *Prediction) (*Prediction, error) {
 	for c, p := range p2.probabilities {
 		mergedProbs[c] += relativeWeight * p
 	}
-	return &Prediction{mergedProbs, totalWeight}, nil
+	return &Prediction{mergedProbs, totalWeight, p1.TotalWeight() + p2.TotalWeight()}, nil
 }
 
 // NewPredictionFromSet takes a context, a set and a feature and returns
 // a prediction for the feature based on the (training) data in the set
 // or an error if there are no samples in the set, or the set cannot
-// be queried
+// be queried. The probabilities of the prediction are computed with the
+// weights of the samples in the set, whereas its weight is the number of
+// samples in the set.
 func NewPredictionFromSet(ctx context.Context, s set.Set, f feature.Feature) (*Prediction, error) {
 	weight, err := s.Count(ctx)
 	if err != nil {
@@ -120,13 +208,20 @@ func NewPredictionFromSet(ctx context.Context, s set.Set, f feature.Feature) (*P
 	if weight == 0 {
 		return nil, ErrCannotPredictFromEmptySet
 	}
-	probs := make(map[string]float64)
-	fvc, err := s.CountFeatureValues(ctx, f)
+	totalWeight, err := s.Weight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if totalWeight <= 0 {
+		return nil, ErrCannotPredictFromEmptySet
+	}
+	fvw, err := s.FeatureValueWeights(ctx, f)
 	if err != nil {
 		return nil, err
 	}
-	for v, c := range fvc {
-		probs[v] = float64(c) / float64(weight)
+	probs := make(map[string]float64, len(fvw))
+	for v, w := range fvw {
+		probs[v] = w / totalWeight
 	}
-	return &Prediction{probs, weight}, nil
+	return &Prediction{probs, weight, totalWeight}, nil
 }
@@ -0,0 +1,63 @@
+package tree
+
+import (
+	"context"
+
+	"github.com/pbanos/botanic/set"
+)
+
+/*
+NodeRouting holds the number of samples of a set that flow through a node of
+a tree when predicting them, that is the samples whose Path includes the
+node, and the share of the samples of the set they represent, together with
+the depth of the node in the tree. Operators can use it to estimate the
+traffic every branch of the tree receives.
+*/
+type NodeRouting struct {
+	Node     *Node
+	Depth    int
+	Samples  int
+	Fraction float64
+}
+
+/*
+Routing takes a context.Context and a Set and returns the routing of the
+samples of the set through every node of the tree, in the order the nodes
+are visited traversing the tree from the root down. Nodes no sample flows
+through are included with 0 samples. Samples are routed following the
+tree's Path. An error is returned if the samples cannot be retrieved from
+the set or the tree cannot be traversed.
+*/
+func (t *Tree) Routing(ctx context.Context, s set.Set) ([]*NodeRouting, error) {
+	samples, err := s.Samples(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		path, err := t.Path(ctx, sample)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range path {
+			counts[n.ID]++
+		}
+	}
+	var result []*NodeRouting
+	depths := make(map[string]int)
+	err = t.Traverse(ctx, false, func(ctx context.Context, n *Node) error {
+		nr := &NodeRouting{Node: n, Depth: depths[n.ID], Samples: counts[n.ID]}
+		for _, id := range n.SubtreeIDs {
+			depths[id] = nr.Depth + 1
+		}
+		if len(samples) > 0 {
+			nr.Fraction = float64(nr.Samples) / float64(len(samples))
+		}
+		result = append(result, nr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,138 @@
+package tree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pbanos/botanic/feature"
+)
+
+/*
+SmoothingMethod is the method with which the probabilities of the
+predictions of a tree are smoothed, so that leaves grown from few samples
+do not predict their values with overconfident probabilities such as 1.0.
+*/
+type SmoothingMethod int
+
+const (
+	// SmoothingNone takes the probabilities of the values as their
+	// frequencies on the training samples. This is the default method.
+	SmoothingNone SmoothingMethod = iota
+	// SmoothingLaplace adds one sample with every value of the class
+	// feature to the training samples before computing the frequencies.
+	SmoothingLaplace
+	// SmoothingMEstimate adds M samples distributed as the prediction of
+	// the parent node to the training samples before computing the
+	// frequencies, or distributed evenly among the values of the class
+	// feature for the root node.
+	SmoothingMEstimate
+)
+
+var smoothingMethodNames = map[SmoothingMethod]string{
+	SmoothingNone:      "none",
+	SmoothingLaplace:   "laplace",
+	SmoothingMEstimate: "m-estimate",
+}
+
+func (m SmoothingMethod) String() string {
+	if n, ok := smoothingMethodNames[m]; ok {
+		return n
+	}
+	return fmt.Sprintf("SmoothingMethod(%d)", int(m))
+}
+
+/*
+Smoothing is the smoothing applied to the probabilities of predictions: a
+SmoothingMethod and, for SmoothingMEstimate, the number M of samples of the
+prior added.
+*/
+type Smoothing struct {
+	Method SmoothingMethod
+	M      float64
+}
+
+/*
+ParseSmoothing takes a string and returns the Smoothing it describes or an
+error if it describes none. Valid descriptions are "none", "laplace" and
+"m-estimate:M", with a positive number M.
+*/
+func ParseSmoothing(description string) (Smoothing, error) {
+	switch {
+	case description == "none":
+		return Smoothing{}, nil
+	case description == "laplace":
+		return Smoothing{Method: SmoothingLaplace}, nil
+	case strings.HasPrefix(description, "m-estimate:"):
+		m, err := strconv.ParseFloat(strings.TrimPrefix(description, "m-estimate:"), 64)
+		if err != nil || m <= 0 {
+			return Smoothing{}, fmt.Errorf("invalid m for m-estimate smoothing '%s', expected a positive number", description)
+		}
+		return Smoothing{Method: SmoothingMEstimate, M: m}, nil
+	}
+	return Smoothing{}, fmt.Errorf("unknown smoothing '%s'", description)
+}
+
+func (s Smoothing) String() string {
+	if s.Method == SmoothingMEstimate {
+		return fmt.Sprintf("%v:%v", s.Method, s.M)
+	}
+	return s.Method.String()
+}
+
+/*
+Smooth takes the class feature, the prediction of a node made from its
+training samples and the smoothed prediction of its parent node, or nil for
+the root node, and returns the prediction with its probabilities smoothed.
+Every value available for the class feature gets a probability, even if no
+sample has it. The prediction is returned as is with SmoothingNone or if it
+is nil.
+*/
+func (s Smoothing) Smooth(f feature.Feature, p, prior *Prediction) *Prediction {
+	if s.Method == SmoothingNone || p == nil {
+		return p
+	}
+	totalWeight := p.TotalWeight()
+	values := classValues(f, p.probabilities)
+	k := float64(len(values))
+	probs := make(map[string]float64, len(values))
+	for _, v := range values {
+		w := p.probabilities[v] * totalWeight
+		switch s.Method {
+		case SmoothingLaplace:
+			probs[v] = (w + 1) / (totalWeight + k)
+		case SmoothingMEstimate:
+			pv := 1 / k
+			if prior != nil {
+				pv = prior.ProbabilityOf(v)
+			}
+			probs[v] = (w + s.M*pv) / (totalWeight + s.M)
+		}
+	}
+	return &Prediction{probs, p.weight, p.totalWeight}
+}
+
+/*
+classValues takes a class feature and the probabilities of its values on a
+set of samples and returns the values available for the feature together
+with those found on the set.
+*/
+func classValues(f feature.Feature, probs map[string]float64) []string {
+	var values []string
+	switch f := f.(type) {
+	case *feature.DiscreteFeature:
+		values = append(values, f.AvailableValues()...)
+	case *feature.BooleanFeature:
+		values = append(values, "true", "false")
+	}
+	known := make(map[string]bool, len(values))
+	for _, v := range values {
+		known[v] = true
+	}
+	for v := range probs {
+		if !known[v] {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,116 @@
+package tree
+
+import (
+	"context"
+	"sort"
+)
+
+/*
+Stats holds statistics on the structure of a tree: the number of nodes and
+leaves, its depth, that is, the depth of its deepest leaf, the number of
+leaves at every depth, the average weight of the leaves, that is, of the
+predictions of the leaves, the number of leaves by size, and the number of
+nodes split on every feature, by feature name. It also holds the Metadata
+of the tree, if any.
+
+LeafSizes[i] is the number of leaves with a weight of at least 2^i and under
+2^(i+1), counting leaves with a weight of 0 in LeafSizes[0], so that the
+sizes of the leaves of large trees can be summarized in a few buckets.
+*/
+type Stats struct {
+	Nodes             int            `json:"nodes"`
+	Leaves            int            `json:"leaves"`
+	Depth             int            `json:"depth"`
+	LeavesByDepth     []int          `json:"leavesByDepth"`
+	AverageLeafWeight float64        `json:"averageLeafWeight"`
+	LeafSizes         []int          `json:"leafSizes"`
+	Splits            map[string]int `json:"splits"`
+	Metadata          *Metadata      `json:"metadata,omitempty"`
+}
+
+/*
+Stats takes a context and returns the statistics of the tree, computed
+going through its nodes with Traverse, or an error if they cannot be
+retrieved from the tree's node store.
+*/
+func (t *Tree) Stats(ctx context.Context) (*Stats, error) {
+	s := &Stats{Splits: make(map[string]int), Metadata: t.Metadata}
+	depths := make(map[string]int)
+	var leafWeight int
+	err := t.Traverse(ctx, false, func(_ context.Context, n *Node) error {
+		d := depths[n.ID]
+		delete(depths, n.ID)
+		s.Nodes++
+		if d > s.Depth {
+			s.Depth = d
+		}
+		if len(n.SubtreeIDs) > 0 {
+			if n.SubtreeFeature != nil {
+				s.Splits[n.SubtreeFeature.Name()]++
+			}
+			for _, id := range n.SubtreeIDs {
+				depths[id] = d + 1
+			}
+			return nil
+		}
+		s.Leaves++
+		s.LeavesByDepth = incrementAt(s.LeavesByDepth, d)
+		var w int
+		if n.Prediction != nil {
+			w = n.Prediction.Weight()
+		}
+		leafWeight += w
+		s.LeafSizes = incrementAt(s.LeafSizes, sizeBucket(w))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.Leaves > 0 {
+		s.AverageLeafWeight = float64(leafWeight) / float64(s.Leaves)
+	}
+	return s, nil
+}
+
+/*
+SplitFeatures returns the names of the features nodes are split on, sorted
+by decreasing number of splits and then by name.
+*/
+func (s *Stats) SplitFeatures() []string {
+	names := make([]string, 0, len(s.Splits))
+	for name := range s.Splits {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s.Splits[names[i]] != s.Splits[names[j]] {
+			return s.Splits[names[i]] > s.Splits[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+/*
+incrementAt takes a slice of counts and an index and increments the count at
+the index, growing the slice if needed, and returns the resulting slice.
+*/
+func incrementAt(counts []int, i int) []int {
+	for len(counts) <= i {
+		counts = append(counts, 0)
+	}
+	counts[i]++
+	return counts
+}
+
+/*
+sizeBucket takes the weight of a leaf and returns the index of the bucket
+of LeafSizes it is counted in.
+*/
+func sizeBucket(w int) int {
+	b := 0
+	for w > 1 {
+		w >>= 1
+		b++
+	}
+	return b
+}
@@ -11,69 +11,267 @@ import (
 
 // Tree represents a a regression tree. It is composed of a
 // NodeStore where all its nodes are stored, the id for the
-// root node of the tree and the classFeature it is able to
-// predict.
+// root node of the tree, the classFeature it is able to
+// predict, the MissingValueStrategy it follows to predict
+// samples with missing values, the Smoothing applied to the
+// probabilities it predicts and its Metadata, nil if its
+// provenance is unknown. The nodes keep the predictions made
+// from their training samples, which are only smoothed when
+// predicting samples, so that pruning works on the actual
+// frequencies of the values.
 type Tree struct {
 	NodeStore
-	RootID       string
-	ClassFeature feature.Feature
+	RootID               string
+	ClassFeature         feature.Feature
+	MissingValueStrategy MissingValueStrategy
+	Smoothing            Smoothing
+	Metadata             *Metadata
 }
 
 // New takes the ID for the root Node, a NodeStore and a class feature and
 // returns a tree composed of the nodes in the NodeStore connected to the
 // node with the given root ID that to predict the given feature.
 func New(rootID string, nodeStore NodeStore, classFeature feature.Feature) *Tree {
-	return &Tree{nodeStore, rootID, classFeature}
+	return &Tree{NodeStore: nodeStore, RootID: rootID, ClassFeature: classFeature}
 }
 
 // Predict takes a sample and returns a prediction according to the tree and an
-// error if the prediction could not be made.
+// error if the prediction could not be made. If the sample does not satisfy
+// the criteria of any of the subtrees of a node, as it happens with samples
+// with an undefined value for a feature whose undefined values were skipped
+// when growing the tree, the tree's MissingValueStrategy is applied and, if
+// it cannot select a subtree, ErrCannotPredictFromSample is returned.
+// The prediction is smoothed with the tree's Smoothing.
 func (t *Tree) Predict(ctx context.Context, s feature.Sample) (*Prediction, error) {
+	if t != nil && t.MissingValueStrategy == MissingValueFractional {
+		n, err := t.root(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return t.fractionalPrediction(ctx, n, s, nil)
+	}
+	path, err := t.Path(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	n := path[len(path)-1]
+	if n.SubtreeFeature != nil {
+		return nil, ErrCannotPredictFromSample
+	}
+	if n.Prediction == nil {
+		return nil, ErrCannotPredictFromSample
+	}
+	if t.Smoothing.Method == SmoothingNone {
+		return n.Prediction, nil
+	}
+	var p *Prediction
+	for _, n := range path {
+		p = t.smooth(n, p)
+	}
+	return p, nil
+}
+
+/*
+smooth takes a node and the smoothed prediction of its parent node, or nil
+for the root node, and returns the prediction of the node smoothed with the
+tree's Smoothing.
+*/
+func (t *Tree) smooth(n *Node, prior *Prediction) *Prediction {
+	return t.Smoothing.Smooth(t.ClassFeature, n.Prediction, prior)
+}
+
+// Path takes a sample and returns the nodes the sample goes through
+// when predicting it with the tree, starting with the root node. The
+// last node in the path is either a leaf of the tree or a node with
+// no subtree whose criterion is satisfied by the sample. Samples with
+// a missing value are routed according to the tree's MissingValueStrategy,
+// with MissingValueFractional following the subtree MissingValueMajority
+// would select. An error is returned if the nodes cannot be retrieved
+// from the tree's node store or if the criteria cannot be evaluated on
+// the sample.
+func (t *Tree) Path(ctx context.Context, s feature.Sample) ([]*Node, error) {
+	n, err := t.root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	path := []*Node{n}
+	for n.SubtreeFeature != nil {
+		selectedNode, subnodes, err := t.subtreeFor(ctx, n, s)
+		if err != nil {
+			return nil, err
+		}
+		if selectedNode == nil && t.MissingValueStrategy != MissingValueUndefined {
+			selectedNode, err = t.missingValueSubtree(ctx, n, subnodes, s)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if selectedNode == nil {
+			break
+		}
+		n = selectedNode
+		path = append(path, n)
+	}
+	return path, nil
+}
+
+func (t *Tree) root(ctx context.Context) (*Node, error) {
 	if t == nil {
 		return nil, fmt.Errorf("nil tree cannot predict samples")
 	}
 	n, err := t.Get(ctx, t.RootID)
 	if err != nil {
-		return nil, fmt.Errorf("predicting sample: retrieving node %v: %v", t.RootID, err)
+		return nil, fmt.Errorf("predicting sample: retrieving node %v: %w", t.RootID, err)
 	}
 	if n == nil {
-		return nil, fmt.Errorf("predicting sample: root node %v not found", t.RootID)
+		return nil, fmt.Errorf("predicting sample: root %w: %v", ErrNodeNotFound, t.RootID)
 	}
-	for {
-		if n.SubtreeFeature == nil {
-			break
+	return n, nil
+}
+
+/*
+subtreeFor takes a context, a node and a sample and returns the node of the
+subtree whose criterion is satisfied by the sample, nil if there is none. An
+undefined criterion subtree is only selected if no other subtree is satisfied.
+When no subtree is selected, all the subtree nodes of the node are returned
+too.
+*/
+func (t *Tree) subtreeFor(ctx context.Context, n *Node, s feature.Sample) (*Node, []*Node, error) {
+	var undefinedNode *Node
+	subnodes, err := GetNodes(ctx, t.NodeStore, n.SubtreeIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("predicting sample: retrieving subtrees of node %v: %w", n.ID, err)
+	}
+	for i, subnode := range subnodes {
+		if subnode == nil {
+			return nil, nil, fmt.Errorf("predicting sample: %w: %v", ErrNodeNotFound, n.SubtreeIDs[i])
+		}
+	}
+	for _, subnode := range subnodes {
+		if subnode.FeatureCriterion == nil {
+			continue
+		}
+		ok, err := subnode.FeatureCriterion.SatisfiedBy(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if _, undefined := subnode.FeatureCriterion.(feature.UndefinedCriterion); !undefined {
+			return subnode, nil, nil
 		}
-		var selectedNode *Node
-		for _, nID := range n.SubtreeIDs {
-			subnode, err := t.Get(ctx, nID)
+		if undefinedNode == nil {
+			undefinedNode = subnode
+		}
+	}
+	if undefinedNode != nil {
+		return undefinedNode, nil, nil
+	}
+	return nil, subnodes, nil
+}
+
+/*
+missingValueSubtree takes a context, a node, its subtree nodes and a sample
+and, if the sample has no value for the node's SubtreeFeature, returns the
+subtree node selected for it by the tree's MissingValueStrategy. It returns
+nil if the sample has a value for the feature or no subtree can be selected.
+*/
+func (t *Tree) missingValueSubtree(ctx context.Context, n *Node, subnodes []*Node, s feature.Sample) (*Node, error) {
+	v, err := s.ValueFor(n.SubtreeFeature)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		return nil, nil
+	}
+	if t.MissingValueStrategy == MissingValueSurrogate && n.Surrogate != nil {
+		for i, c := range n.Surrogate.Criteria {
+			ok, err := c.SatisfiedBy(s)
 			if err != nil {
-				return nil, fmt.Errorf("predicting sample: retrieving node %v: %v", nID, err)
+				return nil, err
 			}
-			if subnode == nil {
-				return nil, fmt.Errorf("predicting sample: node %v not found", nID)
+			if !ok {
+				continue
 			}
-			if subnode.FeatureCriterion != nil {
-				ok, err := subnode.FeatureCriterion.SatisfiedBy(s)
-				if err != nil {
-					return nil, err
-				}
-				if ok {
-					selectedNode = subnode
-					if _, ok = subnode.FeatureCriterion.(feature.UndefinedCriterion); !ok {
-						break
-					}
+			for _, subnode := range subnodes {
+				if subnode.ID == n.Surrogate.SubtreeIDs[i] {
+					return subnode, nil
 				}
 			}
 		}
-		if selectedNode == nil {
-			return nil, fmt.Errorf("sample does not satisfy any subtree criteria on feature %s", n.SubtreeFeature.Name())
+	}
+	var majorityNode *Node
+	for _, subnode := range subnodes {
+		if subnode.Prediction == nil {
+			continue
+		}
+		if majorityNode == nil || subnode.Prediction.Weight() > majorityNode.Prediction.Weight() {
+			majorityNode = subnode
 		}
-		n = selectedNode
 	}
-	if n.Prediction != nil {
-		return n.Prediction, nil
+	return majorityNode, nil
+}
+
+/*
+fractionalPrediction takes a context, a node, a sample and the smoothed
+prediction of the parent node, or nil for the root node, and returns the
+prediction for the sample of the subtree under the node, smoothed with the
+tree's Smoothing. Samples with no value for the feature a node splits on
+and no subtree to follow are sent down every subtree, combining the
+predictions of the subtrees weighted by the number of training samples they
+were grown from.
+*/
+func (t *Tree) fractionalPrediction(ctx context.Context, n *Node, s feature.Sample, prior *Prediction) (*Prediction, error) {
+	p := t.smooth(n, prior)
+	if n.SubtreeFeature == nil {
+		if p == nil {
+			return nil, ErrCannotPredictFromSample
+		}
+		return p, nil
+	}
+	selectedNode, subnodes, err := t.subtreeFor(ctx, n, s)
+	if err != nil {
+		return nil, err
+	}
+	if selectedNode != nil {
+		return t.fractionalPrediction(ctx, selectedNode, s, p)
+	}
+	v, err := s.ValueFor(n.SubtreeFeature)
+	if err != nil {
+		return nil, err
 	}
-	return nil, ErrCannotPredictFromSample
+	if v != nil {
+		return nil, ErrCannotPredictFromSample
+	}
+	probs := make(map[string]float64)
+	var totalWeight int
+	var sampleWeight float64
+	for _, subnode := range subnodes {
+		if subnode.Prediction == nil || subnode.Prediction.Weight() == 0 {
+			continue
+		}
+		sp, err := t.fractionalPrediction(ctx, subnode, s, p)
+		if err != nil {
+			if err == ErrCannotPredictFromSample {
+				continue
+			}
+			return nil, err
+		}
+		w := subnode.Prediction.Weight()
+		for value, prob := range sp.Probabilities() {
+			probs[value] += prob * float64(w)
+		}
+		totalWeight += w
+		sampleWeight += sp.TotalWeight()
+	}
+	if totalWeight == 0 {
+		return nil, ErrCannotPredictFromSample
+	}
+	for value := range probs {
+		probs[value] /= float64(totalWeight)
+	}
+	return NewWeightedPrediction(probs, totalWeight, sampleWeight), nil
 }
 
 /*
@@ -83,41 +281,18 @@ Test takes a context.Context, a Set and a class Feature and returns three values
  * an error if a prediction could not be set for reasons other than the tree not
    being able to do so. If this is not nil, the other values will be 0.0 and 0
    respectively
+The samples are streamed from the set and predicted concurrently as Evaluate
+does.
 */
 func (t *Tree) Test(ctx context.Context, s set.Set) (float64, int, error) {
 	if t == nil {
 		return 0.0, 0, nil
 	}
-	var result float64
-	var errCount int
-	samples, err := s.Samples(ctx)
+	ev, err := t.Evaluate(ctx, s, DefaultConfidenceZ)
 	if err != nil {
 		return 0.0, 0, err
 	}
-	count, err := s.Count(ctx)
-	if err != nil {
-		return 0.0, 0, err
-	}
-	for _, sample := range samples {
-		p, err := t.Predict(ctx, sample)
-		if err != nil {
-			if err != ErrCannotPredictFromSample {
-				return 0.0, 0, err
-			}
-			errCount++
-		} else {
-			pV, _ := p.PredictedValue()
-			v, err := sample.ValueFor(t.ClassFeature)
-			if err != nil {
-				return 0.0, 0, err
-			}
-			if pV == v {
-				result += 1.0
-			}
-		}
-	}
-	result = result / float64(count)
-	return result, errCount, nil
+	return ev.SuccessRate, ev.Unpredicted, nil
 }
 
 // Traverse takes a context, bottomup boolean and an
@@ -132,7 +307,8 @@ func (t *Tree) Test(ctx context.Context, s set.Set) (float64, int, error) {
 // tree's node store, the obtained error is returned. If the
 // call to the function returns an error, the traversing is
 // aborted and the error is returned. Otherwise, when the
-// traversing is over, nil is returned.
+// traversing is over, nil is returned. The subtree nodes
+// of every node are retrieved together with GetNodes.
 func (t *Tree) Traverse(ctx context.Context, bottomup bool, f func(context.Context, *Node) error) error {
 	n, err := t.NodeStore.Get(ctx, t.RootID)
 	if err != nil {
@@ -152,11 +328,11 @@ func (t *Tree) traverse(ctx context.Context, n *Node, bottomup bool, f func(cont
 	if err != nil {
 		return err
 	}
-	for _, snID := range n.SubtreeIDs {
-		sn, err := t.NodeStore.Get(ctx, snID)
-		if err != nil {
-			return err
-		}
+	subnodes, err := GetNodes(ctx, t.NodeStore, n.SubtreeIDs)
+	if err != nil {
+		return err
+	}
+	for _, sn := range subnodes {
 		err = t.traverse(ctx, sn, bottomup, f)
 		if err != nil {
 			return err
@@ -171,6 +347,63 @@ func (t *Tree) traverse(ctx context.Context, n *Node, bottomup bool, f func(cont
 	return nil
 }
 
+/*
+CopyTo takes a context and a NodeStore and creates on the store a copy of
+every node of the tree, with the IDs given by the store, returning a tree
+with the same class feature, missing value strategy and metadata over the copied
+nodes. Nodes are created in the order Traverse goes through them, so that
+every node is created after its parent, and the references to their
+subtrees are updated afterwards with StoreNodes. It returns an error if the
+nodes cannot be read from the tree or copied to the store.
+*/
+func (t *Tree) CopyTo(ctx context.Context, ns NodeStore) (*Tree, error) {
+	ids := make(map[string]string)
+	var branches []*Node
+	var originals []*Node
+	err := t.Traverse(ctx, false, func(ctx context.Context, n *Node) error {
+		if n == nil {
+			return fmt.Errorf("copying tree: %w", ErrNodeNotFound)
+		}
+		cn := *n
+		cn.ParentID = ids[n.ParentID]
+		cn.SubtreeIDs = nil
+		if n.Surrogate != nil {
+			cs := *n.Surrogate
+			cs.SubtreeIDs = nil
+			cn.Surrogate = &cs
+		}
+		err := ns.Create(ctx, &cn)
+		if err != nil {
+			return fmt.Errorf("copying node %v: %w", n.ID, err)
+		}
+		ids[n.ID] = cn.ID
+		if len(n.SubtreeIDs) > 0 {
+			branches = append(branches, &cn)
+			originals = append(originals, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, cn := range branches {
+		n := originals[i]
+		cn.SubtreeIDs = renumberNodeIDs(n.SubtreeIDs, ids)
+		if n.Surrogate != nil {
+			cn.Surrogate.SubtreeIDs = renumberNodeIDs(n.Surrogate.SubtreeIDs, ids)
+		}
+	}
+	err = StoreNodes(ctx, ns, branches)
+	if err != nil {
+		return nil, fmt.Errorf("copying tree: %w", err)
+	}
+	ct := New(ids[t.RootID], ns, t.ClassFeature)
+	ct.MissingValueStrategy = t.MissingValueStrategy
+	ct.Smoothing = t.Smoothing
+	ct.Metadata = t.Metadata
+	return ct, nil
+}
+
 func (t *Tree) String() string {
 	return t.subtreeString(t.RootID)
 }
@@ -180,6 +413,9 @@ func (t *Tree) subtreeString(nodeID string) string {
 	if err != nil {
 		return fmt.Sprintf("ERROR: %s\n", err.Error())
 	}
+	if n == nil {
+		return fmt.Sprintf("ERROR: %v: %s\n", ErrNodeNotFound, nodeID)
+	}
 	result := fmt.Sprintf("[%s]\n", nodeID)
 	if n.FeatureCriterion != nil {
 		result = fmt.Sprintf("%s{ %v }\n", result, n.FeatureCriterion)
@@ -0,0 +1,150 @@
+/*
+Package webhook provides a Notifier to post events on the growth and testing
+of trees to a webhook URL, so that other systems can react to them without
+polling.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// EventGrowthStarted is the type of the event notified when the
+	// growth of a tree or ensemble of trees starts.
+	EventGrowthStarted = "growth.started"
+	// EventGrowthMilestone is the type of the event notified every time
+	// a given number of nodes of a tree have been developed.
+	EventGrowthMilestone = "growth.milestone"
+	// EventGrowthCompleted is the type of the event notified when a tree
+	// or ensemble of trees is grown and written.
+	EventGrowthCompleted = "growth.completed"
+	// EventAccuracyComputed is the type of the event notified when a tree
+	// or ensemble of trees has been tested against a set.
+	EventAccuracyComputed = "accuracy.computed"
+	// EventError is the type of the event notified when a command fails.
+	EventError = "error"
+)
+
+/*
+DefaultTimeout is the time a Notifier waits for a webhook to respond to an
+event by default.
+*/
+const DefaultTimeout = 10 * time.Second
+
+/*
+eventBufferSize is the number of events a Notifier holds pending
+delivery before Notify blocks.
+*/
+const eventBufferSize = 100
+
+/*
+Event is a notification posted to a webhook. It is posted as a JSON object
+with the following fields:
+* "event": a string with the type of the event
+* "time": a string with the time of the event in RFC 3339 format
+* "data": an object with information on the event that depends on its type
+*/
+type Event struct {
+	Type string                 `json:"event"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+/*
+Notifier posts events to a webhook URL. Events are posted one at a time in
+the background in the order they are notified, so that notifying them does
+not delay the work being notified on. Delivery failures are reported to the
+notifier's error handler, if any, and never interrupt the delivery of
+subsequent events.
+*/
+type Notifier struct {
+	url     string
+	client  *http.Client
+	events  chan *Event
+	done    chan struct{}
+	onError func(*Event, error)
+}
+
+/*
+New takes a webhook URL, a timeout and a function to handle delivery errors,
+which may be nil, and returns a Notifier that posts events to the URL
+waiting up to the timeout for every response. If the timeout is not positive,
+DefaultTimeout is used.
+*/
+func New(url string, timeout time.Duration, onError func(*Event, error)) *Notifier {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	n := &Notifier{
+		url:     url,
+		client:  &http.Client{Timeout: timeout},
+		events:  make(chan *Event, eventBufferSize),
+		done:    make(chan struct{}),
+		onError: onError,
+	}
+	go n.deliver()
+	return n
+}
+
+/*
+Notify takes a context, an event type and the data of the event and queues
+the event for delivery. It only blocks if too many events are pending
+delivery, and returns the context's error if it is cancelled or times out
+before the event can be queued. Notify must not be called after Close.
+*/
+func (n *Notifier) Notify(ctx context.Context, eventType string, data map[string]interface{}) error {
+	e := &Event{Type: eventType, Time: time.Now().UTC(), Data: data}
+	select {
+	case n.events <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+Close takes a context and waits for the events pending delivery to be
+posted. It returns the context's error if it is cancelled or times out
+before that.
+*/
+func (n *Notifier) Close(ctx context.Context) error {
+	close(n.events)
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) deliver() {
+	defer close(n.done)
+	for e := range n.events {
+		err := n.post(e)
+		if err != nil && n.onError != nil {
+			n.onError(e, err)
+		}
+	}
+}
+
+func (n *Notifier) post(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", res.Status)
+	}
+	return nil
+}